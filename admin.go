@@ -0,0 +1,196 @@
+package session
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// SessionMeta holds session information without its values.
+// SessionList() fills ID and times only.
+type SessionMeta struct {
+	ID           string
+	TimeCreated  time.Time
+	TimeAccessed time.Time
+	ExpiresAt    time.Time //set with Session.SetExpiry(), zero if not set
+	Size         int       //stored payload size in bytes
+	Fingerprint  string    //client fingerprint, see SetFingerprint(), empty if not stored or not read by provider
+	Provider     string    //provider name
+}
+
+// MetaProvider is an optional interface for providers able to return session metadata
+// without decoding session values. Session access time is not updated.
+type MetaProvider interface {
+	SessionMeta(sid string) (SessionMeta, error) //ErrSessionNotFound if there is no session
+}
+
+// SessionMeta returns metadata of the session with the given ID without loading its values,
+// so sessions can be inspected without touching them. ErrSessionNotFound is returned if there is no session.
+// Expired sessions not yet removed by GC are returned as well.
+// Provider must implement MetaProvider interface.
+func (manager *Manager) SessionMeta(sid string) (SessionMeta, error) {
+	meta, err := sessionMeta(manager.provider, sid)
+	if err != nil {
+		return SessionMeta{}, err
+	}
+	if meta.Provider == "" {
+		meta.Provider = manager.providerName
+	}
+	return meta, nil
+}
+
+// sessionMeta returns session metadata from provider implementing MetaProvider, used by decorators.
+func sessionMeta(p Provider, sid string) (SessionMeta, error) {
+	meta_pder, ok := p.(MetaProvider)
+	if !ok {
+		return SessionMeta{}, ErrNoSessionMeta
+	}
+	return meta_pder.SessionMeta(sid)
+}
+
+// PeekProvider is an optional interface for providers able to read a session without side effects:
+// access time is not updated, a missing session is not created and an expired one is not removed.
+// The returned session must not be written nor closed.
+// ExportSessions(), DebugDump() and Migrate() read sessions with it.
+type PeekProvider interface {
+	SessionPeek(sid string) (Session, error) //ErrSessionNotFound if there is no session, ErrSessionExpired if it is expired
+}
+
+// sessionPeek reads session from provider implementing PeekProvider, used by decorators.
+func sessionPeek(p Provider, sid string) (Session, error) {
+	peek_pder, ok := p.(PeekProvider)
+	if !ok {
+		return nil, ErrNoSessionPeek
+	}
+	return peek_pder.SessionPeek(sid)
+}
+
+// readSession reads session for administration with SessionPeek(), with SessionRead() if provider
+// does not support it. The returned function closes the session.
+func readSession(p Provider, sid string) (Session, func(), error) {
+	sess, err := sessionPeek(p, sid)
+	if err == nil {
+		return sess, func() {}, nil
+	}
+	if !errors.Is(err, ErrNoSessionPeek) {
+		return nil, nil, err
+	}
+	if sess, err = p.SessionRead(sid); err != nil {
+		return nil, nil, err
+	}
+	return sess, func() { p.SessionClose(sid) }, nil
+}
+
+// AdminProvider is an optional interface for providers
+// supporting session administration.
+type AdminProvider interface {
+	SessionCount() (int, error)                           //number of stored sessions
+	SessionList(offset, limit int) ([]SessionMeta, error) //sessions ordered by ID, limit 0 means no limit
+}
+
+// BulkDestroyProvider is an optional interface for providers
+// able to destroy many sessions at once.
+type BulkDestroyProvider interface {
+	SessionDestroyMany(sids []string) error
+}
+
+// Count returns number of sessions kept by provider.
+// Sessions expired but not yet removed by GC are counted as well.
+// Provider must implement AdminProvider interface.
+func (manager *Manager) Count() (int, error) {
+	adm_pder, ok := manager.provider.(AdminProvider)
+	if !ok {
+		return 0, ErrNotAdminProvider
+	}
+	return adm_pder.SessionCount()
+}
+
+// ListSessions returns a page of session metadata ordered by session ID.
+// Use limit 0 to get all sessions starting at offset.
+// Provider must implement AdminProvider interface.
+func (manager *Manager) ListSessions(offset, limit int) ([]SessionMeta, error) {
+	adm_pder, ok := manager.provider.(AdminProvider)
+	if !ok {
+		return nil, ErrNotAdminProvider
+	}
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("session: ListSessions offset and limit must not be negative")
+	}
+	return adm_pder.SessionList(offset, limit)
+}
+
+// PageSessionIDs sorts session IDs and returns the page given by offset and limit.
+// It is a helper for providers not able to sort and paginate on the server side.
+func PageSessionIDs(ids []string, offset, limit int) []string {
+	sort.Strings(ids)
+	if offset >= len(ids) {
+		return []string{}
+	}
+	ids = ids[offset:]
+	if limit > 0 && limit < len(ids) {
+		ids = ids[:limit]
+	}
+	return ids
+}
+
+// LIST_PAGE_SIZE is a page size used when all sessions are iterated.
+const LIST_PAGE_SIZE = 1000
+
+// DestroySessionsWhere destroys all sessions for which filter returns true,
+// e.g. to force logout of a user from all devices.
+// Matching sessions are collected first, then destroyed with one call if provider
+// implements BulkDestroyProvider, otherwise one by one with SessionDestroy(),
+// continuing on errors, all errors are returned joined.
+// OnSessionDestroyed hooks are called for destroyed sessions.
+// Provider must implement AdminProvider interface.
+func (manager *Manager) DestroySessionsWhere(filter func(meta SessionMeta) bool) error {
+	sids := make([]string, 0)
+	for offset := 0; ; offset += LIST_PAGE_SIZE {
+		list, err := manager.ListSessions(offset, LIST_PAGE_SIZE)
+		if err != nil {
+			return err
+		}
+		for _, meta := range list {
+			if filter(meta) {
+				sids = append(sids, meta.ID)
+			}
+		}
+		if len(list) < LIST_PAGE_SIZE {
+			break
+		}
+	}
+
+	return manager.destroySessions(sids)
+}
+
+// destroySessions destroys sessions with one call if provider implements BulkDestroyProvider,
+// otherwise one by one with SessionDestroy(), continuing on errors.
+func (manager *Manager) destroySessions(sids []string) error {
+	if len(sids) == 0 {
+		return nil
+	}
+
+	if bulk_pder, ok := manager.provider.(BulkDestroyProvider); ok {
+		if err := manager.trashSessions(sids...); err != nil {
+			return err
+		}
+		start := time.Now()
+		err := bulk_pder.SessionDestroyMany(sids)
+		manager.metrics.observe(METRIC_WRITES, METRIC_WRITE_DURATION, start, err)
+		if err != nil {
+			return err
+		}
+		for _, sid := range sids {
+			manager.sessionDestroyed(sid)
+		}
+		return nil
+	}
+
+	var errs []error
+	for _, sid := range sids {
+		if err := manager.SessionDestroy(sid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
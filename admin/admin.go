@@ -0,0 +1,243 @@
+// Package admin contains an http.Handler exposing session administration as JSON endpoints,
+// e.g. for embedding in ops dashboards. Manager provider must implement session.AdminProvider
+// for listing sessions and session.MetaProvider for session metadata.
+//
+// Endpoints relative to the handler mount point:
+//
+//	GET    /sessions?offset=0&limit=50  sessions ordered by ID with the total count
+//	GET    /sessions/{sid}              session metadata, values are not returned
+//	DELETE /sessions/{sid}              destroys session
+//	DELETE /sessions                    destroys all sessions
+//	POST   /gc                          runs GC, returns its report
+//
+// Every request is authorized with AuthFunc passed to NewHandler():
+//
+//	h := admin.NewHandler(SessManager, admin.BasicAuth("admin", os.Getenv("SESSION_ADMIN_PASSWORD")))
+//	http.Handle("/admin/", http.StripPrefix("/admin", h))
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dronm/session"
+)
+
+// DEF_LIST_LIMIT is a page size of session list if limit is not given.
+const DEF_LIST_LIMIT = 100
+
+// AuthFunc reports whether request r may access admin endpoints.
+type AuthFunc func(r *http.Request) bool
+
+// BasicAuth returns AuthFunc checking HTTP basic authentication credentials.
+func BasicAuth(user, password string) AuthFunc {
+	return func(r *http.Request) bool {
+		u, p, ok := r.BasicAuth()
+		return ok &&
+			subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+	}
+}
+
+// Handler serves admin endpoints of a manager.
+type Handler struct {
+	manager *session.Manager
+	auth    AuthFunc
+}
+
+// NewHandler returns admin handler of manager, requests are authorized with auth.
+// All requests are rejected if auth is nil.
+func NewHandler(manager *session.Manager, auth AuthFunc) *Handler {
+	return &Handler{manager: manager, auth: auth}
+}
+
+// Session is session metadata in JSON responses.
+type Session struct {
+	ID           string     `json:"id"`
+	TimeCreated  time.Time  `json:"created"`
+	TimeAccessed time.Time  `json:"accessed"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	Size         int        `json:"size,omitempty"`
+	Fingerprint  string     `json:"fingerprint,omitempty"`
+	Provider     string     `json:"provider,omitempty"`
+}
+
+func newSession(meta session.SessionMeta) Session {
+	s := Session{
+		ID:           meta.ID,
+		TimeCreated:  meta.TimeCreated,
+		TimeAccessed: meta.TimeAccessed,
+		Size:         meta.Size,
+		Fingerprint:  meta.Fingerprint,
+		Provider:     meta.Provider,
+	}
+	if !meta.ExpiresAt.IsZero() {
+		s.ExpiresAt = &meta.ExpiresAt
+	}
+	return s
+}
+
+// SessionList is a response of session list endpoint.
+type SessionList struct {
+	Count    int       `json:"count"` //all sessions, expired ones not removed by GC included
+	Offset   int       `json:"offset"`
+	Limit    int       `json:"limit"`
+	Sessions []Session `json:"sessions"`
+}
+
+// GCReport is a response of GC endpoint, see session.GCReport.
+type GCReport struct {
+	Scanned         int     `json:"scanned"`
+	DeletedIdle     int     `json:"deleted_idle"`
+	DeletedLifetime int     `json:"deleted_lifetime"`
+	DeletedExpiry   int     `json:"deleted_expiry"`
+	Errors          int     `json:"errors"`
+	Duration        float64 `json:"duration_sec"`
+}
+
+// errorResponse is a response of failed requests.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.auth == nil || !h.auth(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="session admin"`)
+		writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+	path := strings.Trim(r.URL.Path, "/")
+	switch {
+	case path == "sessions":
+		switch r.Method {
+		case http.MethodGet:
+			h.list(w, r)
+		case http.MethodDelete:
+			h.manager.DestroyAllSessions(io.Discard, session.LOG_LEVEL_ERROR)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+		}
+
+	case strings.HasPrefix(path, "sessions/"):
+		sid := strings.TrimPrefix(path, "sessions/")
+		if sid == "" || strings.Contains(sid, "/") {
+			writeError(w, http.StatusNotFound, errors.New("not found"))
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			h.show(w, sid)
+		case http.MethodDelete:
+			if err := h.manager.SessionDestroy(sid); err != nil {
+				writeError(w, errorStatus(err), err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+		}
+
+	case path == "gc":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		report := h.manager.SessionGC(io.Discard, session.LOG_LEVEL_ERROR)
+		writeJSON(w, http.StatusOK, GCReport{
+			Scanned:         report.Scanned,
+			DeletedIdle:     report.DeletedIdle,
+			DeletedLifetime: report.DeletedLifetime,
+			DeletedExpiry:   report.DeletedExpiry,
+			Errors:          report.Errors,
+			Duration:        report.Duration.Seconds(),
+		})
+
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := queryInt(r, "limit", DEF_LIST_LIMIT)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	cnt, err := h.manager.Count()
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	metas, err := h.manager.ListSessions(offset, limit)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	list := SessionList{Count: cnt, Offset: offset, Limit: limit, Sessions: make([]Session, len(metas))}
+	for i, meta := range metas {
+		list.Sessions[i] = newSession(meta)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (h *Handler) show(w http.ResponseWriter, sid string) {
+	meta, err := h.manager.SessionMeta(sid)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newSession(meta))
+}
+
+// queryInt returns not negative integer query parameter name, def if it is not given.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid " + name)
+	}
+	return n, nil
+}
+
+// errorStatus maps session errors to HTTP status codes.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, session.ErrSessionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, session.ErrInvalidSessionID):
+		return http.StatusBadRequest
+	case errors.Is(err, session.ErrNotAdminProvider), errors.Is(err, session.ErrNoSessionMeta):
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) //fails if client is gone only
+}
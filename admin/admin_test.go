@@ -0,0 +1,129 @@
+// testing functions for session/admin.
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/dronm/session"
+	_ "github.com/dronm/session/bolt"
+)
+
+const BOLT_FILENAME = "test.db"
+
+func newServer(t *testing.T) (*session.Manager, *httptest.Server) {
+	SessManager, err := session.NewManager("bolt", 0, 0, "", BOLT_FILENAME)
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	srv := httptest.NewServer(http.StripPrefix("/admin", NewHandler(SessManager, BasicAuth("admin", "secret"))))
+	t.Cleanup(func() {
+		srv.Close()
+		SessManager.CloseProvider()
+		os.Remove(BOLT_FILENAME)
+	})
+	return SessManager, srv
+}
+
+// request sends authorized request and decodes JSON response to v if it is not nil.
+func request(t *testing.T, srv *httptest.Server, method, path string, v interface{}) int {
+	req, err := http.NewRequest(method, srv.URL+"/admin"+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("admin", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s response decoding failed: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// TestEndpoints lists, shows and destroys sessions and runs GC.
+func TestEndpoints(t *testing.T) {
+	SessManager, srv := newServer(t)
+	sids := make([]string, 3)
+	for i := range sids {
+		sess, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		if err := sess.Put("key", i); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+		sids[i] = sess.SessionID()
+	}
+
+	var list SessionList
+	if st := request(t, srv, http.MethodGet, "/sessions?limit=2", &list); st != http.StatusOK {
+		t.Fatalf("list wanted status 200, got %d", st)
+	}
+	if list.Count != 3 || len(list.Sessions) != 2 {
+		t.Fatalf("list wanted 2 of 3 sessions, got %+v", list)
+	}
+
+	var s Session
+	if st := request(t, srv, http.MethodGet, "/sessions/"+sids[0], &s); st != http.StatusOK {
+		t.Fatalf("show wanted status 200, got %d", st)
+	}
+	if s.ID != sids[0] || s.Size == 0 || s.TimeCreated.IsZero() {
+		t.Fatalf("show returned wrong metadata: %+v", s)
+	}
+
+	if st := request(t, srv, http.MethodDelete, "/sessions/"+sids[0], nil); st != http.StatusNoContent {
+		t.Fatalf("destroy wanted status 204, got %d", st)
+	}
+	var errResp errorResponse
+	if st := request(t, srv, http.MethodGet, "/sessions/"+sids[0], &errResp); st != http.StatusNotFound {
+		t.Fatalf("show of destroyed session wanted status 404, got %d", st)
+	}
+
+	var report GCReport
+	if st := request(t, srv, http.MethodPost, "/gc", &report); st != http.StatusOK {
+		t.Fatalf("gc wanted status 200, got %d", st)
+	}
+	if report.Errors != 0 {
+		t.Fatalf("gc reported errors: %+v", report)
+	}
+
+	if st := request(t, srv, http.MethodDelete, "/sessions", nil); st != http.StatusNoContent {
+		t.Fatalf("destroy all wanted status 204, got %d", st)
+	}
+	if cnt, err := SessManager.Count(); err != nil || cnt != 0 {
+		t.Fatalf("destroy all left %d sessions, error: %v", cnt, err)
+	}
+
+	if st := request(t, srv, http.MethodPut, "/gc", nil); st != http.StatusMethodNotAllowed {
+		t.Fatalf("PUT gc wanted status 405, got %d", st)
+	}
+}
+
+// TestAuth checks requests are rejected without credentials or auth hook.
+func TestAuth(t *testing.T) {
+	SessManager, srv := newServer(t)
+	resp, err := http.Get(srv.URL + "/admin/sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("wanted status 401 without credentials, got %d", resp.StatusCode)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/sessions", nil)
+	req.SetBasicAuth("admin", "secret")
+	NewHandler(SessManager, nil).ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wanted status 401 with nil auth, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,435 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Audited operations.
+const (
+	AUDIT_OP_INIT        = "init"        //session created
+	AUDIT_OP_READ        = "read"        //session read
+	AUDIT_OP_DESTROY     = "destroy"     //session destroyed
+	AUDIT_OP_EXPIRE      = "expire"      //session removed by GC
+	AUDIT_OP_DESTROY_ALL = "destroy_all" //all sessions destroyed
+	AUDIT_OP_SET         = "set"         //value set with Set(), Put(), SetWithTTL() or SetSensitive()
+	AUDIT_OP_DELETE      = "delete"      //value deleted
+	AUDIT_OP_CLEAR       = "clear"       //all values deleted
+	AUDIT_OP_INCREMENT   = "increment"   //value incremented or decremented
+	AUDIT_OP_CAS         = "compare_and_swap"
+	AUDIT_OP_GET_OR_SET  = "get_or_set"
+	AUDIT_OP_SET_EXPIRY  = "set_expiry"
+	AUDIT_OP_RESTORE     = "restore" //values replaced with a snapshot
+)
+
+// AuditRecord is a record of a session operation.
+type AuditRecord struct {
+	Time      time.Time         `json:"time"`
+	SID       string            `json:"sid,omitempty"`
+	Operation string            `json:"op"`
+	Key       string            `json:"key,omitempty"`      //value key, empty for session operations
+	Error     string            `json:"error,omitempty"`    //error of a failed operation
+	Metadata  map[string]string `json:"metadata,omitempty"` //caller metadata, see AuditProvider.SetMetadataFunc()
+}
+
+// AuditSink stores audit records, e.g. in a file, a database table or a message queue.
+// WriteAudit is called synchronously by session operations and must be safe for concurrent use.
+type AuditSink interface {
+	WriteAudit(rec AuditRecord) error
+}
+
+// AuditSinkFunc is a function implementing AuditSink.
+type AuditSinkFunc func(rec AuditRecord) error
+
+func (fn AuditSinkFunc) WriteAudit(rec AuditRecord) error {
+	return fn(rec)
+}
+
+// auditWriter writes audit records as JSON lines.
+type auditWriter struct {
+	mx  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewAuditWriter returns a sink writing records to w as JSON lines, e.g. to a file.
+func NewAuditWriter(w io.Writer) AuditSink {
+	return &auditWriter{enc: json.NewEncoder(w)}
+}
+
+func (aw *auditWriter) WriteAudit(rec AuditRecord) error {
+	aw.mx.Lock()
+	defer aw.mx.Unlock()
+	return aw.enc.Encode(rec)
+}
+
+// AuditProvider is a provider decorator writing a record of every session lifecycle event
+// and every value modification to an AuditSink, for environments which must trace sessions.
+// Value reads are not recorded, records hold value keys only, never values.
+type AuditProvider struct {
+	Provider
+	sink        AuditSink
+	mx          sync.Mutex
+	metadata    func(sid string) map[string]string
+	onError     func(rec AuditRecord, err error)
+	expiredHook SessionHook
+}
+
+// NewAuditProvider returns inner provider writing audit records to sink.
+// The result should be registered under its own name with Register(), then used with NewManager():
+//
+//	f, _ := os.OpenFile("session_audit.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+//	inner, _ := session.LookupProvider("pg")
+//	session.Register("pg_audited", session.NewAuditProvider(inner, session.NewAuditWriter(f)))
+//	SessManager, err := session.NewManager("pg_audited", ...)
+func NewAuditProvider(inner Provider, sink AuditSink) *AuditProvider {
+	return &AuditProvider{Provider: inner, sink: sink}
+}
+
+// SetMetadataFunc sets a function returning caller metadata of a session added to its records,
+// e.g. user ID or client address kept by the application for the session.
+func (apder *AuditProvider) SetMetadataFunc(fn func(sid string) map[string]string) {
+	apder.mx.Lock()
+	defer apder.mx.Unlock()
+	apder.metadata = fn
+}
+
+// SetErrorHandler sets a function called if sink fails to write a record,
+// by default errors are logged with slog.Default().
+func (apder *AuditProvider) SetErrorHandler(fn func(rec AuditRecord, err error)) {
+	apder.mx.Lock()
+	defer apder.mx.Unlock()
+	apder.onError = fn
+}
+
+// audit writes a record of operation op, opErr is the operation error.
+func (apder *AuditProvider) audit(sid, op, key string, opErr error) {
+	apder.mx.Lock()
+	metadata, on_error := apder.metadata, apder.onError
+	apder.mx.Unlock()
+
+	rec := AuditRecord{Time: time.Now(), SID: sid, Operation: op, Key: key}
+	if opErr != nil {
+		rec.Error = opErr.Error()
+	}
+	if metadata != nil && sid != "" {
+		rec.Metadata = metadata(sid)
+	}
+	if err := apder.sink.WriteAudit(rec); err != nil {
+		if on_error != nil {
+			on_error(rec, err)
+			return
+		}
+		slog.Default().Error("session: audit record not written", LOG_KEY_SID, sid, LOG_KEY_OPERATION, op, LOG_KEY_ERROR, err)
+	}
+}
+
+// InitProvider initializes inner provider, sessions removed by its GC
+// are recorded if it implements ExpiryNotifier.
+func (apder *AuditProvider) InitProvider(provParams []interface{}) error {
+	if notifier, ok := apder.Provider.(ExpiryNotifier); ok {
+		notifier.SetExpiredHook(apder.sessionExpired)
+	}
+	return apder.Provider.InitProvider(provParams)
+}
+
+// SessionInit creates session with inner provider.
+func (apder *AuditProvider) SessionInit(sid string) (Session, error) {
+	sess, err := apder.Provider.SessionInit(sid)
+	apder.audit(sid, AUDIT_OP_INIT, "", err)
+	if err != nil {
+		return nil, err
+	}
+	return &auditSession{Session: sess, pder: apder}, nil
+}
+
+// SessionRead reads session with inner provider.
+func (apder *AuditProvider) SessionRead(sid string) (Session, error) {
+	sess, err := apder.Provider.SessionRead(sid)
+	apder.audit(sid, AUDIT_OP_READ, "", err)
+	if err != nil {
+		return nil, err
+	}
+	return &auditSession{Session: sess, pder: apder}, nil
+}
+
+// SessionDestroy destroys session with inner provider.
+func (apder *AuditProvider) SessionDestroy(sid string) error {
+	err := apder.Provider.SessionDestroy(sid)
+	apder.audit(sid, AUDIT_OP_DESTROY, "", err)
+	return err
+}
+
+// DestroyAllSessions destroys all sessions with inner provider.
+func (apder *AuditProvider) DestroyAllSessions(l io.Writer, logLev LogLevel) {
+	apder.Provider.DestroyAllSessions(l, logLev)
+	apder.audit("", AUDIT_OP_DESTROY_ALL, "", nil)
+}
+
+// SetExpiredHook implements ExpiryNotifier.
+func (apder *AuditProvider) SetExpiredHook(fn SessionHook) {
+	apder.mx.Lock()
+	defer apder.mx.Unlock()
+	apder.expiredHook = fn
+}
+
+func (apder *AuditProvider) sessionExpired(sid string) {
+	apder.audit(sid, AUDIT_OP_EXPIRE, "", nil)
+	apder.mx.Lock()
+	fn := apder.expiredHook
+	apder.mx.Unlock()
+	if fn != nil {
+		fn(sid)
+	}
+}
+
+// SetChangedHook passes hook to inner provider if it implements ChangeNotifier.
+func (apder *AuditProvider) SetChangedHook(fn SessionHook) {
+	if notifier, ok := apder.Provider.(ChangeNotifier); ok {
+		notifier.SetChangedHook(fn)
+	}
+}
+
+// IsFatal classifies err with inner provider if it implements Reconnector.
+func (apder *AuditProvider) IsFatal(err error) bool {
+	reconnector, ok := apder.Provider.(Reconnector)
+	return ok && reconnector.IsFatal(err)
+}
+
+// Reconnect implements Reconnector with inner provider.
+func (apder *AuditProvider) Reconnect(ctx context.Context) error {
+	if reconnector, ok := apder.Provider.(Reconnector); ok {
+		return reconnector.Reconnect(ctx)
+	}
+	return nil
+}
+
+// Drain implements DrainProvider with inner provider.
+func (apder *AuditProvider) Drain() error {
+	if drain_pder, ok := apder.Provider.(DrainProvider); ok {
+		return drain_pder.Drain()
+	}
+	return nil
+}
+
+// SetGCMaxDeletions implements GCLimiter, the limit is passed to inner provider.
+func (apder *AuditProvider) SetGCMaxDeletions(maxDeletions int) {
+	setGCMaxDeletions(maxDeletions, apder.Provider)
+}
+
+// SetExpirationMode implements ExpirationModeSetter, the mode is passed to inner provider.
+func (apder *AuditProvider) SetExpirationMode(mode ExpirationMode) {
+	setExpirationMode(mode, apder.Provider)
+}
+
+// SetStrictMode implements StrictModeSetter, the mode is passed to inner provider.
+// ErrNoStrictMode is returned if inner provider does not support strict mode.
+func (apder *AuditProvider) SetStrictMode(strict bool) error {
+	return setStrictMode(strict, apder.Provider)
+}
+
+// SetClock implements ClockSetter, the clock is passed to inner provider.
+func (apder *AuditProvider) SetClock(clock Clock) {
+	setClock(clock, apder.Provider)
+}
+
+// SessionMeta implements MetaProvider with inner provider, the call is not audited.
+func (apder *AuditProvider) SessionMeta(sid string) (SessionMeta, error) {
+	return sessionMeta(apder.Provider, sid)
+}
+
+// SessionPeek implements PeekProvider with inner provider, peeked sessions are not audited.
+func (apder *AuditProvider) SessionPeek(sid string) (Session, error) {
+	return sessionPeek(apder.Provider, sid)
+}
+
+// AcquireGCLeader implements GCLeaderLocker with inner provider.
+func (apder *AuditProvider) AcquireGCLeader(name string, ttl time.Duration) (bool, error) {
+	locker, err := gcLeaderLocker(apder.Provider)
+	if err != nil {
+		return false, err
+	}
+	return locker.AcquireGCLeader(name, ttl)
+}
+
+// ReleaseGCLeader implements GCLeaderLocker with inner provider.
+func (apder *AuditProvider) ReleaseGCLeader(name string) error {
+	locker, err := gcLeaderLocker(apder.Provider)
+	if err != nil {
+		return err
+	}
+	return locker.ReleaseGCLeader(name)
+}
+
+// SetKeyRing passes key ring to inner provider if it implements EncryptedProvider.
+func (apder *AuditProvider) SetKeyRing(keyRing *KeyRing) {
+	if enc_pder, ok := apder.Provider.(EncryptedProvider); ok {
+		enc_pder.SetKeyRing(keyRing)
+	}
+}
+
+// SetLogger passes logger to inner provider if it implements LoggedProvider.
+func (apder *AuditProvider) SetLogger(logger *slog.Logger) {
+	if log_pder, ok := apder.Provider.(LoggedProvider); ok {
+		log_pder.SetLogger(logger)
+	}
+}
+
+// EnsureSchema implements SchemaProvider with inner provider.
+func (apder *AuditProvider) EnsureSchema(ctx context.Context) error {
+	return ensureSchema(ctx, apder.Provider)
+}
+
+// SessionStats implements StatsProvider with inner provider.
+func (apder *AuditProvider) SessionStats(ctx context.Context, sampleSize int) (SessionStats, error) {
+	return ProviderStats(ctx, apder.Provider, sampleSize)
+}
+
+// SessionCount implements AdminProvider with inner provider.
+func (apder *AuditProvider) SessionCount() (int, error) {
+	adm_pder, ok := apder.Provider.(AdminProvider)
+	if !ok {
+		return 0, ErrNotAdminProvider
+	}
+	return adm_pder.SessionCount()
+}
+
+// SessionList implements AdminProvider with inner provider.
+func (apder *AuditProvider) SessionList(offset, limit int) ([]SessionMeta, error) {
+	adm_pder, ok := apder.Provider.(AdminProvider)
+	if !ok {
+		return nil, ErrNotAdminProvider
+	}
+	return adm_pder.SessionList(offset, limit)
+}
+
+// SessionDestroyMany destroys sessions with inner provider, every session is recorded.
+func (apder *AuditProvider) SessionDestroyMany(sids []string) error {
+	if bulk_pder, ok := apder.Provider.(BulkDestroyProvider); ok {
+		err := bulk_pder.SessionDestroyMany(sids)
+		for _, sid := range sids {
+			apder.audit(sid, AUDIT_OP_DESTROY, "", err)
+		}
+		return err
+	}
+	var errs []error
+	for _, sid := range sids {
+		if err := apder.SessionDestroy(sid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// auditSession records value modifications.
+type auditSession struct {
+	Session
+	pder *AuditProvider
+}
+
+func (s *auditSession) Set(key string, value interface{}) error {
+	err := s.Session.Set(key, value)
+	s.pder.audit(s.SessionID(), AUDIT_OP_SET, key, err)
+	return err
+}
+
+func (s *auditSession) Put(key string, value interface{}) error {
+	err := s.Session.Put(key, value)
+	s.pder.audit(s.SessionID(), AUDIT_OP_SET, key, err)
+	return err
+}
+
+func (s *auditSession) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	err := s.Session.SetWithTTL(key, value, ttl)
+	s.pder.audit(s.SessionID(), AUDIT_OP_SET, key, err)
+	return err
+}
+
+func (s *auditSession) SetSensitive(key string, value interface{}) error {
+	err := s.Session.SetSensitive(key, value)
+	s.pder.audit(s.SessionID(), AUDIT_OP_SET, key, err)
+	return err
+}
+
+// SetMany records every set key.
+func (s *auditSession) SetMany(values map[string]interface{}) error {
+	err := s.Session.SetMany(values)
+	for _, key := range sortedKeys(values) {
+		s.pder.audit(s.SessionID(), AUDIT_OP_SET, key, err)
+	}
+	return err
+}
+
+func (s *auditSession) Restore(snapshot map[string]interface{}) error {
+	err := s.Session.Restore(snapshot)
+	s.pder.audit(s.SessionID(), AUDIT_OP_RESTORE, "", err)
+	return err
+}
+
+func (s *auditSession) Delete(key string) error {
+	err := s.Session.Delete(key)
+	s.pder.audit(s.SessionID(), AUDIT_OP_DELETE, key, err)
+	return err
+}
+
+func (s *auditSession) Clear() error {
+	err := s.Session.Clear()
+	s.pder.audit(s.SessionID(), AUDIT_OP_CLEAR, "", err)
+	return err
+}
+
+func (s *auditSession) Increment(key string, delta int64) (int64, error) {
+	v, err := s.Session.Increment(key, delta)
+	s.pder.audit(s.SessionID(), AUDIT_OP_INCREMENT, key, err)
+	return v, err
+}
+
+func (s *auditSession) Decrement(key string, delta int64) (int64, error) {
+	v, err := s.Session.Decrement(key, delta)
+	s.pder.audit(s.SessionID(), AUDIT_OP_INCREMENT, key, err)
+	return v, err
+}
+
+func (s *auditSession) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
+	swapped, err := s.Session.CompareAndSwap(key, oldValue, newValue)
+	if swapped || err != nil {
+		s.pder.audit(s.SessionID(), AUDIT_OP_CAS, key, err)
+	}
+	return swapped, err
+}
+
+func (s *auditSession) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	computed := false
+	err := s.Session.GetOrSet(key, dest, func() (interface{}, error) {
+		computed = true
+		return compute()
+	})
+	if computed || err != nil {
+		s.pder.audit(s.SessionID(), AUDIT_OP_GET_OR_SET, key, err)
+	}
+	return err
+}
+
+func (s *auditSession) SetExpiry(d time.Duration) error {
+	err := s.Session.SetExpiry(d)
+	s.pder.audit(s.SessionID(), AUDIT_OP_SET_EXPIRY, "", err)
+	return err
+}
+
+func (s *auditSession) Bucket(name string) Session {
+	return NewBucket(s, name)
+}
+
+// SessionUserIndex implements UserIndexProvider with inner provider.
+func (apder *AuditProvider) SessionUserIndex() UserIndex {
+	return userIndexOf(apder.Provider)
+}
+
+// ValidateSessionID checks session ID format with inner provider.
+func (apder *AuditProvider) ValidateSessionID(sid string) error {
+	return ValidateSessionID(apder.Provider, sid)
+}
@@ -0,0 +1,75 @@
+package session_test
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dronm/session"
+	"github.com/dronm/session/mock"
+)
+
+// TestAuditProvider checks records written by audit provider decorator.
+func TestAuditProvider(t *testing.T) {
+	var mx sync.Mutex
+	records := make([]session.AuditRecord, 0)
+	apder := session.NewAuditProvider(mock.NewProvider(), session.AuditSinkFunc(func(rec session.AuditRecord) error {
+		mx.Lock()
+		defer mx.Unlock()
+		records = append(records, rec)
+		return nil
+	}))
+	apder.SetMetadataFunc(func(sid string) map[string]string {
+		return map[string]string{"user": "test"}
+	})
+	SessManager, err := session.NewManagerWithProvider(apder, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Set("a", 1); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := currentSession.Put("b", 2); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := currentSession.Delete("a"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if _, err := currentSession.Bucket("cart").Increment("items", 1); err != nil {
+		t.Fatalf("Increment() failed: %v", err)
+	}
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+
+	want := []string{
+		session.AUDIT_OP_INIT,
+		session.AUDIT_OP_SET + " a",
+		session.AUDIT_OP_SET + " b",
+		session.AUDIT_OP_DELETE + " a",
+		session.AUDIT_OP_INCREMENT + " cart" + session.BUCKET_SEP + "items",
+		session.AUDIT_OP_DESTROY,
+	}
+	mx.Lock()
+	defer mx.Unlock()
+	got := make([]string, 0)
+	for _, rec := range records {
+		if rec.Operation == session.AUDIT_OP_READ {
+			continue
+		}
+		if rec.SID != sid || rec.Metadata["user"] != "test" || rec.Error != "" {
+			t.Errorf("unexpected record %+v", rec)
+		}
+		got = append(got, strings.TrimSpace(rec.Operation+" "+rec.Key))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("audit records wanted %v, got %v", want, got)
+	}
+}
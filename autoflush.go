@@ -0,0 +1,196 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// SetAutoFlush enables periodic flushing of modified sessions, so values
+// set without Flush() are not lost if the process crashes, e.g. with sqlite provider
+// keeping values in memory until Flush(). Sessions modified since their last Flush()
+// are flushed every interval and on Close(), interval 0 disables auto flush.
+// SessionClose() flushes the modified session at once, it is not flushed after closing.
+// Flush errors are logged with the logger set with SetLogger().
+// Should be set before sessions are started.
+func (manager *Manager) SetAutoFlush(interval time.Duration) {
+	manager.stopAutoFlush()
+	manager.flushInterval = interval
+	if interval <= 0 {
+		return
+	}
+	var ctx context.Context
+	ctx, manager.flushCancel = context.WithCancel(context.Background())
+	manager.flushWG.Add(1)
+	go (func() {
+		defer manager.flushWG.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := manager.flushDirty(); err != nil && manager.logger != nil {
+					manager.logger.Error("auto flush failed", LOG_KEY_OPERATION, "AutoFlush", LOG_KEY_ERROR, err)
+				}
+			}
+		}
+	})()
+}
+
+// stopAutoFlush stops auto flush goroutine and waits till a flush in progress is finished.
+func (manager *Manager) stopAutoFlush() {
+	if manager.flushCancel != nil {
+		manager.flushCancel()
+		manager.flushCancel = nil
+	}
+	manager.flushWG.Wait()
+}
+
+// dirtySessions holds sessions modified since their last Flush().
+type dirtySessions struct {
+	mx       sync.Mutex
+	sessions map[*autoFlushSession]struct{}
+}
+
+func (d *dirtySessions) add(s *autoFlushSession) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if d.sessions == nil {
+		d.sessions = make(map[*autoFlushSession]struct{})
+	}
+	d.sessions[s] = struct{}{}
+}
+
+func (d *dirtySessions) remove(s *autoFlushSession) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	delete(d.sessions, s)
+}
+
+// forget removes all sessions with the given ID, e.g. destroyed or closed ones, returns removed sessions.
+func (d *dirtySessions) forget(sid string) []*autoFlushSession {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	var list []*autoFlushSession
+	for s := range d.sessions {
+		if s.SessionID() == sid {
+			delete(d.sessions, s)
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// take returns all sessions and clears the list.
+func (d *dirtySessions) take() []*autoFlushSession {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	list := make([]*autoFlushSession, 0, len(d.sessions))
+	for s := range d.sessions {
+		list = append(list, s)
+	}
+	d.sessions = nil
+	return list
+}
+
+// flushDirty flushes all modified sessions.
+func (manager *Manager) flushDirty() error {
+	var errs []error
+	for _, s := range manager.dirty.take() {
+		if err := s.Session.Flush(); err != nil {
+			manager.dirty.add(s) //next time
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// autoFlushSession registers itself as modified with manager on every modification,
+// see SetAutoFlush().
+type autoFlushSession struct {
+	Session
+	dirty *dirtySessions
+}
+
+func (s *autoFlushSession) Set(key string, value interface{}) error {
+	if err := s.Session.Set(key, value); err != nil {
+		return err
+	}
+	s.dirty.add(s)
+	return nil
+}
+
+func (s *autoFlushSession) Put(key string, value interface{}) error {
+	if err := s.Session.Put(key, value); err != nil {
+		return err
+	}
+	s.dirty.remove(s)
+	return nil
+}
+
+func (s *autoFlushSession) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	if err := s.Session.SetWithTTL(key, value, ttl); err != nil {
+		return err
+	}
+	s.dirty.add(s)
+	return nil
+}
+
+func (s *autoFlushSession) SetSensitive(key string, value interface{}) error {
+	if err := s.Session.SetSensitive(key, value); err != nil {
+		return err
+	}
+	s.dirty.add(s)
+	return nil
+}
+
+func (s *autoFlushSession) SetMany(values map[string]interface{}) error {
+	if err := s.Session.SetMany(values); err != nil {
+		return err
+	}
+	s.dirty.add(s)
+	return nil
+}
+
+func (s *autoFlushSession) Restore(snapshot map[string]interface{}) error {
+	if err := s.Session.Restore(snapshot); err != nil {
+		return err
+	}
+	s.dirty.add(s)
+	return nil
+}
+
+func (s *autoFlushSession) Flush() error {
+	if err := s.Session.Flush(); err != nil {
+		return err
+	}
+	s.dirty.remove(s)
+	return nil
+}
+
+func (s *autoFlushSession) Delete(key string) error {
+	if err := s.Session.Delete(key); err != nil {
+		return err
+	}
+	s.dirty.add(s)
+	return nil
+}
+
+func (s *autoFlushSession) Clear() error {
+	if err := s.Session.Clear(); err != nil {
+		return err
+	}
+	s.dirty.add(s)
+	return nil
+}
+
+func (s *autoFlushSession) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	return GetOrSet(s, key, dest, compute)
+}
+
+func (s *autoFlushSession) Bucket(name string) Session {
+	return NewBucket(s, name)
+}
@@ -0,0 +1,70 @@
+package session_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dronm/session"
+	"github.com/dronm/session/mock"
+)
+
+// TestAutoFlushClose checks that a modified session is flushed by SessionClose()
+// and is not flushed by auto flush after closing.
+func TestAutoFlushClose(t *testing.T) {
+	pder := mock.NewProvider()
+	SessManager, err := session.NewManagerWithProvider(pder, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	SessManager.SetAutoFlush(time.Hour)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Set("key", "value"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := SessManager.SessionClose(sid); err != nil {
+		t.Fatalf("SessionClose() failed: %v", err)
+	}
+	if stored, _ := pder.Stored(sid); stored["key"] != "value" {
+		t.Fatalf("modified session is not flushed by SessionClose(): %v", stored)
+	}
+
+	if err := SessManager.Close(context.Background()); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if n := pder.CallCount("Flush"); n != 1 {
+		t.Fatalf("closed session is flushed %d times, wanted once", n)
+	}
+}
+
+// TestAutoFlushStop checks that Close() waits for auto flush in progress.
+func TestAutoFlushStop(t *testing.T) {
+	pder := mock.NewProvider()
+	SessManager, err := session.NewManagerWithProvider(pder, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	SessManager.SetAutoFlush(10 * time.Millisecond)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	pder.SetLatency("Flush", 200*time.Millisecond)
+	if err := currentSession.Set("key", "value"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond) //auto flush is in progress
+	if err := SessManager.Close(context.Background()); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if stored, _ := pder.Stored(sid); stored["key"] != "value" {
+		t.Fatalf("Close() returned before auto flush is finished: %v", stored)
+	}
+}
@@ -0,0 +1,41 @@
+package session
+
+import (
+	"errors"
+	"sort"
+)
+
+// SetMany sets session values one by one with Session.Set(), stops on the first error.
+// Providers not able to set many values at once implement Session.SetMany() with this function.
+func SetMany(s Session, values map[string]interface{}) error {
+	for _, key := range sortedKeys(values) {
+		if err := s.Set(key, values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMany assigns session values to dest pointers by keys with Session.GetStruct(),
+// keys without session values are deleted from dest.
+// Providers not able to read many values at once implement Session.GetMany() with this function.
+func GetMany(s Session, dest map[string]interface{}) error {
+	for key, val := range dest {
+		if err := s.GetStruct(key, val); errors.Is(err, ErrKeyNotFound) {
+			delete(dest, key)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns map keys in sorted order, so values are set in the same order every time.
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,151 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/dronm/session"
+	"github.com/dronm/session/bolt"
+	"github.com/dronm/session/cookie"
+	"github.com/dronm/session/pg"
+	"github.com/dronm/session/redis"
+	"github.com/dronm/session/sqlite"
+)
+
+const (
+	ENV_REDIS_CONN = "REDIS_CONN"
+	ENV_PG_CONN    = "PG_CONN"
+
+	COOKIE_HASH_KEY = "6bU3k0LmQ2v9xR7tY1wE4pA8sD5fG2hJ"
+	PG_ENCRYPT_KEY  = "54ref65w104erc6f4c69e14cfv"
+)
+
+// benchProvider returns provider parameters of NewManager(), skips the benchmark if the provider is not available.
+type benchProvider struct {
+	name   string
+	schema bool //EnsureSchema() is called
+	params func(b *testing.B) []interface{}
+}
+
+var providers = []benchProvider{
+	{sqlite.PROVIDER, true, func(b *testing.B) []interface{} {
+		return []interface{}{filepath.Join(b.TempDir(), "session.db")}
+	}},
+	{bolt.PROVIDER, false, func(b *testing.B) []interface{} {
+		return []interface{}{filepath.Join(b.TempDir(), "session.bolt")}
+	}},
+	{cookie.PROVIDER, false, func(b *testing.B) []interface{} {
+		return []interface{}{COOKIE_HASH_KEY}
+	}},
+	{redis.PROVIDER, false, func(b *testing.B) []interface{} {
+		return []interface{}{envVar(b, ENV_REDIS_CONN), "bench"}
+	}},
+	{pg.PROVIDER, true, func(b *testing.B) []interface{} {
+		dbpool, err := pgxpool.New(context.Background(), envVar(b, ENV_PG_CONN))
+		if err != nil {
+			b.Fatalf("pgxpool.New() failed: %v", err)
+		}
+		b.Cleanup(dbpool.Close)
+		return []interface{}{dbpool, PG_ENCRYPT_KEY}
+	}},
+}
+
+func envVar(b *testing.B, name string) string {
+	v := os.Getenv(name)
+	if v == "" {
+		b.Skipf("%s is not set", name)
+	}
+	return v
+}
+
+// forEachProvider runs fn as a sub-benchmark for every available provider.
+func forEachProvider(b *testing.B, fn func(b *testing.B, manager *session.Manager)) {
+	for _, p := range providers {
+		b.Run(p.name, func(b *testing.B) {
+			manager, err := session.NewManager(p.name, 0, 0, "", p.params(b)...)
+			if err != nil {
+				b.Fatalf("NewManager() failed: %v", err)
+			}
+			b.Cleanup(func() { manager.CloseProvider() }) //after sessions are destroyed
+			if p.schema {
+				if err := manager.EnsureSchema(context.Background()); err != nil {
+					b.Fatalf("EnsureSchema() failed: %v", err)
+				}
+			}
+			fn(b, manager)
+		})
+	}
+}
+
+// startSession starts a new session holding a flushed value.
+func startSession(b *testing.B, manager *session.Manager) session.Session {
+	sess, err := manager.SessionStart("")
+	if err != nil {
+		b.Fatalf("SessionStart() failed: %v", err)
+	}
+	if err := sess.Put("user", "john"); err != nil {
+		b.Fatalf("Put() failed: %v", err)
+	}
+	b.Cleanup(func() { manager.SessionDestroy(sess.SessionID()) })
+	return sess
+}
+
+func BenchmarkSet(b *testing.B) {
+	forEachProvider(b, func(b *testing.B, manager *session.Manager) {
+		sess := startSession(b, manager)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := sess.Set("counter", i); err != nil {
+				b.Fatalf("Set() failed: %v", err)
+			}
+		}
+	})
+}
+
+// BenchmarkGet reads one session from parallel goroutines, readers must not serialize.
+func BenchmarkGet(b *testing.B) {
+	forEachProvider(b, func(b *testing.B, manager *session.Manager) {
+		sess := startSession(b, manager)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if v := sess.GetString("user"); v != "john" {
+					b.Errorf("GetString() returned %q", v)
+					return
+				}
+			}
+		})
+	})
+}
+
+func BenchmarkFlush(b *testing.B) {
+	forEachProvider(b, func(b *testing.B, manager *session.Manager) {
+		sess := startSession(b, manager)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := sess.Set("counter", i); err != nil {
+				b.Fatalf("Set() failed: %v", err)
+			}
+			if err := sess.Flush(); err != nil {
+				b.Fatalf("Flush() failed: %v", err)
+			}
+		}
+	})
+}
+
+// BenchmarkSessionStart reads an existing session.
+func BenchmarkSessionStart(b *testing.B) {
+	forEachProvider(b, func(b *testing.B, manager *session.Manager) {
+		sid := startSession(b, manager).SessionID()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := manager.SessionStart(sid); err != nil {
+				b.Fatalf("SessionStart() failed: %v", err)
+			}
+		}
+	})
+}
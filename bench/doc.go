@@ -0,0 +1,9 @@
+// Package bench holds benchmarks of session providers.
+//
+// Embedded providers (sqlite, bolt, cookie) are always benchmarked,
+// redis and postgresql ones if REDIS_CONN and PG_CONN environment variables are set:
+//
+//	REDIS_CONN=redis://localhost:6379/0 PG_CONN=postgres://localhost/test_proj go test -bench . -benchmem ./bench/
+//
+// Run with -race to check that hot paths are free of data races.
+package bench
@@ -0,0 +1,1251 @@
+// Package bolt contains session provider based on bbolt embedded key/value database.
+// It is a pure Go provider, CGO is not required.
+// Requirements:
+//
+//	bbolt https://github.com/etcd-io/bbolt
+//
+// Sessions are kept in session_vals bucket with session ID as a key, locks are kept in session_locks bucket.
+// Sessions bound to users with session.Manager.BindUser() are indexed in session_users and session_user_ids buckets.
+// Buckets are created by InitProvider().
+//
+// Internally gob encoder is used for data serialization. Session data is read at start and kept in memory SessionStore structure.
+// Session key-value pares are kept in storeValue type.
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dronm/session"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Deprecated: use session.ErrKeyNotFound.
+var EKeyNotFound = session.ErrKeyNotFound
+
+// Deprecated: use session.ErrValMustBePtr.
+var EValMustBePtr = session.ErrValMustBePtr
+
+// Session key ID length.
+const SESS_ID_LEN = 36
+
+const PROVIDER = "bolt"
+
+const LOG_PREF = "bolt provider:"
+
+// Bucket names.
+var (
+	BUCKET_VALS     = []byte("session_vals")
+	BUCKET_LOCKS    = []byte("session_locks")
+	BUCKET_USERS    = []byte("session_users")    //user ID + 0 + session ID -> bind time, see userIndex
+	BUCKET_USER_IDS = []byte("session_user_ids") //session ID -> user ID
+)
+
+// pder holds pointer to Provider struct registered as PROVIDER.
+var pder = NewProvider()
+
+// NewProvider returns a provider independent of the registered one, e.g. to use another database file
+// in another Manager created with session.NewManagerWithProvider().
+func NewProvider() *Provider {
+	return &Provider{clock: session.SystemClock{}}
+}
+
+// storeValue holds session key-value pares.
+type storeValue map[string]interface{}
+
+// dbRecord is a session record kept in session_vals bucket.
+type dbRecord struct {
+	AccessedTime time.Time
+	CreateTime   time.Time
+	ExpiresAt    time.Time //set by SessionStore.SetExpiry(), zero if not set
+	Val          []byte    //encoded storeValue
+	Rates        session.RateCounters
+}
+
+// SessionStore contains session information.
+type SessionStore struct {
+	sid           string    //session id
+	pder          *Provider //provider storing the session
+	mx            sync.RWMutex
+	timeAccessed  atomic.Int64 //last access in Unix nanoseconds, updated under read lock by getters
+	timeCreated   time.Time    //when created
+	value         storeValue   //key-value pair
+	valueModified bool
+	lockToken     string //set when session is locked
+}
+
+// Set sets inmemory value. No database flush is done.
+func (st *SessionStore) Set(key string, value interface{}) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if !reflect.DeepEqual(st.value[key], value) {
+		st.value[key] = value
+		st.valueModified = true
+		st.accessed()
+	}
+	return nil
+}
+
+func (st *SessionStore) Put(key string, value interface{}) error {
+	if err := st.Set(key, value); err != nil {
+		return err
+	}
+	return st.Flush()
+}
+
+// SetMany sets in-memory values under one lock, they are written by one Flush().
+func (st *SessionStore) SetMany(values map[string]interface{}) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	for key, value := range values {
+		if !reflect.DeepEqual(st.value[key], value) {
+			st.value[key] = value
+			st.valueModified = true
+			st.accessed()
+		}
+	}
+	return nil
+}
+
+// SetWithTTL sets in-memory value expiring in ttl, see session.ExpiringValue.
+func (st *SessionStore) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value[key] = session.NewExpiringValueAt(st.pder.clock.Now(), value, ttl)
+	st.valueModified = true
+	st.accessed()
+	return nil
+}
+
+// GetMany assigns in-memory values to dest pointers, see session.GetMany().
+func (st *SessionStore) GetMany(dest map[string]interface{}) error {
+	return session.GetMany(st, dest)
+}
+
+// Snapshot returns a deep copy of in-memory values without expired ones, see session.SnapshotValuesAt().
+func (st *SessionStore) Snapshot() (map[string]interface{}, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return session.SnapshotValuesAt(st.pder.clock.Now(), st.value)
+}
+
+// Restore replaces in-memory values with a copy of snapshot, they are written by Flush().
+func (st *SessionStore) Restore(snapshot map[string]interface{}) error {
+	value, err := session.CopyValues(snapshot)
+	if err != nil {
+		return err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value = value
+	st.valueModified = true
+	st.accessed()
+	return nil
+}
+
+// Flush performs the actual write to database.
+func (st *SessionStore) Flush() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+
+	//flush val only if it's been modified
+	if st.valueModified {
+		//modified
+		val, err := st.pder.getForDb(&st.value)
+		if err != nil {
+			return err
+		}
+
+		if err := st.pder.db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(BUCKET_VALS)
+			rec, err := getRecord(bucket, st.sid)
+			if err != nil {
+				return err
+			}
+			if rec == nil {
+				rec = &dbRecord{CreateTime: st.timeCreated}
+			}
+			rec.Val = val
+			st.pder.touchRecord(rec)
+			return putRecord(bucket, st.sid, rec)
+		}); err != nil {
+			return err
+		}
+		st.valueModified = false
+		st.written()
+	}
+
+	return nil
+}
+
+// Get returns session value by its key. Value is retrieved from memory.
+func (st *SessionStore) Get(key string, val interface{}) error {
+	st.mx.RLock()
+	store_val, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if !ok {
+		return session.ErrKeyNotFound
+	}
+
+	// Get the type of val
+	val_type := reflect.TypeOf(val)
+
+	// Make sure val is a pointer
+	if val_type.Kind() != reflect.Ptr {
+		return session.ErrValMustBePtr
+	}
+
+	// Dereference the pointer and check if it's assignable
+	val_elem := val_type.Elem()
+	if !reflect.TypeOf(store_val).AssignableTo(val_elem) {
+		return session.ErrTypeMismatch
+	}
+
+	// Assign the value to val
+	reflect.ValueOf(val).Elem().Set(reflect.ValueOf(store_val))
+
+	return nil
+}
+
+// GetStruct retrieves session value by its key into dest pointer.
+// Value of a different type is converted, see session.AssignValue().
+func (st *SessionStore) GetStruct(key string, dest interface{}) error {
+	st.mx.RLock()
+	store_val, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if !ok {
+		return session.ErrKeyNotFound
+	}
+	return session.AssignValue(store_val, dest)
+}
+
+// GetBool returns bool value by key.
+func (st *SessionStore) GetBool(key string) bool {
+	v, ok := st.lookup(key)
+	if !ok {
+		return false
+	}
+
+	if v_bool, ok := v.(bool); ok {
+		return v_bool
+	}
+	return false
+}
+
+// GetString returns string value by key.
+func (st *SessionStore) GetString(key string) string {
+	v, ok := st.lookup(key)
+	if !ok {
+		return ""
+	}
+
+	if v_str, ok := v.(string); ok {
+		return v_str
+
+	} else if v_str, ok := v.([]byte); ok {
+		return string(v_str)
+	}
+	return ""
+}
+
+// GetInt returns int value by key.
+func (st *SessionStore) GetInt(key string) int64 {
+	v, ok := st.lookup(key)
+	if !ok {
+		return 0
+	}
+
+	if v_i, ok := v.(int64); ok {
+		return v_i
+
+	} else if v_i, ok := v.(int); ok {
+		return int64(v_i)
+	}
+	return 0
+}
+
+// GetFloat returns float value by key.
+func (st *SessionStore) GetFloat(key string) float64 {
+	v, ok := st.lookup(key)
+	if !ok {
+		return 0
+	}
+
+	if v_f, ok := v.(float64); ok {
+		return v_f
+
+	} else if v_f, ok := v.(float32); ok {
+		return float64(v_f)
+	}
+	return 0
+}
+
+// GetDate returns time.Time value by key.
+func (st *SessionStore) GetDate(key string) time.Time {
+	v, ok := st.lookup(key)
+	if !ok {
+		return time.Time{}
+	}
+
+	if v_t, ok := v.(time.Time); ok {
+		return v_t
+	}
+	return time.Time{}
+}
+
+// GetBytes returns []byte value by key, string value is converted.
+func (st *SessionStore) GetBytes(key string) []byte {
+	v, ok := st.lookup(key)
+	if !ok {
+		return nil
+	}
+
+	if v_b, ok := v.([]byte); ok {
+		return v_b
+
+	} else if v_b, ok := v.(string); ok {
+		return []byte(v_b)
+	}
+	return nil
+}
+
+// GetStringSlice returns []string value by key.
+func (st *SessionStore) GetStringSlice(key string) []string {
+	v, ok := st.lookup(key)
+	if !ok {
+		return nil
+	}
+
+	if v_s, ok := v.([]string); ok {
+		return v_s
+	}
+	return nil
+}
+
+// GetOrSet assigns session value to dest, if there is no value
+// it is computed, stored and flushed under session lock, see session.GetOrSet().
+func (st *SessionStore) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	return session.GetOrSet(st, key, dest, compute)
+}
+
+// Delete deletes session value from memmory by key. No flushing is done.
+func (st *SessionStore) Delete(key string) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if _, ok := st.value[key]; !ok {
+		return nil
+	}
+	st.accessed()
+	delete(st.value, key)
+	st.valueModified = true
+
+	return nil
+}
+
+// Clear deletes all session values from memory keeping session ID and creation time. No flushing is done.
+func (st *SessionStore) Clear() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if len(st.value) > 0 {
+		st.value = make(storeValue)
+		st.valueModified = true
+	}
+	st.accessed()
+
+	return nil
+}
+
+// Increment atomically adds delta to integer session value and returns the new value.
+// Missing value is treated as 0. The value is updated in database within a write transaction
+// and in memory, other modified in-memory values are not flushed.
+func (st *SessionStore) Increment(key string, delta int64) (int64, error) {
+	//held till the value is updated in memory, concurrent Flush() must not overwrite it
+	st.mx.Lock()
+	defer st.mx.Unlock()
+
+	var new_val int64
+	if err := st.pder.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(BUCKET_VALS)
+		rec, err := getRecord(bucket, st.sid)
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			rec = &dbRecord{CreateTime: st.pder.clock.Now()}
+		}
+		db_value := make(storeValue)
+		if err := st.pder.setFromDb(&db_value, rec.Val); err != nil {
+			return err
+		}
+		cur, _ := session.LookupValueAt(st.pder.clock.Now(), db_value, key)
+		if new_val, err = session.IncrementValue(cur, delta); err != nil {
+			return err
+		}
+		db_value[key] = new_val
+		if rec.Val, err = st.pder.getForDb(&db_value); err != nil {
+			return err
+		}
+		st.pder.touchRecord(rec)
+		return putRecord(bucket, st.sid, rec)
+	}); err != nil {
+		return 0, err
+	}
+
+	st.value[key] = new_val
+	st.written()
+
+	return new_val, nil
+}
+
+// Decrement atomically subtracts delta from integer session value and returns the new value.
+func (st *SessionStore) Decrement(key string, delta int64) (int64, error) {
+	return st.Increment(key, -delta)
+}
+
+// CompareAndSwap sets newValue in a write transaction if the stored value equals oldValue,
+// see session.Session.CompareAndSwap().
+func (st *SessionStore) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+
+	swapped := false
+	if err := st.pder.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(BUCKET_VALS)
+		rec, err := getRecord(bucket, st.sid)
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			rec = &dbRecord{CreateTime: st.pder.clock.Now()}
+		}
+		db_value := make(storeValue)
+		if err := st.pder.setFromDb(&db_value, rec.Val); err != nil {
+			return err
+		}
+		if cur, _ := session.LookupValueAt(st.pder.clock.Now(), db_value, key); !session.EqualValue(cur, oldValue) {
+			return nil
+		}
+		if newValue == nil {
+			delete(db_value, key)
+		} else {
+			db_value[key] = newValue
+		}
+		if rec.Val, err = st.pder.getForDb(&db_value); err != nil {
+			return err
+		}
+		st.pder.touchRecord(rec)
+		swapped = true
+		return putRecord(bucket, st.sid, rec)
+	}); err != nil {
+		return false, err
+	}
+	if !swapped {
+		return false, nil
+	}
+
+	if newValue == nil {
+		delete(st.value, key)
+	} else {
+		st.value[key] = newValue
+	}
+	st.written()
+
+	return true, nil
+}
+
+// Bucket returns a view of the session with keys prefixed by name, see session.NewBucket().
+func (st *SessionStore) Bucket(name string) session.Session {
+	return session.NewBucket(st, name)
+}
+
+// Keys returns sorted session value keys.
+func (st *SessionStore) Keys() ([]string, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	keys := make([]string, 0, len(st.value))
+	now := st.pder.clock.Now()
+	for key, v := range st.value {
+		if !session.ValueExpiredAt(now, v) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Len returns number of session values.
+func (st *SessionStore) Len() (int, error) {
+	keys, err := st.Keys()
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// SessionID returns session unique ID.
+func (st *SessionStore) SessionID() string {
+	return st.sid
+}
+
+// TimeCreated returns timeCreated property.
+func (st *SessionStore) TimeCreated() time.Time {
+	return st.timeCreated
+}
+
+// TimeAccessed returns timeAccessed property.
+func (st *SessionStore) TimeAccessed() time.Time {
+	return time.Unix(0, st.timeAccessed.Load())
+}
+
+// Lock acquires session lock. Lock is a record in session_locks bucket
+// with expiration time, it is released automatically after session.LockTTL().
+// Values are reloaded from database after the lock is acquired.
+func (st *SessionStore) Lock() error {
+	token, err := genLockToken()
+	if err != nil {
+		return err
+	}
+	wait_till := time.Now().Add(session.LOCK_WAIT)
+	for {
+		locked := false
+		if err := st.pder.db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(BUCKET_LOCKS)
+			now := st.pder.clock.Now()
+			if lock := bucket.Get([]byte(st.sid)); len(lock) > 8 {
+				lock_till := time.UnixMilli(int64(binary.BigEndian.Uint64(lock[:8])))
+				if lock_till.After(now) {
+					return nil //locked by someone else
+				}
+			}
+			lock := make([]byte, 8, 8+len(token))
+			binary.BigEndian.PutUint64(lock, uint64(now.Add(session.LockTTL()).UnixMilli()))
+			lock = append(lock, token...)
+			locked = true
+			return bucket.Put([]byte(st.sid), lock)
+		}); err != nil {
+			return err
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(wait_till) {
+			return session.ErrLockTimeout
+		}
+		time.Sleep(session.LOCK_RETRY)
+	}
+
+	st.mx.Lock()
+	st.lockToken = token
+	st.mx.Unlock()
+
+	return st.reload()
+}
+
+// Unlock releases session lock.
+func (st *SessionStore) Unlock() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if st.lockToken == "" {
+		return nil
+	}
+	if err := st.pder.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(BUCKET_LOCKS)
+		if lock := bucket.Get([]byte(st.sid)); len(lock) > 8 && string(lock[8:]) == st.lockToken {
+			return bucket.Delete([]byte(st.sid))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	st.lockToken = ""
+	return nil
+}
+
+// SetExpiry sets session expiration time to now+d, the session is removed by GC
+// after that time regardless of max life and idle time. d <= 0 restores defaults.
+func (st *SessionStore) SetExpiry(d time.Duration) error {
+	var expires_at time.Time
+	if d > 0 {
+		expires_at = st.pder.clock.Now().Add(d)
+	}
+	return st.updateRecord(func(rec *dbRecord) {
+		rec.ExpiresAt = expires_at
+	})
+}
+
+// Touch updates session access time in database,
+// nothing is done in session.EXPIRATION_FIXED mode.
+func (st *SessionStore) Touch() error {
+	if !st.pder.expMode.TouchOnWrite() {
+		return nil
+	}
+	now := st.pder.clock.Now()
+	if err := st.updateRecord(func(rec *dbRecord) {
+		rec.AccessedTime = now
+	}); err != nil {
+		return err
+	}
+	st.timeAccessed.Store(now.UnixNano())
+	return nil
+}
+
+// Allow counts action in the current window of provider clock, see session.AllowRate().
+func (st *SessionStore) Allow(action string, limit int, window time.Duration) (bool, error) {
+	return session.AllowRate(st, st.pder.clock, action, limit, window)
+}
+
+// CountRate implements session.RateCounter, counters are kept in session record in a write transaction.
+func (st *SessionStore) CountRate(action string, now time.Time, window time.Duration) (int64, error) {
+	var cnt int64
+	if err := st.pder.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(BUCKET_VALS)
+		rec, err := getRecord(bucket, st.sid)
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			rec = &dbRecord{CreateTime: st.pder.clock.Now()}
+		}
+		if rec.Rates == nil {
+			rec.Rates = make(session.RateCounters)
+		}
+		cnt = rec.Rates.Count(action, now, window)
+		st.pder.touchRecord(rec)
+		return putRecord(bucket, st.sid, rec)
+	}); err != nil {
+		return 0, err
+	}
+	return cnt, nil
+}
+
+// SetSensitive sets value encrypted with keys of session.SetSensitiveKeys(), see session.SetSensitive().
+func (st *SessionStore) SetSensitive(key string, value interface{}) error {
+	return session.SetSensitive(st, key, value)
+}
+
+// lookup returns in-memory value by key under read lock and updates access time on success,
+// so concurrent readers do not serialize.
+func (st *SessionStore) lookup(key string) (interface{}, bool) {
+	st.mx.RLock()
+	v, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if ok {
+		st.accessed()
+	}
+	return v, ok
+}
+
+// accessed updates in-memory access time if it is updated on every access.
+// The time is stored atomically, st.mx is not required.
+func (st *SessionStore) accessed() {
+	if st.pder.expMode.TouchOnRead() {
+		st.timeAccessed.Store(st.pder.clock.Now().UnixNano())
+	}
+}
+
+// written updates in-memory access time after session is written to database.
+func (st *SessionStore) written() {
+	if st.pder.expMode.TouchOnWrite() {
+		st.timeAccessed.Store(st.pder.clock.Now().UnixNano())
+	}
+}
+
+// updateRecord modifies session record with fn in a write transaction.
+// session.ErrSessionNotFound is returned if there is no record.
+func (st *SessionStore) updateRecord(fn func(rec *dbRecord)) error {
+	return st.pder.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(BUCKET_VALS)
+		rec, err := getRecord(bucket, st.sid)
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			return session.ErrSessionNotFound
+		}
+		fn(rec)
+		return putRecord(bucket, st.sid, rec)
+	})
+}
+
+// reload reads values from database to memory.
+func (st *SessionStore) reload() error {
+	var rec *dbRecord
+	if err := st.pder.db.View(func(tx *bolt.Tx) error {
+		var err error
+		rec, err = getRecord(tx.Bucket(BUCKET_VALS), st.sid)
+		return err
+	}); err != nil {
+		return err
+	}
+	value := make(storeValue)
+	if rec != nil {
+		if err := st.pder.setFromDb(&value, rec.Val); err != nil {
+			return err
+		}
+	}
+	st.mx.Lock()
+	st.value = value
+	st.valueModified = false
+	st.mx.Unlock()
+	return nil
+}
+
+// Provider structure holds provider information.
+type Provider struct {
+	db             *bolt.DB
+	payloadVersion *session.PayloadVersion //payload versions, nil if not used
+	maxLifeTime    int64
+	maxIdleTime    int64
+	logger         *slog.Logger        //structured logger, nil if not set
+	expiredHook    session.SessionHook //called for sessions removed by SessionGC
+	gcLimit        int                 //max sessions removed by one SessionGC run, 0 if not limited
+
+	expMode session.ExpirationMode //when record access time is updated
+	strict  bool                   //unknown sessions are not created on read, see SetStrictMode()
+	clock   session.Clock          //session times and expiration, see SetClock()
+
+	keyRing atomic.Pointer[session.KeyRing] //payload encryption, nil if not used
+}
+
+func (pder *Provider) NewSessionStore(sid string) *SessionStore {
+	store := &SessionStore{
+		pder:        pder,
+		sid:         sid,
+		timeCreated: pder.clock.Now(),
+		value:       make(map[string]interface{}, 0),
+	}
+	store.timeAccessed.Store(store.timeCreated.UnixNano())
+	return store
+}
+
+// SessionInit initializes session with given ID.
+func (pder *Provider) SessionInit(sid string) (session.Session, error) {
+	if pder.db == nil {
+		return nil, session.ErrProviderNotInitialized
+	}
+
+	if len(sid) > SESS_ID_LEN {
+		return nil, fmt.Errorf("%w: length exceeds %d", session.ErrInvalidSessionID, SESS_ID_LEN)
+	}
+
+	store := pder.NewSessionStore(sid)
+	if err := pder.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(BUCKET_VALS)
+		if bucket.Get([]byte(sid)) != nil {
+			return nil
+		}
+		return putRecord(bucket, sid, &dbRecord{AccessedTime: store.TimeAccessed(), CreateTime: store.timeCreated})
+	}); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// SessionRead reads session data from db to memory.
+// Expired session is destroyed, session.ErrSessionExpired is returned then.
+func (pder *Provider) SessionRead(sid string) (session.Session, error) {
+	if pder.db == nil {
+		return nil, session.ErrProviderNotInitialized
+	}
+
+	var rec *dbRecord
+	expired := false
+	if err := pder.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(BUCKET_VALS)
+		var err error
+		if rec, err = getRecord(bucket, sid); err != nil || rec == nil {
+			return err
+		}
+		now := pder.clock.Now()
+		if session.IsExpiredAt(now, rec.CreateTime, rec.AccessedTime, rec.ExpiresAt, pder.maxLifeTime, pder.maxIdleTime) {
+			expired = true
+			return bucket.Delete([]byte(sid))
+		}
+		if !pder.expMode.TouchOnRead() {
+			return nil
+		}
+		rec.AccessedTime = now
+		return putRecord(bucket, sid, rec)
+	}); err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, session.ErrSessionExpired
+	}
+	if rec == nil {
+		//no such session
+		if pder.strict {
+			return nil, session.ErrSessionNotFound
+		}
+		return pder.SessionInit(sid)
+	}
+
+	store := pder.NewSessionStore(sid)
+	store.timeAccessed.Store(rec.AccessedTime.UnixNano())
+	store.timeCreated = rec.CreateTime
+	if err := pder.setFromDb(&store.value, rec.Val); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (pder *Provider) SessionClose(sid string) error {
+	return nil
+}
+
+// SessionDestroy destoys session by its ID.
+func (pder *Provider) SessionDestroy(sid string) error {
+	return pder.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(BUCKET_VALS).Delete([]byte(sid))
+	})
+}
+
+// SessionGC clears unused sessions.
+// Sessions with expiration time set by SessionStore.SetExpiry() are removed after that time only.
+func (pder *Provider) SessionGC(l io.Writer, logLev session.LogLevel) session.GCReport {
+	report, _ := pder.sessionGC(pder.getLogger(l, logLev))
+	return report
+}
+
+// CollectGarbage implements session.ProviderV2, record decoding and database errors are returned joined.
+func (pder *Provider) CollectGarbage() (int, error) {
+	report, err := pder.sessionGC(pder.getLogger(nil, session.LOG_LEVEL_ERROR))
+	return report.Deleted(), err
+}
+
+// sessionGC clears unused sessions, errors are logged and returned joined.
+func (pder *Provider) sessionGC(log *slog.Logger) (session.GCReport, error) {
+	log = log.With(session.LOG_KEY_OPERATION, "SessionGC")
+	start := time.Now()
+	var expired [][]byte
+	var report session.GCReport
+	var errs []error
+	if err := pder.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(BUCKET_VALS)
+		now := pder.clock.Now()
+		expired = make([][]byte, 0)
+		report = session.GCReport{}
+		errs = nil
+		if err := bucket.ForEach(func(k, v []byte) error {
+			if pder.gcLimit > 0 && len(expired) >= pder.gcLimit {
+				return errGCLimit
+			}
+			report.Scanned++
+			rec, err := decodeRecord(v)
+			if err != nil {
+				report.Errors++
+				errs = append(errs, err)
+				log.Error(LOG_PREF+"decodeRecord() failed", session.LOG_KEY_SID, string(k), session.LOG_KEY_ERROR, err)
+				return nil
+			}
+			if !rec.ExpiresAt.IsZero() {
+				if !rec.ExpiresAt.After(now) {
+					report.DeletedExpiry++
+					expired = append(expired, append([]byte{}, k...))
+				}
+			} else if pder.maxIdleTime > 0 && !rec.AccessedTime.Add(time.Duration(pder.maxIdleTime)*time.Second).After(now) {
+				report.DeletedIdle++
+				expired = append(expired, append([]byte{}, k...))
+			} else if pder.maxLifeTime > 0 && !rec.CreateTime.Add(time.Duration(pder.maxLifeTime)*time.Second).After(now) {
+				report.DeletedLifetime++
+				expired = append(expired, append([]byte{}, k...))
+			}
+			return nil
+		}); err != nil && err != errGCLimit {
+			return err
+		}
+		for _, k := range expired {
+			log.Debug(LOG_PREF+"SessionGC(): deleting session", session.LOG_KEY_SID, string(k))
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		log.Error(LOG_PREF+"db.Update() failed", session.LOG_KEY_ERROR, err)
+		return session.GCReport{Scanned: report.Scanned, Errors: report.Errors + 1}, errors.Join(append(errs, err)...)
+	}
+	for _, k := range expired {
+		pder.sessionExpired(string(k))
+	}
+	log.Debug(fmt.Sprintf(LOG_PREF+"SessionGC() done, %d sessions deleted", len(expired)), session.LOG_KEY_DURATION, time.Since(start))
+	return report, errors.Join(errs...)
+}
+
+// errGCLimit stops GC scan when GC limit is reached.
+var errGCLimit = errors.New("gc limit reached")
+
+// SetGCMaxDeletions implements session.GCLimiter.
+func (pder *Provider) SetGCMaxDeletions(maxDeletions int) {
+	pder.gcLimit = maxDeletions
+}
+
+// SetExpirationMode implements session.ExpirationModeSetter.
+func (pder *Provider) SetExpirationMode(mode session.ExpirationMode) {
+	pder.expMode = mode
+}
+
+// SetClock implements session.ClockSetter, session times and expiration are of the clock.
+// Should be set before sessions are used.
+func (pder *Provider) SetClock(clock session.Clock) {
+	if clock == nil {
+		clock = session.SystemClock{}
+	}
+	pder.clock = clock
+}
+
+// SetStrictMode implements session.StrictModeSetter: SessionRead() returns session.ErrSessionNotFound
+// for an unknown session instead of creating it.
+func (pder *Provider) SetStrictMode(strict bool) error {
+	pder.strict = strict
+	return nil
+}
+
+// touchRecord updates record access time on session write if expiration mode allows.
+func (pder *Provider) touchRecord(rec *dbRecord) {
+	if pder.expMode.TouchOnWrite() {
+		rec.AccessedTime = pder.clock.Now()
+	}
+}
+
+// SessionCount returns number of stored sessions.
+func (pder *Provider) SessionCount() (int, error) {
+	cnt := 0
+	if err := pder.db.View(func(tx *bolt.Tx) error {
+		cnt = tx.Bucket(BUCKET_VALS).Stats().KeyN
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return cnt, nil
+}
+
+// SessionList returns session metadata ordered by session ID.
+func (pder *Provider) SessionList(offset, limit int) ([]session.SessionMeta, error) {
+	list := make([]session.SessionMeta, 0)
+	if err := pder.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(BUCKET_VALS).Cursor()
+		ind := 0
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if ind < offset {
+				ind++
+				continue
+			}
+			if limit > 0 && len(list) >= limit {
+				break
+			}
+			rec, err := decodeRecord(v)
+			if err != nil {
+				return err
+			}
+			list = append(list, session.SessionMeta{ID: string(k), TimeCreated: rec.CreateTime, TimeAccessed: rec.AccessedTime})
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// SessionMeta implements session.MetaProvider, session values are not decoded.
+func (pder *Provider) SessionMeta(sid string) (session.SessionMeta, error) {
+	var rec *dbRecord
+	if err := pder.db.View(func(tx *bolt.Tx) error {
+		var err error
+		rec, err = getRecord(tx.Bucket(BUCKET_VALS), sid)
+		return err
+	}); err != nil {
+		return session.SessionMeta{}, err
+	}
+	if rec == nil {
+		return session.SessionMeta{}, session.ErrSessionNotFound
+	}
+	return session.SessionMeta{ID: sid,
+		TimeCreated:  rec.CreateTime,
+		TimeAccessed: rec.AccessedTime,
+		ExpiresAt:    rec.ExpiresAt,
+		Size:         len(rec.Val),
+		Provider:     PROVIDER,
+	}, nil
+}
+
+// SessionPeek implements session.PeekProvider: session values are read
+// without updating access time, missing session is not created, expired one is not removed.
+func (pder *Provider) SessionPeek(sid string) (session.Session, error) {
+	if pder.db == nil {
+		return nil, session.ErrProviderNotInitialized
+	}
+	var rec *dbRecord
+	if err := pder.db.View(func(tx *bolt.Tx) error {
+		var err error
+		rec, err = getRecord(tx.Bucket(BUCKET_VALS), sid)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, session.ErrSessionNotFound
+	}
+	if session.IsExpiredAt(pder.clock.Now(), rec.CreateTime, rec.AccessedTime, rec.ExpiresAt, pder.maxLifeTime, pder.maxIdleTime) {
+		return nil, session.ErrSessionExpired
+	}
+	store := pder.NewSessionStore(sid)
+	store.timeAccessed.Store(rec.AccessedTime.UnixNano())
+	store.timeCreated = rec.CreateTime
+	if err := pder.setFromDb(&store.value, rec.Val); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// SessionDestroyMany destroys sessions in one transaction.
+func (pder *Provider) SessionDestroyMany(sids []string) error {
+	return pder.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(BUCKET_VALS)
+		for _, sid := range sids {
+			if err := bucket.Delete([]byte(sid)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (pder *Provider) DestroyAllSessions(l io.Writer, logLev session.LogLevel) {
+	log := pder.getLogger(l, logLev).With(session.LOG_KEY_OPERATION, "DestroyAllSessions")
+	start := time.Now()
+	cnt, err := pder.DestroyAll()
+	if err != nil {
+		log.Error(LOG_PREF+"db.Update() failed", session.LOG_KEY_ERROR, err)
+		return
+	}
+	log.Debug(LOG_PREF+"DestroyAllSessions() done", session.LOG_KEY_COUNT, cnt, session.LOG_KEY_DURATION, time.Since(start))
+}
+
+// DestroyAll implements session.ProviderV2, returns number of destroyed sessions.
+func (pder *Provider) DestroyAll() (int, error) {
+	cnt := 0
+	if err := pder.db.Update(func(tx *bolt.Tx) error {
+		cnt = tx.Bucket(BUCKET_VALS).Stats().KeyN
+		if err := tx.DeleteBucket(BUCKET_VALS); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(BUCKET_VALS)
+		return err
+	}); err != nil {
+		return 0, err
+	}
+	return cnt, nil
+}
+
+// SetExpiredHook sets callback for sessions removed by SessionGC.
+func (pder *Provider) SetExpiredHook(hook session.SessionHook) {
+	pder.expiredHook = hook
+}
+
+// sessionExpired calls expired hook if it is set.
+func (pder *Provider) sessionExpired(sid string) {
+	if pder.expiredHook != nil {
+		pder.expiredHook(sid)
+	}
+}
+
+// SetLogger sets structured logger.
+func (pder *Provider) SetLogger(logger *slog.Logger) {
+	pder.logger = logger
+}
+
+// getLogger returns provider logger or io.Writer adapter if logger is not set.
+func (pder *Provider) getLogger(l io.Writer, logLev session.LogLevel) *slog.Logger {
+	return session.LoggerFor(pder.logger, l, logLev).With(session.LOG_KEY_PROVIDER, PROVIDER)
+}
+
+func (pder *Provider) SetMaxLifeTime(maxLifeTime int64) {
+	pder.maxLifeTime = maxLifeTime
+}
+func (pder *Provider) GetMaxLifeTime() int64 {
+	return pder.maxLifeTime
+}
+
+func (pder *Provider) SetMaxIdleTime(maxIdleTime int64) {
+	pder.maxIdleTime = maxIdleTime
+}
+
+func (pder *Provider) GetMaxIdleTime() int64 {
+	return pder.maxIdleTime
+}
+
+// SetKeyRing sets keys for payload encryption.
+func (pder *Provider) SetKeyRing(keyRing *session.KeyRing) {
+	pder.keyRing.Store(keyRing)
+}
+
+// SetPayloadVersion sets payload version, nil disables versions.
+func (pder *Provider) SetPayloadVersion(payloadVersion *session.PayloadVersion) {
+	pder.payloadVersion = payloadVersion
+}
+
+// InitProvider initializes bolt provider.
+// Function expects parameters:
+//
+//	First parameter: path to a database file.
+//	Second parameter (optional): encryptKey string, if set session payload is encrypted with AES-GCM.
+//
+// This function opens database and creates buckets.
+func (pder *Provider) InitProvider(provParams []interface{}) error {
+	if len(provParams) < 1 {
+		return errors.New("InitProvider missing parameters: path to a database file")
+	}
+	dbFileName, ok := provParams[0].(string)
+	if !ok {
+		return errors.New("InitProvider path to a database file must be a string")
+	}
+
+	if len(provParams) >= 2 {
+		encrkey, ok := provParams[1].(string)
+		if !ok {
+			return errors.New("InitProvider encryptKey parameter(1) must be a string")
+		}
+		if encrkey != "" {
+			key_ring, err := session.NewKeyRing([]byte(encrkey))
+			if err != nil {
+				return err
+			}
+			pder.keyRing.Store(key_ring)
+		}
+	}
+
+	db, err := bolt.Open(dbFileName, 0600, &bolt.Options{Timeout: time.Duration(1) * time.Second})
+	if err != nil {
+		return fmt.Errorf("bolt.Open failed: %v", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{BUCKET_VALS, BUCKET_LOCKS, BUCKET_USERS, BUCKET_USER_IDS} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return err
+	}
+	pder.db = db
+
+	return nil
+}
+
+// CloseProvider closes database.
+func (pder *Provider) CloseProvider() error {
+	if pder.db == nil {
+		return nil
+	}
+	err := pder.db.Close()
+	pder.db = nil
+	return err
+}
+
+// Ping checks that database file is open and readable.
+func (pder *Provider) Ping(ctx context.Context) error {
+	if pder.db == nil {
+		return session.ErrProviderNotInitialized
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return pder.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(BUCKET_VALS) == nil {
+			return errors.New("bucket " + string(BUCKET_VALS) + " not found")
+		}
+		return nil
+	})
+}
+
+func (pder *Provider) GetSessionIDLen() int {
+	return SESS_ID_LEN
+}
+
+// getRecord returns session record by its ID, nil if there is no record.
+func getRecord(bucket *bolt.Bucket, sid string) (*dbRecord, error) {
+	v := bucket.Get([]byte(sid))
+	if v == nil {
+		return nil, nil
+	}
+	return decodeRecord(v)
+}
+
+func decodeRecord(v []byte) (*dbRecord, error) {
+	rec := &dbRecord{}
+	if err := gob.NewDecoder(bytes.NewBuffer(v)).Decode(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// putRecord puts session record to bucket.
+func putRecord(bucket *bolt.Bucket, sid string, rec *dbRecord) error {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(rec); err != nil {
+		return err
+	}
+	return bucket.Put([]byte(sid), b.Bytes())
+}
+
+// genLockToken returns random lock owner token.
+func genLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// setFromDb is a helper function, called on retrieving value from data base.
+// It decrypts and decodes data base value for in-memory store.
+func (pder *Provider) setFromDb(strucVal *storeValue, dbVal []byte) error {
+	if len(dbVal) == 0 {
+		return nil
+	}
+	if key_ring := pder.keyRing.Load(); key_ring != nil {
+		var err error
+		if dbVal, err = key_ring.DecryptPayload(dbVal); err != nil {
+			return err
+		}
+	}
+	if err := pder.payloadVersion.Decode(dbVal, (*map[string]interface{})(strucVal)); err != nil {
+		return err
+	}
+	session.PurgeExpiredValuesAt(pder.clock.Now(), *strucVal) //values set with SetWithTTL() are filtered on read
+	return nil
+}
+
+// getForDb is a helper function called before putting value to database.
+// It encodes and encrypts in-memory session value for data base.
+func (pder *Provider) getForDb(strucVal *storeValue) ([]byte, error) {
+	val, err := pder.payloadVersion.Encode(strucVal)
+	if err != nil {
+		return []byte{}, err
+	}
+	if key_ring := pder.keyRing.Load(); key_ring != nil {
+		return key_ring.Encrypt(val)
+	}
+	return val, nil
+}
+
+func init() {
+	session.Register(PROVIDER, pder)
+}
@@ -0,0 +1,1167 @@
+// testing functions for session/bolt.
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dronm/session" //session manager
+	"github.com/dronm/session/testkit"
+)
+
+const (
+	BOLT_FILENAME = "test.db"
+)
+
+func NewManager(t *testing.T, idleTime int64, lifeTime int64, killTime string) (*session.Manager, error) {
+	return session.NewManager(PROVIDER, idleTime, lifeTime, killTime, BOLT_FILENAME)
+}
+
+func ClearManager(manager *session.Manager) {
+	manager.CloseProvider()
+	os.Remove(BOLT_FILENAME)
+}
+
+// TestTemp opens a new session, puts temporary data to it, reads and compares.
+func TestTemp(t *testing.T) {
+	gob.Register(time.Time{})
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	//start new session
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+
+	sid := currentSession.SessionID()
+	t.Logf("SessionID: %s", sid)
+	var wanted int64 = 177
+	//wanted := time.Now().Truncate(time.Second)
+	//wanted := "time.Now().Truncate(time.Second)"
+	if err := currentSession.Put("dVal", wanted); err != nil {
+		t.Fatalf("Put() for string value failed: %v", err)
+	}
+	//int_v := currentSession.GetInt("dVal")
+	//var got time.Time
+	//var got string
+	var got int64 = 0
+	if err := currentSession.Get("dVal", &got); err != nil {
+		t.Fatalf("Put() for string value failed: %v", err)
+	}
+	if got != wanted {
+		t.Fatalf("Wanted %v, got: %v", wanted, got)
+	}
+}
+
+func TestSession(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	//start new session
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+
+	sid := currentSession.SessionID()
+	t.Logf("SessionID: %s", sid)
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+
+	//test reading
+	testkit.CompareValues(t, currentSession, tests)
+
+	t.Logf("Closing session: %s", sid)
+	if err := SessManager.SessionClose(sid); err != nil {
+		t.Fatalf("SessionClose() failed: %v", err)
+	}
+
+	t.Logf("Reopening session: %s", sid)
+	//reopen
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Errorf("SessionStart() failed: %v", err)
+	}
+	//test reading
+	testkit.CompareValues(t, currentSession, tests)
+
+	if err := SessManager.SessionClose(currentSession.SessionID()); err != nil {
+		t.Errorf("SessionClose() failed: %v", err)
+	}
+
+	//destroying session
+	t.Logf("Destroying session: %s", sid)
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Errorf("SessManager.SessionDestroy() failed: %v", err)
+	}
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Errorf("SessionStart() failed: %v", err)
+	}
+	t.Logf("Trying to read from session")
+	testkit.AssertNoValues(t, currentSession, tests)
+	t.Logf("Session destroyed to read from session")
+}
+
+// TestEncryption puts values to an encrypted session, rotates keys and reads values back.
+// Reading with an unknown key must fail.
+func TestEncryption(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	defer SessManager.SetEncryptionKeys()
+
+	if err := SessManager.SetEncryptionKeys([]byte("old key")); err != nil {
+		t.Fatalf("SetEncryptionKeys() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+
+	t.Logf("Rotating keys")
+	if err := SessManager.SetEncryptionKeys([]byte("new key"), []byte("old key")); err != nil {
+		t.Fatalf("SetEncryptionKeys() failed: %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	testkit.CompareValues(t, currentSession, tests)
+
+	t.Logf("Reading with unknown key")
+	if err := SessManager.SetEncryptionKeys([]byte("new key")); err != nil {
+		t.Fatalf("SetEncryptionKeys() failed: %v", err)
+	}
+	if _, err := SessManager.SessionStart(sid); err == nil {
+		t.Fatalf("SessionStart() succeeded with unknown key")
+	}
+}
+
+// TestLock locks a session, starts the same session in a goroutine which must wait for the lock.
+// The waiting session must see the value flushed by the first one.
+func TestLock(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStartLocked("")
+	if err != nil {
+		t.Fatalf("SessionStartLocked() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	done := make(chan int64)
+	go func() {
+		sess, err := SessManager.SessionStartLocked(sid)
+		if err != nil {
+			t.Errorf("SessionStartLocked() failed: %v", err)
+			close(done)
+			return
+		}
+		got := sess.GetInt("counter")
+		if err := sess.Unlock(); err != nil {
+			t.Errorf("Unlock() failed: %v", err)
+		}
+		done <- got
+	}()
+
+	time.Sleep(time.Duration(200) * time.Millisecond)
+	if err := currentSession.Put("counter", int64(1)); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := currentSession.Unlock(); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+
+	if got := <-done; got != 1 {
+		t.Fatalf("Wanted: 1, got %v", got)
+	}
+}
+
+// TestKeys puts values to a session and checks Keys() and Len() results.
+func TestKeys(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+
+	wanted := make([]string, 0, len(tests))
+	for key := range tests {
+		wanted = append(wanted, key)
+	}
+	sort.Strings(wanted)
+
+	got, err := currentSession.Keys()
+	if err != nil {
+		t.Fatalf("Keys() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Fatalf("Wanted: %v, got %v", wanted, got)
+	}
+
+	cnt, err := currentSession.Len()
+	if err != nil {
+		t.Fatalf("Len() failed: %v", err)
+	}
+	if cnt != len(tests) {
+		t.Fatalf("Wanted: %d, got %d", len(tests), cnt)
+	}
+}
+
+// TestClear puts values to a session, clears it and checks that session ID is kept and values are gone.
+func TestClear(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+
+	if err := currentSession.Clear(); err != nil {
+		t.Fatalf("Clear() failed: %v", err)
+	}
+	if err := currentSession.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if currentSession.SessionID() != sid {
+		t.Fatalf("Wanted: %s, got %s", sid, currentSession.SessionID())
+	}
+	testkit.AssertNoValues(t, currentSession, tests)
+}
+
+// TestIncrement increments a counter from several goroutines with separate session instances.
+// No increment may be lost.
+func TestIncrement(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	const workers, incs = 5, 10
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sess, err := SessManager.SessionStart(sid)
+			if err != nil {
+				t.Errorf("SessionStart() failed: %v", err)
+				return
+			}
+			for j := 0; j < incs; j++ {
+				if _, err := sess.Increment("counter", 2); err != nil {
+					t.Errorf("Increment() failed: %v", err)
+				}
+				if _, err := sess.Decrement("counter", 1); err != nil {
+					t.Errorf("Decrement() failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := currentSession.Increment("counter", 0)
+	if err != nil {
+		t.Fatalf("Increment() failed: %v", err)
+	}
+	if got != workers*incs {
+		t.Fatalf("Wanted: %d, got %d", workers*incs, got)
+	}
+}
+
+// TestLifeTime creates a session with a limited life time.
+// Then waiting for the time more than our life time.
+// After that SessionGC() is called.
+// Then data is retrieved. The session should have been deleted by then.
+// The test fails if any key persists.
+func TestLifeTime(t *testing.T) {
+	var life_time int64 = 2
+	SessManager, err := NewManager(t, life_time, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	sid := currentSession.SessionID()
+	t.Logf("SessionID: %s", sid)
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+	if err := SessManager.SessionClose(currentSession.SessionID()); err != nil {
+		t.Errorf("SessionClose() failed: %v", err)
+	}
+
+	t.Logf("waiting %d seconds for session to be killed", life_time+2)
+	time.Sleep(time.Duration(life_time) * time.Second)
+
+	SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG)
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Errorf("SessionStart() failed: %v", err)
+	}
+	t.Logf("Trying to read from session")
+	testkit.AssertNoValues(t, currentSession, tests)
+	t.Logf("The session %s is destroyed", sid)
+}
+
+// TestIdleTime creates a session with a limited idle time.
+// Some values are put to session store, then retrieved, asserted they exist.
+// Then session data is not touched more then idle time.
+// After that SessionGC() is called.
+// Then data is retrieved. The session should have been deleted by then.
+// The test fails if any key persists.
+func TestIdleTime(t *testing.T) {
+	var idle_time int64 = 3
+	SessManager, err := NewManager(t, 0, idle_time, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	sid := currentSession.SessionID()
+	t.Logf("SessionID: %s", sid)
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+	if err := SessManager.SessionClose(currentSession.SessionID()); err != nil {
+		t.Errorf("SessionClose() failed: %v", err)
+	}
+
+	t.Logf("waiting %d seconds", idle_time/2)
+	time.Sleep(time.Duration(idle_time/2) * time.Second)
+	SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG)
+	//test reading
+	testkit.CompareValues(t, currentSession, tests)
+
+	t.Logf("waiting %d seconds for session to be killed", idle_time+2)
+	time.Sleep(time.Duration(idle_time) * time.Second)
+
+	SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG)
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Errorf("SessionStart() failed: %v", err)
+	}
+	t.Logf("Trying to read from session")
+	testkit.AssertNoValues(t, currentSession, tests)
+	t.Logf("The session %s is destroyed", sid)
+}
+
+// TestHooks checks that manager hooks are called on session creation, value setting,
+// destruction and expiration.
+func TestHooks(t *testing.T) {
+	var idle_time int64 = 1
+	SessManager, err := NewManager(t, 0, idle_time, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	events := make([]string, 0)
+	SessManager.OnSessionCreated(func(sid string) {
+		events = append(events, "created:"+sid)
+	})
+	SessManager.OnValueSet(func(sid string, key string, value interface{}) {
+		events = append(events, "set:"+sid+":"+key)
+	})
+	SessManager.OnSessionDestroyed(func(sid string) {
+		events = append(events, "destroyed:"+sid)
+	})
+	if err := SessManager.OnSessionExpired(func(sid string) {
+		events = append(events, "expired:"+sid)
+	}); err != nil {
+		t.Fatalf("OnSessionExpired() failed: %v", err)
+	}
+
+	sess1, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid1 := sess1.SessionID()
+	if err := sess1.Put("user", "admin"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := SessManager.SessionDestroy(sid1); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+
+	sess2, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid2 := sess2.SessionID()
+	if err := sess2.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	time.Sleep(time.Duration(idle_time+1) * time.Second)
+	SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG)
+
+	want := []string{
+		"created:" + sid1,
+		"set:" + sid1 + ":user",
+		"destroyed:" + sid1,
+		"created:" + sid2,
+		"expired:" + sid2,
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("Wanted: %v, got %v", want, events)
+	}
+}
+
+// TestMetrics checks manager counters.
+func TestMetrics(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	metrics, err := SessManager.EnableMetrics("")
+	if err != nil {
+		t.Fatalf("EnableMetrics() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Put("key", "value"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if _, err := SessManager.SessionStart(sid); err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG)
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+
+	for name, want := range map[string]int64{
+		session.METRIC_SESSIONS_CREATED:   1,
+		session.METRIC_SESSIONS_DESTROYED: 1,
+		session.METRIC_SESSIONS_ACTIVE:    0,
+		session.METRIC_READS:              1,
+		session.METRIC_WRITES:             3, //init, put, destroy
+		session.METRIC_GC_RUNS:            1,
+		session.METRIC_ERRORS:             0,
+	} {
+		if got := metrics.Get(name); got != want {
+			t.Errorf("%s wanted %d, got %d", name, want, got)
+		}
+	}
+}
+
+// TestExpiry checks that GC removes a session after its own expiration time
+// and keeps a session with long expiration regardless of idle time.
+func TestExpiry(t *testing.T) {
+	var idle_time int64 = 1
+	SessManager, err := NewManager(t, 0, idle_time, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	shortSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if err := shortSession.SetExpiry(time.Duration(idle_time+2) * time.Second); err != nil {
+		t.Fatalf("SetExpiry() failed: %v", err)
+	}
+	longSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if err := longSession.SetExpiry(time.Hour); err != nil {
+		t.Fatalf("SetExpiry() failed: %v", err)
+	}
+
+	//idle time passed, expiration is not
+	time.Sleep(time.Duration(idle_time+1) * time.Second)
+	if err := shortSession.Touch(); err != nil {
+		t.Fatalf("Touch() failed: %v", err)
+	}
+	SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG)
+	if cnt, _ := SessManager.Count(); cnt != 2 {
+		t.Fatalf("Count() wanted 2, got %d", cnt)
+	}
+
+	time.Sleep(time.Duration(idle_time+1) * time.Second)
+	SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG)
+	list, err := SessManager.ListSessions(0, 0)
+	if err != nil {
+		t.Fatalf("ListSessions() failed: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != longSession.SessionID() {
+		t.Fatalf("ListSessions() wanted only %s, got %v", longSession.SessionID(), list)
+	}
+}
+
+// TestExpiredOnRead checks that an idle session not removed by GC
+// is destroyed on read with ErrSessionExpired.
+func TestExpiredOnRead(t *testing.T) {
+	var idle_time int64 = 1
+	SessManager, err := NewManager(t, 0, idle_time, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Put("key", "value"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	time.Sleep(time.Duration(idle_time+1) * time.Second)
+	if _, err := SessManager.SessionStart(sid); !errors.Is(err, session.ErrSessionExpired) {
+		t.Fatalf("SessionStart() wanted ErrSessionExpired, got %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if n, _ := currentSession.Len(); n != 0 {
+		t.Fatalf("Session: %s is not destroyed", sid)
+	}
+}
+
+// TestSentinelErrors checks that provider errors match session package errors.
+func TestSentinelErrors(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	var s string
+	if err := currentSession.Get("missing", &s); !errors.Is(err, session.ErrKeyNotFound) {
+		t.Fatalf("Get() wanted ErrKeyNotFound, got %v", err)
+	}
+	if err := currentSession.Set("intVal", int64(1)); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := currentSession.Get("intVal", &s); !errors.Is(err, session.ErrTypeMismatch) {
+		t.Fatalf("Get() wanted ErrTypeMismatch, got %v", err)
+	}
+	if err := currentSession.Get("intVal", s); !errors.Is(err, session.ErrValMustBePtr) {
+		t.Fatalf("Get() wanted ErrValMustBePtr, got %v", err)
+	}
+	if _, err := SessManager.SessionStart(strings.Repeat("a", SESS_ID_LEN+1)); !errors.Is(err, session.ErrInvalidSessionID) {
+		t.Fatalf("SessionStart() wanted ErrInvalidSessionID, got %v", err)
+	}
+
+	if err := SessManager.SessionDestroy(currentSession.SessionID()); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+	if err := currentSession.Touch(); !errors.Is(err, session.ErrSessionNotFound) {
+		t.Fatalf("Touch() wanted ErrSessionNotFound, got %v", err)
+	}
+}
+
+// TestTypedGetters checks GetDate(), GetBytes() and GetStringSlice() after values are reloaded from database.
+func TestTypedGetters(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	date := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	currentSession.Set("date", date)
+	currentSession.Set("bytes", []byte{1, 2, 3})
+	currentSession.Set("str", "abc")
+	currentSession.Set("strs", []string{"a", "b"})
+	if err := currentSession.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	SessManager.SessionClose(sid)
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if v := currentSession.GetDate("date"); !v.Equal(date) {
+		t.Errorf("GetDate() wanted %v, got %v", date, v)
+	}
+	if v := currentSession.GetBytes("bytes"); !reflect.DeepEqual(v, []byte{1, 2, 3}) {
+		t.Errorf("GetBytes() wanted [1 2 3], got %v", v)
+	}
+	if v := currentSession.GetBytes("str"); string(v) != "abc" {
+		t.Errorf("GetBytes() wanted abc, got %s", v)
+	}
+	if v := currentSession.GetStringSlice("strs"); !reflect.DeepEqual(v, []string{"a", "b"}) {
+		t.Errorf("GetStringSlice() wanted [a b], got %v", v)
+	}
+	if v := currentSession.GetStringSlice("missing"); v != nil {
+		t.Errorf("GetStringSlice() wanted nil, got %v", v)
+	}
+}
+
+// TestGetOrSet calls GetOrSet() for the same session concurrently,
+// the value must be computed once.
+func TestGetOrSet(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	var computed int64
+	var mx sync.Mutex
+	compute := func() (interface{}, error) {
+		mx.Lock()
+		computed++
+		mx.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		return "token", nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sess, err := SessManager.SessionStart(sid)
+			if err != nil {
+				t.Errorf("SessionStart() failed: %v", err)
+				return
+			}
+			var v string
+			if err := sess.GetOrSet("token", &v, compute); err != nil {
+				t.Errorf("GetOrSet() failed: %v", err)
+				return
+			}
+			if v != "token" {
+				t.Errorf("GetOrSet() wanted token, got %s", v)
+			}
+		}()
+	}
+	wg.Wait()
+	if computed != 1 {
+		t.Fatalf("value computed %d times, wanted 1", computed)
+	}
+
+	compute_err := errors.New("compute failed")
+	var v string
+	if err := currentSession.GetOrSet("other", &v, func() (interface{}, error) { return nil, compute_err }); !errors.Is(err, compute_err) {
+		t.Fatalf("GetOrSet() wanted compute error, got %v", err)
+	}
+}
+
+// TestCompareAndSwap checks that a value is swapped only if it matches,
+// missing value is matched by nil and nil new value deletes it.
+func TestCompareAndSwap(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	if ok, err := currentSession.CompareAndSwap("state", "new", "paid"); err != nil || ok {
+		t.Fatalf("CompareAndSwap() of missing value wanted false, got %v (%v)", ok, err)
+	}
+	if ok, err := currentSession.CompareAndSwap("state", nil, "new"); err != nil || !ok {
+		t.Fatalf("CompareAndSwap() with nil old value wanted true, got %v (%v)", ok, err)
+	}
+	if ok, err := currentSession.CompareAndSwap("state", "paid", "shipped"); err != nil || ok {
+		t.Fatalf("CompareAndSwap() of other value wanted false, got %v (%v)", ok, err)
+	}
+	if ok, err := currentSession.CompareAndSwap("state", "new", "paid"); err != nil || !ok {
+		t.Fatalf("CompareAndSwap() wanted true, got %v (%v)", ok, err)
+	}
+	if v := currentSession.GetString("state"); v != "paid" {
+		t.Fatalf("GetString() wanted paid, got %s", v)
+	}
+	SessManager.SessionClose(sid)
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if v := currentSession.GetString("state"); v != "paid" {
+		t.Fatalf("GetString() after reading wanted paid, got %s", v)
+	}
+	if ok, err := currentSession.CompareAndSwap("state", "paid", nil); err != nil || !ok {
+		t.Fatalf("CompareAndSwap() with nil new value wanted true, got %v (%v)", ok, err)
+	}
+	if n, _ := currentSession.Len(); n != 0 {
+		t.Fatalf("value is not deleted")
+	}
+}
+
+// TestBucket sets the same key in two buckets and clears one of them.
+func TestBucket(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	cart := currentSession.Bucket("cart")
+	auth := currentSession.Bucket("auth")
+	cart.Set("id", "cart_id")
+	cart.Set("items", int64(3))
+	auth.Set("id", "user_id")
+	currentSession.Set("id", "root_id")
+	if err := currentSession.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	SessManager.SessionClose(sid)
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	cart = currentSession.Bucket("cart")
+	auth = currentSession.Bucket("auth")
+	if v := cart.GetString("id"); v != "cart_id" {
+		t.Errorf("cart GetString() wanted cart_id, got %s", v)
+	}
+	if v := auth.GetString("id"); v != "user_id" {
+		t.Errorf("auth GetString() wanted user_id, got %s", v)
+	}
+	if keys, _ := cart.Keys(); !reflect.DeepEqual(keys, []string{"id", "items"}) {
+		t.Errorf("cart Keys() wanted [id items], got %v", keys)
+	}
+	if v := currentSession.GetString("cart:id"); v != "cart_id" {
+		t.Errorf("GetString() wanted cart_id, got %s", v)
+	}
+
+	if err := cart.Clear(); err != nil {
+		t.Fatalf("Clear() failed: %v", err)
+	}
+	if n, _ := cart.Len(); n != 0 {
+		t.Errorf("cart Len() wanted 0, got %d", n)
+	}
+	if n, _ := currentSession.Len(); n != 2 {
+		t.Errorf("Len() wanted 2, got %d", n)
+	}
+	if v := currentSession.Bucket("auth").Bucket("oauth").GetString("id"); v != "" {
+		t.Errorf("nested bucket GetString() wanted empty string, got %s", v)
+	}
+	currentSession.Bucket("auth").Bucket("oauth").Set("id", "oauth_id")
+	if v := currentSession.GetString("auth:oauth:id"); v != "oauth_id" {
+		t.Errorf("GetString() wanted oauth_id, got %s", v)
+	}
+}
+
+// TestMaxSessionSize checks that a too large value is rejected
+// and that the oldest keys are evicted if eviction is enabled.
+func TestMaxSessionSize(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	value := strings.Repeat("a", 100)
+	SessManager.SetMaxSessionSize(250, false)
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Put("k1", value); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := currentSession.Put("k2", value); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := currentSession.Put("k3", value); !errors.Is(err, session.ErrSessionTooLarge) {
+		t.Fatalf("Put() wanted ErrSessionTooLarge, got %v", err)
+	}
+	SessManager.SessionClose(sid)
+
+	SessManager.SetMaxSessionSize(250, true)
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if err := currentSession.Put("k3", value); err != nil {
+		t.Fatalf("Put() with eviction failed: %v", err)
+	}
+	if err := currentSession.Put("k1", value); err != nil {
+		t.Fatalf("Put() with eviction failed: %v", err)
+	}
+	SessManager.SessionClose(sid)
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	//k1 is evicted first, then k2 as the oldest one
+	if keys, _ := currentSession.Keys(); !reflect.DeepEqual(keys, []string{"k1", "k3"}) {
+		t.Fatalf("Keys() wanted [k1 k3], got %v", keys)
+	}
+	if err := currentSession.Put("big", strings.Repeat("a", 300)); !errors.Is(err, session.ErrSessionTooLarge) {
+		t.Fatalf("Put() wanted ErrSessionTooLarge, got %v", err)
+	}
+	if n, _ := currentSession.Len(); n != 2 {
+		t.Fatalf("Len() wanted 2, got %d", n)
+	}
+	SessManager.SetMaxSessionSize(0, false)
+}
+
+// TestExportImport exports sessions, destroys them and imports the dump back.
+func TestExportImport(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	tests := testkit.NewTestValues()
+	sids := make([]string, 3)
+	for i := range sids {
+		currentSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		testkit.PutValues(t, currentSession, tests)
+		sids[i] = currentSession.SessionID()
+	}
+
+	var dump bytes.Buffer
+	if n, err := SessManager.ExportSessions(&dump); err != nil || n != len(sids) {
+		t.Fatalf("ExportSessions() wanted %d sessions, got %d, %v", len(sids), n, err)
+	}
+	for _, sid := range sids {
+		if err := SessManager.SessionDestroy(sid); err != nil {
+			t.Fatalf("SessionDestroy() failed: %v", err)
+		}
+	}
+	if n, err := SessManager.ImportSessions(&dump); err != nil || n != len(sids) {
+		t.Fatalf("ImportSessions() wanted %d sessions, got %d, %v", len(sids), n, err)
+	}
+	for _, sid := range sids {
+		currentSession, err := SessManager.SessionStart(sid)
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		testkit.CompareValues(t, currentSession, tests)
+	}
+
+	if _, err := SessManager.ImportSessions(strings.NewReader("not a dump")); err == nil {
+		t.Fatalf("ImportSessions() of invalid dump succeeded")
+	}
+}
+
+// TestFingerprint uses a session by clients with different User-Agent headers.
+func TestFingerprint(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	SessManager.SetFingerprint(session.FINGERPRINT_REJECT, nil)
+
+	owner := httptest.NewRequest(http.MethodGet, "/", nil)
+	owner.Header.Set("User-Agent", "owner")
+	thief := httptest.NewRequest(http.MethodGet, "/", nil)
+	thief.Header.Set("User-Agent", "thief")
+
+	currentSession, err := SessManager.SessionStartHTTP(owner, "")
+	if err != nil {
+		t.Fatalf("SessionStartHTTP() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if _, err := SessManager.SessionStartHTTP(owner, sid); err != nil {
+		t.Fatalf("SessionStartHTTP() of owner failed: %v", err)
+	}
+	if _, err := SessManager.SessionStartHTTP(thief, sid); !errors.Is(err, session.ErrFingerprintMismatch) {
+		t.Fatalf("SessionStartHTTP() of other client wanted ErrFingerprintMismatch, got %v", err)
+	}
+
+	SessManager.SetFingerprint(session.FINGERPRINT_LOG, nil)
+	if _, err := SessManager.SessionStartHTTP(thief, sid); err != nil {
+		t.Fatalf("SessionStartHTTP() in log mode failed: %v", err)
+	}
+}
+
+// TestConformance runs testkit conformance suite.
+func TestConformance(t *testing.T) {
+	testkit.Run(t, func(t *testing.T, maxLifeTime, maxIdleTime int64) *session.Manager {
+		t.Cleanup(func() { os.Remove(BOLT_FILENAME) })
+		manager, err := NewManager(t, maxLifeTime, maxIdleTime, "")
+		if err != nil {
+			t.Fatalf("NewManager() failed: %v", err)
+		}
+		return manager
+	})
+}
+
+// autoRegistered is registered on Set(), notRegistered is never registered.
+type autoRegistered struct{ N int }
+type notRegistered struct{ N int }
+
+// TestRegisterType checks automatic type registration and errors of unregistered types.
+func TestRegisterType(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Put("auto", autoRegistered{N: 1}); err != nil {
+		t.Fatalf("Put() of automatically registered type failed: %v", err)
+	}
+	SessManager.SessionClose(sid)
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	var got autoRegistered
+	if err := currentSession.Get("auto", &got); err != nil || got.N != 1 {
+		t.Fatalf("Get() wanted {1}, got %v, error: %v", got, err)
+	}
+
+	SessManager.SetAutoRegisterTypes(false)
+	currentSession, err = SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	err = currentSession.Put("manual", notRegistered{N: 1})
+	if !errors.Is(err, session.ErrTypeNotRegistered) {
+		t.Fatalf("Put() wanted ErrTypeNotRegistered, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "bolt.notRegistered") {
+		t.Fatalf("error does not name unregistered type: %v", err)
+	}
+}
+
+// TestTrash destroys sessions in trash mode and restores them.
+func TestTrash(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	window := 200 * time.Millisecond
+	SessManager.SetTrash(window, nil)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Put("user", "admin"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	SessManager.SessionClose(sid)
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+	if cnt, err := SessManager.Count(); err != nil || cnt != 0 {
+		t.Fatalf("Count() after SessionDestroy() wanted 0, got %d, error: %v", cnt, err)
+	}
+
+	if err := SessManager.RestoreSession(sid); err != nil {
+		t.Fatalf("RestoreSession() failed: %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if v := currentSession.GetString("user"); v != "admin" {
+		t.Fatalf("restored value wanted admin, got %q", v)
+	}
+	SessManager.SessionClose(sid)
+	if err := SessManager.RestoreSession(sid); !errors.Is(err, session.ErrSessionNotFound) {
+		t.Fatalf("second RestoreSession() wanted ErrSessionNotFound, got %v", err)
+	}
+
+	SessManager.DestroyAllSessions(os.Stderr, session.LOG_LEVEL_ERROR)
+	time.Sleep(window * 2)
+	if err := SessManager.RestoreSession(sid); !errors.Is(err, session.ErrSessionNotFound) {
+		t.Fatalf("RestoreSession() after window wanted ErrSessionNotFound, got %v", err)
+	}
+}
+
+// TestBindUser binds sessions to a user with limited number of sessions.
+func TestBindUser(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	SessManager.SetMaxUserSessions(2, session.USER_LIMIT_EVICT)
+	sids := make([]string, 3)
+	for i := range sids {
+		currentSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		sids[i] = currentSession.SessionID()
+		if err := SessManager.BindUser(currentSession, "user1"); err != nil {
+			t.Fatalf("BindUser() failed: %v", err)
+		}
+		if v := currentSession.GetString(session.USER_KEY); v != "user1" {
+			t.Fatalf("USER_KEY value wanted user1, got %q", v)
+		}
+		SessManager.SessionClose(sids[i])
+	}
+	if _, err := SessManager.SessionMeta(sids[0]); !errors.Is(err, session.ErrSessionNotFound) {
+		t.Fatalf("the oldest session wanted evicted, SessionMeta() error: %v", err)
+	}
+	for _, sid := range sids[1:] {
+		if _, err := SessManager.SessionMeta(sid); err != nil {
+			t.Fatalf("SessionMeta() of a bound session failed: %v", err)
+		}
+	}
+
+	SessManager.SetMaxUserSessions(2, session.USER_LIMIT_REJECT)
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if err := SessManager.BindUser(currentSession, "user1"); !errors.Is(err, session.ErrTooManySessions) {
+		t.Fatalf("BindUser() wanted ErrTooManySessions, got %v", err)
+	}
+	if err := SessManager.BindUser(currentSession, "user2"); err != nil {
+		t.Fatalf("BindUser() of another user failed: %v", err)
+	}
+	SessManager.SessionClose(currentSession.SessionID())
+
+	//a destroyed session frees a slot
+	if err := SessManager.SessionDestroy(sids[1]); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+	if err := SessManager.BindUser(currentSession, "user1"); err != nil {
+		t.Fatalf("BindUser() after SessionDestroy() failed: %v", err)
+	}
+}
+
+// TestStats checks session statistics computed of session metadata.
+func TestStats(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	for i := 1; i <= 3; i++ {
+		sess, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		if err := sess.Set("value", strings.Repeat("x", i*100)); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+		if err := sess.Flush(); err != nil {
+			t.Fatalf("Flush() failed: %v", err)
+		}
+		SessManager.SessionClose(sess.SessionID())
+	}
+
+	stats, err := SessManager.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("Stats() failed: %v", err)
+	}
+	if stats.Count != 3 || stats.Sampled != 3 {
+		t.Fatalf("Stats() = %+v, wanted 3 sessions", stats)
+	}
+	if stats.Size.Min <= 0 || stats.Size.Max <= stats.Size.Min || stats.TotalSize < stats.Size.Min+stats.Size.Max {
+		t.Fatalf("Stats() Size = %+v, TotalSize = %d, wanted distribution of different sizes", stats.Size, stats.TotalSize)
+	}
+	if stats.Age.Max < stats.Age.Min || stats.Idle.Max < stats.Idle.Min {
+		t.Fatalf("Stats() Age = %+v, Idle = %+v", stats.Age, stats.Idle)
+	}
+}
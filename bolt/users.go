@@ -0,0 +1,77 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/dronm/session"
+	bolt "go.etcd.io/bbolt"
+)
+
+// userIndex keeps sessions bound to users in session_users bucket with keys
+// user ID + 0 + session ID ordered by user, so sessions of a user are read with a prefix scan.
+// Bind time is kept as Unix nanoseconds.
+type userIndex struct {
+	pder *Provider
+}
+
+// SessionUserIndex implements session.UserIndexProvider.
+func (pder *Provider) SessionUserIndex() session.UserIndex {
+	return &userIndex{pder: pder}
+}
+
+func userKey(userID, sid string) []byte {
+	return []byte(userID + "\x00" + sid)
+}
+
+func (ix *userIndex) Add(userID, sid string, boundAt time.Time) error {
+	return ix.pder.db.Update(func(tx *bolt.Tx) error {
+		if err := removeUserSession(tx, sid); err != nil {
+			return err
+		}
+		bound_at := make([]byte, 8)
+		binary.BigEndian.PutUint64(bound_at, uint64(boundAt.UnixNano()))
+		if err := tx.Bucket(BUCKET_USERS).Put(userKey(userID, sid), bound_at); err != nil {
+			return err
+		}
+		return tx.Bucket(BUCKET_USER_IDS).Put([]byte(sid), []byte(userID))
+	})
+}
+
+func (ix *userIndex) Remove(sid string) error {
+	return ix.pder.db.Update(func(tx *bolt.Tx) error {
+		return removeUserSession(tx, sid)
+	})
+}
+
+func removeUserSession(tx *bolt.Tx, sid string) error {
+	ids := tx.Bucket(BUCKET_USER_IDS)
+	userID := ids.Get([]byte(sid))
+	if userID == nil {
+		return nil
+	}
+	if err := tx.Bucket(BUCKET_USERS).Delete(userKey(string(userID), sid)); err != nil {
+		return err
+	}
+	return ids.Delete([]byte(sid))
+}
+
+func (ix *userIndex) Sessions(userID string) ([]session.UserSession, error) {
+	list := make([]session.UserSession, 0)
+	prefix := []byte(userID + "\x00")
+	if err := ix.pder.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(BUCKET_USERS).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			list = append(list, session.UserSession{
+				ID:      string(k[len(prefix):]),
+				BoundAt: time.Unix(0, int64(binary.BigEndian.Uint64(v))),
+			})
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	session.SortUserSessions(list)
+	return list, nil
+}
@@ -0,0 +1,405 @@
+package session
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// BreakerState is a state of the circuit breaker, see SetCircuitBreaker().
+type BreakerState int
+
+const (
+	BREAKER_CLOSED    BreakerState = iota //provider is used
+	BREAKER_OPEN                          //provider is not used, operations fail fast
+	BREAKER_HALF_OPEN                     //one probe operation is let through after cooldown
+)
+
+func (st BreakerState) String() string {
+	switch st {
+	case BREAKER_OPEN:
+		return "open"
+	case BREAKER_HALF_OPEN:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker counts consecutive provider failures.
+type circuitBreaker struct {
+	mx        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     BreakerState
+	openedAt  time.Time
+	probing   bool                      //half-open probe is in progress
+	degraded  bool                      //in-memory sessions are used while open
+	memory    map[string]*memorySession //degraded sessions by ID, dropped on close
+	logger    *slog.Logger
+	isFailure BreakerClassifier //counts errors as failures, see SetBreakerClassifier()
+	clock     Clock             //cooldown and degraded session times, see Manager.SetClock()
+}
+
+// BreakerClassifier reports if err of a provider operation is a failure of provider backend
+// counted by the circuit breaker, see SetBreakerClassifier().
+type BreakerClassifier func(err error) bool
+
+// SetCircuitBreaker enables circuit breaker protecting provider backend, e.g. redis, from request pileups:
+// after threshold consecutive failed provider operations the breaker opens and operations
+// fail fast with ErrBackendUnavailable. Operations reaching the backend are counted: provider reads,
+// Flush(), Lock(), Unlock(), Touch(), SetExpiry() and atomic operations, values read from memory are not. After cooldown one operation is let through,
+// the breaker is closed if it succeeds and opens again otherwise.
+// Errors of the package, e.g. ErrKeyNotFound or ErrSessionExpired, lock wait timeouts, quota
+// and decoding errors are not failures, see IsBackendFailure() and SetBreakerClassifier().
+//
+// If degraded is true SessionStart() returns sessions kept in process memory while the breaker is open
+// instead of failing, they are not persisted and are dropped when the breaker closes.
+// Threshold 0 disables the breaker.
+func (manager *Manager) SetCircuitBreaker(threshold int, cooldown time.Duration, degraded bool) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if threshold <= 0 {
+		manager.breaker = nil
+		return
+	}
+	manager.breaker = &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		degraded:  degraded,
+		logger:    manager.logger,
+		isFailure: manager.breakerFailure,
+		clock:     manager.clock,
+	}
+}
+
+// SetBreakerClassifier sets function deciding which errors of provider operations are counted
+// by the circuit breaker, e.g. to count only network errors of a driver:
+//
+//	SessManager.SetBreakerClassifier(func(err error) bool {
+//		var net_err net.Error
+//		return errors.As(err, &net_err)
+//	})
+//
+// The function is called for non-nil errors only. Nil restores IsBackendFailure().
+func (manager *Manager) SetBreakerClassifier(fn BreakerClassifier) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.breakerFailure = fn
+	if cb := manager.breaker; cb != nil {
+		cb.mx.Lock()
+		cb.isFailure = fn
+		cb.mx.Unlock()
+	}
+}
+
+// BreakerState returns state of the circuit breaker, BREAKER_CLOSED if it is not enabled.
+func (manager *Manager) BreakerState() BreakerState {
+	cb := manager.breaker
+	if cb == nil {
+		return BREAKER_CLOSED
+	}
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+	return cb.state
+}
+
+// allow returns ErrBackendUnavailable if provider must not be used, nil breaker allows all.
+func (cb *circuitBreaker) allow() error {
+	if cb == nil {
+		return nil
+	}
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+	switch cb.state {
+	case BREAKER_OPEN:
+		if cb.clock.Now().Sub(cb.openedAt) < cb.cooldown {
+			return ErrBackendUnavailable
+		}
+		cb.setState(BREAKER_HALF_OPEN)
+		cb.probing = true
+		return nil
+	case BREAKER_HALF_OPEN:
+		if cb.probing {
+			return ErrBackendUnavailable
+		}
+		cb.probing = true
+	}
+	return nil
+}
+
+// failed reports if err is counted as a failure, cb.mx must be locked.
+func (cb *circuitBreaker) failed(err error) bool {
+	if err == nil {
+		return false
+	}
+	if cb.isFailure != nil {
+		return cb.isFailure(err)
+	}
+	return IsBackendFailure(err)
+}
+
+// done records result of an operation let through by allow().
+func (cb *circuitBreaker) done(err error) {
+	if cb == nil {
+		return
+	}
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+	failed := cb.failed(err)
+	if cb.state == BREAKER_HALF_OPEN {
+		cb.probing = false
+		if failed {
+			cb.open()
+		} else {
+			cb.failures = 0
+			cb.memory = nil
+			cb.setState(BREAKER_CLOSED)
+		}
+		return
+	}
+	if !failed {
+		cb.failures = 0
+		return
+	}
+	cb.countFailure()
+}
+
+// countFailure counts a failure and opens the breaker at threshold, cb.mx must be locked.
+func (cb *circuitBreaker) countFailure() {
+	if cb.failures++; cb.failures >= cb.threshold && cb.state == BREAKER_CLOSED {
+		cb.open()
+	}
+}
+
+// call runs fn of an operation reaching provider backend if the breaker allows it and records its result.
+func (cb *circuitBreaker) call(fn func() error) error {
+	if err := cb.allow(); err != nil {
+		return err
+	}
+	err := fn()
+	cb.done(err)
+	return err
+}
+
+// guard runs fn of an operation which may not reach provider backend, e.g. Set() of providers
+// writing values on Flush(). It fails fast while the breaker is not closed and counts failures,
+// success neither resets failures nor closes the breaker, it is never a half-open probe.
+func (cb *circuitBreaker) guard(fn func() error) error {
+	if cb == nil {
+		return fn()
+	}
+	cb.mx.Lock()
+	closed := cb.state == BREAKER_CLOSED
+	cb.mx.Unlock()
+	if !closed {
+		return ErrBackendUnavailable
+	}
+	err := fn()
+	if err != nil {
+		cb.mx.Lock()
+		if cb.failed(err) && cb.state == BREAKER_CLOSED {
+			cb.countFailure()
+		}
+		cb.mx.Unlock()
+	}
+	return err
+}
+
+// open opens the breaker, cb.mx must be locked.
+func (cb *circuitBreaker) open() {
+	cb.openedAt = cb.clock.Now()
+	cb.setState(BREAKER_OPEN)
+}
+
+// setState changes state logging the change, cb.mx must be locked.
+func (cb *circuitBreaker) setState(state BreakerState) {
+	if cb.state == state {
+		return
+	}
+	log := cb.logger
+	if log == nil {
+		log = slog.Default()
+	}
+	if state == BREAKER_OPEN {
+		log.Warn("session: circuit breaker opened", LOG_KEY_COUNT, cb.failures)
+	} else {
+		log.Info("session: circuit breaker " + state.String())
+	}
+	cb.state = state
+}
+
+// memorySession returns degraded session sid, a new one with generated ID if sid is not a degraded session,
+// so a client can not choose ID of a session.
+func (cb *circuitBreaker) memorySession(sid string, genSessionID func() string) Session {
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+	if sess, ok := cb.memory[sid]; ok {
+		return sess
+	}
+	sid = genSessionID()
+	if cb.memory == nil {
+		cb.memory = make(map[string]*memorySession)
+	}
+	sess := newMemorySession(sid, cb.clock)
+	cb.memory[sid] = sess
+	return sess
+}
+
+// forget removes degraded session sid, reports if it was found.
+func (cb *circuitBreaker) forget(sid string) bool {
+	if cb == nil {
+		return false
+	}
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+	if _, ok := cb.memory[sid]; ok {
+		delete(cb.memory, sid)
+		return true
+	}
+	return false
+}
+
+// setClock sets clock of the breaker.
+func (cb *circuitBreaker) setClock(clock Clock) {
+	if cb == nil {
+		return
+	}
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+	cb.clock = clock
+	for _, sess := range cb.memory {
+		sess.setClock(clock)
+	}
+}
+
+// isDegraded reports if sid is a degraded session.
+func (cb *circuitBreaker) isDegraded(sid string) bool {
+	if cb == nil {
+		return false
+	}
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+	_, ok := cb.memory[sid]
+	return ok
+}
+
+// IsBackendFailure is the default BreakerClassifier. It reports if err is a failure of provider backend.
+// Errors of the package describing sessions and values are not failures, nor are lock wait timeouts
+// of sessions locked by other requests, quota errors and values which can not be decoded or decrypted.
+func IsBackendFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, e := range []error{ErrKeyNotFound, ErrSessionNotFound, ErrSessionExpired, ErrTypeMismatch,
+		ErrValMustBePtr, ErrSessionTooLarge, ErrInvalidSessionID, ErrFingerprintMismatch,
+		ErrLockTimeout, ErrTooManySessions, ErrTypeNotRegistered, ErrDecryptFailed, ErrNoSensitiveKeys} {
+		if errors.Is(err, e) {
+			return false
+		}
+	}
+	return true
+}
+
+// breakerSession records results of session operations in circuit breaker,
+// fails them fast while the breaker is open. Reads of values loaded by the provider
+// are served from memory and are not wrapped, writes which may be buffered till Flush()
+// are guarded only, see circuitBreaker.guard().
+type breakerSession struct {
+	Session
+	cb *circuitBreaker
+}
+
+func (s *breakerSession) Set(key string, value interface{}) error {
+	return s.cb.guard(func() error { return s.Session.Set(key, value) })
+}
+
+func (s *breakerSession) Put(key string, value interface{}) error {
+	return s.cb.guard(func() error { return s.Session.Put(key, value) })
+}
+
+func (s *breakerSession) Delete(key string) error {
+	return s.cb.guard(func() error { return s.Session.Delete(key) })
+}
+
+func (s *breakerSession) Clear() error {
+	return s.cb.guard(s.Session.Clear)
+}
+
+func (s *breakerSession) Increment(key string, delta int64) (n int64, err error) {
+	err = s.cb.call(func() (err error) {
+		n, err = s.Session.Increment(key, delta)
+		return err
+	})
+	return n, err
+}
+
+func (s *breakerSession) Decrement(key string, delta int64) (n int64, err error) {
+	err = s.cb.call(func() (err error) {
+		n, err = s.Session.Decrement(key, delta)
+		return err
+	})
+	return n, err
+}
+
+func (s *breakerSession) Flush() error {
+	return s.cb.call(s.Session.Flush)
+}
+
+func (s *breakerSession) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	return s.cb.guard(func() error { return s.Session.GetOrSet(key, dest, compute) })
+}
+
+func (s *breakerSession) CompareAndSwap(key string, oldValue, newValue interface{}) (swapped bool, err error) {
+	err = s.cb.call(func() (err error) {
+		swapped, err = s.Session.CompareAndSwap(key, oldValue, newValue)
+		return err
+	})
+	return swapped, err
+}
+
+func (s *breakerSession) SetMany(values map[string]interface{}) error {
+	return s.cb.guard(func() error { return s.Session.SetMany(values) })
+}
+
+func (s *breakerSession) Restore(snapshot map[string]interface{}) error {
+	return s.cb.guard(func() error { return s.Session.Restore(snapshot) })
+}
+
+func (s *breakerSession) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	return s.cb.guard(func() error { return s.Session.SetWithTTL(key, value, ttl) })
+}
+
+func (s *breakerSession) SetSensitive(key string, value interface{}) error {
+	return s.cb.guard(func() error { return s.Session.SetSensitive(key, value) })
+}
+
+func (s *breakerSession) Lock() error {
+	return s.cb.call(s.Session.Lock)
+}
+
+func (s *breakerSession) Unlock() error {
+	return s.cb.call(s.Session.Unlock)
+}
+
+func (s *breakerSession) SetExpiry(d time.Duration) error {
+	return s.cb.call(func() error { return s.Session.SetExpiry(d) })
+}
+
+func (s *breakerSession) Touch() error {
+	return s.cb.call(s.Session.Touch)
+}
+
+func (s *breakerSession) Allow(action string, limit int, window time.Duration) (ok bool, err error) {
+	err = s.cb.call(func() (err error) {
+		ok, err = s.Session.Allow(action, limit, window)
+		return err
+	})
+	return ok, err
+}
+
+func (s *breakerSession) Bucket(name string) Session {
+	return NewBucket(s, name)
+}
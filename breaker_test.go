@@ -0,0 +1,192 @@
+package session_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dronm/session"
+	"github.com/dronm/session/mock"
+)
+
+// newMockManager returns a manager of a new mock provider.
+func newMockManager(t *testing.T) (*session.Manager, *mock.Provider) {
+	pder := mock.NewProvider()
+	manager, err := session.NewManagerWithProvider(pder, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	return manager, pder
+}
+
+// TestCircuitBreaker checks the breaker opens after consecutive failures,
+// fails fast or returns degraded sessions and closes after a successful probe.
+func TestCircuitBreaker(t *testing.T) {
+	SessManager, testProvider := newMockManager(t)
+	clock := session.NewManualClock(time.Now())
+	SessManager.SetClock(clock)
+	SessManager.SetCircuitBreaker(2, time.Minute, false)
+	errDown := errors.New("storage is down")
+
+	testProvider.FailWith("SessionInit", errDown)
+	for i := 0; i < 2; i++ {
+		if _, err := SessManager.SessionStart(""); !errors.Is(err, errDown) {
+			t.Fatalf("SessionStart() wanted scripted error, got %v", err)
+		}
+	}
+	if st := SessManager.BreakerState(); st != session.BREAKER_OPEN {
+		t.Fatalf("BreakerState() wanted open, got %s", st)
+	}
+	testProvider.ResetCalls()
+	if _, err := SessManager.SessionStart(""); !errors.Is(err, session.ErrBackendUnavailable) {
+		t.Fatalf("SessionStart() wanted ErrBackendUnavailable, got %v", err)
+	}
+	if n := testProvider.CallCount(mock.ALL_METHODS); n != 0 {
+		t.Fatalf("open breaker let %d calls through", n)
+	}
+
+	//failed probe opens the breaker again
+	clock.Advance(time.Minute)
+	if _, err := SessManager.SessionStart(""); !errors.Is(err, errDown) {
+		t.Fatalf("SessionStart() wanted probe error, got %v", err)
+	}
+	if st := SessManager.BreakerState(); st != session.BREAKER_OPEN {
+		t.Fatalf("BreakerState() wanted open after failed probe, got %s", st)
+	}
+
+	//package errors are not failures
+	testProvider.FailWith("SessionInit", nil)
+	clock.Advance(time.Minute)
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if st := SessManager.BreakerState(); st != session.BREAKER_CLOSED {
+		t.Fatalf("BreakerState() wanted closed after probe, got %s", st)
+	}
+	for i := 0; i < 3; i++ {
+		if err := currentSession.Get("missing", new(string)); !errors.Is(err, session.ErrKeyNotFound) {
+			t.Fatalf("Get() wanted ErrKeyNotFound, got %v", err)
+		}
+	}
+	if st := SessManager.BreakerState(); st != session.BREAKER_CLOSED {
+		t.Fatalf("ErrKeyNotFound opened the breaker")
+	}
+}
+
+// TestCircuitBreakerDegraded checks in-memory sessions returned while the breaker is open.
+func TestCircuitBreakerDegraded(t *testing.T) {
+	SessManager, testProvider := newMockManager(t)
+	SessManager.SetCircuitBreaker(1, time.Hour, true)
+	errDown := errors.New("storage is down")
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	testProvider.FailWith(mock.ALL_METHODS, errDown)
+	if err := currentSession.Put("key", "value"); !errors.Is(err, errDown) {
+		t.Fatalf("Put() wanted scripted error, got %v", err)
+	}
+	if err := currentSession.Put("key", "value"); !errors.Is(err, session.ErrBackendUnavailable) {
+		t.Fatalf("Put() wanted ErrBackendUnavailable, got %v", err)
+	}
+
+	degraded, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() degraded failed: %v", err)
+	}
+	sid := degraded.SessionID()
+	if err := degraded.Put("key", "value"); err != nil {
+		t.Fatalf("Put() degraded failed: %v", err)
+	}
+	if err := SessManager.SessionClose(sid); err != nil {
+		t.Fatalf("SessionClose() degraded failed: %v", err)
+	}
+	degraded, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() degraded failed: %v", err)
+	}
+	if v := degraded.GetString("key"); v != "value" {
+		t.Fatalf("degraded session lost value, got %q", v)
+	}
+	if _, ok := testProvider.Stored(sid); ok {
+		t.Fatalf("degraded session %s stored in provider", sid)
+	}
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Fatalf("SessionDestroy() degraded failed: %v", err)
+	}
+
+	//a client can not choose ID of a degraded session
+	const fixed_sid = "7f8a1c52-3b1e-4a7e-9c1d-2f6b5e4d3c2a"
+	if degraded, err = SessManager.SessionStart(fixed_sid); err != nil {
+		t.Fatalf("SessionStart() degraded failed: %v", err)
+	}
+	if degraded.SessionID() == fixed_sid {
+		t.Fatalf("degraded session is started with unknown ID %s", fixed_sid)
+	}
+}
+
+// TestCircuitBreakerClock checks that cooldown elapses with the manager clock.
+func TestCircuitBreakerClock(t *testing.T) {
+	SessManager, testProvider := newMockManager(t)
+	clock := session.NewManualClock(time.Now())
+	SessManager.SetClock(clock)
+	SessManager.SetCircuitBreaker(1, time.Hour, false)
+
+	testProvider.FailTimes("SessionInit", errors.New("storage is down"), 1)
+	if _, err := SessManager.SessionStart(""); err == nil {
+		t.Fatal("SessionStart() wanted scripted error")
+	}
+	if _, err := SessManager.SessionStart(""); !errors.Is(err, session.ErrBackendUnavailable) {
+		t.Fatalf("SessionStart() wanted ErrBackendUnavailable, got %v", err)
+	}
+	clock.Advance(time.Hour)
+	if _, err := SessManager.SessionStart(""); err != nil {
+		t.Fatalf("SessionStart() probe after cooldown failed: %v", err)
+	}
+	if st := SessManager.BreakerState(); st != session.BREAKER_CLOSED {
+		t.Fatalf("BreakerState() wanted closed after probe, got %s", st)
+	}
+}
+
+// TestCircuitBreakerClassifier checks that lock contention does not open the breaker
+// and that a classifier decides which errors are failures.
+func TestCircuitBreakerClassifier(t *testing.T) {
+	SessManager, testProvider := newMockManager(t)
+	SessManager.SetCircuitBreaker(2, time.Minute, false)
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	defer SessManager.SessionClose(currentSession.SessionID())
+
+	testProvider.FailWith("Lock", fmt.Errorf("wait for session lock: %w", session.ErrLockTimeout))
+	for i := 0; i < 3; i++ {
+		if err := currentSession.Lock(); !errors.Is(err, session.ErrLockTimeout) {
+			t.Fatalf("Lock() wanted ErrLockTimeout, got %v", err)
+		}
+	}
+	if st := SessManager.BreakerState(); st != session.BREAKER_CLOSED {
+		t.Fatalf("lock wait timeouts opened the breaker, state %s", st)
+	}
+
+	//only errors reported by the classifier are failures
+	errNetwork := errors.New("connection refused")
+	SessManager.SetBreakerClassifier(func(err error) bool { return errors.Is(err, errNetwork) })
+	testProvider.FailWith("Lock", errors.New("storage is down"))
+	for i := 0; i < 3; i++ {
+		currentSession.Lock()
+	}
+	if st := SessManager.BreakerState(); st != session.BREAKER_CLOSED {
+		t.Fatalf("error not classified as failure opened the breaker, state %s", st)
+	}
+	testProvider.FailWith("Lock", errNetwork)
+	for i := 0; i < 2; i++ {
+		currentSession.Lock()
+	}
+	if st := SessManager.BreakerState(); st != session.BREAKER_OPEN {
+		t.Fatalf("BreakerState() wanted open after classified failures, got %s", st)
+	}
+}
@@ -0,0 +1,193 @@
+package session
+
+import (
+	"strings"
+	"time"
+)
+
+// BUCKET_SEP separates bucket name from value key.
+const BUCKET_SEP = ":"
+
+// bucketSession is a view of a session with keys prefixed by bucket name.
+// Session wide operations (Flush, Lock, SetExpiry, etc.) are passed to the session.
+type bucketSession struct {
+	Session
+	prefix string
+}
+
+// NewBucket returns a view of sess whose keys are prefixed with name and BUCKET_SEP,
+// so modules sharing a session do not collide on keys.
+// Keys(), Len() and Clear() of the view work with its own keys only.
+// Providers implement Session.Bucket() with this function.
+func NewBucket(sess Session, name string) Session {
+	if b, ok := sess.(*bucketSession); ok {
+		return &bucketSession{Session: b.Session, prefix: b.prefix + name + BUCKET_SEP}
+	}
+	return &bucketSession{Session: sess, prefix: name + BUCKET_SEP}
+}
+
+func (b *bucketSession) key(key string) string {
+	return b.prefix + key
+}
+
+func (b *bucketSession) Set(key string, value interface{}) error {
+	return b.Session.Set(b.key(key), value)
+}
+
+func (b *bucketSession) Put(key string, value interface{}) error {
+	return b.Session.Put(b.key(key), value)
+}
+
+func (b *bucketSession) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	return b.Session.SetWithTTL(b.key(key), value, ttl)
+}
+
+func (b *bucketSession) SetSensitive(key string, value interface{}) error {
+	return b.Session.SetSensitive(b.key(key), value)
+}
+
+func (b *bucketSession) Allow(action string, limit int, window time.Duration) (bool, error) {
+	return b.Session.Allow(b.key(action), limit, window)
+}
+
+func (b *bucketSession) Get(key string, value interface{}) error {
+	return b.Session.Get(b.key(key), value)
+}
+
+func (b *bucketSession) SetMany(values map[string]interface{}) error {
+	prefixed := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		prefixed[b.key(key)] = value
+	}
+	return b.Session.SetMany(prefixed)
+}
+
+func (b *bucketSession) GetMany(dest map[string]interface{}) error {
+	prefixed := make(map[string]interface{}, len(dest))
+	for key, val := range dest {
+		prefixed[b.key(key)] = val
+	}
+	if err := b.Session.GetMany(prefixed); err != nil {
+		return err
+	}
+	for key := range dest {
+		if _, ok := prefixed[b.key(key)]; !ok {
+			delete(dest, key)
+		}
+	}
+	return nil
+}
+
+// Snapshot returns a deep copy of bucket values without bucket prefix.
+func (b *bucketSession) Snapshot() (map[string]interface{}, error) {
+	all, err := b.Session.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := make(map[string]interface{})
+	for key, value := range all {
+		if strings.HasPrefix(key, b.prefix) {
+			snapshot[key[len(b.prefix):]] = value
+		}
+	}
+	return snapshot, nil
+}
+
+// Restore replaces bucket values only.
+func (b *bucketSession) Restore(snapshot map[string]interface{}) error {
+	return Restore(b, snapshot)
+}
+
+func (b *bucketSession) GetStruct(key string, dest interface{}) error {
+	return b.Session.GetStruct(b.key(key), dest)
+}
+
+func (b *bucketSession) GetBool(key string) bool {
+	return b.Session.GetBool(b.key(key))
+}
+
+func (b *bucketSession) GetString(key string) string {
+	return b.Session.GetString(b.key(key))
+}
+
+func (b *bucketSession) GetInt(key string) int64 {
+	return b.Session.GetInt(b.key(key))
+}
+
+func (b *bucketSession) GetFloat(key string) float64 {
+	return b.Session.GetFloat(b.key(key))
+}
+
+func (b *bucketSession) GetDate(key string) time.Time {
+	return b.Session.GetDate(b.key(key))
+}
+
+func (b *bucketSession) GetBytes(key string) []byte {
+	return b.Session.GetBytes(b.key(key))
+}
+
+func (b *bucketSession) GetStringSlice(key string) []string {
+	return b.Session.GetStringSlice(b.key(key))
+}
+
+func (b *bucketSession) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	return b.Session.GetOrSet(b.key(key), dest, compute)
+}
+
+func (b *bucketSession) Delete(key string) error {
+	return b.Session.Delete(b.key(key))
+}
+
+// Clear deletes bucket values only.
+func (b *bucketSession) Clear() error {
+	keys, err := b.Keys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := b.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *bucketSession) Increment(key string, delta int64) (int64, error) {
+	return b.Session.Increment(b.key(key), delta)
+}
+
+func (b *bucketSession) Decrement(key string, delta int64) (int64, error) {
+	return b.Session.Decrement(b.key(key), delta)
+}
+
+func (b *bucketSession) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
+	return b.Session.CompareAndSwap(b.key(key), oldValue, newValue)
+}
+
+// Keys returns sorted bucket keys without bucket prefix.
+func (b *bucketSession) Keys() ([]string, error) {
+	all, err := b.Session.Keys()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0)
+	for _, key := range all {
+		if strings.HasPrefix(key, b.prefix) {
+			keys = append(keys, key[len(b.prefix):])
+		}
+	}
+	return keys, nil
+}
+
+// Len returns number of bucket values.
+func (b *bucketSession) Len() (int, error) {
+	keys, err := b.Keys()
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+func (b *bucketSession) Bucket(name string) Session {
+	return NewBucket(b, name)
+}
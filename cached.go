@@ -0,0 +1,398 @@
+package session
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// CachedProvider is a provider decorator keeping recently used sessions in memory.
+// SessionRead() returns a cached session without a round trip to the inner provider,
+// sessions not used for long are evicted when cache is full (LRU).
+// Cached sessions are inner provider sessions, so Set()/Flush() write
+// through to the inner provider storage.
+//
+// Cache is local to the process, use it when sessions are not modified
+// by other processes, e.g. with one application instance or sticky sessions,
+// or with inner provider implementing ChangeNotifier: sessions changed by other
+// processes are evicted then.
+// Inner provider checks session idle/life time and updates its access time on read only,
+// so ttl should be less than max idle time.
+// Cached session is used by all concurrent requests, see SetSessionSharing().
+type CachedProvider struct {
+	Provider
+	maxEntries  int           //max number of cached sessions, 0 means no limit
+	ttl         time.Duration //cached session is read again after ttl, 0 means never
+	mx          sync.Mutex
+	entries     *list.List               //*cacheEntry, most recently used first
+	items       map[string]*list.Element //by session ID
+	expiredHook SessionHook
+	changedHook SessionHook
+	clock       Clock //see SetClock()
+}
+
+// cacheEntry is a cached session.
+type cacheEntry struct {
+	sid     string
+	sess    Session
+	expires time.Time //zero if ttl is not set
+}
+
+// NewCachedProvider returns inner provider with LRU cache of maxEntries sessions,
+// every session is cached for ttl. The result should be registered under
+// its own name with Register(), then used with NewManager():
+//
+//	inner, _ := session.LookupProvider("redis")
+//	session.Register("redis_cached", session.NewCachedProvider(inner, 10000, time.Minute))
+//	SessManager, err := session.NewManager("redis_cached", ...)
+func NewCachedProvider(inner Provider, maxEntries int, ttl time.Duration) *CachedProvider {
+	return &CachedProvider{Provider: inner,
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    list.New(),
+		items:      make(map[string]*list.Element),
+		clock:      SystemClock{},
+	}
+}
+
+// InitProvider initializes inner provider, sessions removed by its GC
+// are evicted from cache if it implements ExpiryNotifier, sessions changed
+// by other processes are evicted if it implements ChangeNotifier.
+func (cpder *CachedProvider) InitProvider(provParams []interface{}) error {
+	cpder.purge()
+	if notifier, ok := cpder.Provider.(ExpiryNotifier); ok {
+		notifier.SetExpiredHook(cpder.sessionExpired)
+	}
+	if notifier, ok := cpder.Provider.(ChangeNotifier); ok {
+		notifier.SetChangedHook(cpder.sessionChanged)
+	}
+	return cpder.Provider.InitProvider(provParams)
+}
+
+// Len returns number of cached sessions.
+func (cpder *CachedProvider) Len() int {
+	cpder.mx.Lock()
+	defer cpder.mx.Unlock()
+	return cpder.entries.Len()
+}
+
+// get returns cached session, nil if not cached or ttl is expired.
+func (cpder *CachedProvider) get(sid string) Session {
+	cpder.mx.Lock()
+	defer cpder.mx.Unlock()
+	el, ok := cpder.items[sid]
+	if !ok {
+		return nil
+	}
+	entry := el.Value.(*cacheEntry)
+	now := cpder.clock.Now()
+	if (!entry.expires.IsZero() && now.After(entry.expires)) ||
+		IsExpiredAt(now, entry.sess.TimeCreated(), entry.sess.TimeAccessed(), time.Time{}, cpder.GetMaxLifeTime(), cpder.GetMaxIdleTime()) {
+		//session expiration is checked by inner provider on read
+		cpder.entries.Remove(el)
+		delete(cpder.items, sid)
+		return nil
+	}
+	cpder.entries.MoveToFront(el)
+	return entry.sess
+}
+
+// put caches session evicting the least recently used one if cache is full.
+func (cpder *CachedProvider) put(sess Session) {
+	entry := &cacheEntry{sid: sess.SessionID(), sess: sess}
+	if cpder.ttl > 0 {
+		entry.expires = cpder.clock.Now().Add(cpder.ttl)
+	}
+
+	cpder.mx.Lock()
+	defer cpder.mx.Unlock()
+	if el, ok := cpder.items[entry.sid]; ok {
+		el.Value = entry
+		cpder.entries.MoveToFront(el)
+		return
+	}
+	cpder.items[entry.sid] = cpder.entries.PushFront(entry)
+	if cpder.maxEntries > 0 && cpder.entries.Len() > cpder.maxEntries {
+		oldest := cpder.entries.Back()
+		cpder.entries.Remove(oldest)
+		delete(cpder.items, oldest.Value.(*cacheEntry).sid)
+	}
+}
+
+// evict removes sessions from cache.
+func (cpder *CachedProvider) evict(sids ...string) {
+	cpder.mx.Lock()
+	defer cpder.mx.Unlock()
+	for _, sid := range sids {
+		if el, ok := cpder.items[sid]; ok {
+			cpder.entries.Remove(el)
+			delete(cpder.items, sid)
+		}
+	}
+}
+
+// purge removes all sessions from cache.
+func (cpder *CachedProvider) purge() {
+	cpder.mx.Lock()
+	defer cpder.mx.Unlock()
+	cpder.entries.Init()
+	cpder.items = make(map[string]*list.Element)
+}
+
+// CloseProvider clears cache and closes inner provider.
+func (cpder *CachedProvider) CloseProvider() error {
+	cpder.purge()
+	return cpder.Provider.CloseProvider()
+}
+
+// Drain flushes cached sessions and drains inner provider if it implements DrainProvider.
+func (cpder *CachedProvider) Drain() error {
+	cpder.mx.Lock()
+	sessions := make([]Session, 0, cpder.entries.Len())
+	for el := cpder.entries.Front(); el != nil; el = el.Next() {
+		sessions = append(sessions, el.Value.(*cacheEntry).sess)
+	}
+	cpder.mx.Unlock()
+	var errs []error
+	for _, sess := range sessions {
+		errs = append(errs, sess.Flush())
+	}
+	if drain_pder, ok := cpder.Provider.(DrainProvider); ok {
+		errs = append(errs, drain_pder.Drain())
+	}
+	return errors.Join(errs...)
+}
+
+// SessionInit creates session with inner provider and caches it.
+func (cpder *CachedProvider) SessionInit(sid string) (Session, error) {
+	sess, err := cpder.Provider.SessionInit(sid)
+	if err != nil {
+		return nil, err
+	}
+	cpder.put(sess)
+	return sess, nil
+}
+
+// SessionRead returns cached session or reads it with inner provider.
+func (cpder *CachedProvider) SessionRead(sid string) (Session, error) {
+	if sess := cpder.get(sid); sess != nil {
+		return sess, nil
+	}
+	sess, err := cpder.Provider.SessionRead(sid)
+	if err != nil {
+		return nil, err
+	}
+	cpder.put(sess)
+	return sess, nil
+}
+
+// SessionDestroy removes session from cache and destroys it with inner provider.
+func (cpder *CachedProvider) SessionDestroy(sid string) error {
+	cpder.evict(sid)
+	return cpder.Provider.SessionDestroy(sid)
+}
+
+// SessionGC runs inner provider GC. Sessions removed by GC are evicted
+// if inner provider implements ExpiryNotifier, otherwise the whole cache is cleared.
+func (cpder *CachedProvider) SessionGC(l io.Writer, logLev LogLevel) GCReport {
+	report := cpder.Provider.SessionGC(l, logLev)
+	if _, ok := cpder.Provider.(ExpiryNotifier); !ok {
+		cpder.purge()
+	}
+	return report
+}
+
+// SetGCMaxDeletions implements GCLimiter, the limit is passed to inner provider.
+func (cpder *CachedProvider) SetGCMaxDeletions(maxDeletions int) {
+	setGCMaxDeletions(maxDeletions, cpder.Provider)
+}
+
+// SetExpirationMode implements ExpirationModeSetter, the mode is passed to inner provider.
+func (cpder *CachedProvider) SetExpirationMode(mode ExpirationMode) {
+	setExpirationMode(mode, cpder.Provider)
+}
+
+// SetStrictMode implements StrictModeSetter, the mode is passed to inner provider.
+// ErrNoStrictMode is returned if inner provider does not support strict mode.
+func (cpder *CachedProvider) SetStrictMode(strict bool) error {
+	return setStrictMode(strict, cpder.Provider)
+}
+
+// SetClock implements ClockSetter, the clock is used for cache ttl and is passed to inner provider.
+// Should be set before sessions are cached.
+func (cpder *CachedProvider) SetClock(clock Clock) {
+	cpder.clock = clockOrSystem(clock)
+	setClock(clock, cpder.Provider)
+}
+
+// SessionMeta implements MetaProvider with inner provider.
+func (cpder *CachedProvider) SessionMeta(sid string) (SessionMeta, error) {
+	return sessionMeta(cpder.Provider, sid)
+}
+
+// SessionPeek implements PeekProvider with inner provider, the cache is not used.
+func (cpder *CachedProvider) SessionPeek(sid string) (Session, error) {
+	return sessionPeek(cpder.Provider, sid)
+}
+
+// AcquireGCLeader implements GCLeaderLocker with inner provider.
+func (cpder *CachedProvider) AcquireGCLeader(name string, ttl time.Duration) (bool, error) {
+	locker, err := gcLeaderLocker(cpder.Provider)
+	if err != nil {
+		return false, err
+	}
+	return locker.AcquireGCLeader(name, ttl)
+}
+
+// ReleaseGCLeader implements GCLeaderLocker with inner provider.
+func (cpder *CachedProvider) ReleaseGCLeader(name string) error {
+	locker, err := gcLeaderLocker(cpder.Provider)
+	if err != nil {
+		return err
+	}
+	return locker.ReleaseGCLeader(name)
+}
+
+// DestroyAllSessions clears cache and destroys all sessions with inner provider.
+func (cpder *CachedProvider) DestroyAllSessions(l io.Writer, logLev LogLevel) {
+	cpder.purge()
+	cpder.Provider.DestroyAllSessions(l, logLev)
+}
+
+// SetExpiredHook implements ExpiryNotifier.
+func (cpder *CachedProvider) SetExpiredHook(fn SessionHook) {
+	cpder.mx.Lock()
+	defer cpder.mx.Unlock()
+	cpder.expiredHook = fn
+}
+
+func (cpder *CachedProvider) sessionExpired(sid string) {
+	cpder.evict(sid)
+	cpder.mx.Lock()
+	fn := cpder.expiredHook
+	cpder.mx.Unlock()
+	if fn != nil {
+		fn(sid)
+	}
+}
+
+// SetChangedHook implements ChangeNotifier.
+func (cpder *CachedProvider) SetChangedHook(fn SessionHook) {
+	cpder.mx.Lock()
+	defer cpder.mx.Unlock()
+	cpder.changedHook = fn
+}
+
+// IsFatal classifies err with inner provider if it implements Reconnector.
+func (cpder *CachedProvider) IsFatal(err error) bool {
+	reconnector, ok := cpder.Provider.(Reconnector)
+	return ok && reconnector.IsFatal(err)
+}
+
+// Reconnect implements Reconnector with inner provider.
+func (cpder *CachedProvider) Reconnect(ctx context.Context) error {
+	if reconnector, ok := cpder.Provider.(Reconnector); ok {
+		return reconnector.Reconnect(ctx)
+	}
+	return nil
+}
+
+// sessionChanged evicts changed session, the whole cache is cleared if session ID is empty.
+func (cpder *CachedProvider) sessionChanged(sid string) {
+	if sid == "" {
+		cpder.purge()
+	} else {
+		cpder.evict(sid)
+	}
+	cpder.mx.Lock()
+	fn := cpder.changedHook
+	cpder.mx.Unlock()
+	if fn != nil {
+		fn(sid)
+	}
+}
+
+// SetKeyRing passes key ring to inner provider if it implements EncryptedProvider.
+func (cpder *CachedProvider) SetKeyRing(keyRing *KeyRing) {
+	if enc_pder, ok := cpder.Provider.(EncryptedProvider); ok {
+		enc_pder.SetKeyRing(keyRing)
+	}
+}
+
+// SetCompression passes compression to inner provider if it implements CompressedProvider.
+func (cpder *CachedProvider) SetCompression(compression *Compression) {
+	if comp_pder, ok := cpder.Provider.(CompressedProvider); ok {
+		comp_pder.SetCompression(compression)
+	}
+}
+
+// SetPayloadVersion passes payload version to inner provider if it implements VersionedProvider.
+func (cpder *CachedProvider) SetPayloadVersion(payloadVersion *PayloadVersion) {
+	if ver_pder, ok := cpder.Provider.(VersionedProvider); ok {
+		ver_pder.SetPayloadVersion(payloadVersion)
+	}
+}
+
+// SetLogger passes logger to inner provider if it implements LoggedProvider.
+func (cpder *CachedProvider) SetLogger(logger *slog.Logger) {
+	if log_pder, ok := cpder.Provider.(LoggedProvider); ok {
+		log_pder.SetLogger(logger)
+	}
+}
+
+// EnsureSchema implements SchemaProvider with inner provider.
+func (cpder *CachedProvider) EnsureSchema(ctx context.Context) error {
+	return ensureSchema(ctx, cpder.Provider)
+}
+
+// SessionStats implements StatsProvider with inner provider.
+func (cpder *CachedProvider) SessionStats(ctx context.Context, sampleSize int) (SessionStats, error) {
+	return ProviderStats(ctx, cpder.Provider, sampleSize)
+}
+
+// SessionCount implements AdminProvider with inner provider.
+func (cpder *CachedProvider) SessionCount() (int, error) {
+	adm_pder, ok := cpder.Provider.(AdminProvider)
+	if !ok {
+		return 0, ErrNotAdminProvider
+	}
+	return adm_pder.SessionCount()
+}
+
+// SessionList implements AdminProvider with inner provider.
+func (cpder *CachedProvider) SessionList(offset, limit int) ([]SessionMeta, error) {
+	adm_pder, ok := cpder.Provider.(AdminProvider)
+	if !ok {
+		return nil, ErrNotAdminProvider
+	}
+	return adm_pder.SessionList(offset, limit)
+}
+
+// SessionDestroyMany removes sessions from cache and destroys them with inner provider.
+func (cpder *CachedProvider) SessionDestroyMany(sids []string) error {
+	cpder.evict(sids...)
+	if bulk_pder, ok := cpder.Provider.(BulkDestroyProvider); ok {
+		return bulk_pder.SessionDestroyMany(sids)
+	}
+	var errs []error
+	for _, sid := range sids {
+		if err := cpder.Provider.SessionDestroy(sid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SessionUserIndex implements UserIndexProvider with inner provider.
+func (cpder *CachedProvider) SessionUserIndex() UserIndex {
+	return userIndexOf(cpder.Provider)
+}
+
+// ValidateSessionID checks session ID format with inner provider.
+func (cpder *CachedProvider) ValidateSessionID(sid string) error {
+	return ValidateSessionID(cpder.Provider, sid)
+}
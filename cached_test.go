@@ -0,0 +1,61 @@
+package session_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dronm/session"
+	"github.com/dronm/session/mock"
+	"github.com/dronm/session/testkit"
+)
+
+// TestCachedProvider checks that sessions are read from cache, written through
+// to the inner provider and evicted when cache is full.
+func TestCachedProvider(t *testing.T) {
+	pder := mock.NewProvider()
+	cpder := session.NewCachedProvider(pder, 2, time.Minute)
+	SessManager, err := session.NewManagerWithProvider(cpder, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+
+	tests := testkit.NewTestValues()
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	testkit.PutValues(t, currentSession, tests)
+
+	//written through
+	stored, err := pder.SessionRead(sid)
+	if err != nil {
+		t.Fatalf("SessionRead() failed: %v", err)
+	}
+	testkit.CompareValues(t, stored, tests)
+
+	//cached session is not read from database
+	if err := pder.SessionDestroy(sid); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	testkit.CompareValues(t, currentSession, tests)
+
+	//evicted by newer sessions
+	for i := 0; i < 2; i++ {
+		if _, err := SessManager.SessionStart(""); err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+	}
+	if n := cpder.Len(); n != 2 {
+		t.Fatalf("Len() wanted 2, got %d", n)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	testkit.AssertNoValues(t, currentSession, tests)
+}
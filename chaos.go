@@ -0,0 +1,546 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ChaosConfig defines faults injected by ChaosProvider, rates are probabilities from 0 to 1
+// checked independently on every operation.
+type ChaosConfig struct {
+	ErrorRate   float64       //operation fails with Err
+	Err         error         //injected error, ErrChaos if nil
+	LatencyRate float64       //operation is delayed by a random time up to MaxLatency
+	MaxLatency  time.Duration //max injected latency
+	TimeoutRate float64       //operation blocks for Timeout and fails with ErrChaosTimeout
+	Timeout     time.Duration //time a timed out operation blocks for
+	Operations  []string      //names of called methods faults are injected into, e.g. "SessionRead", "Flush", all if empty
+	Seed        int64         //random source seed for reproducible runs, current time if 0
+}
+
+// ChaosStats holds numbers of operations and injected faults.
+type ChaosStats struct {
+	Calls    int64 //operations checked for faults
+	Errors   int64
+	Timeouts int64
+	Delays   int64
+}
+
+// ChaosProvider is a provider decorator injecting random latency, timeouts and errors
+// into operations of inner provider and its sessions, e.g. to validate retry and fallback logic
+// of an application in a test environment. A failed operation is not passed to inner provider.
+// Session methods without error result, e.g. GetString(), are not affected.
+type ChaosProvider struct {
+	Provider
+	mx     sync.Mutex
+	conf   ChaosConfig
+	ops    map[string]bool
+	rnd    *rand.Rand
+	calls  atomic.Int64
+	errs   atomic.Int64
+	tmouts atomic.Int64
+	delays atomic.Int64
+}
+
+// NewChaosProvider returns inner provider injecting faults defined by conf.
+// The result should be registered under its own name with Register(), then used with NewManager():
+//
+//	inner, _ := session.LookupProvider("redis")
+//	session.Register("redis_chaos", session.NewChaosProvider(inner, session.ChaosConfig{
+//		ErrorRate: 0.05, LatencyRate: 0.2, MaxLatency: 200 * time.Millisecond,
+//	}))
+//	SessManager, err := session.NewManager("redis_chaos", ...)
+func NewChaosProvider(inner Provider, conf ChaosConfig) *ChaosProvider {
+	chpder := &ChaosProvider{Provider: inner}
+	chpder.SetConfig(conf)
+	return chpder
+}
+
+// SetConfig replaces injected faults, e.g. ChaosConfig{} stops injection.
+// Random source is reseeded.
+func (chpder *ChaosProvider) SetConfig(conf ChaosConfig) {
+	seed := conf.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	var ops map[string]bool
+	if len(conf.Operations) > 0 {
+		ops = make(map[string]bool, len(conf.Operations))
+		for _, op := range conf.Operations {
+			ops[op] = true
+		}
+	}
+	chpder.mx.Lock()
+	defer chpder.mx.Unlock()
+	chpder.conf = conf
+	chpder.ops = ops
+	chpder.rnd = rand.New(rand.NewSource(seed))
+}
+
+// Stats returns numbers of operations and injected faults.
+func (chpder *ChaosProvider) Stats() ChaosStats {
+	return ChaosStats{
+		Calls:    chpder.calls.Load(),
+		Errors:   chpder.errs.Load(),
+		Timeouts: chpder.tmouts.Load(),
+		Delays:   chpder.delays.Load(),
+	}
+}
+
+// fault delays operation op and returns an injected error, nil if the operation is to be performed.
+func (chpder *ChaosProvider) fault(op string) error {
+	chpder.mx.Lock()
+	conf := chpder.conf
+	if chpder.ops != nil && !chpder.ops[op] {
+		chpder.mx.Unlock()
+		return nil
+	}
+	timeout := chpder.rnd.Float64() < conf.TimeoutRate
+	delayed := chpder.rnd.Float64() < conf.LatencyRate
+	failed := chpder.rnd.Float64() < conf.ErrorRate
+	var latency time.Duration
+	if delayed && conf.MaxLatency > 0 {
+		latency = time.Duration(chpder.rnd.Int63n(int64(conf.MaxLatency)) + 1)
+	}
+	chpder.mx.Unlock()
+
+	chpder.calls.Add(1)
+	if timeout {
+		chpder.tmouts.Add(1)
+		time.Sleep(conf.Timeout)
+		return ErrChaosTimeout
+	}
+	if delayed {
+		chpder.delays.Add(1)
+		time.Sleep(latency)
+	}
+	if failed {
+		chpder.errs.Add(1)
+		if conf.Err != nil {
+			return conf.Err
+		}
+		return ErrChaos
+	}
+	return nil
+}
+
+func (chpder *ChaosProvider) SessionInit(sid string) (Session, error) {
+	if err := chpder.fault("SessionInit"); err != nil {
+		return nil, err
+	}
+	sess, err := chpder.Provider.SessionInit(sid)
+	if err != nil {
+		return nil, err
+	}
+	return &chaosSession{Session: sess, pder: chpder}, nil
+}
+
+func (chpder *ChaosProvider) SessionRead(sid string) (Session, error) {
+	if err := chpder.fault("SessionRead"); err != nil {
+		return nil, err
+	}
+	sess, err := chpder.Provider.SessionRead(sid)
+	if err != nil {
+		return nil, err
+	}
+	return &chaosSession{Session: sess, pder: chpder}, nil
+}
+
+func (chpder *ChaosProvider) SessionDestroy(sid string) error {
+	if err := chpder.fault("SessionDestroy"); err != nil {
+		return err
+	}
+	return chpder.Provider.SessionDestroy(sid)
+}
+
+func (chpder *ChaosProvider) SessionClose(sid string) error {
+	if err := chpder.fault("SessionClose"); err != nil {
+		return err
+	}
+	return chpder.Provider.SessionClose(sid)
+}
+
+// SessionGC runs GC of inner provider, an injected fault is counted in GCReport.Errors.
+func (chpder *ChaosProvider) SessionGC(l io.Writer, logLev LogLevel) GCReport {
+	if err := chpder.fault("SessionGC"); err != nil {
+		return GCReport{Errors: 1}
+	}
+	return chpder.Provider.SessionGC(l, logLev)
+}
+
+func (chpder *ChaosProvider) Ping(ctx context.Context) error {
+	if err := chpder.fault("Ping"); err != nil {
+		return err
+	}
+	return chpder.Provider.Ping(ctx)
+}
+
+// Drain implements DrainProvider with inner provider.
+func (chpder *ChaosProvider) Drain() error {
+	if drain_pder, ok := chpder.Provider.(DrainProvider); ok {
+		return drain_pder.Drain()
+	}
+	return nil
+}
+
+// SetGCMaxDeletions implements GCLimiter, the limit is passed to inner provider.
+func (chpder *ChaosProvider) SetGCMaxDeletions(maxDeletions int) {
+	setGCMaxDeletions(maxDeletions, chpder.Provider)
+}
+
+// SetExpirationMode implements ExpirationModeSetter, the mode is passed to inner provider.
+func (chpder *ChaosProvider) SetExpirationMode(mode ExpirationMode) {
+	setExpirationMode(mode, chpder.Provider)
+}
+
+// SetStrictMode implements StrictModeSetter, the mode is passed to inner provider.
+// ErrNoStrictMode is returned if inner provider does not support strict mode.
+func (chpder *ChaosProvider) SetStrictMode(strict bool) error {
+	return setStrictMode(strict, chpder.Provider)
+}
+
+// SetClock implements ClockSetter, the clock is passed to inner provider.
+func (chpder *ChaosProvider) SetClock(clock Clock) {
+	setClock(clock, chpder.Provider)
+}
+
+// SessionMeta implements MetaProvider with inner provider.
+func (chpder *ChaosProvider) SessionMeta(sid string) (SessionMeta, error) {
+	if err := chpder.fault("SessionMeta"); err != nil {
+		return SessionMeta{}, err
+	}
+	return sessionMeta(chpder.Provider, sid)
+}
+
+// SessionPeek implements PeekProvider with inner provider.
+func (chpder *ChaosProvider) SessionPeek(sid string) (Session, error) {
+	if err := chpder.fault("SessionPeek"); err != nil {
+		return nil, err
+	}
+	return sessionPeek(chpder.Provider, sid)
+}
+
+// AcquireGCLeader implements GCLeaderLocker with inner provider.
+func (chpder *ChaosProvider) AcquireGCLeader(name string, ttl time.Duration) (bool, error) {
+	locker, err := gcLeaderLocker(chpder.Provider)
+	if err != nil {
+		return false, err
+	}
+	if err := chpder.fault("AcquireGCLeader"); err != nil {
+		return false, err
+	}
+	return locker.AcquireGCLeader(name, ttl)
+}
+
+// ReleaseGCLeader implements GCLeaderLocker with inner provider.
+func (chpder *ChaosProvider) ReleaseGCLeader(name string) error {
+	locker, err := gcLeaderLocker(chpder.Provider)
+	if err != nil {
+		return err
+	}
+	return locker.ReleaseGCLeader(name)
+}
+
+// SetExpiredHook passes hook to inner provider if it implements ExpiryNotifier.
+func (chpder *ChaosProvider) SetExpiredHook(fn SessionHook) {
+	if notifier, ok := chpder.Provider.(ExpiryNotifier); ok {
+		notifier.SetExpiredHook(fn)
+	}
+}
+
+// SetChangedHook passes hook to inner provider if it implements ChangeNotifier.
+func (chpder *ChaosProvider) SetChangedHook(fn SessionHook) {
+	if notifier, ok := chpder.Provider.(ChangeNotifier); ok {
+		notifier.SetChangedHook(fn)
+	}
+}
+
+// IsFatal classifies err with inner provider if it implements Reconnector.
+func (chpder *ChaosProvider) IsFatal(err error) bool {
+	reconnector, ok := chpder.Provider.(Reconnector)
+	return ok && reconnector.IsFatal(err)
+}
+
+// Reconnect implements Reconnector with inner provider.
+func (chpder *ChaosProvider) Reconnect(ctx context.Context) error {
+	if reconnector, ok := chpder.Provider.(Reconnector); ok {
+		return reconnector.Reconnect(ctx)
+	}
+	return nil
+}
+
+// SetKeyRing passes key ring to inner provider if it implements EncryptedProvider.
+func (chpder *ChaosProvider) SetKeyRing(keyRing *KeyRing) {
+	if enc_pder, ok := chpder.Provider.(EncryptedProvider); ok {
+		enc_pder.SetKeyRing(keyRing)
+	}
+}
+
+// SetCompression passes compression to inner provider if it implements CompressedProvider.
+func (chpder *ChaosProvider) SetCompression(compression *Compression) {
+	if comp_pder, ok := chpder.Provider.(CompressedProvider); ok {
+		comp_pder.SetCompression(compression)
+	}
+}
+
+// SetPayloadVersion passes payload version to inner provider if it implements VersionedProvider.
+func (chpder *ChaosProvider) SetPayloadVersion(payloadVersion *PayloadVersion) {
+	if ver_pder, ok := chpder.Provider.(VersionedProvider); ok {
+		ver_pder.SetPayloadVersion(payloadVersion)
+	}
+}
+
+// SetLogger passes logger to inner provider if it implements LoggedProvider.
+func (chpder *ChaosProvider) SetLogger(logger *slog.Logger) {
+	if log_pder, ok := chpder.Provider.(LoggedProvider); ok {
+		log_pder.SetLogger(logger)
+	}
+}
+
+// EnsureSchema implements SchemaProvider with inner provider.
+func (chpder *ChaosProvider) EnsureSchema(ctx context.Context) error {
+	return ensureSchema(ctx, chpder.Provider)
+}
+
+// SessionStats implements StatsProvider with inner provider.
+func (chpder *ChaosProvider) SessionStats(ctx context.Context, sampleSize int) (SessionStats, error) {
+	if err := chpder.fault("SessionStats"); err != nil {
+		return SessionStats{}, err
+	}
+	return ProviderStats(ctx, chpder.Provider, sampleSize)
+}
+
+// SessionCount implements AdminProvider with inner provider.
+func (chpder *ChaosProvider) SessionCount() (int, error) {
+	adm_pder, ok := chpder.Provider.(AdminProvider)
+	if !ok {
+		return 0, ErrNotAdminProvider
+	}
+	if err := chpder.fault("SessionCount"); err != nil {
+		return 0, err
+	}
+	return adm_pder.SessionCount()
+}
+
+// SessionList implements AdminProvider with inner provider.
+func (chpder *ChaosProvider) SessionList(offset, limit int) ([]SessionMeta, error) {
+	adm_pder, ok := chpder.Provider.(AdminProvider)
+	if !ok {
+		return nil, ErrNotAdminProvider
+	}
+	if err := chpder.fault("SessionList"); err != nil {
+		return nil, err
+	}
+	return adm_pder.SessionList(offset, limit)
+}
+
+// SessionDestroyMany destroys sessions with inner provider.
+func (chpder *ChaosProvider) SessionDestroyMany(sids []string) error {
+	if bulk_pder, ok := chpder.Provider.(BulkDestroyProvider); ok {
+		if err := chpder.fault("SessionDestroyMany"); err != nil {
+			return err
+		}
+		return bulk_pder.SessionDestroyMany(sids)
+	}
+	var errs []error
+	for _, sid := range sids {
+		if err := chpder.SessionDestroy(sid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// chaosSession injects faults into session methods returning errors.
+type chaosSession struct {
+	Session
+	pder *ChaosProvider
+}
+
+func (s *chaosSession) Set(key string, value interface{}) error {
+	if err := s.pder.fault("Set"); err != nil {
+		return err
+	}
+	return s.Session.Set(key, value)
+}
+
+func (s *chaosSession) Put(key string, value interface{}) error {
+	if err := s.pder.fault("Put"); err != nil {
+		return err
+	}
+	return s.Session.Put(key, value)
+}
+
+func (s *chaosSession) Get(key string, value interface{}) error {
+	if err := s.pder.fault("Get"); err != nil {
+		return err
+	}
+	return s.Session.Get(key, value)
+}
+
+func (s *chaosSession) GetStruct(key string, dest interface{}) error {
+	if err := s.pder.fault("GetStruct"); err != nil {
+		return err
+	}
+	return s.Session.GetStruct(key, dest)
+}
+
+func (s *chaosSession) Delete(key string) error {
+	if err := s.pder.fault("Delete"); err != nil {
+		return err
+	}
+	return s.Session.Delete(key)
+}
+
+func (s *chaosSession) Clear() error {
+	if err := s.pder.fault("Clear"); err != nil {
+		return err
+	}
+	return s.Session.Clear()
+}
+
+func (s *chaosSession) Increment(key string, delta int64) (int64, error) {
+	if err := s.pder.fault("Increment"); err != nil {
+		return 0, err
+	}
+	return s.Session.Increment(key, delta)
+}
+
+func (s *chaosSession) Decrement(key string, delta int64) (int64, error) {
+	if err := s.pder.fault("Decrement"); err != nil {
+		return 0, err
+	}
+	return s.Session.Decrement(key, delta)
+}
+
+func (s *chaosSession) Keys() ([]string, error) {
+	if err := s.pder.fault("Keys"); err != nil {
+		return nil, err
+	}
+	return s.Session.Keys()
+}
+
+func (s *chaosSession) Len() (int, error) {
+	if err := s.pder.fault("Len"); err != nil {
+		return 0, err
+	}
+	return s.Session.Len()
+}
+
+func (s *chaosSession) Flush() error {
+	if err := s.pder.fault("Flush"); err != nil {
+		return err
+	}
+	return s.Session.Flush()
+}
+
+func (s *chaosSession) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	if err := s.pder.fault("GetOrSet"); err != nil {
+		return err
+	}
+	return s.Session.GetOrSet(key, dest, compute)
+}
+
+func (s *chaosSession) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
+	if err := s.pder.fault("CompareAndSwap"); err != nil {
+		return false, err
+	}
+	return s.Session.CompareAndSwap(key, oldValue, newValue)
+}
+
+func (s *chaosSession) SetMany(values map[string]interface{}) error {
+	if err := s.pder.fault("SetMany"); err != nil {
+		return err
+	}
+	return s.Session.SetMany(values)
+}
+
+func (s *chaosSession) GetMany(dest map[string]interface{}) error {
+	if err := s.pder.fault("GetMany"); err != nil {
+		return err
+	}
+	return s.Session.GetMany(dest)
+}
+
+func (s *chaosSession) Snapshot() (map[string]interface{}, error) {
+	if err := s.pder.fault("Snapshot"); err != nil {
+		return nil, err
+	}
+	return s.Session.Snapshot()
+}
+
+func (s *chaosSession) Restore(snapshot map[string]interface{}) error {
+	if err := s.pder.fault("Restore"); err != nil {
+		return err
+	}
+	return s.Session.Restore(snapshot)
+}
+
+func (s *chaosSession) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	if err := s.pder.fault("SetWithTTL"); err != nil {
+		return err
+	}
+	return s.Session.SetWithTTL(key, value, ttl)
+}
+
+func (s *chaosSession) SetSensitive(key string, value interface{}) error {
+	if err := s.pder.fault("SetSensitive"); err != nil {
+		return err
+	}
+	return s.Session.SetSensitive(key, value)
+}
+
+func (s *chaosSession) Lock() error {
+	if err := s.pder.fault("Lock"); err != nil {
+		return err
+	}
+	return s.Session.Lock()
+}
+
+func (s *chaosSession) Unlock() error {
+	if err := s.pder.fault("Unlock"); err != nil {
+		return err
+	}
+	return s.Session.Unlock()
+}
+
+func (s *chaosSession) SetExpiry(d time.Duration) error {
+	if err := s.pder.fault("SetExpiry"); err != nil {
+		return err
+	}
+	return s.Session.SetExpiry(d)
+}
+
+func (s *chaosSession) Touch() error {
+	if err := s.pder.fault("Touch"); err != nil {
+		return err
+	}
+	return s.Session.Touch()
+}
+
+func (s *chaosSession) Allow(action string, limit int, window time.Duration) (bool, error) {
+	if err := s.pder.fault("Allow"); err != nil {
+		return false, err
+	}
+	return s.Session.Allow(action, limit, window)
+}
+
+func (s *chaosSession) Bucket(name string) Session {
+	return NewBucket(s, name)
+}
+
+// SessionUserIndex implements UserIndexProvider with inner provider.
+func (chpder *ChaosProvider) SessionUserIndex() UserIndex {
+	return userIndexOf(chpder.Provider)
+}
+
+// ValidateSessionID checks session ID format with inner provider.
+func (chpder *ChaosProvider) ValidateSessionID(sid string) error {
+	return ValidateSessionID(chpder.Provider, sid)
+}
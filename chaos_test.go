@@ -0,0 +1,55 @@
+package session_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dronm/session"
+	"github.com/dronm/session/mock"
+)
+
+// TestChaosProvider checks faults injected by chaos provider decorator.
+func TestChaosProvider(t *testing.T) {
+	chaos := session.NewChaosProvider(mock.NewProvider(), session.ChaosConfig{})
+	SessManager, err := session.NewManagerWithProvider(chaos, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	defer SessManager.SessionDestroy(sid)
+
+	chaos.SetConfig(session.ChaosConfig{ErrorRate: 1, Operations: []string{"Flush"}})
+	if err := currentSession.Set("key", "value"); err != nil {
+		t.Fatalf("Set() not in Operations failed: %v", err)
+	}
+	if err := currentSession.Flush(); !errors.Is(err, session.ErrChaos) {
+		t.Fatalf("Flush() wanted ErrChaos, got %v", err)
+	}
+
+	chaos.SetConfig(session.ChaosConfig{TimeoutRate: 1, Timeout: 10 * time.Millisecond, Operations: []string{"SessionRead"}})
+	if _, err := SessManager.SessionStart(sid); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("SessionStart() wanted timeout, got %v", err)
+	}
+
+	chaos.SetConfig(session.ChaosConfig{})
+	if err := currentSession.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if got := currentSession.GetString("key"); got != "value" {
+		t.Fatalf("wanted key=value, got %q", got)
+	}
+	if stats := chaos.Stats(); stats.Errors != 1 || stats.Timeouts != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
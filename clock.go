@@ -0,0 +1,122 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a source of current time and timers, see Manager.SetClock().
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time //sends current time after d
+}
+
+// SystemClock is a Clock of the time package, it is used by default.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// ClockSetter is an optional interface for providers checking expiration with a Clock,
+// so tests move time instead of waiting.
+type ClockSetter interface {
+	SetClock(Clock) //nil restores SystemClock
+}
+
+// ManualClock is a Clock for tests, its time changes with Advance() only.
+// Timers of After() fire when time is advanced past them.
+type ManualClock struct {
+	mx     sync.Mutex
+	now    time.Time
+	timers []manualTimer
+}
+
+// manualTimer is a pending timer of ManualClock.
+type manualTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewManualClock returns clock stopped at now.
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, manualTimer{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves time forward by d and fires timers due by then.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.ch <- c.now
+	}
+	c.timers = pending
+}
+
+// Timers returns number of pending timers, e.g. to wait till a goroutine sleeps on After().
+func (c *ManualClock) Timers() int {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return len(c.timers)
+}
+
+// clockOrSystem returns clock, SystemClock if it is nil.
+func clockOrSystem(clock Clock) Clock {
+	if clock == nil {
+		return SystemClock{}
+	}
+	return clock
+}
+
+// SetClock sets clock of GC and kill time scheduling, trash, user bindings and circuit breaker and passes it
+// to provider if it implements ClockSetter, e.g. ManualClock to test expiration without sleeping.
+// Decorators pass the clock to every inner provider. Storage expiration, e.g. redis key TTL,
+// runs in real time. Nil restores SystemClock.
+// Should be set before StartGC().
+func (manager *Manager) SetClock(clock Clock) {
+	manager.clock = clockOrSystem(clock)
+	manager.breaker.setClock(manager.clock)
+	setClock(clock, manager.provider)
+}
+
+// Clock returns clock of the manager set with SetClock(), SystemClock by default.
+func (manager *Manager) Clock() Clock {
+	return manager.clock
+}
+
+// setClock passes clock to providers implementing ClockSetter.
+func setClock(clock Clock, providers ...Provider) {
+	for _, p := range providers {
+		if setter, ok := p.(ClockSetter); ok {
+			setter.SetClock(clock)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+// Command sessctl inspects and manages sessions of a provider.
+//
+// Usage:
+//
+//	sessctl -provider redis -params redis://localhost:6379/0,myapp <command> [sid]
+//
+// Commands:
+//
+//	list           prints session IDs with creation and access times, see -offset and -limit
+//	show <sid>     prints session metadata and values
+//	destroy <sid>  destroys session
+//	destroy-all    destroys all sessions, -yes must be given
+//	gc             removes expired sessions, see -max-life and -max-idle
+//	stats          prints number of sessions, size, age and idle time percentiles and storage size
+//
+// Provider parameters are comma separated strings passed to provider InitProvider(),
+// see provider packages. Supported providers: sqlite3, bolt, redis, pg.
+// The first pg parameter is a connection string, a connection pool is opened with it.
+// Custom value types can not be decoded by the command and are reported as errors.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dronm/session"
+	_ "github.com/dronm/session/bolt"
+	_ "github.com/dronm/session/pg"
+	_ "github.com/dronm/session/redis"
+	_ "github.com/dronm/session/sqlite"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const TIME_LAYOUT = time.RFC3339
+
+// errUsage is returned when command line is incomplete, usage is printed.
+var errUsage = errors.New("usage")
+
+// options are parsed command line.
+type options struct {
+	pderName   string
+	pderParams string
+	maxLife    int64
+	maxIdle    int64
+	offset     int
+	limit      int
+	yes        bool
+	cmd        string
+	sid        string
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "sessctl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage(fs *flag.FlagSet) {
+	fmt.Fprintf(fs.Output(), "Usage: %s [flags] list|show <sid>|destroy <sid>|destroy-all|gc|stats\n", fs.Name())
+	fs.PrintDefaults()
+}
+
+// parseArgs parses command line arguments, usage and flag errors are written to errOut.
+func parseArgs(args []string, errOut io.Writer) (*options, error) {
+	opts := &options{}
+	fs := flag.NewFlagSet("sessctl", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	fs.Usage = func() { usage(fs) }
+	fs.StringVar(&opts.pderName, "provider", "", "provider name")
+	fs.StringVar(&opts.pderParams, "params", "", "comma separated provider parameters")
+	fs.Int64Var(&opts.maxLife, "max-life", 0, "max session life time in seconds for gc, 0 means no limit")
+	fs.Int64Var(&opts.maxIdle, "max-idle", 0, "max session idle time in seconds for gc, 0 means no limit")
+	fs.IntVar(&opts.offset, "offset", 0, "list offset")
+	fs.IntVar(&opts.limit, "limit", 0, "list limit, 0 means all sessions")
+	fs.BoolVar(&opts.yes, "yes", false, "confirm destroy-all")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	args = fs.Args()
+	if opts.pderName == "" || len(args) == 0 {
+		fs.Usage()
+		return nil, errUsage
+	}
+	opts.cmd = args[0]
+	switch opts.cmd {
+	case "show", "destroy":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s: session ID expected", opts.cmd)
+		}
+		opts.sid = args[1]
+	case "list", "destroy-all", "gc", "stats":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s: unexpected arguments", opts.cmd)
+		}
+	default:
+		return nil, fmt.Errorf("unknown command %q", opts.cmd)
+	}
+	if opts.cmd == "destroy-all" && !opts.yes {
+		return nil, errors.New("destroy-all: add -yes to destroy all sessions")
+	}
+	return opts, nil
+}
+
+// run executes command given by args, output is written to out, provider logs to errOut.
+func run(args []string, out, errOut io.Writer) error {
+	opts, err := parseArgs(args, errOut)
+	if err != nil {
+		return err
+	}
+
+	prov_params, err := providerParams(opts.pderName, opts.pderParams)
+	if err != nil {
+		return err
+	}
+	manager, err := session.NewManager(opts.pderName, opts.maxLife, opts.maxIdle, "", prov_params...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", opts.pderName, err)
+	}
+	defer manager.CloseProvider()
+
+	switch opts.cmd {
+	case "list":
+		return list(out, manager, opts.offset, opts.limit)
+	case "show":
+		return show(out, manager, opts.pderName, opts.sid)
+	case "destroy":
+		return manager.SessionDestroy(opts.sid)
+	case "destroy-all":
+		manager.DestroyAllSessions(errOut, session.LOG_LEVEL_ERROR)
+		return nil
+	case "gc":
+		report := manager.SessionGC(errOut, session.LOG_LEVEL_ERROR)
+		fmt.Fprintf(out, "scanned: %d, deleted: %d (idle: %d, life time: %d, expiry: %d), errors: %d, duration: %v\n",
+			report.Scanned, report.Deleted(), report.DeletedIdle, report.DeletedLifetime, report.DeletedExpiry,
+			report.Errors, report.Duration)
+		return nil
+	default:
+		return stats(out, manager)
+	}
+}
+
+// providerParams converts comma separated string parameters to provider parameters.
+func providerParams(name, params string) ([]interface{}, error) {
+	var prov_params []interface{}
+	if params != "" {
+		for _, p := range strings.Split(params, ",") {
+			prov_params = append(prov_params, p)
+		}
+	}
+	if name == "pg" && len(prov_params) > 0 {
+		pool, err := pgxpool.New(context.Background(), prov_params[0].(string))
+		if err != nil {
+			return nil, err
+		}
+		prov_params[0] = pool
+		if len(prov_params) == 1 {
+			prov_params = append(prov_params, "") //no encryption
+		}
+	}
+	return prov_params, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(TIME_LAYOUT)
+}
+
+func list(out io.Writer, manager *session.Manager, offset, limit int) error {
+	metas, err := manager.ListSessions(offset, limit)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCREATED\tACCESSED")
+	for _, meta := range metas {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", meta.ID, formatTime(meta.TimeCreated), formatTime(meta.TimeAccessed))
+	}
+	return w.Flush()
+}
+
+// show prints session metadata if provider supports it and session values.
+// Session values are read with provider directly, so the session is not created if it does not exist.
+func show(out io.Writer, manager *session.Manager, pderName, sid string) error {
+	meta, err := manager.SessionMeta(sid)
+	if err != nil && !errors.Is(err, session.ErrNoSessionMeta) {
+		return err
+	}
+	if err == nil {
+		fmt.Fprintf(out, "id: %s\ncreated: %s\naccessed: %s\nexpires: %s\nsize: %d\n",
+			meta.ID, formatTime(meta.TimeCreated), formatTime(meta.TimeAccessed), formatTime(meta.ExpiresAt), meta.Size)
+		if meta.Fingerprint != "" {
+			fmt.Fprintf(out, "fingerprint: %s\n", meta.Fingerprint)
+		}
+	}
+	pder, _ := session.LookupProvider(pderName)
+	sess, err := pder.SessionRead(sid)
+	if err != nil {
+		return err
+	}
+	defer pder.SessionClose(sid)
+	values, err := sess.Snapshot()
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Fprintln(out, "values:")
+	for _, key := range keys {
+		fmt.Fprintf(out, "  %s: %#v\n", key, values[key])
+	}
+	return nil
+}
+
+// stats prints session statistics of the manager.
+func stats(out io.Writer, manager *session.Manager) error {
+	st, err := manager.Stats(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "sessions: %d\nsampled: %d\ntotal size: %d\nstorage: %d\n", st.Count, st.Sampled, st.TotalSize, st.StorageBytes)
+	fmt.Fprintf(out, "size: min %d, p50 %d, p90 %d, p99 %d, max %d\n", st.Size.Min, st.Size.P50, st.Size.P90, st.Size.P99, st.Size.Max)
+	fmt.Fprintf(out, "age: min %v, p50 %v, p90 %v, p99 %v, max %v\n", st.Age.Min, st.Age.P50, st.Age.P90, st.Age.P99, st.Age.Max)
+	fmt.Fprintf(out, "idle: min %v, p50 %v, p90 %v, p99 %v, max %v\n", st.Idle.Min, st.Idle.P50, st.Idle.P90, st.Idle.P99, st.Idle.Max)
+	return nil
+}
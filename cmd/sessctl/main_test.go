@@ -0,0 +1,97 @@
+// testing functions for sessctl.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/dronm/session"
+	"github.com/dronm/session/mock"
+)
+
+var testProvider = mock.NewProvider()
+
+func init() {
+	session.Register(mock.PROVIDER, testProvider)
+}
+
+// TestParseArgs checks flags, commands and their arguments.
+func TestParseArgs(t *testing.T) {
+	opts, err := parseArgs([]string{"-provider", "redis", "-params", "redis://localhost:6379/0,app", "-offset", "10", "-limit", "5", "list"}, io.Discard)
+	if err != nil {
+		t.Fatalf("parseArgs() failed: %v", err)
+	}
+	if opts.pderName != "redis" || opts.pderParams != "redis://localhost:6379/0,app" || opts.offset != 10 || opts.limit != 5 || opts.cmd != "list" {
+		t.Fatalf("parseArgs() = %+v", opts)
+	}
+	if opts, err = parseArgs([]string{"-provider", "mock", "show", "sid1"}, io.Discard); err != nil || opts.sid != "sid1" {
+		t.Fatalf("parseArgs() = %+v, %v, wanted session ID sid1", opts, err)
+	}
+
+	var usage bytes.Buffer
+	if _, err := parseArgs([]string{"list"}, &usage); !errors.Is(err, errUsage) {
+		t.Fatalf("parseArgs() without provider wanted errUsage, got %v", err)
+	}
+	if !strings.Contains(usage.String(), "Usage:") {
+		t.Fatalf("usage is not printed: %s", usage.String())
+	}
+	if _, err := parseArgs([]string{"-h"}, io.Discard); !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("parseArgs() -h wanted flag.ErrHelp, got %v", err)
+	}
+
+	for _, args := range [][]string{
+		{"-provider", "mock", "show"},
+		{"-provider", "mock", "destroy", "sid1", "sid2"},
+		{"-provider", "mock", "list", "sid1"},
+		{"-provider", "mock", "destroy-all"},
+		{"-provider", "mock", "drop"},
+		{"-provider", "mock", "-max-life", "day", "gc"},
+	} {
+		if _, err := parseArgs(args, io.Discard); err == nil {
+			t.Fatalf("parseArgs(%v) wanted error", args)
+		}
+	}
+	if _, err := parseArgs([]string{"-provider", "mock", "-yes", "destroy-all"}, io.Discard); err != nil {
+		t.Fatalf("parseArgs() destroy-all with -yes failed: %v", err)
+	}
+}
+
+// TestDestroy destroys a session of the mock provider and runs gc.
+func TestDestroy(t *testing.T) {
+	testProvider.Reset()
+	SessManager, err := session.NewManager(mock.PROVIDER, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Set("user", "user1"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	SessManager.SessionClose(sid)
+	if _, ok := testProvider.Stored(sid); !ok {
+		t.Fatal("session is not stored")
+	}
+
+	if err := run([]string{"-provider", mock.PROVIDER, "destroy", sid}, io.Discard, io.Discard); err != nil {
+		t.Fatalf("run() destroy failed: %v", err)
+	}
+	if _, ok := testProvider.Stored(sid); ok {
+		t.Fatal("session is not destroyed")
+	}
+
+	var out bytes.Buffer
+	if err := run([]string{"-provider", mock.PROVIDER, "gc"}, &out, io.Discard); err != nil {
+		t.Fatalf("run() gc failed: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "scanned: ") {
+		t.Fatalf("gc printed %q", out.String())
+	}
+}
@@ -0,0 +1,104 @@
+// Command sessmigrate copies all sessions from one provider to another, see session.Migrate().
+//
+// Usage:
+//
+//	sessmigrate -src sqlite3 -src-params sessions.db -dst redis -dst-params redis://localhost:6379/0,myapp
+//
+// Provider parameters are comma separated strings passed to provider InitProvider(),
+// see provider packages. Supported providers: sqlite3, bolt, redis, pg.
+// The first pg parameter is a connection string, a connection pool is opened with it.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dronm/session"
+	_ "github.com/dronm/session/bolt"
+	_ "github.com/dronm/session/pg"
+	_ "github.com/dronm/session/redis"
+	_ "github.com/dronm/session/sqlite"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, "sessmigrate:", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	src_name := flag.String("src", "", "source provider name")
+	src_params := flag.String("src-params", "", "comma separated source provider parameters")
+	dst_name := flag.String("dst", "", "destination provider name")
+	dst_params := flag.String("dst-params", "", "comma separated destination provider parameters")
+	destroy := flag.Bool("destroy-src", false, "destroy migrated sessions in source provider")
+	page_size := flag.Int("page-size", session.LIST_PAGE_SIZE, "sessions listed at once")
+	verbose := flag.Bool("v", false, "print every session")
+	flag.Parse()
+
+	if *src_name == "" || *dst_name == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *src_name == *dst_name {
+		return errors.New("source and destination providers must differ")
+	}
+	src, err := initProvider(*src_name, *src_params)
+	if err != nil {
+		return err
+	}
+	defer src.CloseProvider()
+	dst, err := initProvider(*dst_name, *dst_params)
+	if err != nil {
+		return err
+	}
+	defer dst.CloseProvider()
+
+	opts := session.MigrateOptions{PageSize: *page_size, DestroySource: *destroy}
+	if *verbose {
+		opts.OnSession = func(sid string, err error) {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", sid, err)
+				return
+			}
+			fmt.Println(sid)
+		}
+	}
+	res, err := session.Migrate(src, dst, opts)
+	fmt.Printf("migrated: %d, skipped: %d, failed: %d\n", res.Migrated, res.Skipped, res.Failed)
+	return err
+}
+
+// initProvider looks up registered provider and initializes it with string parameters.
+func initProvider(name, params string) (session.Provider, error) {
+	pder, ok := session.LookupProvider(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+	var prov_params []interface{}
+	if params != "" {
+		for _, p := range strings.Split(params, ",") {
+			prov_params = append(prov_params, p)
+		}
+	}
+	if name == "pg" && len(prov_params) > 0 {
+		pool, err := pgxpool.New(context.Background(), prov_params[0].(string))
+		if err != nil {
+			return nil, err
+		}
+		prov_params[0] = pool
+		if len(prov_params) == 1 {
+			prov_params = append(prov_params, "") //no encryption
+		}
+	}
+	if err := pder.InitProvider(prov_params); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return pder, nil
+}
@@ -0,0 +1,149 @@
+package session
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// COMPRESSION_MAGIC is the first byte of compressed payloads, it is followed by compressor ID.
+// Gob streams never start with this byte, so payloads written before compression was enabled
+// are decoded as they are.
+const COMPRESSION_MAGIC byte = 0xC5
+
+// Compressor IDs.
+const (
+	COMPRESSOR_GZIP byte = 1
+	COMPRESSOR_ZSTD byte = 2 //reserved for a zstd compressor registered with RegisterCompressor()
+)
+
+// COMPRESSION_THRESHOLD is a default size in bytes of payloads compressed, see SetCompression().
+const COMPRESSION_THRESHOLD = 1024
+
+// Compressor compresses session payloads.
+type Compressor interface {
+	ID() byte //written after COMPRESSION_MAGIC, compressor is found by it on decompression
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var compressors = map[byte]Compressor{COMPRESSOR_GZIP: GzipCompressor{}}
+var compressorsMx sync.RWMutex
+
+// RegisterCompressor makes compressor available for decompression of payloads by its ID,
+// e.g. a zstd one. GzipCompressor is registered by default.
+func RegisterCompressor(c Compressor) {
+	compressorsMx.Lock()
+	defer compressorsMx.Unlock()
+	compressors[c.ID()] = c
+}
+
+// GzipCompressor compresses payloads with gzip.
+type GzipCompressor struct {
+	Level int //gzip compression level, 0 means gzip.DefaultCompression
+}
+
+func (c GzipCompressor) ID() byte {
+	return COMPRESSOR_GZIP
+}
+
+func (c GzipCompressor) Compress(data []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	var b bytes.Buffer
+	w, err := gzip.NewWriterLevel(&b, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (c GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// Compression compresses payloads of threshold size or larger.
+type Compression struct {
+	compressor Compressor
+	threshold  int
+}
+
+// NewCompression returns compression of payloads of threshold size or larger with compressor.
+func NewCompression(compressor Compressor, threshold int) *Compression {
+	return &Compression{compressor: compressor, threshold: threshold}
+}
+
+// Compress returns compressed data prefixed with COMPRESSION_MAGIC and compressor ID.
+// Data smaller than threshold or not reduced by compression is returned as is.
+func (c *Compression) Compress(data []byte) ([]byte, error) {
+	if len(data) < c.threshold {
+		return data, nil
+	}
+	compressed, err := c.compressor.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(compressed)+2 >= len(data) {
+		return data, nil
+	}
+	return append([]byte{COMPRESSION_MAGIC, c.compressor.ID()}, compressed...), nil
+}
+
+// Decompress decompresses payload returned by Compression.Compress(),
+// payloads without COMPRESSION_MAGIC are returned as is.
+// Providers call it whether compression is enabled or not.
+func Decompress(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != COMPRESSION_MAGIC {
+		return data, nil
+	}
+	compressorsMx.RLock()
+	c, ok := compressors[data[1]]
+	compressorsMx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("session: payload compressed with unknown compressor %d", data[1])
+	}
+	return c.Decompress(data[2:])
+}
+
+// CompressedProvider is an optional interface for providers
+// supporting session payload compression.
+type CompressedProvider interface {
+	SetCompression(*Compression) //nil disables compression, compressed payloads are still decompressed
+}
+
+// SetCompression enables compression of session payloads of threshold size in bytes or larger,
+// e.g. large blobs stored in sessions, COMPRESSION_THRESHOLD is used if threshold is 0.
+// Nil compressor disables compression, payloads written compressed are still read.
+// The compressor is registered with RegisterCompressor().
+// Provider must implement CompressedProvider interface.
+func (manager *Manager) SetCompression(compressor Compressor, threshold int) error {
+	comp_pder, ok := manager.provider.(CompressedProvider)
+	if !ok {
+		return ErrNoCompression
+	}
+	if compressor == nil {
+		comp_pder.SetCompression(nil)
+		return nil
+	}
+	if threshold == 0 {
+		threshold = COMPRESSION_THRESHOLD
+	}
+	RegisterCompressor(compressor)
+	comp_pder.SetCompression(NewCompression(compressor, threshold))
+	return nil
+}
@@ -0,0 +1,18 @@
+package session
+
+import "context"
+
+// sessionCtxKey is a context key of the session, see NewContext().
+type sessionCtxKey struct{}
+
+// NewContext returns a copy of ctx carrying sess, so handler layers get the session
+// with FromContext() instead of starting it by ID again.
+func NewContext(ctx context.Context, sess Session) context.Context {
+	return context.WithValue(ctx, sessionCtxKey{}, sess)
+}
+
+// FromContext returns the session carried by ctx, false if there is none.
+func FromContext(ctx context.Context) (Session, bool) {
+	sess, ok := ctx.Value(sessionCtxKey{}).(Session)
+	return sess, ok
+}
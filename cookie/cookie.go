@@ -0,0 +1,767 @@
+// Package cookie contains client-side session provider, session data is kept in a cookie.
+// No server-side storage is required.
+//
+// Session values are serialized with gob encoder, optionally encrypted with AES-GCM
+// and signed with HMAC-SHA256. The signed value is base64 url encoded and is used as session ID:
+// SessionID() changes on every Flush() and the cookie must be written again, see SetCookie().
+//
+// As sessions are not kept on server, SessionDestroy() and SessionGC() do nothing,
+// max life time, max idle time and expiration set with SetExpiry() are checked when a session is read.
+// Lock()/Unlock() do nothing as well.
+package cookie
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dronm/session"
+)
+
+// Deprecated: use session.ErrKeyNotFound.
+var EKeyNotFound = session.ErrKeyNotFound
+
+// Deprecated: use session.ErrValMustBePtr.
+var EValMustBePtr = session.ErrValMustBePtr
+
+// ErrInvalidCookie is returned by SessionRead() if a cookie value is not a session signed with the provider key.
+var ErrInvalidCookie = errors.New("cookie provider: invalid cookie signature")
+
+// ErrCookieTooLarge is returned if an encoded session exceeds MAX_COOKIE_LEN.
+var ErrCookieTooLarge = errors.New("cookie provider: encoded session exceeds max cookie size")
+
+// Deprecated: use ErrInvalidCookie.
+var EInvalidCookie = ErrInvalidCookie
+
+// Deprecated: use ErrCookieTooLarge.
+var ECookieTooLarge = ErrCookieTooLarge
+
+// MAX_COOKIE_LEN is max length of encoded session, browsers limit cookie size to 4096 bytes.
+const MAX_COOKIE_LEN = 4000
+
+const PROVIDER = "cookie"
+
+const LOG_PREF = "cookie provider:"
+
+// pder holds pointer to Provider struct registered as PROVIDER.
+var pder = NewProvider()
+
+// NewProvider returns a provider independent of the registered one, e.g. to use another hash key
+// in another Manager created with session.NewManagerWithProvider().
+func NewProvider() *Provider {
+	return &Provider{clock: session.SystemClock{}}
+}
+
+// storeValue holds session key-value pares.
+type storeValue map[string]interface{}
+
+// cookieRecord is a session serialized to a cookie.
+type cookieRecord struct {
+	AccessedTime time.Time
+	CreateTime   time.Time
+	ExpiresAt    time.Time //set by SessionStore.SetExpiry(), zero if not set
+	Val          storeValue
+	Rates        session.RateCounters //counters of session.AllowRate()
+}
+
+// SessionStore contains session information.
+type SessionStore struct {
+	sid           string    //encoded session
+	pder          *Provider //provider storing the session
+	mx            sync.RWMutex
+	timeAccessed  time.Time  //last modified
+	timeCreated   time.Time  //when created
+	expiresAt     time.Time  //set by SetExpiry()
+	value         storeValue //key-value pair
+	rates         session.RateCounters
+	valueModified bool
+}
+
+// Set sets inmemory value. Session is encoded on Flush.
+func (st *SessionStore) Set(key string, value interface{}) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if !reflect.DeepEqual(st.value[key], value) {
+		st.value[key] = value
+		st.valueModified = true
+		st.accessed()
+	}
+	return nil
+}
+
+func (st *SessionStore) Put(key string, value interface{}) error {
+	if err := st.Set(key, value); err != nil {
+		return err
+	}
+	return st.Flush()
+}
+
+// SetMany sets in-memory values under one lock, they are encoded by one Flush().
+func (st *SessionStore) SetMany(values map[string]interface{}) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	for key, value := range values {
+		if !reflect.DeepEqual(st.value[key], value) {
+			st.value[key] = value
+			st.valueModified = true
+			st.accessed()
+		}
+	}
+	return nil
+}
+
+// SetWithTTL sets in-memory value expiring in ttl, see session.ExpiringValue.
+func (st *SessionStore) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value[key] = session.NewExpiringValueAt(st.pder.clock.Now(), value, ttl)
+	st.valueModified = true
+	st.accessed()
+	return nil
+}
+
+// GetMany assigns in-memory values to dest pointers, see session.GetMany().
+func (st *SessionStore) GetMany(dest map[string]interface{}) error {
+	return session.GetMany(st, dest)
+}
+
+// Snapshot returns a deep copy of in-memory values without expired ones, see session.SnapshotValuesAt().
+func (st *SessionStore) Snapshot() (map[string]interface{}, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return session.SnapshotValuesAt(st.pder.clock.Now(), st.value)
+}
+
+// Restore replaces in-memory values with a copy of snapshot, they are written by Flush().
+func (st *SessionStore) Restore(snapshot map[string]interface{}) error {
+	value, err := session.CopyValues(snapshot)
+	if err != nil {
+		return err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value = value
+	st.valueModified = true
+	st.accessed()
+	return nil
+}
+
+// Flush encodes modified session to a new session ID.
+func (st *SessionStore) Flush() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if !st.valueModified {
+		return nil
+	}
+	if st.pder.expMode.TouchOnWrite() {
+		st.timeAccessed = st.pder.clock.Now()
+	}
+	sid, err := st.pder.encode(&cookieRecord{
+		AccessedTime: st.timeAccessed,
+		CreateTime:   st.timeCreated,
+		ExpiresAt:    st.expiresAt,
+		Val:          st.value,
+		Rates:        st.rates,
+	})
+	if err != nil {
+		return err
+	}
+	st.sid = sid
+	st.valueModified = false
+	return nil
+}
+
+// Get returns session value by its key. Value is retrieved from memory.
+func (st *SessionStore) Get(key string, val interface{}) error {
+	st.mx.RLock()
+	store_val, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if !ok {
+		return session.ErrKeyNotFound
+	}
+
+	// Get the type of val
+	val_type := reflect.TypeOf(val)
+
+	// Make sure val is a pointer
+	if val_type.Kind() != reflect.Ptr {
+		return session.ErrValMustBePtr
+	}
+
+	// Dereference the pointer and check if it's assignable
+	val_elem := val_type.Elem()
+	if !reflect.TypeOf(store_val).AssignableTo(val_elem) {
+		return session.ErrTypeMismatch
+	}
+
+	// Assign the value to val
+	reflect.ValueOf(val).Elem().Set(reflect.ValueOf(store_val))
+
+	return nil
+}
+
+// GetStruct retrieves session value by its key into dest pointer.
+// Value of a different type is converted, see session.AssignValue().
+func (st *SessionStore) GetStruct(key string, dest interface{}) error {
+	st.mx.RLock()
+	store_val, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if !ok {
+		return session.ErrKeyNotFound
+	}
+	return session.AssignValue(store_val, dest)
+}
+
+// GetBool returns bool value by key.
+func (st *SessionStore) GetBool(key string) bool {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	v, _ := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	if v_bool, ok := v.(bool); ok {
+		return v_bool
+	}
+	return false
+}
+
+// GetString returns string value by key.
+func (st *SessionStore) GetString(key string) string {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	v, _ := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	if v_str, ok := v.(string); ok {
+		return v_str
+
+	} else if v_str, ok := v.([]byte); ok {
+		return string(v_str)
+	}
+	return ""
+}
+
+// GetInt returns int value by key.
+func (st *SessionStore) GetInt(key string) int64 {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	v, _ := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	if v_i, ok := v.(int64); ok {
+		return v_i
+
+	} else if v_i, ok := v.(int); ok {
+		return int64(v_i)
+	}
+	return 0
+}
+
+// GetFloat returns float value by key.
+func (st *SessionStore) GetFloat(key string) float64 {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	v, _ := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	if v_f, ok := v.(float64); ok {
+		return v_f
+
+	} else if v_f, ok := v.(float32); ok {
+		return float64(v_f)
+	}
+	return 0
+}
+
+// GetDate returns time.Time value by key.
+func (st *SessionStore) GetDate(key string) time.Time {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	v, _ := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	if v_t, ok := v.(time.Time); ok {
+		return v_t
+	}
+	return time.Time{}
+}
+
+// GetBytes returns []byte value by key, string value is converted.
+func (st *SessionStore) GetBytes(key string) []byte {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	v, _ := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	if v_b, ok := v.([]byte); ok {
+		return v_b
+
+	} else if v_b, ok := v.(string); ok {
+		return []byte(v_b)
+	}
+	return nil
+}
+
+// GetStringSlice returns []string value by key.
+func (st *SessionStore) GetStringSlice(key string) []string {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	v, _ := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	if v_s, ok := v.([]string); ok {
+		return v_s
+	}
+	return nil
+}
+
+// GetOrSet assigns session value to dest, if there is no value
+// it is computed, stored and flushed under session lock, see session.GetOrSet().
+func (st *SessionStore) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	return session.GetOrSet(st, key, dest, compute)
+}
+
+// Delete deletes session value from memmory by key.
+func (st *SessionStore) Delete(key string) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if _, ok := st.value[key]; ok {
+		delete(st.value, key)
+		st.valueModified = true
+		st.accessed()
+	}
+	return nil
+}
+
+// Clear deletes all session values keeping creation time.
+func (st *SessionStore) Clear() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if len(st.value) > 0 {
+		st.value = make(storeValue)
+		st.valueModified = true
+	}
+	st.accessed()
+
+	return nil
+}
+
+// Increment adds delta to integer session value and returns the new value.
+// Missing value is treated as 0. As the session is kept on client side,
+// the increment is atomic within this session instance only.
+func (st *SessionStore) Increment(key string, delta int64) (int64, error) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	cur, _ := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	new_val, err := session.IncrementValue(cur, delta)
+	if err != nil {
+		return 0, err
+	}
+	st.value[key] = new_val
+	st.valueModified = true
+	st.accessed()
+	return new_val, nil
+}
+
+// Decrement subtracts delta from integer session value and returns the new value.
+func (st *SessionStore) Decrement(key string, delta int64) (int64, error) {
+	return st.Increment(key, -delta)
+}
+
+// CompareAndSwap sets newValue in memory if the current value equals oldValue,
+// see session.Session.CompareAndSwap(). Session must be flushed.
+func (st *SessionStore) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if cur, _ := session.LookupValueAt(st.pder.clock.Now(), st.value, key); !session.EqualValue(cur, oldValue) {
+		return false, nil
+	}
+	if newValue == nil {
+		delete(st.value, key)
+	} else {
+		st.value[key] = newValue
+	}
+	st.valueModified = true
+	st.accessed()
+	return true, nil
+}
+
+// Bucket returns a view of the session with keys prefixed by name, see session.NewBucket().
+func (st *SessionStore) Bucket(name string) session.Session {
+	return session.NewBucket(st, name)
+}
+
+// Keys returns sorted session value keys.
+func (st *SessionStore) Keys() ([]string, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	keys := make([]string, 0, len(st.value))
+	now := st.pder.clock.Now()
+	for key, v := range st.value {
+		if !session.ValueExpiredAt(now, v) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Len returns number of session values.
+func (st *SessionStore) Len() (int, error) {
+	keys, err := st.Keys()
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// SessionID returns encoded session. It changes on every Flush() of a modified session.
+func (st *SessionStore) SessionID() string {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return st.sid
+}
+
+// TimeCreated returns timeCreated property.
+func (st *SessionStore) TimeCreated() time.Time {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return st.timeCreated
+}
+
+// TimeAccessed returns timeAccessed property.
+func (st *SessionStore) TimeAccessed() time.Time {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return st.timeAccessed
+}
+
+// Lock does nothing, session is not shared between requests.
+func (st *SessionStore) Lock() error {
+	return nil
+}
+
+// Unlock does nothing.
+func (st *SessionStore) Unlock() error {
+	return nil
+}
+
+// SetExpiry sets session expiration time to now+d, the session is treated as expired
+// after that time regardless of max life and idle time. d <= 0 restores defaults.
+// Session must be flushed.
+func (st *SessionStore) SetExpiry(d time.Duration) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if d > 0 {
+		st.expiresAt = st.pder.clock.Now().Add(d)
+	} else {
+		st.expiresAt = time.Time{}
+	}
+	st.valueModified = true
+	return nil
+}
+
+// Touch updates session access time. Session must be flushed.
+// Nothing is done in session.EXPIRATION_FIXED mode.
+func (st *SessionStore) Touch() error {
+	if !st.pder.expMode.TouchOnWrite() {
+		return nil
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.timeAccessed = st.pder.clock.Now()
+	st.valueModified = true
+	return nil
+}
+
+// Allow counts action in the current window of provider clock, see session.AllowRate().
+func (st *SessionStore) Allow(action string, limit int, window time.Duration) (bool, error) {
+	return session.AllowRate(st, st.pder.clock, action, limit, window)
+}
+
+// CountRate implements session.RateCounter, counters are encoded with the session apart from values.
+// As the session is kept on client side, the count is atomic within this session instance only.
+func (st *SessionStore) CountRate(action string, now time.Time, window time.Duration) (int64, error) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	cnt := st.rates.Count(action, now, window)
+	st.valueModified = true
+	st.accessed()
+	return cnt, nil
+}
+
+// SetSensitive sets value encrypted with keys of session.SetSensitiveKeys(), see session.SetSensitive().
+func (st *SessionStore) SetSensitive(key string, value interface{}) error {
+	return session.SetSensitive(st, key, value)
+}
+
+// accessed updates in-memory access time if it is updated on every access, st.mx must be locked.
+func (st *SessionStore) accessed() {
+	if st.pder.expMode.TouchOnRead() {
+		st.timeAccessed = st.pder.clock.Now()
+	}
+}
+
+// Provider structure holds provider information.
+type Provider struct {
+	hashKey     []byte //HMAC key
+	maxLifeTime int64
+	maxIdleTime int64
+
+	expMode session.ExpirationMode //when access time is updated
+	strict  atomic.Bool            //invalid cookies are reported as unknown sessions, see SetStrictMode()
+	clock   session.Clock          //session times and expiration, see SetClock()
+
+	keyRing atomic.Pointer[session.KeyRing] //payload encryption, nil if not used
+}
+
+// NewSessionStore returns empty session store.
+func (pder *Provider) NewSessionStore() *SessionStore {
+	now := pder.clock.Now()
+	return &SessionStore{
+		pder:          pder,
+		timeAccessed:  now,
+		timeCreated:   now,
+		value:         make(storeValue),
+		rates:         make(session.RateCounters),
+		valueModified: true,
+	}
+}
+
+// SessionInit returns new empty session. Given session ID is ignored,
+// session ID is the encoded session.
+func (pder *Provider) SessionInit(sid string) (session.Session, error) {
+	if pder.hashKey == nil {
+		return nil, session.ErrProviderNotInitialized
+	}
+	store := pder.NewSessionStore()
+	if err := store.Flush(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// SessionRead verifies and decodes session from its ID.
+// session.ErrSessionExpired is returned for expired session.
+// ErrInvalidCookie is returned if signature does not match, joined with session.ErrSessionNotFound in strict mode.
+func (pder *Provider) SessionRead(sid string) (session.Session, error) {
+	if pder.hashKey == nil {
+		return nil, session.ErrProviderNotInitialized
+	}
+	rec, err := pder.decode(sid)
+	if errors.Is(err, ErrInvalidCookie) && pder.strict.Load() {
+		return nil, errors.Join(session.ErrSessionNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.IsExpiredAt(pder.clock.Now(), rec.CreateTime, rec.AccessedTime, rec.ExpiresAt, pder.maxLifeTime, pder.maxIdleTime) {
+		return nil, session.ErrSessionExpired
+	}
+	store := &SessionStore{
+		pder:         pder,
+		sid:          sid,
+		timeAccessed: rec.AccessedTime,
+		timeCreated:  rec.CreateTime,
+		expiresAt:    rec.ExpiresAt,
+		value:        rec.Val,
+		rates:        rec.Rates,
+	}
+	if store.value == nil {
+		store.value = make(storeValue)
+	}
+	if store.rates == nil {
+		store.rates = make(session.RateCounters)
+	}
+	session.PurgeExpiredValuesAt(pder.clock.Now(), store.value) //values set with SetWithTTL() are filtered on read
+	return store, nil
+}
+
+func (pder *Provider) SessionClose(sid string) error {
+	return nil
+}
+
+// SessionDestroy does nothing, the cookie must be deleted on client side.
+func (pder *Provider) SessionDestroy(sid string) error {
+	return nil
+}
+
+// SessionGC does nothing, expiration is checked on read.
+func (pder *Provider) SessionGC(l io.Writer, logLev session.LogLevel) session.GCReport {
+	return session.GCReport{}
+}
+
+// DestroyAllSessions does nothing, rotate hash key to invalidate all sessions.
+func (pder *Provider) DestroyAllSessions(l io.Writer, logLev session.LogLevel) {
+}
+
+func (pder *Provider) SetMaxLifeTime(maxLifeTime int64) {
+	pder.maxLifeTime = maxLifeTime
+}
+func (pder *Provider) GetMaxLifeTime() int64 {
+	return pder.maxLifeTime
+}
+
+func (pder *Provider) SetMaxIdleTime(maxIdleTime int64) {
+	pder.maxIdleTime = maxIdleTime
+}
+
+func (pder *Provider) GetMaxIdleTime() int64 {
+	return pder.maxIdleTime
+}
+
+// SetExpirationMode implements session.ExpirationModeSetter.
+// Access time is kept in the cookie and is written on Flush() only, so it is not updated on read.
+func (pder *Provider) SetExpirationMode(mode session.ExpirationMode) {
+	pder.expMode = mode
+}
+
+// SetStrictMode implements session.StrictModeSetter. Sessions are never created on read,
+// in strict mode SessionRead() of a cookie value which is not a valid session returns
+// session.ErrSessionNotFound joined with ErrInvalidCookie, so session.Manager.Middleware() starts a new session.
+func (pder *Provider) SetStrictMode(strict bool) error {
+	pder.strict.Store(strict)
+	return nil
+}
+
+// SetClock implements session.ClockSetter, session times and expiration checked on read
+// are of the clock. Nil restores session.SystemClock.
+func (pder *Provider) SetClock(clock session.Clock) {
+	if clock == nil {
+		clock = session.SystemClock{}
+	}
+	pder.clock = clock
+}
+
+// SetKeyRing sets keys for payload encryption.
+func (pder *Provider) SetKeyRing(keyRing *session.KeyRing) {
+	pder.keyRing.Store(keyRing)
+}
+
+// InitProvider initializes cookie provider.
+// Function expects parameters:
+//
+//	First parameter: hashKey string, HMAC key, at least 32 bytes are recommended.
+//	Second parameter (optional): encryptKey string, if set session payload is encrypted with AES-GCM.
+func (pder *Provider) InitProvider(provParams []interface{}) error {
+	if len(provParams) < 1 {
+		return errors.New("InitProvider missing parameters: hashKey")
+	}
+	hash_key, ok := provParams[0].(string)
+	if !ok || hash_key == "" {
+		return errors.New("InitProvider hashKey parameter(0) must be a non empty string")
+	}
+
+	pder.keyRing.Store(nil)
+	if len(provParams) >= 2 {
+		encrkey, ok := provParams[1].(string)
+		if !ok {
+			return errors.New("InitProvider encryptKey parameter(1) must be a string")
+		}
+		if encrkey != "" {
+			key_ring, err := session.NewKeyRing([]byte(encrkey))
+			if err != nil {
+				return err
+			}
+			pder.keyRing.Store(key_ring)
+		}
+	}
+	pder.hashKey = []byte(hash_key)
+
+	return nil
+}
+
+// CloseProvider does nothing.
+func (pder *Provider) CloseProvider() error {
+	return nil
+}
+
+// Ping checks that provider is initialized, there is no backend.
+func (pder *Provider) Ping(ctx context.Context) error {
+	if pder.hashKey == nil {
+		return session.ErrProviderNotInitialized
+	}
+	return nil
+}
+
+// GetSessionIDLen returns max length of encoded session.
+func (pder *Provider) GetSessionIDLen() int {
+	return MAX_COOKIE_LEN
+}
+
+// encode serializes, encrypts and signs session.
+func (pder *Provider) encode(rec *cookieRecord) (string, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(rec); err != nil {
+		return "", session.TypeError(err)
+	}
+	payload := b.Bytes()
+	if key_ring := pder.keyRing.Load(); key_ring != nil {
+		var err error
+		if payload, err = key_ring.Encrypt(payload); err != nil {
+			return "", err
+		}
+	}
+	sid := base64.RawURLEncoding.EncodeToString(append(payload, pder.sign(payload)...))
+	if len(sid) > MAX_COOKIE_LEN {
+		return "", ErrCookieTooLarge
+	}
+	return sid, nil
+}
+
+// decode verifies signature, decrypts and deserializes session.
+func (pder *Provider) decode(sid string) (*cookieRecord, error) {
+	if len(sid) > MAX_COOKIE_LEN {
+		return nil, ErrCookieTooLarge
+	}
+	data, err := base64.RawURLEncoding.DecodeString(sid)
+	if err != nil || len(data) < sha256.Size {
+		return nil, ErrInvalidCookie
+	}
+	payload, mac := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if !hmac.Equal(mac, pder.sign(payload)) {
+		return nil, ErrInvalidCookie
+	}
+	if key_ring := pder.keyRing.Load(); key_ring != nil {
+		if payload, err = key_ring.DecryptPayload(payload); err != nil {
+			return nil, err
+		}
+	}
+	rec := &cookieRecord{}
+	if err := gob.NewDecoder(bytes.NewBuffer(payload)).Decode(rec); err != nil {
+		return nil, session.TypeError(err)
+	}
+	return rec, nil
+}
+
+func (pder *Provider) sign(payload []byte) []byte {
+	h := hmac.New(sha256.New, pder.hashKey)
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// SessionStart starts session from the named request cookie.
+// New session is started if there is no cookie or the cookie is invalid,
+// session.ErrSessionExpired is returned for expired session.
+func SessionStart(manager *session.Manager, r *http.Request, name string) (session.Session, error) {
+	if c, err := r.Cookie(name); err == nil && c.Value != "" {
+		sess, err := manager.SessionStart(c.Value)
+		if err == nil || errors.Is(err, session.ErrSessionExpired) {
+			return sess, err
+		}
+	}
+	return manager.SessionStart("")
+}
+
+// SetCookie flushes session and writes it to response cookie.
+// cookie holds cookie attributes (Name, Path, MaxAge, Secure, HttpOnly, SameSite...), its Value is ignored.
+// Must be called before response body is written.
+func SetCookie(w http.ResponseWriter, sess session.Session, cookie http.Cookie) error {
+	if err := sess.Flush(); err != nil {
+		return err
+	}
+	cookie.Value = sess.SessionID()
+	http.SetCookie(w, &cookie)
+	return nil
+}
+
+func init() {
+	session.Register(PROVIDER, pder)
+}
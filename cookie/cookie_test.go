@@ -0,0 +1,212 @@
+// testing functions for session/cookie.
+package cookie
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dronm/session" //session manager
+	"github.com/dronm/session/testkit"
+)
+
+const (
+	HASH_KEY    = "6bU3k0LmQ2v9xR7tY1wE4pA8sD5fG2hJ"
+	ENCRYPT_KEY = "4gWv64T54583v8t410-45vkUiopgjw4gwmjRcGkck,ld"
+	COOKIE_NAME = "sess"
+)
+
+// roundTrip writes session to a response cookie and starts session from a request with this cookie.
+func roundTrip(t *testing.T, manager *session.Manager, sess session.Session) session.Session {
+	rec := httptest.NewRecorder()
+	if err := SetCookie(rec, sess, http.Cookie{Name: COOKIE_NAME, Path: "/", HttpOnly: true}); err != nil {
+		t.Fatalf("SetCookie() failed: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	sess, err := SessionStart(manager, req, COOKIE_NAME)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	return sess
+}
+
+// TestSession writes values, round trips the session via cookie and compares values,
+// plain and encrypted.
+func TestSession(t *testing.T) {
+	for _, encrypt_key := range []string{"", ENCRYPT_KEY} {
+		SessManager, err := session.NewManager(PROVIDER, 0, 0, "", HASH_KEY, encrypt_key)
+		if err != nil {
+			t.Fatalf("NewManager() failed: %v", err)
+		}
+		currentSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		tests := testkit.NewTestValues()
+		testkit.PutValues(t, currentSession, tests)
+
+		currentSession = roundTrip(t, SessManager, currentSession)
+		testkit.CompareValues(t, currentSession, tests)
+	}
+}
+
+// TestTampered checks that a modified cookie is rejected.
+func TestTampered(t *testing.T) {
+	SessManager, err := session.NewManager(PROVIDER, 0, 0, "", HASH_KEY)
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if err := currentSession.Put("role", "user"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	sid := []byte(currentSession.SessionID())
+	if sid[5] == 'A' {
+		sid[5] = 'B'
+	} else {
+		sid[5] = 'A'
+	}
+	if _, err := SessManager.SessionStart(string(sid)); !errors.Is(err, ErrInvalidCookie) {
+		t.Fatalf("SessionStart() wanted ErrInvalidCookie, got %v", err)
+	}
+
+	//other key
+	if _, err := session.NewManager(PROVIDER, 0, 0, "", HASH_KEY+"2"); err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	if _, err := SessManager.SessionStart(currentSession.SessionID()); !errors.Is(err, ErrInvalidCookie) {
+		t.Fatalf("SessionStart() wanted ErrInvalidCookie, got %v", err)
+	}
+}
+
+// TestStrictMode checks that a modified cookie is reported as unknown session in strict mode.
+func TestStrictMode(t *testing.T) {
+	SessManager, err := session.NewManager(PROVIDER, 0, 0, "", HASH_KEY)
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := []byte(currentSession.SessionID())
+	if sid[5] == 'A' {
+		sid[5] = 'B'
+	} else {
+		sid[5] = 'A'
+	}
+	if _, err := SessManager.SessionStart(string(sid)); errors.Is(err, session.ErrSessionNotFound) {
+		t.Fatalf("SessionStart() wanted ErrInvalidCookie only, got %v", err)
+	}
+	if err := SessManager.SetStrictMode(true); err != nil {
+		t.Fatalf("SetStrictMode() failed: %v", err)
+	}
+	defer SessManager.SetStrictMode(false)
+	_, err = SessManager.SessionStart(string(sid))
+	if !errors.Is(err, session.ErrSessionNotFound) || !errors.Is(err, ErrInvalidCookie) {
+		t.Fatalf("SessionStart() wanted ErrSessionNotFound and ErrInvalidCookie, got %v", err)
+	}
+}
+
+// TestIdleTime checks that reading an idle session returns ErrSessionExpired.
+func TestIdleTime(t *testing.T) {
+	SessManager, err := session.NewManager(PROVIDER, 0, 1, "", HASH_KEY)
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	clock := session.NewManualClock(time.Now())
+	SessManager.SetClock(clock)
+	defer SessManager.SetClock(nil)
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if err := currentSession.Put("key", "value"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	clock.Advance(2 * time.Second)
+	if _, err := SessManager.SessionStart(sid); !errors.Is(err, session.ErrSessionExpired) {
+		t.Fatalf("SessionStart() wanted ErrSessionExpired, got %v", err)
+	}
+}
+
+// TestConcurrentAccess uses one session from goroutines, run with -race.
+func TestConcurrentAccess(t *testing.T) {
+	SessManager, err := session.NewManager(PROVIDER, 0, 0, "", HASH_KEY)
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+
+	testkit.ConcurrentAccess(t, currentSession, 8, 50)
+}
+
+// TestMiddleware checks that values set by handlers reach the client in the session cookie
+// written by session.Middleware().
+func TestMiddleware(t *testing.T) {
+	SessManager, err := session.NewManagerWithProvider(NewProvider(), 0, 0, "", HASH_KEY, ENCRYPT_KEY)
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/set", func(w http.ResponseWriter, r *http.Request) {
+		sess, _ := session.FromContext(r.Context())
+		sess.Set("user", "user1")
+		w.Write([]byte("ok")) //header is written by the handler
+	})
+	mux.HandleFunc("/count", func(w http.ResponseWriter, r *http.Request) {
+		sess, _ := session.FromContext(r.Context())
+		sess.Set("count", sess.GetInt("count")+1) //header is written when the handler returns
+	})
+	mux.HandleFunc("/get", func(w http.ResponseWriter, r *http.Request) {
+		sess, _ := session.FromContext(r.Context())
+		w.Write([]byte(sess.GetString("user")))
+	})
+	handler := SessManager.Middleware(session.MiddlewareConfig{})(mux)
+
+	var cookie *http.Cookie
+	serve := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == session.DEFAULT_COOKIE_NAME {
+				cookie = c
+			}
+		}
+		if cookie == nil {
+			t.Fatalf("%s: session cookie is not set", path)
+		}
+		return rec
+	}
+	serve("/set")
+	serve("/count")
+	serve("/count")
+	if got := serve("/get").Body.String(); got != "user1" {
+		t.Fatalf("value set through middleware = %q, wanted user1", got)
+	}
+	sess, err := SessManager.SessionStart(cookie.Value)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if got := sess.GetInt("count"); got != 2 {
+		t.Fatalf("count = %d, wanted 2", got)
+	}
+}
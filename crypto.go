@@ -0,0 +1,92 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+)
+
+// ENCRYPTION_MAGIC is the first byte of encrypted data, it is followed by nonce and cipher text.
+// Gob streams never start with this byte, so payloads written before encryption was enabled
+// are read as plaintext, see KeyRing.DecryptPayload().
+const ENCRYPTION_MAGIC byte = 0xC7
+
+// KeyRing holds AES-GCM keys used for session payload encryption.
+// The first key is used for encryption, all keys are tried on decryption,
+// so keys can be rotated: put a new key first and keep old keys
+// until all sessions encrypted with them are gone.
+type KeyRing struct {
+	aeads []cipher.AEAD
+}
+
+// NewKeyRing creates KeyRing from the given keys.
+// Keys can be of any length, AES-256 key is derived from each key with SHA-256.
+func NewKeyRing(keys ...[]byte) (*KeyRing, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("session: NewKeyRing no keys given")
+	}
+	kr := &KeyRing{aeads: make([]cipher.AEAD, 0, len(keys))}
+	for _, key := range keys {
+		if len(key) == 0 {
+			return nil, errors.New("session: NewKeyRing empty key")
+		}
+		k := sha256.Sum256(key)
+		block, err := aes.NewCipher(k[:])
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		kr.aeads = append(kr.aeads, aead)
+	}
+	return kr, nil
+}
+
+// Encrypt encrypts data with the first key. ENCRYPTION_MAGIC and random nonce are prepended to the result.
+func (kr *KeyRing) Encrypt(data []byte) ([]byte, error) {
+	aead := kr.aeads[0]
+	out := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(data)+aead.Overhead())
+	out[0] = ENCRYPTION_MAGIC
+	nonce := out[1:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, data, nil), nil
+}
+
+// Decrypt decrypts data previously encrypted with any of the keys.
+func (kr *KeyRing) Decrypt(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != ENCRYPTION_MAGIC {
+		return nil, ErrDecryptFailed
+	}
+	return kr.open(data[1:])
+}
+
+// DecryptPayload decrypts session payload as Decrypt() does, payload without ENCRYPTION_MAGIC
+// is returned as is, so sessions written before encryption was enabled are read
+// and are encrypted on the next write. Providers use it for payloads and values.
+func (kr *KeyRing) DecryptPayload(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != ENCRYPTION_MAGIC {
+		return data, nil //plaintext
+	}
+	return kr.Decrypt(data)
+}
+
+// open decrypts nonce and cipher text with any of the keys.
+func (kr *KeyRing) open(data []byte) ([]byte, error) {
+	for _, aead := range kr.aeads {
+		if len(data) < aead.NonceSize() {
+			continue
+		}
+		nonce, cipher_text := data[:aead.NonceSize()], data[aead.NonceSize():]
+		if plain, err := aead.Open(nil, nonce, cipher_text, nil); err == nil {
+			return plain, nil
+		}
+	}
+	return nil, ErrDecryptFailed
+}
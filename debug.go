@@ -0,0 +1,162 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DebugValueMode defines how session values are shown by DebugDump().
+type DebugValueMode int
+
+const (
+	DEBUG_VALUES_REDACTED DebugValueMode = iota //values are replaced with their type and size
+	DEBUG_VALUES_HASHED                         //a hash is added, equal values of one dump have equal hashes
+)
+
+// DebugDump writes keys of n randomly sampled sessions to w, one log line per session, to diagnose
+// what bloats session storage in production: hashed session ID, age, idle time, total size
+// and for every key its value type and encoded size, the largest first.
+// Values are never written, in DEBUG_VALUES_HASHED mode a salted hash is added to spot repeated values,
+// the salt is random for every dump and is not written. Sensitive values are not decrypted,
+// they are shown as "sensitive" with encrypted size and are never hashed. Session IDs are hashed as well,
+// so the dump exposes no personal data and can not be used to take over sessions.
+// Sessions are read as with ExportSessions(), their access time is not updated by the dump
+// if provider implements PeekProvider. Returns number of dumped sessions.
+// Provider must implement AdminProvider interface.
+func (manager *Manager) DebugDump(w io.Writer, n int, mode DebugValueMode) (int, error) {
+	adm_pder, ok := manager.provider.(AdminProvider)
+	if !ok {
+		return 0, ErrNotAdminProvider
+	}
+	if n <= 0 {
+		return 0, nil
+	}
+	cnt, err := adm_pder.SessionCount()
+	if err != nil {
+		return 0, err
+	}
+	//session IDs are random, so sessions at a random offset in ID order are a random sample
+	offset := 0
+	if cnt > n {
+		offset = mrand.Intn(cnt - n + 1)
+	}
+	list, err := adm_pder.SessionList(offset, n)
+	if err != nil {
+		return 0, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return 0, err
+	}
+	now := manager.clock.Now()
+	dumped := 0
+	for _, meta := range list {
+		line, err := manager.debugSession(meta, mode, salt, now)
+		if err != nil {
+			WriteToLog(w, fmt.Sprintf("debug dump: session %s: %v", debugHash(salt, []byte(meta.ID)), err), LOG_LEVEL_ERROR)
+			continue
+		}
+		WriteToLog(w, line, LOG_LEVEL_DEBUG)
+		dumped++
+	}
+	return dumped, nil
+}
+
+// debugValue is a described session value.
+type debugValue struct {
+	key  string
+	desc string
+	size int
+}
+
+// debugSession returns dump line of a session.
+func (manager *Manager) debugSession(meta SessionMeta, mode DebugValueMode, salt []byte, now time.Time) (string, error) {
+	sess, close_sess, err := readSession(manager.provider, meta.ID)
+	if err != nil {
+		return "", err
+	}
+	defer close_sess()
+	keys, err := sess.Keys()
+	if err != nil {
+		return "", err
+	}
+	var sizes map[string]int
+	if sized, ok := sess.(SizedSession); ok {
+		if sizes, err = sized.ValueSizes(); err != nil {
+			return "", err
+		}
+	}
+	sensitive, err := sensitiveValues(sess)
+	if err != nil {
+		return "", err
+	}
+	values := make([]debugValue, 0, len(keys))
+	total := 0
+	for _, key := range keys {
+		if sv, ok := sensitive[key]; ok {
+			size, ok := sizes[key]
+			if !ok {
+				size, _ = EncodedSize(sv)
+			}
+			total += size
+			values = append(values, debugValue{key: key, desc: fmt.Sprintf("sensitive(%d)", size), size: size})
+			continue
+		}
+		var value interface{}
+		if err := sess.Get(key, &value); err != nil {
+			return "", fmt.Errorf("key %s: %w", key, err)
+		}
+		size, ok := sizes[key]
+		if !ok {
+			size, _ = EncodedSize(value)
+		}
+		total += size
+		desc := fmt.Sprintf("%T(%d)", value, size)
+		if mode == DEBUG_VALUES_HASHED {
+			desc += "#" + debugHash(salt, debugBytes(value))
+		}
+		values = append(values, debugValue{key: key, desc: desc, size: size})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].size != values[j].size {
+			return values[i].size > values[j].size
+		}
+		return values[i].key < values[j].key
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "debug dump: session %s", debugHash(salt, []byte(meta.ID)))
+	//times of the list, SessionRead() of providers not implementing PeekProvider updates access time
+	if !meta.TimeCreated.IsZero() {
+		fmt.Fprintf(&b, " age %v", now.Sub(meta.TimeCreated).Truncate(time.Second))
+	}
+	if !meta.TimeAccessed.IsZero() {
+		fmt.Fprintf(&b, " idle %v", now.Sub(meta.TimeAccessed).Truncate(time.Second))
+	}
+	fmt.Fprintf(&b, " keys %d size %d:", len(values), total)
+	for _, v := range values {
+		b.WriteString(" " + v.key + "=" + v.desc)
+	}
+	return b.String(), nil
+}
+
+// debugBytes returns text representation of value, maps are printed with sorted keys,
+// so equal values have equal representations.
+func debugBytes(value interface{}) []byte {
+	return []byte(fmt.Sprintf("%#v", value))
+}
+
+// debugHash returns short salted SHA-256 hash of data.
+func debugHash(salt, data []byte) string {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)[:6])
+}
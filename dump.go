@@ -0,0 +1,122 @@
+package session
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DUMP_FORMAT identifies session dump streams.
+const DUMP_FORMAT = "dronm/session dump"
+
+// DUMP_VERSION is a version of session dump format written by ExportSessions().
+const DUMP_VERSION = 1
+
+// dumpHeader starts a session dump.
+type dumpHeader struct {
+	Format  string
+	Version int
+	Created time.Time
+}
+
+// dumpRecord is a dumped session.
+type dumpRecord struct {
+	ID           string
+	TimeCreated  time.Time
+	TimeAccessed time.Time
+	Values       map[string]interface{}
+}
+
+// ExportSessions writes all sessions with their values to w as a gob stream:
+// a header with format version followed by a record for every session.
+// Custom value types must be registered with RegisterType().
+// Sessions are read with SessionPeek() if provider implements PeekProvider, so their access time is not updated.
+// Expired sessions and sessions removed while exporting are skipped, sensitive values are left out, see Session.SetSensitive().
+// Values are taken with Session.Snapshot(), values set with Session.SetWithTTL() keep their expiration time.
+// Returns number of exported sessions.
+// Provider must implement AdminProvider interface.
+func (manager *Manager) ExportSessions(w io.Writer) (int, error) {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(dumpHeader{Format: DUMP_FORMAT, Version: DUMP_VERSION, Created: manager.clock.Now()}); err != nil {
+		return 0, err
+	}
+	cnt := 0
+	for offset := 0; ; offset += LIST_PAGE_SIZE {
+		list, err := manager.ListSessions(offset, LIST_PAGE_SIZE)
+		if err != nil {
+			return cnt, err
+		}
+		for _, meta := range list {
+			rec, err := manager.dumpSession(meta)
+			if errors.Is(err, ErrSessionExpired) || errors.Is(err, ErrSessionNotFound) {
+				continue //expired or removed after listing
+			}
+			if err != nil {
+				return cnt, fmt.Errorf("session %s: %w", meta.ID, err)
+			}
+			if err := enc.Encode(rec); err != nil {
+				return cnt, fmt.Errorf("session %s: %w", meta.ID, TypeError(err))
+			}
+			cnt++
+		}
+		if len(list) < LIST_PAGE_SIZE {
+			break
+		}
+	}
+	return cnt, nil
+}
+
+func (manager *Manager) dumpSession(meta SessionMeta) (*dumpRecord, error) {
+	sess, close_sess, err := readSession(manager.provider, meta.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer close_sess()
+	values, err := sessionValues(sess)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		if ev, ok := value.(ExpiringValue); ok {
+			value = ev.Value
+		}
+		if _, ok := value.(SensitiveValue); ok {
+			delete(values, key)
+		}
+	}
+	return &dumpRecord{ID: meta.ID, TimeCreated: meta.TimeCreated, TimeAccessed: meta.TimeAccessed, Values: values}, nil
+}
+
+// ImportSessions reads sessions written by ExportSessions() from r,
+// e.g. to restore a backup or to seed a test environment.
+// Sessions are created with the same IDs, values of existing sessions are replaced.
+// Creation and access times are not restored, imported sessions are created at the moment.
+// Returns number of imported sessions.
+func (manager *Manager) ImportSessions(r io.Reader) (int, error) {
+	dec := gob.NewDecoder(r)
+	var header dumpHeader
+	if err := dec.Decode(&header); err != nil {
+		return 0, fmt.Errorf("session: dump header: %w", err)
+	}
+	if header.Format != DUMP_FORMAT {
+		return 0, errors.New("session: not a session dump")
+	}
+	if header.Version > DUMP_VERSION {
+		return 0, fmt.Errorf("session: unsupported dump version %d", header.Version)
+	}
+	cnt := 0
+	for {
+		var rec dumpRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return cnt, nil
+		} else if err != nil {
+			return cnt, TypeError(err)
+		}
+		if err := replaceValues(manager.provider, rec.ID, rec.Values); err != nil {
+			return cnt, fmt.Errorf("session %s: %w", rec.ID, err)
+		}
+		cnt++
+	}
+}
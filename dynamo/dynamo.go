@@ -0,0 +1,1356 @@
+// Package dynamo contains AWS DynamoDB session provider based on aws-sdk-go-v2.
+// Requirements:
+//
+//	aws-sdk-go-v2 https://github.com/aws/aws-sdk-go-v2
+//	Table with string partition key "id" must exist,
+//	Time to Live must be enabled on "expires_at" attribute.
+//
+// Each session is kept in one item keyed by session ID. Max life time is mapped to the native TTL attribute,
+// so expired sessions are deleted by DynamoDB itself, SessionGC only handles idle sessions.
+//
+// Internally gob encoder is used for data serialization. Session data is read at start and kept in memory SessionStore structure.
+// Session key-value pares are kept in storeValue type.
+package dynamo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/dronm/session"
+)
+
+// Deprecated: use session.ErrKeyNotFound.
+var EKeyNotFound = session.ErrKeyNotFound
+
+// Deprecated: use session.ErrValMustBePtr.
+var EValMustBePtr = session.ErrValMustBePtr
+
+// Session key ID length.
+const SESS_ID_LEN = 36
+
+const PROVIDER = "dynamodb"
+
+const LOG_PREF = "dynamodb provider:"
+
+// Max transaction attempts for atomic value updates.
+const INCR_MAX_RETRIES = 100
+
+// Item attributes.
+const (
+	ATTR_ID            = "id"
+	ATTR_ACCESSED_TIME = "accessed_time" //unix time
+	ATTR_CREATE_TIME   = "create_time"   //unix time
+	ATTR_VAL           = "val"
+	ATTR_EXPIRES_AT    = "expires_at" //TTL attribute, unix time
+	ATTR_VERSION       = "version"    //incremented on every value write
+	ATTR_LOCK_TOKEN    = "lock_token"
+	ATTR_LOCK_TILL     = "lock_till"  //unix time in milliseconds
+	ATTR_EXPIRY_SET    = "expiry_set" //true if expires_at is set by SessionStore.SetExpiry()
+	ATTR_RATES         = "rates"      //counters of session.AllowRate(), see session.EncodeRates()
+)
+
+// attrNames are expression placeholders for attributes, all attribute names are
+// substituted as some of them are DynamoDB reserved words.
+var attrNames = map[string]string{
+	"#id":  ATTR_ID,
+	"#acc": ATTR_ACCESSED_TIME,
+	"#cr":  ATTR_CREATE_TIME,
+	"#val": ATTR_VAL,
+	"#exp": ATTR_EXPIRES_AT,
+	"#ver": ATTR_VERSION,
+	"#lk":  ATTR_LOCK_TOKEN,
+	"#lt":  ATTR_LOCK_TILL,
+	"#es":  ATTR_EXPIRY_SET,
+	"#rt":  ATTR_RATES,
+}
+
+// pder holds pointer to Provider struct registered as PROVIDER.
+var pder = NewProvider()
+
+// NewProvider returns a provider independent of the registered one, e.g. to use another table
+// in another Manager created with session.NewManagerWithProvider().
+func NewProvider() *Provider {
+	return &Provider{clock: session.SystemClock{}}
+}
+
+// storeValue holds session key-value pares.
+type storeValue map[string]interface{}
+
+// SessionStore contains session information.
+type SessionStore struct {
+	sid           string    //session id
+	pder          *Provider //provider storing the session
+	mx            sync.RWMutex
+	timeAccessed  atomic.Int64 //last access in Unix nanoseconds, updated under read lock by getters
+	timeCreated   time.Time    //when created
+	value         storeValue   //key-value pair
+	valueModified bool
+	lockToken     string //set when session is locked
+}
+
+// Set sets inmemory value. No database flush is done.
+func (st *SessionStore) Set(key string, value interface{}) error {
+	//type assertion is needed
+	/*
+		var v interface{}
+
+		switch value.(type) {
+		case int:
+			v = int64(value.(int))
+		case int32:
+			v = int64(value.(int32))
+		case float32:
+			v = float64(value.(float32))
+		default:
+			v = value
+		}
+	*/
+	if !reflect.DeepEqual(st.value[key], value) {
+		st.mx.Lock()
+		st.value[key] = value
+		st.valueModified = true
+		st.accessed()
+		st.mx.Unlock()
+	}
+	return nil
+}
+
+func (st *SessionStore) Put(key string, value interface{}) error {
+	if err := st.Set(key, value); err != nil {
+		return err
+	}
+	return st.Flush()
+}
+
+// SetMany sets in-memory values under one lock, they are written by one Flush().
+func (st *SessionStore) SetMany(values map[string]interface{}) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	for key, value := range values {
+		if !reflect.DeepEqual(st.value[key], value) {
+			st.value[key] = value
+			st.valueModified = true
+			st.accessed()
+		}
+	}
+	return nil
+}
+
+// SetWithTTL sets in-memory value expiring in ttl, see session.ExpiringValue.
+func (st *SessionStore) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value[key] = session.NewExpiringValueAt(st.pder.clock.Now(), value, ttl)
+	st.valueModified = true
+	st.accessed()
+	return nil
+}
+
+// GetMany assigns in-memory values to dest pointers, see session.GetMany().
+func (st *SessionStore) GetMany(dest map[string]interface{}) error {
+	return session.GetMany(st, dest)
+}
+
+// Snapshot returns a deep copy of in-memory values without expired ones, see session.SnapshotValuesAt().
+func (st *SessionStore) Snapshot() (map[string]interface{}, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return session.SnapshotValuesAt(st.pder.clock.Now(), st.value)
+}
+
+// Restore replaces in-memory values with a copy of snapshot, they are written by Flush().
+func (st *SessionStore) Restore(snapshot map[string]interface{}) error {
+	value, err := session.CopyValues(snapshot)
+	if err != nil {
+		return err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value = value
+	st.valueModified = true
+	st.accessed()
+	return nil
+}
+
+// Flush performs the actual write to database.
+func (st *SessionStore) Flush() error {
+	//flush val only if it's been modified
+	if st.valueModified {
+		//modified
+		val, err := st.pder.getForDb(&st.value)
+		if err != nil {
+			return err
+		}
+
+		st.mx.Lock()
+		defer st.mx.Unlock()
+
+		if _, err := st.pder.client.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(st.pder.table),
+			Key:                       itemKey(st.sid),
+			UpdateExpression:          aws.String("SET #val = :val, #acc = " + st.pder.accessedExpr(false) + " ADD #ver :one"),
+			ExpressionAttributeNames:  exprNames("#val", "#acc", "#ver"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":val": &types.AttributeValueMemberB{Value: val}, ":now": numAttr(st.pder.clock.Now().Unix()), ":one": numAttr(1)},
+		}); err != nil {
+			return err
+		}
+		st.valueModified = false
+		st.written()
+	}
+
+	return nil
+}
+
+// Get returns session value by its key. Value is retrieved from memory.
+func (st *SessionStore) Get(key string, val interface{}) error {
+	st.mx.RLock()
+	store_val, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if !ok {
+		return session.ErrKeyNotFound
+	}
+
+	// Get the type of val
+	val_type := reflect.TypeOf(val)
+
+	// Make sure val is a pointer
+	if val_type.Kind() != reflect.Ptr {
+		return session.ErrValMustBePtr
+	}
+
+	// Dereference the pointer and check if it's assignable
+	val_elem := val_type.Elem()
+	if !reflect.TypeOf(store_val).AssignableTo(val_elem) {
+		return session.ErrTypeMismatch
+	}
+
+	// Assign the value to val
+	reflect.ValueOf(val).Elem().Set(reflect.ValueOf(store_val))
+
+	return nil
+}
+
+// GetStruct retrieves session value by its key into dest pointer.
+// Value of a different type is converted, see session.AssignValue().
+func (st *SessionStore) GetStruct(key string, dest interface{}) error {
+	st.mx.RLock()
+	store_val, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if !ok {
+		return session.ErrKeyNotFound
+	}
+	return session.AssignValue(store_val, dest)
+}
+
+// GetBool returns bool value by key.
+func (st *SessionStore) GetBool(key string) bool {
+	v, ok := st.lookup(key)
+	if !ok {
+		return false
+	}
+
+	if v_bool, ok := v.(bool); ok {
+		return v_bool
+	}
+	return false
+}
+
+// GetString returns string value by key.
+func (st *SessionStore) GetString(key string) string {
+	v, ok := st.lookup(key)
+	if !ok {
+		return ""
+	}
+
+	if v_str, ok := v.(string); ok {
+		return v_str
+
+	} else if v_str, ok := v.([]byte); ok {
+		return string(v_str)
+	}
+	return ""
+}
+
+// GetInt returns int value by key.
+func (st *SessionStore) GetInt(key string) int64 {
+	v, ok := st.lookup(key)
+	if !ok {
+		return 0
+	}
+
+	if v_i, ok := v.(int64); ok {
+		return v_i
+
+	} else if v_i, ok := v.(int); ok {
+		return int64(v_i)
+	}
+	return 0
+}
+
+// GetFloat returns float value by key.
+func (st *SessionStore) GetFloat(key string) float64 {
+	v, ok := st.lookup(key)
+	if !ok {
+		return 0
+	}
+
+	if v_f, ok := v.(float64); ok {
+		return v_f
+
+	} else if v_f, ok := v.(float32); ok {
+		return float64(v_f)
+	}
+	return 0
+}
+
+// GetDate returns time.Time value by key.
+func (st *SessionStore) GetDate(key string) time.Time {
+	v, ok := st.lookup(key)
+	if !ok {
+		return time.Time{}
+	}
+
+	if v_t, ok := v.(time.Time); ok {
+		return v_t
+	}
+	return time.Time{}
+}
+
+// GetBytes returns []byte value by key, string value is converted.
+func (st *SessionStore) GetBytes(key string) []byte {
+	v, ok := st.lookup(key)
+	if !ok {
+		return nil
+	}
+
+	if v_b, ok := v.([]byte); ok {
+		return v_b
+
+	} else if v_b, ok := v.(string); ok {
+		return []byte(v_b)
+	}
+	return nil
+}
+
+// GetStringSlice returns []string value by key.
+func (st *SessionStore) GetStringSlice(key string) []string {
+	v, ok := st.lookup(key)
+	if !ok {
+		return nil
+	}
+
+	if v_s, ok := v.([]string); ok {
+		return v_s
+	}
+	return nil
+}
+
+// GetOrSet assigns session value to dest, if there is no value
+// it is computed, stored and flushed under session lock, see session.GetOrSet().
+func (st *SessionStore) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	return session.GetOrSet(st, key, dest, compute)
+}
+
+// Delete deletes session value from memmory by key. No flushing is done.
+func (st *SessionStore) Delete(key string) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if _, ok := st.value[key]; !ok {
+		return nil
+	}
+	st.accessed()
+	delete(st.value, key)
+	st.valueModified = true
+
+	return nil
+}
+
+// Clear deletes all session values from memory keeping session ID and creation time. No flushing is done.
+func (st *SessionStore) Clear() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if len(st.value) > 0 {
+		st.value = make(storeValue)
+		st.valueModified = true
+	}
+	st.accessed()
+
+	return nil
+}
+
+// Increment atomically adds delta to integer session value and returns the new value.
+// Missing value is treated as 0. Optimistic locking on version attribute is used,
+// update is retried up to INCR_MAX_RETRIES times on concurrent modification.
+// Other modified in-memory values are not flushed.
+func (st *SessionStore) Increment(key string, delta int64) (int64, error) {
+	ctx := context.Background()
+	for i := 0; i < INCR_MAX_RETRIES; i++ {
+		out, err := st.pder.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(st.pder.table),
+			Key:            itemKey(st.sid),
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return 0, err
+		}
+		db_value := make(storeValue)
+		if err := st.pder.setFromDb(&db_value, bytesAttr(out.Item, ATTR_VAL)); err != nil {
+			return 0, err
+		}
+		cur, _ := session.LookupValueAt(st.pder.clock.Now(), db_value, key)
+		new_val, err := session.IncrementValue(cur, delta)
+		if err != nil {
+			return 0, err
+		}
+		db_value[key] = new_val
+		val, err := st.pder.getForDb(&db_value)
+		if err != nil {
+			return 0, err
+		}
+
+		values := map[string]types.AttributeValue{
+			":val": &types.AttributeValueMemberB{Value: val},
+			":now": numAttr(st.pder.clock.Now().Unix()),
+			":one": numAttr(1),
+		}
+		cond := "attribute_not_exists(#ver)"
+		if _, ok := out.Item[ATTR_VERSION]; ok {
+			cond = "#ver = :ver"
+			values[":ver"] = numAttr(numValue(out.Item, ATTR_VERSION))
+		}
+		_, err = st.pder.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(st.pder.table),
+			Key:                       itemKey(st.sid),
+			UpdateExpression:          aws.String("SET #val = :val, #acc = " + st.pder.accessedExpr(false) + " ADD #ver :one"),
+			ConditionExpression:       aws.String(cond),
+			ExpressionAttributeNames:  exprNames("#val", "#acc", "#ver"),
+			ExpressionAttributeValues: values,
+		})
+		if isConditionFailed(err) {
+			continue //value modified concurrently
+		} else if err != nil {
+			return 0, err
+		}
+
+		st.mx.Lock()
+		st.value[key] = new_val
+		st.written()
+		st.mx.Unlock()
+
+		return new_val, nil
+	}
+	return 0, errors.New("Increment: max retries exceeded")
+}
+
+// Decrement atomically subtracts delta from integer session value and returns the new value.
+func (st *SessionStore) Decrement(key string, delta int64) (int64, error) {
+	return st.Increment(key, -delta)
+}
+
+// CompareAndSwap sets newValue if the stored value equals oldValue.
+// Item is updated on condition its version is not changed, the operation is retried otherwise,
+// see session.Session.CompareAndSwap().
+func (st *SessionStore) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
+	ctx := context.Background()
+	for i := 0; i < INCR_MAX_RETRIES; i++ {
+		out, err := st.pder.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(st.pder.table),
+			Key:            itemKey(st.sid),
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return false, err
+		}
+		db_value := make(storeValue)
+		if err := st.pder.setFromDb(&db_value, bytesAttr(out.Item, ATTR_VAL)); err != nil {
+			return false, err
+		}
+		if cur, _ := session.LookupValueAt(st.pder.clock.Now(), db_value, key); !session.EqualValue(cur, oldValue) {
+			return false, nil
+		}
+		if newValue == nil {
+			delete(db_value, key)
+		} else {
+			db_value[key] = newValue
+		}
+		val, err := st.pder.getForDb(&db_value)
+		if err != nil {
+			return false, err
+		}
+
+		values := map[string]types.AttributeValue{
+			":val": &types.AttributeValueMemberB{Value: val},
+			":now": numAttr(st.pder.clock.Now().Unix()),
+			":one": numAttr(1),
+		}
+		cond := "attribute_not_exists(#ver)"
+		if _, ok := out.Item[ATTR_VERSION]; ok {
+			cond = "#ver = :ver"
+			values[":ver"] = numAttr(numValue(out.Item, ATTR_VERSION))
+		}
+		_, err = st.pder.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(st.pder.table),
+			Key:                       itemKey(st.sid),
+			UpdateExpression:          aws.String("SET #val = :val, #acc = " + st.pder.accessedExpr(false) + " ADD #ver :one"),
+			ConditionExpression:       aws.String(cond),
+			ExpressionAttributeNames:  exprNames("#val", "#acc", "#ver"),
+			ExpressionAttributeValues: values,
+		})
+		if isConditionFailed(err) {
+			continue //value modified concurrently
+		} else if err != nil {
+			return false, err
+		}
+
+		st.mx.Lock()
+		if newValue == nil {
+			delete(st.value, key)
+		} else {
+			st.value[key] = newValue
+		}
+		st.written()
+		st.mx.Unlock()
+
+		return true, nil
+	}
+	return false, errors.New("CompareAndSwap: max retries exceeded")
+}
+
+// Bucket returns a view of the session with keys prefixed by name, see session.NewBucket().
+func (st *SessionStore) Bucket(name string) session.Session {
+	return session.NewBucket(st, name)
+}
+
+// Keys returns sorted session value keys.
+func (st *SessionStore) Keys() ([]string, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	keys := make([]string, 0, len(st.value))
+	now := st.pder.clock.Now()
+	for key, v := range st.value {
+		if !session.ValueExpiredAt(now, v) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Len returns number of session values.
+func (st *SessionStore) Len() (int, error) {
+	keys, err := st.Keys()
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// SessionID returns session unique ID.
+func (st *SessionStore) SessionID() string {
+	return st.sid
+}
+
+// TimeCreated returns timeCreated property.
+func (st *SessionStore) TimeCreated() time.Time {
+	return st.timeCreated
+}
+
+// TimeAccessed returns timeAccessed property.
+func (st *SessionStore) TimeAccessed() time.Time {
+	return time.Unix(0, st.timeAccessed.Load())
+}
+
+// Lock acquires session lock. Lock token and expiration time are kept in session item
+// with conditional update, lock is released automatically after session.LockTTL().
+// Values are reloaded from database after the lock is acquired.
+func (st *SessionStore) Lock() error {
+	token, err := genLockToken()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), session.LOCK_WAIT)
+	defer cancel()
+	for {
+		now := st.pder.clock.Now()
+		_, err := st.pder.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                aws.String(st.pder.table),
+			Key:                      itemKey(st.sid),
+			UpdateExpression:         aws.String("SET #lk = :token, #lt = :till"),
+			ConditionExpression:      aws.String("attribute_not_exists(#lt) OR #lt < :now"),
+			ExpressionAttributeNames: exprNames("#lk", "#lt"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":token": &types.AttributeValueMemberS{Value: token},
+				":till":  numAttr(now.Add(session.LockTTL()).UnixMilli()),
+				":now":   numAttr(now.UnixMilli()),
+			},
+		})
+		if err == nil {
+			break
+		}
+		if !isConditionFailed(err) {
+			if ctx.Err() != nil {
+				return session.ErrLockTimeout
+			}
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return session.ErrLockTimeout
+		case <-time.After(session.LOCK_RETRY):
+		}
+	}
+
+	st.mx.Lock()
+	st.lockToken = token
+	st.mx.Unlock()
+
+	return st.reload()
+}
+
+// Unlock releases session lock.
+func (st *SessionStore) Unlock() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if st.lockToken == "" {
+		return nil
+	}
+	if _, err := st.pder.client.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(st.pder.table),
+		Key:                       itemKey(st.sid),
+		UpdateExpression:          aws.String("REMOVE #lk, #lt"),
+		ConditionExpression:       aws.String("#lk = :token"),
+		ExpressionAttributeNames:  exprNames("#lk", "#lt"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":token": &types.AttributeValueMemberS{Value: st.lockToken}},
+	}); err != nil && !isConditionFailed(err) {
+		return err
+	}
+	st.lockToken = ""
+	return nil
+}
+
+// SetExpiry sets session expiration time to now+d, the session is deleted by DynamoDB TTL
+// after that time regardless of max life and idle time. d <= 0 restores defaults.
+func (st *SessionStore) SetExpiry(d time.Duration) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName:           aws.String(st.pder.table),
+		Key:                 itemKey(st.sid),
+		ConditionExpression: aws.String("attribute_exists(#id)"),
+	}
+	if d > 0 {
+		input.UpdateExpression = aws.String("SET #exp = :exp, #es = :true")
+		input.ExpressionAttributeNames = exprNames("#id", "#exp", "#es")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":exp":  numAttr(st.pder.clock.Now().Add(d).Unix()),
+			":true": &types.AttributeValueMemberBOOL{Value: true},
+		}
+	} else if st.pder.maxLifeTime > 0 {
+		input.UpdateExpression = aws.String("SET #exp = #cr + :life REMOVE #es")
+		input.ExpressionAttributeNames = exprNames("#id", "#exp", "#cr", "#es")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{":life": numAttr(st.pder.maxLifeTime)}
+	} else {
+		input.UpdateExpression = aws.String("REMOVE #exp, #es")
+		input.ExpressionAttributeNames = exprNames("#id", "#exp", "#es")
+	}
+	if _, err := st.pder.client.UpdateItem(context.Background(), input); isConditionFailed(err) {
+		return session.ErrSessionNotFound
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Touch updates session access time in database,
+// nothing is done in session.EXPIRATION_FIXED mode.
+func (st *SessionStore) Touch() error {
+	if !st.pder.expMode.TouchOnWrite() {
+		return nil
+	}
+	now := st.pder.clock.Now()
+	if _, err := st.pder.client.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(st.pder.table),
+		Key:                       itemKey(st.sid),
+		UpdateExpression:          aws.String("SET #acc = :now"),
+		ConditionExpression:       aws.String("attribute_exists(#id)"),
+		ExpressionAttributeNames:  exprNames("#id", "#acc"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":now": numAttr(now.Unix())},
+	}); isConditionFailed(err) {
+		return session.ErrSessionNotFound
+	} else if err != nil {
+		return err
+	}
+	st.timeAccessed.Store(now.UnixNano())
+	return nil
+}
+
+// Allow counts action in the current window of provider clock, see session.AllowRate().
+func (st *SessionStore) Allow(action string, limit int, window time.Duration) (bool, error) {
+	return session.AllowRate(st, st.pder.clock, action, limit, window)
+}
+
+// CountRate implements session.RateCounter, counters are kept in rates attribute.
+// Item is updated on condition the counters are not changed, the update is retried
+// up to INCR_MAX_RETRIES times otherwise. Value version is not changed.
+func (st *SessionStore) CountRate(action string, now time.Time, window time.Duration) (int64, error) {
+	ctx := context.Background()
+	for i := 0; i < INCR_MAX_RETRIES; i++ {
+		out, err := st.pder.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(st.pder.table),
+			Key:            itemKey(st.sid),
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return 0, err
+		}
+		old_rates := bytesAttr(out.Item, ATTR_RATES)
+		rates, err := session.DecodeRates(old_rates)
+		if err != nil {
+			return 0, err
+		}
+		cnt := rates.Count(action, now, window)
+		rates_b, err := session.EncodeRates(rates)
+		if err != nil {
+			return 0, err
+		}
+
+		values := map[string]types.AttributeValue{
+			":rt":  &types.AttributeValueMemberB{Value: rates_b},
+			":now": numAttr(st.pder.clock.Now().Unix()),
+		}
+		cond := "attribute_not_exists(#rt)"
+		if _, ok := out.Item[ATTR_RATES]; ok {
+			cond = "#rt = :old"
+			values[":old"] = &types.AttributeValueMemberB{Value: old_rates}
+		}
+		_, err = st.pder.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(st.pder.table),
+			Key:                       itemKey(st.sid),
+			UpdateExpression:          aws.String("SET #rt = :rt, #acc = " + st.pder.accessedExpr(false)),
+			ConditionExpression:       aws.String(cond),
+			ExpressionAttributeNames:  exprNames("#rt", "#acc"),
+			ExpressionAttributeValues: values,
+		})
+		if isConditionFailed(err) {
+			continue //counters modified concurrently
+		} else if err != nil {
+			return 0, err
+		}
+		return cnt, nil
+	}
+	return 0, errors.New("CountRate: max retries exceeded")
+}
+
+// SetSensitive sets value encrypted with keys of session.SetSensitiveKeys(), see session.SetSensitive().
+func (st *SessionStore) SetSensitive(key string, value interface{}) error {
+	return session.SetSensitive(st, key, value)
+}
+
+// lookup returns in-memory value by key under read lock and updates access time on success,
+// so concurrent readers do not serialize.
+func (st *SessionStore) lookup(key string) (interface{}, bool) {
+	st.mx.RLock()
+	v, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if ok {
+		st.accessed()
+	}
+	return v, ok
+}
+
+// accessed updates in-memory access time if it is updated on every access.
+// The time is stored atomically, st.mx is not required.
+func (st *SessionStore) accessed() {
+	if st.pder.expMode.TouchOnRead() {
+		st.timeAccessed.Store(st.pder.clock.Now().UnixNano())
+	}
+}
+
+// written updates in-memory access time after session is written to database.
+func (st *SessionStore) written() {
+	if st.pder.expMode.TouchOnWrite() {
+		st.timeAccessed.Store(st.pder.clock.Now().UnixNano())
+	}
+}
+
+// reload reads values from database to memory.
+func (st *SessionStore) reload() error {
+	out, err := st.pder.client.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName:      aws.String(st.pder.table),
+		Key:            itemKey(st.sid),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+	value := make(storeValue)
+	if err := st.pder.setFromDb(&value, bytesAttr(out.Item, ATTR_VAL)); err != nil {
+		return err
+	}
+	st.mx.Lock()
+	st.value = value
+	st.valueModified = false
+	st.mx.Unlock()
+	return nil
+}
+
+// Provider structure holds provider information.
+type Provider struct {
+	client         *dynamodb.Client
+	table          string
+	payloadVersion *session.PayloadVersion //payload versions, nil if not used
+	maxLifeTime    int64
+	maxIdleTime    int64
+	logger         *slog.Logger        //structured logger, nil if not set
+	expiredHook    session.SessionHook //called for sessions removed by SessionGC
+	gcLimit        int                 //max sessions removed by one SessionGC run, 0 if not limited
+
+	expMode session.ExpirationMode //when access time attribute is updated
+	strict  bool                   //unknown sessions are not created on read, see SetStrictMode()
+	clock   session.Clock          //session times and expiration, see SetClock()
+
+	keyRing atomic.Pointer[session.KeyRing] //payload encryption, nil if not used
+}
+
+func (pder *Provider) NewSessionStore(sid string) *SessionStore {
+	store := &SessionStore{
+		pder:        pder,
+		sid:         sid,
+		timeCreated: pder.clock.Now(),
+		value:       make(map[string]interface{}, 0),
+	}
+	store.timeAccessed.Store(store.timeCreated.UnixNano())
+	return store
+}
+
+// SessionInit initializes session with given ID.
+// Expiration attribute is set if max life time is set.
+func (pder *Provider) SessionInit(sid string) (session.Session, error) {
+	if pder.client == nil {
+		return nil, session.ErrProviderNotInitialized
+	}
+
+	if len(sid) > SESS_ID_LEN {
+		return nil, fmt.Errorf("%w: length exceeds %d", session.ErrInvalidSessionID, SESS_ID_LEN)
+	}
+
+	store := pder.NewSessionStore(sid)
+	now := store.timeCreated.Unix()
+	item := itemKey(sid)
+	item[ATTR_CREATE_TIME] = numAttr(now)
+	item[ATTR_ACCESSED_TIME] = numAttr(now)
+	if pder.maxLifeTime > 0 {
+		item[ATTR_EXPIRES_AT] = numAttr(now + pder.maxLifeTime)
+	}
+	//existing item is replaced only if it is expired, but not yet deleted by DynamoDB
+	if _, err := pder.client.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName:                 aws.String(pder.table),
+		Item:                      item,
+		ConditionExpression:       aws.String("attribute_not_exists(#id) OR #exp <= :now"),
+		ExpressionAttributeNames:  exprNames("#id", "#exp"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":now": numAttr(now)},
+	}); err != nil && !isConditionFailed(err) {
+		return nil, err
+	}
+	return store, nil
+}
+
+// SessionRead reads session data from db to memory.
+// Expired session is destroyed, session.ErrSessionExpired is returned then,
+// including expired items which are not deleted by DynamoDB yet.
+func (pder *Provider) SessionRead(sid string) (session.Session, error) {
+	if pder.client == nil {
+		return nil, session.ErrProviderNotInitialized
+	}
+
+	now := pder.clock.Now().Unix()
+	out, err := pder.client.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(pder.table),
+		Key:                       itemKey(sid),
+		UpdateExpression:          aws.String("SET #acc = " + pder.accessedExpr(true)),
+		ConditionExpression:       aws.String("attribute_exists(#id)"),
+		ExpressionAttributeNames:  exprNames("#id", "#acc"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":now": numAttr(now)},
+		ReturnValues:              types.ReturnValueAllOld,
+	})
+	if isConditionFailed(err) {
+		//no such session
+		if pder.strict {
+			return nil, session.ErrSessionNotFound
+		}
+		return pder.SessionInit(sid)
+
+	} else if err != nil {
+		return nil, err
+	}
+
+	//values before update
+	prev_accessed := time.Unix(numValue(out.Attributes, ATTR_ACCESSED_TIME), 0)
+	created := time.Unix(numValue(out.Attributes, ATTR_CREATE_TIME), 0)
+	var expired bool
+	if _, ok := out.Attributes[ATTR_EXPIRY_SET]; ok {
+		expired = session.IsExpiredAt(pder.clock.Now(), created, prev_accessed, time.Unix(numValue(out.Attributes, ATTR_EXPIRES_AT), 0), 0, 0)
+	} else {
+		expired = session.IsExpiredAt(pder.clock.Now(), created, prev_accessed, time.Time{}, pder.maxLifeTime, pder.maxIdleTime)
+	}
+	if expired {
+		if err := pder.SessionDestroy(sid); err != nil {
+			return nil, err
+		}
+		return nil, session.ErrSessionExpired
+	}
+
+	store := pder.NewSessionStore(sid)
+	store.timeAccessed.Store(time.Unix(now, 0).UnixNano())
+	if !pder.expMode.TouchOnRead() {
+		store.timeAccessed.Store(prev_accessed.UnixNano())
+	}
+	store.timeCreated = created
+	if err := pder.setFromDb(&store.value, bytesAttr(out.Attributes, ATTR_VAL)); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (pder *Provider) SessionClose(sid string) error {
+	return nil
+}
+
+// SessionDestroy destoys session by its ID.
+func (pder *Provider) SessionDestroy(sid string) error {
+	_, err := pder.client.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(pder.table),
+		Key:       itemKey(sid),
+	})
+	return err
+}
+
+// SessionGC clears idle sessions with table scan.
+// Max life time is controled by DynamoDB TTL.
+// Sessions with expiration time set by SessionStore.SetExpiry() are not checked for idling.
+func (pder *Provider) SessionGC(l io.Writer, logLev session.LogLevel) session.GCReport {
+	//life time is controled by DynamoDB
+	if pder.maxIdleTime == 0 {
+		return session.GCReport{}
+	}
+
+	log := pder.getLogger(l, logLev).With(session.LOG_KEY_OPERATION, "SessionGC")
+	start := time.Now()
+	deleted, err := pder.deleteOnScan(&dynamodb.ScanInput{
+		TableName:                 aws.String(pder.table),
+		ProjectionExpression:      aws.String("#id"),
+		FilterExpression:          aws.String("#acc <= :till AND attribute_not_exists(#es)"),
+		ExpressionAttributeNames:  exprNames("#id", "#acc", "#es"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":till": numAttr(pder.clock.Now().Unix() - pder.maxIdleTime)},
+	}, pder.gcLimit, pder.sessionExpired, log)
+	report := session.GCReport{DeletedIdle: deleted}
+	if err != nil {
+		report.Errors++
+		log.Error(LOG_PREF+"deleteOnScan() failed", session.LOG_KEY_ERROR, err)
+	}
+	log.Debug(fmt.Sprintf(LOG_PREF+"SessionGC() done, %d sessions deleted", deleted), session.LOG_KEY_DURATION, time.Since(start))
+	return report
+}
+
+// SessionCount returns number of stored sessions, counted with table scan.
+func (pder *Provider) SessionCount() (int, error) {
+	cnt := 0
+	pages := dynamodb.NewScanPaginator(pder.client, &dynamodb.ScanInput{
+		TableName: aws.String(pder.table),
+		Select:    types.SelectCount,
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(context.Background())
+		if err != nil {
+			return 0, err
+		}
+		cnt += int(page.Count)
+	}
+	return cnt, nil
+}
+
+// SessionList returns session metadata ordered by session ID.
+// All session IDs are scanned and sorted in memory.
+func (pder *Provider) SessionList(offset, limit int) ([]session.SessionMeta, error) {
+	metas := make(map[string]session.SessionMeta)
+	ids := make([]string, 0)
+	pages := dynamodb.NewScanPaginator(pder.client, &dynamodb.ScanInput{
+		TableName:                aws.String(pder.table),
+		ProjectionExpression:     aws.String("#id, #cr, #acc"),
+		ExpressionAttributeNames: exprNames("#id", "#cr", "#acc"),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			sid_attr, ok := item[ATTR_ID].(*types.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			ids = append(ids, sid_attr.Value)
+			metas[sid_attr.Value] = session.SessionMeta{
+				ID:           sid_attr.Value,
+				TimeCreated:  time.Unix(numValue(item, ATTR_CREATE_TIME), 0),
+				TimeAccessed: time.Unix(numValue(item, ATTR_ACCESSED_TIME), 0),
+			}
+		}
+	}
+	ids = session.PageSessionIDs(ids, offset, limit)
+	list := make([]session.SessionMeta, len(ids))
+	for i, sid := range ids {
+		list[i] = metas[sid]
+	}
+	return list, nil
+}
+
+// SessionMeta implements session.MetaProvider, session values are not decoded.
+// ExpiresAt is set only if expiration is set by SessionStore.SetExpiry().
+func (pder *Provider) SessionMeta(sid string) (session.SessionMeta, error) {
+	out, err := pder.client.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName:                aws.String(pder.table),
+		Key:                      itemKey(sid),
+		ConsistentRead:           aws.Bool(true),
+		ProjectionExpression:     aws.String("#cr, #acc, #exp, #es, #val"),
+		ExpressionAttributeNames: exprNames("#cr", "#acc", "#exp", "#es", "#val"),
+	})
+	if err != nil {
+		return session.SessionMeta{}, err
+	}
+	if len(out.Item) == 0 {
+		return session.SessionMeta{}, session.ErrSessionNotFound
+	}
+	meta := session.SessionMeta{ID: sid,
+		TimeCreated:  time.Unix(numValue(out.Item, ATTR_CREATE_TIME), 0),
+		TimeAccessed: time.Unix(numValue(out.Item, ATTR_ACCESSED_TIME), 0),
+		Size:         len(bytesAttr(out.Item, ATTR_VAL)),
+		Provider:     PROVIDER,
+	}
+	if _, ok := out.Item[ATTR_EXPIRY_SET]; ok {
+		meta.ExpiresAt = time.Unix(numValue(out.Item, ATTR_EXPIRES_AT), 0)
+	}
+	return meta, nil
+}
+
+// SessionPeek implements session.PeekProvider: session values are read
+// without updating access time, missing session is not created, expired one is not removed.
+func (pder *Provider) SessionPeek(sid string) (session.Session, error) {
+	if pder.client == nil {
+		return nil, session.ErrProviderNotInitialized
+	}
+	out, err := pder.client.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName:      aws.String(pder.table),
+		Key:            itemKey(sid),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Item) == 0 {
+		return nil, session.ErrSessionNotFound
+	}
+	accessed := time.Unix(numValue(out.Item, ATTR_ACCESSED_TIME), 0)
+	created := time.Unix(numValue(out.Item, ATTR_CREATE_TIME), 0)
+	var expired bool
+	if _, ok := out.Item[ATTR_EXPIRY_SET]; ok {
+		expired = session.IsExpiredAt(pder.clock.Now(), created, accessed, time.Unix(numValue(out.Item, ATTR_EXPIRES_AT), 0), 0, 0)
+	} else {
+		expired = session.IsExpiredAt(pder.clock.Now(), created, accessed, time.Time{}, pder.maxLifeTime, pder.maxIdleTime)
+	}
+	if expired {
+		return nil, session.ErrSessionExpired
+	}
+	store := pder.NewSessionStore(sid)
+	store.timeAccessed.Store(accessed.UnixNano())
+	store.timeCreated = created
+	if err := pder.setFromDb(&store.value, bytesAttr(out.Item, ATTR_VAL)); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (pder *Provider) DestroyAllSessions(l io.Writer, logLev session.LogLevel) {
+	log := pder.getLogger(l, logLev).With(session.LOG_KEY_OPERATION, "DestroyAllSessions")
+	start := time.Now()
+	deleted, err := pder.deleteOnScan(&dynamodb.ScanInput{
+		TableName:                aws.String(pder.table),
+		ProjectionExpression:     aws.String("#id"),
+		ExpressionAttributeNames: exprNames("#id"),
+	}, 0, nil, log)
+	if err != nil {
+		log.Error(LOG_PREF+"deleteOnScan() failed", session.LOG_KEY_ERROR, err)
+		return
+	}
+	log.Debug(fmt.Sprintf(LOG_PREF+"DestroyAllSessions() done, %d sessions deleted", deleted), session.LOG_KEY_DURATION, time.Since(start))
+}
+
+// deleteOnScan deletes all items returned by scan, no more than limit items if limit > 0,
+// returns number of deleted items.
+// onDelete is called for every deleted session if set.
+func (pder *Provider) deleteOnScan(scan *dynamodb.ScanInput, limit int, onDelete func(sid string), log *slog.Logger) (int, error) {
+	ctx := context.Background()
+	deleted := 0
+	pages := dynamodb.NewScanPaginator(pder.client, scan)
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return deleted, err
+		}
+		for _, item := range page.Items {
+			if limit > 0 && deleted >= limit {
+				return deleted, nil
+			}
+			sid_attr, ok := item[ATTR_ID].(*types.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			log.Debug(LOG_PREF+"deleting session", session.LOG_KEY_SID, sid_attr.Value)
+			if err := pder.SessionDestroy(sid_attr.Value); err != nil {
+				return deleted, err
+			}
+			if onDelete != nil {
+				onDelete(sid_attr.Value)
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// SetGCMaxDeletions implements session.GCLimiter.
+func (pder *Provider) SetGCMaxDeletions(maxDeletions int) {
+	pder.gcLimit = maxDeletions
+}
+
+// SetExpirationMode implements session.ExpirationModeSetter.
+func (pder *Provider) SetExpirationMode(mode session.ExpirationMode) {
+	pder.expMode = mode
+}
+
+// SetStrictMode implements session.StrictModeSetter: SessionRead() returns session.ErrSessionNotFound
+// for an unknown session instead of creating it.
+func (pder *Provider) SetStrictMode(strict bool) error {
+	pder.strict = strict
+	return nil
+}
+
+// SetClock implements session.ClockSetter, session times, idle time checked by GC and expiration checked on read are of the clock.
+// DynamoDB item TTL runs in real time.
+// Nil restores session.SystemClock.
+func (pder *Provider) SetClock(clock session.Clock) {
+	if clock == nil {
+		clock = session.SystemClock{}
+	}
+	pder.clock = clock
+}
+
+// accessedExpr returns update expression value of access time attribute (#acc) on session read or write,
+// the attribute is kept if expiration mode does not update access time, :now is the current time.
+func (pder *Provider) accessedExpr(read bool) string {
+	if read && pder.expMode.TouchOnRead() || !read && pder.expMode.TouchOnWrite() {
+		return ":now"
+	}
+	return "if_not_exists(#acc, :now)"
+}
+
+// SetExpiredHook sets callback for sessions removed by SessionGC.
+func (pder *Provider) SetExpiredHook(hook session.SessionHook) {
+	pder.expiredHook = hook
+}
+
+// sessionExpired calls expired hook if it is set.
+func (pder *Provider) sessionExpired(sid string) {
+	if pder.expiredHook != nil {
+		pder.expiredHook(sid)
+	}
+}
+
+// SetLogger sets structured logger.
+func (pder *Provider) SetLogger(logger *slog.Logger) {
+	pder.logger = logger
+}
+
+// getLogger returns provider logger or io.Writer adapter if logger is not set.
+func (pder *Provider) getLogger(l io.Writer, logLev session.LogLevel) *slog.Logger {
+	return session.LoggerFor(pder.logger, l, logLev).With(session.LOG_KEY_PROVIDER, PROVIDER)
+}
+
+func (pder *Provider) SetMaxLifeTime(maxLifeTime int64) {
+	pder.maxLifeTime = maxLifeTime
+}
+func (pder *Provider) GetMaxLifeTime() int64 {
+	return pder.maxLifeTime
+}
+
+func (pder *Provider) SetMaxIdleTime(maxIdleTime int64) {
+	pder.maxIdleTime = maxIdleTime
+}
+
+func (pder *Provider) GetMaxIdleTime() int64 {
+	return pder.maxIdleTime
+}
+
+// SetKeyRing sets keys for payload encryption.
+func (pder *Provider) SetKeyRing(keyRing *session.KeyRing) {
+	pder.keyRing.Store(keyRing)
+}
+
+// SetPayloadVersion sets payload version, nil disables versions.
+func (pder *Provider) SetPayloadVersion(payloadVersion *session.PayloadVersion) {
+	pder.payloadVersion = payloadVersion
+}
+
+// InitProvider initializes DynamoDB provider.
+// Function expects parameters:
+//
+//	First parameter: *dynamodb.Client
+//	Second parameter: table name string
+//	Third parameter (optional): encryptKey string, if set session payload is encrypted with AES-GCM.
+func (pder *Provider) InitProvider(provParams []interface{}) error {
+	if len(provParams) < 2 {
+		return errors.New("InitProvider missing parameters: *dynamodb.Client, table name")
+	}
+	var ok bool
+	pder.client, ok = provParams[0].(*dynamodb.Client)
+	if !ok {
+		return errors.New("InitProvider client parameter(0) must be of type *dynamodb.Client")
+	}
+
+	pder.table, ok = provParams[1].(string)
+	if !ok {
+		return errors.New("InitProvider table name parameter(1) must be a string")
+	}
+
+	if len(provParams) >= 3 {
+		encrkey, ok := provParams[2].(string)
+		if !ok {
+			return errors.New("InitProvider encryptKey parameter(2) must be a string")
+		}
+		if encrkey != "" {
+			key_ring, err := session.NewKeyRing([]byte(encrkey))
+			if err != nil {
+				return err
+			}
+			pder.keyRing.Store(key_ring)
+		}
+	}
+
+	return nil
+}
+
+// CloseProvider is a stub, client is owned by the caller.
+func (pder *Provider) CloseProvider() error {
+	return nil
+}
+
+// Ping checks that session table is available with DescribeTable.
+func (pder *Provider) Ping(ctx context.Context) error {
+	if pder.client == nil {
+		return session.ErrProviderNotInitialized
+	}
+	_, err := pder.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(pder.table)})
+	return err
+}
+
+func (pder *Provider) GetSessionIDLen() int {
+	return SESS_ID_LEN
+}
+
+// itemKey returns primary key of session item.
+func itemKey(sid string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{ATTR_ID: &types.AttributeValueMemberS{Value: sid}}
+}
+
+// exprNames returns expression attribute names for the given placeholders.
+func exprNames(placeholders ...string) map[string]string {
+	names := make(map[string]string, len(placeholders))
+	for _, p := range placeholders {
+		names[p] = attrNames[p]
+	}
+	return names
+}
+
+func numAttr(v int64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(v, 10)}
+}
+
+// numValue returns numeric attribute value, 0 if there is no attribute.
+func numValue(item map[string]types.AttributeValue, name string) int64 {
+	if attr, ok := item[name].(*types.AttributeValueMemberN); ok {
+		v, _ := strconv.ParseInt(attr.Value, 10, 64)
+		return v
+	}
+	return 0
+}
+
+// bytesAttr returns binary attribute value, nil if there is no attribute.
+func bytesAttr(item map[string]types.AttributeValue, name string) []byte {
+	if attr, ok := item[name].(*types.AttributeValueMemberB); ok {
+		return attr.Value
+	}
+	return nil
+}
+
+func isConditionFailed(err error) bool {
+	var cond_err *types.ConditionalCheckFailedException
+	return err != nil && errors.As(err, &cond_err)
+}
+
+// genLockToken returns random lock owner token.
+func genLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// setFromDb is a helper function, called on retrieving value from data base.
+// It decrypts and decodes data base value for in-memory store.
+func (pder *Provider) setFromDb(strucVal *storeValue, dbVal []byte) error {
+	if len(dbVal) == 0 {
+		return nil
+	}
+	if key_ring := pder.keyRing.Load(); key_ring != nil {
+		var err error
+		if dbVal, err = key_ring.DecryptPayload(dbVal); err != nil {
+			return err
+		}
+	}
+	if err := pder.payloadVersion.Decode(dbVal, (*map[string]interface{})(strucVal)); err != nil {
+		return err
+	}
+	session.PurgeExpiredValuesAt(pder.clock.Now(), *strucVal) //values set with SetWithTTL() are filtered on read
+	return nil
+}
+
+// getForDb is a helper function called before putting value to database.
+// It encodes and encrypts in-memory session value for data base.
+func (pder *Provider) getForDb(strucVal *storeValue) ([]byte, error) {
+	val, err := pder.payloadVersion.Encode(strucVal)
+	if err != nil {
+		return []byte{}, err
+	}
+	if key_ring := pder.keyRing.Load(); key_ring != nil {
+		return key_ring.Encrypt(val)
+	}
+	return val, nil
+}
+
+func init() {
+	session.Register(PROVIDER, pder)
+}
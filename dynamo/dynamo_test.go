@@ -0,0 +1,371 @@
+// testing functions for session/dynamo.
+// Testing asumes the following:
+//
+//	DynamoDB (or DynamoDB local) is accessible with default AWS credentials.
+//	Table with string partition key "id" already exists.
+package dynamo
+
+import (
+	"context"
+	"encoding/gob"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/dronm/session" //session manager
+	"github.com/dronm/session/testkit"
+)
+
+const (
+	//DynamoDB endpoint, optional, for example http://localhost:8000 for DynamoDB local
+	ENV_DYNAMO_ENDPOINT = "DYNAMO_ENDPOINT"
+	ENV_DYNAMO_TABLE    = "DYNAMO_TABLE"
+)
+
+func getTestVar(t *testing.T, n string) string {
+	v := os.Getenv(n)
+	if v == "" {
+		t.Fatalf("getTestVar() failed: %s environment variable is not set", n)
+	}
+	return v
+}
+
+func NewManager(t *testing.T, idleTime int64, lifeTime int64, killTime string) (*session.Manager, error) {
+	table := getTestVar(t, ENV_DYNAMO_TABLE)
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		t.Fatalf("LoadDefaultConfig() failed: %v", err)
+	}
+	client := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if endpoint := os.Getenv(ENV_DYNAMO_ENDPOINT); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+	return session.NewManager(PROVIDER, idleTime, lifeTime, killTime, client, table)
+}
+
+// TestTemp opens a new session, puts temporary data to it, reads and compares.
+func TestTemp(t *testing.T) {
+	gob.Register(time.Time{})
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+
+	//start new session
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+
+	sid := currentSession.SessionID()
+	t.Logf("SessionID: %s", sid)
+	var wanted int64 = 177
+	//wanted := time.Now().Truncate(time.Second)
+	//wanted := "time.Now().Truncate(time.Second)"
+	if err := currentSession.Put("dVal", wanted); err != nil {
+		t.Fatalf("Put() for string value failed: %v", err)
+	}
+	//int_v := currentSession.GetInt("dVal")
+	//var got time.Time
+	//var got string
+	var got int64 = 0
+	if err := currentSession.Get("dVal", &got); err != nil {
+		t.Fatalf("Put() for string value failed: %v", err)
+	}
+	if got != wanted {
+		t.Fatalf("Wanted %v, got: %v", wanted, got)
+	}
+}
+
+func TestSession(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+
+	//start new session
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+
+	sid := currentSession.SessionID()
+	t.Logf("SessionID: %s", sid)
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+
+	//test reading
+	testkit.CompareValues(t, currentSession, tests)
+
+	t.Logf("Closing session: %s", sid)
+	if err := SessManager.SessionClose(sid); err != nil {
+		t.Fatalf("SessionClose() failed: %v", err)
+	}
+
+	t.Logf("Reopening session: %s", sid)
+	//reopen
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Errorf("SessionStart() failed: %v", err)
+	}
+	//test reading
+	testkit.CompareValues(t, currentSession, tests)
+
+	if err := SessManager.SessionClose(currentSession.SessionID()); err != nil {
+		t.Errorf("SessionClose() failed: %v", err)
+	}
+
+	//destroying session
+	t.Logf("Destroying session: %s", sid)
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Errorf("SessManager.SessionDestroy() failed: %v", err)
+	}
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Errorf("SessionStart() failed: %v", err)
+	}
+	t.Logf("Trying to read from session")
+	testkit.AssertNoValues(t, currentSession, tests)
+	t.Logf("Session destroyed to read from session")
+}
+
+// TestDestroyAllSessions creates a session, puts some data, destroys this session,
+// then tries to reopen and read from the session. If at leas one key is found, test fails.
+func TestDestroyAllSessions(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+
+	//start new session
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+
+	sid := currentSession.SessionID()
+	t.Logf("SessionID: %s", sid)
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+
+	SessManager.DestroyAllSessions(os.Stderr, session.LOG_LEVEL_DEBUG)
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Errorf("SessionStart() failed: %v", err)
+	}
+	t.Logf("Trying to read from session")
+	testkit.AssertNoValues(t, currentSession, tests)
+}
+
+// TestLock locks a session, starts the same session in a goroutine which must wait for the lock.
+// The waiting session must see the value flushed by the first one.
+func TestLock(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStartLocked("")
+	if err != nil {
+		t.Fatalf("SessionStartLocked() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	done := make(chan int64)
+	go func() {
+		sess, err := SessManager.SessionStartLocked(sid)
+		if err != nil {
+			t.Errorf("SessionStartLocked() failed: %v", err)
+			close(done)
+			return
+		}
+		got := sess.GetInt("counter")
+		if err := sess.Unlock(); err != nil {
+			t.Errorf("Unlock() failed: %v", err)
+		}
+		done <- got
+	}()
+
+	time.Sleep(time.Duration(200) * time.Millisecond)
+	if err := currentSession.Put("counter", int64(1)); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := currentSession.Unlock(); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+
+	if got := <-done; got != 1 {
+		t.Fatalf("Wanted: 1, got %v", got)
+	}
+}
+
+// TestKeys puts values to a session and checks Keys() and Len() results.
+func TestKeys(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+
+	wanted := make([]string, 0, len(tests))
+	for key := range tests {
+		wanted = append(wanted, key)
+	}
+	sort.Strings(wanted)
+
+	got, err := currentSession.Keys()
+	if err != nil {
+		t.Fatalf("Keys() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Fatalf("Wanted: %v, got %v", wanted, got)
+	}
+
+	cnt, err := currentSession.Len()
+	if err != nil {
+		t.Fatalf("Len() failed: %v", err)
+	}
+	if cnt != len(tests) {
+		t.Fatalf("Wanted: %d, got %d", len(tests), cnt)
+	}
+}
+
+// TestClear puts values to a session, clears it and checks that session ID is kept and values are gone.
+func TestClear(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+
+	if err := currentSession.Clear(); err != nil {
+		t.Fatalf("Clear() failed: %v", err)
+	}
+	if err := currentSession.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if currentSession.SessionID() != sid {
+		t.Fatalf("Wanted: %s, got %s", sid, currentSession.SessionID())
+	}
+	testkit.AssertNoValues(t, currentSession, tests)
+}
+
+// TestIncrement increments a counter from several goroutines with separate session instances.
+// No increment may be lost.
+func TestIncrement(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	const workers, incs = 5, 10
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sess, err := SessManager.SessionStart(sid)
+			if err != nil {
+				t.Errorf("SessionStart() failed: %v", err)
+				return
+			}
+			for j := 0; j < incs; j++ {
+				if _, err := sess.Increment("counter", 2); err != nil {
+					t.Errorf("Increment() failed: %v", err)
+				}
+				if _, err := sess.Decrement("counter", 1); err != nil {
+					t.Errorf("Decrement() failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := currentSession.Increment("counter", 0)
+	if err != nil {
+		t.Fatalf("Increment() failed: %v", err)
+	}
+	if got != workers*incs {
+		t.Fatalf("Wanted: %d, got %d", workers*incs, got)
+	}
+}
+
+// TestIdleTime creates a session with a limited idle time.
+// Some values are put to session store, then retrieved, asserted they exist.
+// Then session data is not touched more then idle time.
+// After that SessionGC() is called.
+// Then data is retrieved. The session should have been deleted by then.
+// The test fails if any key persists.
+func TestIdleTime(t *testing.T) {
+	var idle_time int64 = 3
+	SessManager, err := NewManager(t, 0, idle_time, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStart("")
+	sid := currentSession.SessionID()
+	t.Logf("SessionID: %s", sid)
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+	if err := SessManager.SessionClose(currentSession.SessionID()); err != nil {
+		t.Errorf("SessionClose() failed: %v", err)
+	}
+
+	t.Logf("waiting %d seconds", idle_time/2)
+	time.Sleep(time.Duration(idle_time/2) * time.Second)
+	SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG)
+	//test reading
+	testkit.CompareValues(t, currentSession, tests)
+
+	t.Logf("waiting %d seconds for session to be killed", idle_time+2)
+	time.Sleep(time.Duration(idle_time) * time.Second)
+
+	SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG)
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Errorf("SessionStart() failed: %v", err)
+	}
+	t.Logf("Trying to read from session")
+	testkit.AssertNoValues(t, currentSession, tests)
+	t.Logf("The session %s is destroyed", sid)
+}
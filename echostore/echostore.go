@@ -0,0 +1,33 @@
+// Package echostore provides echo middleware keeping sessions with session.Manager,
+// sessions are returned by echo-contrib/session Get():
+//
+//	e := echo.New()
+//	e.Use(echostore.Middleware(SessManager))
+//	e.GET("/", func(c echo.Context) error {
+//		sess, err := session.Get("sid", c)
+//		if err != nil {
+//			return err
+//		}
+//		sess.Values["user"] = "user1"
+//		return sess.Save(c.Request(), c.Response())
+//	})
+//
+// Sessions are kept as gorillastore.Store keeps them.
+package echostore
+
+import (
+	"github.com/dronm/session"
+	"github.com/dronm/session/gorillastore"
+	echosession "github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// Middleware returns echo-contrib/session middleware with the store of manager sessions.
+func Middleware(manager *session.Manager) echo.MiddlewareFunc {
+	return echosession.Middleware(NewStore(manager))
+}
+
+// NewStore returns store of manager sessions, e.g. for echo-contrib/session MiddlewareWithConfig().
+func NewStore(manager *session.Manager) *gorillastore.Store {
+	return gorillastore.NewStore(manager)
+}
@@ -0,0 +1,84 @@
+// testing functions for session/echostore.
+package echostore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dronm/session"
+	"github.com/dronm/session/mock"
+	echosession "github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+const COOKIE_NAME = "sid"
+
+func newServer(t *testing.T) (*session.Manager, *echo.Echo) {
+	SessManager, err := session.NewManagerWithProvider(mock.NewProvider(), 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	e := echo.New()
+	e.Use(Middleware(SessManager))
+	e.GET("/set", func(c echo.Context) error {
+		sess, err := echosession.Get(COOKIE_NAME, c)
+		if err != nil {
+			return err
+		}
+		sess.Values["user"] = "user1"
+		return sess.Save(c.Request(), c.Response())
+	})
+	e.GET("/get", func(c echo.Context) error {
+		sess, err := echosession.Get(COOKIE_NAME, c)
+		if err != nil {
+			return err
+		}
+		user, _ := sess.Values["user"].(string)
+		return c.String(http.StatusOK, user)
+	})
+	return SessManager, e
+}
+
+// request serves path with cookies, returns response.
+func request(e *echo.Echo, path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	return w
+}
+
+// TestMiddleware saves values in one request and reads them in the next one.
+func TestMiddleware(t *testing.T) {
+	SessManager, e := newServer(t)
+	w := request(e, "/set", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("/set status %d: %s", w.Code, w.Body.String())
+	}
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == COOKIE_NAME {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value == "" || !cookie.HttpOnly {
+		t.Fatalf("cookie %v wanted session ID and HttpOnly", cookie)
+	}
+
+	sess, err := SessManager.SessionStart(cookie.Value)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if got := sess.GetString("user"); got != "user1" {
+		t.Fatalf("manager session value = %q, wanted user1", got)
+	}
+	SessManager.SessionClose(cookie.Value)
+
+	w = request(e, "/get", []*http.Cookie{cookie})
+	if got := w.Body.String(); got != "user1" {
+		t.Fatalf("store session value = %q, wanted user1", got)
+	}
+}
@@ -0,0 +1,160 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EraseMode defines what EraseUserData() does with sessions of a user.
+type EraseMode int
+
+const (
+	ERASE_DESTROY   EraseMode = iota //sessions are destroyed, their copies are removed from trash
+	ERASE_ANONYMIZE                  //sensitive values and the user binding are deleted, other values are kept
+)
+
+// UserData is a session of a user returned by ExportUserData().
+type UserData struct {
+	TimeCreated  time.Time
+	TimeAccessed time.Time
+	BoundAt      time.Time
+	Values       map[string]interface{} //sensitive values are decrypted
+}
+
+// ExportUserData returns sessions bound to user with BindUser() with their values to answer
+// a data subject access request. Sensitive values are decrypted, see Session.SetSensitive().
+// Session IDs are left out as they give access to the sessions. Sessions are looked up
+// in user index, see SetUserIndex(), sessions without values are skipped.
+func (manager *Manager) ExportUserData(userID string) ([]UserData, error) {
+	if userID == "" {
+		return nil, errors.New("session: ExportUserData user ID must not be empty")
+	}
+	manager.usersMx.Lock()
+	defer manager.usersMx.Unlock()
+
+	list, err := manager.liveUserSessions(manager.users(), userID, "")
+	if err != nil {
+		return nil, err
+	}
+	data := make([]UserData, 0, len(list))
+	for _, us := range list {
+		ud, err := manager.userData(us)
+		if errors.Is(err, ErrSessionExpired) || errors.Is(err, ErrSessionNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("session: export user data: %w", err)
+		}
+		if len(ud.Values) > 0 {
+			data = append(data, ud)
+		}
+	}
+	return data, nil
+}
+
+// userData returns values of user session with sensitive values decrypted.
+func (manager *Manager) userData(us UserSession) (UserData, error) {
+	sess, err := manager.provider.SessionRead(us.ID)
+	if err != nil {
+		return UserData{}, err
+	}
+	defer manager.provider.SessionClose(us.ID)
+	values, err := sessionValues(sess)
+	if err != nil {
+		return UserData{}, err
+	}
+	for key, value := range values {
+		if sv, ok := value.(SensitiveValue); ok {
+			if values[key], err = sv.Open(); err != nil {
+				return UserData{}, fmt.Errorf("key %s: %w", key, err)
+			}
+		}
+	}
+	return UserData{TimeCreated: sess.TimeCreated(), TimeAccessed: sess.TimeAccessed(), BoundAt: us.BoundAt, Values: values}, nil
+}
+
+// EraseUserData erases session data of user bound with BindUser() to fulfil a data subject erasure request.
+// Sessions are looked up in user index, see SetUserIndex(), and are destroyed or anonymized
+// depending on mode. Anonymized sessions keep working without the user: sensitive values,
+// see Session.SetSensitive(), and USER_KEY are deleted. Provider decorators erase the data
+// in all their providers. Destroyed sessions are not kept in trash, copies of sessions of the user
+// destroyed before are removed from trash if its store implements UserTrashStore, see SetTrash().
+// Returns number of erased sessions.
+func (manager *Manager) EraseUserData(userID string, mode EraseMode) (int, error) {
+	if userID == "" {
+		return 0, errors.New("session: EraseUserData user ID must not be empty")
+	}
+	manager.usersMx.Lock()
+	defer manager.usersMx.Unlock()
+
+	index := manager.users()
+	list, err := manager.liveUserSessions(index, userID, "")
+	if err != nil {
+		return 0, err
+	}
+	cnt := 0
+	for _, us := range list {
+		if mode == ERASE_ANONYMIZE {
+			err = manager.anonymizeSession(us.ID)
+			if err == nil {
+				err = index.Remove(us.ID)
+			}
+		} else {
+			err = manager.eraseSession(us.ID)
+		}
+		if err != nil {
+			return cnt, fmt.Errorf("session: erase user data: %w", err)
+		}
+		cnt++
+	}
+	if user_trash, ok := manager.trash.(UserTrashStore); ok {
+		if _, err := user_trash.TakeUser(userID); err != nil {
+			return cnt, fmt.Errorf("session: erase user data: %w", err)
+		}
+	}
+	return cnt, nil
+}
+
+// eraseSession destroys session and removes it from trash.
+func (manager *Manager) eraseSession(sid string) error {
+	if err := manager.SessionDestroy(sid); err != nil {
+		return err
+	}
+	if manager.trash == nil {
+		return nil
+	}
+	if _, err := manager.trash.Take(sid); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return err
+	}
+	return nil
+}
+
+// anonymizeSession deletes sensitive values and user binding of session.
+func (manager *Manager) anonymizeSession(sid string) error {
+	if manager.shareSessions {
+		manager.forgetShared(sid)
+	}
+	manager.dirty.forget(sid)
+	sess, err := manager.provider.SessionRead(sid)
+	if errors.Is(err, ErrSessionExpired) || errors.Is(err, ErrSessionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer manager.provider.SessionClose(sid)
+	sensitive, err := sensitiveValues(sess)
+	if err != nil {
+		return err
+	}
+	for key := range sensitive {
+		if err := sess.Delete(key); err != nil {
+			return err
+		}
+	}
+	if err := sess.Delete(USER_KEY); err != nil {
+		return err
+	}
+	return sess.Flush()
+}
@@ -0,0 +1,146 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the package and by providers.
+// Providers return or wrap these values, so they can be checked with errors.Is().
+var (
+	// ErrKeyNotFound is returned by Session.Get() and Session.GetStruct() if there is no value for a key.
+	ErrKeyNotFound = errors.New("session: key not found")
+
+	// ErrSessionNotFound is returned by Session.SetExpiry() and Session.Touch()
+	// if the session is no longer in storage, e.g. destroyed or removed by GC,
+	// and by SessionStart() for an unknown session in strict mode, see Manager.SetStrictMode().
+	ErrSessionNotFound = errors.New("session: session not found")
+
+	// ErrSessionExpired is returned by SessionStart() for a session expired but not yet removed by GC.
+	// The session is destroyed, a new session should be started.
+	ErrSessionExpired = errors.New("session: session expired")
+
+	// ErrProviderNotInitialized is returned if a provider is used before InitProvider().
+	ErrProviderNotInitialized = errors.New("session: provider not initialized")
+
+	// ErrTypeMismatch is returned if a session value can not be assigned to the destination.
+	ErrTypeMismatch = errors.New("session: value type mismatch")
+
+	// ErrValMustBePtr is returned if a destination of a session value is not a pointer.
+	ErrValMustBePtr = errors.New("session: value must be of type ptr")
+
+	// ErrSessionTooLarge is returned if a session exceeds the size limit, see Manager.SetMaxSessionSize().
+	ErrSessionTooLarge = errors.New("session: session is too large")
+
+	// ErrInvalidSessionID is returned for a session ID not accepted by a provider, e.g. too long.
+	ErrInvalidSessionID = errors.New("session: invalid session ID")
+
+	// ErrFingerprintMismatch is returned by SessionStartHTTP() if a session is used by a client
+	// with another fingerprint, see Manager.SetFingerprint().
+	ErrFingerprintMismatch = errors.New("session: client fingerprint mismatch")
+
+	// ErrBackendUnavailable is returned while the circuit breaker is open, see Manager.SetCircuitBreaker().
+	ErrBackendUnavailable = errors.New("session: backend unavailable")
+
+	// ErrTooManySessions is returned by Manager.BindUser() if the user has the maximum number of sessions
+	// and new sessions are rejected, see Manager.SetMaxUserSessions().
+	ErrTooManySessions = errors.New("session: too many sessions of the user")
+
+	// ErrGCFailed is returned by Manager.CollectGarbage() and Manager.RunGCOnce() if storage operations of GC failed
+	// and provider does not return their errors, see ProviderV2.
+	ErrGCFailed = errors.New("session: GC failed")
+
+	// ErrNoStrictMode is returned by Manager.SetStrictMode() if provider does not support strict mode.
+	ErrNoStrictMode = errors.New("session: provider does not support strict mode")
+
+	// ErrNoGCLeaderLock is returned if provider can not elect GC leader, see Manager.SetGCLeaderLock().
+	ErrNoGCLeaderLock = errors.New("session: provider does not support GC leader lock")
+
+	// ErrNoExpirationMode is returned by Manager.SetExpirationMode() if provider does not support expiration modes.
+	ErrNoExpirationMode = errors.New("session: provider does not support expiration mode")
+
+	// ErrNoSessionMeta is returned by Manager.SessionMeta() if provider does not support session metadata.
+	ErrNoSessionMeta = errors.New("session: provider does not support session metadata")
+
+	// ErrNoSessionPeek is returned if provider can not read a session without side effects, see PeekProvider.
+	ErrNoSessionPeek = errors.New("session: provider does not support session peek")
+
+	// ErrNoCompression is returned by Manager.SetCompression() if provider does not support payload compression.
+	ErrNoCompression = errors.New("session: provider does not support compression")
+
+	// ErrNoPayloadVersion is returned by Manager.SetPayloadVersion() if provider does not support payload versions.
+	ErrNoPayloadVersion = errors.New("session: provider does not support payload versions")
+
+	// ErrNoSensitiveKeys is returned if sensitive values are set or read before SetSensitiveKeys().
+	ErrNoSensitiveKeys = errors.New("session: sensitive value keys are not set")
+
+	// ErrLockTimeout is returned by Session.Lock() if the lock is not acquired in LOCK_WAIT.
+	ErrLockTimeout = errors.New("session: lock wait timeout")
+
+	// ErrDecryptFailed is returned if a payload or a sensitive value can not be decrypted with keys of KeyRing.
+	ErrDecryptFailed = errors.New("session: payload decryption failed")
+
+	// ErrNotAdminProvider is returned by Manager.Count(), Manager.ListSessions() and other administration
+	// methods if provider does not implement AdminProvider.
+	ErrNotAdminProvider = errors.New("session: provider does not support administration")
+
+	// ErrQuorum is returned by ReplicatedProvider if fewer providers than the quorum succeeded.
+	ErrQuorum = errors.New("session: replication quorum is not reached")
+
+	// ErrTypeNotRegistered is returned if a session value can not be encoded or decoded
+	// because its type is not registered, see RegisterType().
+	ErrTypeNotRegistered = errors.New("session: value type not registered")
+
+	// ErrChaos is returned by operations failed by ChaosProvider if ChaosConfig.Err is not set.
+	ErrChaos = errors.New("session: chaos fault injected")
+
+	// ErrChaosTimeout is returned by operations timed out by ChaosProvider,
+	// errors.Is(err, context.DeadlineExceeded) is true for it.
+	ErrChaosTimeout = fmt.Errorf("session: chaos timeout: %w", context.DeadlineExceeded)
+
+	// ErrRetriesExhausted is returned by RetryProvider if an operation failed with a transient error
+	// on every attempt, the last error is wrapped as well:
+	//
+	//	if errors.Is(err, session.ErrRetriesExhausted) { ... }
+	ErrRetriesExhausted = errors.New("session: retries exhausted")
+)
+
+// Deprecated: use ErrTypeMismatch.
+var ETypeMismatch = ErrTypeMismatch
+
+// Deprecated: use ErrValMustBePtr.
+var EValMustBePtr = ErrValMustBePtr
+
+// Deprecated: use ErrNoStrictMode.
+var ENoStrictMode = ErrNoStrictMode
+
+// Deprecated: use ErrNoGCLeaderLock.
+var ENoGCLeaderLock = ErrNoGCLeaderLock
+
+// Deprecated: use ErrNoExpirationMode.
+var ENoExpirationMode = ErrNoExpirationMode
+
+// Deprecated: use ErrNoSessionMeta.
+var ENoSessionMeta = ErrNoSessionMeta
+
+// Deprecated: use ErrNoCompression.
+var ENoCompression = ErrNoCompression
+
+// Deprecated: use ErrNoPayloadVersion.
+var ENoPayloadVersion = ErrNoPayloadVersion
+
+// Deprecated: use ErrNoSensitiveKeys.
+var ENoSensitiveKeys = ErrNoSensitiveKeys
+
+// Deprecated: use ErrLockTimeout.
+var ELockTimeout = ErrLockTimeout
+
+// Deprecated: use ErrDecryptFailed.
+var EDecryptFailed = ErrDecryptFailed
+
+// Deprecated: use ErrNotAdminProvider.
+var ENotAdminProvider = ErrNotAdminProvider
+
+// Deprecated: use ErrQuorum.
+var EQuorum = ErrQuorum
@@ -0,0 +1,1350 @@
+// Package etcd contains etcd v3 session provider based on go.etcd.io/etcd/client/v3.
+// Requirements:
+//
+//	etcd client https://github.com/etcd-io/etcd/tree/main/client/v3
+//
+// Each session is kept in one key namespace+"sess/"+ID holding a JSON record. Max life time is mapped
+// to a lease granted for every session, so expired sessions are deleted by etcd itself,
+// SessionGC only handles idle sessions. Session locks are keys namespace+"lock/"+ID attached
+// to leases of session.LockTTL().
+//
+// Sessions deleted by lease expiry are reported to the hook set with SetExpiredHook() by a watch
+// started in InitProvider(), so every application instance watching the namespace is notified.
+//
+// Internally gob encoder is used for data serialization. Session data is read at start and kept in memory SessionStore structure.
+// Session key-value pares are kept in storeValue type.
+package etcd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dronm/session"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Deprecated: use session.ErrKeyNotFound.
+var EKeyNotFound = session.ErrKeyNotFound
+
+// Deprecated: use session.ErrValMustBePtr.
+var EValMustBePtr = session.ErrValMustBePtr
+
+// Session key ID length.
+const SESS_ID_LEN = 36
+
+const PROVIDER = "etcd"
+
+const LOG_PREF = "etcd provider:"
+
+// Max transaction attempts for atomic record updates.
+const INCR_MAX_RETRIES = 100
+
+// Key prefixes following the namespace.
+const (
+	SESSIONS_PREFIX = "sess/"
+	LOCKS_PREFIX    = "lock/"
+)
+
+// SCAN_PAGE_SIZE is the number of keys read by one range request of SessionGC and admin functions.
+const SCAN_PAGE_SIZE = 1000
+
+// WATCH_RETRY is a delay before the expiry watch is restarted after it is closed by the server.
+const WATCH_RETRY = time.Second
+
+// EXPIRY_TOLERANCE is a clock difference allowed between the application and etcd
+// when a deleted session is checked for lease expiry.
+const EXPIRY_TOLERANCE = time.Second
+
+// pder holds pointer to Provider struct registered as PROVIDER.
+var pder = NewProvider()
+
+// NewProvider returns a provider independent of the registered one, e.g. to use another namespace
+// in another Manager created with session.NewManagerWithProvider().
+func NewProvider() *Provider {
+	return &Provider{clock: session.SystemClock{}}
+}
+
+// storeValue holds session key-value pares.
+type storeValue map[string]interface{}
+
+// record is a stored session.
+type record struct {
+	Created   int64                `json:"created"`              //unix nanoseconds
+	Accessed  int64                `json:"accessed"`             //unix nanoseconds
+	ExpiresAt int64                `json:"expires_at,omitempty"` //unix nanoseconds, set by SessionStore.SetExpiry()
+	Val       []byte               `json:"val,omitempty"`
+	Rates     session.RateCounters `json:"rates,omitempty"` //counters of session.AllowRate()
+}
+
+// SessionStore contains session information.
+type SessionStore struct {
+	sid           string    //session id
+	pder          *Provider //provider storing the session
+	mx            sync.RWMutex
+	timeAccessed  atomic.Int64 //last access in Unix nanoseconds, updated under read lock by getters
+	timeCreated   time.Time    //when created
+	value         storeValue   //key-value pair
+	valueModified bool
+	lockLease     clientv3.LeaseID //lease of the lock key, set when session is locked
+}
+
+// Set sets inmemory value. No database flush is done.
+func (st *SessionStore) Set(key string, value interface{}) error {
+	//type assertion is needed
+	/*
+		var v interface{}
+
+		switch value.(type) {
+		case int:
+			v = int64(value.(int))
+		case int32:
+			v = int64(value.(int32))
+		case float32:
+			v = float64(value.(float32))
+		default:
+			v = value
+		}
+	*/
+	if !reflect.DeepEqual(st.value[key], value) {
+		st.mx.Lock()
+		st.value[key] = value
+		st.valueModified = true
+		st.accessed()
+		st.mx.Unlock()
+	}
+	return nil
+}
+
+func (st *SessionStore) Put(key string, value interface{}) error {
+	if err := st.Set(key, value); err != nil {
+		return err
+	}
+	return st.Flush()
+}
+
+// SetMany sets in-memory values under one lock, they are written by one Flush().
+func (st *SessionStore) SetMany(values map[string]interface{}) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	for key, value := range values {
+		if !reflect.DeepEqual(st.value[key], value) {
+			st.value[key] = value
+			st.valueModified = true
+			st.accessed()
+		}
+	}
+	return nil
+}
+
+// SetWithTTL sets in-memory value expiring in ttl, see session.ExpiringValue.
+func (st *SessionStore) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value[key] = session.NewExpiringValueAt(st.pder.clock.Now(), value, ttl)
+	st.valueModified = true
+	st.accessed()
+	return nil
+}
+
+// GetMany assigns in-memory values to dest pointers, see session.GetMany().
+func (st *SessionStore) GetMany(dest map[string]interface{}) error {
+	return session.GetMany(st, dest)
+}
+
+// Snapshot returns a deep copy of in-memory values without expired ones, see session.SnapshotValuesAt().
+func (st *SessionStore) Snapshot() (map[string]interface{}, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return session.SnapshotValuesAt(st.pder.clock.Now(), st.value)
+}
+
+// Restore replaces in-memory values with a copy of snapshot, they are written by Flush().
+func (st *SessionStore) Restore(snapshot map[string]interface{}) error {
+	value, err := session.CopyValues(snapshot)
+	if err != nil {
+		return err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value = value
+	st.valueModified = true
+	st.accessed()
+	return nil
+}
+
+// Flush performs the actual write to database.
+// Values of a session which is no longer stored are not written.
+func (st *SessionStore) Flush() error {
+	//flush val only if it's been modified
+	if st.valueModified {
+		//modified
+		val, err := st.pder.getForDb(&st.value)
+		if err != nil {
+			return err
+		}
+
+		st.mx.Lock()
+		defer st.mx.Unlock()
+
+		err = st.pder.updateRecord(st.sid, func(rec *record) (bool, error) {
+			rec.Val = val
+			st.pder.touchRecord(rec, false)
+			return true, nil
+		})
+		if err != nil && !errors.Is(err, session.ErrSessionNotFound) {
+			return err
+		}
+		st.valueModified = false
+		st.written()
+	}
+
+	return nil
+}
+
+// Get returns session value by its key. Value is retrieved from memory.
+func (st *SessionStore) Get(key string, val interface{}) error {
+	st.mx.RLock()
+	store_val, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if !ok {
+		return session.ErrKeyNotFound
+	}
+
+	// Get the type of val
+	val_type := reflect.TypeOf(val)
+
+	// Make sure val is a pointer
+	if val_type.Kind() != reflect.Ptr {
+		return session.ErrValMustBePtr
+	}
+
+	// Dereference the pointer and check if it's assignable
+	val_elem := val_type.Elem()
+	if !reflect.TypeOf(store_val).AssignableTo(val_elem) {
+		return session.ErrTypeMismatch
+	}
+
+	// Assign the value to val
+	reflect.ValueOf(val).Elem().Set(reflect.ValueOf(store_val))
+
+	return nil
+}
+
+// GetStruct retrieves session value by its key into dest pointer.
+// Value of a different type is converted, see session.AssignValue().
+func (st *SessionStore) GetStruct(key string, dest interface{}) error {
+	st.mx.RLock()
+	store_val, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if !ok {
+		return session.ErrKeyNotFound
+	}
+	return session.AssignValue(store_val, dest)
+}
+
+// GetBool returns bool value by key.
+func (st *SessionStore) GetBool(key string) bool {
+	v, ok := st.lookup(key)
+	if !ok {
+		return false
+	}
+
+	if v_bool, ok := v.(bool); ok {
+		return v_bool
+	}
+	return false
+}
+
+// GetString returns string value by key.
+func (st *SessionStore) GetString(key string) string {
+	v, ok := st.lookup(key)
+	if !ok {
+		return ""
+	}
+
+	if v_str, ok := v.(string); ok {
+		return v_str
+
+	} else if v_str, ok := v.([]byte); ok {
+		return string(v_str)
+	}
+	return ""
+}
+
+// GetInt returns int value by key.
+func (st *SessionStore) GetInt(key string) int64 {
+	v, ok := st.lookup(key)
+	if !ok {
+		return 0
+	}
+
+	if v_i, ok := v.(int64); ok {
+		return v_i
+
+	} else if v_i, ok := v.(int); ok {
+		return int64(v_i)
+	}
+	return 0
+}
+
+// GetFloat returns float value by key.
+func (st *SessionStore) GetFloat(key string) float64 {
+	v, ok := st.lookup(key)
+	if !ok {
+		return 0
+	}
+
+	if v_f, ok := v.(float64); ok {
+		return v_f
+
+	} else if v_f, ok := v.(float32); ok {
+		return float64(v_f)
+	}
+	return 0
+}
+
+// GetDate returns time.Time value by key.
+func (st *SessionStore) GetDate(key string) time.Time {
+	v, ok := st.lookup(key)
+	if !ok {
+		return time.Time{}
+	}
+
+	if v_t, ok := v.(time.Time); ok {
+		return v_t
+	}
+	return time.Time{}
+}
+
+// GetBytes returns []byte value by key, string value is converted.
+func (st *SessionStore) GetBytes(key string) []byte {
+	v, ok := st.lookup(key)
+	if !ok {
+		return nil
+	}
+
+	if v_b, ok := v.([]byte); ok {
+		return v_b
+
+	} else if v_b, ok := v.(string); ok {
+		return []byte(v_b)
+	}
+	return nil
+}
+
+// GetStringSlice returns []string value by key.
+func (st *SessionStore) GetStringSlice(key string) []string {
+	v, ok := st.lookup(key)
+	if !ok {
+		return nil
+	}
+
+	if v_s, ok := v.([]string); ok {
+		return v_s
+	}
+	return nil
+}
+
+// GetOrSet assigns session value to dest, if there is no value
+// it is computed, stored and flushed under session lock, see session.GetOrSet().
+func (st *SessionStore) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	return session.GetOrSet(st, key, dest, compute)
+}
+
+// Delete deletes session value from memmory by key. No flushing is done.
+func (st *SessionStore) Delete(key string) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if _, ok := st.value[key]; !ok {
+		return nil
+	}
+	st.accessed()
+	delete(st.value, key)
+	st.valueModified = true
+
+	return nil
+}
+
+// Clear deletes all session values from memory keeping session ID and creation time. No flushing is done.
+func (st *SessionStore) Clear() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if len(st.value) > 0 {
+		st.value = make(storeValue)
+		st.valueModified = true
+	}
+	st.accessed()
+
+	return nil
+}
+
+// Increment atomically adds delta to integer session value and returns the new value.
+// Missing value is treated as 0. The record is written on condition its revision is not changed,
+// update is retried up to INCR_MAX_RETRIES times on concurrent modification.
+// Other modified in-memory values are not flushed.
+func (st *SessionStore) Increment(key string, delta int64) (int64, error) {
+	var new_val int64
+	err := st.pder.updateRecord(st.sid, func(rec *record) (bool, error) {
+		db_value := make(storeValue)
+		if err := st.pder.setFromDb(&db_value, rec.Val); err != nil {
+			return false, err
+		}
+		cur, _ := session.LookupValueAt(st.pder.clock.Now(), db_value, key)
+		val, err := session.IncrementValue(cur, delta)
+		if err != nil {
+			return false, err
+		}
+		db_value[key] = val
+		if rec.Val, err = st.pder.getForDb(&db_value); err != nil {
+			return false, err
+		}
+		st.pder.touchRecord(rec, false)
+		new_val = val
+		return true, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	st.mx.Lock()
+	st.value[key] = new_val
+	st.written()
+	st.mx.Unlock()
+
+	return new_val, nil
+}
+
+// Decrement atomically subtracts delta from integer session value and returns the new value.
+func (st *SessionStore) Decrement(key string, delta int64) (int64, error) {
+	return st.Increment(key, -delta)
+}
+
+// CompareAndSwap sets newValue if the stored value equals oldValue.
+// The record is written on condition its revision is not changed, the operation is retried otherwise,
+// see session.Session.CompareAndSwap().
+func (st *SessionStore) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
+	var swapped bool
+	err := st.pder.updateRecord(st.sid, func(rec *record) (bool, error) {
+		db_value := make(storeValue)
+		if err := st.pder.setFromDb(&db_value, rec.Val); err != nil {
+			return false, err
+		}
+		if cur, _ := session.LookupValueAt(st.pder.clock.Now(), db_value, key); !session.EqualValue(cur, oldValue) {
+			return false, nil
+		}
+		if newValue == nil {
+			delete(db_value, key)
+		} else {
+			db_value[key] = newValue
+		}
+		var err error
+		if rec.Val, err = st.pder.getForDb(&db_value); err != nil {
+			return false, err
+		}
+		st.pder.touchRecord(rec, false)
+		swapped = true
+		return true, nil
+	})
+	if err != nil || !swapped {
+		return false, err
+	}
+
+	st.mx.Lock()
+	if newValue == nil {
+		delete(st.value, key)
+	} else {
+		st.value[key] = newValue
+	}
+	st.written()
+	st.mx.Unlock()
+
+	return true, nil
+}
+
+// Bucket returns a view of the session with keys prefixed by name, see session.NewBucket().
+func (st *SessionStore) Bucket(name string) session.Session {
+	return session.NewBucket(st, name)
+}
+
+// Keys returns sorted session value keys.
+func (st *SessionStore) Keys() ([]string, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	keys := make([]string, 0, len(st.value))
+	now := st.pder.clock.Now()
+	for key, v := range st.value {
+		if !session.ValueExpiredAt(now, v) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Len returns number of session values.
+func (st *SessionStore) Len() (int, error) {
+	keys, err := st.Keys()
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// SessionID returns session unique ID.
+func (st *SessionStore) SessionID() string {
+	return st.sid
+}
+
+// TimeCreated returns timeCreated property.
+func (st *SessionStore) TimeCreated() time.Time {
+	return st.timeCreated
+}
+
+// TimeAccessed returns timeAccessed property.
+func (st *SessionStore) TimeAccessed() time.Time {
+	return time.Unix(0, st.timeAccessed.Load())
+}
+
+// Lock acquires session lock. Lock key is created if it does not exist and is attached
+// to a lease of session.LockTTL(), so the lock is released automatically after that time.
+// Values are reloaded from database after the lock is acquired.
+func (st *SessionStore) Lock() error {
+	token, err := genLockToken()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), session.LOCK_WAIT)
+	defer cancel()
+	lease, err := st.pder.client.Grant(ctx, leaseSeconds(session.LockTTL()))
+	if err != nil {
+		return err
+	}
+	key := st.pder.lockKey(st.sid)
+	for {
+		resp, err := st.pder.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+			Then(clientv3.OpPut(key, token, clientv3.WithLease(lease.ID))).
+			Commit()
+		if err == nil && resp.Succeeded {
+			break
+		}
+		if err != nil && ctx.Err() == nil {
+			st.pder.revokeLease(lease.ID)
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			st.pder.revokeLease(lease.ID)
+			return session.ErrLockTimeout
+		case <-time.After(session.LOCK_RETRY):
+		}
+	}
+
+	st.mx.Lock()
+	st.lockLease = lease.ID
+	st.mx.Unlock()
+
+	return st.reload()
+}
+
+// Unlock releases session lock revoking its lease, the lock key is deleted with the lease.
+func (st *SessionStore) Unlock() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if st.lockLease == clientv3.NoLease {
+		return nil
+	}
+	if _, err := st.pder.client.Revoke(context.Background(), st.lockLease); err != nil && !isLeaseNotFound(err) {
+		return err
+	}
+	st.lockLease = clientv3.NoLease
+	return nil
+}
+
+// SetExpiry sets session expiration time to now+d, the session key is attached to a new lease
+// of d, so it is deleted by etcd after that time regardless of max life and idle time.
+// d <= 0 restores defaults: a lease of the rest of max life time or no lease.
+func (st *SessionStore) SetExpiry(d time.Duration) error {
+	ctx := context.Background()
+	created := st.timeCreated
+	ttl := d
+	if d <= 0 && st.pder.maxLifeTime > 0 {
+		ttl = time.Until(created.Add(time.Duration(st.pder.maxLifeTime) * time.Second))
+		if ttl < time.Second {
+			ttl = time.Second
+		}
+	}
+	opt := clientv3.WithLease(clientv3.NoLease)
+	var lease_id clientv3.LeaseID
+	if ttl > 0 {
+		lease, err := st.pder.client.Grant(ctx, leaseSeconds(ttl))
+		if err != nil {
+			return err
+		}
+		lease_id = lease.ID
+		opt = clientv3.WithLease(lease.ID)
+	}
+	err := st.pder.updateRecordWith(st.sid, func(rec *record) (bool, error) {
+		rec.ExpiresAt = 0
+		if d > 0 {
+			rec.ExpiresAt = st.pder.clock.Now().Add(d).UnixNano()
+		}
+		return true, nil
+	}, opt)
+	if err != nil && lease_id != clientv3.NoLease {
+		st.pder.revokeLease(lease_id)
+	}
+	return err
+}
+
+// Touch updates session access time in database,
+// nothing is done in session.EXPIRATION_FIXED mode.
+func (st *SessionStore) Touch() error {
+	if !st.pder.expMode.TouchOnWrite() {
+		return nil
+	}
+	now := st.pder.clock.Now()
+	if err := st.pder.updateRecord(st.sid, func(rec *record) (bool, error) {
+		rec.Accessed = now.UnixNano()
+		return true, nil
+	}); err != nil {
+		return err
+	}
+	st.timeAccessed.Store(now.UnixNano())
+	return nil
+}
+
+// Allow counts action in the current window of provider clock, see session.AllowRate().
+func (st *SessionStore) Allow(action string, limit int, window time.Duration) (bool, error) {
+	return session.AllowRate(st, st.pder.clock, action, limit, window)
+}
+
+// CountRate implements session.RateCounter, counters are kept in session record
+// written on condition its revision is not changed.
+func (st *SessionStore) CountRate(action string, now time.Time, window time.Duration) (int64, error) {
+	var cnt int64
+	err := st.pder.updateRecord(st.sid, func(rec *record) (bool, error) {
+		if rec.Rates == nil {
+			rec.Rates = make(session.RateCounters)
+		}
+		cnt = rec.Rates.Count(action, now, window)
+		st.pder.touchRecord(rec, false)
+		return true, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return cnt, nil
+}
+
+// SetSensitive sets value encrypted with keys of session.SetSensitiveKeys(), see session.SetSensitive().
+func (st *SessionStore) SetSensitive(key string, value interface{}) error {
+	return session.SetSensitive(st, key, value)
+}
+
+// lookup returns in-memory value by key under read lock and updates access time on success,
+// so concurrent readers do not serialize.
+func (st *SessionStore) lookup(key string) (interface{}, bool) {
+	st.mx.RLock()
+	v, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if ok {
+		st.accessed()
+	}
+	return v, ok
+}
+
+// accessed updates in-memory access time if it is updated on every access.
+// The time is stored atomically, st.mx is not required.
+func (st *SessionStore) accessed() {
+	if st.pder.expMode.TouchOnRead() {
+		st.timeAccessed.Store(st.pder.clock.Now().UnixNano())
+	}
+}
+
+// written updates in-memory access time after session is written to database.
+func (st *SessionStore) written() {
+	if st.pder.expMode.TouchOnWrite() {
+		st.timeAccessed.Store(st.pder.clock.Now().UnixNano())
+	}
+}
+
+// reload reads values from database to memory.
+func (st *SessionStore) reload() error {
+	rec, _, err := st.pder.getRecord(context.Background(), st.sid)
+	if err != nil {
+		return err
+	}
+	value := make(storeValue)
+	if rec != nil {
+		if err := st.pder.setFromDb(&value, rec.Val); err != nil {
+			return err
+		}
+	}
+	st.mx.Lock()
+	st.value = value
+	st.valueModified = false
+	st.mx.Unlock()
+	return nil
+}
+
+// Provider structure holds provider information.
+type Provider struct {
+	client         *clientv3.Client
+	namespace      string                  //key prefix
+	payloadVersion *session.PayloadVersion //payload versions, nil if not used
+	maxLifeTime    int64
+	maxIdleTime    int64
+	logger         *slog.Logger //structured logger, nil if not set
+	hookMx         sync.RWMutex
+	expiredHook    session.SessionHook //called for sessions removed by SessionGC and by lease expiry
+	gcLimit        int                 //max sessions removed by one SessionGC run, 0 if not limited
+	watchCancel    context.CancelFunc  //stops expiry watch
+
+	expMode session.ExpirationMode //when access time is updated
+	strict  bool                   //unknown sessions are not created on read, see SetStrictMode()
+	clock   session.Clock          //session times and expiration, see SetClock()
+
+	keyRing atomic.Pointer[session.KeyRing] //payload encryption, nil if not used
+}
+
+func (pder *Provider) NewSessionStore(sid string) *SessionStore {
+	store := &SessionStore{
+		pder:        pder,
+		sid:         sid,
+		timeCreated: pder.clock.Now(),
+		value:       make(map[string]interface{}, 0),
+	}
+	store.timeAccessed.Store(store.timeCreated.UnixNano())
+	return store
+}
+
+// SessionInit initializes session with given ID.
+// The session key is attached to a lease of max life time if it is set.
+// Existing session is kept.
+func (pder *Provider) SessionInit(sid string) (session.Session, error) {
+	if pder.client == nil {
+		return nil, session.ErrProviderNotInitialized
+	}
+
+	if len(sid) > SESS_ID_LEN {
+		return nil, fmt.Errorf("%w: length exceeds %d", session.ErrInvalidSessionID, SESS_ID_LEN)
+	}
+
+	ctx := context.Background()
+	store := pder.NewSessionStore(sid)
+	now := store.timeCreated.UnixNano()
+	data, err := json.Marshal(record{Created: now, Accessed: now})
+	if err != nil {
+		return nil, err
+	}
+	var opts []clientv3.OpOption
+	var lease_id clientv3.LeaseID
+	if pder.maxLifeTime > 0 {
+		lease, err := pder.client.Grant(ctx, pder.maxLifeTime)
+		if err != nil {
+			return nil, err
+		}
+		lease_id = lease.ID
+		opts = append(opts, clientv3.WithLease(lease.ID))
+	}
+	key := pder.sessionKey(sid)
+	resp, err := pder.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(data), opts...)).
+		Commit()
+	if err != nil || !resp.Succeeded {
+		if lease_id != clientv3.NoLease {
+			pder.revokeLease(lease_id)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// SessionRead reads session data from db to memory.
+// Expired session is destroyed, session.ErrSessionExpired is returned then.
+func (pder *Provider) SessionRead(sid string) (session.Session, error) {
+	if pder.client == nil {
+		return nil, session.ErrProviderNotInitialized
+	}
+
+	var prev record
+	var expired bool
+	err := pder.updateRecord(sid, func(rec *record) (bool, error) {
+		prev = *rec
+		if expired = pder.recordExpired(rec); expired {
+			return false, nil
+		}
+		return pder.touchRecord(rec, true), nil
+	})
+	if errors.Is(err, session.ErrSessionNotFound) {
+		//no such session
+		if pder.strict {
+			return nil, session.ErrSessionNotFound
+		}
+		return pder.SessionInit(sid)
+
+	} else if err != nil {
+		return nil, err
+	}
+
+	if expired {
+		if err := pder.SessionDestroy(sid); err != nil {
+			return nil, err
+		}
+		return nil, session.ErrSessionExpired
+	}
+
+	store := pder.NewSessionStore(sid)
+	if !pder.expMode.TouchOnRead() {
+		store.timeAccessed.Store(prev.Accessed)
+	}
+	store.timeCreated = time.Unix(0, prev.Created)
+	if err := pder.setFromDb(&store.value, prev.Val); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (pder *Provider) SessionClose(sid string) error {
+	return nil
+}
+
+// SessionDestroy destoys session by its ID with its lock.
+// Session lease is not revoked, it expires with no keys attached.
+func (pder *Provider) SessionDestroy(sid string) error {
+	_, err := pder.client.Txn(context.Background()).
+		Then(clientv3.OpDelete(pder.sessionKey(sid)), clientv3.OpDelete(pder.lockKey(sid))).
+		Commit()
+	return err
+}
+
+// SessionGC clears idle sessions scanning session keys.
+// Max life time and expiration time set by SessionStore.SetExpiry() are controled by etcd leases,
+// sessions with expiration time are not checked for idling.
+// A session is deleted on condition it is not modified since it was read.
+func (pder *Provider) SessionGC(l io.Writer, logLev session.LogLevel) session.GCReport {
+	//life time is controled by etcd
+	if pder.maxIdleTime == 0 {
+		return session.GCReport{}
+	}
+
+	log := pder.getLogger(l, logLev).With(session.LOG_KEY_OPERATION, "SessionGC")
+	start := time.Now()
+	ctx := context.Background()
+	var report session.GCReport
+	err := pder.scanRecords(ctx, func(sid string, rec *record, rev int64) (bool, error) {
+		if pder.gcLimit > 0 && report.Deleted() >= pder.gcLimit {
+			return false, nil
+		}
+		report.Scanned++
+		if rec.ExpiresAt != 0 || !session.IsExpiredAt(pder.clock.Now(), time.Unix(0, rec.Created), time.Unix(0, rec.Accessed), time.Time{}, 0, pder.maxIdleTime) {
+			return true, nil
+		}
+		log.Debug(LOG_PREF+"deleting session", session.LOG_KEY_SID, sid)
+		key := pder.sessionKey(sid)
+		resp, err := pder.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).
+			Then(clientv3.OpDelete(key), clientv3.OpDelete(pder.lockKey(sid))).
+			Commit()
+		if err != nil {
+			report.Errors++
+			log.Error(LOG_PREF+"Txn() failed", session.LOG_KEY_SID, sid, session.LOG_KEY_ERROR, err)
+			return true, nil
+		}
+		if resp.Succeeded {
+			report.DeletedIdle++
+			pder.sessionExpired(sid)
+		}
+		return true, nil
+	})
+	if err != nil {
+		report.Errors++
+		log.Error(LOG_PREF+"scanRecords() failed", session.LOG_KEY_ERROR, err)
+	}
+	log.Debug(fmt.Sprintf(LOG_PREF+"SessionGC() done, %d sessions deleted", report.Deleted()), session.LOG_KEY_DURATION, time.Since(start))
+	return report
+}
+
+// SessionCount returns number of stored sessions, counted by etcd.
+func (pder *Provider) SessionCount() (int, error) {
+	resp, err := pder.client.Get(context.Background(), pder.namespace+SESSIONS_PREFIX, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return 0, err
+	}
+	return int(resp.Count), nil
+}
+
+// SessionList returns session metadata ordered by session ID,
+// keys are sorted by etcd, skipped sessions are read as well.
+func (pder *Provider) SessionList(offset, limit int) ([]session.SessionMeta, error) {
+	list := make([]session.SessionMeta, 0)
+	n := 0
+	err := pder.scanRecords(context.Background(), func(sid string, rec *record, rev int64) (bool, error) {
+		if limit > 0 && len(list) >= limit {
+			return false, nil
+		}
+		if n >= offset {
+			list = append(list, pder.recordMeta(sid, rec))
+		}
+		n++
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// SessionMeta implements session.MetaProvider, session values are not decoded.
+// ExpiresAt is set only if expiration is set by SessionStore.SetExpiry().
+func (pder *Provider) SessionMeta(sid string) (session.SessionMeta, error) {
+	rec, _, err := pder.getRecord(context.Background(), sid)
+	if err != nil {
+		return session.SessionMeta{}, err
+	}
+	if rec == nil {
+		return session.SessionMeta{}, session.ErrSessionNotFound
+	}
+	return pder.recordMeta(sid, rec), nil
+}
+
+// SessionPeek implements session.PeekProvider: session values are read
+// without updating access time, missing session is not created, expired one is not removed.
+func (pder *Provider) SessionPeek(sid string) (session.Session, error) {
+	if pder.client == nil {
+		return nil, session.ErrProviderNotInitialized
+	}
+	rec, _, err := pder.getRecord(context.Background(), sid)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, session.ErrSessionNotFound
+	}
+	if pder.recordExpired(rec) {
+		return nil, session.ErrSessionExpired
+	}
+	store := pder.NewSessionStore(sid)
+	store.timeAccessed.Store(rec.Accessed)
+	store.timeCreated = time.Unix(0, rec.Created)
+	if err := pder.setFromDb(&store.value, rec.Val); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (pder *Provider) recordMeta(sid string, rec *record) session.SessionMeta {
+	meta := session.SessionMeta{ID: sid,
+		TimeCreated:  time.Unix(0, rec.Created),
+		TimeAccessed: time.Unix(0, rec.Accessed),
+		Size:         len(rec.Val),
+		Provider:     PROVIDER,
+	}
+	if rec.ExpiresAt != 0 {
+		meta.ExpiresAt = time.Unix(0, rec.ExpiresAt)
+	}
+	return meta
+}
+
+// DestroyAllSessions deletes all session and lock keys of the namespace.
+func (pder *Provider) DestroyAllSessions(l io.Writer, logLev session.LogLevel) {
+	log := pder.getLogger(l, logLev).With(session.LOG_KEY_OPERATION, "DestroyAllSessions")
+	start := time.Now()
+	resp, err := pder.client.Txn(context.Background()).
+		Then(clientv3.OpDelete(pder.namespace+SESSIONS_PREFIX, clientv3.WithPrefix()),
+			clientv3.OpDelete(pder.namespace+LOCKS_PREFIX, clientv3.WithPrefix())).
+		Commit()
+	if err != nil {
+		log.Error(LOG_PREF+"Txn() failed", session.LOG_KEY_ERROR, err)
+		return
+	}
+	deleted := resp.Responses[0].GetResponseDeleteRange().Deleted
+	log.Debug(fmt.Sprintf(LOG_PREF+"DestroyAllSessions() done, %d sessions deleted", deleted), session.LOG_KEY_DURATION, time.Since(start))
+}
+
+// scanRecords calls fn for every stored session in the order of session IDs
+// reading SCAN_PAGE_SIZE keys at once, scanning is stopped if fn returns false.
+func (pder *Provider) scanRecords(ctx context.Context, fn func(sid string, rec *record, rev int64) (bool, error)) error {
+	prefix := pder.namespace + SESSIONS_PREFIX
+	end := clientv3.GetPrefixRangeEnd(prefix)
+	from := prefix
+	for {
+		resp, err := pder.client.Get(ctx, from, clientv3.WithRange(end), clientv3.WithLimit(SCAN_PAGE_SIZE),
+			clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+		if err != nil {
+			return err
+		}
+		for _, kv := range resp.Kvs {
+			var rec record
+			if err := json.Unmarshal(kv.Value, &rec); err != nil {
+				return fmt.Errorf("%s: %w", kv.Key, err)
+			}
+			next, err := fn(strings.TrimPrefix(string(kv.Key), prefix), &rec, kv.ModRevision)
+			if err != nil || !next {
+				return err
+			}
+		}
+		if !resp.More || len(resp.Kvs) == 0 {
+			return nil
+		}
+		from = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+}
+
+// getRecord returns stored session with its modification revision, nil if there is no session.
+func (pder *Provider) getRecord(ctx context.Context, sid string) (*record, int64, error) {
+	resp, err := pder.client.Get(ctx, pder.sessionKey(sid))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, 0, nil
+	}
+	var rec record
+	if err := json.Unmarshal(resp.Kvs[0].Value, &rec); err != nil {
+		return nil, 0, err
+	}
+	return &rec, resp.Kvs[0].ModRevision, nil
+}
+
+// updateRecord applies fn to the stored session keeping its lease, see updateRecordWith().
+func (pder *Provider) updateRecord(sid string, fn func(rec *record) (bool, error)) error {
+	return pder.updateRecordWith(sid, fn, clientv3.WithIgnoreLease())
+}
+
+// updateRecordWith applies fn to the stored session and writes it with leaseOpt on condition
+// the session is not modified since it was read, the operation is retried up to INCR_MAX_RETRIES times.
+// The session is not written if fn returns false. session.ErrSessionNotFound is returned
+// if there is no session.
+func (pder *Provider) updateRecordWith(sid string, fn func(rec *record) (bool, error), leaseOpt clientv3.OpOption) error {
+	ctx := context.Background()
+	key := pder.sessionKey(sid)
+	for i := 0; i < INCR_MAX_RETRIES; i++ {
+		rec, rev, err := pder.getRecord(ctx, sid)
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			return session.ErrSessionNotFound
+		}
+		write, err := fn(rec)
+		if err != nil || !write {
+			return err
+		}
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		resp, err := pder.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).
+			Then(clientv3.OpPut(key, string(data), leaseOpt)).
+			Commit()
+		if err != nil {
+			return err
+		}
+		if resp.Succeeded {
+			return nil
+		}
+		//session modified concurrently
+	}
+	return errors.New("updateRecord: max retries exceeded")
+}
+
+// touchRecord sets access time of the record on session read or write depending on expiration mode,
+// returns true if the time is set.
+func (pder *Provider) touchRecord(rec *record, read bool) bool {
+	if read && pder.expMode.TouchOnRead() || !read && pder.expMode.TouchOnWrite() || rec.Accessed == 0 {
+		rec.Accessed = pder.clock.Now().UnixNano()
+		return true
+	}
+	return false
+}
+
+// recordExpired reports if the session is expired but not yet deleted by GC or etcd.
+func (pder *Provider) recordExpired(rec *record) bool {
+	if rec.ExpiresAt != 0 {
+		return session.IsExpiredAt(pder.clock.Now(), time.Unix(0, rec.Created), time.Unix(0, rec.Accessed), time.Unix(0, rec.ExpiresAt), 0, 0)
+	}
+	return session.IsExpiredAt(pder.clock.Now(), time.Unix(0, rec.Created), time.Unix(0, rec.Accessed), time.Time{}, pder.maxLifeTime, pder.maxIdleTime)
+}
+
+// leaseExpired reports if the deleted session is deleted by its lease: its expiration time
+// or max life time is passed.
+func (pder *Provider) leaseExpired(rec *record) bool {
+	deadline := time.Unix(0, rec.ExpiresAt)
+	if rec.ExpiresAt == 0 {
+		if pder.maxLifeTime == 0 {
+			return false
+		}
+		deadline = time.Unix(0, rec.Created).Add(time.Duration(pder.maxLifeTime) * time.Second)
+	}
+	return !pder.clock.Now().Add(EXPIRY_TOLERANCE).Before(deadline)
+}
+
+// watchExpired watches deleted session keys and calls expired hook for sessions deleted by lease expiry.
+// The watch is restarted if it is closed by the server till ctx is cancelled.
+func (pder *Provider) watchExpired(ctx context.Context) {
+	prefix := pder.namespace + SESSIONS_PREFIX
+	log := pder.getLogger(nil, session.LOG_LEVEL_ERROR).With(session.LOG_KEY_OPERATION, "watchExpired")
+	for {
+		watch := pder.client.Watch(clientv3.WithRequireLeader(ctx), prefix,
+			clientv3.WithPrefix(), clientv3.WithPrevKV(), clientv3.WithFilterPut())
+		for resp := range watch {
+			if err := resp.Err(); err != nil {
+				log.Error(LOG_PREF+"watch failed", session.LOG_KEY_ERROR, err)
+				continue
+			}
+			for _, ev := range resp.Events {
+				if ev.Type != clientv3.EventTypeDelete || ev.PrevKv == nil {
+					continue
+				}
+				var rec record
+				if err := json.Unmarshal(ev.PrevKv.Value, &rec); err != nil || !pder.leaseExpired(&rec) {
+					continue
+				}
+				pder.sessionExpired(strings.TrimPrefix(string(ev.Kv.Key), prefix))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(WATCH_RETRY):
+		}
+	}
+}
+
+// SetGCMaxDeletions implements session.GCLimiter.
+func (pder *Provider) SetGCMaxDeletions(maxDeletions int) {
+	pder.gcLimit = maxDeletions
+}
+
+// SetExpirationMode implements session.ExpirationModeSetter.
+func (pder *Provider) SetExpirationMode(mode session.ExpirationMode) {
+	pder.expMode = mode
+}
+
+// SetStrictMode implements session.StrictModeSetter: SessionRead() returns session.ErrSessionNotFound
+// for an unknown session instead of creating it.
+func (pder *Provider) SetStrictMode(strict bool) error {
+	pder.strict = strict
+	return nil
+}
+
+// SetClock implements session.ClockSetter, session times, idle time checked by GC and expiration checked on read are of the clock.
+// Leases run in real time.
+// Nil restores session.SystemClock.
+func (pder *Provider) SetClock(clock session.Clock) {
+	if clock == nil {
+		clock = session.SystemClock{}
+	}
+	pder.clock = clock
+}
+
+// SetExpiredHook sets callback for sessions removed by SessionGC and by lease expiry.
+func (pder *Provider) SetExpiredHook(hook session.SessionHook) {
+	pder.hookMx.Lock()
+	defer pder.hookMx.Unlock()
+	pder.expiredHook = hook
+}
+
+// sessionExpired calls expired hook if it is set.
+func (pder *Provider) sessionExpired(sid string) {
+	pder.hookMx.RLock()
+	hook := pder.expiredHook
+	pder.hookMx.RUnlock()
+	if hook != nil {
+		hook(sid)
+	}
+}
+
+// SetLogger sets structured logger.
+func (pder *Provider) SetLogger(logger *slog.Logger) {
+	pder.logger = logger
+}
+
+// getLogger returns provider logger or io.Writer adapter if logger is not set.
+func (pder *Provider) getLogger(l io.Writer, logLev session.LogLevel) *slog.Logger {
+	return session.LoggerFor(pder.logger, l, logLev).With(session.LOG_KEY_PROVIDER, PROVIDER)
+}
+
+func (pder *Provider) SetMaxLifeTime(maxLifeTime int64) {
+	pder.maxLifeTime = maxLifeTime
+}
+func (pder *Provider) GetMaxLifeTime() int64 {
+	return pder.maxLifeTime
+}
+
+func (pder *Provider) SetMaxIdleTime(maxIdleTime int64) {
+	pder.maxIdleTime = maxIdleTime
+}
+
+func (pder *Provider) GetMaxIdleTime() int64 {
+	return pder.maxIdleTime
+}
+
+// SetKeyRing sets keys for payload encryption.
+func (pder *Provider) SetKeyRing(keyRing *session.KeyRing) {
+	pder.keyRing.Store(keyRing)
+}
+
+// SetPayloadVersion sets payload version, nil disables versions.
+func (pder *Provider) SetPayloadVersion(payloadVersion *session.PayloadVersion) {
+	pder.payloadVersion = payloadVersion
+}
+
+// InitProvider initializes etcd provider and starts expiry watch.
+// Function expects parameters:
+//
+//	First parameter: *clientv3.Client
+//	Second parameter: namespace string, key prefix, e.g. "myapp/sessions/"
+//	Third parameter (optional): encryptKey string, if set session payload is encrypted with AES-GCM.
+func (pder *Provider) InitProvider(provParams []interface{}) error {
+	if len(provParams) < 2 {
+		return errors.New("InitProvider missing parameters: *clientv3.Client, namespace")
+	}
+	var ok bool
+	pder.client, ok = provParams[0].(*clientv3.Client)
+	if !ok || pder.client == nil {
+		return errors.New("InitProvider client parameter(0) must be of type *clientv3.Client")
+	}
+
+	pder.namespace, ok = provParams[1].(string)
+	if !ok {
+		return errors.New("InitProvider namespace parameter(1) must be a string")
+	}
+
+	if len(provParams) >= 3 {
+		encrkey, ok := provParams[2].(string)
+		if !ok {
+			return errors.New("InitProvider encryptKey parameter(2) must be a string")
+		}
+		if encrkey != "" {
+			key_ring, err := session.NewKeyRing([]byte(encrkey))
+			if err != nil {
+				return err
+			}
+			pder.keyRing.Store(key_ring)
+		}
+	}
+
+	pder.stopWatch()
+	var ctx context.Context
+	ctx, pder.watchCancel = context.WithCancel(context.Background())
+	go pder.watchExpired(ctx)
+
+	return nil
+}
+
+// CloseProvider stops expiry watch, client is owned by the caller.
+func (pder *Provider) CloseProvider() error {
+	pder.stopWatch()
+	return nil
+}
+
+func (pder *Provider) stopWatch() {
+	if pder.watchCancel != nil {
+		pder.watchCancel()
+		pder.watchCancel = nil
+	}
+}
+
+// Ping checks that etcd cluster is available reading a key.
+func (pder *Provider) Ping(ctx context.Context) error {
+	if pder.client == nil {
+		return session.ErrProviderNotInitialized
+	}
+	_, err := pder.client.Get(ctx, pder.namespace+SESSIONS_PREFIX, clientv3.WithCountOnly())
+	return err
+}
+
+func (pder *Provider) GetSessionIDLen() int {
+	return SESS_ID_LEN
+}
+
+func (pder *Provider) sessionKey(sid string) string {
+	return pder.namespace + SESSIONS_PREFIX + sid
+}
+
+func (pder *Provider) lockKey(sid string) string {
+	return pder.namespace + LOCKS_PREFIX + sid
+}
+
+// revokeLease revokes unused lease, errors are ignored as the lease expires anyway.
+func (pder *Provider) revokeLease(id clientv3.LeaseID) {
+	pder.client.Revoke(context.Background(), id)
+}
+
+// leaseSeconds returns lease TTL rounded up to seconds.
+func leaseSeconds(d time.Duration) int64 {
+	secs := int64((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		return 1
+	}
+	return secs
+}
+
+func isLeaseNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "requested lease not found")
+}
+
+// genLockToken returns random lock owner token.
+func genLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// setFromDb is a helper function, called on retrieving value from data base.
+// It decrypts and decodes data base value for in-memory store.
+func (pder *Provider) setFromDb(strucVal *storeValue, dbVal []byte) error {
+	if len(dbVal) == 0 {
+		return nil
+	}
+	if key_ring := pder.keyRing.Load(); key_ring != nil {
+		var err error
+		if dbVal, err = key_ring.DecryptPayload(dbVal); err != nil {
+			return err
+		}
+	}
+	if err := pder.payloadVersion.Decode(dbVal, (*map[string]interface{})(strucVal)); err != nil {
+		return err
+	}
+	session.PurgeExpiredValuesAt(pder.clock.Now(), *strucVal) //values set with SetWithTTL() are filtered on read
+	return nil
+}
+
+// getForDb is a helper function called before putting value to database.
+// It encodes and encrypts in-memory session value for data base.
+func (pder *Provider) getForDb(strucVal *storeValue) ([]byte, error) {
+	val, err := pder.payloadVersion.Encode(strucVal)
+	if err != nil {
+		return []byte{}, err
+	}
+	if key_ring := pder.keyRing.Load(); key_ring != nil {
+		return key_ring.Encrypt(val)
+	}
+	return val, nil
+}
+
+func init() {
+	session.Register(PROVIDER, pder)
+}
@@ -0,0 +1,160 @@
+// testing functions for session/etcd.
+// Testing asumes the following:
+//
+//	etcd cluster is accessible at ETCD_ENDPOINTS, comma separated endpoints, e.g. localhost:2379.
+//	Keys are created with ETCD_NAMESPACE prefix.
+package etcd
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dronm/session" //session manager
+	"github.com/dronm/session/testkit"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	ENV_ETCD_ENDPOINTS = "ETCD_ENDPOINTS"
+	ENV_ETCD_NAMESPACE = "ETCD_NAMESPACE"
+)
+
+func getTestVar(t *testing.T, n string) string {
+	v := os.Getenv(n)
+	if v == "" {
+		t.Fatalf("getTestVar() failed: %s environment variable is not set", n)
+	}
+	return v
+}
+
+func NewManager(t *testing.T, idleTime int64, lifeTime int64, killTime string) (*session.Manager, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   strings.Split(getTestVar(t, ENV_ETCD_ENDPOINTS), ","),
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("clientv3.New() failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return session.NewManager(PROVIDER, idleTime, lifeTime, killTime, client, getTestVar(t, ENV_ETCD_NAMESPACE))
+}
+
+func TestSession(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer SessManager.CloseProvider()
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+	testkit.CompareValues(t, currentSession, tests)
+	if err := SessManager.SessionClose(sid); err != nil {
+		t.Fatalf("SessionClose() failed: %v", err)
+	}
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	testkit.CompareValues(t, currentSession, tests)
+	if err := SessManager.SessionClose(sid); err != nil {
+		t.Fatalf("SessionClose() failed: %v", err)
+	}
+
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	testkit.AssertNoValues(t, currentSession, tests)
+}
+
+// TestLeaseExpiry checks that a session is deleted by its lease and the expired hook is called by the watch.
+func TestLeaseExpiry(t *testing.T) {
+	var life_time int64 = 2
+	SessManager, err := NewManager(t, life_time, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer SessManager.CloseProvider()
+
+	expired := make(chan string, 10)
+	if err := SessManager.OnSessionExpired(func(sid string) {
+		expired <- sid
+	}); err != nil {
+		t.Fatalf("OnSessionExpired() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Put("key", "value"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	SessManager.SessionClose(sid)
+
+	timeout := time.After(time.Duration(life_time)*time.Second + 5*time.Second)
+	for {
+		select {
+		case got := <-expired:
+			if got != sid {
+				continue
+			}
+			if _, err := SessManager.SessionMeta(sid); err != session.ErrSessionNotFound {
+				t.Fatalf("SessionMeta() wanted ErrSessionNotFound, got %v", err)
+			}
+			return
+		case <-timeout:
+			t.Fatal("expired hook is not called")
+		}
+	}
+}
+
+// TestIdleTime checks that idle sessions are deleted by SessionGC.
+func TestIdleTime(t *testing.T) {
+	var idle_time int64 = 1
+	SessManager, err := NewManager(t, 0, idle_time, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer SessManager.CloseProvider()
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	SessManager.SessionClose(sid)
+
+	time.Sleep(time.Duration(idle_time)*time.Second + 500*time.Millisecond)
+	if report := SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_ERROR); report.DeletedIdle < 1 || report.Errors != 0 {
+		t.Fatalf("SessionGC() wanted idle session deleted, got %+v", report)
+	}
+	if _, err := SessManager.SessionMeta(sid); err != session.ErrSessionNotFound {
+		t.Fatalf("SessionMeta() wanted ErrSessionNotFound, got %v", err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	testkit.Run(t, func(t *testing.T, maxLifeTime, maxIdleTime int64) *session.Manager {
+		manager, err := NewManager(t, maxLifeTime, maxIdleTime, "")
+		if err != nil {
+			t.Fatalf("NewManager() failed: %v", err)
+		}
+		t.Cleanup(func() { manager.CloseProvider() })
+		return manager
+	})
+}
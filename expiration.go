@@ -0,0 +1,48 @@
+package session
+
+// ExpirationMode defines when session access time, idle time is counted from, is updated.
+type ExpirationMode int
+
+const (
+	EXPIRATION_SLIDING  ExpirationMode = iota //access time is updated on every read and write, default
+	EXPIRATION_FIXED                          //access time is never updated, idle time is counted from creation
+	EXPIRATION_EXPLICIT                       //access time is updated by Touch() and writes to storage only
+)
+
+// TouchOnRead reports if access time is updated when session is read.
+func (mode ExpirationMode) TouchOnRead() bool {
+	return mode == EXPIRATION_SLIDING
+}
+
+// TouchOnWrite reports if access time is updated by Touch() and when session values are written to storage.
+func (mode ExpirationMode) TouchOnWrite() bool {
+	return mode != EXPIRATION_FIXED
+}
+
+// ExpirationModeSetter is an optional interface for providers supporting expiration modes.
+type ExpirationModeSetter interface {
+	SetExpirationMode(ExpirationMode)
+}
+
+// SetExpirationMode sets when providers update session access time:
+// EXPIRATION_SLIDING on every read and write, EXPIRATION_EXPLICIT on Touch(), Flush()
+// and other writes to storage, EXPIRATION_FIXED never, so idle time works as a life time.
+// Decorators pass the mode to every inner provider.
+// Provider must implement ExpirationModeSetter interface.
+func (manager *Manager) SetExpirationMode(mode ExpirationMode) error {
+	setter, ok := manager.provider.(ExpirationModeSetter)
+	if !ok {
+		return ErrNoExpirationMode
+	}
+	setter.SetExpirationMode(mode)
+	return nil
+}
+
+// setExpirationMode passes expiration mode to providers implementing ExpirationModeSetter.
+func setExpirationMode(mode ExpirationMode, providers ...Provider) {
+	for _, p := range providers {
+		if setter, ok := p.(ExpirationModeSetter); ok {
+			setter.SetExpirationMode(mode)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// FallbackProvider is a two-tier provider: sessions are kept by the primary provider (e.g. redis),
+// their modifications are mirrored to the secondary provider (e.g. sqlite).
+// When the primary provider fails to start a session, it is considered unreachable
+// and sessions are served by the secondary provider, so users keep their sessions during the outage.
+// Sessions used during the outage are copied back to the primary provider
+// by a background resync every resync interval, then the primary provider is used again.
+//
+// Values are copied to the primary provider with Get() into interface{},
+// so the secondary provider should keep values in memory (sqlite, pg, bolt).
+type FallbackProvider struct {
+	primary        Provider
+	secondary      Provider
+	resyncInterval time.Duration
+	mx             sync.Mutex
+	primaryDown    bool
+	dirty          map[string]struct{} //sessions used during outage
+	destroyed      map[string]struct{} //sessions destroyed during outage
+	logger         *slog.Logger
+	stop           chan struct{}
+	done           chan struct{}
+}
+
+// NewFallbackProvider returns two-tier provider. The result should be registered
+// under its own name with Register(), then used with NewManager(), provider parameters
+// are []interface{} parameters of the primary provider followed by the ones of the secondary provider:
+//
+//	redis_pder, _ := session.LookupProvider("redis")
+//	sqlite_pder, _ := session.LookupProvider("sqlite")
+//	session.Register("redis_sqlite", session.NewFallbackProvider(redis_pder, sqlite_pder, 10*time.Second))
+//	SessManager, err := session.NewManager("redis_sqlite", 0, 3600, "",
+//		[]interface{}{REDIS_ADDR, REDIS_NAMESPACE}, []interface{}{SQLITE_FILE})
+func NewFallbackProvider(primary, secondary Provider, resyncInterval time.Duration) *FallbackProvider {
+	return &FallbackProvider{primary: primary,
+		secondary:      secondary,
+		resyncInterval: resyncInterval,
+		dirty:          make(map[string]struct{}),
+		destroyed:      make(map[string]struct{}),
+	}
+}
+
+// InitProvider initializes both providers, parameters are two slices
+// of primary and secondary provider parameters.
+// Unreachable primary provider is not an error, the secondary one is used then.
+func (fpder *FallbackProvider) InitProvider(provParams []interface{}) error {
+	params, err := splitProviderParams(provParams, 2)
+	if err != nil {
+		return err
+	}
+	if err := fpder.secondary.InitProvider(params[1]); err != nil {
+		return err
+	}
+	if err := fpder.primary.InitProvider(params[0]); err != nil {
+		fpder.setPrimaryDown(err)
+	}
+
+	if fpder.resyncInterval > 0 {
+		fpder.stop = make(chan struct{})
+		fpder.done = make(chan struct{})
+		go fpder.resyncLoop(fpder.stop, fpder.done)
+	}
+	return nil
+}
+
+// CloseProvider stops resync and closes both providers.
+func (fpder *FallbackProvider) CloseProvider() error {
+	if fpder.stop != nil {
+		close(fpder.stop)
+		<-fpder.done
+		fpder.stop = nil
+	}
+	return errors.Join(fpder.primary.CloseProvider(), fpder.secondary.CloseProvider())
+}
+
+// Ping checks both providers. Unreachable primary provider is marked down,
+// error is returned only if the secondary provider is unreachable too,
+// as sessions are still served then.
+func (fpder *FallbackProvider) Ping(ctx context.Context) error {
+	if err := fpder.primary.Ping(ctx); err != nil {
+		fpder.setPrimaryDown(err)
+		if sec_err := fpder.secondary.Ping(ctx); sec_err != nil {
+			return errors.Join(err, sec_err)
+		}
+	}
+	return nil
+}
+
+// PrimaryDown returns true if sessions are served by the secondary provider.
+func (fpder *FallbackProvider) PrimaryDown() bool {
+	fpder.mx.Lock()
+	defer fpder.mx.Unlock()
+	return fpder.primaryDown
+}
+
+func (fpder *FallbackProvider) setPrimaryDown(err error) {
+	fpder.mx.Lock()
+	defer fpder.mx.Unlock()
+	if !fpder.primaryDown {
+		fpder.log().Warn("primary provider is unreachable, using secondary provider",
+			LOG_KEY_OPERATION, "fallback", LOG_KEY_ERROR, err)
+	}
+	fpder.primaryDown = true
+}
+
+// secondarySession marks session as used during outage.
+func (fpder *FallbackProvider) secondarySession(sid string) {
+	fpder.mx.Lock()
+	defer fpder.mx.Unlock()
+	fpder.dirty[sid] = struct{}{}
+	delete(fpder.destroyed, sid)
+}
+
+func (fpder *FallbackProvider) log() *slog.Logger {
+	return LoggerFor(fpder.logger, nil, LOG_LEVEL_ERROR)
+}
+
+// start opens session with primary provider, secondary provider is used if it fails.
+func (fpder *FallbackProvider) start(sid string, open func(Provider, string) (Session, error)) (Session, error) {
+	if !fpder.PrimaryDown() {
+		sess, err := open(fpder.primary, sid)
+		if err == nil {
+			return newReplicatedSession(sess, 1, fpder.secondary), nil
+		}
+		if errors.Is(err, ErrSessionExpired) {
+			fpder.secondary.SessionDestroy(sid)
+			return nil, err
+		}
+		fpder.setPrimaryDown(err)
+	}
+	sess, err := open(fpder.secondary, sid)
+	if err != nil {
+		return nil, err
+	}
+	fpder.secondarySession(sess.SessionID())
+	return sess, nil
+}
+
+// SessionInit creates a new session.
+func (fpder *FallbackProvider) SessionInit(sid string) (Session, error) {
+	return fpder.start(sid, Provider.SessionInit)
+}
+
+// SessionRead reads session.
+func (fpder *FallbackProvider) SessionRead(sid string) (Session, error) {
+	return fpder.start(sid, Provider.SessionRead)
+}
+
+// SessionDestroy destroys session with both providers.
+func (fpder *FallbackProvider) SessionDestroy(sid string) error {
+	if err := fpder.secondary.SessionDestroy(sid); err != nil {
+		return err
+	}
+	if fpder.PrimaryDown() {
+		fpder.mx.Lock()
+		fpder.destroyed[sid] = struct{}{}
+		delete(fpder.dirty, sid)
+		fpder.mx.Unlock()
+		return nil
+	}
+	return fpder.primary.SessionDestroy(sid)
+}
+
+// SessionClose closes session with both providers.
+func (fpder *FallbackProvider) SessionClose(sid string) error {
+	if !fpder.PrimaryDown() {
+		if err := fpder.primary.SessionClose(sid); err != nil {
+			return err
+		}
+	}
+	return fpder.secondary.SessionClose(sid)
+}
+
+// SessionGC runs GC of both providers.
+func (fpder *FallbackProvider) SessionGC(l io.Writer, logLev LogLevel) GCReport {
+	var report GCReport
+	if !fpder.PrimaryDown() {
+		report = fpder.primary.SessionGC(l, logLev)
+	}
+	report.Add(fpder.secondary.SessionGC(l, logLev))
+	return report
+}
+
+// SetGCMaxDeletions implements GCLimiter, the limit is set for both providers.
+func (fpder *FallbackProvider) SetGCMaxDeletions(maxDeletions int) {
+	setGCMaxDeletions(maxDeletions, fpder.primary, fpder.secondary)
+}
+
+// SetExpirationMode implements ExpirationModeSetter, the mode is set for both providers.
+func (fpder *FallbackProvider) SetExpirationMode(mode ExpirationMode) {
+	setExpirationMode(mode, fpder.primary, fpder.secondary)
+}
+
+// SetStrictMode implements StrictModeSetter, the mode is set for both providers.
+// ErrNoStrictMode is returned if either provider does not support strict mode.
+func (fpder *FallbackProvider) SetStrictMode(strict bool) error {
+	return setStrictMode(strict, fpder.primary, fpder.secondary)
+}
+
+// SetClock implements ClockSetter, the clock is set for both providers.
+func (fpder *FallbackProvider) SetClock(clock Clock) {
+	setClock(clock, fpder.primary, fpder.secondary)
+}
+
+// SessionStats implements StatsProvider with the provider serving sessions,
+// stats of the primary and secondary providers are in Providers, the primary is skipped while it is down.
+func (fpder *FallbackProvider) SessionStats(ctx context.Context, sampleSize int) (SessionStats, error) {
+	sec_stats, err := ProviderStats(ctx, fpder.secondary, sampleSize)
+	if err != nil {
+		return SessionStats{}, err
+	}
+	if fpder.PrimaryDown() {
+		stats := sec_stats
+		stats.Provider = ""
+		stats.Providers = []SessionStats{sec_stats}
+		return stats, nil
+	}
+	prim_stats, err := ProviderStats(ctx, fpder.primary, sampleSize)
+	if err != nil {
+		return SessionStats{}, err
+	}
+	stats := prim_stats
+	stats.Provider = ""
+	stats.Providers = []SessionStats{prim_stats, sec_stats}
+	return stats, nil
+}
+
+// SessionMeta implements MetaProvider with the provider serving sessions.
+func (fpder *FallbackProvider) SessionMeta(sid string) (SessionMeta, error) {
+	if fpder.PrimaryDown() {
+		return sessionMeta(fpder.secondary, sid)
+	}
+	return sessionMeta(fpder.primary, sid)
+}
+
+// SessionPeek implements PeekProvider with primary provider, with secondary one if primary is down.
+func (fpder *FallbackProvider) SessionPeek(sid string) (Session, error) {
+	if fpder.PrimaryDown() {
+		return sessionPeek(fpder.secondary, sid)
+	}
+	return sessionPeek(fpder.primary, sid)
+}
+
+// AcquireGCLeader implements GCLeaderLocker with primary provider.
+func (fpder *FallbackProvider) AcquireGCLeader(name string, ttl time.Duration) (bool, error) {
+	locker, err := gcLeaderLocker(fpder.primary)
+	if err != nil {
+		return false, err
+	}
+	return locker.AcquireGCLeader(name, ttl)
+}
+
+// ReleaseGCLeader implements GCLeaderLocker with primary provider.
+func (fpder *FallbackProvider) ReleaseGCLeader(name string) error {
+	locker, err := gcLeaderLocker(fpder.primary)
+	if err != nil {
+		return err
+	}
+	return locker.ReleaseGCLeader(name)
+}
+
+// DestroyAllSessions destroys all sessions of both providers.
+func (fpder *FallbackProvider) DestroyAllSessions(l io.Writer, logLev LogLevel) {
+	if !fpder.PrimaryDown() {
+		fpder.primary.DestroyAllSessions(l, logLev)
+	}
+	fpder.secondary.DestroyAllSessions(l, logLev)
+}
+
+// GetSessionIDLen returns session ID length of the primary provider.
+func (fpder *FallbackProvider) GetSessionIDLen() int {
+	return fpder.primary.GetSessionIDLen()
+}
+
+// SetMaxLifeTime sets max life time for both providers.
+func (fpder *FallbackProvider) SetMaxLifeTime(maxLifeTime int64) {
+	fpder.primary.SetMaxLifeTime(maxLifeTime)
+	fpder.secondary.SetMaxLifeTime(maxLifeTime)
+}
+
+// GetMaxLifeTime returns max life time.
+func (fpder *FallbackProvider) GetMaxLifeTime() int64 {
+	return fpder.primary.GetMaxLifeTime()
+}
+
+// SetMaxIdleTime sets max idle time for both providers.
+func (fpder *FallbackProvider) SetMaxIdleTime(maxIdleTime int64) {
+	fpder.primary.SetMaxIdleTime(maxIdleTime)
+	fpder.secondary.SetMaxIdleTime(maxIdleTime)
+}
+
+// GetMaxIdleTime returns max idle time.
+func (fpder *FallbackProvider) GetMaxIdleTime() int64 {
+	return fpder.primary.GetMaxIdleTime()
+}
+
+// SetKeyRing passes key ring to providers implementing EncryptedProvider.
+func (fpder *FallbackProvider) SetKeyRing(keyRing *KeyRing) {
+	for _, p := range []Provider{fpder.primary, fpder.secondary} {
+		if enc_pder, ok := p.(EncryptedProvider); ok {
+			enc_pder.SetKeyRing(keyRing)
+		}
+	}
+}
+
+// SetCompression passes compression to providers implementing CompressedProvider.
+func (fpder *FallbackProvider) SetCompression(compression *Compression) {
+	for _, p := range []Provider{fpder.primary, fpder.secondary} {
+		if comp_pder, ok := p.(CompressedProvider); ok {
+			comp_pder.SetCompression(compression)
+		}
+	}
+}
+
+// SetPayloadVersion passes payload version to providers implementing VersionedProvider.
+func (fpder *FallbackProvider) SetPayloadVersion(payloadVersion *PayloadVersion) {
+	for _, p := range []Provider{fpder.primary, fpder.secondary} {
+		if ver_pder, ok := p.(VersionedProvider); ok {
+			ver_pder.SetPayloadVersion(payloadVersion)
+		}
+	}
+}
+
+// SetLogger sets logger for resync and providers implementing LoggedProvider.
+func (fpder *FallbackProvider) SetLogger(logger *slog.Logger) {
+	fpder.mx.Lock()
+	fpder.logger = logger
+	fpder.mx.Unlock()
+	for _, p := range []Provider{fpder.primary, fpder.secondary} {
+		if log_pder, ok := p.(LoggedProvider); ok {
+			log_pder.SetLogger(logger)
+		}
+	}
+}
+
+// EnsureSchema creates schema of providers implementing SchemaProvider.
+func (fpder *FallbackProvider) EnsureSchema(ctx context.Context) error {
+	return ensureSchema(ctx, fpder.primary, fpder.secondary)
+}
+
+func (fpder *FallbackProvider) resyncLoop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(fpder.resyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if fpder.PrimaryDown() {
+				if err := fpder.Resync(); err != nil {
+					fpder.log().Debug("resync failed", LOG_KEY_OPERATION, "resync", LOG_KEY_ERROR, err)
+				}
+			}
+		}
+	}
+}
+
+// Resync copies sessions used during the outage from the secondary provider
+// to the primary one and switches back to the primary provider on success.
+// It is called by the background resync, but can be called directly as well.
+func (fpder *FallbackProvider) Resync() error {
+	fpder.mx.Lock()
+	dirty := make([]string, 0, len(fpder.dirty))
+	for sid := range fpder.dirty {
+		dirty = append(dirty, sid)
+	}
+	destroyed := make([]string, 0, len(fpder.destroyed))
+	for sid := range fpder.destroyed {
+		destroyed = append(destroyed, sid)
+	}
+	fpder.mx.Unlock()
+
+	for _, sid := range destroyed {
+		if err := fpder.primary.SessionDestroy(sid); err != nil {
+			return err
+		}
+		fpder.mx.Lock()
+		delete(fpder.destroyed, sid)
+		fpder.mx.Unlock()
+	}
+	for _, sid := range dirty {
+		if err := fpder.resyncSession(sid); err != nil {
+			return fmt.Errorf("session %s: %w", sid, err)
+		}
+	}
+
+	fpder.mx.Lock()
+	defer fpder.mx.Unlock()
+	if len(fpder.dirty) > 0 || len(fpder.destroyed) > 0 {
+		return errors.New("session: sessions used during resync")
+	}
+	fpder.primaryDown = false
+	fpder.log().Warn("primary provider is reachable, sessions resynchronized", LOG_KEY_OPERATION, "resync")
+	return nil
+}
+
+// resyncSession copies session values from secondary to primary provider.
+func (fpder *FallbackProvider) resyncSession(sid string) error {
+	sec_sess, err := fpder.secondary.SessionRead(sid)
+	if err != nil {
+		return err
+	}
+	prim_sess, err := fpder.primary.SessionRead(sid)
+	if errors.Is(err, ErrSessionNotFound) {
+		//created while primary was down, strict mode
+		prim_sess, err = fpder.primary.SessionInit(sid)
+	}
+	if err != nil {
+		return err
+	}
+	if err := copyValues(sec_sess, prim_sess); err != nil {
+		return err
+	}
+	fpder.mx.Lock()
+	delete(fpder.dirty, sid)
+	fpder.mx.Unlock()
+	return nil
+}
+
+// copyValues replaces all values of dest session with values of src session and flushes dest.
+func copyValues(src, dest Session) error {
+	keys, err := src.Keys()
+	if err != nil {
+		return err
+	}
+	if err := dest.Clear(); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		var val interface{}
+		if err := src.Get(key, &val); err != nil {
+			return err
+		}
+		if err := dest.Set(key, val); err != nil {
+			return err
+		}
+	}
+	return dest.Flush()
+}
+
+// ValidateSessionID checks session ID format with the primary provider.
+func (fpder *FallbackProvider) ValidateSessionID(sid string) error {
+	return ValidateSessionID(fpder.primary, sid)
+}
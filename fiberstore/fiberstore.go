@@ -0,0 +1,103 @@
+// Package fiberstore implements fiber.Storage on top of session.Manager
+// for fiber session middleware:
+//
+//	store := session.New(session.Config{Storage: fiberstore.New(SessManager)})
+//	app.Get("/", func(c *fiber.Ctx) error {
+//		sess, err := store.Get(c)
+//		if err != nil {
+//			return err
+//		}
+//		sess.Set("user", "user1")
+//		return sess.Save()
+//	})
+//
+// Fiber encodes all values of a session into one value, it is kept under VALUE_KEY
+// of the session with the fiber session ID. The storage is for sessions only,
+// other middleware, e.g. limiter, should use their own storage.
+package fiberstore
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dronm/session"
+)
+
+// VALUE_KEY is a key of encoded fiber session values.
+const VALUE_KEY = "fiber_session"
+
+// Storage is a fiber.Storage keeping fiber sessions with session.Manager.
+type Storage struct {
+	manager *session.Manager
+}
+
+// New returns storage of manager sessions.
+func New(manager *session.Manager) *Storage {
+	return &Storage{manager: manager}
+}
+
+// Get returns encoded session values, nil if there is no session.
+func (s *Storage) Get(key string) ([]byte, error) {
+	if key == "" {
+		return nil, nil
+	}
+	//session is not created by reading if provider supports metadata
+	if _, err := s.manager.SessionMeta(key); errors.Is(err, session.ErrSessionNotFound) {
+		return nil, nil
+	}
+	sess, err := s.manager.SessionStart(key)
+	if errors.Is(err, session.ErrSessionExpired) || errors.Is(err, session.ErrSessionNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer s.manager.SessionClose(key)
+	val := sess.GetBytes(VALUE_KEY)
+	if len(val) == 0 {
+		return nil, nil
+	}
+	return val, nil
+}
+
+// Set writes encoded session values, session expires in exp if it is not 0.
+func (s *Storage) Set(key string, val []byte, exp time.Duration) error {
+	if key == "" || len(val) == 0 {
+		return nil
+	}
+	sess, err := s.manager.SessionStart(key)
+	if err != nil {
+		return err
+	}
+	err = sess.Set(VALUE_KEY, val)
+	if err == nil && exp > 0 {
+		err = sess.SetExpiry(exp)
+	}
+	if err == nil {
+		err = sess.Flush()
+	}
+	return errors.Join(err, s.manager.SessionClose(key))
+}
+
+// Delete destroys session.
+func (s *Storage) Delete(key string) error {
+	if key == "" {
+		return nil
+	}
+	err := s.manager.SessionDestroy(key)
+	if errors.Is(err, session.ErrSessionNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Reset destroys all sessions of the manager.
+func (s *Storage) Reset() error {
+	_, err := s.manager.DestroyAll()
+	return err
+}
+
+// Close does nothing, manager is closed by the application.
+func (s *Storage) Close() error {
+	return nil
+}
@@ -0,0 +1,37 @@
+// testing functions for session/fiberstore.
+package fiberstore
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dronm/session"
+	"github.com/dronm/session/mock"
+)
+
+// TestStorage sets, gets and deletes encoded values.
+func TestStorage(t *testing.T) {
+	SessManager, err := session.NewManagerWithProvider(mock.NewProvider(), 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	storage := New(SessManager)
+	const sid = "7f8a1c52-3b1e-4a7e-9c1d-2f6b5e4d3c2a"
+
+	if val, err := storage.Get(sid); err != nil || val != nil {
+		t.Fatalf("Get() of unknown session = %v, %v, wanted nil", val, err)
+	}
+	if err := storage.Set(sid, []byte("encoded"), time.Hour); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if val, err := storage.Get(sid); err != nil || !bytes.Equal(val, []byte("encoded")) {
+		t.Fatalf("Get() = %q, %v, wanted encoded", val, err)
+	}
+	if err := storage.Delete(sid); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if val, err := storage.Get(sid); err != nil || val != nil {
+		t.Fatalf("Get() of deleted session = %v, %v, wanted nil", val, err)
+	}
+}
@@ -0,0 +1,118 @@
+package session
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+// FINGERPRINT_KEY is a session value holding client fingerprint, see SessionStartHTTP().
+// Clear() and Restore() of sessions started by a manager with fingerprint set keep it,
+// so a cleared session is not bound to the next client.
+const FINGERPRINT_KEY = "session_fingerprint"
+
+// FingerprintMode defines what is done if a session is used by a client with another fingerprint.
+type FingerprintMode int
+
+const (
+	FINGERPRINT_OFF    FingerprintMode = iota //fingerprint is not checked
+	FINGERPRINT_LOG                           //mismatch is logged, session is returned
+	FINGERPRINT_REJECT                        //ErrFingerprintMismatch is returned
+)
+
+// FingerprintFunc returns client fingerprint of a request.
+type FingerprintFunc func(r *http.Request) string
+
+// DefaultFingerprint returns SHA-256 hash of client IP address and User-Agent header.
+// Client IP is taken from RemoteAddr, behind a proxy use a function
+// reading the address from a trusted header.
+func DefaultFingerprint(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	sum := sha256.Sum256([]byte(ip + "\x00" + r.UserAgent()))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetFingerprint binds sessions started with SessionStartHTTP() to client fingerprint
+// returned by fn, DefaultFingerprint() is used if fn is nil.
+// The fingerprint is stored in session on creation, a session used by a client
+// with another fingerprint is rejected or logged depending on mode,
+// it mitigates use of stolen session cookies.
+// Clients changing IP address, e.g. mobile ones, get a mismatch with DefaultFingerprint().
+func (manager *Manager) SetFingerprint(mode FingerprintMode, fn FingerprintFunc) {
+	if fn == nil {
+		fn = DefaultFingerprint
+	}
+	manager.fpMode = mode
+	manager.fpFunc = fn
+}
+
+// SessionStartHTTP starts session with the given ID as SessionStart() does
+// and checks that it is used by the client it was created for, see SetFingerprint().
+// Fingerprint is stored in a session created without it.
+// In FINGERPRINT_REJECT mode ErrFingerprintMismatch is returned for other clients,
+// the session is not destroyed, so its owner keeps it.
+func (manager *Manager) SessionStartHTTP(r *http.Request, sid string) (Session, error) {
+	sess, err := manager.SessionStart(sid)
+	if err != nil || manager.fpMode == FINGERPRINT_OFF {
+		return sess, err
+	}
+	fp := manager.fpFunc(r)
+	stored := sess.GetString(FINGERPRINT_KEY)
+	if stored == "" {
+		if err := sess.Put(FINGERPRINT_KEY, fp); err != nil {
+			manager.SessionClose(sess.SessionID())
+			return nil, err
+		}
+		return sess, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(fp)) == 1 {
+		return sess, nil
+	}
+	log := manager.logger
+	if log == nil {
+		log = slog.Default()
+	}
+	log.Warn("session fingerprint mismatch", LOG_KEY_SID_HASH, HashSessionID(sess.SessionID()), "remote_addr", r.RemoteAddr)
+	if manager.fpMode == FINGERPRINT_REJECT {
+		manager.SessionClose(sess.SessionID())
+		return nil, ErrFingerprintMismatch
+	}
+	return sess, nil
+}
+
+// fingerprintSession keeps FINGERPRINT_KEY value through Clear() and Restore(),
+// sessions are wrapped by the manager if fingerprint is checked.
+type fingerprintSession struct {
+	Session
+}
+
+func (s *fingerprintSession) Clear() error {
+	fp := s.Session.GetString(FINGERPRINT_KEY)
+	if err := s.Session.Clear(); err != nil {
+		return err
+	}
+	if fp == "" {
+		return nil
+	}
+	return s.Session.Set(FINGERPRINT_KEY, fp)
+}
+
+// Restore replaces values with snapshot keeping the bound fingerprint instead of the one of snapshot.
+func (s *fingerprintSession) Restore(snapshot map[string]interface{}) error {
+	fp := s.Session.GetString(FINGERPRINT_KEY)
+	if fp != "" {
+		values := make(map[string]interface{}, len(snapshot)+1)
+		for key, value := range snapshot {
+			values[key] = value
+		}
+		values[FINGERPRINT_KEY] = fp
+		snapshot = values
+	}
+	return s.Session.Restore(snapshot)
+}
@@ -0,0 +1,97 @@
+package session_test
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dronm/session"
+	"github.com/dronm/session/mock"
+)
+
+// TestFingerprintClear checks that a session cleared or restored by its owner
+// is not bound to the next client.
+func TestFingerprintClear(t *testing.T) {
+	SessManager, err := session.NewManagerWithProvider(mock.NewProvider(), 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	SessManager.SetFingerprint(session.FINGERPRINT_REJECT, nil)
+
+	owner := httptest.NewRequest(http.MethodGet, "/", nil)
+	owner.Header.Set("User-Agent", "owner")
+	thief := httptest.NewRequest(http.MethodGet, "/", nil)
+	thief.Header.Set("User-Agent", "thief")
+
+	currentSession, err := SessManager.SessionStartHTTP(owner, "")
+	if err != nil {
+		t.Fatalf("SessionStartHTTP() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Set("user", "john"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	snapshot, err := currentSession.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() failed: %v", err)
+	}
+	if err := currentSession.Clear(); err != nil {
+		t.Fatalf("Clear() failed: %v", err)
+	}
+	if err := currentSession.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if _, err := SessManager.SessionStartHTTP(thief, sid); !errors.Is(err, session.ErrFingerprintMismatch) {
+		t.Fatalf("SessionStartHTTP() of other client after Clear() wanted ErrFingerprintMismatch, got %v", err)
+	}
+
+	delete(snapshot, session.FINGERPRINT_KEY)
+	if err := currentSession.Restore(snapshot); err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+	if err := currentSession.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if _, err := SessManager.SessionStartHTTP(thief, sid); !errors.Is(err, session.ErrFingerprintMismatch) {
+		t.Fatalf("SessionStartHTTP() of other client after Restore() wanted ErrFingerprintMismatch, got %v", err)
+	}
+	if _, err := SessManager.SessionStartHTTP(owner, sid); err != nil {
+		t.Fatalf("SessionStartHTTP() of owner failed: %v", err)
+	}
+}
+
+// TestFingerprintLog checks that a mismatch is logged with hash of session ID.
+func TestFingerprintLog(t *testing.T) {
+	SessManager, err := session.NewManagerWithProvider(mock.NewProvider(), 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	var buf bytes.Buffer
+	SessManager.SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	SessManager.SetFingerprint(session.FINGERPRINT_LOG, nil)
+
+	owner := httptest.NewRequest(http.MethodGet, "/", nil)
+	owner.Header.Set("User-Agent", "owner")
+	thief := httptest.NewRequest(http.MethodGet, "/", nil)
+	thief.Header.Set("User-Agent", "thief")
+
+	currentSession, err := SessManager.SessionStartHTTP(owner, "")
+	if err != nil {
+		t.Fatalf("SessionStartHTTP() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if _, err := SessManager.SessionStartHTTP(thief, sid); err != nil {
+		t.Fatalf("SessionStartHTTP() in log mode failed: %v", err)
+	}
+	log := buf.String()
+	if strings.Contains(log, sid) {
+		t.Fatalf("session ID is logged: %s", log)
+	}
+	if !strings.Contains(log, session.LOG_KEY_SID_HASH+"="+session.HashSessionID(sid)) {
+		t.Fatalf("hash of session ID is not logged: %s", log)
+	}
+}
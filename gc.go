@@ -0,0 +1,171 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// GCReport holds results of one SessionGC() run.
+type GCReport struct {
+	Scanned         int           //sessions checked, 0 if provider removes sessions in storage without reading them
+	DeletedIdle     int           //sessions idling more than max idle time
+	DeletedLifetime int           //sessions living more than max life time
+	DeletedExpiry   int           //sessions with expiration time set by Session.SetExpiry() elapsed
+	Errors          int           //failed storage operations, see GC log for details
+	Duration        time.Duration //GC run time
+}
+
+// Deleted returns number of all removed sessions.
+func (r GCReport) Deleted() int {
+	return r.DeletedIdle + r.DeletedLifetime + r.DeletedExpiry
+}
+
+// Add adds counters of other report, e.g. of a shard. Duration is not changed.
+func (r *GCReport) Add(other GCReport) {
+	r.Scanned += other.Scanned
+	r.DeletedIdle += other.DeletedIdle
+	r.DeletedLifetime += other.DeletedLifetime
+	r.DeletedExpiry += other.DeletedExpiry
+	r.Errors += other.Errors
+}
+
+// GCHook is called with results of every GC run started by StartGC() or RunGCOnce().
+type GCHook func(GCReport)
+
+// OnGC registers a callback called after every SessionGC() run of StartGC() and RunGCOnce(),
+// e.g. to alert when GC stops removing sessions or fails.
+// Hooks should be registered before GC is started.
+func (manager *Manager) OnGC(fn GCHook) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.hooks.gc = append(manager.hooks.gc, fn)
+}
+
+func (manager *Manager) gcDone(report GCReport) {
+	for _, fn := range manager.hooks.gc {
+		fn(report)
+	}
+}
+
+// GCLimiter is an optional interface for providers able to limit
+// the number of sessions removed by one SessionGC() run.
+type GCLimiter interface {
+	SetGCMaxDeletions(int) //0 means no limit
+}
+
+// SetGCMaxDeletions limits the number of sessions removed by one SessionGC() run,
+// so a huge wave of expired sessions is removed in several runs without stalling storage.
+// Decorators pass the limit to every inner provider. 0 means no limit.
+// Provider must implement GCLimiter interface.
+func (manager *Manager) SetGCMaxDeletions(maxDeletions int) error {
+	limiter, ok := manager.provider.(GCLimiter)
+	if !ok {
+		return errors.New("session: provider does not support GC limit")
+	}
+	limiter.SetGCMaxDeletions(maxDeletions)
+	return nil
+}
+
+// setGCMaxDeletions passes GC limit to providers implementing GCLimiter.
+func setGCMaxDeletions(maxDeletions int, providers ...Provider) {
+	for _, p := range providers {
+		if limiter, ok := p.(GCLimiter); ok {
+			limiter.SetGCMaxDeletions(maxDeletions)
+		}
+	}
+}
+
+// SetGCJitter adds a random delay from 0 to jitter to every GC interval,
+// so application instances sharing one storage and started at once
+// do not run GC at the same moment. Restart GC with RestartGC() to apply.
+func (manager *Manager) SetGCJitter(jitter time.Duration) {
+	manager.gcJitter = jitter
+}
+
+// gcDelay returns GC interval with a random jitter.
+func (manager *Manager) gcDelay(interval time.Duration) time.Duration {
+	if manager.gcJitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Int63n(int64(manager.gcJitter)))
+}
+
+// ProviderV2 is an optional interface for providers returning errors of GC and of destruction
+// of all sessions, which Provider.SessionGC() and Provider.DestroyAllSessions() only log.
+// Manager adapts other providers, see Manager.CollectGarbage() and Manager.DestroyAll().
+type ProviderV2 interface {
+	CollectGarbage() (int, error) //removes expired sessions, returns number of removed sessions and joined errors
+	DestroyAll() (int, error)     //destroys all sessions, returns number of destroyed sessions
+}
+
+// CollectGarbage removes expired sessions and sessions trashed before the restore window as SessionGC() does,
+// returns number of removed sessions and an error if any storage operation failed, so callers can react
+// to GC failures. For providers not implementing ProviderV2 ErrGCFailed is returned
+// with the number of failed operations of GCReport.
+func (manager *Manager) CollectGarbage() (int, error) {
+	start := time.Now()
+	var deleted int
+	var err error
+	if v2_pder, ok := manager.provider.(ProviderV2); ok {
+		deleted, err = v2_pder.CollectGarbage()
+	} else {
+		report := manager.provider.SessionGC(io.Discard, LOG_LEVEL_ERROR)
+		deleted = report.Deleted()
+		if report.Errors > 0 {
+			err = fmt.Errorf("%w: %d failed storage operations", ErrGCFailed, report.Errors)
+		}
+	}
+	err = errors.Join(err, manager.purgeTrash(io.Discard, LOG_LEVEL_ERROR))
+	manager.metrics.observe(METRIC_GC_RUNS, METRIC_GC_DURATION, start, err)
+	return deleted, err
+}
+
+// RunGCOnce runs one synchronous GC pass as StartGC() does on every interval and returns its report,
+// so tests and cron jobs do not depend on the background scheduler. OnGC() callbacks are called
+// with the report. The GC leader lock is not used, kill time cleanup is not run.
+// Context is checked before the pass, provider GC is not interrupted.
+// ErrGCFailed is returned with the report if storage operations failed.
+func (manager *Manager) RunGCOnce(ctx context.Context) (GCReport, error) {
+	if err := ctx.Err(); err != nil {
+		return GCReport{}, err
+	}
+	report := manager.SessionGC(io.Discard, LOG_LEVEL_ERROR)
+	manager.gcDone(report)
+	if report.Errors > 0 {
+		return report, fmt.Errorf("%w: %d failed storage operations", ErrGCFailed, report.Errors)
+	}
+	return report, nil
+}
+
+// DestroyAll destroys all sessions as DestroyAllSessions() does, returns number of destroyed sessions
+// and an error if destruction failed. For providers not implementing ProviderV2 sessions are counted
+// before and after destruction if provider implements AdminProvider, an error is returned
+// if sessions remain. 0 and nil are returned for other providers.
+func (manager *Manager) DestroyAll() (int, error) {
+	if err := manager.trashAllSessions(); err != nil {
+		return 0, err
+	}
+	if v2_pder, ok := manager.provider.(ProviderV2); ok {
+		return v2_pder.DestroyAll()
+	}
+	before, err := manager.Count()
+	if err != nil && !errors.Is(err, ErrNotAdminProvider) {
+		return 0, err
+	}
+	manager.provider.DestroyAllSessions(io.Discard, LOG_LEVEL_ERROR)
+	if errors.Is(err, ErrNotAdminProvider) {
+		return 0, nil
+	}
+	after, err := manager.Count()
+	if err != nil {
+		return 0, err
+	}
+	if after > 0 {
+		return 0, fmt.Errorf("session: %d sessions not destroyed, see provider log", after)
+	}
+	return before, nil
+}
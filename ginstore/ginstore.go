@@ -0,0 +1,33 @@
+// Package ginstore implements gin-contrib/sessions Store on top of session.Manager:
+//
+//	r := gin.Default()
+//	r.Use(sessions.Sessions("sid", ginstore.NewStore(SessManager)))
+//	r.GET("/", func(c *gin.Context) {
+//		sess := sessions.Default(c)
+//		sess.Set("user", "user1")
+//		sess.Save()
+//	})
+//
+// Sessions are kept as gorillastore.Store keeps them.
+package ginstore
+
+import (
+	"github.com/dronm/session"
+	"github.com/dronm/session/gorillastore"
+	"github.com/gin-contrib/sessions"
+)
+
+// Store is a gin-contrib sessions.Store keeping sessions with session.Manager.
+type Store struct {
+	*gorillastore.Store
+}
+
+// NewStore returns store of manager sessions with default cookie options of gorillastore.NewStore().
+func NewStore(manager *session.Manager) *Store {
+	return &Store{Store: gorillastore.NewStore(manager)}
+}
+
+// Options sets cookie options of new sessions.
+func (s *Store) Options(options sessions.Options) {
+	s.Store.Options = options.ToGorillaOptions()
+}
@@ -0,0 +1,99 @@
+// testing functions for session/ginstore.
+package ginstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dronm/session"
+	"github.com/dronm/session/mock"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const COOKIE_NAME = "sid"
+
+func newRouter(t *testing.T) (*session.Manager, *Store, *gin.Engine) {
+	SessManager, err := session.NewManagerWithProvider(mock.NewProvider(), 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	store := NewStore(SessManager)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(sessions.Sessions(COOKIE_NAME, store))
+	r.GET("/set", func(c *gin.Context) {
+		sess := sessions.Default(c)
+		sess.Set("user", "user1")
+		if err := sess.Save(); err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	r.GET("/get", func(c *gin.Context) {
+		user, _ := sessions.Default(c).Get("user").(string)
+		c.String(http.StatusOK, user)
+	})
+	return SessManager, store, r
+}
+
+// request serves path with cookies, returns response.
+func request(r *gin.Engine, path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// sessionCookie returns session cookie of response.
+func sessionCookie(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == COOKIE_NAME {
+			return c
+		}
+	}
+	t.Fatal("session cookie is not set")
+	return nil
+}
+
+// TestStore saves values in one request and reads them in the next one.
+func TestStore(t *testing.T) {
+	SessManager, _, r := newRouter(t)
+	w := request(r, "/set", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("/set status %d: %s", w.Code, w.Body.String())
+	}
+	cookie := sessionCookie(t, w)
+	if cookie.Value == "" {
+		t.Fatalf("cookie %v wanted session ID", cookie)
+	}
+
+	sess, err := SessManager.SessionStart(cookie.Value)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if got := sess.GetString("user"); got != "user1" {
+		t.Fatalf("manager session value = %q, wanted user1", got)
+	}
+	SessManager.SessionClose(cookie.Value)
+
+	w = request(r, "/get", []*http.Cookie{cookie})
+	if got := w.Body.String(); got != "user1" {
+		t.Fatalf("store session value = %q, wanted user1", got)
+	}
+}
+
+// TestOptions sets cookie options of new sessions.
+func TestOptions(t *testing.T) {
+	_, store, r := newRouter(t)
+	store.Options(sessions.Options{Path: "/app", MaxAge: 600, HttpOnly: true})
+	cookie := sessionCookie(t, request(r, "/set", nil))
+	if cookie.Path != "/app" || cookie.MaxAge != 600 || !cookie.HttpOnly {
+		t.Fatalf("cookie %v wanted Path /app, MaxAge 600 and HttpOnly", cookie)
+	}
+}
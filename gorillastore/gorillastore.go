@@ -0,0 +1,121 @@
+// Package gorillastore implements gorilla/sessions Store on top of session.Manager,
+// so handlers written for gorilla sessions, e.g. with echo-contrib/session, keep sessions
+// in any provider of the manager.
+//
+//	store := gorillastore.NewStore(SessManager)
+//	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+//		sess, _ := store.Get(r, "sid")
+//		sess.Values["user"] = "user1"
+//		sess.Save(r, w)
+//	})
+//
+// Cookie holds session ID only, values are kept by the provider under their string keys,
+// so they are accessible with Manager.SessionStart() as well. Values with keys of other types
+// can not be saved.
+package gorillastore
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dronm/session"
+	"github.com/gorilla/sessions"
+)
+
+// Store is a gorilla sessions.Store keeping sessions with session.Manager.
+type Store struct {
+	manager *session.Manager
+	Options *sessions.Options //default cookie options of new sessions
+}
+
+// NewStore returns store of manager sessions, session cookie is sent to all paths,
+// it is not available to scripts and lives till the browser is closed.
+func NewStore(manager *session.Manager) *Store {
+	return &Store{
+		manager: manager,
+		Options: &sessions.Options{Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode},
+	}
+}
+
+// Get returns session of the request, it is cached in the request registry,
+// so all handlers of a request use the same session.
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns session of the request cookie with its values,
+// new session without ID is returned if there is no cookie or the session can not be read.
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	gs := sessions.NewSession(s, name)
+	opts := *s.Options
+	gs.Options = &opts
+	gs.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil || cookie.Value == "" {
+		return gs, nil
+	}
+	values, err := s.load(cookie.Value)
+	if err != nil {
+		return gs, nil
+	}
+	for key, value := range values {
+		gs.Values[key] = value
+	}
+	gs.ID = cookie.Value
+	gs.IsNew = false
+	return gs, nil
+}
+
+// load returns session values.
+func (s *Store) load(sid string) (map[string]interface{}, error) {
+	sess, err := s.manager.SessionStart(sid)
+	if err != nil {
+		return nil, err
+	}
+	defer s.manager.SessionClose(sid)
+	return sess.Snapshot()
+}
+
+// Save writes session values and sets session cookie.
+// Session with negative Options.MaxAge is destroyed and its cookie is deleted.
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, gs *sessions.Session) error {
+	if gs.Options.MaxAge < 0 {
+		if gs.ID != "" {
+			if err := s.manager.SessionDestroy(gs.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(gs.Name(), "", gs.Options))
+		return nil
+	}
+
+	values := make(map[string]interface{}, len(gs.Values))
+	for key, value := range gs.Values {
+		str_key, ok := key.(string)
+		if !ok {
+			return fmt.Errorf("gorillastore: session value key %v must be a string", key)
+		}
+		values[str_key] = value
+	}
+	sess, err := s.manager.SessionStart(gs.ID)
+	if gs.ID != "" && (errors.Is(err, session.ErrSessionExpired) || errors.Is(err, session.ErrSessionNotFound)) {
+		//session is removed since it was read, values are saved to a new one
+		sess, err = s.manager.SessionStart("")
+	}
+	if err != nil {
+		return err
+	}
+	sid := sess.SessionID()
+	err = sess.Restore(values)
+	if err == nil {
+		err = sess.Flush()
+	}
+	if err = errors.Join(err, s.manager.SessionClose(sid)); err != nil {
+		return err
+	}
+	gs.ID = sid
+	http.SetCookie(w, sessions.NewCookie(gs.Name(), sid, gs.Options))
+	return nil
+}
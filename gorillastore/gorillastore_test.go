@@ -0,0 +1,101 @@
+// testing functions for session/gorillastore.
+package gorillastore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dronm/session"
+	"github.com/dronm/session/mock"
+)
+
+const COOKIE_NAME = "sid"
+
+func newStore(t *testing.T) (*session.Manager, *Store) {
+	SessManager, err := session.NewManagerWithProvider(mock.NewProvider(), 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	return SessManager, NewStore(SessManager)
+}
+
+// request runs handler with the store session of the request with cookies, returns response cookie.
+func request(t *testing.T, store *Store, cookies []*http.Cookie, handler func(values map[interface{}]interface{})) *http.Cookie {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	gs, err := store.Get(r, COOKIE_NAME)
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	handler(gs.Values)
+	if err := gs.Save(r, w); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == COOKIE_NAME {
+			return c
+		}
+	}
+	t.Fatal("session cookie is not set")
+	return nil
+}
+
+// TestStore saves values in one request and reads them in the next one.
+func TestStore(t *testing.T) {
+	SessManager, store := newStore(t)
+	cookie := request(t, store, nil, func(values map[interface{}]interface{}) {
+		values["user"] = "user1"
+	})
+	if cookie.Value == "" || !cookie.HttpOnly {
+		t.Fatalf("cookie %v wanted session ID and HttpOnly", cookie)
+	}
+
+	sess, err := SessManager.SessionStart(cookie.Value)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if got := sess.GetString("user"); got != "user1" {
+		t.Fatalf("manager session value = %q, wanted user1", got)
+	}
+	SessManager.SessionClose(cookie.Value)
+
+	request(t, store, []*http.Cookie{cookie}, func(values map[interface{}]interface{}) {
+		if got := values["user"]; got != "user1" {
+			t.Fatalf("store session value = %v, wanted user1", got)
+		}
+	})
+}
+
+// TestDelete destroys session with negative MaxAge.
+func TestDelete(t *testing.T) {
+	SessManager, store := newStore(t)
+	cookie := request(t, store, nil, func(values map[interface{}]interface{}) {
+		values["user"] = "user1"
+	})
+	var destroyed string
+	SessManager.OnSessionDestroyed(func(sid string) { destroyed = sid })
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+	gs, err := store.Get(r, COOKIE_NAME)
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	gs.Options.MaxAge = -1
+	if err := gs.Save(r, httptest.NewRecorder()); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	if destroyed != cookie.Value {
+		t.Fatalf("destroyed session %q, wanted %q", destroyed, cookie.Value)
+	}
+
+	gs.Options.MaxAge = 0
+	gs.Values[1] = "not a string key"
+	if err := gs.Save(r, httptest.NewRecorder()); err == nil {
+		t.Fatal("Save() wanted error for not a string key")
+	}
+}
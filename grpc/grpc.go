@@ -0,0 +1,697 @@
+// Package grpc contains a session service exposing session manager over gRPC
+// and a remote session provider using it, so application instances can share
+// a central session service without connecting to the session storage themselves.
+// Requirements:
+//
+//	grpc https://github.com/grpc/grpc-go
+//
+// Server side:
+//
+//	SessManager, err := session.NewManager("redis", 0, 3600, "", REDIS_ADDR, REDIS_NAMESPACE)
+//	SessManager.StartGC(os.Stderr, session.LOG_LEVEL_ERROR)
+//	s := grpc.NewServer()
+//	sessgrpc.Register(s, sessgrpc.NewServer(SessManager))
+//	s.Serve(lis)
+//
+// Client side:
+//
+//	SessManager, err := session.NewManager("grpc", 0, 0, "", "sessions:50051")
+//
+// Service messages are encoded with gob, no protobuf code is generated.
+// Session values are gob encoded by clients, custom types must be registered with session.RegisterType().
+// Max life time, max idle time and GC are handled by the server manager.
+// Session is read at start and kept in memory SessionStore structure,
+// modifications are sent to the server on Flush().
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dronm/session"
+	rpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Deprecated: use session.ErrKeyNotFound.
+var EKeyNotFound = session.ErrKeyNotFound
+
+// Deprecated: use session.ErrValMustBePtr.
+var EValMustBePtr = session.ErrValMustBePtr
+
+// Session key ID length.
+const SESS_ID_LEN = 36
+
+const PROVIDER = "grpc"
+
+const LOG_PREF = "grpc provider:"
+
+// CALL_TIMEOUT is a max duration of a service call.
+const CALL_TIMEOUT = 30 * time.Second
+
+// pder holds pointer to Provider struct registered as PROVIDER.
+var pder = NewProvider()
+
+// NewProvider returns a provider independent of the registered one, e.g. to use another session service
+// in another Manager created with session.NewManagerWithProvider().
+func NewProvider() *Provider {
+	return &Provider{clock: session.SystemClock{}}
+}
+
+// storeValue holds session key-value pares.
+type storeValue map[string]interface{}
+
+// SessionStore contains session information.
+type SessionStore struct {
+	sid          string    //session id
+	pder         *Provider //provider storing the session
+	mx           sync.RWMutex
+	timeAccessed time.Time  //last modified
+	timeCreated  time.Time  //when created
+	value        storeValue //key-value pair
+	modified     map[string]struct{}
+	deleted      map[string]struct{}
+	cleared      bool
+}
+
+// Set sets inmemory value. No server call is done.
+func (st *SessionStore) Set(key string, value interface{}) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if cur, ok := st.value[key]; !ok || !reflect.DeepEqual(cur, value) {
+		st.value[key] = value
+		st.modified[key] = struct{}{}
+		delete(st.deleted, key)
+		st.timeAccessed = st.pder.clock.Now()
+	}
+	return nil
+}
+
+func (st *SessionStore) Put(key string, value interface{}) error {
+	if err := st.Set(key, value); err != nil {
+		return err
+	}
+	return st.Flush()
+}
+
+// SetMany sets in-memory values under one lock, they are sent to the server by one Flush().
+func (st *SessionStore) SetMany(values map[string]interface{}) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	for key, value := range values {
+		if cur, ok := st.value[key]; !ok || !reflect.DeepEqual(cur, value) {
+			st.value[key] = value
+			st.modified[key] = struct{}{}
+			delete(st.deleted, key)
+			st.timeAccessed = st.pder.clock.Now()
+		}
+	}
+	return nil
+}
+
+// SetWithTTL sets in-memory value expiring in ttl, see session.ExpiringValue.
+func (st *SessionStore) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value[key] = session.NewExpiringValueAt(st.pder.clock.Now(), value, ttl)
+	st.modified[key] = struct{}{}
+	delete(st.deleted, key)
+	st.timeAccessed = st.pder.clock.Now()
+	return nil
+}
+
+// GetMany assigns in-memory values to dest pointers, see session.GetMany().
+func (st *SessionStore) GetMany(dest map[string]interface{}) error {
+	return session.GetMany(st, dest)
+}
+
+// Snapshot returns a deep copy of in-memory values without expired ones, see session.SnapshotValuesAt().
+func (st *SessionStore) Snapshot() (map[string]interface{}, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return session.SnapshotValuesAt(st.pder.clock.Now(), st.value)
+}
+
+// Restore replaces in-memory values with a copy of snapshot,
+// Flush() clears server values and sends all snapshot values.
+func (st *SessionStore) Restore(snapshot map[string]interface{}) error {
+	value, err := session.CopyValues(snapshot)
+	if err != nil {
+		return err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value = value
+	st.resetModified()
+	st.cleared = true
+	for key := range value {
+		st.modified[key] = struct{}{}
+	}
+	st.timeAccessed = st.pder.clock.Now()
+	return nil
+}
+
+// Flush sends modified values to the server.
+func (st *SessionStore) Flush() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if !st.cleared && len(st.modified) == 0 && len(st.deleted) == 0 {
+		return nil
+	}
+	req := &WriteRequest{SID: st.sid,
+		Clear:   st.cleared,
+		Deleted: make([]string, 0, len(st.deleted)),
+		Values:  make(map[string][]byte, len(st.modified)),
+	}
+	for key := range st.deleted {
+		req.Deleted = append(req.Deleted, key)
+	}
+	for key := range st.modified {
+		val, err := encodeValue(st.value[key])
+		if err != nil {
+			return err
+		}
+		req.Values[key] = val
+	}
+	if err := st.pder.invoke(METHOD_WRITE, req, &Empty{}); err != nil {
+		return err
+	}
+	st.resetModified()
+	return nil
+}
+
+func (st *SessionStore) resetModified() {
+	st.modified = make(map[string]struct{})
+	st.deleted = make(map[string]struct{})
+	st.cleared = false
+}
+
+// Get returns session value by its key. Value is retrieved from memory.
+func (st *SessionStore) Get(key string, val interface{}) error {
+	st.mx.RLock()
+	store_val, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if !ok {
+		return session.ErrKeyNotFound
+	}
+
+	// Get the type of val
+	val_type := reflect.TypeOf(val)
+
+	// Make sure val is a pointer
+	if val_type.Kind() != reflect.Ptr {
+		return session.ErrValMustBePtr
+	}
+
+	// Dereference the pointer and check if it's assignable
+	val_elem := val_type.Elem()
+	if !reflect.TypeOf(store_val).AssignableTo(val_elem) {
+		return session.ErrTypeMismatch
+	}
+
+	// Assign the value to val
+	reflect.ValueOf(val).Elem().Set(reflect.ValueOf(store_val))
+
+	return nil
+}
+
+// GetStruct retrieves session value by its key into dest pointer.
+// Value of a different type is converted, see session.AssignValue().
+func (st *SessionStore) GetStruct(key string, dest interface{}) error {
+	st.mx.RLock()
+	store_val, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if !ok {
+		return session.ErrKeyNotFound
+	}
+	return session.AssignValue(store_val, dest)
+}
+
+// GetBool returns bool value by key.
+func (st *SessionStore) GetBool(key string) bool {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	v, _ := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	if v_bool, ok := v.(bool); ok {
+		return v_bool
+	}
+	return false
+}
+
+// GetString returns string value by key.
+func (st *SessionStore) GetString(key string) string {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	v, _ := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	if v_str, ok := v.(string); ok {
+		return v_str
+
+	} else if v_str, ok := v.([]byte); ok {
+		return string(v_str)
+	}
+	return ""
+}
+
+// GetInt returns int value by key.
+func (st *SessionStore) GetInt(key string) int64 {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	v, _ := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	if v_i, ok := v.(int64); ok {
+		return v_i
+
+	} else if v_i, ok := v.(int); ok {
+		return int64(v_i)
+	}
+	return 0
+}
+
+// GetFloat returns float value by key.
+func (st *SessionStore) GetFloat(key string) float64 {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	v, _ := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	if v_f, ok := v.(float64); ok {
+		return v_f
+
+	} else if v_f, ok := v.(float32); ok {
+		return float64(v_f)
+	}
+	return 0
+}
+
+// GetDate returns time.Time value by key.
+func (st *SessionStore) GetDate(key string) time.Time {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	v, _ := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	if v_t, ok := v.(time.Time); ok {
+		return v_t
+	}
+	return time.Time{}
+}
+
+// GetBytes returns []byte value by key, string value is converted.
+func (st *SessionStore) GetBytes(key string) []byte {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	v, _ := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	if v_b, ok := v.([]byte); ok {
+		return v_b
+
+	} else if v_b, ok := v.(string); ok {
+		return []byte(v_b)
+	}
+	return nil
+}
+
+// GetStringSlice returns []string value by key.
+func (st *SessionStore) GetStringSlice(key string) []string {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	v, _ := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	if v_s, ok := v.([]string); ok {
+		return v_s
+	}
+	return nil
+}
+
+// GetOrSet assigns session value to dest, if there is no value
+// it is computed, stored and flushed under session lock, see session.GetOrSet().
+func (st *SessionStore) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	return session.GetOrSet(st, key, dest, compute)
+}
+
+// Delete deletes session value from memmory by key.
+func (st *SessionStore) Delete(key string) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if _, ok := st.value[key]; ok {
+		delete(st.value, key)
+		delete(st.modified, key)
+		st.deleted[key] = struct{}{}
+		st.timeAccessed = st.pder.clock.Now()
+	}
+	return nil
+}
+
+// Clear deletes all session values keeping creation time.
+func (st *SessionStore) Clear() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value = make(storeValue)
+	st.resetModified()
+	st.cleared = true
+	st.timeAccessed = st.pder.clock.Now()
+	return nil
+}
+
+// Increment adds delta to integer session value on the server and returns the new value.
+// Missing value is treated as 0.
+func (st *SessionStore) Increment(key string, delta int64) (int64, error) {
+	rep := &IncrementReply{}
+	if err := st.pder.invoke(METHOD_INCREMENT, &IncrementRequest{SID: st.sid, Key: key, Delta: delta}, rep); err != nil {
+		return 0, err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value[key] = rep.Value
+	delete(st.modified, key)
+	delete(st.deleted, key)
+	return rep.Value, nil
+}
+
+// Decrement subtracts delta from integer session value and returns the new value.
+func (st *SessionStore) Decrement(key string, delta int64) (int64, error) {
+	return st.Increment(key, -delta)
+}
+
+// CompareAndSwap sets newValue on the server if the stored value equals oldValue,
+// see session.Session.CompareAndSwap().
+func (st *SessionStore) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
+	req := &CompareAndSwapRequest{SID: st.sid, Key: key}
+	var err error
+	if oldValue != nil {
+		if req.Old, err = encodeValue(oldValue); err != nil {
+			return false, err
+		}
+	}
+	if newValue != nil {
+		if req.New, err = encodeValue(newValue); err != nil {
+			return false, err
+		}
+	}
+	rep := &CompareAndSwapReply{}
+	if err := st.pder.invoke(METHOD_COMPARE_AND_SWAP, req, rep); err != nil {
+		return false, err
+	}
+	if !rep.Swapped {
+		return false, nil
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if newValue == nil {
+		delete(st.value, key)
+	} else {
+		st.value[key] = newValue
+	}
+	delete(st.modified, key)
+	delete(st.deleted, key)
+	return true, nil
+}
+
+// Bucket returns a view of the session with keys prefixed by name, see session.NewBucket().
+func (st *SessionStore) Bucket(name string) session.Session {
+	return session.NewBucket(st, name)
+}
+
+// Keys returns sorted session value keys.
+func (st *SessionStore) Keys() ([]string, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	keys := make([]string, 0, len(st.value))
+	now := st.pder.clock.Now()
+	for key, v := range st.value {
+		if !session.ValueExpiredAt(now, v) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Len returns number of session values.
+func (st *SessionStore) Len() (int, error) {
+	keys, err := st.Keys()
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// SessionID returns session ID.
+func (st *SessionStore) SessionID() string {
+	return st.sid
+}
+
+// TimeCreated returns timeCreated property.
+func (st *SessionStore) TimeCreated() time.Time {
+	return st.timeCreated
+}
+
+// TimeAccessed returns timeAccessed property.
+func (st *SessionStore) TimeAccessed() time.Time {
+	return st.timeAccessed
+}
+
+// Lock acquires session lock on the server, values are reloaded.
+func (st *SessionStore) Lock() error {
+	rep := &SessionReply{}
+	if err := st.pder.invoke(METHOD_LOCK, &SessionRequest{SID: st.sid}, rep); err != nil {
+		return err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	return st.load(rep)
+}
+
+// Unlock releases session lock on the server.
+func (st *SessionStore) Unlock() error {
+	return st.pder.invoke(METHOD_UNLOCK, &SessionRequest{SID: st.sid}, &Empty{})
+}
+
+// SetExpiry sets session expiration on the server, see session.Session.SetExpiry().
+func (st *SessionStore) SetExpiry(d time.Duration) error {
+	return st.pder.invoke(METHOD_SET_EXPIRY, &ExpiryRequest{SID: st.sid, Expiry: d}, &Empty{})
+}
+
+// Touch updates session access time on the server.
+func (st *SessionStore) Touch() error {
+	if err := st.pder.invoke(METHOD_TOUCH, &SessionRequest{SID: st.sid}, &Empty{}); err != nil {
+		return err
+	}
+	st.mx.Lock()
+	st.timeAccessed = st.pder.clock.Now()
+	st.mx.Unlock()
+	return nil
+}
+
+// Allow counts action on the server, see session.Session.Allow().
+func (st *SessionStore) Allow(action string, limit int, window time.Duration) (bool, error) {
+	rep := &AllowReply{}
+	req := &AllowRequest{SID: st.sid, Action: action, Limit: limit, Window: window}
+	if err := st.pder.invoke(METHOD_ALLOW, req, rep); err != nil {
+		return false, err
+	}
+	return rep.Allowed, nil
+}
+
+// SetSensitive sets value encrypted with keys of session.SetSensitiveKeys(), see session.SetSensitive().
+func (st *SessionStore) SetSensitive(key string, value interface{}) error {
+	return session.SetSensitive(st, key, value)
+}
+
+// load replaces session data with server reply.
+func (st *SessionStore) load(rep *SessionReply) error {
+	value := make(storeValue, len(rep.Values))
+	now := st.pder.clock.Now()
+	for key, data := range rep.Values {
+		val, err := decodeValue(data)
+		if err != nil {
+			return err
+		}
+		if session.ValueExpiredAt(now, val) {
+			continue //set with SetWithTTL()
+		}
+		value[key] = val
+	}
+	st.value = value
+	st.timeCreated = rep.TimeCreated
+	st.timeAccessed = rep.TimeAccessed
+	st.resetModified()
+	return nil
+}
+
+// Provider structure holds provider information.
+type Provider struct {
+	conn        *rpc.ClientConn
+	maxLifeTime int64
+	maxIdleTime int64
+	clock       session.Clock //client side access times and value TTLs, see SetClock()
+}
+
+// NewSessionStore returns empty session store.
+func (pder *Provider) NewSessionStore(sid string) *SessionStore {
+	now := pder.clock.Now()
+	st := &SessionStore{sid: sid, pder: pder,
+		timeAccessed: now,
+		timeCreated:  now,
+		value:        make(storeValue),
+	}
+	st.resetModified()
+	return st
+}
+
+// invoke calls service method with CALL_TIMEOUT.
+func (pder *Provider) invoke(method string, req interface{}, rep interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), CALL_TIMEOUT)
+	defer cancel()
+	return pder.invokeContext(ctx, method, req, rep)
+}
+
+// invokeContext calls service method converting status errors to session errors.
+func (pder *Provider) invokeContext(ctx context.Context, method string, req interface{}, rep interface{}) error {
+	if pder.conn == nil {
+		return session.ErrProviderNotInitialized
+	}
+	err := pder.conn.Invoke(ctx, "/"+SERVICE_NAME+"/"+method, req, rep, rpc.CallContentSubtype(CODEC_NAME))
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	switch status.Code(err) {
+	case codes.DeadlineExceeded:
+		return session.ErrLockTimeout
+	case codes.Unauthenticated:
+		return session.ErrSessionExpired
+	case codes.NotFound:
+		return session.ErrSessionNotFound
+	}
+	return err
+}
+
+// SessionInit creates a new session with the given ID.
+func (pder *Provider) SessionInit(sid string) (session.Session, error) {
+	return pder.SessionRead(sid)
+}
+
+// SessionRead reads session from the server, a new one is created if there is no such session.
+// session.ErrSessionExpired is returned for expired session.
+func (pder *Provider) SessionRead(sid string) (session.Session, error) {
+	rep := &SessionReply{}
+	if err := pder.invoke(METHOD_READ, &SessionRequest{SID: sid}, rep); err != nil {
+		return nil, err
+	}
+	store := pder.NewSessionStore(sid)
+	if err := store.load(rep); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// SessionClose does nothing, server sessions are closed after every call.
+func (pder *Provider) SessionClose(sid string) error {
+	return nil
+}
+
+// SessionDestroy destroys session on the server.
+func (pder *Provider) SessionDestroy(sid string) error {
+	return pder.invoke(METHOD_DESTROY, &SessionRequest{SID: sid}, &Empty{})
+}
+
+// SessionGC runs GC on the server, returns the server report.
+func (pder *Provider) SessionGC(l io.Writer, logLev session.LogLevel) session.GCReport {
+	var report session.GCReport
+	if err := pder.invoke(METHOD_GC, &Empty{}, &report); err != nil {
+		session.WriteToLog(l, LOG_PREF+" SessionGC(): "+err.Error(), session.LOG_LEVEL_ERROR)
+		report.Errors++
+	}
+	return report
+}
+
+// DestroyAllSessions destroys all sessions on the server.
+func (pder *Provider) DestroyAllSessions(l io.Writer, logLev session.LogLevel) {
+	if err := pder.invoke(METHOD_DESTROY_ALL, &Empty{}, &Empty{}); err != nil {
+		session.WriteToLog(l, LOG_PREF+" DestroyAllSessions(): "+err.Error(), session.LOG_LEVEL_ERROR)
+	}
+}
+
+// SetMaxLifeTime is kept for Provider interface, max life time is set on the server.
+func (pder *Provider) SetMaxLifeTime(maxLifeTime int64) {
+	pder.maxLifeTime = maxLifeTime
+}
+
+func (pder *Provider) GetMaxLifeTime() int64 {
+	return pder.maxLifeTime
+}
+
+// SetMaxIdleTime is kept for Provider interface, max idle time is set on the server.
+func (pder *Provider) SetMaxIdleTime(maxIdleTime int64) {
+	pder.maxIdleTime = maxIdleTime
+}
+
+func (pder *Provider) GetMaxIdleTime() int64 {
+	return pder.maxIdleTime
+}
+
+// SetClock implements session.ClockSetter, client side access times and value TTLs
+// are of the clock. Nil restores session.SystemClock.
+func (pder *Provider) SetClock(clock session.Clock) {
+	if clock == nil {
+		clock = session.SystemClock{}
+	}
+	pder.clock = clock
+}
+
+// InitProvider connects to the session service.
+// Parameters:
+//
+//	0 - service address (string), e.g. "localhost:50051"
+//	1 - optional dial options ([]grpc.DialOption), insecure connection is used if not set
+func (pder *Provider) InitProvider(provParams []interface{}) error {
+	if len(provParams) < 1 {
+		return errors.New("InitProvider missing parameters: service address")
+	}
+	addr, ok := provParams[0].(string)
+	if !ok {
+		return errors.New("InitProvider service address parameter must be of type string")
+	}
+	opts := []rpc.DialOption{rpc.WithTransportCredentials(insecure.NewCredentials())}
+	if len(provParams) >= 2 {
+		if opts, ok = provParams[1].([]rpc.DialOption); !ok {
+			return errors.New("InitProvider dial options parameter must be of type []grpc.DialOption")
+		}
+	}
+	if pder.conn != nil {
+		pder.conn.Close()
+	}
+	conn, err := rpc.NewClient(addr, opts...)
+	if err != nil {
+		return err
+	}
+	pder.conn = conn
+	return nil
+}
+
+// CloseProvider closes service connection.
+func (pder *Provider) CloseProvider() error {
+	if pder.conn == nil {
+		return nil
+	}
+	err := pder.conn.Close()
+	pder.conn = nil
+	return err
+}
+
+// Ping checks health of the server session backend, see session.Manager.HealthCheck().
+func (pder *Provider) Ping(ctx context.Context) error {
+	if pder.conn == nil {
+		return session.ErrProviderNotInitialized
+	}
+	return pder.invokeContext(ctx, METHOD_PING, &Empty{}, &Empty{})
+}
+
+// GetSessionIDLen returns session ID length.
+func (pder *Provider) GetSessionIDLen() int {
+	return SESS_ID_LEN
+}
+
+func init() {
+	session.Register(PROVIDER, pder)
+}
@@ -0,0 +1,173 @@
+// testing functions for session/grpc.
+// Server side sessions are kept by bolt provider.
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/dronm/session" //session manager
+	_ "github.com/dronm/session/bolt"
+	"github.com/dronm/session/testkit"
+	rpc "google.golang.org/grpc"
+)
+
+const (
+	BOLT_FILENAME = "test.db"
+)
+
+// NewManager starts session service with bolt manager and returns client manager.
+func NewManager(t *testing.T) *session.Manager {
+	srv_manager, err := session.NewManager("bolt", 0, 0, "", BOLT_FILENAME)
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() failed: %v", err)
+	}
+	s := rpc.NewServer()
+	Register(s, NewServer(srv_manager))
+	go s.Serve(lis)
+
+	SessManager, err := session.NewManager(PROVIDER, 0, 0, "", lis.Addr().String())
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	t.Cleanup(func() {
+		SessManager.CloseProvider()
+		s.Stop()
+		srv_manager.CloseProvider()
+		os.Remove(BOLT_FILENAME)
+	})
+	return SessManager
+}
+
+// TestSession writes values, reads them with a new session and compares.
+func TestSession(t *testing.T) {
+	SessManager := NewManager(t)
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	testkit.CompareValues(t, currentSession, tests)
+
+	//deleted value
+	if err := currentSession.Delete("stringVal"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if err := currentSession.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if n, _ := currentSession.Len(); n != len(tests)-1 {
+		t.Fatalf("Len() wanted %d, got %d", len(tests)-1, n)
+	}
+
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if n, _ := currentSession.Len(); n != 0 {
+		t.Fatalf("Session: %s is not destroyed", sid)
+	}
+}
+
+// TestIncrement increments the same value concurrently from different sessions.
+func TestIncrement(t *testing.T) {
+	SessManager := NewManager(t)
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	const workers = 10
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sess, err := SessManager.SessionStart(sid)
+			if err != nil {
+				t.Errorf("SessionStart() failed: %v", err)
+				return
+			}
+			if _, err := sess.Increment("counter", 1); err != nil {
+				t.Errorf("Increment() failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if v := currentSession.GetInt("counter"); v != workers {
+		t.Fatalf("counter wanted %d, got %d", workers, v)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	SessManager := NewManager(t)
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	if ok, err := currentSession.CompareAndSwap("state", nil, "new"); err != nil || !ok {
+		t.Fatalf("CompareAndSwap() with nil old value wanted true, got %v (%v)", ok, err)
+	}
+	if ok, err := currentSession.CompareAndSwap("state", "paid", "shipped"); err != nil || ok {
+		t.Fatalf("CompareAndSwap() of other value wanted false, got %v (%v)", ok, err)
+	}
+	if ok, err := currentSession.CompareAndSwap("state", "new", "paid"); err != nil || !ok {
+		t.Fatalf("CompareAndSwap() wanted true, got %v (%v)", ok, err)
+	}
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if v := currentSession.GetString("state"); v != "paid" {
+		t.Fatalf("GetString() wanted paid, got %s", v)
+	}
+	if ok, err := currentSession.CompareAndSwap("state", "paid", nil); err != nil || !ok {
+		t.Fatalf("CompareAndSwap() with nil new value wanted true, got %v (%v)", ok, err)
+	}
+	if n, _ := currentSession.Len(); n != 0 {
+		t.Fatalf("value is not deleted")
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	SessManager := NewManager(t)
+	if err := SessManager.HealthCheck(context.Background()); err != nil {
+		t.Fatalf("HealthCheck() failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := SessManager.HealthCheck(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("HealthCheck() wanted context.Canceled, got %v", err)
+	}
+}
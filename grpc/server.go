@@ -0,0 +1,273 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/dronm/session"
+	rpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server serves sessions of a manager to remote providers.
+// Session values are kept by the manager provider as gob encoded []byte,
+// so the server does not need application types to be registered.
+type Server struct {
+	manager *session.Manager
+	mx      sync.Mutex
+	locked  map[string]session.Session //sessions locked by clients
+}
+
+// NewServer returns session service for the manager.
+// Manager GC should be started on server side, see session.Manager.StartGC().
+func NewServer(manager *session.Manager) *Server {
+	return &Server{manager: manager, locked: make(map[string]session.Session)}
+}
+
+// Register registers session service on gRPC server:
+//
+//	s := grpc.NewServer()
+//	sessgrpc.Register(s, sessgrpc.NewServer(SessManager))
+//	s.Serve(lis)
+func Register(s *rpc.Server, srv *Server) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+// statusError converts session errors to gRPC status errors.
+func statusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, session.ErrLockTimeout) {
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	if errors.Is(err, session.ErrSessionExpired) {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	if errors.Is(err, session.ErrSessionNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// withSession calls fn with started session closing it afterwards.
+func (srv *Server) withSession(sid string, fn func(sess session.Session) error) error {
+	if sid == "" {
+		return status.Error(codes.InvalidArgument, "session ID is empty")
+	}
+	sess, err := srv.manager.SessionStart(sid)
+	if err != nil {
+		return statusError(err)
+	}
+	defer srv.manager.SessionClose(sid)
+	return statusError(fn(sess))
+}
+
+// sessionReply returns session data.
+func sessionReply(sess session.Session) (*SessionReply, error) {
+	keys, err := sess.Keys()
+	if err != nil {
+		return nil, err
+	}
+	rep := &SessionReply{TimeCreated: sess.TimeCreated(),
+		TimeAccessed: sess.TimeAccessed(),
+		Values:       make(map[string][]byte, len(keys)),
+	}
+	for _, key := range keys {
+		var val []byte
+		if err := sess.Get(key, &val); err != nil {
+			return nil, err
+		}
+		rep.Values[key] = val
+	}
+	return rep, nil
+}
+
+func (srv *Server) read(_ context.Context, req *SessionRequest) (*SessionReply, error) {
+	var rep *SessionReply
+	err := srv.withSession(req.SID, func(sess session.Session) (err error) {
+		rep, err = sessionReply(sess)
+		return err
+	})
+	return rep, err
+}
+
+func (srv *Server) write(_ context.Context, req *WriteRequest) (*Empty, error) {
+	err := srv.withSession(req.SID, func(sess session.Session) error {
+		if req.Clear {
+			if err := sess.Clear(); err != nil {
+				return err
+			}
+		}
+		for _, key := range req.Deleted {
+			if err := sess.Delete(key); err != nil {
+				return err
+			}
+		}
+		for key, val := range req.Values {
+			if err := sess.Set(key, val); err != nil {
+				return err
+			}
+		}
+		return sess.Flush()
+	})
+	return &Empty{}, err
+}
+
+func (srv *Server) destroy(_ context.Context, req *SessionRequest) (*Empty, error) {
+	srv.mx.Lock()
+	delete(srv.locked, req.SID)
+	srv.mx.Unlock()
+	return &Empty{}, statusError(srv.manager.SessionDestroy(req.SID))
+}
+
+// increment changes encoded integer value under session lock.
+func (srv *Server) increment(_ context.Context, req *IncrementRequest) (*IncrementReply, error) {
+	rep := &IncrementReply{}
+	err := srv.withSession(req.SID, func(sess session.Session) error {
+		if err := sess.Lock(); err != nil {
+			return err
+		}
+		defer sess.Unlock()
+
+		var cur interface{}
+		var val []byte
+		if err := sess.Get(req.Key, &val); err == nil {
+			if cur, err = decodeValue(val); err != nil {
+				return err
+			}
+			cur, _ = session.UnwrapValueAt(srv.manager.Clock().Now(), cur)
+		}
+		new_val, err := session.IncrementValue(cur, req.Delta)
+		if err != nil {
+			return err
+		}
+		if val, err = encodeValue(new_val); err != nil {
+			return err
+		}
+		rep.Value = new_val
+		return sess.Put(req.Key, val)
+	})
+	return rep, err
+}
+
+// compareAndSwap sets encoded value under session lock if the current value equals the old one.
+func (srv *Server) compareAndSwap(_ context.Context, req *CompareAndSwapRequest) (*CompareAndSwapReply, error) {
+	rep := &CompareAndSwapReply{}
+	err := srv.withSession(req.SID, func(sess session.Session) error {
+		if err := sess.Lock(); err != nil {
+			return err
+		}
+		defer sess.Unlock()
+
+		var cur, old interface{}
+		var val []byte
+		if err := sess.Get(req.Key, &val); err == nil {
+			if cur, err = decodeValue(val); err != nil {
+				return err
+			}
+			cur, _ = session.UnwrapValueAt(srv.manager.Clock().Now(), cur)
+		}
+		if req.Old != nil {
+			var err error
+			if old, err = decodeValue(req.Old); err != nil {
+				return err
+			}
+		}
+		if !session.EqualValue(cur, old) {
+			return nil
+		}
+		rep.Swapped = true
+		if req.New == nil {
+			if err := sess.Delete(req.Key); err != nil {
+				return err
+			}
+			return sess.Flush()
+		}
+		return sess.Put(req.Key, req.New)
+	})
+	return rep, err
+}
+
+func (srv *Server) lock(_ context.Context, req *SessionRequest) (*SessionReply, error) {
+	if req.SID == "" {
+		return nil, status.Error(codes.InvalidArgument, "session ID is empty")
+	}
+	sess, err := srv.manager.SessionStart(req.SID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if err := sess.Lock(); err != nil {
+		srv.manager.SessionClose(req.SID)
+		return nil, statusError(err)
+	}
+	srv.mx.Lock()
+	srv.locked[req.SID] = sess
+	srv.mx.Unlock()
+
+	rep, err := sessionReply(sess)
+	return rep, statusError(err)
+}
+
+func (srv *Server) unlock(_ context.Context, req *SessionRequest) (*Empty, error) {
+	srv.mx.Lock()
+	sess, ok := srv.locked[req.SID]
+	delete(srv.locked, req.SID)
+	srv.mx.Unlock()
+	if !ok {
+		return &Empty{}, nil
+	}
+	defer srv.manager.SessionClose(req.SID)
+	return &Empty{}, statusError(sess.Unlock())
+}
+
+func (srv *Server) setExpiry(_ context.Context, req *ExpiryRequest) (*Empty, error) {
+	err := srv.withSession(req.SID, func(sess session.Session) error {
+		if err := sess.SetExpiry(req.Expiry); err != nil {
+			return err
+		}
+		return sess.Flush()
+	})
+	return &Empty{}, err
+}
+
+func (srv *Server) touch(_ context.Context, req *SessionRequest) (*Empty, error) {
+	err := srv.withSession(req.SID, func(sess session.Session) error {
+		return sess.Touch()
+	})
+	return &Empty{}, err
+}
+
+// allow counts action with the session of the server provider, so counters are kept apart from values.
+func (srv *Server) allow(_ context.Context, req *AllowRequest) (*AllowReply, error) {
+	rep := &AllowReply{}
+	err := srv.withSession(req.SID, func(sess session.Session) error {
+		var err error
+		rep.Allowed, err = sess.Allow(req.Action, req.Limit, req.Window)
+		return err
+	})
+	return rep, err
+}
+
+func (srv *Server) gc(_ context.Context, _ *Empty) (*session.GCReport, error) {
+	report := srv.manager.SessionGC(nil, session.LOG_LEVEL_ERROR)
+	return &report, nil
+}
+
+func (srv *Server) destroyAll(_ context.Context, _ *Empty) (*Empty, error) {
+	srv.mx.Lock()
+	srv.locked = make(map[string]session.Session)
+	srv.mx.Unlock()
+	srv.manager.DestroyAllSessions(nil, session.LOG_LEVEL_ERROR)
+	return &Empty{}, nil
+}
+
+// ping checks health of the manager provider.
+func (srv *Server) ping(ctx context.Context, _ *Empty) (*Empty, error) {
+	if err := srv.manager.HealthCheck(ctx); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &Empty{}, nil
+}
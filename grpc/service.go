@@ -0,0 +1,188 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"time"
+
+	"github.com/dronm/session"
+	rpc "google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// SERVICE_NAME is a full gRPC service name.
+const SERVICE_NAME = "session.Sessions"
+
+// CODEC_NAME is a content subtype of the service messages, see gobCodec.
+const CODEC_NAME = "gob"
+
+// Service methods.
+const (
+	METHOD_READ             = "Read"
+	METHOD_WRITE            = "Write"
+	METHOD_DESTROY          = "Destroy"
+	METHOD_INCREMENT        = "Increment"
+	METHOD_COMPARE_AND_SWAP = "CompareAndSwap"
+	METHOD_LOCK             = "Lock"
+	METHOD_UNLOCK           = "Unlock"
+	METHOD_SET_EXPIRY       = "SetExpiry"
+	METHOD_TOUCH            = "Touch"
+	METHOD_GC               = "GC"
+	METHOD_DESTROY_ALL      = "DestroyAll"
+	METHOD_PING             = "Ping"
+	METHOD_ALLOW            = "Allow"
+)
+
+// SessionRequest identifies a session.
+type SessionRequest struct {
+	SID string
+}
+
+// SessionReply holds session data, values are gob encoded.
+type SessionReply struct {
+	TimeCreated  time.Time
+	TimeAccessed time.Time
+	Values       map[string][]byte
+}
+
+// WriteRequest holds session modifications: all values are deleted if Clear is set,
+// then Deleted keys are deleted and Values are set.
+type WriteRequest struct {
+	SID     string
+	Clear   bool
+	Deleted []string
+	Values  map[string][]byte
+}
+
+// IncrementRequest adds Delta to an integer session value.
+type IncrementRequest struct {
+	SID   string
+	Key   string
+	Delta int64
+}
+
+// IncrementReply holds a new value.
+type IncrementReply struct {
+	Value int64
+}
+
+// CompareAndSwapRequest sets New value if the stored value equals Old one,
+// values are gob encoded, nil Old matches a missing value, nil New deletes the value.
+type CompareAndSwapRequest struct {
+	SID string
+	Key string
+	Old []byte
+	New []byte
+}
+
+// CompareAndSwapReply reports if the value is set.
+type CompareAndSwapReply struct {
+	Swapped bool
+}
+
+// ExpiryRequest sets session expiration, see session.Session.SetExpiry().
+type ExpiryRequest struct {
+	SID    string
+	Expiry time.Duration
+}
+
+// AllowRequest counts Action in the current window, see session.Session.Allow().
+type AllowRequest struct {
+	SID    string
+	Action string
+	Limit  int
+	Window time.Duration
+}
+
+// AllowReply reports if the action is allowed.
+type AllowReply struct {
+	Allowed bool
+}
+
+// Empty is an empty request/reply.
+type Empty struct{}
+
+// gobCodec encodes service messages with gob, so no generated protobuf code is needed.
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (gobCodec) Name() string {
+	return CODEC_NAME
+}
+
+// unaryHandler returns gRPC method handler calling fn with decoded request.
+func unaryHandler[Req any, Rep any](method string, fn func(*Server, context.Context, *Req) (*Rep, error)) rpc.MethodDesc {
+	return rpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor rpc.UnaryServerInterceptor) (interface{}, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return fn(srv.(*Server), ctx, req)
+			}
+			info := &rpc.UnaryServerInfo{Server: srv, FullMethod: "/" + SERVICE_NAME + "/" + method}
+			return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return fn(srv.(*Server), ctx, req.(*Req))
+			})
+		},
+	}
+}
+
+// serviceDesc describes session service.
+var serviceDesc = rpc.ServiceDesc{
+	ServiceName: SERVICE_NAME,
+	HandlerType: (*interface{})(nil),
+	Methods: []rpc.MethodDesc{
+		unaryHandler(METHOD_READ, (*Server).read),
+		unaryHandler(METHOD_WRITE, (*Server).write),
+		unaryHandler(METHOD_DESTROY, (*Server).destroy),
+		unaryHandler(METHOD_INCREMENT, (*Server).increment),
+		unaryHandler(METHOD_COMPARE_AND_SWAP, (*Server).compareAndSwap),
+		unaryHandler(METHOD_LOCK, (*Server).lock),
+		unaryHandler(METHOD_UNLOCK, (*Server).unlock),
+		unaryHandler(METHOD_SET_EXPIRY, (*Server).setExpiry),
+		unaryHandler(METHOD_TOUCH, (*Server).touch),
+		unaryHandler(METHOD_GC, (*Server).gc),
+		unaryHandler(METHOD_DESTROY_ALL, (*Server).destroyAll),
+		unaryHandler(METHOD_PING, (*Server).ping),
+		unaryHandler(METHOD_ALLOW, (*Server).allow),
+	},
+	Streams: []rpc.StreamDesc{},
+}
+
+// encodeValue gob encodes session value as interface,
+// so custom types must be registered with session.RegisterType() by clients.
+func encodeValue(value interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(&value); err != nil {
+		return nil, session.TypeError(err)
+	}
+	return b.Bytes(), nil
+}
+
+// decodeValue decodes session value encoded with encodeValue().
+func decodeValue(data []byte) (interface{}, error) {
+	var value interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		return nil, session.TypeError(err)
+	}
+	return value, nil
+}
+
+func init() {
+	encoding.RegisterCodec(gobCodec{})
+}
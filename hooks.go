@@ -0,0 +1,220 @@
+package session
+
+import (
+	"errors"
+	"time"
+)
+
+// SessionHook is called with session ID on session lifecycle events.
+type SessionHook func(sid string)
+
+// ValueHook is called when session value is set.
+type ValueHook func(sid string, key string, value interface{})
+
+// ExpiryNotifier is an optional interface for providers
+// reporting sessions removed by SessionGC.
+type ExpiryNotifier interface {
+	SetExpiredHook(SessionHook) //nil disables notification
+}
+
+// ChangeNotifier is an optional interface for providers reporting sessions
+// modified or destroyed by other application instances, e.g. with postgres LISTEN/NOTIFY.
+// Session ID is empty if any session may have been changed: all sessions are destroyed
+// or notifications may have been lost while reconnecting.
+type ChangeNotifier interface {
+	SetChangedHook(SessionHook) //nil disables notification
+}
+
+// sessionHooks holds registered callbacks.
+type sessionHooks struct {
+	created   []SessionHook
+	destroyed []SessionHook
+	expired   []SessionHook
+	changed   []SessionHook
+	valueSet  []ValueHook
+	gc        []GCHook
+	recovered []RecoveryHook
+}
+
+// OnSessionCreated registers a callback called after a new session
+// with a generated ID is started.
+// Hooks should be registered before sessions are started.
+func (manager *Manager) OnSessionCreated(fn SessionHook) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.hooks.created = append(manager.hooks.created, fn)
+}
+
+// OnSessionDestroyed registers a callback called after a session
+// is destroyed with SessionDestroy(). DestroyAllSessions() does not call it.
+func (manager *Manager) OnSessionDestroyed(fn SessionHook) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.hooks.destroyed = append(manager.hooks.destroyed, fn)
+}
+
+// OnSessionExpired registers a callback called by provider for every
+// session removed by SessionGC. Provider must implement ExpiryNotifier interface.
+func (manager *Manager) OnSessionExpired(fn SessionHook) error {
+	notifier, ok := manager.provider.(ExpiryNotifier)
+	if !ok {
+		return errors.New("session: provider does not support expiry notification")
+	}
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.hooks.expired = append(manager.hooks.expired, fn)
+	notifier.SetExpiredHook(manager.sessionExpired)
+	return nil
+}
+
+// OnSessionChanged registers a callback called by provider for every session
+// modified or destroyed by another application instance, see ChangeNotifier.
+// Provider must implement ChangeNotifier interface.
+func (manager *Manager) OnSessionChanged(fn SessionHook) error {
+	notifier, ok := manager.provider.(ChangeNotifier)
+	if !ok {
+		return errors.New("session: provider does not support change notification")
+	}
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.hooks.changed = append(manager.hooks.changed, fn)
+	notifier.SetChangedHook(manager.sessionChanged)
+	return nil
+}
+
+// OnValueSet registers a callback called after a session value is set with Set() or Put().
+// Sessions returned by SessionStart() are wrapped to intercept the calls.
+func (manager *Manager) OnValueSet(fn ValueHook) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.hooks.valueSet = append(manager.hooks.valueSet, fn)
+}
+
+func (manager *Manager) sessionCreated(sid string) {
+	manager.metrics.add(METRIC_SESSIONS_CREATED, 1)
+	manager.metrics.add(METRIC_SESSIONS_ACTIVE, 1)
+	for _, fn := range manager.hooks.created {
+		fn(sid)
+	}
+}
+
+func (manager *Manager) sessionDestroyed(sid string) {
+	manager.metrics.add(METRIC_SESSIONS_DESTROYED, 1)
+	manager.metrics.add(METRIC_SESSIONS_ACTIVE, -1)
+	manager.unbindUser(sid)
+	for _, fn := range manager.hooks.destroyed {
+		fn(sid)
+	}
+}
+
+func (manager *Manager) sessionExpired(sid string) {
+	manager.metrics.add(METRIC_SESSIONS_EXPIRED, 1)
+	manager.metrics.add(METRIC_SESSIONS_ACTIVE, -1)
+	manager.unbindUser(sid)
+	for _, fn := range manager.hooks.expired {
+		fn(sid)
+	}
+}
+
+func (manager *Manager) sessionChanged(sid string) {
+	for _, fn := range manager.hooks.changed {
+		fn(sid)
+	}
+}
+
+// managedSession calls value hooks on Set/Put, counts writes in metrics
+// and registers types of set values, see SetAutoRegisterTypes().
+type managedSession struct {
+	Session
+	manager *Manager
+}
+
+// register registers type of value before it is set.
+func (s *managedSession) register(value interface{}) {
+	if !s.manager.noAutoRegister {
+		registerValueType(value)
+	}
+}
+
+func (s *managedSession) Set(key string, value interface{}) error {
+	s.register(value)
+	if err := s.Session.Set(key, value); err != nil {
+		return err
+	}
+	s.valueSet(key, value)
+	return nil
+}
+
+func (s *managedSession) Put(key string, value interface{}) error {
+	s.register(value)
+	start := time.Now()
+	err := s.Session.Put(key, value)
+	s.manager.metrics.observe(METRIC_WRITES, METRIC_WRITE_DURATION, start, err)
+	if err != nil {
+		return err
+	}
+	s.valueSet(key, value)
+	return nil
+}
+
+func (s *managedSession) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	s.register(value)
+	if err := s.Session.SetWithTTL(key, value, ttl); err != nil {
+		return err
+	}
+	s.valueSet(key, value)
+	return nil
+}
+
+// SetSensitive sets encrypted value with managed Set(), so hooks get SensitiveValue.
+func (s *managedSession) SetSensitive(key string, value interface{}) error {
+	s.register(value)
+	return SetSensitive(s, key, value)
+}
+
+func (s *managedSession) SetMany(values map[string]interface{}) error {
+	for _, value := range values {
+		s.register(value)
+	}
+	if err := s.Session.SetMany(values); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(values) {
+		s.valueSet(key, values[key])
+	}
+	return nil
+}
+
+// CompareAndSwap calls value hooks if a new value is set.
+func (s *managedSession) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
+	s.register(newValue)
+	swapped, err := s.Session.CompareAndSwap(key, oldValue, newValue)
+	if err == nil && swapped && newValue != nil {
+		s.valueSet(key, newValue)
+	}
+	return swapped, err
+}
+
+// Bucket returns a bucket of the managed session.
+func (s *managedSession) Bucket(name string) Session {
+	return NewBucket(s, name)
+}
+
+// GetOrSet stores computed value with managed Put().
+func (s *managedSession) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	return GetOrSet(s, key, dest, compute)
+}
+
+func (s *managedSession) Flush() error {
+	start := time.Now()
+	err := s.Session.Flush()
+	s.manager.metrics.observe(METRIC_WRITES, METRIC_WRITE_DURATION, start, err)
+	return err
+}
+
+func (s *managedSession) valueSet(key string, value interface{}) {
+	sid := s.SessionID()
+	for _, fn := range s.manager.hooks.valueSet {
+		fn(sid, key, value)
+	}
+}
@@ -0,0 +1,34 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// KeepAlive marks session accessed with Session.Touch() every interval till ctx is done,
+// so idle GC does not remove sessions of users connected with a long-lived connection
+// (websocket, SSE) without issuing HTTP requests. interval must be less than max idle time.
+// KeepAlive blocks, it returns nil when ctx is done or Touch() error, e.g. ErrSessionNotFound
+// for a destroyed session:
+//
+//	ctx, cancel := context.WithCancel(r.Context())
+//	defer cancel()
+//	go session.KeepAlive(ctx, currentSession, time.Minute)
+func KeepAlive(ctx context.Context, s Session, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("session: keep alive interval must be positive")
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := s.Touch(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// killSchedule returns the next time all sessions are destroyed.
+type killSchedule interface {
+	next(after time.Time) time.Time //the first kill time after given time, zero time if none
+}
+
+// parseKillSchedule parses comma separated list of day times in format 00:00 or 00:00:00,
+// or a cron expression with 5 fields: minute hour day-of-month month day-of-week.
+func parseKillSchedule(s string) (killSchedule, error) {
+	if len(strings.Fields(s)) == CRON_FIELDS {
+		return parseCron(s)
+	}
+	times := make(dailySchedule, 0)
+	for _, time_s := range strings.Split(s, ",") {
+		t, err := parseTime(strings.TrimSpace(time_s))
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+// dailySchedule holds kill times of every day, local time.
+type dailySchedule []time.Time
+
+func (sched dailySchedule) next(after time.Time) time.Time {
+	var res time.Time
+	for _, t := range sched {
+		kill_t := time.Date(after.Year(), after.Month(), after.Day(), t.Hour(), t.Minute(), t.Second(), 0, after.Location())
+		if !kill_t.After(after) {
+			kill_t = kill_t.AddDate(0, 0, 1)
+		}
+		if res.IsZero() || kill_t.Before(res) {
+			res = kill_t
+		}
+	}
+	return res
+}
+
+// CRON_FIELDS is a number of fields of a cron expression.
+const CRON_FIELDS = 5
+
+// cronSchedule is a parsed cron expression, every field is a bit set of allowed values.
+// As in cron, a day matches if day of month or day of week matches when both fields are restricted.
+type cronSchedule struct {
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool //day of month is *
+	dowStar bool //day of week is *
+}
+
+var cron_months = []string{"", "JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+var cron_days = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+
+// parseCron parses cron expression, e.g. "0 3 * * SUN".
+// Fields support *, values, ranges (1-5), lists (1,15), steps (*/15, 0-30/10),
+// month names (JAN-DEC) and day names (SUN-SAT), 7 is Sunday as well as 0.
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != CRON_FIELDS {
+		return nil, fmt.Errorf("session: cron expression %q: %d fields expected", expr, CRON_FIELDS)
+	}
+	sched := &cronSchedule{domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	var err error
+	if sched.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("session: cron minute %q: %w", fields[0], err)
+	}
+	if sched.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("session: cron hour %q: %w", fields[1], err)
+	}
+	if sched.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("session: cron day of month %q: %w", fields[2], err)
+	}
+	if sched.month, err = parseCronField(fields[3], 1, 12, cron_months); err != nil {
+		return nil, fmt.Errorf("session: cron month %q: %w", fields[3], err)
+	}
+	if sched.dow, err = parseCronField(fields[4], 0, 7, cron_days); err != nil {
+		return nil, fmt.Errorf("session: cron day of week %q: %w", fields[4], err)
+	}
+	if sched.dow&(1<<7) != 0 {
+		sched.dow |= 1 //Sunday
+	}
+	return sched, nil
+}
+
+// parseCronField returns bit set of values of a comma separated field.
+// names are value names indexed by value.
+func parseCronField(field string, min, max int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if ind := strings.Index(part, "/"); ind >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[ind+1:]); err != nil || step <= 0 {
+				return 0, errors.New("invalid step")
+			}
+			part = part[:ind]
+		}
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = parseCronValue(bounds[0], min, max, names); err != nil {
+				return 0, err
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = parseCronValue(bounds[1], min, max, names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				to = max //5/15 means 5-max/15
+			}
+			if to < from {
+				return 0, errors.New("invalid range")
+			}
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(s string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if name != "" && strings.EqualFold(s, name) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", v, min, max)
+	}
+	return v, nil
+}
+
+// CRON_SEARCH_YEARS limits the search of the next time matching a cron expression,
+// e.g. for 30 February which never comes.
+const CRON_SEARCH_YEARS = 5
+
+func (sched *cronSchedule) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	max_year := t.Year() + CRON_SEARCH_YEARS
+	for t.Year() <= max_year {
+		switch {
+		case sched.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !sched.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case sched.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case sched.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (sched *cronSchedule) dayMatches(t time.Time) bool {
+	dom := sched.dom&(1<<uint(t.Day())) != 0
+	dow := sched.dow&(1<<uint(t.Weekday())) != 0
+	if sched.domStar || sched.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// NextKillTime returns the first time after given time when all sessions are destroyed by GC,
+// zero time if kill time is not set.
+func (manager *Manager) NextKillTime(after time.Time) time.Time {
+	sched := manager.killScheduler()
+	if sched == nil {
+		return time.Time{}
+	}
+	return sched.next(after)
+}
+
+// killScheduler returns kill schedule, SessionsKillTime is used if it is set directly.
+func (manager *Manager) killScheduler() killSchedule {
+	if manager.killSchedule != nil {
+		return manager.killSchedule
+	}
+	if manager.SessionsKillTime != (time.Time{}) {
+		return dailySchedule{manager.SessionsKillTime}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package session
+
+import (
+	"log/slog"
+	"time"
+)
+
+// GC_LEADER_KILL_TTL is a leadership time acquired for sessions killing at kill time.
+const GC_LEADER_KILL_TTL = time.Minute
+
+// GCLeaderLocker is an optional interface for providers able to elect one application instance
+// running GC among instances sharing the storage.
+type GCLeaderLocker interface {
+	//AcquireGCLeader acquires or extends leadership named name for at least ttl,
+	//returns false if another instance is the leader
+	AcquireGCLeader(name string, ttl time.Duration) (bool, error)
+	//ReleaseGCLeader releases leadership if it is held
+	ReleaseGCLeader(name string) error
+}
+
+// SetGCLeaderLock makes GC started by StartGC() run by one application instance only
+// when many instances share the storage: before every SessionGC() and DestroyAllSessions() run
+// the instance acquires a distributed lock named name, the run is skipped if another instance holds it.
+// The leader keeps the lock while it runs GC, the lock is released by StopGC().
+// Instances of one application must use the same name. Empty name disables the lock.
+// Provider must implement GCLeaderLocker interface.
+func (manager *Manager) SetGCLeaderLock(name string) error {
+	if name != "" {
+		if _, ok := manager.provider.(GCLeaderLocker); !ok {
+			return ErrNoGCLeaderLock
+		}
+	}
+	manager.gcLeader = name
+	return nil
+}
+
+// isGCLeader acquires GC leadership for ttl, returns true if GC should be run by this instance.
+func (manager *Manager) isGCLeader(log *slog.Logger, ttl time.Duration) bool {
+	if manager.gcLeader == "" {
+		return true
+	}
+	locker, ok := manager.provider.(GCLeaderLocker)
+	if !ok {
+		return true
+	}
+	leader, err := locker.AcquireGCLeader(manager.gcLeader, ttl)
+	if err != nil {
+		log.Error("AcquireGCLeader() failed", LOG_KEY_ERROR, err)
+		return false
+	}
+	if !leader {
+		log.Debug("GC is run by another instance")
+	}
+	return leader
+}
+
+// releaseGCLeader releases GC leadership if GC leader lock is used.
+func (manager *Manager) releaseGCLeader() error {
+	if manager.gcLeader == "" {
+		return nil
+	}
+	locker, ok := manager.provider.(GCLeaderLocker)
+	if !ok {
+		return nil
+	}
+	return locker.ReleaseGCLeader(manager.gcLeader)
+}
+
+// gcLeaderLocker returns p as GCLeaderLocker, used by decorators.
+func gcLeaderLocker(p Provider) (GCLeaderLocker, error) {
+	locker, ok := p.(GCLeaderLocker)
+	if !ok {
+		return nil, ErrNoGCLeaderLock
+	}
+	return locker, nil
+}
@@ -0,0 +1,375 @@
+package session
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// KEY_ORDER is a session value holding keys in write order, oldest first.
+// It is kept when eviction is enabled with SetMaxSessionSize(),
+// it is hidden from Keys() and is not counted in session size.
+const KEY_ORDER = "session_key_order"
+
+// SizedSession is implemented by sessions reporting encoded size of their values.
+// Sessions not implementing it are measured with EncodedSize() of every value.
+type SizedSession interface {
+	ValueSizes() (map[string]int, error) //encoded value sizes by key
+}
+
+// SetMaxSessionSize limits session size: total length of keys and encoded values
+// must not exceed maxSize bytes, 0 means no limit.
+// Set(), Put() and Flush() return ErrSessionTooLarge if a session exceeds the limit.
+// If evictOldest is set, the oldest written keys are deleted to make room for a new value,
+// ErrSessionTooLarge is returned only if the value does not fit in an empty session.
+// Should be set before sessions are started.
+func (manager *Manager) SetMaxSessionSize(maxSize int, evictOldest bool) {
+	manager.maxSessionSize = maxSize
+	manager.evictOldest = evictOldest
+}
+
+// EncodedSize returns gob encoded size of value.
+func EncodedSize(value interface{}) (int, error) {
+	if value == nil {
+		return 0, nil
+	}
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(value); err != nil {
+		return 0, err
+	}
+	return b.Len(), nil
+}
+
+// limitedSession enforces session size limit.
+// Value sizes are loaded on the first modification and are tracked afterwards.
+type limitedSession struct {
+	Session
+	maxSize int
+	evict   bool
+	mx      sync.Mutex
+	sizes   map[string]int //encoded value sizes by key, nil if not loaded
+	order   []string       //keys in write order, oldest first, eviction mode only
+}
+
+// loadSizes reads value sizes and key order.
+func (s *limitedSession) loadSizes() error {
+	if s.sizes != nil {
+		return nil
+	}
+	var sizes map[string]int
+	if sized, ok := s.Session.(SizedSession); ok {
+		var err error
+		if sizes, err = sized.ValueSizes(); err != nil {
+			return err
+		}
+	} else {
+		keys, err := s.Session.Keys()
+		if err != nil {
+			return err
+		}
+		sizes = make(map[string]int, len(keys))
+		for _, key := range keys {
+			var value interface{}
+			if err := s.Session.Get(key, &value); err != nil {
+				return err
+			}
+			if sizes[key], err = EncodedSize(value); err != nil {
+				return err
+			}
+		}
+	}
+	delete(sizes, KEY_ORDER)
+
+	s.order = nil
+	if s.evict {
+		var order []string
+		_ = s.Session.Get(KEY_ORDER, &order)
+		//keys written before eviction was enabled are the oldest ones
+		in_order := make(map[string]bool, len(order))
+		for _, key := range order {
+			in_order[key] = true
+		}
+		var unordered []string
+		for key := range sizes {
+			if !in_order[key] {
+				unordered = append(unordered, key)
+			}
+		}
+		sort.Strings(unordered)
+		s.order = unordered
+		for _, key := range order {
+			if _, ok := sizes[key]; ok {
+				s.order = append(s.order, key)
+			}
+		}
+	}
+	s.sizes = sizes
+	return nil
+}
+
+// total returns session size.
+func (s *limitedSession) total() int {
+	total := 0
+	for key, size := range s.sizes {
+		total += len(key) + size
+	}
+	return total
+}
+
+func (s *limitedSession) tooLarge(size int) error {
+	return fmt.Errorf("%w: %d bytes, max %d", ErrSessionTooLarge, size, s.maxSize)
+}
+
+// makeRoom checks that value of size fits in session under key,
+// the oldest keys are deleted if eviction is enabled.
+func (s *limitedSession) makeRoom(key string, size int) error {
+	total := s.total() - s.entrySize(key) + len(key) + size
+	if total <= s.maxSize {
+		return nil
+	}
+	if !s.evict {
+		return s.tooLarge(total)
+	}
+	var evicted []string
+	for _, old_key := range s.order {
+		if total <= s.maxSize {
+			break
+		}
+		if old_key != key {
+			total -= s.entrySize(old_key)
+			evicted = append(evicted, old_key)
+		}
+	}
+	if total > s.maxSize {
+		return s.tooLarge(total)
+	}
+	for _, old_key := range evicted {
+		if err := s.Session.Delete(old_key); err != nil {
+			return err
+		}
+		s.forget(old_key)
+	}
+	return nil
+}
+
+func (s *limitedSession) entrySize(key string) int {
+	if size, ok := s.sizes[key]; ok {
+		return len(key) + size
+	}
+	return 0
+}
+
+// written updates size and write order of key.
+func (s *limitedSession) written(key string, size int) error {
+	s.sizes[key] = size
+	if !s.evict {
+		return nil
+	}
+	s.removeOrder(key)
+	s.order = append(s.order, key)
+	return s.Session.Set(KEY_ORDER, s.order)
+}
+
+func (s *limitedSession) forget(key string) {
+	delete(s.sizes, key)
+	s.removeOrder(key)
+}
+
+func (s *limitedSession) removeOrder(key string) {
+	for i, k := range s.order {
+		if k == key {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			return
+		}
+	}
+}
+
+// set checks size limit and sets value.
+func (s *limitedSession) set(key string, value interface{}) error {
+	return s.setWith(key, value, func() error { return s.Session.Set(key, value) })
+}
+
+// setWith checks size limit and sets value with write.
+func (s *limitedSession) setWith(key string, value interface{}, write func() error) error {
+	size, err := EncodedSize(value)
+	if err != nil {
+		return err
+	}
+	if err := s.loadSizes(); err != nil {
+		return err
+	}
+	if err := s.makeRoom(key, size); err != nil {
+		return err
+	}
+	if err := write(); err != nil {
+		return err
+	}
+	return s.written(key, size)
+}
+
+func (s *limitedSession) Set(key string, value interface{}) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.set(key, value)
+}
+
+func (s *limitedSession) Put(key string, value interface{}) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if err := s.set(key, value); err != nil {
+		return err
+	}
+	return s.Session.Flush()
+}
+
+func (s *limitedSession) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.setWith(key, value, func() error { return s.Session.SetWithTTL(key, value, ttl) })
+}
+
+// SetSensitive checks size of the encrypted value.
+func (s *limitedSession) SetSensitive(key string, value interface{}) error {
+	return SetSensitive(s, key, value)
+}
+
+// SetMany sets values one by one, as every value is checked against the limit.
+func (s *limitedSession) SetMany(values map[string]interface{}) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	for _, key := range sortedKeys(values) {
+		if err := s.set(key, values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush checks that session does not exceed the limit, e.g. after the limit is lowered.
+func (s *limitedSession) Flush() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.sizes != nil {
+		if err := s.makeRoom("", 0); err != nil {
+			return err
+		}
+	}
+	return s.Session.Flush()
+}
+
+func (s *limitedSession) Delete(key string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if err := s.Session.Delete(key); err != nil {
+		return err
+	}
+	if s.sizes != nil {
+		s.forget(key)
+		if s.evict {
+			return s.Session.Set(KEY_ORDER, s.order)
+		}
+	}
+	return nil
+}
+
+func (s *limitedSession) Clear() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.sizes = nil
+	return s.Session.Clear()
+}
+
+// Restore replaces values, sizes are reloaded as after Clear().
+func (s *limitedSession) Restore(snapshot map[string]interface{}) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.sizes = nil
+	return s.Session.Restore(snapshot)
+}
+
+func (s *limitedSession) Increment(key string, delta int64) (int64, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	val, err := s.Session.Increment(key, delta)
+	if err != nil {
+		return 0, err
+	}
+	if s.sizes != nil {
+		size, _ := EncodedSize(val)
+		return val, s.written(key, size)
+	}
+	return val, nil
+}
+
+func (s *limitedSession) Decrement(key string, delta int64) (int64, error) {
+	return s.Increment(key, -delta)
+}
+
+// CompareAndSwap returns ErrSessionTooLarge if newValue does not fit, no keys are evicted.
+func (s *limitedSession) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	size, err := EncodedSize(newValue)
+	if err != nil {
+		return false, err
+	}
+	if err := s.loadSizes(); err != nil {
+		return false, err
+	}
+	if total := s.total() - s.entrySize(key) + len(key) + size; total > s.maxSize {
+		return false, s.tooLarge(total)
+	}
+	swapped, err := s.Session.CompareAndSwap(key, oldValue, newValue)
+	if err != nil || !swapped {
+		return swapped, err
+	}
+	if newValue == nil {
+		s.forget(key)
+		if s.evict {
+			return true, s.Session.Set(KEY_ORDER, s.order)
+		}
+		return true, nil
+	}
+	return true, s.written(key, size)
+}
+
+func (s *limitedSession) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	return GetOrSet(s, key, dest, compute)
+}
+
+// Lock reloads values, so sizes are loaded again.
+func (s *limitedSession) Lock() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.sizes = nil
+	return s.Session.Lock()
+}
+
+// Keys returns session keys without KEY_ORDER.
+func (s *limitedSession) Keys() ([]string, error) {
+	keys, err := s.Session.Keys()
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		if key == KEY_ORDER {
+			return append(keys[:i], keys[i+1:]...), nil
+		}
+	}
+	return keys, nil
+}
+
+func (s *limitedSession) Len() (int, error) {
+	keys, err := s.Keys()
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+func (s *limitedSession) Bucket(name string) Session {
+	return NewBucket(s, name)
+}
@@ -0,0 +1,146 @@
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Structured log fields.
+const (
+	LOG_KEY_PROVIDER  = "provider"
+	LOG_KEY_SID       = "sid"
+	LOG_KEY_SID_HASH  = "sid_hash" //see HashSessionID()
+	LOG_KEY_OPERATION = "operation"
+	LOG_KEY_DURATION  = "duration"
+	LOG_KEY_ERROR     = "error"
+	LOG_KEY_COUNT     = "count"
+)
+
+// SID_HASH_LEN is the number of hash bytes in HashSessionID().
+const SID_HASH_LEN = 8
+
+// HashSessionID returns hex encoded prefix of SHA-256 of sid, it is logged as LOG_KEY_SID_HASH
+// where session ID must not be exposed, so log readers can not use it.
+func HashSessionID(sid string) string {
+	h := sha256.Sum256([]byte(sid))
+	return hex.EncodeToString(h[:SID_HASH_LEN])
+}
+
+// LoggedProvider is an optional interface for providers
+// supporting structured logging.
+type LoggedProvider interface {
+	SetLogger(*slog.Logger) //nil restores io.Writer logging
+}
+
+// SlogLevel maps LogLevel to slog.Level.
+func (lv LogLevel) SlogLevel() slog.Level {
+	switch lv {
+	case LOG_LEVEL_ERROR:
+		return slog.LevelError
+	case LOG_LEVEL_WARN:
+		return slog.LevelWarn
+	default:
+		return slog.LevelDebug
+	}
+}
+
+// logLevelFromSlog maps slog.Level to LogLevel.
+func logLevelFromSlog(level slog.Level) LogLevel {
+	if level >= slog.LevelError {
+		return LOG_LEVEL_ERROR
+	} else if level >= slog.LevelWarn {
+		return LOG_LEVEL_WARN
+	}
+	return LOG_LEVEL_DEBUG
+}
+
+// writerHandler is a slog.Handler writing records to io.Writer
+// in WriteToLog() format with attributes appended as key=value pairs.
+type writerHandler struct {
+	w      io.Writer
+	logLev LogLevel
+	attrs  string //preformatted attributes
+	group  string //attribute key prefix
+	mx     *sync.Mutex
+}
+
+func (h *writerHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.w != nil && logLevelFromSlog(level) <= h.logLev
+}
+
+func (h *writerHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		h.appendAttr(&b, h.group, a)
+		return true
+	})
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	_, err := io.WriteString(h.w, "SessionManager	"+t.Format(time.RFC3339)+"	"+logLevelFromSlog(r.Level).String()+"	"+b.String()+"\n")
+	return err
+}
+
+func (h *writerHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	b.WriteString(h.attrs)
+	for _, a := range attrs {
+		h.appendAttr(&b, h.group, a)
+	}
+	h2 := *h
+	h2.attrs = b.String()
+	return &h2
+}
+
+func (h *writerHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.group = h.group + name + "."
+	return &h2
+}
+
+func (h *writerHandler) appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			h.appendAttr(b, prefix, ga)
+		}
+		return
+	}
+	fmt.Fprintf(b, " %s%s=%v", prefix, a.Key, a.Value.Any())
+}
+
+// NewWriterLogger returns slog.Logger writing to w in WriteToLog() format.
+// Records above logLev are skipped. If w is nil, nothing is logged.
+func NewWriterLogger(w io.Writer, logLev LogLevel) *slog.Logger {
+	return slog.New(&writerHandler{w: w, logLev: logLev, mx: &sync.Mutex{}})
+}
+
+// LoggerFor returns logger if it is set,
+// otherwise io.Writer adapter is returned, see NewWriterLogger().
+func LoggerFor(logger *slog.Logger, w io.Writer, logLev LogLevel) *slog.Logger {
+	if logger != nil {
+		return logger
+	}
+	return NewWriterLogger(w, logLev)
+}
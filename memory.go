@@ -0,0 +1,339 @@
+package session
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memorySession is a session kept in process memory only,
+// it is used in degraded mode of the circuit breaker, see SetCircuitBreaker().
+type memorySession struct {
+	sid          string
+	mx           sync.RWMutex
+	timeCreated  time.Time
+	timeAccessed time.Time
+	value        map[string]interface{}
+	rates        RateCounters
+	clock        Clock
+}
+
+func newMemorySession(sid string, clock Clock) *memorySession {
+	clock = clockOrSystem(clock)
+	now := clock.Now()
+	return &memorySession{sid: sid, timeCreated: now, timeAccessed: now, value: make(map[string]interface{}), rates: make(RateCounters), clock: clock}
+}
+
+// setClock sets clock of access time, value TTLs and rate windows.
+func (st *memorySession) setClock(clock Clock) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.clock = clockOrSystem(clock)
+}
+
+func (st *memorySession) Set(key string, value interface{}) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value[key] = value
+	st.timeAccessed = st.clock.Now()
+	return nil
+}
+
+func (st *memorySession) Put(key string, value interface{}) error {
+	return st.Set(key, value)
+}
+
+func (st *memorySession) SetMany(values map[string]interface{}) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	for key, value := range values {
+		st.value[key] = value
+	}
+	st.timeAccessed = st.clock.Now()
+	return nil
+}
+
+func (st *memorySession) GetMany(dest map[string]interface{}) error {
+	return GetMany(st, dest)
+}
+
+func (st *memorySession) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.timeAccessed = st.clock.Now()
+	st.value[key] = NewExpiringValueAt(st.timeAccessed, value, ttl)
+	return nil
+}
+
+func (st *memorySession) Snapshot() (map[string]interface{}, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return SnapshotValuesAt(st.clock.Now(), st.value)
+}
+
+func (st *memorySession) Restore(snapshot map[string]interface{}) error {
+	value, err := CopyValues(snapshot)
+	if err != nil {
+		return err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value = value
+	return nil
+}
+
+// Flush does nothing, values are not persisted.
+func (st *memorySession) Flush() error {
+	return nil
+}
+
+func (st *memorySession) lookup(key string) (interface{}, bool) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return LookupValueAt(st.clock.Now(), st.value, key)
+}
+
+func (st *memorySession) Get(key string, val interface{}) error {
+	store_val, ok := st.lookup(key)
+	if !ok {
+		return ErrKeyNotFound
+	}
+	return assignPtr(store_val, val)
+}
+
+func (st *memorySession) GetStruct(key string, dest interface{}) error {
+	store_val, ok := st.lookup(key)
+	if !ok {
+		return ErrKeyNotFound
+	}
+	return AssignValue(store_val, dest)
+}
+
+func (st *memorySession) GetBool(key string) bool {
+	v, _ := st.lookup(key)
+	return boolOf(v)
+}
+
+func (st *memorySession) GetString(key string) string {
+	v, _ := st.lookup(key)
+	return stringOf(v)
+}
+
+func (st *memorySession) GetInt(key string) int64 {
+	v, _ := st.lookup(key)
+	return intOf(v)
+}
+
+func (st *memorySession) GetFloat(key string) float64 {
+	v, _ := st.lookup(key)
+	return floatOf(v)
+}
+
+func (st *memorySession) GetDate(key string) time.Time {
+	v, _ := st.lookup(key)
+	return dateOf(v)
+}
+
+func (st *memorySession) GetBytes(key string) []byte {
+	v, _ := st.lookup(key)
+	return bytesOf(v)
+}
+
+func (st *memorySession) GetStringSlice(key string) []string {
+	v, _ := st.lookup(key)
+	return stringSliceOf(v)
+}
+
+// assignPtr assigns in-memory value to val pointer as Session.Get() does, the type must match.
+func assignPtr(store_val interface{}, val interface{}) error {
+	val_type := reflect.TypeOf(val)
+	if val_type.Kind() != reflect.Ptr {
+		return ErrValMustBePtr
+	}
+	if !reflect.TypeOf(store_val).AssignableTo(val_type.Elem()) {
+		return ErrTypeMismatch
+	}
+	reflect.ValueOf(val).Elem().Set(reflect.ValueOf(store_val))
+	return nil
+}
+
+//conversions of in-memory values of typed getters, zero values are returned for other types
+
+func boolOf(v interface{}) bool {
+	v_bool, _ := v.(bool)
+	return v_bool
+}
+
+func stringOf(v interface{}) string {
+	switch v_str := v.(type) {
+	case string:
+		return v_str
+	case []byte:
+		return string(v_str)
+	}
+	return ""
+}
+
+func intOf(v interface{}) int64 {
+	switch v_i := v.(type) {
+	case int64:
+		return v_i
+	case int:
+		return int64(v_i)
+	}
+	return 0
+}
+
+func floatOf(v interface{}) float64 {
+	switch v_f := v.(type) {
+	case float64:
+		return v_f
+	case float32:
+		return float64(v_f)
+	}
+	return 0
+}
+
+func dateOf(v interface{}) time.Time {
+	v_t, _ := v.(time.Time)
+	return v_t
+}
+
+func bytesOf(v interface{}) []byte {
+	switch v_b := v.(type) {
+	case []byte:
+		return v_b
+	case string:
+		return []byte(v_b)
+	}
+	return nil
+}
+
+func stringSliceOf(v interface{}) []string {
+	v_s, _ := v.([]string)
+	return v_s
+}
+
+func (st *memorySession) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	return GetOrSet(st, key, dest, compute)
+}
+
+func (st *memorySession) Delete(key string) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	delete(st.value, key)
+	return nil
+}
+
+func (st *memorySession) Clear() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value = make(map[string]interface{})
+	return nil
+}
+
+func (st *memorySession) Increment(key string, delta int64) (int64, error) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	cur, _ := LookupValueAt(st.clock.Now(), st.value, key)
+	new_val, err := IncrementValue(cur, delta)
+	if err != nil {
+		return 0, err
+	}
+	st.value[key] = new_val
+	return new_val, nil
+}
+
+func (st *memorySession) Decrement(key string, delta int64) (int64, error) {
+	return st.Increment(key, -delta)
+}
+
+func (st *memorySession) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if cur, _ := LookupValueAt(st.clock.Now(), st.value, key); !EqualValue(cur, oldValue) {
+		return false, nil
+	}
+	if newValue == nil {
+		delete(st.value, key)
+	} else {
+		st.value[key] = newValue
+	}
+	return true, nil
+}
+
+func (st *memorySession) Bucket(name string) Session {
+	return NewBucket(st, name)
+}
+
+func (st *memorySession) Keys() ([]string, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	keys := make([]string, 0, len(st.value))
+	now := st.clock.Now()
+	for key, v := range st.value {
+		if !ValueExpiredAt(now, v) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (st *memorySession) Len() (int, error) {
+	keys, err := st.Keys()
+	return len(keys), err
+}
+
+func (st *memorySession) SessionID() string {
+	return st.sid
+}
+
+func (st *memorySession) TimeCreated() time.Time {
+	return st.timeCreated
+}
+
+func (st *memorySession) TimeAccessed() time.Time {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return st.timeAccessed
+}
+
+// Lock does nothing, the session is kept by one process.
+func (st *memorySession) Lock() error {
+	return nil
+}
+
+func (st *memorySession) Unlock() error {
+	return nil
+}
+
+// SetExpiry does nothing, the session lives while the breaker is open.
+func (st *memorySession) SetExpiry(d time.Duration) error {
+	return nil
+}
+
+func (st *memorySession) Touch() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.timeAccessed = st.clock.Now()
+	return nil
+}
+
+func (st *memorySession) Allow(action string, limit int, window time.Duration) (bool, error) {
+	st.mx.RLock()
+	clock := st.clock
+	st.mx.RUnlock()
+	return AllowRate(st, clock, action, limit, window)
+}
+
+func (st *memorySession) CountRate(action string, now time.Time, window time.Duration) (int64, error) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	return st.rates.Count(action, now, window), nil
+}
+
+func (st *memorySession) SetSensitive(key string, value interface{}) error {
+	return SetSensitive(st, key, value)
+}
@@ -0,0 +1,107 @@
+package session
+
+import (
+	"errors"
+	"expvar"
+	"time"
+)
+
+// Metric names. Durations are accumulated in nanoseconds,
+// average latency is duration divided by the corresponding counter.
+const (
+	METRIC_SESSIONS_ACTIVE    = "sessions_active" //created minus destroyed and expired since metrics are enabled
+	METRIC_SESSIONS_CREATED   = "sessions_created"
+	METRIC_SESSIONS_DESTROYED = "sessions_destroyed"
+	METRIC_SESSIONS_EXPIRED   = "sessions_expired" //provider must implement ExpiryNotifier
+	METRIC_GC_RUNS            = "gc_runs"
+	METRIC_GC_DURATION        = "gc_duration_ns"
+	METRIC_READS              = "reads"
+	METRIC_READ_DURATION      = "read_duration_ns"
+	METRIC_WRITES             = "writes"
+	METRIC_WRITE_DURATION     = "write_duration_ns"
+	METRIC_ERRORS             = "errors"
+)
+
+var metric_names = []string{
+	METRIC_SESSIONS_ACTIVE,
+	METRIC_SESSIONS_CREATED,
+	METRIC_SESSIONS_DESTROYED,
+	METRIC_SESSIONS_EXPIRED,
+	METRIC_GC_RUNS,
+	METRIC_GC_DURATION,
+	METRIC_READS,
+	METRIC_READ_DURATION,
+	METRIC_WRITES,
+	METRIC_WRITE_DURATION,
+	METRIC_ERRORS,
+}
+
+// Metrics holds manager counters in expvar map.
+// The map can be exported to prometheus with expvar collector.
+type Metrics struct {
+	vars *expvar.Map
+}
+
+func newMetrics() *Metrics {
+	m := &Metrics{vars: new(expvar.Map).Init()}
+	for _, name := range metric_names {
+		m.vars.Add(name, 0)
+	}
+	return m
+}
+
+// Map returns expvar map with all counters.
+func (m *Metrics) Map() *expvar.Map {
+	return m.vars
+}
+
+// Get returns counter value by its name.
+func (m *Metrics) Get(name string) int64 {
+	if v, ok := m.vars.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func (m *Metrics) add(name string, delta int64) {
+	if m != nil {
+		m.vars.Add(name, delta)
+	}
+}
+
+// observe counts an operation with its duration and error.
+func (m *Metrics) observe(countName, durationName string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.vars.Add(countName, 1)
+	m.vars.Add(durationName, int64(time.Since(start)))
+	if err != nil {
+		m.vars.Add(METRIC_ERRORS, 1)
+	}
+}
+
+// EnableMetrics enables manager metrics. If name is not empty,
+// metrics are published with expvar under this name.
+// Expired sessions are counted if provider implements ExpiryNotifier interface.
+func (manager *Manager) EnableMetrics(name string) (*Metrics, error) {
+	if name != "" && expvar.Get(name) != nil {
+		return nil, errors.New("session: expvar " + name + " is already published")
+	}
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	m := newMetrics()
+	if name != "" {
+		expvar.Publish(name, m.vars)
+	}
+	manager.metrics = m
+	if notifier, ok := manager.provider.(ExpiryNotifier); ok {
+		notifier.SetExpiredHook(manager.sessionExpired)
+	}
+	return m, nil
+}
+
+// Metrics returns manager metrics, nil if metrics are not enabled.
+func (manager *Manager) Metrics() *Metrics {
+	return manager.metrics
+}
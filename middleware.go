@@ -0,0 +1,137 @@
+package session
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// DEFAULT_COOKIE_NAME is a name of the session cookie if MiddlewareConfig.Cookie.Name is empty.
+const DEFAULT_COOKIE_NAME = "sid"
+
+// MiddlewareConfig holds parameters of Middleware().
+type MiddlewareConfig struct {
+	//Cookie is a template of the session cookie, its Value is the session ID.
+	//Name is DEFAULT_COOKIE_NAME and Path is "/" if empty. Not used if Transport is set.
+	Cookie http.Cookie
+
+	//Transport reads and sends session ID, e.g. HeaderTransport or BearerTransport,
+	//CookieTransport with Cookie if nil.
+	Transport SIDTransport
+
+	//ErrorHandler writes response if the session can not be started, e.g. storage is down,
+	//503 Service Unavailable is written if nil.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// Middleware returns HTTP middleware starting session of the request cookie, or of MiddlewareConfig.Transport,
+// with SessionStartHTTP() and putting it into the request context, handlers get it with FromContext():
+//
+//	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+//		sess, _ := session.FromContext(r.Context())
+//		sess.Set("visited", true)
+//	})
+//	http.ListenAndServe(":8080", SessManager.Middleware(session.MiddlewareConfig{})(mux))
+//
+// A new session is started and its ID is sent to the client if the request has no session ID or its session
+// is expired, not found or rejected. The session is a RequestSession, its values are written
+// with one Flush() before the response header is written and it is closed when the handler returns.
+// Session ID is sent after the values are written, so client-side sessions of the cookie provider,
+// whose ID changes on Flush(), reach the client. Values set after the handler starts writing
+// response are written when it returns, they do not change the session ID sent.
+func (manager *Manager) Middleware(cfg MiddlewareConfig) func(http.Handler) http.Handler {
+	if cfg.Transport == nil {
+		cfg.Transport = CookieTransport{Cookie: cfg.Cookie}
+	}
+	if cfg.ErrorHandler == nil {
+		cfg.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sid := cfg.Transport.ReadSID(r)
+			sess, err := manager.startRequestSession(r, sid)
+			if err != nil {
+				manager.middlewareLogger().Error("session start failed", LOG_KEY_OPERATION, "Middleware", LOG_KEY_ERROR, err)
+				cfg.ErrorHandler(w, r, err)
+				return
+			}
+			started_sid := sess.SessionID()
+			req_sess := newRequestSession(sess, manager.clock)
+			var done_err error
+			sid_w := &sidWriter{ResponseWriter: w}
+			sid_w.write = func() {
+				//session ID of the cookie provider changes on Flush()
+				done_err = req_sess.Done()
+				if cur_sid := sess.SessionID(); cur_sid != sid {
+					cfg.Transport.WriteSID(w, r, cur_sid)
+				}
+			}
+			defer func() {
+				sid_w.writeSID() //handler has not written response
+				if err := errors.Join(done_err, req_sess.Done(), manager.SessionClose(started_sid)); err != nil {
+					manager.middlewareLogger().Error("session close failed", LOG_KEY_OPERATION, "Middleware", LOG_KEY_SID, started_sid, LOG_KEY_ERROR, err)
+				}
+			}()
+			next.ServeHTTP(sid_w, r.WithContext(NewContext(r.Context(), req_sess)))
+		})
+	}
+}
+
+// sidWriter writes session ID before the response header is written.
+type sidWriter struct {
+	http.ResponseWriter
+	once  sync.Once
+	write func() //flushes session and writes its ID
+}
+
+// writeSID flushes session and writes its ID once.
+func (w *sidWriter) writeSID() {
+	w.once.Do(w.write)
+}
+
+func (w *sidWriter) WriteHeader(statusCode int) {
+	w.writeSID()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *sidWriter) Write(b []byte) (int, error) {
+	w.writeSID()
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher.
+func (w *sidWriter) Flush() {
+	w.writeSID()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the original writer for http.ResponseController.
+func (w *sidWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// startRequestSession starts session of the request,
+// a new session is started if the session is expired, not found or rejected.
+func (manager *Manager) startRequestSession(r *http.Request, sid string) (Session, error) {
+	sess, err := manager.SessionStartHTTP(r, sid)
+	if sid != "" && (errors.Is(err, ErrSessionExpired) ||
+		errors.Is(err, ErrSessionNotFound) ||
+		errors.Is(err, ErrInvalidSessionID) ||
+		errors.Is(err, ErrFingerprintMismatch)) {
+		return manager.SessionStartHTTP(r, "")
+	}
+	return sess, err
+}
+
+// middlewareLogger returns logger set with SetLogger() or the default one.
+func (manager *Manager) middlewareLogger() *slog.Logger {
+	if manager.logger != nil {
+		return manager.logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,134 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MigrateOptions holds options of Migrate().
+type MigrateOptions struct {
+	PageSize      int                         //sessions listed at once, LIST_PAGE_SIZE if 0
+	DestroySource bool                        //migrated sessions are destroyed in source provider
+	OnSession     func(sid string, err error) //called for every session, e.g. for progress output
+}
+
+// MigrateResult holds Migrate() counters.
+type MigrateResult struct {
+	Migrated int //copied sessions
+	Skipped  int //sessions expired in source provider or removed while migrating
+	Failed   int //sessions not copied because of errors
+}
+
+// Migrate copies all sessions of src provider to dst provider with the same session IDs,
+// so users are not logged out when storage is changed, e.g. from sqlite to redis.
+// Both providers must be initialized, src must implement AdminProvider.
+// Session values are copied with Session.Snapshot() and Session.Restore(), values set with Session.SetWithTTL()
+// keep their expiration time. Values of a session existing in dst are replaced.
+// Creation and access times are not copied, migrated sessions are created at the moment.
+// Sessions are read with SessionPeek() if src implements PeekProvider, so their access time is not updated.
+// Expired sessions are skipped. Migration continues on errors, all errors are returned joined.
+func Migrate(src, dst Provider, opts MigrateOptions) (MigrateResult, error) {
+	var res MigrateResult
+	adm_pder, ok := src.(AdminProvider)
+	if !ok {
+		return res, ErrNotAdminProvider
+	}
+	page_size := opts.PageSize
+	if page_size <= 0 {
+		page_size = LIST_PAGE_SIZE
+	}
+
+	//all IDs are listed first as destroying sessions shifts pages
+	sids := make([]string, 0)
+	for offset := 0; ; offset += page_size {
+		list, err := adm_pder.SessionList(offset, page_size)
+		if err != nil {
+			return res, err
+		}
+		for _, meta := range list {
+			sids = append(sids, meta.ID)
+		}
+		if len(list) < page_size {
+			break
+		}
+	}
+
+	var errs []error
+	for _, sid := range sids {
+		err := migrateSession(src, dst, sid)
+		switch {
+		case errors.Is(err, ErrSessionExpired), errors.Is(err, ErrSessionNotFound):
+			res.Skipped++
+		case err != nil:
+			res.Failed++
+			errs = append(errs, fmt.Errorf("session %s: %w", sid, err))
+		default:
+			res.Migrated++
+			if opts.DestroySource {
+				if err = src.SessionDestroy(sid); err != nil {
+					errs = append(errs, fmt.Errorf("session %s: %w", sid, err))
+				}
+			}
+		}
+		if opts.OnSession != nil {
+			opts.OnSession(sid, err)
+		}
+	}
+	return res, errors.Join(errs...)
+}
+
+// migrateSession copies session values from src to dst.
+func migrateSession(src, dst Provider, sid string) error {
+	src_sess, close_src, err := readSession(src, sid)
+	if err != nil {
+		return err
+	}
+	defer close_src()
+	values, err := sessionValues(src_sess)
+	if err != nil {
+		return err
+	}
+	return replaceValues(dst, sid, values)
+}
+
+// sessionValues returns all session values as Session.Snapshot() does, so ExpiringValue keeps its TTL,
+// sensitive values are kept encrypted as SensitiveValue.
+func sessionValues(sess Session) (map[string]interface{}, error) {
+	values, err := sess.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := sess.(SensitiveSession); !ok {
+		return values, nil
+	}
+	sensitive, err := sensitiveValues(sess)
+	if err != nil {
+		return nil, err
+	}
+	for key, sv := range sensitive {
+		if ev, ok := values[key].(ExpiringValue); ok {
+			ev.Value = sv
+			values[key] = ev
+			continue
+		}
+		values[key] = sv
+	}
+	return values, nil
+}
+
+// replaceValues replaces values of session sid of provider pder with Session.Restore(),
+// the session is created if missing.
+func replaceValues(pder Provider, sid string, values map[string]interface{}) error {
+	sess, err := pder.SessionRead(sid)
+	if errors.Is(err, ErrSessionExpired) || errors.Is(err, ErrSessionNotFound) {
+		sess, err = pder.SessionInit(sid)
+	}
+	if err != nil {
+		return err
+	}
+	defer pder.SessionClose(sid)
+	if err := sess.Restore(values); err != nil {
+		return err
+	}
+	return sess.Flush()
+}
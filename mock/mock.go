@@ -0,0 +1,383 @@
+// Package mock contains a scriptable in-memory session provider for unit tests
+// of applications using sessions, no redis server or database file is needed.
+//
+// Any Provider or Session method can be made to fail with an error or to take longer,
+// all calls are recorded:
+//
+//	pder := mock.NewProvider()
+//	session.Register("mock", pder)
+//	SessManager, _ := session.NewManager("mock", 0, 0, "")
+//	pder.FailWith("Flush", errors.New("storage is down"))
+//	pder.SetLatency("SessionRead", 100*time.Millisecond)
+//	//run application handler
+//	if pder.CallCount("Flush") != 1 { ... }
+//
+// Methods are named as they are in session.Provider and session.Session interfaces,
+// e.g. "SessionRead", "Flush", "Set". Session methods without error result,
+// e.g. GetString(), are recorded and delayed but can not fail.
+// Session values are kept in memory, flushed values are shared by all sessions
+// read with the same ID, unflushed ones are lost on SessionClose().
+package mock
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/dronm/session"
+)
+
+const PROVIDER = "mock"
+
+// ALL_METHODS is a method name matching every method in FailWith(), FailTimes() and SetLatency().
+const ALL_METHODS = "*"
+
+// SESS_ID_LEN is a length of session ID generated by session.Manager.
+const SESS_ID_LEN = 36
+
+// Call is a recorded call of a Provider or Session method.
+type Call struct {
+	Method string        //method name, e.g. "SessionRead" or "Flush"
+	SID    string        //session ID, empty for calls not related to a session
+	Args   []interface{} //method arguments except session ID
+	Err    error         //scripted error returned, nil if the call was not failed
+	Time   time.Time     //call start time
+}
+
+// failure is an error returned by a method, times calls, every call if times is 0.
+type failure struct {
+	err   error
+	times int
+}
+
+// record is a flushed session.
+type record struct {
+	value        map[string]interface{}
+	timeCreated  time.Time
+	timeAccessed time.Time
+	expiresAt    time.Time //set by Session.SetExpiry(), zero if not set
+	rates        session.RateCounters
+}
+
+// Provider is a scriptable session provider, create it with NewProvider().
+type Provider struct {
+	mx          sync.Mutex
+	records     map[string]*record
+	maxLifeTime int64
+	maxIdleTime int64
+	strict      bool          //unknown sessions are not created on read
+	clock       session.Clock //see SetClock()
+
+	//script
+	failures map[string]*failure
+	latency  map[string]time.Duration
+	calls    []Call
+}
+
+// NewProvider returns an empty provider, it is registered with session.Register().
+func NewProvider() *Provider {
+	return &Provider{
+		records:  make(map[string]*record),
+		failures: make(map[string]*failure),
+		latency:  make(map[string]time.Duration),
+		clock:    session.SystemClock{},
+	}
+}
+
+// FailWith makes every call of method return err, nil err removes the failure.
+func (pder *Provider) FailWith(method string, err error) {
+	pder.FailTimes(method, err, 0)
+}
+
+// FailTimes makes next n calls of method return err, every call if n is 0.
+func (pder *Provider) FailTimes(method string, err error, n int) {
+	pder.mx.Lock()
+	defer pder.mx.Unlock()
+	if err == nil {
+		delete(pder.failures, method)
+		return
+	}
+	pder.failures[method] = &failure{err: err, times: n}
+}
+
+// SetLatency delays every call of method by d, 0 removes the delay.
+// Delays of a method and of ALL_METHODS are summed up.
+func (pder *Provider) SetLatency(method string, d time.Duration) {
+	pder.mx.Lock()
+	defer pder.mx.Unlock()
+	if d <= 0 {
+		delete(pder.latency, method)
+		return
+	}
+	pder.latency[method] = d
+}
+
+// Calls returns recorded calls in order they were made.
+func (pder *Provider) Calls() []Call {
+	pder.mx.Lock()
+	defer pder.mx.Unlock()
+	return append([]Call(nil), pder.calls...)
+}
+
+// CallCount returns number of recorded calls of method, of all methods for ALL_METHODS.
+func (pder *Provider) CallCount(method string) int {
+	pder.mx.Lock()
+	defer pder.mx.Unlock()
+	if method == ALL_METHODS {
+		return len(pder.calls)
+	}
+	cnt := 0
+	for _, c := range pder.calls {
+		if c.Method == method {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// ResetCalls clears recorded calls.
+func (pder *Provider) ResetCalls() {
+	pder.mx.Lock()
+	defer pder.mx.Unlock()
+	pder.calls = nil
+}
+
+// Reset removes failures, latencies, recorded calls and all sessions.
+func (pder *Provider) Reset() {
+	pder.mx.Lock()
+	defer pder.mx.Unlock()
+	pder.records = make(map[string]*record)
+	pder.failures = make(map[string]*failure)
+	pder.latency = make(map[string]time.Duration)
+	pder.calls = nil
+	pder.strict = false
+	pder.clock = session.SystemClock{}
+}
+
+// Stored returns a copy of flushed values of session sid, false if there is no such session.
+func (pder *Provider) Stored(sid string) (map[string]interface{}, bool) {
+	pder.mx.Lock()
+	defer pder.mx.Unlock()
+	rec, ok := pder.records[sid]
+	if !ok {
+		return nil, false
+	}
+	return copyValues(rec.value), true
+}
+
+// call records a call of method, waits for its latency and returns its scripted error.
+func (pder *Provider) call(method, sid string, args ...interface{}) error {
+	pder.mx.Lock()
+	c := Call{Method: method, SID: sid, Args: args, Time: pder.clock.Now()}
+	for _, m := range []string{method, ALL_METHODS} {
+		if f, ok := pder.failures[m]; ok {
+			c.Err = f.err
+			if f.times > 0 {
+				if f.times--; f.times == 0 {
+					delete(pder.failures, m)
+				}
+			}
+			break
+		}
+	}
+	delay := pder.latency[method] + pder.latency[ALL_METHODS]
+	pder.calls = append(pder.calls, c)
+	pder.mx.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return c.Err
+}
+
+// InitProvider does nothing, the provider has no parameters.
+func (pder *Provider) InitProvider(provParams []interface{}) error {
+	return pder.call("InitProvider", "", provParams)
+}
+
+// CloseProvider does nothing, sessions are kept till Reset().
+func (pder *Provider) CloseProvider() error {
+	return pder.call("CloseProvider", "")
+}
+
+// SessionInit creates a new session.
+func (pder *Provider) SessionInit(sid string) (session.Session, error) {
+	if err := pder.call("SessionInit", sid); err != nil {
+		return nil, err
+	}
+	now := pder.now()
+	pder.mx.Lock()
+	pder.records[sid] = &record{value: make(map[string]interface{}), timeCreated: now, timeAccessed: now}
+	pder.mx.Unlock()
+	return pder.newSession(sid, make(map[string]interface{}), now, now, time.Time{}), nil
+}
+
+// SessionRead returns session with flushed values, a new session is created if there is no such session,
+// session.ErrSessionNotFound is returned in strict mode then.
+// Expired session is destroyed, session.ErrSessionExpired is returned then.
+func (pder *Provider) SessionRead(sid string) (session.Session, error) {
+	if err := pder.call("SessionRead", sid); err != nil {
+		return nil, err
+	}
+	pder.mx.Lock()
+	rec, ok := pder.records[sid]
+	if !ok {
+		strict := pder.strict
+		pder.mx.Unlock()
+		if strict {
+			return nil, session.ErrSessionNotFound
+		}
+		return pder.SessionInit(sid)
+	}
+	now := pder.clock.Now()
+	if session.IsExpiredAt(now, rec.timeCreated, rec.timeAccessed, rec.expiresAt, pder.maxLifeTime, pder.maxIdleTime) {
+		delete(pder.records, sid)
+		pder.mx.Unlock()
+		return nil, session.ErrSessionExpired
+	}
+	rec.timeAccessed = now
+	sess := pder.newSession(sid, copyValues(rec.value), rec.timeCreated, rec.timeAccessed, rec.expiresAt)
+	pder.mx.Unlock()
+	return sess, nil
+}
+
+// SessionDestroy removes session.
+func (pder *Provider) SessionDestroy(sid string) error {
+	if err := pder.call("SessionDestroy", sid); err != nil {
+		return err
+	}
+	pder.mx.Lock()
+	delete(pder.records, sid)
+	pder.mx.Unlock()
+	return nil
+}
+
+// SessionClose does nothing, unflushed values are lost.
+func (pder *Provider) SessionClose(sid string) error {
+	return pder.call("SessionClose", sid)
+}
+
+// SessionGC removes expired sessions, a failure set for "SessionGC" is counted in GCReport.Errors.
+func (pder *Provider) SessionGC(l io.Writer, logLev session.LogLevel) session.GCReport {
+	var report session.GCReport
+	if err := pder.call("SessionGC", ""); err != nil {
+		report.Errors++
+		return report
+	}
+	now := pder.now()
+	pder.mx.Lock()
+	defer pder.mx.Unlock()
+	for sid, rec := range pder.records {
+		report.Scanned++
+		if !rec.expiresAt.IsZero() {
+			if !rec.expiresAt.After(now) {
+				report.DeletedExpiry++
+				delete(pder.records, sid)
+			}
+		} else if pder.maxIdleTime > 0 && !rec.timeAccessed.Add(time.Duration(pder.maxIdleTime)*time.Second).After(now) {
+			report.DeletedIdle++
+			delete(pder.records, sid)
+		} else if pder.maxLifeTime > 0 && !rec.timeCreated.Add(time.Duration(pder.maxLifeTime)*time.Second).After(now) {
+			report.DeletedLifetime++
+			delete(pder.records, sid)
+		}
+	}
+	return report
+}
+
+// DestroyAllSessions removes all sessions.
+func (pder *Provider) DestroyAllSessions(l io.Writer, logLev session.LogLevel) {
+	if err := pder.call("DestroyAllSessions", ""); err != nil {
+		return
+	}
+	pder.mx.Lock()
+	pder.records = make(map[string]*record)
+	pder.mx.Unlock()
+}
+
+// Ping returns a failure set for "Ping", nil otherwise.
+func (pder *Provider) Ping(ctx context.Context) error {
+	return pder.call("Ping", "")
+}
+
+// SetClock implements session.ClockSetter, session times and expiration are of the clock.
+func (pder *Provider) SetClock(clock session.Clock) {
+	if clock == nil {
+		clock = session.SystemClock{}
+	}
+	pder.mx.Lock()
+	defer pder.mx.Unlock()
+	pder.clock = clock
+}
+
+// now returns current time of the clock.
+func (pder *Provider) now() time.Time {
+	return pder.getClock().Now()
+}
+
+// getClock returns clock set with SetClock().
+func (pder *Provider) getClock() session.Clock {
+	pder.mx.Lock()
+	defer pder.mx.Unlock()
+	return pder.clock
+}
+
+// SetStrictMode implements session.StrictModeSetter.
+func (pder *Provider) SetStrictMode(strict bool) error {
+	pder.mx.Lock()
+	pder.strict = strict
+	pder.mx.Unlock()
+	return nil
+}
+
+func (pder *Provider) GetSessionIDLen() int {
+	return SESS_ID_LEN
+}
+
+func (pder *Provider) SetMaxLifeTime(maxLifeTime int64) {
+	pder.mx.Lock()
+	pder.maxLifeTime = maxLifeTime
+	pder.mx.Unlock()
+}
+
+func (pder *Provider) GetMaxLifeTime() int64 {
+	pder.mx.Lock()
+	defer pder.mx.Unlock()
+	return pder.maxLifeTime
+}
+
+func (pder *Provider) SetMaxIdleTime(maxIdleTime int64) {
+	pder.mx.Lock()
+	pder.maxIdleTime = maxIdleTime
+	pder.mx.Unlock()
+}
+
+func (pder *Provider) GetMaxIdleTime() int64 {
+	pder.mx.Lock()
+	defer pder.mx.Unlock()
+	return pder.maxIdleTime
+}
+
+// store writes session values, st.mx must be locked.
+func (pder *Provider) store(st *Session) {
+	pder.mx.Lock()
+	defer pder.mx.Unlock()
+	rec, ok := pder.records[st.sid]
+	if !ok {
+		rec = &record{timeCreated: st.timeCreated}
+		pder.records[st.sid] = rec
+	}
+	rec.value = copyValues(st.value)
+	rec.timeAccessed = st.timeAccessed
+	rec.expiresAt = st.expiresAt
+}
+
+// copyValues returns a shallow copy of values, values need not be registered with gob.
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		c[k] = v
+	}
+	return c
+}
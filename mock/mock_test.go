@@ -0,0 +1,737 @@
+// testing functions for session/mock.
+package mock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dronm/session"
+	"github.com/dronm/session/testkit"
+)
+
+var testProvider = NewProvider()
+
+func init() {
+	session.Register(PROVIDER, testProvider)
+}
+
+func NewManager(t *testing.T, maxLifeTime, maxIdleTime int64) *session.Manager {
+	testProvider.Reset()
+	manager, err := session.NewManager(PROVIDER, maxLifeTime, maxIdleTime, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	return manager
+}
+
+// TestFailures checks scripted errors returned by provider and session methods.
+func TestFailures(t *testing.T) {
+	SessManager := NewManager(t, 0, 0)
+	errDown := errors.New("storage is down")
+
+	testProvider.FailTimes("SessionInit", errDown, 1)
+	if _, err := SessManager.SessionStart(""); !errors.Is(err, errDown) {
+		t.Fatalf("SessionStart() wanted scripted error, got %v", err)
+	}
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	testProvider.FailWith("Flush", errDown)
+	if err := currentSession.Put("key", "value"); !errors.Is(err, errDown) {
+		t.Fatalf("Put() wanted scripted error, got %v", err)
+	}
+	if _, ok := testProvider.Stored(sid); !ok {
+		t.Fatalf("Stored() session %s not found", sid)
+	}
+	if stored, _ := testProvider.Stored(sid); len(stored) != 0 {
+		t.Fatalf("failed Flush() stored values: %v", stored)
+	}
+	testProvider.FailWith("Flush", nil)
+	if err := currentSession.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if stored, _ := testProvider.Stored(sid); stored["key"] != "value" {
+		t.Fatalf("Flush() did not store values: %v", stored)
+	}
+
+	testProvider.FailWith(ALL_METHODS, errDown)
+	if err := SessManager.HealthCheck(context.Background()); !errors.Is(err, errDown) {
+		t.Fatalf("HealthCheck() wanted scripted error, got %v", err)
+	}
+	if report := SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_ERROR); report.Errors != 1 {
+		t.Fatalf("SessionGC() wanted 1 error, got %d", report.Errors)
+	}
+	//provider not implementing ProviderV2 is adapted
+	if _, err := SessManager.CollectGarbage(); !errors.Is(err, session.ErrGCFailed) {
+		t.Fatalf("CollectGarbage() wanted ErrGCFailed, got %v", err)
+	}
+	testProvider.FailWith(ALL_METHODS, nil)
+	if _, err := SessManager.CollectGarbage(); err != nil {
+		t.Fatalf("CollectGarbage() failed: %v", err)
+	}
+}
+
+// TestCalls checks call recording and latency.
+func TestCalls(t *testing.T) {
+	SessManager := NewManager(t, 0, 0)
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	testProvider.ResetCalls()
+
+	testProvider.SetLatency("SessionRead", 50*time.Millisecond)
+	start := time.Now()
+	if _, err := SessManager.SessionStart(sid); err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("SessionStart() wanted latency, took %v", d)
+	}
+	currentSession.Set("key", 1)
+	currentSession.GetInt("key")
+
+	calls := testProvider.Calls()
+	if len(calls) != 3 {
+		t.Fatalf("Calls() wanted 3 calls, got %v", calls)
+	}
+	if calls[0].Method != "SessionRead" || calls[0].SID != sid {
+		t.Fatalf("wanted SessionRead of %s, got %+v", sid, calls[0])
+	}
+	if calls[1].Method != "Set" || calls[1].Args[0] != "key" || calls[1].Args[1] != 1 {
+		t.Fatalf("wanted Set(key, 1), got %+v", calls[1])
+	}
+	if n := testProvider.CallCount("GetInt"); n != 1 {
+		t.Fatalf("CallCount() wanted 1, got %d", n)
+	}
+}
+
+// TestConformance runs testkit conformance suite.
+func TestConformance(t *testing.T) {
+	testkit.Run(t, func(t *testing.T, maxLifeTime, maxIdleTime int64) *session.Manager {
+		return NewManager(t, maxLifeTime, maxIdleTime)
+	})
+}
+
+// TestMiddleware checks that middleware puts session of the request cookie into the request context.
+func TestMiddleware(t *testing.T) {
+	SessManager := NewManager(t, 0, 0)
+	handler := SessManager.Middleware(session.MiddlewareConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess, ok := session.FromContext(r.Context())
+		if !ok {
+			t.Fatal("FromContext() returned no session")
+		}
+		if err := sess.Set("visits", sess.GetInt("visits")+1); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != session.DEFAULT_COOKIE_NAME || cookies[0].Value == "" {
+		t.Fatalf("session cookie is not set: %v", cookies)
+	}
+	sid := cookies[0].Value
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if len(w.Result().Cookies()) != 0 {
+		t.Fatal("cookie of existing session is set again")
+	}
+	sess, err := SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	defer SessManager.SessionClose(sid)
+	if got := sess.GetInt("visits"); got != 2 {
+		t.Fatalf("visits = %d, wanted 2 flushed by middleware", got)
+	}
+
+	testProvider.FailTimes("SessionInit", errors.New("storage is down"), 1)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, wanted 503 if session can not be started", w.Code)
+	}
+}
+
+// TestMiddlewareTransport checks header and bearer token session ID transports.
+func TestMiddlewareTransport(t *testing.T) {
+	SessManager := NewManager(t, 0, 0)
+	var got_sid string
+	handler := SessManager.Middleware(session.MiddlewareConfig{
+		Transport: session.MultiTransport(session.HeaderTransport{}, session.BearerTransport{}),
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess, _ := session.FromContext(r.Context())
+		got_sid = sess.SessionID()
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	sid := w.Header().Get(session.DEFAULT_SID_HEADER)
+	if sid == "" || sid != got_sid {
+		t.Fatalf("response header %s = %q, wanted session ID %q", session.DEFAULT_SID_HEADER, sid, got_sid)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+sid)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if got_sid != sid || w.Header().Get(session.DEFAULT_SID_HEADER) != "" {
+		t.Fatalf("bearer token session %q is not used, wanted %q", got_sid, sid)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(session.DEFAULT_SID_HEADER, sid)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if got_sid != sid {
+		t.Fatalf("header session %q is not used, wanted %q", got_sid, sid)
+	}
+}
+
+// TestMiddlewareHardenedCookie checks hardened cookie attributes and encrypted session ID.
+func TestMiddlewareHardenedCookie(t *testing.T) {
+	SessManager := NewManager(t, 0, 0)
+	keys, err := session.NewKeyRing([]byte("cookie key"))
+	if err != nil {
+		t.Fatalf("NewKeyRing: %v", err)
+	}
+	cookie := session.HardenedCookie(http.SameSiteNoneMode)
+	cookie.Domain = "example.com" //not allowed with __Host- prefix
+	var got_sid string
+	handler := SessManager.Middleware(session.MiddlewareConfig{
+		Transport: session.CookieTransport{Cookie: cookie, KeyRing: keys},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess, _ := session.FromContext(r.Context())
+		got_sid = sess.SessionID()
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, wanted 1", len(cookies))
+	}
+	c := cookies[0]
+	if c.Name != "__Host-sid" || !c.Secure || !c.HttpOnly || c.Path != "/" || c.Domain != "" || c.SameSite != http.SameSiteNoneMode {
+		t.Fatalf("cookie %s is not hardened", c.String())
+	}
+	if c.Value == "" || c.Value == got_sid {
+		t.Fatalf("cookie value %q is not encrypted session ID %q", c.Value, got_sid)
+	}
+	sid := got_sid
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if got_sid != sid || len(w.Result().Cookies()) != 0 {
+		t.Fatalf("encrypted cookie session %q is not used, wanted %q", got_sid, sid)
+	}
+
+	//raw session ID is not accepted
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: c.Name, Value: sid})
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if got_sid == sid {
+		t.Fatal("raw session ID in the cookie is accepted")
+	}
+}
+
+// TestSessionIDValidation checks generated session IDs and rejection of malformed ones.
+func TestSessionIDValidation(t *testing.T) {
+	SessManager := NewManager(t, 0, 0)
+	sess, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart: %v", err)
+	}
+	sid := sess.SessionID()
+	SessManager.SessionClose(sid)
+	if len(sid) != SESS_ID_LEN {
+		t.Fatalf("generated session ID %q length is %d, wanted %d", sid, len(sid), SESS_ID_LEN)
+	}
+	if err := session.ValidateSessionID(testProvider, sid); err != nil {
+		t.Fatalf("generated session ID %q is invalid: %v", sid, err)
+	}
+	for _, bad_sid := range []string{"<script>", "a b", "../etc", sid + "0"} {
+		if _, err := SessManager.SessionStart(bad_sid); !errors.Is(err, session.ErrInvalidSessionID) {
+			t.Fatalf("SessionStart(%q) error is %v, wanted %v", bad_sid, err, session.ErrInvalidSessionID)
+		}
+	}
+}
+
+// TestSIDValidator checks that IDs rejected by application validator do not reach the provider.
+func TestSIDValidator(t *testing.T) {
+	SessManager := NewManager(t, 0, 0)
+	SessManager.SetSIDValidator(func(sid string) error {
+		if len(sid) != SESS_ID_LEN {
+			return errors.New("wrong length")
+		}
+		return nil
+	})
+	defer SessManager.SetSIDValidator(nil)
+
+	sess, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart: %v", err)
+	}
+	sid := sess.SessionID()
+	SessManager.SessionClose(sid)
+	if _, err := SessManager.SessionStart(sid); err != nil {
+		t.Fatalf("SessionStart(%q): %v", sid, err)
+	}
+	SessManager.SessionClose(sid)
+
+	testProvider.ResetCalls()
+	if _, err := SessManager.SessionStart("attacker-chosen-id"); !errors.Is(err, session.ErrInvalidSessionID) {
+		t.Fatalf("SessionStart error is %v, wanted %v", err, session.ErrInvalidSessionID)
+	}
+	if _, ok := testProvider.Stored("attacker-chosen-id"); ok {
+		t.Fatal("session of rejected ID is created")
+	}
+	if n := testProvider.CallCount("SessionRead"); n != 0 {
+		t.Fatalf("provider SessionRead() is called %d times for rejected ID", n)
+	}
+}
+
+// TestStrictMode checks that middleware replaces unknown session ID in strict mode.
+func TestStrictMode(t *testing.T) {
+	SessManager := NewManager(t, 0, 0)
+	if err := SessManager.SetStrictMode(true); err != nil {
+		t.Fatalf("SetStrictMode: %v", err)
+	}
+	const unknown_sid = "00000000-0000-0000-0000-000000000000"
+	if _, err := SessManager.SessionStart(unknown_sid); !errors.Is(err, session.ErrSessionNotFound) {
+		t.Fatalf("SessionStart error is %v, wanted %v", err, session.ErrSessionNotFound)
+	}
+
+	var got_sid string
+	handler := SessManager.Middleware(session.MiddlewareConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess, _ := session.FromContext(r.Context())
+		got_sid = sess.SessionID()
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: session.DEFAULT_COOKIE_NAME, Value: unknown_sid})
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if got_sid == "" || got_sid == unknown_sid {
+		t.Fatalf("middleware session ID is %q, wanted a new one", got_sid)
+	}
+	if _, ok := testProvider.Stored(unknown_sid); ok {
+		t.Fatal("session of unknown ID is created")
+	}
+}
+
+// TestSensitiveValue checks that sensitive value is stored encrypted and read decrypted.
+func TestSensitiveValue(t *testing.T) {
+	SessManager := NewManager(t, 0, 0)
+	sess, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if err := sess.SetSensitive("email", "user@example.com"); !errors.Is(err, session.ErrNoSensitiveKeys) {
+		t.Fatalf("SetSensitive() error without keys is %v, wanted %v", err, session.ErrNoSensitiveKeys)
+	}
+	if err := session.SetSensitiveKeys([]byte("sensitive key")); err != nil {
+		t.Fatalf("SetSensitiveKeys() failed: %v", err)
+	}
+	defer session.SetSensitiveKeys()
+	if err := sess.SetSensitive("email", "user@example.com"); err != nil {
+		t.Fatalf("SetSensitive() failed: %v", err)
+	}
+	if err := sess.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	sid := sess.SessionID()
+	SessManager.SessionClose(sid)
+
+	stored, _ := testProvider.Stored(sid)
+	sv, ok := stored["email"].(session.SensitiveValue)
+	if !ok {
+		t.Fatalf("stored value is %T, wanted session.SensitiveValue", stored["email"])
+	}
+	if bytes.Contains(sv.Sealed, []byte("user@example.com")) {
+		t.Fatal("stored value is not encrypted")
+	}
+
+	sess, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if v := sess.GetString("email"); v != "user@example.com" {
+		t.Fatalf("GetString() = %q, wanted decrypted value", v)
+	}
+	var v interface{}
+	if err := sess.Get("email", &v); err != nil || v != "user@example.com" {
+		t.Fatalf("Get() = %v, %v, wanted decrypted value", v, err)
+	}
+	if err := session.SetSensitiveKeys([]byte("another key")); err != nil {
+		t.Fatalf("SetSensitiveKeys() failed: %v", err)
+	}
+	if err := sess.Get("email", &v); !errors.Is(err, session.ErrKeyNotFound) {
+		t.Fatalf("Get() error with another key is %v, wanted %v", err, session.ErrKeyNotFound)
+	}
+}
+
+// TestEraseUserData checks export of user data, anonymization and destruction of user sessions.
+func TestEraseUserData(t *testing.T) {
+	SessManager := NewManager(t, 0, 0)
+	SessManager.SetTrash(time.Hour, nil)
+	if err := session.SetSensitiveKeys([]byte("sensitive key")); err != nil {
+		t.Fatalf("SetSensitiveKeys() failed: %v", err)
+	}
+	defer session.SetSensitiveKeys()
+	start := func(userID string) string {
+		sess, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		defer SessManager.SessionClose(sess.SessionID())
+		if err := sess.SetSensitive("email", userID+"@example.com"); err != nil {
+			t.Fatalf("SetSensitive() failed: %v", err)
+		}
+		if err := sess.Set("cart", "item"); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+		if err := SessManager.BindUser(sess, userID); err != nil {
+			t.Fatalf("BindUser() failed: %v", err)
+		}
+		return sess.SessionID()
+	}
+	sids := []string{start("user1"), start("user1")}
+	other_sid := start("user2")
+
+	data, err := SessManager.ExportUserData("user1")
+	if err != nil {
+		t.Fatalf("ExportUserData() failed: %v", err)
+	}
+	if len(data) != 2 || data[0].Values["email"] != "user1@example.com" || data[0].Values["cart"] != "item" {
+		t.Fatalf("ExportUserData() = %+v, wanted 2 sessions with decrypted values", data)
+	}
+
+	if n, err := SessManager.EraseUserData("user1", session.ERASE_ANONYMIZE); err != nil || n != 2 {
+		t.Fatalf("EraseUserData(ERASE_ANONYMIZE) = %d, %v, wanted 2 sessions", n, err)
+	}
+	for _, sid := range sids {
+		stored, ok := testProvider.Stored(sid)
+		if !ok || stored["cart"] != "item" || stored["email"] != nil || stored[session.USER_KEY] != nil {
+			t.Fatalf("anonymized session values are %v, wanted cart only", stored)
+		}
+	}
+	if data, err := SessManager.ExportUserData("user1"); err != nil || len(data) != 0 {
+		t.Fatalf("ExportUserData() of anonymized user = %+v, %v", data, err)
+	}
+
+	trashed_sid := start("user2")
+	if err := SessManager.SessionDestroy(trashed_sid); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+	if n, err := SessManager.EraseUserData("user2", session.ERASE_DESTROY); err != nil || n != 1 {
+		t.Fatalf("EraseUserData(ERASE_DESTROY) = %d, %v, wanted 1 session", n, err)
+	}
+	if _, ok := testProvider.Stored(other_sid); ok {
+		t.Fatal("erased session is not destroyed")
+	}
+	for _, sid := range []string{other_sid, trashed_sid} {
+		if err := SessManager.RestoreSession(sid); !errors.Is(err, session.ErrSessionNotFound) {
+			t.Fatalf("RestoreSession() of erased session error is %v, wanted %v", err, session.ErrSessionNotFound)
+		}
+	}
+}
+
+// TestManualClock checks expiration and GC driven by ManualClock without sleeping.
+func TestManualClock(t *testing.T) {
+	SessManager := NewManager(t, 0, 60)
+	clock := session.NewManualClock(time.Now())
+	SessManager.SetClock(clock)
+	defer SessManager.SetClock(nil)
+
+	sess, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := sess.SessionID()
+	SessManager.SessionClose(sid)
+
+	clock.Advance(30 * time.Second)
+	if _, err := SessManager.SessionStart(sid); err != nil {
+		t.Fatalf("SessionStart() before idle time failed: %v", err)
+	}
+	SessManager.SessionClose(sid)
+	clock.Advance(61 * time.Second)
+	if _, err := SessManager.SessionStart(sid); !errors.Is(err, session.ErrSessionExpired) {
+		t.Fatalf("SessionStart() after idle time error is %v, wanted %v", err, session.ErrSessionExpired)
+	}
+
+	sess, err = SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid = sess.SessionID()
+	SessManager.SessionClose(sid)
+	gc_done := make(chan session.GCReport, 1)
+	SessManager.OnGC(func(report session.GCReport) {
+		select {
+		case gc_done <- report:
+		default:
+		}
+	})
+	SessManager.SetGCInterval(time.Minute)
+	SessManager.StartGC(nil, session.LOG_LEVEL_ERROR)
+	defer SessManager.StopGC()
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(2 * time.Minute)
+	select {
+	case <-gc_done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("GC did not run after clock advance")
+	}
+	if _, ok := testProvider.Stored(sid); ok {
+		t.Fatal("expired session is not removed by GC")
+	}
+}
+
+// TestRunGCOnce checks one synchronous GC pass and its report.
+func TestRunGCOnce(t *testing.T) {
+	SessManager := NewManager(t, 0, 60)
+	clock := session.NewManualClock(time.Now())
+	SessManager.SetClock(clock)
+	defer SessManager.SetClock(nil)
+	var hook_report session.GCReport
+	SessManager.OnGC(func(report session.GCReport) { hook_report = report })
+
+	for i := 0; i < 2; i++ {
+		sess, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		SessManager.SessionClose(sess.SessionID())
+	}
+	if report, err := SessManager.RunGCOnce(context.Background()); err != nil || report.Deleted() != 0 {
+		t.Fatalf("RunGCOnce() = %+v, %v, wanted no removed sessions", report, err)
+	}
+	clock.Advance(61 * time.Second)
+	report, err := SessManager.RunGCOnce(context.Background())
+	if err != nil || report.DeletedIdle != 2 || report.Scanned != 2 {
+		t.Fatalf("RunGCOnce() = %+v, %v, wanted 2 idle sessions removed", report, err)
+	}
+	if hook_report.DeletedIdle != 2 {
+		t.Fatalf("OnGC() callback got %+v, wanted the report of RunGCOnce()", hook_report)
+	}
+
+	testProvider.FailTimes("SessionGC", errors.New("storage is down"), 1)
+	if report, err := SessManager.RunGCOnce(context.Background()); !errors.Is(err, session.ErrGCFailed) || report.Errors != 1 {
+		t.Fatalf("RunGCOnce() = %+v, %v, wanted %v", report, err, session.ErrGCFailed)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := SessManager.RunGCOnce(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("RunGCOnce() with cancelled context error is %v, wanted %v", err, context.Canceled)
+	}
+}
+
+// TestStopGCWaits checks StopGC() returns after a GC run in progress is finished.
+func TestStopGCWaits(t *testing.T) {
+	SessManager := NewManager(t, 0, 60)
+	clock := session.NewManualClock(time.Now())
+	SessManager.SetClock(clock)
+	defer SessManager.SetClock(nil)
+	gc_done := make(chan struct{})
+	SessManager.OnGC(func(report session.GCReport) { close(gc_done) })
+	SessManager.SetGCInterval(time.Minute)
+	testProvider.SetLatency("SessionGC", 100*time.Millisecond)
+	SessManager.StartGC(nil, session.LOG_LEVEL_ERROR)
+
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Minute)
+	for testProvider.CallCount("SessionGC") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	SessManager.StopGC()
+	select {
+	case <-gc_done:
+	default:
+		t.Fatal("StopGC() returned before GC run was finished")
+	}
+}
+
+// TestRestartGC checks that StartGC() runs one GC loop and RestartGC() applies a new interval.
+func TestRestartGC(t *testing.T) {
+	SessManager := NewManager(t, 0, 60)
+	clock := session.NewManualClock(time.Now())
+	SessManager.SetClock(clock)
+	defer SessManager.SetClock(nil)
+	SessManager.SetGCInterval(time.Minute)
+	var buf bytes.Buffer
+	SessManager.StartGC(&buf, session.LOG_LEVEL_WARN)
+	SessManager.StartGC(&buf, session.LOG_LEVEL_WARN)
+	defer SessManager.StopGC()
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := clock.Timers(); n != 1 {
+		t.Fatalf("%d GC loops are waiting after second StartGC(), wanted 1", n)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("already running")) {
+		t.Fatalf("second StartGC() logged %q, wanted a warning", buf.String())
+	}
+
+	SessManager.SetGCInterval(time.Hour)
+	SessManager.RestartGC(&buf, session.LOG_LEVEL_WARN)
+	for clock.Timers() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	//the timer of the stopped loop fires without a GC run
+	clock.Advance(time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	if n := testProvider.CallCount("SessionGC"); n != 0 {
+		t.Fatalf("SessionGC() called %d times with restarted interval of an hour, wanted 0", n)
+	}
+	clock.Advance(time.Hour)
+	for testProvider.CallCount("SessionGC") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestStartGCNotStarted checks that StartGC() without GC interval and kill time does not
+// prevent a later StartGC() with an interval.
+func TestStartGCNotStarted(t *testing.T) {
+	SessManager := NewManager(t, 0, 0)
+	clock := session.NewManualClock(time.Now())
+	SessManager.SetClock(clock)
+	defer SessManager.SetClock(nil)
+	var buf bytes.Buffer
+	SessManager.StartGC(&buf, session.LOG_LEVEL_WARN)
+	defer SessManager.StopGC()
+
+	SessManager.SetGCInterval(time.Minute)
+	SessManager.StartGC(&buf, session.LOG_LEVEL_WARN)
+	if bytes.Contains(buf.Bytes(), []byte("already running")) {
+		t.Fatalf("StartGC() logged %q after GC was not started", buf.String())
+	}
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Minute)
+	for testProvider.CallCount("SessionGC") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestRequestSession checks that changes of a request are kept in memory
+// and written by Done() with one SetMany() and one Flush().
+func TestRequestSession(t *testing.T) {
+	SessManager := NewManager(t, 0, 0)
+	sess, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := sess.SessionID()
+	defer SessManager.SessionClose(sid)
+	if err := sess.Put("old", "value"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	testProvider.ResetCalls()
+
+	reqSession := session.NewRequestSession(sess)
+	if err := reqSession.Set("a", "value a"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := reqSession.Put("b", int64(2)); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := reqSession.SetMany(map[string]interface{}{"c": true, "d": 1.5}); err != nil {
+		t.Fatalf("SetMany() failed: %v", err)
+	}
+	if err := reqSession.Delete("old"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if err := reqSession.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if reqSession.GetString("a") != "value a" || reqSession.GetInt("b") != 2 || !reqSession.GetBool("c") {
+		t.Fatal("pending values are not read from memory")
+	}
+	var old string
+	if err := reqSession.Get("old", &old); !errors.Is(err, session.ErrKeyNotFound) {
+		t.Fatalf("Get() of deleted value error is %v, wanted %v", err, session.ErrKeyNotFound)
+	}
+	if keys, err := reqSession.Keys(); err != nil || len(keys) != 4 || keys[0] != "a" || keys[3] != "d" {
+		t.Fatalf("Keys() = %v, %v, wanted [a b c d]", keys, err)
+	}
+	if n := testProvider.CallCount(ALL_METHODS); n != 1 {
+		t.Fatalf("session is called %d times before Done(), wanted Keys() only: %v", n, testProvider.Calls())
+	}
+	if !reqSession.Modified() {
+		t.Fatal("Modified() wanted true")
+	}
+
+	if err := reqSession.Done(); err != nil {
+		t.Fatalf("Done() failed: %v", err)
+	}
+	for method, want := range map[string]int{"SetMany": 1, "Delete": 1, "Flush": 1, "Set": 0, "Put": 0} {
+		if n := testProvider.CallCount(method); n != want {
+			t.Fatalf("Done() called %s() %d times, wanted %d", method, n, want)
+		}
+	}
+	stored, _ := testProvider.Stored(sid)
+	if len(stored) != 4 || stored["a"] != "value a" || stored["d"] != 1.5 {
+		t.Fatalf("stored values are %v", stored)
+	}
+	//not modified
+	testProvider.ResetCalls()
+	if err := reqSession.Done(); err != nil {
+		t.Fatalf("Done() failed: %v", err)
+	}
+	if n := testProvider.CallCount(ALL_METHODS); n != 0 {
+		t.Fatalf("Done() of not modified session made %d calls", n)
+	}
+}
+
+// TestSessionStartLocked checks that a session is closed when its lock is not acquired
+// and that lock time to live is set with SetLockTTL().
+func TestSessionStartLocked(t *testing.T) {
+	SessManager := NewManager(t, 0, 0)
+	testProvider.FailTimes("Lock", session.ErrLockTimeout, 1)
+	if _, err := SessManager.SessionStartLocked(""); !errors.Is(err, session.ErrLockTimeout) {
+		t.Fatalf("SessionStartLocked() wanted ErrLockTimeout, got %v", err)
+	}
+	if n := testProvider.CallCount("SessionClose"); n != 1 {
+		t.Fatalf("session not locked must be closed, SessionClose() called %d times", n)
+	}
+
+	currentSession, err := SessManager.SessionStartLocked("")
+	if err != nil {
+		t.Fatalf("SessionStartLocked() failed: %v", err)
+	}
+	if err := currentSession.Unlock(); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+
+	defer session.SetLockTTL(0)
+	session.SetLockTTL(5 * time.Minute)
+	if ttl := session.LockTTL(); ttl != 5*time.Minute {
+		t.Fatalf("LockTTL() wanted 5m, got %v", ttl)
+	}
+	session.SetLockTTL(0)
+	if ttl := session.LockTTL(); ttl != session.LOCK_TTL {
+		t.Fatalf("LockTTL() wanted LOCK_TTL after reset, got %v", ttl)
+	}
+}
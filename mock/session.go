@@ -0,0 +1,468 @@
+package mock
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dronm/session"
+)
+
+// Session is an in-memory session of Provider, every method call is recorded.
+type Session struct {
+	pder          *Provider
+	sid           string
+	mx            sync.RWMutex
+	timeCreated   time.Time
+	timeAccessed  time.Time
+	expiresAt     time.Time //set by SetExpiry()
+	value         map[string]interface{}
+	valueModified bool
+}
+
+func (pder *Provider) newSession(sid string, value map[string]interface{}, created, accessed, expiresAt time.Time) *Session {
+	return &Session{
+		pder:         pder,
+		sid:          sid,
+		value:        value,
+		timeCreated:  created,
+		timeAccessed: accessed,
+		expiresAt:    expiresAt,
+	}
+}
+
+// Set sets in-memory value.
+func (st *Session) Set(key string, value interface{}) error {
+	if err := st.pder.call("Set", st.sid, key, value); err != nil {
+		return err
+	}
+	st.set(key, value)
+	return nil
+}
+
+func (st *Session) set(key string, value interface{}) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value[key] = value
+	st.modified()
+}
+
+// Put sets value and flushes session.
+func (st *Session) Put(key string, value interface{}) error {
+	if err := st.pder.call("Put", st.sid, key, value); err != nil {
+		return err
+	}
+	st.set(key, value)
+	return st.Flush()
+}
+
+// SetMany sets in-memory values.
+func (st *Session) SetMany(values map[string]interface{}) error {
+	if err := st.pder.call("SetMany", st.sid, values); err != nil {
+		return err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	for key, value := range values {
+		st.value[key] = value
+	}
+	st.modified()
+	return nil
+}
+
+// GetMany assigns in-memory values to dest pointers, see session.GetMany().
+func (st *Session) GetMany(dest map[string]interface{}) error {
+	if err := st.pder.call("GetMany", st.sid, dest); err != nil {
+		return err
+	}
+	return session.GetMany(st, dest)
+}
+
+// SetWithTTL sets in-memory value expiring in ttl, see session.ExpiringValue.
+func (st *Session) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	if err := st.pder.call("SetWithTTL", st.sid, key, value, ttl); err != nil {
+		return err
+	}
+	st.set(key, session.NewExpiringValueAt(st.pder.now(), value, ttl))
+	return nil
+}
+
+// Snapshot returns a deep copy of in-memory values without expired ones, see session.SnapshotValuesAt().
+func (st *Session) Snapshot() (map[string]interface{}, error) {
+	if err := st.pder.call("Snapshot", st.sid); err != nil {
+		return nil, err
+	}
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return session.SnapshotValuesAt(st.pder.now(), st.value)
+}
+
+// Restore replaces in-memory values with a copy of snapshot.
+func (st *Session) Restore(snapshot map[string]interface{}) error {
+	if err := st.pder.call("Restore", st.sid, snapshot); err != nil {
+		return err
+	}
+	value, err := session.CopyValues(snapshot)
+	if err != nil {
+		return err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value = value
+	st.modified()
+	return nil
+}
+
+// Flush stores in-memory values in provider.
+func (st *Session) Flush() error {
+	if err := st.pder.call("Flush", st.sid); err != nil {
+		return err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if st.valueModified {
+		st.pder.store(st)
+		st.valueModified = false
+	}
+	return nil
+}
+
+// Get assigns in-memory value to val pointer.
+func (st *Session) Get(key string, val interface{}) error {
+	if err := st.pder.call("Get", st.sid, key); err != nil {
+		return err
+	}
+	store_val, ok := st.lookup(key)
+	if !ok {
+		return session.ErrKeyNotFound
+	}
+	val_type := reflect.TypeOf(val)
+	if val_type.Kind() != reflect.Ptr {
+		return session.ErrValMustBePtr
+	}
+	if !reflect.TypeOf(store_val).AssignableTo(val_type.Elem()) {
+		return session.ErrTypeMismatch
+	}
+	reflect.ValueOf(val).Elem().Set(reflect.ValueOf(store_val))
+	return nil
+}
+
+// GetStruct assigns in-memory value to dest converting it, see session.AssignValue().
+func (st *Session) GetStruct(key string, dest interface{}) error {
+	if err := st.pder.call("GetStruct", st.sid, key); err != nil {
+		return err
+	}
+	store_val, ok := st.lookup(key)
+	if !ok {
+		return session.ErrKeyNotFound
+	}
+	return session.AssignValue(store_val, dest)
+}
+
+func (st *Session) GetBool(key string) bool {
+	st.pder.call("GetBool", st.sid, key)
+	v, _ := st.lookup(key)
+	v_bool, _ := v.(bool)
+	return v_bool
+}
+
+func (st *Session) GetString(key string) string {
+	st.pder.call("GetString", st.sid, key)
+	v, _ := st.lookup(key)
+	switch v_str := v.(type) {
+	case string:
+		return v_str
+	case []byte:
+		return string(v_str)
+	}
+	return ""
+}
+
+func (st *Session) GetInt(key string) int64 {
+	st.pder.call("GetInt", st.sid, key)
+	v, _ := st.lookup(key)
+	switch v_i := v.(type) {
+	case int64:
+		return v_i
+	case int:
+		return int64(v_i)
+	}
+	return 0
+}
+
+func (st *Session) GetFloat(key string) float64 {
+	st.pder.call("GetFloat", st.sid, key)
+	v, _ := st.lookup(key)
+	switch v_f := v.(type) {
+	case float64:
+		return v_f
+	case float32:
+		return float64(v_f)
+	}
+	return 0
+}
+
+func (st *Session) GetDate(key string) time.Time {
+	st.pder.call("GetDate", st.sid, key)
+	v, _ := st.lookup(key)
+	v_t, _ := v.(time.Time)
+	return v_t
+}
+
+func (st *Session) GetBytes(key string) []byte {
+	st.pder.call("GetBytes", st.sid, key)
+	v, _ := st.lookup(key)
+	switch v_b := v.(type) {
+	case []byte:
+		return v_b
+	case string:
+		return []byte(v_b)
+	}
+	return nil
+}
+
+func (st *Session) GetStringSlice(key string) []string {
+	st.pder.call("GetStringSlice", st.sid, key)
+	v, _ := st.lookup(key)
+	v_s, _ := v.([]string)
+	return v_s
+}
+
+// GetOrSet assigns session value to dest, computing and storing a missing one, see session.GetOrSet().
+func (st *Session) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	if err := st.pder.call("GetOrSet", st.sid, key); err != nil {
+		return err
+	}
+	return session.GetOrSet(st, key, dest, compute)
+}
+
+// Delete deletes in-memory value.
+func (st *Session) Delete(key string) error {
+	if err := st.pder.call("Delete", st.sid, key); err != nil {
+		return err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if _, ok := st.value[key]; ok {
+		delete(st.value, key)
+		st.modified()
+	}
+	return nil
+}
+
+// Clear deletes all in-memory values.
+func (st *Session) Clear() error {
+	if err := st.pder.call("Clear", st.sid); err != nil {
+		return err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value = make(map[string]interface{})
+	st.modified()
+	return nil
+}
+
+// Increment adds delta to integer value in memory and in provider at once.
+func (st *Session) Increment(key string, delta int64) (int64, error) {
+	if err := st.pder.call("Increment", st.sid, key, delta); err != nil {
+		return 0, err
+	}
+	var new_val int64
+	now := st.pder.now()
+	err := st.update(func(value map[string]interface{}) error {
+		cur, _ := session.LookupValueAt(now, value, key)
+		var err error
+		if new_val, err = session.IncrementValue(cur, delta); err != nil {
+			return err
+		}
+		value[key] = new_val
+		return nil
+	})
+	return new_val, err
+}
+
+func (st *Session) Decrement(key string, delta int64) (int64, error) {
+	return st.Increment(key, -delta)
+}
+
+// CompareAndSwap sets newValue in memory and in provider at once if the stored value equals oldValue,
+// see session.Session.CompareAndSwap().
+func (st *Session) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
+	if err := st.pder.call("CompareAndSwap", st.sid, key, oldValue, newValue); err != nil {
+		return false, err
+	}
+	swapped := false
+	now := st.pder.now()
+	err := st.update(func(value map[string]interface{}) error {
+		if cur, _ := session.LookupValueAt(now, value, key); !session.EqualValue(cur, oldValue) {
+			return nil
+		}
+		if newValue == nil {
+			delete(value, key)
+		} else {
+			value[key] = newValue
+		}
+		swapped = true
+		return nil
+	})
+	return swapped, err
+}
+
+// update applies fn to stored values and copies the changed value to memory.
+func (st *Session) update(fn func(value map[string]interface{}) error) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	pder := st.pder
+	pder.mx.Lock()
+	defer pder.mx.Unlock()
+	rec, ok := pder.records[st.sid]
+	if !ok {
+		rec = &record{value: make(map[string]interface{}), timeCreated: st.timeCreated}
+		pder.records[st.sid] = rec
+	}
+	value := copyValues(rec.value)
+	if err := fn(value); err != nil {
+		return err
+	}
+	for key := range rec.value {
+		if _, ok := value[key]; !ok {
+			delete(st.value, key)
+		}
+	}
+	for key, v := range value {
+		if !reflect.DeepEqual(rec.value[key], v) {
+			st.value[key] = v
+		}
+	}
+	rec.value = value
+	return nil
+}
+
+// Bucket returns a view of the session with keys prefixed by name, see session.NewBucket().
+func (st *Session) Bucket(name string) session.Session {
+	return session.NewBucket(st, name)
+}
+
+// Keys returns sorted keys of in-memory values.
+func (st *Session) Keys() ([]string, error) {
+	if err := st.pder.call("Keys", st.sid); err != nil {
+		return nil, err
+	}
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	keys := make([]string, 0, len(st.value))
+	now := st.pder.now()
+	for key, v := range st.value {
+		if !session.ValueExpiredAt(now, v) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Len returns number of in-memory values.
+func (st *Session) Len() (int, error) {
+	keys, err := st.Keys()
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// SessionID is not recorded, session.Manager calls it internally.
+func (st *Session) SessionID() string {
+	return st.sid
+}
+
+func (st *Session) TimeCreated() time.Time {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return st.timeCreated
+}
+
+func (st *Session) TimeAccessed() time.Time {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return st.timeAccessed
+}
+
+// Lock does nothing but recording the call.
+func (st *Session) Lock() error {
+	return st.pder.call("Lock", st.sid)
+}
+
+// Unlock does nothing but recording the call.
+func (st *Session) Unlock() error {
+	return st.pder.call("Unlock", st.sid)
+}
+
+// SetExpiry sets session expiration time to now+d, d <= 0 restores defaults. Session must be flushed.
+func (st *Session) SetExpiry(d time.Duration) error {
+	if err := st.pder.call("SetExpiry", st.sid, d); err != nil {
+		return err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if d > 0 {
+		st.expiresAt = st.pder.now().Add(d)
+	} else {
+		st.expiresAt = time.Time{}
+	}
+	st.valueModified = true
+	return nil
+}
+
+// Touch updates session access time. Session must be flushed.
+func (st *Session) Touch() error {
+	if err := st.pder.call("Touch", st.sid); err != nil {
+		return err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.modified()
+	return nil
+}
+
+// Allow counts action in the current window of provider clock, see session.AllowRate().
+func (st *Session) Allow(action string, limit int, window time.Duration) (bool, error) {
+	if err := st.pder.call("Allow", st.sid, action, limit, window); err != nil {
+		return false, err
+	}
+	return session.AllowRate(st, st.pder.getClock(), action, limit, window)
+}
+
+// CountRate implements session.RateCounter, counters are kept in provider apart from values.
+func (st *Session) CountRate(action string, now time.Time, window time.Duration) (int64, error) {
+	pder := st.pder
+	pder.mx.Lock()
+	defer pder.mx.Unlock()
+	rec, ok := pder.records[st.sid]
+	if !ok {
+		rec = &record{value: make(map[string]interface{}), timeCreated: st.timeCreated}
+		pder.records[st.sid] = rec
+	}
+	if rec.rates == nil {
+		rec.rates = make(session.RateCounters)
+	}
+	return rec.rates.Count(action, now, window), nil
+}
+
+// SetSensitive sets value encrypted with keys of session.SetSensitiveKeys(), see session.SetSensitive().
+func (st *Session) SetSensitive(key string, value interface{}) error {
+	return session.SetSensitive(st, key, value)
+}
+
+// lookup returns unexpired in-memory value.
+func (st *Session) lookup(key string) (interface{}, bool) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return session.LookupValueAt(st.pder.now(), st.value, key)
+}
+
+// modified marks session modified and accessed, st.mx must be locked.
+func (st *Session) modified() {
+	st.valueModified = true
+	st.timeAccessed = st.pder.now()
+}
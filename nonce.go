@@ -0,0 +1,78 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// NONCE_PREFIX prefixes session values holding nonces: NONCE_PREFIX+scope+BUCKET_SEP+token,
+// a value is nonce expiration time in Unix nanoseconds.
+const NONCE_PREFIX = "session_nonce:"
+
+// NONCE_LEN is a number of random bytes of a nonce.
+const NONCE_LEN = 16
+
+// IssueNonce returns a one-time token of the session for scope, e.g. a form or a download link,
+// the token is valid till it is consumed with ConsumeNonce() or ttl passes.
+// Expired nonces of the scope are deleted. Nonces are written with Session.CompareAndSwap(),
+// so they are stored at once and atomically by every provider.
+func IssueNonce(s Session, scope string, ttl time.Duration) (string, error) {
+	if err := purgeNonces(s, scope); err != nil {
+		return "", err
+	}
+	b := make([]byte, NONCE_LEN)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	if _, err := s.CompareAndSwap(nonceKey(scope, token), nil, time.Now().Add(ttl).UnixNano()); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConsumeNonce deletes nonce issued by IssueNonce() for scope and reports if it was valid:
+// issued for the scope, not expired and not consumed before.
+// Of concurrent calls with the same token only one returns true.
+func ConsumeNonce(s Session, scope, token string) (bool, error) {
+	if b, err := hex.DecodeString(token); err != nil || len(b) != NONCE_LEN {
+		return false, nil
+	}
+	key := nonceKey(scope, token)
+	expires := s.GetInt(key)
+	if expires == 0 {
+		return false, nil
+	}
+	consumed, err := s.CompareAndSwap(key, expires, nil)
+	if err != nil || !consumed {
+		return false, err
+	}
+	return time.Now().UnixNano() < expires, nil
+}
+
+func nonceKey(scope, token string) string {
+	return NONCE_PREFIX + scope + BUCKET_SEP + token
+}
+
+// purgeNonces deletes expired nonces of scope.
+func purgeNonces(s Session, scope string) error {
+	keys, err := s.Keys()
+	if err != nil {
+		return err
+	}
+	prefix := NONCE_PREFIX + scope + BUCKET_SEP
+	now := time.Now().UnixNano()
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) || strings.Contains(key[len(prefix):], BUCKET_SEP) {
+			continue
+		}
+		if expires := s.GetInt(key); expires <= now {
+			if _, err := s.CompareAndSwap(key, expires, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package session
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// PAYLOAD_MAGIC is the first byte of versioned payloads, it is followed by payload version.
+// Gob streams never start with this byte, so payloads written before versions were enabled
+// are read as version 0.
+const PAYLOAD_MAGIC byte = 0xC6
+
+// PayloadMigration decodes gob payload data written with oldVersion to dest,
+// e.g. decoding old struct types and converting them to the current ones.
+// Dest is a pointer to map[string]interface{} holding all session values.
+type PayloadMigration func(oldVersion byte, data []byte, dest interface{}) error
+
+// PayloadVersion writes version to session payloads and migrates payloads of other versions.
+type PayloadVersion struct {
+	version byte
+	migrate PayloadMigration
+}
+
+// NewPayloadVersion returns payload version, payloads of other versions are decoded with migrate,
+// as they are if migrate is nil.
+func NewPayloadVersion(version byte, migrate PayloadMigration) *PayloadVersion {
+	return &PayloadVersion{version: version, migrate: migrate}
+}
+
+// Encode returns gob encoded value prefixed with PAYLOAD_MAGIC and version.
+// Nil payload version encodes value without prefix.
+func (pv *PayloadVersion) Encode(value interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if pv != nil {
+		b.Write([]byte{PAYLOAD_MAGIC, pv.version})
+	}
+	if err := gob.NewEncoder(&b).Encode(value); err != nil {
+		return nil, TypeError(err)
+	}
+	return b.Bytes(), nil
+}
+
+// Decode decodes payload returned by Encode() to dest.
+// Payloads of another version are passed to the migration if it is set.
+// Nil payload version decodes all payloads as they are.
+func (pv *PayloadVersion) Decode(data []byte, dest interface{}) error {
+	version, data := SplitPayloadVersion(data)
+	if pv != nil && pv.migrate != nil && version != pv.version {
+		return pv.migrate(version, data, dest)
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(dest); err != nil {
+		return TypeError(err)
+	}
+	return nil
+}
+
+// SplitPayloadVersion returns payload version and gob data, version 0 if payload is not versioned.
+func SplitPayloadVersion(payload []byte) (byte, []byte) {
+	if len(payload) < 2 || payload[0] != PAYLOAD_MAGIC {
+		return 0, payload
+	}
+	return payload[1], payload[2:]
+}
+
+// VersionedProvider is an optional interface for providers writing versioned payloads.
+type VersionedProvider interface {
+	SetPayloadVersion(*PayloadVersion) //nil disables versions, versioned payloads are still read
+}
+
+// SetPayloadVersion sets version written to session payloads, so payloads of an incompatible
+// previous version are passed to the hook set by OnPayloadVersionMismatch() instead of failing to decode
+// after a deploy changing value types. Payloads written before versions were enabled have version 0.
+// Provider must implement VersionedProvider interface.
+func (manager *Manager) SetPayloadVersion(version byte) error {
+	manager.payloadVersion = version
+	return manager.applyPayloadVersion()
+}
+
+// OnPayloadVersionMismatch sets a hook decoding session payloads of versions other than
+// the one set by SetPayloadVersion(). Migrated values are written with the current version
+// when the session is modified and flushed. Provider must implement VersionedProvider interface.
+func (manager *Manager) OnPayloadVersionMismatch(fn PayloadMigration) error {
+	manager.payloadMigration = fn
+	return manager.applyPayloadVersion()
+}
+
+func (manager *Manager) applyPayloadVersion() error {
+	ver_pder, ok := manager.provider.(VersionedProvider)
+	if !ok {
+		return ErrNoPayloadVersion
+	}
+	ver_pder.SetPayloadVersion(NewPayloadVersion(manager.payloadVersion, manager.payloadMigration))
+	return nil
+}
@@ -0,0 +1,77 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dronm/session"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Config holds provider parameters. It is passed to InitProvider() as the only parameter
+// instead of a pool opened by the application, the pool is opened by the provider then
+// and closed by CloseProvider():
+//
+//	session.NewManager("pg", 0, 3600, "", pg.Config{
+//		URL: "postgres://user@db.example.com/app",
+//		TLS: &session.TLSConfig{CAFile: "ca.pem", CertFile: "client.pem", KeyFile: "client-key.pem"},
+//	})
+type Config struct {
+	URL        string             //connection string, postgres://<user>:<pass>@localhost:5432/<db>, required
+	TLS        *session.TLSConfig //TLS of connections, overrides sslmode of URL and is required if set, only the first host of URL is used
+	EncryptKey string             //if set session payload is encrypted with PGP_SYM_ENCRYPT
+
+	//Connection pool parameters, zero values keep values of URL parameters (pool_max_conns etc.)
+	//or pgxpool defaults: max 4 connections or number of CPUs, no idle connections kept open,
+	//connections are closed after an hour or 30 minutes of idle time, no connect timeout.
+	MaxConns        int32         //max open connections
+	MinConns        int32         //connections kept open
+	MaxConnLifetime time.Duration //connections are closed after this time
+	MaxConnIdleTime time.Duration //idle connections are closed after this time
+	ConnectTimeout  time.Duration //timeout of opening a connection
+}
+
+// newPool opens connection pool of the config.
+func (cfg Config) newPool(ctx context.Context) (*pgxpool.Pool, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("InitProvider Config.URL must not be empty")
+	}
+	pool_cfg, err := pgxpool.ParseConfig(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxConns < 0 || cfg.MinConns < 0 || cfg.MaxConnLifetime < 0 || cfg.MaxConnIdleTime < 0 || cfg.ConnectTimeout < 0 {
+		return nil, errors.New("InitProvider Config connection pool parameters must not be negative")
+	}
+	if cfg.MaxConns > 0 && cfg.MinConns > cfg.MaxConns {
+		return nil, errors.New("InitProvider Config.MinConns must not exceed Config.MaxConns")
+	}
+	if cfg.MaxConns > 0 {
+		pool_cfg.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		pool_cfg.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		pool_cfg.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		pool_cfg.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.ConnectTimeout > 0 {
+		pool_cfg.ConnConfig.ConnectTimeout = cfg.ConnectTimeout
+	}
+	if cfg.TLS != nil {
+		tls_cfg, err := cfg.TLS.Load()
+		if err != nil {
+			return nil, err
+		}
+		if tls_cfg.ServerName == "" {
+			tls_cfg.ServerName = pool_cfg.ConnConfig.Host
+		}
+		pool_cfg.ConnConfig.TLSConfig = tls_cfg
+		pool_cfg.ConnConfig.Fallbacks = nil //no plain text fallback of sslmode=prefer and other hosts
+	}
+	return pgxpool.NewWithConfig(ctx, pool_cfg)
+}
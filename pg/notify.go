@@ -0,0 +1,143 @@
+package pg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/dronm/session"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// NOTIFY_CHANNEL is a channel of session change notifications, see SetChangedHook().
+const NOTIFY_CHANNEL = "session_changes"
+
+// LISTEN_RETRY is a delay before the listening connection is opened again after an error.
+const LISTEN_RETRY = time.Second
+
+// execer runs a query with a pool, a connection or a transaction.
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+// SetChangedHook implements session.ChangeNotifier. While the hook is set, provider notifies
+// other application instances of modified and destroyed sessions with NOTIFY on NOTIFY_CHANNEL
+// and listens to their notifications on a dedicated connection. Payload is instance ID:session ID,
+// so notifications of the instance itself are skipped.
+func (pder *Provider) SetChangedHook(hook session.SessionHook) {
+	pder.hookMx.Lock()
+	pder.changedHook = hook
+	pder.hookMx.Unlock()
+	pder.restartListen()
+}
+
+// notifying returns true if changes are sent to other instances.
+func (pder *Provider) notifying() bool {
+	pder.hookMx.Lock()
+	defer pder.hookMx.Unlock()
+	return pder.changedHook != nil
+}
+
+// notify sends change notifications of sessions, empty session ID means all sessions.
+// Notifications sent within a transaction are delivered on commit.
+func (pder *Provider) notify(ctx context.Context, db execer, sids ...string) error {
+	if len(sids) == 0 || !pder.notifying() {
+		return nil
+	}
+	_, err := db.Exec(ctx,
+		`SELECT pg_notify($1, $2 || id) FROM unnest($3::text[]) AS id`,
+		NOTIFY_CHANNEL, pder.instanceID+":", sids,
+	)
+	return err
+}
+
+// sessionChanged calls changed hook if it is set.
+func (pder *Provider) sessionChanged(sid string) {
+	pder.hookMx.Lock()
+	hook := pder.changedHook
+	pder.hookMx.Unlock()
+	if hook != nil {
+		hook(sid)
+	}
+}
+
+// restartListen stops listening and starts it again if changed hook is set and provider is initialized.
+func (pder *Provider) restartListen() {
+	pder.stopListen()
+	pder.hookMx.Lock()
+	defer pder.hookMx.Unlock()
+	if pder.changedHook == nil || pder.dbpool == nil {
+		return
+	}
+	var ctx context.Context
+	ctx, pder.listenCancel = context.WithCancel(context.Background())
+	go pder.listen(ctx, pder.dbpool)
+}
+
+func (pder *Provider) stopListen() {
+	pder.hookMx.Lock()
+	defer pder.hookMx.Unlock()
+	if pder.listenCancel != nil {
+		pder.listenCancel()
+		pder.listenCancel = nil
+	}
+}
+
+// listen receives notifications till ctx is canceled, connection is opened again on errors.
+func (pder *Provider) listen(ctx context.Context, pool *pgxpool.Pool) {
+	log := pder.getLogger(nil, session.LOG_LEVEL_ERROR).With(session.LOG_KEY_OPERATION, "listen")
+	for reconnect := false; ; reconnect = true {
+		err := pder.listenConn(ctx, pool, reconnect)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Error(LOG_PREF+"listenConn() failed", session.LOG_KEY_ERROR, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(LISTEN_RETRY):
+		}
+	}
+}
+
+// listenConn listens on a connection taken from the pool till an error.
+// Notifications may have been lost before reconnect, changed hook is called with empty session ID then.
+func (pder *Provider) listenConn(ctx context.Context, pool *pgxpool.Pool, reconnect bool) error {
+	pool_conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	conn := pool_conn.Hijack() //listening connection is not returned to the pool
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{NOTIFY_CHANNEL}.Sanitize()); err != nil {
+		return err
+	}
+	if reconnect {
+		pder.sessionChanged("")
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		instance_id, sid, ok := strings.Cut(n.Payload, ":")
+		if !ok || instance_id == pder.instanceID {
+			continue
+		}
+		pder.sessionChanged(sid)
+	}
+}
+
+// genInstanceID returns random ID of the application instance.
+func genInstanceID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
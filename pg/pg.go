@@ -4,8 +4,11 @@
 //	jackc connection to PG Sql https://github.com/jackc/pgx
 //	PG_CRYPTO extension must be installed CREATE EXTENSION pgrypto, PGP_SYM_DECRYPT, PGP_SYM_ENCRYPT functions are used,
 //	If encryption is not necessary - correct sql in SessionRead/SessionClose functions
+//	Extension, table and indexes are created with Provider.EnsureSchema(), see schema.sql.
 //	Some SQL scripts are nesessary:
-//		session_vals.sql contains table for holding session values
+//		session_vals.sql contains table for holding session values,
+//			expires_at column holds per-session expiration set with SessionStore.SetExpiry(), see script.sql
+//		session_users table indexed by user_id keeps sessions bound to users with session.Manager.BindUser(), see schema.sql
 //		session_vals_process.sql trigger function for updating login information (logins table must be present in database)
 //		session_vals_trigger.sql creating trigger script
 //
@@ -14,14 +17,16 @@
 package pg
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
+	_ "embed"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dronm/session"
@@ -29,8 +34,11 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-var EKeyNotFound = errors.New("key not found")
-var EValMustBePtr = errors.New("value must be of type ptr")
+// Deprecated: use session.ErrKeyNotFound.
+var EKeyNotFound = session.ErrKeyNotFound
+
+// Deprecated: use session.ErrValMustBePtr.
+var EValMustBePtr = session.ErrValMustBePtr
 
 // Session key ID length. As it is stored it pg data base in varchar column its length is limited.
 const SESS_ID_LEN = 36
@@ -39,20 +47,28 @@ const PROVIDER = "pg"
 
 const LOG_PREF = "pg provider:"
 
-// pder holds pointer to Provider struct.
-var pder = &Provider{}
+// pder holds pointer to Provider struct registered as PROVIDER.
+var pder = NewProvider()
+
+// NewProvider returns a provider independent of the registered one, e.g. to use another connection pool
+// in another Manager created with session.NewManagerWithProvider().
+func NewProvider() *Provider {
+	return &Provider{clock: session.SystemClock{}}
+}
 
 // storeValue holds session key-value pares.
 type storeValue map[string]interface{}
 
 // SessionStore contains session information.
 type SessionStore struct {
-	sid           string //session id
+	sid           string    //session id
+	pder          *Provider //provider storing the session
 	mx            sync.RWMutex
-	timeAccessed  time.Time  //last modified
-	timeCreated   time.Time  //when created
-	value         storeValue //key-value pair
+	timeAccessed  atomic.Int64 //last access in Unix nanoseconds, updated under read lock by getters
+	timeCreated   time.Time    //when created
+	value         storeValue   //key-value pair
 	valueModified bool
+	lockConn      *pgxpool.Conn //connection holding advisory lock
 }
 
 // Set sets inmemory value. No database flush is done.
@@ -76,7 +92,7 @@ func (st *SessionStore) Set(key string, value interface{}) error {
 		st.mx.Lock()
 		st.value[key] = value
 		st.valueModified = true
-		st.timeAccessed = time.Now()
+		st.accessed()
 		st.mx.Unlock()
 	}
 	return nil
@@ -89,16 +105,66 @@ func (st *SessionStore) Put(key string, value interface{}) error {
 	return st.Flush()
 }
 
+// SetMany sets in-memory values under one lock, they are written by one Flush().
+func (st *SessionStore) SetMany(values map[string]interface{}) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	for key, value := range values {
+		if !reflect.DeepEqual(st.value[key], value) {
+			st.value[key] = value
+			st.valueModified = true
+			st.accessed()
+		}
+	}
+	return nil
+}
+
+// SetWithTTL sets in-memory value expiring in ttl, see session.ExpiringValue.
+func (st *SessionStore) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value[key] = session.NewExpiringValueAt(st.pder.clock.Now(), value, ttl)
+	st.valueModified = true
+	st.accessed()
+	return nil
+}
+
+// GetMany assigns in-memory values to dest pointers, see session.GetMany().
+func (st *SessionStore) GetMany(dest map[string]interface{}) error {
+	return session.GetMany(st, dest)
+}
+
+// Snapshot returns a deep copy of in-memory values without expired ones, see session.SnapshotValuesAt().
+func (st *SessionStore) Snapshot() (map[string]interface{}, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return session.SnapshotValuesAt(st.pder.clock.Now(), st.value)
+}
+
+// Restore replaces in-memory values with a copy of snapshot, they are written by Flush().
+func (st *SessionStore) Restore(snapshot map[string]interface{}) error {
+	value, err := session.CopyValues(snapshot)
+	if err != nil {
+		return err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value = value
+	st.valueModified = true
+	st.accessed()
+	return nil
+}
+
 // Flush performs the actual write to database.
 func (st *SessionStore) Flush() error {
 	//flush val only if it's been modified
 	if st.valueModified {
 		//modified
-		val, err := getForDb(&st.value)
+		val, err := st.pder.getForDb(&st.value)
 		if err != nil {
 			return err
 		}
-		conn, err := pder.dbpool.Acquire(context.Background())
+		conn, err := st.pder.dbpool.Acquire(context.Background())
 		if err != nil {
 			return err
 		}
@@ -108,16 +174,20 @@ func (st *SessionStore) Flush() error {
 			`UPDATE session_vals
 			SET
 				val = pgp_sym_encrypt_bytea($1, $2),
-				accessed_time = now()
+				accessed_time = `+st.pder.accessedTime(false)+`
 			WHERE id = $3`,
 			val,
-			pder.encrkey,
+			st.pder.encrkey,
 			st.sid,
 		); err != nil {
 			return err
 		}
+		if err := st.pder.notify(context.Background(), conn, st.sid); err != nil {
+			return err
+		}
 		st.mx.Lock()
 		st.valueModified = false
+		st.written()
 		st.mx.Unlock()
 	}
 
@@ -126,9 +196,11 @@ func (st *SessionStore) Flush() error {
 
 // Get returns session value by its key. Value is retrieved from memory.
 func (st *SessionStore) Get(key string, val interface{}) error {
-	store_val, ok := st.value[key]
+	st.mx.RLock()
+	store_val, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
 	if !ok {
-		return EKeyNotFound
+		return session.ErrKeyNotFound
 	}
 
 	// Get the type of val
@@ -136,13 +208,13 @@ func (st *SessionStore) Get(key string, val interface{}) error {
 
 	// Make sure val is a pointer
 	if val_type.Kind() != reflect.Ptr {
-		return EValMustBePtr
+		return session.ErrValMustBePtr
 	}
 
 	// Dereference the pointer and check if it's assignable
 	val_elem := val_type.Elem()
 	if !reflect.TypeOf(store_val).AssignableTo(val_elem) {
-		return errors.New("value type mismatch")
+		return session.ErrTypeMismatch
 	}
 
 	// Assign the value to val
@@ -151,15 +223,24 @@ func (st *SessionStore) Get(key string, val interface{}) error {
 	return nil
 }
 
+// GetStruct retrieves session value by its key into dest pointer.
+// Value of a different type is converted, see session.AssignValue().
+func (st *SessionStore) GetStruct(key string, dest interface{}) error {
+	st.mx.RLock()
+	store_val, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if !ok {
+		return session.ErrKeyNotFound
+	}
+	return session.AssignValue(store_val, dest)
+}
+
 // GetBool returns bool value by key.
 func (st *SessionStore) GetBool(key string) bool {
-	v, ok := st.value[key]
+	v, ok := st.lookup(key)
 	if !ok {
 		return false
 	}
-	st.mx.Lock()
-	defer st.mx.Unlock()
-	st.timeAccessed = time.Now()
 
 	if v_bool, ok := v.(bool); ok {
 		return v_bool
@@ -169,15 +250,11 @@ func (st *SessionStore) GetBool(key string) bool {
 
 // GetString returns string value by key.
 func (st *SessionStore) GetString(key string) string {
-	v, ok := st.value[key]
+	v, ok := st.lookup(key)
 	if !ok {
 		return ""
 	}
 
-	st.mx.Lock()
-	defer st.mx.Unlock()
-	st.timeAccessed = time.Now()
-
 	if v_str, ok := v.(string); ok {
 		return v_str
 
@@ -189,15 +266,11 @@ func (st *SessionStore) GetString(key string) string {
 
 // GetInt returns int value by key.
 func (st *SessionStore) GetInt(key string) int64 {
-	v, ok := st.value[key]
+	v, ok := st.lookup(key)
 	if !ok {
 		return 0
 	}
 
-	st.mx.Lock()
-	defer st.mx.Unlock()
-	st.timeAccessed = time.Now()
-
 	if v_i, ok := v.(int64); ok {
 		return v_i
 
@@ -209,15 +282,11 @@ func (st *SessionStore) GetInt(key string) int64 {
 
 // GetFloat returns float value by key.
 func (st *SessionStore) GetFloat(key string) float64 {
-	v, ok := st.value[key]
+	v, ok := st.lookup(key)
 	if !ok {
 		return 0
 	}
 
-	st.mx.Lock()
-	defer st.mx.Unlock()
-	st.timeAccessed = time.Now()
-
 	if v_f, ok := v.(float64); ok {
 		return v_f
 
@@ -229,36 +298,244 @@ func (st *SessionStore) GetFloat(key string) float64 {
 
 // GetDate returns time.Time value by key.
 func (st *SessionStore) GetDate(key string) time.Time {
-	v, ok := st.value[key]
+	v, ok := st.lookup(key)
 	if !ok {
 		return time.Time{}
 	}
 
-	st.mx.Lock()
-	defer st.mx.Unlock()
-	st.timeAccessed = time.Now()
-
 	if v_t, ok := v.(time.Time); ok {
 		return v_t
 	}
 	return time.Time{}
 }
 
-// Delete deletes session value from memmory by key. No flushing is done.
-func (st *SessionStore) Delete(key string) error {
-	_, ok := st.value[key]
+// GetBytes returns []byte value by key, string value is converted.
+func (st *SessionStore) GetBytes(key string) []byte {
+	v, ok := st.lookup(key)
+	if !ok {
+		return nil
+	}
+
+	if v_b, ok := v.([]byte); ok {
+		return v_b
+
+	} else if v_b, ok := v.(string); ok {
+		return []byte(v_b)
+	}
+	return nil
+}
+
+// GetStringSlice returns []string value by key.
+func (st *SessionStore) GetStringSlice(key string) []string {
+	v, ok := st.lookup(key)
 	if !ok {
 		return nil
 	}
 
+	if v_s, ok := v.([]string); ok {
+		return v_s
+	}
+	return nil
+}
+
+// GetOrSet assigns session value to dest, if there is no value
+// it is computed, stored and flushed under session lock, see session.GetOrSet().
+func (st *SessionStore) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	return session.GetOrSet(st, key, dest, compute)
+}
+
+// Delete deletes session value from memmory by key. No flushing is done.
+func (st *SessionStore) Delete(key string) error {
 	st.mx.Lock()
 	defer st.mx.Unlock()
-	st.timeAccessed = time.Now()
+	if _, ok := st.value[key]; !ok {
+		return nil
+	}
+	st.accessed()
 	delete(st.value, key)
+	st.valueModified = true
 
 	return nil
 }
 
+// Clear deletes all session values from memory keeping session ID and creation time. No flushing is done.
+func (st *SessionStore) Clear() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if len(st.value) > 0 {
+		st.value = make(storeValue)
+		st.valueModified = true
+	}
+	st.accessed()
+
+	return nil
+}
+
+// Increment atomically adds delta to integer session value and returns the new value.
+// Missing value is treated as 0. The value is updated in database within a transaction
+// with the session row locked and in memory, other modified in-memory values are not flushed.
+func (st *SessionStore) Increment(key string, delta int64) (int64, error) {
+	ctx := context.Background()
+	tx, err := st.pder.dbpool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		"INSERT INTO session_vals(id, accessed_time, create_time) VALUES($1, "+st.pder.now()+", "+st.pder.now()+") ON CONFLICT(id) DO NOTHING",
+		st.sid,
+	); err != nil {
+		return 0, err
+	}
+	var val []byte
+	if err := tx.QueryRow(ctx,
+		`SELECT pgp_sym_decrypt_bytea(val, $2) FROM session_vals WHERE id = $1 FOR UPDATE`,
+		st.sid, st.pder.encrkey).Scan(&val); err != nil {
+		return 0, err
+	}
+	db_value := make(storeValue)
+	if err := st.pder.setFromDb(&db_value, val); err != nil {
+		return 0, err
+	}
+	cur, _ := session.LookupValueAt(st.pder.clock.Now(), db_value, key)
+	new_val, err := session.IncrementValue(cur, delta)
+	if err != nil {
+		return 0, err
+	}
+	db_value[key] = new_val
+	if val, err = st.pder.getForDb(&db_value); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE session_vals
+		SET
+			val = pgp_sym_encrypt_bytea($1, $2),
+			accessed_time = `+st.pder.accessedTime(false)+`
+		WHERE id = $3`,
+		val,
+		st.pder.encrkey,
+		st.sid,
+	); err != nil {
+		return 0, err
+	}
+	if err := st.pder.notify(ctx, tx, st.sid); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	st.mx.Lock()
+	st.value[key] = new_val
+	st.written()
+	st.mx.Unlock()
+
+	return new_val, nil
+}
+
+// Decrement atomically subtracts delta from integer session value and returns the new value.
+func (st *SessionStore) Decrement(key string, delta int64) (int64, error) {
+	return st.Increment(key, -delta)
+}
+
+// CompareAndSwap sets newValue in a transaction if the stored value equals oldValue,
+// session row is locked with SELECT FOR UPDATE, see session.Session.CompareAndSwap().
+func (st *SessionStore) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
+	ctx := context.Background()
+	tx, err := st.pder.dbpool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		"INSERT INTO session_vals(id, accessed_time, create_time) VALUES($1, "+st.pder.now()+", "+st.pder.now()+") ON CONFLICT(id) DO NOTHING",
+		st.sid,
+	); err != nil {
+		return false, err
+	}
+	var val []byte
+	if err := tx.QueryRow(ctx,
+		`SELECT pgp_sym_decrypt_bytea(val, $2) FROM session_vals WHERE id = $1 FOR UPDATE`,
+		st.sid, st.pder.encrkey).Scan(&val); err != nil {
+		return false, err
+	}
+	db_value := make(storeValue)
+	if err := st.pder.setFromDb(&db_value, val); err != nil {
+		return false, err
+	}
+	if cur, _ := session.LookupValueAt(st.pder.clock.Now(), db_value, key); !session.EqualValue(cur, oldValue) {
+		return false, nil
+	}
+	if newValue == nil {
+		delete(db_value, key)
+	} else {
+		db_value[key] = newValue
+	}
+	if val, err = st.pder.getForDb(&db_value); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE session_vals
+		SET
+			val = pgp_sym_encrypt_bytea($1, $2),
+			accessed_time = `+st.pder.accessedTime(false)+`
+		WHERE id = $3`,
+		val,
+		st.pder.encrkey,
+		st.sid,
+	); err != nil {
+		return false, err
+	}
+	if err := st.pder.notify(ctx, tx, st.sid); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+
+	st.mx.Lock()
+	if newValue == nil {
+		delete(st.value, key)
+	} else {
+		st.value[key] = newValue
+	}
+	st.written()
+	st.mx.Unlock()
+
+	return true, nil
+}
+
+// Bucket returns a view of the session with keys prefixed by name, see session.NewBucket().
+func (st *SessionStore) Bucket(name string) session.Session {
+	return session.NewBucket(st, name)
+}
+
+// Keys returns sorted session value keys.
+func (st *SessionStore) Keys() ([]string, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	keys := make([]string, 0, len(st.value))
+	now := st.pder.clock.Now()
+	for key, v := range st.value {
+		if !session.ValueExpiredAt(now, v) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Len returns number of session values.
+func (st *SessionStore) Len() (int, error) {
+	keys, err := st.Keys()
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
 // SessionID returns session unique ID.
 func (st *SessionStore) SessionID() string {
 	return st.sid
@@ -271,38 +548,239 @@ func (st *SessionStore) TimeCreated() time.Time {
 
 // TimeAccessed returns timeAccessed property.
 func (st *SessionStore) TimeAccessed() time.Time {
-	return st.timeAccessed
+	return time.Unix(0, st.timeAccessed.Load())
+}
+
+// Lock acquires session lock. Postgresql advisory lock is used,
+// the connection holding the lock is kept until Unlock() is called.
+// Values are reloaded from database after the lock is acquired.
+func (st *SessionStore) Lock() error {
+	ctx, cancel := context.WithTimeout(context.Background(), session.LOCK_WAIT)
+	defer cancel()
+
+	conn, err := st.pder.dbpool.Acquire(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return session.ErrLockTimeout
+		}
+		return err
+	}
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock(hashtext($1))`, st.sid); err != nil {
+		conn.Release()
+		if ctx.Err() != nil {
+			return session.ErrLockTimeout
+		}
+		return err
+	}
+
+	st.mx.Lock()
+	st.lockConn = conn
+	st.mx.Unlock()
+
+	return st.reload()
+}
+
+// Unlock releases session lock.
+func (st *SessionStore) Unlock() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if st.lockConn == nil {
+		return nil
+	}
+	defer func() {
+		st.lockConn.Release()
+		st.lockConn = nil
+	}()
+	if _, err := st.lockConn.Exec(context.Background(), `SELECT pg_advisory_unlock(hashtext($1))`, st.sid); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetExpiry sets session expiration time to now+d, the session is removed by GC
+// after that time regardless of max life and idle time. d <= 0 restores defaults.
+func (st *SessionStore) SetExpiry(d time.Duration) error {
+	var expires_at interface{} //NULL
+	if d > 0 {
+		expires_at = st.pder.clock.Now().Add(d)
+	}
+	res, err := st.pder.dbpool.Exec(context.Background(),
+		`UPDATE session_vals SET expires_at = $1 WHERE id = $2`,
+		expires_at, st.sid,
+	)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return session.ErrSessionNotFound
+	}
+	return st.pder.notify(context.Background(), st.pder.dbpool, st.sid)
+}
+
+// Touch updates session access time in database,
+// nothing is done in session.EXPIRATION_FIXED mode.
+func (st *SessionStore) Touch() error {
+	if !st.pder.expMode.TouchOnWrite() {
+		return nil
+	}
+	res, err := st.pder.dbpool.Exec(context.Background(),
+		`UPDATE session_vals SET accessed_time = `+st.pder.now()+` WHERE id = $1`,
+		st.sid,
+	)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return session.ErrSessionNotFound
+	}
+	st.mx.Lock()
+	st.written()
+	st.mx.Unlock()
+	return nil
+}
+
+// Allow counts action in the current window of provider clock, see session.AllowRate().
+func (st *SessionStore) Allow(action string, limit int, window time.Duration) (bool, error) {
+	return session.AllowRate(st, st.pder.clock, action, limit, window)
+}
+
+// CountRate implements session.RateCounter, counters are kept in rates column in a transaction.
+func (st *SessionStore) CountRate(action string, now time.Time, window time.Duration) (int64, error) {
+	ctx := context.Background()
+	tx, err := st.pder.dbpool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		"INSERT INTO session_vals(id, accessed_time, create_time) VALUES($1, "+st.pder.now()+", "+st.pder.now()+") ON CONFLICT(id) DO NOTHING",
+		st.sid,
+	); err != nil {
+		return 0, err
+	}
+	var rates_b []byte
+	if err := tx.QueryRow(ctx, `SELECT rates FROM session_vals WHERE id = $1 FOR UPDATE`, st.sid).Scan(&rates_b); err != nil {
+		return 0, err
+	}
+	rates, err := session.DecodeRates(rates_b)
+	if err != nil {
+		return 0, err
+	}
+	cnt := rates.Count(action, now, window)
+	if rates_b, err = session.EncodeRates(rates); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE session_vals SET rates = $1, accessed_time = `+st.pder.accessedTime(false)+` WHERE id = $2`,
+		rates_b, st.sid,
+	); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return cnt, nil
+}
+
+// SetSensitive sets value encrypted with keys of session.SetSensitiveKeys(), see session.SetSensitive().
+func (st *SessionStore) SetSensitive(key string, value interface{}) error {
+	return session.SetSensitive(st, key, value)
+}
+
+// lookup returns in-memory value by key under read lock and updates access time on success,
+// so concurrent readers do not serialize.
+func (st *SessionStore) lookup(key string) (interface{}, bool) {
+	st.mx.RLock()
+	v, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if ok {
+		st.accessed()
+	}
+	return v, ok
+}
+
+// accessed updates in-memory access time if it is updated on every access.
+// The time is stored atomically, st.mx is not required.
+func (st *SessionStore) accessed() {
+	if st.pder.expMode.TouchOnRead() {
+		st.timeAccessed.Store(st.pder.clock.Now().UnixNano())
+	}
+}
+
+// written updates in-memory access time after session is written to database.
+func (st *SessionStore) written() {
+	if st.pder.expMode.TouchOnWrite() {
+		st.timeAccessed.Store(st.pder.clock.Now().UnixNano())
+	}
+}
+
+// reload reads values from database to memory.
+func (st *SessionStore) reload() error {
+	var val []byte
+	if err := st.pder.dbpool.QueryRow(context.Background(),
+		`SELECT pgp_sym_decrypt_bytea(val, $2) FROM session_vals WHERE id = $1`,
+		st.sid, st.pder.encrkey).Scan(&val); err != nil && err != pgx.ErrNoRows {
+		return err
+	}
+	value := make(storeValue)
+	if err := st.pder.setFromDb(&value, val); err != nil {
+		return err
+	}
+	st.mx.Lock()
+	st.value = value
+	st.valueModified = false
+	st.mx.Unlock()
+	return nil
 }
 
 // Provider structure holds provider information.
 type Provider struct {
-	dbpool      *pgxpool.Pool
-	encrkey     string
-	maxLifeTime int64
-	maxIdleTime int64
+	dbpool         *pgxpool.Pool
+	ownPool        bool //pool is opened from Config and closed by CloseProvider()
+	encrkey        string
+	payloadVersion *session.PayloadVersion //payload versions, nil if not used
+	maxLifeTime    int64
+	maxIdleTime    int64
+	logger         *slog.Logger        //structured logger, nil if not set
+	expiredHook    session.SessionHook //called for sessions removed by SessionGC
+	gcLimit        int                 //max sessions removed by one SessionGC run, 0 if not limited
+
+	expMode     session.ExpirationMode   //when accessed_time is updated
+	strict      bool                     //unknown sessions are not created on read, see SetStrictMode()
+	clock       session.Clock            //session times and expiration, see SetClock()
+	leaderMx    sync.Mutex               //guards leaderConns
+	leaderConns map[string]*pgxpool.Conn //connections holding GC leader advisory locks by lock name
+
+	instanceID   string              //application instance ID sent with change notifications
+	hookMx       sync.Mutex          //guards changedHook and listenCancel
+	changedHook  session.SessionHook //called for sessions changed by other instances, see SetChangedHook()
+	listenCancel context.CancelFunc  //stops listening to change notifications, nil if not listening
 }
 
 func (pder *Provider) NewSessionStore(sid string) *SessionStore {
-	return &SessionStore{
-		sid:          sid,
-		timeAccessed: time.Now(),
-		timeCreated:  time.Now(),
-		value:        make(map[string]interface{}, 0),
+	store := &SessionStore{
+		pder:        pder,
+		sid:         sid,
+		timeCreated: pder.clock.Now(),
+		value:       make(map[string]interface{}, 0),
 	}
+	store.timeAccessed.Store(store.timeCreated.UnixNano())
+	return store
 }
 
 // SessionInit initializes session with given ID.
 func (pder *Provider) SessionInit(sid string) (session.Session, error) {
 	if pder.dbpool == nil {
-		return nil, errors.New("Provider not initialized")
+		return nil, session.ErrProviderNotInitialized
 	}
 
 	if len(sid) > SESS_ID_LEN {
-		return nil, errors.New("Session key length exceeded max value")
+		return nil, fmt.Errorf("%w: length exceeds %d", session.ErrInvalidSessionID, SESS_ID_LEN)
 	}
 
 	if _, err := pder.dbpool.Exec(context.Background(),
-		"INSERT INTO session_vals(id) VALUES($1) ON CONFLICT(id) DO NOTHING",
+		"INSERT INTO session_vals(id, accessed_time, create_time) VALUES($1, "+pder.now()+", "+pder.now()+") ON CONFLICT(id) DO NOTHING",
 		sid,
 	); err != nil {
 		return nil, err
@@ -311,32 +789,54 @@ func (pder *Provider) SessionInit(sid string) (session.Session, error) {
 }
 
 // SessionRead reads session data from db to memory.
+// Expired session is destroyed, session.ErrSessionExpired is returned then.
 func (pder *Provider) SessionRead(sid string) (session.Session, error) {
 	var val []byte
 
 	store := pder.NewSessionStore(sid)
 
+	var prev_accessed, accessed_time time.Time
+	var expires_at *time.Time
 	if err := pder.dbpool.QueryRow(context.Background(),
-		`UPDATE session_vals
+		`WITH prev AS (SELECT accessed_time FROM session_vals WHERE id = $1)
+		UPDATE session_vals
 		SET
-			accessed_time = now()
-		WHERE id = $1
+			accessed_time = `+pder.accessedTime(true)+`
+		FROM prev
+		WHERE session_vals.id = $1
 		RETURNING
-			accessed_time,
-			create_time,
-			pgp_sym_decrypt_bytea(val, $2)`,
-		sid, pder.encrkey).Scan(&store.timeAccessed,
+			prev.accessed_time,
+			session_vals.accessed_time,
+			session_vals.create_time,
+			session_vals.expires_at,
+			pgp_sym_decrypt_bytea(session_vals.val, $2)`,
+		sid, pder.encrkey).Scan(&prev_accessed,
+		&accessed_time,
 		&store.timeCreated,
+		&expires_at,
 		&val,
 	); err != nil && err == pgx.ErrNoRows {
 		//no such session
+		if pder.strict {
+			return nil, session.ErrSessionNotFound
+		}
 		return pder.SessionInit(sid)
 
 	} else if err != nil {
 		return nil, err
 	}
+	store.timeAccessed.Store(accessed_time.UnixNano())
+	if expires_at == nil {
+		expires_at = &time.Time{}
+	}
+	if session.IsExpiredAt(pder.clock.Now(), store.timeCreated, prev_accessed, *expires_at, pder.maxLifeTime, pder.maxIdleTime) {
+		if err := pder.removeSessionFromDb(sid); err != nil {
+			return nil, err
+		}
+		return nil, session.ErrSessionExpired
+	}
 
-	if err := setFromDb(&store.value, val); err != nil {
+	if err := pder.setFromDb(&store.value, val); err != nil {
 		return nil, err
 	}
 
@@ -355,42 +855,409 @@ func (pder *Provider) SessionDestroy(sid string) error {
 	return nil
 }
 
-// SessionGC clears unused sessions
-func (pder *Provider) SessionGC(l io.Writer, logLev session.LogLevel) {
-	if pder.maxIdleTime == 0 && pder.maxLifeTime == 0 {
-		return
+// SessionGC clears unused sessions.
+// Sessions with expiration time set by SessionStore.SetExpiry() are removed after that time only.
+func (pder *Provider) SessionGC(l io.Writer, logLev session.LogLevel) session.GCReport {
+	report, _ := pder.sessionGC(pder.getLogger(l, logLev))
+	return report
+}
+
+// CollectGarbage implements session.ProviderV2, errors of GC queries are returned joined.
+func (pder *Provider) CollectGarbage() (int, error) {
+	report, err := pder.sessionGC(pder.getLogger(nil, session.LOG_LEVEL_ERROR))
+	return report.Deleted(), err
+}
+
+// sessionGC clears unused sessions, errors are logged and returned joined.
+func (pder *Provider) sessionGC(log *slog.Logger) (session.GCReport, error) {
+	log = log.With(session.LOG_KEY_OPERATION, "SessionGC")
+	start := time.Now()
+	var report session.GCReport
+	var errs []error
+	defer func() {
+		log.Debug(LOG_PREF+"SessionGC() done", session.LOG_KEY_COUNT, report.Deleted(), session.LOG_KEY_DURATION, time.Since(start))
+	}()
+
+	if cnt, err := pder.gcDelete(
+		`expires_at IS NOT NULL AND expires_at <= `+pder.now(), pder.gcLimit,
+	); err != nil {
+		report.Errors++
+		errs = append(errs, err)
+		log.Error(LOG_PREF+"gcDelete() failed on expires_at", session.LOG_KEY_ERROR, err)
+	} else {
+		report.DeletedExpiry = cnt
 	}
 
 	//inactive sessions
-	if pder.maxIdleTime > 0 {
-		if _, err := pder.dbpool.Exec(context.Background(),
-			fmt.Sprintf(`DELETE FROM session_vals WHERE accessed_time + ('%d seconds')::interval <= now()`, pder.maxIdleTime),
+	if limit, ok := pder.gcRemaining(report); ok && pder.maxIdleTime > 0 {
+		if cnt, err := pder.gcDelete(
+			fmt.Sprintf(`expires_at IS NULL AND accessed_time + ('%d seconds')::interval <= %s`, pder.maxIdleTime, pder.now()), limit,
 		); err != nil {
-			//log error
-			if l != nil {
-				session.WriteToLog(l, fmt.Sprintf(LOG_PREF+"Exec() failed on DELETE FROM session_vals WHERE accessed_time: %v", err), session.LOG_LEVEL_ERROR)
-			}
+			report.Errors++
+			errs = append(errs, err)
+			log.Error(LOG_PREF+"gcDelete() failed on accessed_time", session.LOG_KEY_ERROR, err)
+		} else {
+			report.DeletedIdle = cnt
 		}
 	}
 
-	if pder.maxLifeTime > 0 {
-		if _, err := pder.dbpool.Exec(context.Background(),
-			fmt.Sprintf(`DELETE FROM session_vals WHERE create_time + ('%d seconds')::interval <= now()`, pder.maxLifeTime),
+	if limit, ok := pder.gcRemaining(report); ok && pder.maxLifeTime > 0 {
+		if cnt, err := pder.gcDelete(
+			fmt.Sprintf(`expires_at IS NULL AND create_time + ('%d seconds')::interval <= %s`, pder.maxLifeTime, pder.now()), limit,
 		); err != nil {
-			//log error
-			if l != nil {
-				session.WriteToLog(l, fmt.Sprintf(LOG_PREF+"Exec() failed on DELETE FROM session_vals WHERE create_time: %v", err), session.LOG_LEVEL_ERROR)
-			}
+			report.Errors++
+			errs = append(errs, err)
+			log.Error(LOG_PREF+"gcDelete() failed on create_time", session.LOG_KEY_ERROR, err)
+		} else {
+			report.DeletedLifetime = cnt
 		}
 	}
+	return report, errors.Join(errs...)
 }
 
-func (pder *Provider) DestroyAllSessions(l io.Writer, logLev session.LogLevel) {
-	if _, err := pder.dbpool.Exec(context.Background(), `DELETE FROM session_vals`); err != nil {
-		if l != nil {
-			session.WriteToLog(l, fmt.Sprintf(LOG_PREF+"Exec() failed on DELETE FROM session_vals: %v", err), session.LOG_LEVEL_ERROR)
+// gcRemaining returns max number of sessions the next GC query may remove, 0 if not limited.
+// ok is false if GC limit is reached.
+func (pder *Provider) gcRemaining(report session.GCReport) (limit int, ok bool) {
+	if pder.gcLimit <= 0 {
+		return 0, true
+	}
+	limit = pder.gcLimit - report.Deleted()
+	return limit, limit > 0
+}
+
+// gcDelete deletes sessions matching where condition, no more than limit sessions if limit > 0.
+func (pder *Provider) gcDelete(where string, limit int) (int, error) {
+	if limit > 0 {
+		return pder.deleteExpired(
+			fmt.Sprintf(`DELETE FROM session_vals WHERE id IN (SELECT id FROM session_vals WHERE %s LIMIT %d) RETURNING id`, where, limit),
+		)
+	}
+	return pder.deleteExpired(`DELETE FROM session_vals WHERE ` + where + ` RETURNING id`)
+}
+
+// GC_LEADER_LOCK_PREF prefixes GC leader lock names hashed to advisory lock keys.
+const GC_LEADER_LOCK_PREF = "session_gc_leader:"
+
+// AcquireGCLeader implements session.GCLeaderLocker with postgres advisory lock.
+// The lock is held by a dedicated pool connection till ReleaseGCLeader(), ttl is not used:
+// the server releases the lock if the connection is lost.
+func (pder *Provider) AcquireGCLeader(name string, ttl time.Duration) (bool, error) {
+	pder.leaderMx.Lock()
+	defer pder.leaderMx.Unlock()
+
+	ctx := context.Background()
+	if conn, ok := pder.leaderConns[name]; ok {
+		if err := conn.Ping(ctx); err == nil {
+			return true, nil
+		}
+		//lock is lost with connection
+		conn.Conn().Close(ctx)
+		conn.Release()
+		delete(pder.leaderConns, name)
+	}
+
+	conn, err := pder.dbpool.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	var leader bool
+	if err := conn.QueryRow(ctx,
+		`SELECT pg_try_advisory_lock(hashtextextended($1, 0))`,
+		GC_LEADER_LOCK_PREF+name,
+	).Scan(&leader); err != nil {
+		conn.Release()
+		return false, err
+	}
+	if !leader {
+		conn.Release()
+		return false, nil
+	}
+	if pder.leaderConns == nil {
+		pder.leaderConns = make(map[string]*pgxpool.Conn)
+	}
+	pder.leaderConns[name] = conn
+	return true, nil
+}
+
+// ReleaseGCLeader implements session.GCLeaderLocker.
+func (pder *Provider) ReleaseGCLeader(name string) error {
+	pder.leaderMx.Lock()
+	defer pder.leaderMx.Unlock()
+
+	conn, ok := pder.leaderConns[name]
+	if !ok {
+		return nil
+	}
+	delete(pder.leaderConns, name)
+	defer conn.Release()
+	_, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock(hashtextextended($1, 0))`, GC_LEADER_LOCK_PREF+name)
+	return err
+}
+
+// SetGCMaxDeletions implements session.GCLimiter.
+func (pder *Provider) SetGCMaxDeletions(maxDeletions int) {
+	pder.gcLimit = maxDeletions
+}
+
+// SetExpirationMode implements session.ExpirationModeSetter.
+func (pder *Provider) SetExpirationMode(mode session.ExpirationMode) {
+	pder.expMode = mode
+}
+
+// SetStrictMode implements session.StrictModeSetter: SessionRead() returns session.ErrSessionNotFound
+// for an unknown session instead of creating it.
+func (pder *Provider) SetStrictMode(strict bool) error {
+	pder.strict = strict
+	return nil
+}
+
+// accessedTime returns SQL expression for accessed_time column on session read or write,
+// column is not changed if expiration mode does not update access time.
+func (pder *Provider) accessedTime(read bool) string {
+	if read && pder.expMode.TouchOnRead() || !read && pder.expMode.TouchOnWrite() {
+		return pder.now()
+	}
+	return "accessed_time"
+}
+
+// now returns SQL expression for current time: now() for session.SystemClock,
+// time of the clock set with SetClock() otherwise.
+func (pder *Provider) now() string {
+	if _, ok := pder.clock.(session.SystemClock); ok {
+		return "now()"
+	}
+	return "'" + pder.clock.Now().UTC().Format(time.RFC3339Nano) + "'::timestamptz"
+}
+
+// SetClock implements session.ClockSetter, session times and expiration checked on read and by GC
+// are of the clock. Nil restores session.SystemClock.
+func (pder *Provider) SetClock(clock session.Clock) {
+	if clock == nil {
+		clock = session.SystemClock{}
+	}
+	pder.clock = clock
+}
+
+// deleteExpired runs DELETE ... RETURNING id query
+// and calls expired hook for every deleted session.
+// Number of deleted sessions is returned.
+func (pder *Provider) deleteExpired(query string) (int, error) {
+	rows, err := pder.dbpool.Query(context.Background(), query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	sids := make([]string, 0)
+	for rows.Next() {
+		var sid string
+		if err := rows.Scan(&sid); err != nil {
+			return 0, err
+		}
+		sids = append(sids, sid)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+	for _, sid := range sids {
+		pder.sessionExpired(sid)
+	}
+	if err := pder.notify(context.Background(), pder.dbpool, sids...); err != nil {
+		return len(sids), err
+	}
+	return len(sids), nil
+}
+
+// SessionCount returns number of sessions in session_vals table.
+func (pder *Provider) SessionCount() (int, error) {
+	var cnt int
+	if err := pder.dbpool.QueryRow(context.Background(),
+		`SELECT count(*) FROM session_vals`,
+	).Scan(&cnt); err != nil {
+		return 0, err
+	}
+	return cnt, nil
+}
+
+// SessionList returns session metadata ordered by session ID.
+func (pder *Provider) SessionList(offset, limit int) ([]session.SessionMeta, error) {
+	var lim interface{} //NULL means no limit
+	if limit > 0 {
+		lim = limit
+	}
+	rows, err := pder.dbpool.Query(context.Background(),
+		`SELECT id, create_time, accessed_time
+		FROM session_vals
+		ORDER BY id
+		LIMIT $1 OFFSET $2`,
+		lim, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]session.SessionMeta, 0)
+	for rows.Next() {
+		var meta session.SessionMeta
+		if err := rows.Scan(&meta.ID, &meta.TimeCreated, &meta.TimeAccessed); err != nil {
+			return nil, err
 		}
+		list = append(list, meta)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// SessionStats implements session.StatsProvider with one aggregate query, distributions are exact
+// and are computed of all sessions, sampleSize is not used. Sizes are of encrypted payloads,
+// StorageBytes is the size of session_vals table with its indexes and TOAST data.
+func (pder *Provider) SessionStats(ctx context.Context, sampleSize int) (session.SessionStats, error) {
+	stats := session.SessionStats{Provider: PROVIDER}
+	var sizes []int64
+	var ages, idles []float64 //seconds
+	if err := pder.dbpool.QueryRow(ctx,
+		`SELECT
+			count(*),
+			coalesce(sum(octet_length(val)), 0),
+			percentile_disc($1::float8[]) WITHIN GROUP (ORDER BY coalesce(octet_length(val), 0)),
+			percentile_disc($1::float8[]) WITHIN GROUP (ORDER BY extract(epoch FROM `+pder.now()+` - create_time)::float8),
+			percentile_disc($1::float8[]) WITHIN GROUP (ORDER BY extract(epoch FROM `+pder.now()+` - accessed_time)::float8),
+			pg_total_relation_size('session_vals')
+		FROM session_vals`,
+		[]float64{0, 0.5, 0.9, 0.99, 1},
+	).Scan(&stats.Count, &stats.TotalSize, &sizes, &ages, &idles, &stats.StorageBytes); err != nil {
+		return session.SessionStats{}, err
+	}
+	stats.Sampled = stats.Count
+	if len(sizes) == 5 {
+		stats.Size = session.Distribution[int64]{Min: sizes[0], P50: sizes[1], P90: sizes[2], P99: sizes[3], Max: sizes[4]}
+	}
+	stats.Age = durationPercentiles(ages)
+	stats.Idle = durationPercentiles(idles)
+	return stats, nil
+}
+
+// durationPercentiles returns distribution of min, p50, p90, p99, max values in seconds.
+func durationPercentiles(seconds []float64) session.Distribution[time.Duration] {
+	if len(seconds) != 5 {
+		return session.Distribution[time.Duration]{}
+	}
+	d := func(sec float64) time.Duration {
+		return time.Duration(sec * float64(time.Second))
+	}
+	return session.Distribution[time.Duration]{Min: d(seconds[0]), P50: d(seconds[1]), P90: d(seconds[2]), P99: d(seconds[3]), Max: d(seconds[4])}
+}
+
+// SessionMeta implements session.MetaProvider, session values are not decrypted.
+// Size is a size of encrypted payload.
+func (pder *Provider) SessionMeta(sid string) (session.SessionMeta, error) {
+	meta := session.SessionMeta{ID: sid, Provider: PROVIDER}
+	var expires_at *time.Time
+	if err := pder.dbpool.QueryRow(context.Background(),
+		`SELECT create_time, accessed_time, expires_at, coalesce(octet_length(val), 0)
+		FROM session_vals
+		WHERE id = $1`,
+		sid).Scan(&meta.TimeCreated, &meta.TimeAccessed, &expires_at, &meta.Size); err == pgx.ErrNoRows {
+		return session.SessionMeta{}, session.ErrSessionNotFound
+
+	} else if err != nil {
+		return session.SessionMeta{}, err
+	}
+	if expires_at != nil {
+		meta.ExpiresAt = *expires_at
+	}
+	return meta, nil
+}
+
+// SessionPeek implements session.PeekProvider: session values are read
+// without updating access time, missing session is not created, expired one is not removed.
+func (pder *Provider) SessionPeek(sid string) (session.Session, error) {
+	var val []byte
+	store := pder.NewSessionStore(sid)
+	var accessed_time time.Time
+	var expires_at *time.Time
+	if err := pder.dbpool.QueryRow(context.Background(),
+		`SELECT accessed_time, create_time, expires_at, pgp_sym_decrypt_bytea(val, $2)
+		FROM session_vals
+		WHERE id = $1`,
+		sid, pder.encrkey).Scan(&accessed_time,
+		&store.timeCreated,
+		&expires_at,
+		&val,
+	); err == pgx.ErrNoRows {
+		return nil, session.ErrSessionNotFound
+
+	} else if err != nil {
+		return nil, err
+	}
+	if expires_at == nil {
+		expires_at = &time.Time{}
+	}
+	if session.IsExpiredAt(pder.clock.Now(), store.timeCreated, accessed_time, *expires_at, pder.maxLifeTime, pder.maxIdleTime) {
+		return nil, session.ErrSessionExpired
+	}
+	store.timeAccessed.Store(accessed_time.UnixNano())
+	if err := pder.setFromDb(&store.value, val); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// SessionDestroyMany destroys sessions with one query.
+func (pder *Provider) SessionDestroyMany(sids []string) error {
+	if _, err := pder.dbpool.Exec(context.Background(), `DELETE FROM session_vals WHERE id = ANY($1)`, sids); err != nil {
+		return err
+	}
+	return pder.notify(context.Background(), pder.dbpool, sids...)
+}
+
+func (pder *Provider) DestroyAllSessions(l io.Writer, logLev session.LogLevel) {
+	log := pder.getLogger(l, logLev).With(session.LOG_KEY_OPERATION, "DestroyAllSessions")
+	start := time.Now()
+	cnt, err := pder.DestroyAll()
+	if err != nil {
+		log.Error(LOG_PREF+"Exec() failed on DELETE FROM session_vals", session.LOG_KEY_ERROR, err)
+		return
+	}
+	log.Debug(LOG_PREF+"DestroyAllSessions() done", session.LOG_KEY_COUNT, cnt, session.LOG_KEY_DURATION, time.Since(start))
+}
+
+// DestroyAll implements session.ProviderV2, returns number of destroyed sessions.
+func (pder *Provider) DestroyAll() (int, error) {
+	tag, err := pder.dbpool.Exec(context.Background(), `DELETE FROM session_vals`)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), pder.notify(context.Background(), pder.dbpool, "")
+}
+
+// SetExpiredHook sets callback for sessions removed by SessionGC.
+func (pder *Provider) SetExpiredHook(hook session.SessionHook) {
+	pder.expiredHook = hook
+}
+
+// sessionExpired calls expired hook if it is set.
+func (pder *Provider) sessionExpired(sid string) {
+	if pder.expiredHook != nil {
+		pder.expiredHook(sid)
+	}
+}
+
+// SetLogger sets structured logger.
+func (pder *Provider) SetLogger(logger *slog.Logger) {
+	pder.logger = logger
+}
+
+// SetPayloadVersion sets payload version, nil disables versions.
+func (pder *Provider) SetPayloadVersion(payloadVersion *session.PayloadVersion) {
+	pder.payloadVersion = payloadVersion
+}
+
+// getLogger returns provider logger or io.Writer adapter if logger is not set.
+func (pder *Provider) getLogger(l io.Writer, logLev session.LogLevel) *slog.Logger {
+	return session.LoggerFor(pder.logger, l, logLev).With(session.LOG_KEY_PROVIDER, PROVIDER)
 }
 
 func (pder *Provider) SetMaxLifeTime(maxLifeTime int64) {
@@ -409,11 +1276,23 @@ func (pder *Provider) GetMaxIdleTime() int64 {
 }
 
 // InitProvider initializes postgresql provider.
-// Function expects two parameters:
+// Function expects a Config or two parameters:
 //
 //	First parameter: *pgxpool.Pool
 //	Second parameter: encryptKey application unique,if to set no encryption used
 func (pder *Provider) InitProvider(provParams []interface{}) error {
+	if len(provParams) == 1 {
+		if cfg, ok := provParams[0].(Config); ok {
+			dbpool, err := cfg.newPool(context.Background())
+			if err != nil {
+				return err
+			}
+			pder.dbpool = dbpool
+			pder.ownPool = true
+			pder.encrkey = cfg.EncryptKey
+			return pder.initInstance()
+		}
+	}
 	if len(provParams) < 2 {
 		return errors.New("InitProvider missing parameters: *pgxpool.Pool, encryptKey")
 	}
@@ -422,25 +1301,65 @@ func (pder *Provider) InitProvider(provParams []interface{}) error {
 	if !ok {
 		return errors.New("InitProvider db connection parameter(0) must be of type *pgxpool.Pool")
 	}
+	pder.ownPool = false
 
 	pder.encrkey, ok = provParams[1].(string)
 	if !ok {
 		return errors.New("InitProvider encryptKey parameter(1) must be a string")
 	}
+	return pder.initInstance()
+}
+
+// initInstance generates instance ID and starts listening to change notifications.
+func (pder *Provider) initInstance() error {
+	instance_id, err := genInstanceID()
+	if err != nil {
+		return err
+	}
+	pder.instanceID = instance_id
+	pder.restartListen()
+	return nil
+}
 
+// CloseProvider stops listening to change notifications,
+// pool is closed if it is opened by the provider from Config, otherwise it is owned by the caller.
+func (pder *Provider) CloseProvider() error {
+	pder.stopListen()
+	if pder.ownPool && pder.dbpool != nil {
+		pder.dbpool.Close()
+		pder.dbpool = nil
+	}
 	return nil
 }
 
-// CloseProvider closes all database connections.
-func (pder *Provider) CloseProvider() {
+// Ping checks database connection.
+func (pder *Provider) Ping(ctx context.Context) error {
+	if pder.dbpool == nil {
+		return session.ErrProviderNotInitialized
+	}
+	return pder.dbpool.Ping(ctx)
+}
 
+// SCHEMA_SQL creates database objects used by the provider, see EnsureSchema().
+//
+//go:embed schema.sql
+var SCHEMA_SQL string
+
+// EnsureSchema creates pgcrypto extension, session_vals and session_users tables with indexes if they do not exist.
+// Application specific triggers (e.g. updating login information) are not created.
+func (pder *Provider) EnsureSchema(ctx context.Context) error {
+	if pder.dbpool == nil {
+		return session.ErrProviderNotInitialized
+	}
+	_, err := pder.dbpool.Exec(ctx, SCHEMA_SQL)
+	return err
 }
 
 func (pder *Provider) removeSessionFromDb(sid string) error {
 	if _, err := pder.dbpool.Exec(context.Background(), `DELETE FROM session_vals WHERE id = $1`, sid); err != nil {
 		return err
 	}
-	return nil
+	return pder.notify(context.Background(), pder.dbpool, sid)
 }
 
 func (pder *Provider) GetSessionIDLen() int {
@@ -449,27 +1368,25 @@ func (pder *Provider) GetSessionIDLen() int {
 
 // setFromDb is a helper function, called on retrieving value from data base.
 // It decodes data base value for in-memory store.
-func setFromDb(strucVal *storeValue, dbVal []byte) error {
+func (pder *Provider) setFromDb(strucVal *storeValue, dbVal []byte) error {
 	if len(dbVal) == 0 {
 		return nil
 	}
-	dec := gob.NewDecoder(bytes.NewBuffer(dbVal))
-	if err := dec.Decode(strucVal); err != nil {
+	if err := pder.payloadVersion.Decode(dbVal, (*map[string]interface{})(strucVal)); err != nil {
 		return err
 	}
+	session.PurgeExpiredValuesAt(pder.clock.Now(), *strucVal) //values set with SetWithTTL() are filtered on read
 	return nil
 }
 
 // getForDb is a helper function called before putting value to database.
 // It encodes in-memory session value for data base.
-func getForDb(strucVal *storeValue) ([]byte, error) {
-	var b bytes.Buffer
-	enc := gob.NewEncoder(&b)
-	err := enc.Encode(strucVal)
+func (pder *Provider) getForDb(strucVal *storeValue) ([]byte, error) {
+	val, err := pder.payloadVersion.Encode(strucVal)
 	if err != nil {
 		return []byte{}, err
 	}
-	return b.Bytes(), nil
+	return val, nil
 }
 
 func init() {
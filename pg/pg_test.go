@@ -3,14 +3,13 @@ package pg
 
 import (
 	"context"
-	"database/sql"
 	"encoding/gob"
 	"os"
-	"reflect"
 	"testing"
 	"time"
 
 	"github.com/dronm/session" //session manager
+	"github.com/dronm/session/testkit"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -27,72 +26,6 @@ func getTestVar(t *testing.T, n string) string {
 	return v
 }
 
-// TestStruct custom struct for use in session.
-type TestStruct struct {
-	IntVal   int
-	FloatVal float32
-	StrVal   string
-}
-
-func NewTestStruct() TestStruct {
-	return TestStruct{IntVal: 375, FloatVal: 3.14, StrVal: "Some string value in struct"}
-}
-
-func NewTestValues() map[string]interface{} {
-	//Register custom struct for marshaling.
-	gob.Register(TestStruct{})
-	gob.Register(time.Time{})
-
-	return map[string]interface{}{
-		"stringVal":  "some string value",
-		"int32Val":   int32(2147483647),
-		"int64Val":   2147483647 * 2,
-		"float32Val": float32(3.14),
-		"float64Val": float64(3.14),
-		"dateVal":    time.Now().Truncate(time.Second),
-		"structVal":  NewTestStruct(),
-	}
-}
-
-func putValues(t *testing.T, currentSession session.Session, tests map[string]interface{}) {
-	//test writing
-	for key, val := range tests {
-		t.Logf("Setting key: %s to %v", key, val)
-		if err := currentSession.Set(key, val); err != nil {
-			t.Fatalf("Set() for string value failed: %v", err)
-		}
-	}
-	if err := currentSession.Flush(); err != nil {
-		t.Fatalf("Flush() failed: %v", err)
-	}
-}
-
-func compareValues(t *testing.T, currentSession session.Session, tests map[string]interface{}) {
-	for key, wanted := range tests {
-		t.Logf("Getting key: %s", key)
-
-		ptr := reflect.New(reflect.TypeOf(wanted))
-		err := currentSession.Get(key, ptr.Interface())
-		if err != nil {
-			t.Fatalf("Get() failed: %v", err)
-		}
-		got := ptr.Elem().Interface()
-		if !reflect.DeepEqual(got, wanted) {
-			t.Fatalf("Wanted: %v, got %v", wanted, got)
-		}
-	}
-}
-
-func assertNoValues(t *testing.T, currentSession session.Session, tests map[string]interface{}) {
-	for key, wanted := range tests {
-		ptr := reflect.New(reflect.TypeOf(wanted))
-		err := currentSession.Get(key, ptr.Interface())
-		if err == nil {
-			t.Fatalf("Session: %s is not destroyed", currentSession.SessionID())
-		}
-	}
-}
-
 func NewManager(t *testing.T, idleTime int64, lifeTime int64, killTime string) (*session.Manager, error) {
 	dbpool, err := pgxpool.New(context.Background(), getTestVar(t, ENV_PG_CONN))
 	if err != nil {
@@ -149,11 +82,11 @@ func TestSession(t *testing.T) {
 	sid := currentSession.SessionID()
 	t.Logf("SessionID: %s", sid)
 
-	tests := NewTestValues()
-	putValues(t, currentSession, tests)
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
 
 	//test reading
-	compareValues(t, currentSession, tests)
+	testkit.CompareValues(t, currentSession, tests)
 
 	t.Logf("Closing session: %s", sid)
 	if err := SessManager.SessionClose(sid); err != nil {
@@ -167,7 +100,7 @@ func TestSession(t *testing.T) {
 		t.Errorf("SessionStart() failed: %v", err)
 	}
 	//test reading
-	compareValues(t, currentSession, tests)
+	testkit.CompareValues(t, currentSession, tests)
 
 	if err := SessManager.SessionClose(currentSession.SessionID()); err != nil {
 		t.Errorf("SessionClose() failed: %v", err)
@@ -184,7 +117,7 @@ func TestSession(t *testing.T) {
 		t.Errorf("SessionStart() failed: %v", err)
 	}
 	t.Logf("Trying to read from session")
-	assertNoValues(t, currentSession, tests)
+	testkit.AssertNoValues(t, currentSession, tests)
 	t.Logf("Session destroyed to read from session")
 }
 
@@ -205,8 +138,8 @@ func TestDestroyAllSessions(t *testing.T) {
 	sid := currentSession.SessionID()
 	t.Logf("SessionID: %s", sid)
 
-	tests := NewTestValues()
-	putValues(t, currentSession, tests)
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
 
 	SessManager.DestroyAllSessions(os.Stderr, session.LOG_LEVEL_DEBUG)
 
@@ -215,7 +148,7 @@ func TestDestroyAllSessions(t *testing.T) {
 		t.Errorf("SessionStart() failed: %v", err)
 	}
 	t.Logf("Trying to read from session")
-	assertNoValues(t, currentSession, tests)
+	testkit.AssertNoValues(t, currentSession, tests)
 }
 
 // TestLifeTime creates a session with a limited life time.
@@ -234,8 +167,8 @@ func TestLifeTime(t *testing.T) {
 	sid := currentSession.SessionID()
 	t.Logf("SessionID: %s", sid)
 
-	tests := NewTestValues()
-	putValues(t, currentSession, tests)
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
 	if err := SessManager.SessionClose(currentSession.SessionID()); err != nil {
 		t.Errorf("SessionClose() failed: %v", err)
 	}
@@ -250,7 +183,7 @@ func TestLifeTime(t *testing.T) {
 		t.Errorf("SessionStart() failed: %v", err)
 	}
 	t.Logf("Trying to read from session")
-	assertNoValues(t, currentSession, tests)
+	testkit.AssertNoValues(t, currentSession, tests)
 	t.Logf("The session %s is destroyed", sid)
 }
 
@@ -271,8 +204,8 @@ func TestIdleTime(t *testing.T) {
 	sid := currentSession.SessionID()
 	t.Logf("SessionID: %s", sid)
 
-	tests := NewTestValues()
-	putValues(t, currentSession, tests)
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
 	if err := SessManager.SessionClose(currentSession.SessionID()); err != nil {
 		t.Errorf("SessionClose() failed: %v", err)
 	}
@@ -281,7 +214,7 @@ func TestIdleTime(t *testing.T) {
 	time.Sleep(time.Duration(idle_time/2) * time.Second)
 	SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG)
 	//test reading
-	compareValues(t, currentSession, tests)
+	testkit.CompareValues(t, currentSession, tests)
 
 	t.Logf("waiting %d seconds for session to be killed", idle_time+2)
 	time.Sleep(time.Duration(idle_time) * time.Second)
@@ -293,7 +226,7 @@ func TestIdleTime(t *testing.T) {
 		t.Errorf("SessionStart() failed: %v", err)
 	}
 	t.Logf("Trying to read from session")
-	assertNoValues(t, currentSession, tests)
+	testkit.AssertNoValues(t, currentSession, tests)
 	t.Logf("The session %s is destroyed", sid)
 }
 
@@ -322,8 +255,8 @@ func TestKillByTime(t *testing.T) {
 	sid := currentSession.SessionID()
 	t.Logf("SessionID: %s", sid)
 
-	tests := NewTestValues()
-	putValues(t, currentSession, tests)
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
 	if err := SessManager.SessionClose(currentSession.SessionID()); err != nil {
 		t.Errorf("SessionClose() failed: %v", err)
 	}
@@ -331,7 +264,7 @@ func TestKillByTime(t *testing.T) {
 	t.Logf("waiting %d seconds", 1)
 	time.Sleep(time.Duration(1) * time.Second)
 	//test reading
-	compareValues(t, currentSession, tests)
+	testkit.CompareValues(t, currentSession, tests)
 
 	t.Logf("waiting %d seconds for session to be killed", in_sec+2)
 	time.Sleep(time.Duration(in_sec+2) * time.Second)
@@ -341,7 +274,7 @@ func TestKillByTime(t *testing.T) {
 		t.Errorf("SessionStart() failed: %v", err)
 	}
 	t.Logf("Trying to read from session")
-	assertNoValues(t, currentSession, tests)
+	testkit.AssertNoValues(t, currentSession, tests)
 	t.Logf("The session %s is destroyed", sid)
 }
 
@@ -357,13 +290,13 @@ func TestRestartGC(t *testing.T) {
 	SessManager.StartGC(os.Stderr, session.LOG_LEVEL_DEBUG)
 
 	currentSession, err := SessManager.SessionStart("")
-	tests := NewTestValues()
-	putValues(t, currentSession, tests)
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
 	if err := SessManager.SessionClose(currentSession.SessionID()); err != nil {
 		t.Errorf("SessionClose() failed: %v", err)
 	}
 	time.Sleep(time.Duration(1) * time.Second)
-	compareValues(t, currentSession, tests)
+	testkit.CompareValues(t, currentSession, tests)
 
 	//reset the GC time
 	lt_sec = lt_sec * 2
@@ -375,6 +308,151 @@ func TestRestartGC(t *testing.T) {
 	t.Logf("Waiting %d seconds", lt_sec+2)
 	time.Sleep(time.Duration(lt_sec+2) * time.Second)
 	t.Logf("Trying to read from session")
-	assertNoValues(t, currentSession, tests)
+	testkit.AssertNoValues(t, currentSession, tests)
 	t.Logf("The session %s is destroyed", currentSession.SessionID())
 }
+
+// TestLock locks a session, starts the same session in a goroutine which must wait for the lock.
+// The waiting session must see the value flushed by the first one.
+func TestLock(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStartLocked("")
+	if err != nil {
+		t.Fatalf("SessionStartLocked() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	done := make(chan int64)
+	go func() {
+		sess, err := SessManager.SessionStartLocked(sid)
+		if err != nil {
+			t.Errorf("SessionStartLocked() failed: %v", err)
+			close(done)
+			return
+		}
+		got := sess.GetInt("counter")
+		if err := sess.Unlock(); err != nil {
+			t.Errorf("Unlock() failed: %v", err)
+		}
+		done <- got
+	}()
+
+	time.Sleep(time.Duration(200) * time.Millisecond)
+	if err := currentSession.Put("counter", int64(1)); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := currentSession.Unlock(); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+
+	if got := <-done; got != 1 {
+		t.Fatalf("Wanted: 1, got %v", got)
+	}
+}
+
+// TestConformance runs testkit conformance suite.
+func TestConformance(t *testing.T) {
+	testkit.Run(t, func(t *testing.T, maxLifeTime, maxIdleTime int64) *session.Manager {
+		manager, err := NewManager(t, maxLifeTime, maxIdleTime, "")
+		if err != nil {
+			t.Fatalf("NewManager() failed: %v", err)
+		}
+		return manager
+	})
+}
+
+// TestConfig initializes provider with Config, pool is opened and closed by the provider.
+func TestConfig(t *testing.T) {
+	prov := NewProvider()
+	SessManager, err := session.NewManagerWithProvider(prov, 0, 0, "", Config{URL: getTestVar(t, ENV_PG_CONN)})
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	if err := SessManager.HealthCheck(context.Background()); err != nil {
+		t.Fatalf("HealthCheck() failed: %v", err)
+	}
+	if err := SessManager.CloseProvider(); err != nil {
+		t.Fatalf("CloseProvider() failed: %v", err)
+	}
+	if prov.dbpool != nil {
+		t.Fatal("pool opened from Config is not closed by CloseProvider()")
+	}
+
+	tls_cfg := &session.TLSConfig{CertFile: "client.pem"}
+	if _, err := session.NewManagerWithProvider(NewProvider(), 0, 0, "", Config{URL: getTestVar(t, ENV_PG_CONN), TLS: tls_cfg}); err == nil {
+		t.Fatal("NewManagerWithProvider() wanted error for TLSConfig.CertFile without KeyFile")
+	}
+}
+
+// TestChangedHook checks LISTEN/NOTIFY of sessions changed by another provider instance.
+// Both instances must have changed hook set, instances notify only while listening.
+func TestChangedHook(t *testing.T) {
+	cfg := Config{URL: getTestVar(t, ENV_PG_CONN)}
+	SessManager, err := session.NewManagerWithProvider(NewProvider(), 0, 0, "", cfg)
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	defer SessManager.CloseProvider()
+	OtherManager, err := session.NewManagerWithProvider(NewProvider(), 0, 0, "", cfg)
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	defer OtherManager.CloseProvider()
+
+	own := make(chan string, 100)
+	if err := SessManager.OnSessionChanged(func(sid string) { own <- sid }); err != nil {
+		t.Fatalf("OnSessionChanged() failed: %v", err)
+	}
+	changed := make(chan string, 100)
+	if err := OtherManager.OnSessionChanged(func(sid string) { changed <- sid }); err != nil {
+		t.Fatalf("OnSessionChanged() failed: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond) //LISTEN is made asynchronously
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	waitChanged := func(action string) {
+		t.Helper()
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case changed_sid := <-changed:
+				if changed_sid == sid {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("session change is not reported after %s", action)
+			}
+		}
+	}
+	if err := currentSession.Put("key", "value"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	waitChanged("Put()")
+
+	otherSession, err := OtherManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if got := otherSession.GetString("key"); got != "value" {
+		t.Fatalf("changed value is not read by other instance, got %q", got)
+	}
+
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+	waitChanged("SessionDestroy()")
+
+	for len(own) > 0 {
+		if own_sid := <-own; own_sid == sid {
+			t.Fatalf("instance is notified of its own change of %s", own_sid)
+		}
+	}
+}
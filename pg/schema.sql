@@ -0,0 +1,22 @@
+-- Session provider schema, applied by Provider.EnsureSchema().
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+CREATE TABLE IF NOT EXISTS session_vals
+(
+    id character(36) NOT NULL,
+    accessed_time timestamp with time zone DEFAULT now(),
+    create_time timestamp with time zone DEFAULT now(),
+    val bytea,
+    expires_at timestamp with time zone,
+    rates bytea,
+    CONSTRAINT session_vals_pkey PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS session_vals_accessed_time_idx ON session_vals(accessed_time);
+CREATE INDEX IF NOT EXISTS session_vals_create_time_idx ON session_vals(create_time);
+CREATE TABLE IF NOT EXISTS session_users
+(
+    id character(36) NOT NULL,
+    user_id text NOT NULL,
+    bound_at bigint NOT NULL,
+    CONSTRAINT session_users_pkey PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS session_users_user_id_idx ON session_users(user_id);
@@ -10,6 +10,8 @@ CREATE TABLE public.session_vals
     accessed_time timestamp with time zone DEFAULT now(),
     create_time timestamp with time zone DEFAULT now(),
     val bytea,
+    expires_at timestamp with time zone,
+    rates bytea,
     CONSTRAINT session_vals_pkey PRIMARY KEY (id)
 )
 WITH (
@@ -18,3 +20,16 @@ WITH (
 TABLESPACE pg_default;
 
 create extension pgcrypto;
+
+-- Table: public.session_users, sessions bound to users
+
+-- DROP TABLE public.session_users;
+
+CREATE TABLE public.session_users
+(
+    id character(36) NOT NULL,
+    user_id text NOT NULL,
+    bound_at bigint NOT NULL,
+    CONSTRAINT session_users_pkey PRIMARY KEY (id)
+);
+CREATE INDEX session_users_user_id_idx ON public.session_users(user_id);
@@ -0,0 +1,55 @@
+package pg
+
+import (
+	"context"
+	"time"
+
+	"github.com/dronm/session"
+)
+
+// userIndex keeps sessions bound to users in session_users table indexed by user_id,
+// bind time is kept in Unix nanoseconds.
+type userIndex struct {
+	pder *Provider
+}
+
+// SessionUserIndex implements session.UserIndexProvider.
+func (pder *Provider) SessionUserIndex() session.UserIndex {
+	return &userIndex{pder: pder}
+}
+
+func (ix *userIndex) Add(userID, sid string, boundAt time.Time) error {
+	_, err := ix.pder.dbpool.Exec(context.Background(),
+		`INSERT INTO session_users (id, user_id, bound_at) VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET user_id = excluded.user_id, bound_at = excluded.bound_at`,
+		sid, userID, boundAt.UnixNano())
+	return err
+}
+
+func (ix *userIndex) Remove(sid string) error {
+	_, err := ix.pder.dbpool.Exec(context.Background(), `DELETE FROM session_users WHERE id = $1`, sid)
+	return err
+}
+
+func (ix *userIndex) Sessions(userID string) ([]session.UserSession, error) {
+	rows, err := ix.pder.dbpool.Query(context.Background(),
+		`SELECT id, bound_at FROM session_users WHERE user_id = $1 ORDER BY bound_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]session.UserSession, 0)
+	for rows.Next() {
+		var us session.UserSession
+		var bound_at int64
+		if err := rows.Scan(&us.ID, &bound_at); err != nil {
+			return nil, err
+		}
+		us.BoundAt = time.Unix(0, bound_at)
+		list = append(list, us)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
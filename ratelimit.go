@@ -0,0 +1,84 @@
+package session
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"time"
+)
+
+// RateCounter is implemented by sessions keeping counters of AllowRate() apart from session values,
+// so Clear(), Restore() or SetMany() do not reset them and they are not exported or dumped.
+// Providers store counters with the session record, they are deleted with the session.
+type RateCounter interface {
+	//increments counter of action in the window of now and returns the new count, see RateCounters.Count()
+	CountRate(action string, now time.Time, window time.Duration) (int64, error)
+}
+
+// AllowRate counts action of the session in the current fixed window of window duration
+// and reports if the count does not exceed limit, e.g. to throttle logins or API calls per user.
+// The window is of clock time, nil clock is SystemClock.
+// Providers implement Session.Allow() with this function unless they have native counters.
+func AllowRate(c RateCounter, clock Clock, action string, limit int, window time.Duration) (bool, error) {
+	if window <= 0 {
+		return false, errors.New("session: rate window must be positive")
+	}
+	n, err := c.CountRate(action, clockOrSystem(clock).Now(), window)
+	if err != nil {
+		return false, err
+	}
+	return n <= int64(limit), nil
+}
+
+// RateWindow is a count of actions in a fixed window.
+type RateWindow struct {
+	Window  int64     //window number: unix time in nanoseconds divided by window duration
+	Count   int64     //actions in the window
+	Expires time.Time //end of the window
+}
+
+// RateCounters are counters of AllowRate() by action, providers keep them with the session
+// apart from session values, see EncodeRates().
+type RateCounters map[string]RateWindow
+
+// Count increments counter of action in the window of now and returns the new count.
+// Counters of ended windows are deleted, of other actions as well.
+func (c RateCounters) Count(action string, now time.Time, window time.Duration) int64 {
+	for name, w := range c {
+		if !now.Before(w.Expires) {
+			delete(c, name)
+		}
+	}
+	win := now.UnixNano() / int64(window)
+	w, ok := c[action]
+	if !ok || w.Window != win {
+		w = RateWindow{Window: win, Expires: time.Unix(0, (win+1)*int64(window))}
+	}
+	w.Count++
+	c[action] = w
+	return w.Count
+}
+
+// EncodeRates returns gob encoded counters for storage, nil if there are none.
+func EncodeRates(c RateCounters) ([]byte, error) {
+	if len(c) == 0 {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeRates returns counters encoded with EncodeRates(), empty ones for empty data.
+func DecodeRates(data []byte) (RateCounters, error) {
+	c := make(RateCounters)
+	if len(data) == 0 {
+		return c, nil
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,90 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+// TestAllowRate checks that actions over the limit are denied in a window.
+func TestAllowRate(t *testing.T) {
+	sess := newMemorySession("sid", nil)
+	for i := 1; i <= 4; i++ {
+		ok, err := sess.Allow("login", 3, time.Hour)
+		if err != nil {
+			t.Fatalf("Allow() failed: %v", err)
+		}
+		if ok != (i <= 3) {
+			t.Fatalf("Allow() call %d = %v, wanted %v", i, ok, i <= 3)
+		}
+	}
+	if ok, err := sess.Allow("search", 3, time.Hour); err != nil || !ok {
+		t.Fatalf("Allow() of another action = %v, %v, wanted true", ok, err)
+	}
+	if _, err := sess.Allow("login", 3, 0); err == nil {
+		t.Fatal("Allow() with zero window wanted an error")
+	}
+}
+
+// TestAllowRateWindow checks that the count is reset in a new window of the session clock
+// and counters of ended windows are deleted.
+func TestAllowRateWindow(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0).Add(time.Hour))
+	sess := newMemorySession("sid", clock)
+	ok1, _ := sess.Allow("login", 1, time.Minute)
+	ok2, _ := sess.Allow("login", 1, time.Minute)
+	if !ok1 || ok2 {
+		t.Fatalf("Allow() in one window = %v, %v, wanted true, false", ok1, ok2)
+	}
+	if _, err := sess.Allow("search", 1, time.Second); err != nil {
+		t.Fatalf("Allow() failed: %v", err)
+	}
+	clock.Advance(time.Minute)
+	if ok, err := sess.Allow("login", 1, time.Minute); err != nil || !ok {
+		t.Fatalf("Allow() in a new window = %v, %v, wanted true", ok, err)
+	}
+	if len(sess.rates) != 1 {
+		t.Fatalf("%d counters are kept, wanted only the counter of the current window: %v", len(sess.rates), sess.rates)
+	}
+}
+
+// TestAllowRateValues checks that counters are kept apart from session values,
+// so they are not listed, exported or reset by Clear() and Restore(), of buckets as well.
+func TestAllowRateValues(t *testing.T) {
+	sess := newMemorySession("sid", nil)
+	if err := sess.Set("user", "name"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if _, err := sess.Allow("login", 1, time.Hour); err != nil {
+		t.Fatalf("Allow() failed: %v", err)
+	}
+	if _, err := sess.Bucket("api").Allow("call", 1, time.Hour); err != nil {
+		t.Fatalf("Allow() of bucket failed: %v", err)
+	}
+	keys, err := sess.Keys()
+	if err != nil {
+		t.Fatalf("Keys() failed: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "user" {
+		t.Fatalf("Keys() = %v, wanted only user value", keys)
+	}
+	values, err := sessionValues(sess)
+	if err != nil {
+		t.Fatalf("sessionValues() failed: %v", err)
+	}
+	if len(values) != 1 {
+		t.Fatalf("exported values = %v, wanted only user value", values)
+	}
+
+	if err := sess.Clear(); err != nil {
+		t.Fatalf("Clear() failed: %v", err)
+	}
+	if err := sess.Restore(values); err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+	if ok, err := sess.Allow("login", 1, time.Hour); err != nil || ok {
+		t.Fatalf("Allow() after Clear() and Restore() = %v, %v, wanted false", ok, err)
+	}
+	if ok, err := sess.Bucket("api").Allow("call", 1, time.Hour); err != nil || ok {
+		t.Fatalf("Allow() of bucket after Clear() and Restore() = %v, %v, wanted false", ok, err)
+	}
+}
@@ -0,0 +1,121 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Reconnector is an optional interface for providers able to open their connections again
+// with parameters of InitProvider(), e.g. after the sqlite database file is replaced.
+// Providers with clients reconnecting by themselves, e.g. redis, do not need it.
+type Reconnector interface {
+	IsFatal(err error) bool              //connections are unusable till Reconnect()
+	Reconnect(ctx context.Context) error //opens connections again
+}
+
+// RecoveryHook is called when provider storage is reachable again,
+// err is the first failure and down is the time since it.
+type RecoveryHook func(err error, down time.Duration)
+
+// OnRecovered registers a callback called when provider storage is reachable again
+// after failed checks of the monitor started with SetReconnect().
+func (manager *Manager) OnRecovered(fn RecoveryHook) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.hooks.recovered = append(manager.hooks.recovered, fn)
+}
+
+// SetReconnect starts monitor checking provider storage with Ping() every interval.
+// While checks fail they are repeated with backoff delays, every interval if backoff is nil,
+// and if provider implements Reconnector and the error is fatal its connections are opened again.
+// Recovery is logged with the logger set with SetLogger() and reported to OnRecovered() callbacks.
+// Interval 0 stops the monitor, it is stopped by Close() as well.
+func (manager *Manager) SetReconnect(interval time.Duration, backoff Backoff) {
+	manager.stopReconnect()
+	if interval <= 0 {
+		return
+	}
+	var ctx context.Context
+	ctx, manager.reconnectCancel = context.WithCancel(context.Background())
+	go manager.monitor(ctx, interval, backoff)
+}
+
+// stopReconnect stops provider monitor.
+func (manager *Manager) stopReconnect() {
+	if manager.reconnectCancel != nil {
+		manager.reconnectCancel()
+		manager.reconnectCancel = nil
+	}
+}
+
+// monitor checks provider till ctx is done.
+func (manager *Manager) monitor(ctx context.Context, interval time.Duration, backoff Backoff) {
+	var down_err error //first failure, nil if provider is up
+	var down_since time.Time
+	attempt := 0
+	delay := interval
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		failure, err := manager.checkProvider(ctx, interval)
+		if ctx.Err() != nil {
+			return
+		}
+		if failure != nil && down_err == nil {
+			down_err = failure
+			down_since = time.Now()
+		}
+		if err == nil {
+			if down_err != nil {
+				manager.recovered(down_err, time.Since(down_since))
+				down_err = nil
+			}
+			attempt = 0
+			delay = interval
+			continue
+		}
+		attempt++
+		if backoff != nil {
+			delay = backoff(attempt)
+		}
+		if manager.logger != nil {
+			manager.logger.Error("provider check failed", LOG_KEY_OPERATION, "Reconnect", "attempt", attempt, LOG_KEY_ERROR, err)
+		}
+	}
+}
+
+// checkProvider pings provider, connections are opened again on fatal errors.
+// failure is the ping error, err is not nil if provider is still unusable.
+func (manager *Manager) checkProvider(ctx context.Context, timeout time.Duration) (failure, err error) {
+	ping_ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	failure = manager.provider.Ping(ping_ctx)
+	if failure == nil {
+		return nil, nil
+	}
+	reconnector, ok := manager.provider.(Reconnector)
+	if !ok || !reconnector.IsFatal(failure) {
+		return failure, failure
+	}
+	if err := reconnector.Reconnect(ping_ctx); err != nil {
+		return failure, errors.Join(failure, err)
+	}
+	return failure, manager.provider.Ping(ping_ctx)
+}
+
+// recovered logs recovery and calls recovery hooks.
+func (manager *Manager) recovered(err error, down time.Duration) {
+	if manager.logger != nil {
+		manager.logger.Info("provider recovered", LOG_KEY_OPERATION, "Reconnect", LOG_KEY_DURATION, down, LOG_KEY_ERROR, err)
+	}
+	manager.lock.Lock()
+	hooks := manager.hooks.recovered
+	manager.lock.Unlock()
+	for _, fn := range hooks {
+		fn(err, down)
+	}
+}
@@ -0,0 +1,105 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dronm/session"
+	"github.com/redis/go-redis/v9"
+)
+
+// Config holds provider parameters. It is passed to InitProvider() as the only parameter
+// instead of positional parameters:
+//
+//	session.NewManager("redis", 0, 3600, "", redis.Config{URL: "redis://localhost:6379/0", Namespace: "myapp", PoolSize: 20})
+//
+// An already configured client of the application (shared pool, TLS, instrumentation) is passed in Client
+// instead of URL. It is not closed by CloseProvider(), the application closes it.
+// Keys are found with SCAN, which visits one node only with a cluster client, so cluster clients are not supported.
+type Config struct {
+	URL       string                //redis://<user>:<pass>@localhost:6379/<db>, required if Client is not set
+	Client    redis.UniversalClient //existing client used instead of URL
+	Namespace string                //key prefix
+	Mode      string                //storage mode, MODE_KEYS or MODE_HASH, MODE_KEYS if empty
+	TLS       *session.TLSConfig    //TLS of the connection, overrides TLS of rediss:// URL, not used with Client
+
+	//Connection pool parameters are not used with Client, zero values keep client defaults
+	//(10 connections per CPU, no idle connections kept open, 5s dial timeout, 3s read and write timeouts)
+	//or values of URL parameters, e.g. redis://localhost/0?pool_size=20&dial_timeout=1s.
+	PoolSize     int           //max connections
+	MinIdleConns int           //idle connections kept open
+	DialTimeout  time.Duration //timeout of opening a connection
+	ReadTimeout  time.Duration //timeout of reading a command reply
+	WriteTimeout time.Duration //timeout of writing a command
+}
+
+// applyPool sets non zero connection pool parameters to client options.
+func (cfg Config) applyPool(opts *redis.Options) {
+	set_int := func(v int, opt *int) {
+		if v > 0 {
+			*opt = v
+		}
+	}
+	set_dur := func(v time.Duration, opt *time.Duration) {
+		if v > 0 {
+			*opt = v
+		}
+	}
+	set_int(cfg.PoolSize, &opts.PoolSize)
+	set_int(cfg.MinIdleConns, &opts.MinIdleConns)
+	set_dur(cfg.DialTimeout, &opts.DialTimeout)
+	set_dur(cfg.ReadTimeout, &opts.ReadTimeout)
+	set_dur(cfg.WriteTimeout, &opts.WriteTimeout)
+}
+
+// poolSet returns true if any connection pool parameter is set.
+func (cfg Config) poolSet() bool {
+	return cfg.PoolSize != 0 || cfg.MinIdleConns != 0 || cfg.DialTimeout != 0 || cfg.ReadTimeout != 0 || cfg.WriteTimeout != 0
+}
+
+// configOf returns config from InitProvider() parameters: a Config or positional parameters.
+func configOf(provParams []interface{}) (Config, error) {
+	if len(provParams) == 1 {
+		if cfg, ok := provParams[0].(Config); ok {
+			if cfg.URL == "" && cfg.Client == nil {
+				return Config{}, errors.New("InitProvider Config.URL or Config.Client must be set")
+			}
+			if cfg.URL != "" && cfg.Client != nil {
+				return Config{}, errors.New("InitProvider Config.URL and Config.Client must not be set both")
+			}
+			if cfg.Mode != "" && cfg.Mode != MODE_KEYS && cfg.Mode != MODE_HASH {
+				return Config{}, fmt.Errorf("InitProvider Config.Mode must be %q or %q", MODE_KEYS, MODE_HASH)
+			}
+			if cfg.Client != nil && (cfg.poolSet() || cfg.TLS != nil) {
+				return Config{}, errors.New("InitProvider connection pool parameters and Config.TLS are not used with Config.Client")
+			}
+			if cfg.PoolSize < 0 || cfg.MinIdleConns < 0 {
+				return Config{}, errors.New("InitProvider Config.PoolSize and Config.MinIdleConns must not be negative")
+			}
+			if cfg.DialTimeout < 0 || cfg.ReadTimeout < 0 || cfg.WriteTimeout < 0 {
+				return Config{}, errors.New("InitProvider Config timeouts must not be negative")
+			}
+			return cfg, nil
+		}
+	}
+	if len(provParams) < 2 {
+		return Config{}, errors.New("InitProvider missing parameters: <redis connection string>, <redis namespace>")
+	}
+
+	var cfg Config
+	var ok bool
+	if cfg.URL, ok = provParams[0].(string); !ok {
+		return Config{}, errors.New("InitProvider redis connection parameter(0) must be a string")
+	}
+	if cfg.Namespace, ok = provParams[1].(string); !ok {
+		return Config{}, errors.New("InitProvider redis namespace parameter(1) must be a string")
+	}
+	if len(provParams) >= 3 {
+		cfg.Mode, ok = provParams[2].(string)
+		if !ok || (cfg.Mode != MODE_KEYS && cfg.Mode != MODE_HASH) {
+			return Config{}, fmt.Errorf("InitProvider redis storage mode parameter(2) must be %q or %q", MODE_KEYS, MODE_HASH)
+		}
+	}
+	return cfg, nil
+}
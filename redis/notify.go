@@ -0,0 +1,96 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/dronm/session"
+)
+
+// KEYSPACE_EVENTS is notify-keyspace-events server setting required by SetChangedHook():
+// keyspace events of generic, string and hash commands, expired and evicted keys.
+const KEYSPACE_EVENTS = "Kg$hxe"
+
+// SetChangedHook implements session.ChangeNotifier with redis keyspace notifications,
+// which must be enabled on the server, e.g. CONFIG SET notify-keyspace-events Kg$hxe.
+// In keys mode sessions with modified values and removed sessions are reported, in hash mode
+// only removed sessions are reported, as the hash is modified on every read with access time.
+// Changes made by the application instance itself are reported as well.
+func (pder *Provider) SetChangedHook(hook session.SessionHook) {
+	pder.hookMx.Lock()
+	pder.changedHook = hook
+	pder.hookMx.Unlock()
+	pder.restartSubscription()
+}
+
+// sessionChanged calls changed hook if it is set.
+func (pder *Provider) sessionChanged(sid string) {
+	pder.hookMx.Lock()
+	hook := pder.changedHook
+	pder.hookMx.Unlock()
+	if hook != nil {
+		hook(sid)
+	}
+}
+
+// restartSubscription closes keyspace subscription and subscribes again
+// if changed hook is set and provider is initialized.
+func (pder *Provider) restartSubscription() {
+	pder.stopSubscription()
+	pder.hookMx.Lock()
+	defer pder.hookMx.Unlock()
+	if pder.changedHook == nil || pder.client == nil {
+		return
+	}
+	pattern := "__keyspace@" + strconv.Itoa(pder.db) + "__:" + pder.namespace + ":*"
+	pder.pubsub = pder.client.PSubscribe(context.Background(), pattern)
+	ch := pder.pubsub.Channel() //closed with pubsub, reconnects are handled by the client
+	go func() {
+		for msg := range ch {
+			if sid, ok := pder.changedSession(msg.Channel, msg.Payload); ok {
+				pder.sessionChanged(sid)
+			}
+		}
+	}()
+}
+
+func (pder *Provider) stopSubscription() {
+	pder.hookMx.Lock()
+	defer pder.hookMx.Unlock()
+	if pder.pubsub != nil {
+		pder.pubsub.Close()
+		pder.pubsub = nil
+	}
+}
+
+// changedSession returns session ID of keyspace notification channel,
+// ok is false if event does not change the session.
+func (pder *Provider) changedSession(channel, event string) (sid string, ok bool) {
+	_, key, found := strings.Cut(channel, "__:")
+	if !found {
+		return "", false
+	}
+	if key, found = strings.CutPrefix(key, pder.namespace+":"); !found {
+		return "", false
+	}
+	if event == "expire" || event == "persist" {
+		//time to live is changed on access
+		return "", false
+	}
+	if pder.hashMode {
+		if event != "del" && event != "expired" && event != "evicted" {
+			return "", false
+		}
+		if strings.Contains(key, ":") {
+			//lock key namespace:sid:__lock is out of the hash
+			return "", false
+		}
+		return key, true
+	}
+	sid, val_key, found := strings.Cut(key, ":")
+	if !found || val_key == LOCK_KEY || val_key == KEY_TIME_ACCESSED || val_key == KEY_TIME_EXPIRES {
+		return "", false
+	}
+	return sid, true
+}
@@ -2,307 +2,2038 @@
 // Requirements:
 //
 //	redis client https://github.com/redis/go-redis
+//
+// Two storage modes are supported:
+//
+//	MODE_KEYS (default): every session value is kept in its own key namespace:sid:key
+//	MODE_HASH: all session values are kept in one hash namespace:sid, values are hash fields.
+//		Session is read with one HGETALL, expiration is set on the hash and session is destroyed with one DEL.
 package redis
 
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/gob"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dronm/session"
 	"github.com/redis/go-redis/v9"
 )
 
-var EKeyNotFound = errors.New("key not found")
+// Deprecated: use session.ErrKeyNotFound.
+var EKeyNotFound = session.ErrKeyNotFound
 
 const PROVIDER = "redis"
 
 // Session key ID length.
 const SESS_ID_LEN = 36
 
+// Max transaction attempts for atomic value updates.
+const INCR_MAX_RETRIES = 100
+
+// Max number of keys removed with one UNLINK command.
+const UNLINK_BATCH_SIZE = 500
+
+// Default COUNT hint of SCAN commands run by GC.
+const GC_SCAN_COUNT = 100
+
+// GC leader lock key is namespace+GC_LEADER_KEY+lock name, it is out of session keys namespace:*.
+const GC_LEADER_KEY = "_gc_leader:"
+
+// User index keys, out of session keys namespace:* as well, see userIndex:
+// namespace+USERS_KEY+user ID is a sorted set of user session IDs scored by bind time,
+// namespace+USER_ID_KEY+session ID holds user ID of the session.
+const (
+	USERS_KEY   = "_users:"
+	USER_ID_KEY = "_user_id:"
+)
+
+// Rate counter keys namespace+RATE_KEY+sid:action:window are out of session keys namespace:* as well,
+// see SessionStore.Allow().
+const RATE_KEY = "_rate:"
+
 const LOG_PREF = "redis provider:"
 
-// pder holds pointer to Provider struct.
-var pder = &Provider{}
+// pder holds pointer to Provider struct registered as PROVIDER.
+var pder = NewProvider()
+
+// NewProvider returns a provider independent of the registered one, e.g. to use another redis server or namespace
+// in another Manager created with session.NewManagerWithProvider().
+func NewProvider() *Provider {
+	return &Provider{gcScanCount: GC_SCAN_COUNT, clock: session.SystemClock{}}
+}
+
+// Storage modes.
+const (
+	MODE_KEYS = "keys" //every value is a separate key namespace:sid:key
+	MODE_HASH = "hash" //all session values are kept in one hash namespace:sid
+)
+
+// Service keys, stored as namespace:sid:KEY (or hash fields) along with session values.
+const (
+	LOCK_KEY          = "__lock"
+	KEY_TIME_ACCESSED = "time_accessed"
+	KEY_TIME_CREATED  = "time_created"
+	KEY_TIME_EXPIRES  = "time_expires" //set by SessionStore.SetExpiry()
+)
+
+// isServiceKey returns true for keys not holding session values.
+func isServiceKey(key string) bool {
+	return key == LOCK_KEY || key == KEY_TIME_ACCESSED || key == KEY_TIME_CREATED || key == KEY_TIME_EXPIRES
+}
+
+// unlockScript deletes lock key only if it is owned by the caller.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
 
-// SessionStore contains session id.
+// SessionStore contains session id and session values read by SessionRead().
+// Values are written to redis at once, read values are kept in memory,
+// so Get() does not hit redis, values set by other processes are read with the next SessionRead() or Lock().
 type SessionStore struct {
-	sid string
+	sid       string
+	pder      *Provider //provider storing the session
+	lockToken string    //set when session is locked
+	expiresAt time.Time //set by SetExpiry(), zero if not set
+	mx        sync.Mutex
+	values    map[string][]byte //encoded values by key, nil if not read, values are read from redis then
+	touched   bool              //access time is updated on read
+
+	expires map[string]time.Time //expiration of values set by SetWithTTL() while values are read
 }
 
 // Set sets redis value, updates access time.
 func (st *SessionStore) Set(key string, value interface{}) error {
-	if err := pder.setValue(st.sid, key, value); err != nil {
+	if err := st.setValue(key, value); err != nil {
 		return err
 	}
 	return nil
 }
 
-// Set sets redis value, updates access time.
+// Put sets redis value and access time in one round trip.
 func (st *SessionStore) Put(key string, value interface{}) error {
-	if err := pder.setValue(st.sid, key, value); err != nil {
+	if !st.pder.expMode.TouchOnWrite() {
+		if err := st.setValue(key, value); err != nil {
+			return err
+		}
+		return st.accessed(false)
+	}
+	return st.setValues(map[string]interface{}{key: value, KEY_TIME_ACCESSED: st.pder.clock.Now()})
+}
+
+// SetWithTTL sets redis value expiring in ttl but not later than the session.
+// In keys mode the value key is set with ttl, in hash mode HPEXPIRE of the hash field is used,
+// it requires Redis 7.4 or later.
+func (st *SessionStore) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	val_b, err := st.pder.encodeValue(value)
+	if err != nil {
 		return err
 	}
-	return st.Flush()
+	return st.setEncodedValueTTL(key, val_b, ttl)
 }
 
-func (st *SessionStore) Flush() error {
-	pder.sessionAccessed(st.sid)
+// setEncodedValueTTL sets encoded value expiring in ttl, not later than the session.
+func (st *SessionStore) setEncodedValueTTL(key string, val_b []byte, ttl time.Duration) error {
+	sess_ttl := st.ttl()
+	if sess_ttl > 0 && sess_ttl < ttl {
+		ttl = sess_ttl
+	}
+	if err := st.pder.setEncodedValueTTL(st.sid, key, val_b, ttl, sess_ttl); err != nil {
+		return err
+	}
+	st.cache(key, val_b)
+	st.mx.Lock()
+	if st.values != nil {
+		if st.expires == nil {
+			st.expires = make(map[string]time.Time)
+		}
+		st.expires[key] = st.pder.clock.Now().Add(ttl)
+	}
+	st.mx.Unlock()
+	return nil
+}
+
+// SetSensitive sets value encrypted with keys of session.SetSensitiveKeys(), see session.SetSensitive().
+func (st *SessionStore) SetSensitive(key string, value interface{}) error {
+	return session.SetSensitive(st, key, value)
+}
+
+// SensitiveValues returns encrypted sensitive values by key, implements session.SensitiveSession.
+func (st *SessionStore) SensitiveValues() (map[string]session.SensitiveValue, error) {
+	st.mx.Lock()
+	values := st.values
+	if values != nil {
+		values = make(map[string][]byte, len(st.values))
+		for key, val_b := range st.values {
+			if !st.cachedExpired(key) {
+				values[key] = val_b
+			}
+		}
+	}
+	st.mx.Unlock()
+	if values == nil {
+		var err error
+		if values, err = st.pder.readValues(st.sid); err != nil {
+			return nil, err
+		}
+	}
+	sensitive := make(map[string]session.SensitiveValue)
+	for key, val_b := range values {
+		if isServiceKey(key) {
+			continue
+		}
+		if sv, ok := st.pder.sensitiveValue(val_b); ok {
+			sensitive[key] = sv
+		}
+	}
+	return sensitive, nil
+}
+
+// SetMany sets redis values in one round trip, see Put().
+func (st *SessionStore) SetMany(values map[string]interface{}) error {
+	if len(values) == 0 {
+		return nil
+	}
+	return st.setValues(values)
+}
+
+// GetMany assigns session values to dest pointers. Values not read by SessionRead()
+// are read with one HMGET or MGET, access time is updated once.
+func (st *SessionStore) GetMany(dest map[string]interface{}) error {
+	st.mx.Lock()
+	read := st.values != nil
+	st.mx.Unlock()
+	if read || len(dest) == 0 {
+		return session.GetMany(st, dest)
+	}
+
+	keys := make([]string, 0, len(dest))
+	for key := range dest {
+		keys = append(keys, key)
+	}
+	values, err := st.pder.readEncodedValues(st.sid, keys)
+	if err != nil {
+		return err
+	}
+	for key, val := range dest {
+		val_b, ok := values[key]
+		if !ok {
+			delete(dest, key)
+			continue
+		}
+		if err := st.pder.decodeValue(val_b, val); err != nil {
+			return err
+		}
+	}
+	return st.accessed(true)
+}
+
+// encodedValue is a snapshot value kept encoded as it is stored in redis.
+type encodedValue []byte
+
+// Snapshot returns session values encoded as they are stored in redis,
+// so the snapshot can only be restored into redis sessions with Restore().
+// Values read by SessionRead() are taken from memory, expired values are left out.
+// Values set with SetWithTTL() by this session store are wrapped in session.ExpiringValue,
+// TTLs of values read from redis are not known.
+func (st *SessionStore) Snapshot() (map[string]interface{}, error) {
+	st.mx.Lock()
+	values := st.values
+	expires := make(map[string]time.Time, len(st.expires))
+	if values != nil {
+		values = make(map[string][]byte, len(st.values))
+		for key, val_b := range st.values {
+			if !st.cachedExpired(key) {
+				values[key] = val_b
+			}
+		}
+		for key, exp := range st.expires {
+			expires[key] = exp
+		}
+	}
+	st.mx.Unlock()
+	if values == nil {
+		var err error
+		if values, err = st.pder.readValues(st.sid); err != nil {
+			return nil, err
+		}
+	}
+	snapshot := make(map[string]interface{}, len(values))
+	for key, val_b := range values {
+		if isServiceKey(key) {
+			continue
+		}
+		if exp, ok := expires[key]; ok {
+			snapshot[key] = session.ExpiringValue{Value: encodedValue(val_b), Expires: exp.UnixNano()}
+			continue
+		}
+		snapshot[key] = encodedValue(val_b)
+	}
+	return snapshot, nil
+}
+
+// Restore deletes values missing in snapshot and sets snapshot values in one round trip.
+// Values returned by Snapshot() are written as they are, other values are encoded.
+// session.ExpiringValue values are set with their left TTL as SetWithTTL() does, expired ones are deleted.
+func (st *SessionStore) Restore(snapshot map[string]interface{}) error {
+	keys, err := st.Keys()
+	if err != nil {
+		return err
+	}
+	deleted := make([]string, 0)
+	for _, key := range keys {
+		if _, ok := snapshot[key]; !ok {
+			deleted = append(deleted, key)
+		}
+	}
+	values := make(map[string]interface{}, len(snapshot))
+	expiring := make(map[string]session.ExpiringValue)
+	now := st.pder.clock.Now().UnixNano()
+	for key, val := range snapshot {
+		ev, ok := val.(session.ExpiringValue)
+		switch {
+		case !ok:
+			values[key] = val
+		case now >= ev.Expires:
+			deleted = append(deleted, key)
+		default:
+			expiring[key] = ev
+		}
+	}
+	if err := st.pder.delValues(st.sid, deleted...); err != nil {
+		return err
+	}
+	st.uncache(deleted...)
+	if len(values) > 0 {
+		if err := st.setValues(values); err != nil {
+			return err
+		}
+	}
+	for key, ev := range expiring {
+		val_b, ok := ev.Value.(encodedValue)
+		if !ok {
+			if val_b, err = st.pder.encodeValue(ev.Value); err != nil {
+				return err
+			}
+		}
+		if err := st.setEncodedValueTTL(key, val_b, time.Duration(ev.Expires-now)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (st *SessionStore) Flush() error {
+	return st.accessed(false)
+}
+
 // Get retrieves session value by its key.
 // If there is no key error is returned.
 func (st *SessionStore) Get(key string, val interface{}) error {
-	if err := pder.getValue(st.sid, key, val); err != nil {
+	if err := st.getValue(key, val); err != nil {
 		return err
 	}
 	return nil
 }
 
+// GetStruct retrieves session value by its key into dest pointer.
+// Value is decoded into dest, the same as Get() does.
+func (st *SessionStore) GetStruct(key string, dest interface{}) error {
+	return st.getValue(key, dest)
+}
+
 // GetBool returns bool value by key.
 func (st *SessionStore) GetBool(key string) bool {
 	var v bool
-	_ = pder.getValue(st.sid, key, &v)
+	_ = st.getValue(key, &v)
 	return v
 }
 
 // GetString returns string value by key.
 func (st *SessionStore) GetString(key string) string {
 	var v string
-	_ = pder.getValue(st.sid, key, &v)
+	_ = st.getValue(key, &v)
 	return v
 }
 
 // GetInt returns int value by key.
 func (st *SessionStore) GetInt(key string) int64 {
 	var v int64
-	pder.getValue(st.sid, key, &v)
+	st.getValue(key, &v)
 	return v
 }
 
 // GetFloat returns float value by key.
 func (st *SessionStore) GetFloat(key string) float64 {
 	var v float64
-	_ = pder.getValue(st.sid, key, &v)
+	_ = st.getValue(key, &v)
 	return v
 }
 
 // GetDate returns time.Time value by key.
 func (st *SessionStore) GetDate(key string) time.Time {
 	var v time.Time
-	_ = pder.getValue(st.sid, key, &v)
+	_ = st.getValue(key, &v)
 	return v
 }
 
-// Delete deletes session value from memmory by key.
-func (st *SessionStore) Delete(key string) error {
-	pder.client.Del(context.Background(), pder.getPrefixedKey(st.sid, key))
-	pder.sessionAccessed(st.sid)
-
-	return nil
+// GetBytes returns []byte value by key.
+func (st *SessionStore) GetBytes(key string) []byte {
+	var v []byte
+	_ = st.getValue(key, &v)
+	return v
 }
 
-// SessionID returns session unique ID.
-func (st *SessionStore) SessionID() string {
-	return st.sid
+// GetStringSlice returns []string value by key.
+func (st *SessionStore) GetStringSlice(key string) []string {
+	var v []string
+	_ = st.getValue(key, &v)
+	return v
 }
 
-// TimeCreated returns timeCreated property.
-func (st *SessionStore) TimeCreated() time.Time {
-	return st.GetDate("time_created")
+// GetOrSet assigns session value to dest, if there is no value
+// it is computed, stored and flushed under session lock, see session.GetOrSet().
+func (st *SessionStore) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	return session.GetOrSet(st, key, dest, compute)
 }
 
-// TimeCreated returns timeAccessed property.
-func (st *SessionStore) TimeAccessed() time.Time {
-	return st.GetDate("time_accessed")
-}
+// Delete deletes session value from memmory by key.
+func (st *SessionStore) Delete(key string) error {
+	st.pder.delValues(st.sid, key)
+	st.uncache(key)
+	st.accessed(false)
 
-// Provider structure holds provider information.
-type Provider struct {
-	client      *redis.Client
-	namespace   string //key prefix
-	maxLifeTime int64
-	maxIdleTime int64
+	return nil
 }
 
-// SessionInit initializes session with given ID.
-func (pder *Provider) SessionInit(sid string) (session.Session, error) {
-	if pder.client == nil {
-		return nil, errors.New("Provider not initialized")
+// Clear deletes all session values keeping session ID and creation time.
+func (st *SessionStore) Clear() error {
+	keys, err := st.Keys()
+	if err != nil {
+		return err
 	}
-
-	if len(sid) > SESS_ID_LEN {
-		return nil, errors.New("Session key length exceeded max value")
+	if err := st.pder.delValues(st.sid, keys...); err != nil {
+		return err
 	}
-
-	return &SessionStore{sid: sid}, nil
-}
-
-func (pder *Provider) SessionRead(sid string) (session.Session, error) {
-	return &SessionStore{sid: sid}, nil
+	st.uncache(keys...)
+	return st.accessed(false)
 }
 
-// SessionClose is a stub
-func (pder *Provider) SessionClose(sid string) error {
-	return nil
+// Increment atomically adds delta to integer session value and returns the new value.
+// Missing value is treated as 0. Optimistic WATCH/MULTI transaction is used,
+// it is retried up to INCR_MAX_RETRIES times on concurrent modification.
+func (st *SessionStore) Increment(key string, delta int64) (int64, error) {
+	ctx := context.Background()
+	redis_key := st.pder.getPrefixedKey(st.sid, key)
+	if st.pder.hashMode {
+		redis_key = st.pder.getSessionKey(st.sid)
+	}
+	var res int64
+	var new_b []byte
+	txf := func(tx *redis.Tx) error {
+		var cur int64
+		var val_b []byte
+		var err error
+		if st.pder.hashMode {
+			val_b, err = tx.HGet(ctx, redis_key, key).Bytes()
+		} else {
+			val_b, err = tx.Get(ctx, redis_key).Bytes()
+		}
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if err == nil {
+			if err := st.pder.decodeValue(val_b, &cur); err != nil {
+				return fmt.Errorf("%w: %v", session.ErrTypeMismatch, err)
+			}
+		}
+		res = cur + delta
+		if new_b, err = st.pder.encodeValue(res); err != nil {
+			return err
+		}
+		ttl := st.ttl()
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if st.pder.hashMode {
+				pipe.HSet(ctx, redis_key, key, new_b)
+				if ttl > 0 {
+					pipe.Expire(ctx, redis_key, ttl)
+				}
+			} else {
+				pipe.Set(ctx, redis_key, new_b, ttl)
+			}
+			return nil
+		})
+		return err
+	}
+	for i := 0; i < INCR_MAX_RETRIES; i++ {
+		err := st.pder.client.Watch(ctx, txf, redis_key)
+		if err == redis.TxFailedErr {
+			continue //value modified concurrently
+		} else if err != nil {
+			return 0, err
+		}
+		st.cache(key, new_b)
+		return res, st.accessed(false)
+	}
+	return 0, errors.New("Increment: max retries exceeded")
 }
 
-// SessionDestroy destoys session by its ID.
-func (pder *Provider) SessionDestroy(sid string) error {
-	return pder.removeSession(sid)
+// Decrement atomically subtracts delta from integer session value and returns the new value.
+func (st *SessionStore) Decrement(key string, delta int64) (int64, error) {
+	return st.Increment(key, -delta)
 }
 
-// SessionGC removes unused sessions.
-// Handle max idle time only.
-// Max life time is controled by REDIS.
-func (pder *Provider) SessionGC(l io.Writer, logLev session.LogLevel) {
-	//life time is controled by radis
-	if pder.maxIdleTime == 0 {
-		return
-	}
+// CompareAndSwap sets newValue if the stored value equals oldValue.
+// Value key is watched with WATCH, the operation is retried if it is modified concurrently.
+// Stored value is decoded to oldValue type for comparison, see session.Session.CompareAndSwap().
+// If the value is not swapped, the stored value is read into session store, so it can be retried with Get().
+func (st *SessionStore) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
 	ctx := context.Background()
-	iter := pder.client.Scan(ctx, 0, pder.namespace+":*:time_accessed", 0).Iterator()
-	tm := time.Now().Unix()
-	for iter.Next(ctx) {
-		var t time.Time
-		key := iter.Val()
-		if err := pder.getValueForKey(key, &t); err != nil {
-			if l != nil {
-				session.WriteToLog(l, fmt.Sprintf(LOG_PREF+"pder.getValueForKey() failed for key %s: %v", key, err), session.LOG_LEVEL_ERROR)
+	redis_key := st.pder.getPrefixedKey(st.sid, key)
+	if st.pder.hashMode {
+		redis_key = st.pder.getSessionKey(st.sid)
+	}
+	var new_b []byte
+	if newValue != nil {
+		var err error
+		if new_b, err = st.pder.encodeValue(newValue); err != nil {
+			return false, err
+		}
+	}
+	var swapped bool
+	var val_b []byte //stored value
+	txf := func(tx *redis.Tx) error {
+		swapped = false
+		var err error
+		if st.pder.hashMode {
+			val_b, err = tx.HGet(ctx, redis_key, key).Bytes()
+		} else {
+			val_b, err = tx.Get(ctx, redis_key).Bytes()
+		}
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if err == redis.Nil || oldValue == nil {
+			if (err == redis.Nil) != (oldValue == nil) {
+				return nil
+			}
+		} else {
+			cur := reflect.New(reflect.TypeOf(oldValue))
+			if err := st.pder.decodeValue(val_b, cur.Interface()); err != nil ||
+				!reflect.DeepEqual(cur.Elem().Interface(), oldValue) {
+				return nil
 			}
-			continue
 		}
-		if t.Unix()+pder.maxIdleTime <= tm {
-			sess_keys := strings.Replace(key, "time_accessed", "*", 1)
-			if l != nil && logLev >= session.LOG_LEVEL_DEBUG {
-				session.WriteToLog(l, LOG_PREF+"SessionGC(): deleting keys on pattern: "+sess_keys, session.LOG_LEVEL_DEBUG)
+		ttl := st.ttl()
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			switch {
+			case st.pder.hashMode && newValue == nil:
+				pipe.HDel(ctx, redis_key, key)
+			case st.pder.hashMode:
+				pipe.HSet(ctx, redis_key, key, new_b)
+				if ttl > 0 {
+					pipe.Expire(ctx, redis_key, ttl)
+				}
+			case newValue == nil:
+				pipe.Unlink(ctx, redis_key)
+			default:
+				pipe.Set(ctx, redis_key, new_b, ttl)
 			}
-			pder.removeOnPattern(sess_keys)
+			return nil
+		})
+		swapped = err == nil
+		return err
+	}
+	for i := 0; i < INCR_MAX_RETRIES; i++ {
+		err := st.pder.client.Watch(ctx, txf, redis_key)
+		if err == redis.TxFailedErr {
+			continue //value modified concurrently
+		} else if err != nil {
+			return false, err
 		}
+		//read value is refreshed with the stored one if it is not swapped
+		switch {
+		case swapped && newValue == nil, !swapped && len(val_b) == 0:
+			st.uncache(key)
+		case swapped:
+			st.cache(key, new_b)
+		default:
+			st.cache(key, val_b)
+		}
+		return swapped, st.accessed(false)
 	}
+	return false, errors.New("CompareAndSwap: max retries exceeded")
 }
 
-func (pder *Provider) DestroyAllSessions(l io.Writer, logLev session.LogLevel) {
-	sess_keys := pder.namespace + ":*"
-	if l != nil && logLev >= session.LOG_LEVEL_DEBUG {
-		session.WriteToLog(l, LOG_PREF+"DestroyAllSessions(): deleting keys on pattern: "+sess_keys, session.LOG_LEVEL_DEBUG)
+// ValueSizes returns encoded value sizes by key, implements session.SizedSession.
+func (st *SessionStore) ValueSizes() (map[string]int, error) {
+	st.mx.Lock()
+	if st.values != nil {
+		sizes := make(map[string]int, len(st.values))
+		for key, val_b := range st.values {
+			if !isServiceKey(key) {
+				sizes[key] = len(val_b)
+			}
+		}
+		st.mx.Unlock()
+		return sizes, nil
 	}
-	pder.removeOnPattern(sess_keys)
-}
-
-func (pder *Provider) SetMaxLifeTime(maxLifeTime int64) {
-	pder.maxLifeTime = maxLifeTime
-}
-func (pder *Provider) GetMaxLifeTime() int64 {
-	return pder.maxLifeTime
-}
+	st.mx.Unlock()
 
-func (pder *Provider) SetMaxIdleTime(maxIdleTime int64) {
-	pder.maxIdleTime = maxIdleTime
-}
-
-func (pder *Provider) GetMaxIdleTime() int64 {
-	return pder.maxIdleTime
+	keys, err := st.Keys()
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	cmds := make([]*redis.Cmd, len(keys))
+	if _, err := st.pder.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			if st.pder.hashMode {
+				cmds[i] = pipe.Do(ctx, "HSTRLEN", st.pder.getSessionKey(st.sid), key)
+			} else {
+				cmds[i] = pipe.Do(ctx, "STRLEN", st.pder.getPrefixedKey(st.sid, key))
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int, len(keys))
+	for i, key := range keys {
+		if sizes[key], err = cmds[i].Int(); err != nil {
+			return nil, err
+		}
+	}
+	return sizes, nil
 }
 
-func (pder *Provider) CloseProvider() {
+// Bucket returns a view of the session with keys prefixed by name, see session.NewBucket().
+func (st *SessionStore) Bucket(name string) session.Session {
+	return session.NewBucket(st, name)
 }
 
-// InitProvider initializes postgresql provider.
-// Function expects two parameters:
-//
-//	0 parameter: Redis url string, redis://<user>:<pass>@localhost:6379/<db>
-//	1 parameter: redis namespace (username)
-func (pder *Provider) InitProvider(provParams []interface{}) error {
-	if len(provParams) < 2 {
-		return errors.New("InitProvider missing parameters: <redis connection string>, <redis namespace>")
-	}
-	conn_url, ok := provParams[0].(string)
-	if !ok {
-		return errors.New("InitProvider redis connection parameter(0) must be a string")
+// Keys returns sorted session value keys.
+// Keys are retrieved with SCAN command or with HKEYS in hash mode.
+func (st *SessionStore) Keys() ([]string, error) {
+	st.mx.Lock()
+	if st.values != nil {
+		keys := make([]string, 0, len(st.values))
+		for key := range st.values {
+			if !isServiceKey(key) && !st.cachedExpired(key) {
+				keys = append(keys, key)
+			}
+		}
+		st.mx.Unlock()
+		sort.Strings(keys)
+		return keys, nil
 	}
+	st.mx.Unlock()
 
-	pder.namespace, ok = provParams[1].(string)
-	if !ok {
-		return errors.New("InitProvider redis namespace parameter(1) must be a string")
+	ctx := context.Background()
+	if st.pder.hashMode {
+		fields, err := st.pder.client.HKeys(ctx, st.pder.getSessionKey(st.sid)).Result()
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(fields))
+		for _, key := range fields {
+			if !isServiceKey(key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		return keys, nil
 	}
-
-	redis_opts, err := redis.ParseURL(conn_url)
-	if err != nil {
-		return err
+	prefix := st.pder.getPrefixedKey(st.sid, "")
+	keys := make([]string, 0)
+	iter := st.pder.client.Scan(ctx, 0, prefix+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		key := strings.TrimPrefix(iter.Val(), prefix)
+		if !isServiceKey(key) {
+			keys = append(keys, key)
+		}
 	}
-	pder.client = redis.NewClient(redis_opts)
-	if _, err := pder.client.Ping(context.Background()).Result(); err != nil {
-		return err
+	if err := iter.Err(); err != nil {
+		return nil, err
 	}
-
-	return nil
+	sort.Strings(keys)
+	return keys, nil
 }
 
-func (pder *Provider) GetSessionIDLen() int {
-	return SESS_ID_LEN
+// Len returns number of session values.
+func (st *SessionStore) Len() (int, error) {
+	keys, err := st.Keys()
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
 }
 
-// removeSession removes all values with keys sess:SESSION_ID:*
-// helper function for SessionDestroy and SessionGC
-func (pder *Provider) removeSession(sid string) error {
-	return pder.removeOnPattern(pder.getPrefixedKey(sid, "*"))
-}
+// SetExpiry sets session expiration time to now+d: TTL of all session keys is set to d
+// and is kept on later writes, idle time is not checked by GC. d <= 0 restores defaults.
+func (st *SessionStore) SetExpiry(d time.Duration) error {
+	if d > 0 {
+		st.expiresAt = st.pder.clock.Now().Add(d)
+		if err := st.setValue(KEY_TIME_EXPIRES, st.expiresAt); err != nil {
+			return err
+		}
+	} else {
+		st.expiresAt = time.Time{}
+		if err := st.pder.delValues(st.sid, KEY_TIME_EXPIRES); err != nil {
+			return err
+		}
+		st.uncache(KEY_TIME_EXPIRES)
+	}
 
-// removeOnKey removes all kes on pattern
-func (pder *Provider) removeOnPattern(pattern string) error {
 	ctx := context.Background()
-	iter := pder.client.Scan(ctx, 0, pattern, 0).Iterator()
-	for iter.Next(ctx) {
-		if err := pder.client.Del(ctx, iter.Val()).Err(); err != nil {
+	var redis_keys []string
+	if st.pder.hashMode {
+		redis_keys = []string{st.pder.getSessionKey(st.sid)}
+	} else {
+		iter := st.pder.client.Scan(ctx, 0, st.pder.getPrefixedKey(st.sid, "*"), 0).Iterator()
+		for iter.Next(ctx) {
+			if iter.Val() != st.pder.getPrefixedKey(st.sid, LOCK_KEY) {
+				redis_keys = append(redis_keys, iter.Val())
+			}
+		}
+		if err := iter.Err(); err != nil {
 			return err
 		}
 	}
-	return iter.Err()
+	ttl := st.ttl()
+	_, err := st.pder.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, redis_key := range redis_keys {
+			if ttl > 0 {
+				pipe.Expire(ctx, redis_key, ttl)
+			} else {
+				pipe.Persist(ctx, redis_key)
+			}
+		}
+		return nil
+	})
+	return err
 }
 
-// protected
-func (pder *Provider) sessionAccessed(sid string) error {
-	return pder.setValue(sid, "time_accessed", time.Now())
+// Touch updates session access time,
+// nothing is done in session.EXPIRATION_FIXED mode.
+func (st *SessionStore) Touch() error {
+	if !st.pder.expMode.TouchOnWrite() {
+		return nil
+	}
+	return st.accessed(false)
 }
 
-func (pder *Provider) getValue(sid, key string, t interface{}) error {
-	if err := pder.getValueForKey(pder.getPrefixedKey(sid, key), t); err != nil {
-		return err
+// Allow counts action in the current fixed window with INCR of key namespace+RATE_KEY+sid:action:window
+// expiring with the window, see session.AllowRate(). Counters are not session values.
+func (st *SessionStore) Allow(action string, limit int, window time.Duration) (bool, error) {
+	if window <= 0 {
+		return false, errors.New("session: rate window must be positive")
 	}
-	pder.sessionAccessed(sid)
-	return nil
+	win := st.pder.clock.Now().UnixNano() / int64(window)
+	key := fmt.Sprintf("%s%s%s:%s:%d", st.pder.namespace, RATE_KEY, st.sid, action, win)
+	ctx := context.Background()
+	var incr *redis.IntCmd
+	if _, err := st.pder.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.PExpire(ctx, key, window)
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	return incr.Val() <= int64(limit), nil
 }
 
-func (pder *Provider) getValueForKey(redisKey string, t interface{}) error {
-	val_b, err := pder.client.Get(context.Background(), redisKey).Bytes()
-	if err != nil {
-		return err
+// ttl returns time to live for session keys: time left till expiration
+// set with SetExpiry() or max life time, 0 means no expiration.
+func (st *SessionStore) ttl() time.Duration {
+	if !st.expiresAt.IsZero() {
+		if ttl := st.expiresAt.Sub(st.pder.clock.Now()); ttl > 0 {
+			return ttl
+		}
+		return time.Millisecond //expired
 	}
-	if len(val_b) == 0 {
-		return EKeyNotFound //no value found
+	return time.Duration(st.pder.maxLifeTime) * time.Second
+}
+
+// accessed updates session access time on session write or read depending on expiration mode.
+// In session.EXPIRATION_FIXED mode access time is written once on the first write,
+// idle time is counted from it.
+func (st *SessionStore) accessed(read bool) error {
+	switch {
+	case read && st.pder.expMode.TouchOnRead(), !read && st.pder.expMode.TouchOnWrite():
+		return st.setValue(KEY_TIME_ACCESSED, st.pder.clock.Now())
+	case read || st.pder.expMode != session.EXPIRATION_FIXED:
+		return nil
 	}
-	dec := gob.NewDecoder(bytes.NewBuffer(val_b))
-	if err := dec.Decode(t); err != nil {
+	st.mx.Lock()
+	_, ok := st.values[KEY_TIME_ACCESSED]
+	st.mx.Unlock()
+	if ok {
+		return nil
+	}
+	val_b, err := st.pder.encodeValue(st.pder.clock.Now())
+	if err != nil {
 		return err
 	}
+	set, err := st.pder.setEncodedValueNX(st.sid, KEY_TIME_ACCESSED, val_b, st.ttl())
+	if err == nil && set {
+		st.cache(KEY_TIME_ACCESSED, val_b)
+	}
+	return err
+}
+
+// getValue reads session value and updates session access time.
+// Values read by SessionRead() are decoded from memory, access time is updated once then.
+func (st *SessionStore) getValue(key string, t interface{}) error {
+	st.mx.Lock()
+	if st.values != nil {
+		val_b, ok := st.values[key]
+		if ok && st.cachedExpired(key) {
+			ok = false
+		}
+		touched := st.touched
+		st.touched = true
+		st.mx.Unlock()
+		if !ok {
+			return session.ErrKeyNotFound
+		}
+		if err := st.pder.decodeValue(val_b, t); err != nil {
+			return err
+		}
+		if !touched {
+			st.accessed(true)
+		}
+		return nil
+	}
+	st.mx.Unlock()
+
+	if err := st.pder.readValue(st.sid, key, t); err == redis.Nil {
+		return session.ErrKeyNotFound
+	} else if err != nil {
+		return err
+	}
+	st.accessed(true)
+	return nil
+}
+
+// setValue sets session value with session TTL.
+func (st *SessionStore) setValue(key string, val interface{}) error {
+	return st.setValues(map[string]interface{}{key: val})
+}
+
+// setValues sets session values with session TTL in one round trip, encodedValue values are written as they are.
+func (st *SessionStore) setValues(values map[string]interface{}) error {
+	encoded := make(map[string][]byte, len(values))
+	for key, val := range values {
+		if val_b, ok := val.(encodedValue); ok {
+			encoded[key] = val_b
+			continue
+		}
+		val_b, err := st.pder.encodeValue(val)
+		if err != nil {
+			return err
+		}
+		encoded[key] = val_b
+	}
+	if err := st.pder.setEncodedValues(st.sid, encoded, st.ttl()); err != nil {
+		return err
+	}
+	for key, val_b := range encoded {
+		st.cache(key, val_b)
+	}
+	return nil
+}
+
+// cache keeps written value if values are read.
+func (st *SessionStore) cache(key string, val_b []byte) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if st.values != nil {
+		st.values[key] = val_b
+		delete(st.expires, key)
+	}
+}
+
+// uncache removes deleted values.
+func (st *SessionStore) uncache(keys ...string) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	for _, key := range keys {
+		delete(st.values, key)
+		delete(st.expires, key)
+	}
+}
+
+// cachedExpired returns true if value read by SessionRead() is set by SetWithTTL() and expired,
+// mx must be locked.
+func (st *SessionStore) cachedExpired(key string) bool {
+	exp, ok := st.expires[key]
+	return ok && !st.pder.clock.Now().Before(exp)
+}
+
+// SessionID returns session unique ID.
+func (st *SessionStore) SessionID() string {
+	return st.sid
+}
+
+// TimeCreated returns timeCreated property.
+func (st *SessionStore) TimeCreated() time.Time {
+	return st.GetDate(KEY_TIME_CREATED)
+}
+
+// TimeCreated returns timeAccessed property.
+func (st *SessionStore) TimeAccessed() time.Time {
+	return st.GetDate(KEY_TIME_ACCESSED)
+}
+
+// Lock acquires session lock with SET NX,
+// lock is released automatically after session.LockTTL().
+func (st *SessionStore) Lock() error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), session.LOCK_WAIT)
+	defer cancel()
+	lock_key := st.pder.getPrefixedKey(st.sid, LOCK_KEY)
+	for {
+		ok, err := st.pder.client.SetNX(ctx, lock_key, token, session.LockTTL()).Result()
+		if err != nil {
+			if ctx.Err() != nil {
+				return session.ErrLockTimeout
+			}
+			return err
+		}
+		if ok {
+			st.mx.Lock()
+			st.lockToken = token
+			st.mx.Unlock()
+			return st.reload()
+		}
+		select {
+		case <-ctx.Done():
+			return session.ErrLockTimeout
+		case <-time.After(session.LOCK_RETRY):
+		}
+	}
+}
+
+// reload reads session values again if they were read, e.g. after the session is locked.
+func (st *SessionStore) reload() error {
+	st.mx.Lock()
+	loaded := st.values != nil
+	st.mx.Unlock()
+	if !loaded {
+		return nil
+	}
+	values, err := st.pder.readValues(st.sid)
+	if err != nil {
+		return err
+	}
+	st.mx.Lock()
+	st.values = values
+	st.expires = nil
+	st.mx.Unlock()
+	return nil
+}
+
+// Unlock releases session lock.
+func (st *SessionStore) Unlock() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if st.lockToken == "" {
+		return nil
+	}
+	lock_key := st.pder.getPrefixedKey(st.sid, LOCK_KEY)
+	if err := unlockScript.Run(context.Background(), st.pder.client, []string{lock_key}, st.lockToken).Err(); err != nil {
+		return err
+	}
+	st.lockToken = ""
+	return nil
+}
+
+// Provider structure holds provider information.
+type Provider struct {
+	client      redis.UniversalClient
+	ownClient   bool                 //client is opened by the provider and closed by CloseProvider()
+	db          int                  //database number of the client
+	namespace   string               //key prefix
+	hashMode    bool                 //MODE_HASH storage
+	compression *session.Compression //value compression, nil if not used
+	maxLifeTime int64
+	maxIdleTime int64
+	logger      *slog.Logger        //structured logger, nil if not set
+	expiredHook session.SessionHook //called for sessions removed by SessionGC
+	gcScanCount int64               //SCAN COUNT hint used by SessionGC
+	gcLimit     int                 //max sessions removed by one SessionGC run, 0 if not limited
+	leaderToken string              //GC leader lock owner token of the process
+
+	expMode session.ExpirationMode //when access time is updated
+	strict  bool                   //unknown sessions are not created on read, see SetStrictMode()
+	clock   session.Clock          //session times and expiration, see SetClock()
+
+	hookMx      sync.Mutex          //guards changedHook and pubsub
+	changedHook session.SessionHook //called for changed sessions, see SetChangedHook()
+	pubsub      *redis.PubSub       //keyspace notifications subscription, nil if not subscribed
+
+	keyRing atomic.Pointer[session.KeyRing] //payload encryption, nil if not used
+}
+
+// SessionInit initializes session with given ID.
+func (pder *Provider) SessionInit(sid string) (session.Session, error) {
+	if pder.client == nil {
+		return nil, session.ErrProviderNotInitialized
+	}
+
+	if len(sid) > SESS_ID_LEN {
+		return nil, fmt.Errorf("%w: length exceeds %d", session.ErrInvalidSessionID, SESS_ID_LEN)
+	}
+
+	if pder.hashMode {
+		//expiration is set for the whole session hash
+		ctx := context.Background()
+		sess_key := pder.getSessionKey(sid)
+		val_b, err := pder.encodeValue(pder.clock.Now())
+		if err != nil {
+			return nil, err
+		}
+		created, err := pder.client.HSetNX(ctx, sess_key, KEY_TIME_CREATED, val_b).Result()
+		if err != nil {
+			return nil, err
+		}
+		if created && pder.maxLifeTime > 0 {
+			if err := pder.client.Expire(ctx, sess_key, time.Duration(pder.maxLifeTime)*time.Second).Err(); err != nil {
+				return nil, err
+			}
+		}
+	} else if pder.maxLifeTime > 0 {
+		//creation time is kept for life time check on read, see expired()
+		val_b, err := pder.encodeValue(pder.clock.Now())
+		if err != nil {
+			return nil, err
+		}
+		if err := pder.client.SetNX(context.Background(), pder.getPrefixedKey(sid, KEY_TIME_CREATED), val_b,
+			time.Duration(pder.maxLifeTime)*time.Second).Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &SessionStore{sid: sid, pder: pder}, nil
+}
+
+// SessionRead reads all session values in one round trip: with HGETALL in hash mode,
+// with SCAN and MGET in keys mode. Values are kept in session store, see SessionStore.
+// Expired session is destroyed, session.ErrSessionExpired is returned then.
+func (pder *Provider) SessionRead(sid string) (session.Session, error) {
+	if pder.client == nil {
+		return nil, session.ErrProviderNotInitialized
+	}
+	store, expired, err := pder.readStore(sid)
+	if err != nil {
+		return nil, err
+	}
+	if len(store.values) == 0 && pder.strict {
+		return nil, session.ErrSessionNotFound
+	}
+	if expired {
+		if err := pder.removeSession(sid); err != nil {
+			return nil, err
+		}
+		return nil, session.ErrSessionExpired
+	}
+	return store, nil
+}
+
+// SessionPeek implements session.PeekProvider: session values are read
+// without updating access time, missing session is not created, expired one is not removed.
+func (pder *Provider) SessionPeek(sid string) (session.Session, error) {
+	if pder.client == nil {
+		return nil, session.ErrProviderNotInitialized
+	}
+	store, expired, err := pder.readStore(sid)
+	if err != nil {
+		return nil, err
+	}
+	if len(store.values) == 0 {
+		return nil, session.ErrSessionNotFound
+	}
+	if expired {
+		return nil, session.ErrSessionExpired
+	}
+	store.touched = true //Get() must not update access time
+	return store, nil
+}
+
+// readStore reads all session values to a new session store and checks session expiration.
+func (pder *Provider) readStore(sid string) (*SessionStore, bool, error) {
+	values, err := pder.readValues(sid)
+	if err != nil {
+		return nil, false, err
+	}
+	store := &SessionStore{sid: sid, pder: pder, values: values}
+	if val_b, ok := values[KEY_TIME_EXPIRES]; ok {
+		if err := pder.decodeValue(val_b, &store.expiresAt); err != nil {
+			return nil, false, err
+		}
+	}
+	var created, accessed time.Time
+	if val_b, ok := values[KEY_TIME_CREATED]; ok {
+		if err := pder.decodeValue(val_b, &created); err != nil {
+			return nil, false, err
+		}
+	}
+	if val_b, ok := values[KEY_TIME_ACCESSED]; ok {
+		if err := pder.decodeValue(val_b, &accessed); err != nil {
+			return nil, false, err
+		}
+	}
+	return store, pder.expired(store.expiresAt, created, accessed), nil
+}
+
+// expired checks session expiration at the time of the provider clock: explicit expiration,
+// max idle time and max life time, which is also handled by redis key TTL in real time.
+// Zero accessed means never accessed, zero created means creation time is not kept.
+func (pder *Provider) expired(expiresAt, created, accessed time.Time) bool {
+	var life_time, idle_time int64
+	if !created.IsZero() {
+		life_time = pder.maxLifeTime
+	}
+	if !accessed.IsZero() {
+		idle_time = pder.maxIdleTime
+	}
+	return session.IsExpiredAt(pder.clock.Now(), created, accessed, expiresAt, life_time, idle_time)
+}
+
+// readValues returns all encoded session values including service keys, except lock key.
+func (pder *Provider) readValues(sid string) (map[string][]byte, error) {
+	ctx := context.Background()
+	values := make(map[string][]byte)
+	if pder.hashMode {
+		fields, err := pder.client.HGetAll(ctx, pder.getSessionKey(sid)).Result()
+		if err != nil {
+			return nil, err
+		}
+		for key, val := range fields {
+			values[key] = []byte(val)
+		}
+		return values, nil
+	}
+
+	prefix := pder.getPrefixedKey(sid, "")
+	redis_keys := make([]string, 0)
+	iter := pder.client.Scan(ctx, 0, prefix+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		if iter.Val() != prefix+LOCK_KEY {
+			redis_keys = append(redis_keys, iter.Val())
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	if len(redis_keys) == 0 {
+		return values, nil
+	}
+	vals, err := pder.client.MGet(ctx, redis_keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, val := range vals {
+		if val_s, ok := val.(string); ok { //nil if expired after SCAN
+			values[strings.TrimPrefix(redis_keys[i], prefix)] = []byte(val_s)
+		}
+	}
+	return values, nil
+}
+
+// readEncodedValues reads session values by keys in one round trip,
+// missing keys are not returned.
+func (pder *Provider) readEncodedValues(sid string, keys []string) (map[string][]byte, error) {
+	ctx := context.Background()
+	var vals []interface{}
+	var err error
+	if pder.hashMode {
+		vals, err = pder.client.HMGet(ctx, pder.getSessionKey(sid), keys...).Result()
+	} else {
+		redis_keys := make([]string, len(keys))
+		for i, key := range keys {
+			redis_keys[i] = pder.getPrefixedKey(sid, key)
+		}
+		vals, err = pder.client.MGet(ctx, redis_keys...).Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string][]byte, len(keys))
+	for i, val := range vals {
+		if val_s, ok := val.(string); ok {
+			values[keys[i]] = []byte(val_s)
+		}
+	}
+	return values, nil
+}
+
+// SessionClose is a stub
+func (pder *Provider) SessionClose(sid string) error {
+	return nil
+}
+
+// SessionDestroy destoys session by its ID.
+func (pder *Provider) SessionDestroy(sid string) error {
+	return pder.removeSession(sid)
+}
+
+// SessionGC removes unused sessions.
+// Handle max idle time only.
+// Max life time is controled by REDIS.
+// Sessions with expiration time set by SessionStore.SetExpiry() are not checked for idling.
+// Keys are scanned with SCAN COUNT set by SetGCScanCount(), an idle session is removed
+// with a Lua script checking that it is not accessed since its access time is read,
+// so sessions used concurrently are not removed.
+func (pder *Provider) SessionGC(l io.Writer, logLev session.LogLevel) session.GCReport {
+	report, _ := pder.sessionGC(pder.getLogger(l, logLev))
+	return report
+}
+
+// CollectGarbage implements session.ProviderV2, errors of redis commands are returned joined.
+func (pder *Provider) CollectGarbage() (int, error) {
+	report, err := pder.sessionGC(pder.getLogger(nil, session.LOG_LEVEL_ERROR))
+	return report.Deleted(), err
+}
+
+// sessionGC removes idle sessions, errors are logged and returned joined.
+func (pder *Provider) sessionGC(log *slog.Logger) (session.GCReport, error) {
+	//life time is controled by radis
+	if pder.maxIdleTime == 0 {
+		return session.GCReport{}, nil
+	}
+	log = log.With(session.LOG_KEY_OPERATION, "SessionGC")
+	start := time.Now()
+	var report session.GCReport
+	var err error
+	if pder.hashMode {
+		report, err = pder.sessionGCHash(log)
+	} else {
+		report, err = pder.sessionGCKeys(log)
+	}
+	log.Debug(LOG_PREF+"SessionGC() done", session.LOG_KEY_COUNT, report.Deleted(), session.LOG_KEY_DURATION, time.Since(start))
+	return report, err
+}
+
+// gcKeysScript removes session keys KEYS[3:] if access time key KEYS[1] holds ARGV[1]
+// and there is no expiration time key KEYS[2].
+var gcKeysScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] or redis.call("EXISTS", KEYS[2]) == 1 then
+	return 0
+end
+for i = 3, #KEYS do
+	redis.call("UNLINK", KEYS[i])
+end
+return 1`)
+
+// gcHashScript removes session hash KEYS[1] and lock key KEYS[2]
+// if access time field holds ARGV[2] and there is no expiration time field.
+var gcHashScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) ~= ARGV[2] or redis.call("HEXISTS", KEYS[1], ARGV[3]) == 1 then
+	return 0
+end
+redis.call("UNLINK", KEYS[1], KEYS[2])
+return 1`)
+
+// idle returns true if encoded access time is older than max idle time.
+func (pder *Provider) idle(accessed_b []byte, now int64) (bool, error) {
+	var t time.Time
+	if err := pder.decodeValue(accessed_b, &t); err != nil {
+		return false, err
+	}
+	return t.Unix()+pder.maxIdleTime <= now, nil
+}
+
+// sessionGCKeys removes idle sessions in keys mode, returns GC report and joined errors.
+func (pder *Provider) sessionGCKeys(log *slog.Logger) (session.GCReport, error) {
+	ctx := context.Background()
+	iter := pder.client.Scan(ctx, 0, pder.namespace+":*:"+KEY_TIME_ACCESSED, pder.gcScanCount).Iterator()
+	tm := pder.clock.Now().Unix()
+	var report session.GCReport
+	var errs []error
+	for iter.Next(ctx) {
+		if pder.gcLimit > 0 && report.DeletedIdle >= pder.gcLimit {
+			log.Debug(LOG_PREF+"SessionGC(): GC limit reached", session.LOG_KEY_COUNT, report.DeletedIdle)
+			break
+		}
+		key := iter.Val()
+		report.Scanned++
+		accessed_b, err := pder.client.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			continue //removed concurrently
+		} else if err != nil {
+			report.Errors++
+			errs = append(errs, err)
+			log.Error(LOG_PREF+"Get() failed", "key", key, session.LOG_KEY_ERROR, err)
+			continue
+		}
+		if idle, err := pder.idle(accessed_b, tm); err != nil {
+			report.Errors++
+			errs = append(errs, err)
+			log.Error(LOG_PREF+"pder.idle() failed", "key", key, session.LOG_KEY_ERROR, err)
+			continue
+		} else if !idle {
+			continue
+		}
+		sid := strings.TrimSuffix(strings.TrimPrefix(key, pder.namespace+":"), ":"+KEY_TIME_ACCESSED)
+		script_keys := []string{key, pder.getPrefixedKey(sid, KEY_TIME_EXPIRES)}
+		sess_iter := pder.client.Scan(ctx, 0, pder.getPrefixedKey(sid, "*"), pder.gcScanCount).Iterator()
+		for sess_iter.Next(ctx) {
+			script_keys = append(script_keys, sess_iter.Val())
+		}
+		if err := sess_iter.Err(); err != nil {
+			report.Errors++
+			errs = append(errs, err)
+			log.Error(LOG_PREF+"Scan() failed", session.LOG_KEY_SID, sid, session.LOG_KEY_ERROR, err)
+			continue
+		}
+		log.Debug(LOG_PREF+"SessionGC(): deleting session", session.LOG_KEY_SID, sid)
+		removed, err := gcKeysScript.Run(ctx, pder.client, script_keys, accessed_b).Int()
+		if err != nil {
+			report.Errors++
+			errs = append(errs, err)
+			log.Error(LOG_PREF+"gcKeysScript.Run() failed", session.LOG_KEY_SID, sid, session.LOG_KEY_ERROR, err)
+			continue
+		}
+		if removed == 1 {
+			report.DeletedIdle++
+			pder.sessionExpired(sid)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		report.Errors++
+		errs = append(errs, err)
+		log.Error(LOG_PREF+"Scan() failed", session.LOG_KEY_ERROR, err)
+	}
+	return report, errors.Join(errs...)
+}
+
+// sessionGCHash removes idle sessions in hash mode, returns GC report and joined errors.
+func (pder *Provider) sessionGCHash(log *slog.Logger) (session.GCReport, error) {
+	ctx := context.Background()
+	iter := pder.client.ScanType(ctx, 0, pder.namespace+":*", pder.gcScanCount, "hash").Iterator()
+	tm := pder.clock.Now().Unix()
+	var report session.GCReport
+	var errs []error
+	for iter.Next(ctx) {
+		if pder.gcLimit > 0 && report.DeletedIdle >= pder.gcLimit {
+			log.Debug(LOG_PREF+"SessionGC(): GC limit reached", session.LOG_KEY_COUNT, report.DeletedIdle)
+			break
+		}
+		key := iter.Val()
+		report.Scanned++
+		accessed_b, err := pder.client.HGet(ctx, key, KEY_TIME_ACCESSED).Bytes()
+		if err == redis.Nil {
+			continue //never accessed
+		} else if err != nil {
+			report.Errors++
+			errs = append(errs, err)
+			log.Error(LOG_PREF+"HGet() failed", "key", key, session.LOG_KEY_ERROR, err)
+			continue
+		}
+		if idle, err := pder.idle(accessed_b, tm); err != nil {
+			report.Errors++
+			errs = append(errs, err)
+			log.Error(LOG_PREF+"pder.idle() failed", "key", key, session.LOG_KEY_ERROR, err)
+			continue
+		} else if !idle {
+			continue
+		}
+		sid := strings.TrimPrefix(key, pder.namespace+":")
+		log.Debug(LOG_PREF+"SessionGC(): deleting session", session.LOG_KEY_SID, sid)
+		removed, err := gcHashScript.Run(ctx, pder.client, []string{key, pder.getPrefixedKey(sid, LOCK_KEY)},
+			KEY_TIME_ACCESSED, accessed_b, KEY_TIME_EXPIRES).Int()
+		if err != nil {
+			report.Errors++
+			errs = append(errs, err)
+			log.Error(LOG_PREF+"gcHashScript.Run() failed", session.LOG_KEY_SID, sid, session.LOG_KEY_ERROR, err)
+			continue
+		}
+		if removed == 1 {
+			report.DeletedIdle++
+			pder.sessionExpired(sid)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		report.Errors++
+		errs = append(errs, err)
+		log.Error(LOG_PREF+"ScanType() failed", session.LOG_KEY_ERROR, err)
+	}
+	return report, errors.Join(errs...)
+}
+
+// SetGCScanCount sets COUNT hint of SCAN commands run by SessionGC(), GC_SCAN_COUNT by default.
+func (pder *Provider) SetGCScanCount(count int64) {
+	pder.gcScanCount = count
+}
+
+// gcLeaderScript acquires GC leader key KEYS[1] with token ARGV[1] for ARGV[2] milliseconds,
+// the lock of the current leader is extended if it expires sooner.
+var gcLeaderScript = redis.NewScript(`
+local token = redis.call("GET", KEYS[1])
+if not token then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+if token ~= ARGV[1] then
+	return 0
+end
+if redis.call("PTTL", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 1`)
+
+// AcquireGCLeader implements session.GCLeaderLocker with a key set with SET NX semantics,
+// the key expires after ttl if the leader stops extending it.
+func (pder *Provider) AcquireGCLeader(name string, ttl time.Duration) (bool, error) {
+	leader, err := gcLeaderScript.Run(context.Background(), pder.client,
+		[]string{pder.namespace + GC_LEADER_KEY + name}, pder.leaderToken, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return leader == 1, nil
+}
+
+// ReleaseGCLeader implements session.GCLeaderLocker.
+func (pder *Provider) ReleaseGCLeader(name string) error {
+	return unlockScript.Run(context.Background(), pder.client, []string{pder.namespace + GC_LEADER_KEY + name}, pder.leaderToken).Err()
+}
+
+// SetGCMaxDeletions implements session.GCLimiter.
+func (pder *Provider) SetGCMaxDeletions(maxDeletions int) {
+	pder.gcLimit = maxDeletions
+}
+
+// SetExpirationMode implements session.ExpirationModeSetter.
+func (pder *Provider) SetExpirationMode(mode session.ExpirationMode) {
+	pder.expMode = mode
+}
+
+// SetStrictMode implements session.StrictModeSetter: SessionRead() returns session.ErrSessionNotFound
+// for a session without stored keys instead of creating it. In keys mode a session is stored
+// with its first flushed value, in hash mode it is stored by SessionInit().
+func (pder *Provider) SetStrictMode(strict bool) error {
+	pder.strict = strict
+	return nil
+}
+
+// SetGCScanCount sets SCAN COUNT hint of the registered provider GC.
+func SetGCScanCount(count int64) {
+	pder.SetGCScanCount(count)
+}
+
+// SessionCount returns number of stored sessions.
+// Sessions are counted with SCAN command.
+func (pder *Provider) SessionCount() (int, error) {
+	ids, err := pder.scanSessionIDs()
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// SessionList returns session metadata ordered by session ID.
+// All session IDs are scanned and sorted in memory.
+func (pder *Provider) SessionList(offset, limit int) ([]session.SessionMeta, error) {
+	ids, err := pder.scanSessionIDs()
+	if err != nil {
+		return nil, err
+	}
+	ids = session.PageSessionIDs(ids, offset, limit)
+	list := make([]session.SessionMeta, len(ids))
+	for i, sid := range ids {
+		list[i].ID = sid
+		if err := pder.readValue(sid, KEY_TIME_CREATED, &list[i].TimeCreated); err != nil && err != redis.Nil {
+			return nil, err
+		}
+		if err := pder.readValue(sid, KEY_TIME_ACCESSED, &list[i].TimeAccessed); err != nil && err != redis.Nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// SessionStats implements session.StatsProvider: sessions are counted with SCAN, distributions
+// are computed of metadata of sampled sessions, StorageBytes is estimated of their MEMORY USAGE,
+// it is 0 if the command is not available.
+func (pder *Provider) SessionStats(ctx context.Context, sampleSize int) (session.SessionStats, error) {
+	ids, err := pder.scanSessionIDs()
+	if err != nil {
+		return session.SessionStats{}, err
+	}
+	//SCAN returns keys in hash table order, so the first IDs are a random sample
+	sample_ids := ids
+	if sampleSize > 0 && len(sample_ids) > sampleSize {
+		sample_ids = sample_ids[:sampleSize]
+	}
+	sample := make([]session.SessionMeta, 0, len(sample_ids))
+	var memory int64
+	memory_ok := true
+	for _, sid := range sample_ids {
+		if err := ctx.Err(); err != nil {
+			return session.SessionStats{}, err
+		}
+		meta, err := pder.SessionMeta(sid)
+		if errors.Is(err, session.ErrSessionNotFound) {
+			continue //removed since scanned
+		}
+		if err != nil {
+			return session.SessionStats{}, err
+		}
+		sample = append(sample, meta)
+		if memory_ok {
+			n, err := pder.sessionMemory(ctx, sid)
+			memory += n
+			memory_ok = err == nil
+		}
+	}
+	stats := session.SampleStats(len(ids), sample, pder.clock.Now())
+	stats.Provider = PROVIDER
+	if memory_ok && len(sample) > 0 {
+		stats.StorageBytes = memory * int64(len(ids)) / int64(len(sample))
+	}
+	return stats, nil
+}
+
+// sessionMemory returns memory used by session keys, reported by MEMORY USAGE.
+func (pder *Provider) sessionMemory(ctx context.Context, sid string) (int64, error) {
+	if pder.hashMode {
+		memory, err := pder.client.MemoryUsage(ctx, pder.getSessionKey(sid)).Result()
+		if err == redis.Nil {
+			return 0, nil
+		}
+		return memory, err
+	}
+	var memory int64
+	iter := pder.client.Scan(ctx, 0, pder.getPrefixedKey(sid, "")+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		n, err := pder.client.MemoryUsage(ctx, iter.Val()).Result()
+		if err != nil && err != redis.Nil {
+			return 0, err
+		}
+		memory += n
+	}
+	return memory, iter.Err()
+}
+
+// SessionMeta implements session.MetaProvider. Service values and client fingerprint are read,
+// Size is a sum of stored value lengths got with STRLEN (HSTRLEN in hash mode), other values are not read.
+func (pder *Provider) SessionMeta(sid string) (session.SessionMeta, error) {
+	ctx := context.Background()
+	keys := make([]string, 0)
+	if pder.hashMode {
+		fields, err := pder.client.HKeys(ctx, pder.getSessionKey(sid)).Result()
+		if err != nil {
+			return session.SessionMeta{}, err
+		}
+		keys = fields
+	} else {
+		prefix := pder.getPrefixedKey(sid, "")
+		iter := pder.client.Scan(ctx, 0, prefix+"*", 0).Iterator()
+		for iter.Next(ctx) {
+			if iter.Val() != prefix+LOCK_KEY {
+				keys = append(keys, strings.TrimPrefix(iter.Val(), prefix))
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return session.SessionMeta{}, err
+		}
+	}
+	if len(keys) == 0 {
+		return session.SessionMeta{}, session.ErrSessionNotFound
+	}
+
+	lens := make([]*redis.Cmd, len(keys))
+	if _, err := pder.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			if pder.hashMode {
+				lens[i] = pipe.Do(ctx, "HSTRLEN", pder.getSessionKey(sid), key)
+			} else {
+				lens[i] = pipe.Do(ctx, "STRLEN", pder.getPrefixedKey(sid, key))
+			}
+		}
+		return nil
+	}); err != nil {
+		return session.SessionMeta{}, err
+	}
+	meta := session.SessionMeta{ID: sid, Provider: PROVIDER}
+	for i, key := range keys {
+		if !isServiceKey(key) {
+			n, _ := lens[i].Int()
+			meta.Size += n
+		}
+	}
+
+	service_vals := map[string]interface{}{
+		KEY_TIME_CREATED:        &meta.TimeCreated,
+		KEY_TIME_ACCESSED:       &meta.TimeAccessed,
+		KEY_TIME_EXPIRES:        &meta.ExpiresAt,
+		session.FINGERPRINT_KEY: &meta.Fingerprint,
+	}
+	for key, val := range service_vals {
+		if err := pder.readValue(sid, key, val); err != nil && err != redis.Nil {
+			return session.SessionMeta{}, err
+		}
+	}
+	return meta, nil
+}
+
+// scanSessionIDs returns IDs of all sessions in namespace.
+// In keys mode a session is found by its time_accessed key, in hash mode by its hash key.
+func (pder *Provider) scanSessionIDs() ([]string, error) {
+	ctx := context.Background()
+	var iter *redis.ScanIterator
+	suffix := ""
+	if pder.hashMode {
+		iter = pder.client.ScanType(ctx, 0, pder.namespace+":*", 0, "hash").Iterator()
+	} else {
+		suffix = ":" + KEY_TIME_ACCESSED
+		iter = pder.client.Scan(ctx, 0, pder.namespace+":*"+suffix, 0).Iterator()
+	}
+	ids := make([]string, 0)
+	for iter.Next(ctx) {
+		ids = append(ids, strings.TrimSuffix(strings.TrimPrefix(iter.Val(), pder.namespace+":"), suffix))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (pder *Provider) DestroyAllSessions(l io.Writer, logLev session.LogLevel) {
+	log := pder.getLogger(l, logLev).With(session.LOG_KEY_OPERATION, "DestroyAllSessions")
+	start := time.Now()
+	log.Debug(LOG_PREF + "DestroyAllSessions(): deleting keys on pattern: " + pder.namespace + ":*")
+	cnt, err := pder.DestroyAll()
+	if err != nil {
+		log.Error(LOG_PREF+"DestroyAll() failed", session.LOG_KEY_ERROR, err)
+		return
+	}
+	log.Debug(LOG_PREF+"DestroyAllSessions() done", session.LOG_KEY_COUNT, cnt, session.LOG_KEY_DURATION, time.Since(start))
+}
+
+// DestroyAll implements session.ProviderV2, sessions are counted before their keys are removed,
+// so sessions created concurrently may be removed without being counted.
+func (pder *Provider) DestroyAll() (int, error) {
+	sids, err := pder.scanSessionIDs()
+	if err != nil {
+		return 0, err
+	}
+	if err := pder.removeOnPattern(pder.namespace + ":*"); err != nil {
+		return 0, err
+	}
+	return len(sids), nil
+}
+
+// SetExpiredHook sets callback for sessions removed by SessionGC.
+func (pder *Provider) SetExpiredHook(hook session.SessionHook) {
+	pder.expiredHook = hook
+}
+
+// sessionExpired calls expired hook if it is set.
+func (pder *Provider) sessionExpired(sid string) {
+	if pder.expiredHook != nil {
+		pder.expiredHook(sid)
+	}
+}
+
+// SetClock implements session.ClockSetter, session times, idle time checked by GC and expiration
+// checked on read are of the clock. Redis key TTL runs in real time. Nil restores session.SystemClock.
+func (pder *Provider) SetClock(clock session.Clock) {
+	if clock == nil {
+		clock = session.SystemClock{}
+	}
+	pder.clock = clock
+}
+
+// SetLogger sets structured logger.
+func (pder *Provider) SetLogger(logger *slog.Logger) {
+	pder.logger = logger
+}
+
+// getLogger returns provider logger or io.Writer adapter if logger is not set.
+func (pder *Provider) getLogger(l io.Writer, logLev session.LogLevel) *slog.Logger {
+	return session.LoggerFor(pder.logger, l, logLev).With(session.LOG_KEY_PROVIDER, PROVIDER)
+}
+
+func (pder *Provider) SetMaxLifeTime(maxLifeTime int64) {
+	pder.maxLifeTime = maxLifeTime
+}
+func (pder *Provider) GetMaxLifeTime() int64 {
+	return pder.maxLifeTime
+}
+
+func (pder *Provider) SetMaxIdleTime(maxIdleTime int64) {
+	pder.maxIdleTime = maxIdleTime
+}
+
+func (pder *Provider) GetMaxIdleTime() int64 {
+	return pder.maxIdleTime
+}
+
+// CloseProvider closes keyspace subscription and redis client,
+// a client passed in Config.Client is left open.
+func (pder *Provider) CloseProvider() error {
+	pder.stopSubscription()
+	if pder.client == nil {
+		return nil
+	}
+	var err error
+	if pder.ownClient {
+		err = pder.client.Close()
+	}
+	pder.client = nil
+	return err
+}
+
+// Ping checks redis connection with PING command.
+func (pder *Provider) Ping(ctx context.Context) error {
+	if pder.client == nil {
+		return session.ErrProviderNotInitialized
+	}
+	return pder.client.Ping(ctx).Err()
+}
+
+// TRANSIENT_ERR_PREFIXES are prefixes of redis server errors which go away on retry,
+// e.g. while a replica is loading data set or a failover is in progress.
+var TRANSIENT_ERR_PREFIXES = []string{"LOADING", "READONLY", "TRYAGAIN", "CLUSTERDOWN", "MASTERDOWN"}
+
+// IsTransient implements session.TransientClassifier, connection errors, pool timeouts
+// and server errors with TRANSIENT_ERR_PREFIXES are transient.
+func (pder *Provider) IsTransient(err error) bool {
+	if session.IsTransient(err) {
+		return true
+	}
+	for _, prefix := range TRANSIENT_ERR_PREFIXES {
+		if redis.HasErrorPrefix(err, prefix) {
+			return true
+		}
+	}
+	return err != nil && strings.Contains(err.Error(), "connection pool timeout")
+}
+
+// SetKeyRing sets keys for value encryption.
+func (pder *Provider) SetKeyRing(keyRing *session.KeyRing) {
+	pder.keyRing.Store(keyRing)
+}
+
+// SetCompression sets compression of values, nil disables it.
+func (pder *Provider) SetCompression(compression *session.Compression) {
+	pder.compression = compression
+}
+
+// InitProvider initializes redis provider.
+// Function expects a Config or parameters:
+//
+//	0 parameter: Redis url string, redis://<user>:<pass>@localhost:6379/<db>
+//	1 parameter: redis namespace (username)
+//	2 parameter (optional): storage mode, MODE_KEYS or MODE_HASH, MODE_KEYS is used by default
+func (pder *Provider) InitProvider(provParams []interface{}) error {
+	cfg, err := configOf(provParams)
+	if err != nil {
+		return err
+	}
+	pder.namespace = cfg.Namespace
+	pder.hashMode = cfg.Mode == MODE_HASH
+
+	if cfg.Client != nil {
+		pder.client = cfg.Client
+		pder.ownClient = false
+		pder.db = clientDB(cfg.Client)
+	} else {
+		redis_opts, err := redis.ParseURL(cfg.URL)
+		if err != nil {
+			return err
+		}
+		cfg.applyPool(redis_opts)
+		if cfg.TLS != nil {
+			if redis_opts.TLSConfig, err = cfg.TLS.Load(); err != nil {
+				return err
+			}
+			if redis_opts.TLSConfig.ServerName == "" {
+				redis_opts.TLSConfig.ServerName, _, _ = net.SplitHostPort(redis_opts.Addr)
+			}
+		}
+		pder.client = redis.NewClient(redis_opts)
+		pder.ownClient = true
+		pder.db = redis_opts.DB
+	}
+	if _, err := pder.client.Ping(context.Background()).Result(); err != nil {
+		return err
+	}
+	pder.restartSubscription()
+
+	pder.leaderToken, err = newToken()
+	return err
+}
+
+// clientDB returns database number of the client, 0 if it is unknown.
+func clientDB(client redis.UniversalClient) int {
+	if c, ok := client.(*redis.Client); ok {
+		return c.Options().DB
+	}
+	return 0
+}
+
+// newToken returns a random lock owner token.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (pder *Provider) GetSessionIDLen() int {
+	return SESS_ID_LEN
+}
+
+// removeSession removes all values with keys sess:SESSION_ID:*
+// or session hash with its lock key in hash mode.
+// helper function for SessionDestroy and SessionGC
+func (pder *Provider) removeSession(sid string) error {
+	if pder.hashMode {
+		return pder.client.Unlink(context.Background(), pder.getSessionKey(sid), pder.getPrefixedKey(sid, LOCK_KEY)).Err()
+	}
+	return pder.removeOnPattern(pder.getPrefixedKey(sid, "*"))
+}
+
+// removeOnPattern removes all keys on pattern.
+// Keys are removed with UNLINK in batches of UNLINK_BATCH_SIZE keys,
+// so redis frees memory in background and is not blocked.
+func (pder *Provider) removeOnPattern(pattern string) error {
+	ctx := context.Background()
+	batch := make([]string, 0, UNLINK_BATCH_SIZE)
+	iter := pder.client.Scan(ctx, 0, pattern, UNLINK_BATCH_SIZE).Iterator()
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == UNLINK_BATCH_SIZE {
+			if err := pder.client.Unlink(ctx, batch...).Err(); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return pder.client.Unlink(ctx, batch...).Err()
+	}
+	return nil
+}
+
+// protected
+// readValue reads session value without updating session access time.
+func (pder *Provider) readValue(sid, key string, t interface{}) error {
+	if pder.hashMode {
+		val_b, err := pder.client.HGet(context.Background(), pder.getSessionKey(sid), key).Bytes()
+		if err != nil {
+			return err
+		}
+		return pder.decodeValue(val_b, t)
+	}
+	return pder.getValueForKey(pder.getPrefixedKey(sid, key), t)
+}
+
+func (pder *Provider) getValueForKey(redisKey string, t interface{}) error {
+	val_b, err := pder.client.Get(context.Background(), redisKey).Bytes()
+	if err != nil {
+		return err
+	}
+	return pder.decodeValue(val_b, t)
+}
+
+// setEncodedValues sets encoded session values with the given TTL, 0 means no expiration.
+// Values are written with one HSET in hash mode, with pipelined SET commands in keys mode.
+func (pder *Provider) setEncodedValues(sid string, values map[string][]byte, ttl time.Duration) error {
+	ctx := context.Background()
+	if pder.hashMode {
+		sess_key := pder.getSessionKey(sid)
+		field_vals := make([]interface{}, 0, len(values)*2)
+		for key, val_b := range values {
+			field_vals = append(field_vals, key, val_b)
+		}
+		_, err := pder.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, sess_key, field_vals...)
+			if ttl > 0 {
+				pipe.Expire(ctx, sess_key, ttl)
+			}
+			return nil
+		})
+		return err
+	}
+	if len(values) == 1 {
+		for key, val_b := range values {
+			return pder.client.Set(ctx, pder.getPrefixedKey(sid, key), val_b, ttl).Err()
+		}
+	}
+	_, err := pder.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, val_b := range values {
+			pipe.Set(ctx, pder.getPrefixedKey(sid, key), val_b, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// setEncodedValueTTL sets session value expiring in ttl, in hash mode the hash expires in sessTTL.
+func (pder *Provider) setEncodedValueTTL(sid, key string, val_b []byte, ttl, sessTTL time.Duration) error {
+	ctx := context.Background()
+	if !pder.hashMode {
+		return pder.client.Set(ctx, pder.getPrefixedKey(sid, key), val_b, ttl).Err()
+	}
+	sess_key := pder.getSessionKey(sid)
+	_, err := pder.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, sess_key, key, val_b)
+		if sessTTL > 0 {
+			pipe.Expire(ctx, sess_key, sessTTL)
+		}
+		pipe.HPExpire(ctx, sess_key, ttl, key)
+		return nil
+	})
+	return err
+}
+
+// setEncodedValueNX sets session value if it is not set, returns true if it is set.
+// In hash mode session TTL is not changed.
+func (pder *Provider) setEncodedValueNX(sid, key string, val_b []byte, ttl time.Duration) (bool, error) {
+	ctx := context.Background()
+	if pder.hashMode {
+		return pder.client.HSetNX(ctx, pder.getSessionKey(sid), key, val_b).Result()
+	}
+	return pder.client.SetNX(ctx, pder.getPrefixedKey(sid, key), val_b, ttl).Result()
+}
+
+// delValues deletes session values by keys.
+func (pder *Provider) delValues(sid string, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if pder.hashMode {
+		return pder.client.HDel(context.Background(), pder.getSessionKey(sid), keys...).Err()
+	}
+	redis_keys := make([]string, len(keys))
+	for i, key := range keys {
+		redis_keys[i] = pder.getPrefixedKey(sid, key)
+	}
+	return pder.client.Unlink(context.Background(), redis_keys...).Err()
+}
+
+// decodeValue decrypts and decodes redis value, session.SensitiveValue is decrypted as well.
+func (pder *Provider) decodeValue(val_b []byte, t interface{}) error {
+	if len(val_b) == 0 {
+		return session.ErrKeyNotFound //no value found
+	}
+	val_b, err := pder.decodeBytes(val_b)
+	if err != nil {
+		return err
+	}
+	dec := gob.NewDecoder(bytes.NewBuffer(val_b))
+	if err := dec.Decode(t); err != nil {
+		sv, ok := decodeSensitive(val_b)
+		if !ok {
+			return session.TypeError(err)
+		}
+		return openValue(sv, t)
+	}
+	if p, ok := t.(*interface{}); ok {
+		if sv, ok := (*p).(session.SensitiveValue); ok {
+			return openValue(sv, t)
+		}
+	}
 	return nil
 }
 
-func (pder *Provider) setValue(sid string, key string, val interface{}) error {
+// decodeBytes returns decrypted and decompressed gob encoding of value.
+func (pder *Provider) decodeBytes(val_b []byte) ([]byte, error) {
+	var err error
+	if key_ring := pder.keyRing.Load(); key_ring != nil {
+		if val_b, err = key_ring.DecryptPayload(val_b); err != nil {
+			return nil, err
+		}
+	}
+	return session.Decompress(val_b)
+}
+
+// sensitiveValue returns encrypted value if val_b is an encoded session.SensitiveValue.
+func (pder *Provider) sensitiveValue(val_b []byte) (session.SensitiveValue, bool) {
+	val_b, err := pder.decodeBytes(val_b)
+	if err != nil {
+		return session.SensitiveValue{}, false
+	}
+	return decodeSensitive(val_b)
+}
+
+// decodeSensitive decodes session.SensitiveValue, it is encoded as an interface value,
+// so decoding of other values fails.
+func decodeSensitive(val_b []byte) (session.SensitiveValue, bool) {
+	var v interface{}
+	if err := gob.NewDecoder(bytes.NewBuffer(val_b)).Decode(&v); err != nil {
+		return session.SensitiveValue{}, false
+	}
+	sv, ok := v.(session.SensitiveValue)
+	return sv, ok
+}
+
+// openValue assigns decrypted sensitive value to t.
+func openValue(sv session.SensitiveValue, t interface{}) error {
+	value, err := sv.Open()
+	if err != nil {
+		return err
+	}
+	return session.AssignValue(value, t)
+}
+
+// encodeValue encodes and encrypts value for redis.
+// session.SensitiveValue is encoded as an interface value, so it is told from other values on decoding.
+func (pder *Provider) encodeValue(val interface{}) ([]byte, error) {
 	var b bytes.Buffer //value to bytes
 	enc := gob.NewEncoder(&b)
-	if err := enc.Encode(val); err != nil {
-		return err
+	var err error
+	if _, ok := val.(session.SensitiveValue); ok {
+		err = enc.Encode(&val)
+	} else {
+		err = enc.Encode(val)
 	}
-	prefixed_key := pder.getPrefixedKey(sid, key)
-	return pder.client.Set(context.Background(), prefixed_key, b.Bytes(), time.Duration(pder.maxLifeTime)*time.Second).Err()
+	if err != nil {
+		return nil, session.TypeError(err)
+	}
+	val_b := b.Bytes()
+	if pder.compression != nil {
+		var err error
+		if val_b, err = pder.compression.Compress(val_b); err != nil {
+			return nil, err
+		}
+	}
+	if key_ring := pder.keyRing.Load(); key_ring != nil {
+		return key_ring.Encrypt(val_b)
+	}
+	return val_b, nil
+}
+
+// getSessionKey returns session hash key for hash mode.
+func (pder *Provider) getSessionKey(sid string) string {
+	return pder.namespace + ":" + sid
 }
 
 func (pder *Provider) getPrefixedKey(sid, key string) string {
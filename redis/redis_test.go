@@ -7,13 +7,24 @@
 package redis
 
 import (
+	"bytes"
+	"context"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"net/http/httptest"
 	"os"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/dronm/session" //session manager
+	_ "github.com/dronm/session/bolt"
+	"github.com/dronm/session/testkit"
+	"github.com/redis/go-redis/v9"
 )
 
 const (
@@ -31,75 +42,13 @@ func getTestVar(t *testing.T, n string) string {
 	return v
 }
 
-// TestStruct custom struct for use in session.
-type TestStruct struct {
-	IntVal   int
-	FloatVal float32
-	StrVal   string
-}
-
-func NewTestStruct() TestStruct {
-	return TestStruct{IntVal: 375, FloatVal: 3.14, StrVal: "Some string value in struct"}
-}
-
-func NewTestValues() map[string]interface{} {
-	return map[string]interface{}{
-		"stringVal":  "some string value",
-		"int32Val":   int32(2147483647),
-		"int64Val":   2147483647 * 2,
-		"float32Val": float32(3.14),
-		"float64Val": float64(3.14),
-		"dateVal":    time.Now().Truncate(time.Second),
-		"structVal":  NewTestStruct(),
-	}	
-}
-
-func putValues(t *testing.T, currentSession session.Session, tests map[string]interface{}) {
-	//test writing
-	for key, val := range tests {
-		t.Logf("Setting key: %s to %v", key, val)
-		if err := currentSession.Set(key, val); err != nil {
-			t.Fatalf("Set() for string value failed: %v", err)
-		}
-	}
-	if err := currentSession.Flush(); err != nil {
-		t.Fatalf("Flush() failed: %v", err)
-	}
-}
-
-func compareValues(t *testing.T, currentSession session.Session, tests map[string]interface{}) {
-	for key, wanted := range tests {
-		t.Logf("Getting key: %s", key)
-		
-		ptr := reflect.New(reflect.TypeOf(wanted))
-		err := currentSession.Get(key, ptr.Interface())
-		if err != nil {
-			t.Fatalf("Get() failed: %v", err)
-		}
-		got := ptr.Elem().Interface()
-		if !reflect.DeepEqual(got, wanted) {
-			t.Fatalf("Wanted: %v, got %v", wanted, got)
-		}
-	}
-}
-
-func assertNoValues(t *testing.T, currentSession session.Session, tests map[string]interface{}) {
-	for key, wanted := range tests {
-		ptr := reflect.New(reflect.TypeOf(wanted))
-		err := currentSession.Get(key, ptr.Interface())	
-		if err == nil {
-			t.Fatalf("Session: %s is not destroyed", currentSession.SessionID())
-		}
-	}
-}
-
 func NewManager(t *testing.T, idleTime int64, lifeTime int64, killTime string) (*session.Manager, error) {
 	return session.NewManager(PROVIDER, idleTime, lifeTime, killTime, getTestVar(t, ENV_REDIS_CONN), getTestVar(t, ENV_REDIS_NAMESPACE))
 }
 
 func TestSession(t *testing.T) {
 	//Register custom struct for marshaling.
-	gob.Register(TestStruct{})
+	gob.Register(testkit.TestStruct{})
 
 	SessManager, err := NewManager(t, 0, 0, "")
 	if err != nil {
@@ -115,11 +64,11 @@ func TestSession(t *testing.T) {
 	sid := currentSession.SessionID()
 	t.Logf("SessionID: %s", sid)
 
-	tests := NewTestValues()
-	putValues(t, currentSession, tests)
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
 
 	//test reading
-	compareValues(t, currentSession, tests)
+	testkit.CompareValues(t, currentSession, tests)
 
 	t.Logf("Closing session: %s", sid)
 	if err := SessManager.SessionClose(sid); err != nil {
@@ -133,7 +82,7 @@ func TestSession(t *testing.T) {
 		t.Errorf("SessionStart() failed: %v", err)
 	}
 	//test reading
-	compareValues(t, currentSession, tests)
+	testkit.CompareValues(t, currentSession, tests)
 
 	if err := SessManager.SessionClose(currentSession.SessionID()); err != nil {
 		t.Errorf("SessionClose() failed: %v", err)
@@ -150,7 +99,7 @@ func TestSession(t *testing.T) {
 		t.Errorf("SessionStart() failed: %v", err)
 	}
 	t.Logf("Trying to read from session")
-	assertNoValues(t, currentSession, tests)
+	testkit.AssertNoValues(t, currentSession, tests)
 	t.Logf("Session destroyed to read from session")
 }
 
@@ -171,8 +120,8 @@ func TestDestroyAllSessions(t *testing.T) {
 	sid := currentSession.SessionID()
 	t.Logf("SessionID: %s", sid)
 
-	tests := NewTestValues()
-	putValues(t, currentSession, tests)
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
 	
 	SessManager.DestroyAllSessions(os.Stderr, session.LOG_LEVEL_DEBUG)
 
@@ -181,12 +130,12 @@ func TestDestroyAllSessions(t *testing.T) {
 		t.Errorf("SessionStart() failed: %v", err)
 	}
 	t.Logf("Trying to read from session")
-	assertNoValues(t, currentSession, tests)
+	testkit.AssertNoValues(t, currentSession, tests)
 }
 
 // TestLifeTime creates a session with a limited life time.
-// Then waiting for the time more than our life time.
-// After that SessionGC() is called. 
+// Then the clock is advanced for the time more than our life time.
+// After that SessionGC() is called.
 // Then data is retrieved. The session should have been deleted by then.
 // The test fails if any key persists.
 func TestLifeTime(t *testing.T) {
@@ -195,35 +144,37 @@ func TestLifeTime(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewManager() failed: %v", err)
 	}
-	
+	clock := session.NewManualClock(time.Now())
+	SessManager.SetClock(clock)
+	defer SessManager.SetClock(nil)
+
 	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
 	sid := currentSession.SessionID()
 	t.Logf("SessionID: %s", sid)
 
-	tests := NewTestValues()
-	putValues(t, currentSession, tests)
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
 	if err := SessManager.SessionClose(currentSession.SessionID()); err != nil {
 		t.Errorf("SessionClose() failed: %v", err)
 	}
-	
-	t.Logf("waiting %d seconds for session to be killed", life_time + 2)
-	time.Sleep(time.Duration(life_time) * time.Second)	
-	
+
+	t.Logf("advancing clock %d seconds for session to be killed", life_time+1)
+	clock.Advance(time.Duration(life_time+1) * time.Second)
+
 	SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG)
-		
-	currentSession, err = SessManager.SessionStart(sid)
-	if err != nil {
-		t.Errorf("SessionStart() failed: %v", err)
-	}
+
 	t.Logf("Trying to read from session")
-	assertNoValues(t, currentSession, tests)	
+	assertSessionDestroyed(t, SessManager, sid, tests)
 	t.Logf("The session %s is destroyed", sid)
 }
 
 // TestIdleTime creates a session with a limited idle time.
 // Some values are put to session store, then retrieved, asserted they exist.
 // Then session data is not touched more then idle time.
-// After that SessionGC() is called. 
+// After that SessionGC() is called.
 // Then data is retrieved. The session should have been deleted by then.
 // The test fails if any key persists.
 func TestIdleTime(t *testing.T) {
@@ -232,41 +183,46 @@ func TestIdleTime(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewManager() failed: %v", err)
 	}
-	
+	clock := session.NewManualClock(time.Now())
+	SessManager.SetClock(clock)
+	defer SessManager.SetClock(nil)
+
 	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
 	sid := currentSession.SessionID()
 	t.Logf("SessionID: %s", sid)
 
-	tests := NewTestValues()
-	putValues(t, currentSession, tests)
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
 	if err := SessManager.SessionClose(currentSession.SessionID()); err != nil {
 		t.Errorf("SessionClose() failed: %v", err)
 	}
-	
-	t.Logf("waiting %d seconds", idle_time/2)
-	time.Sleep(time.Duration(idle_time/2) * time.Second)	
-	SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG)	
+
+	t.Logf("advancing clock %d seconds", idle_time/2)
+	clock.Advance(time.Duration(idle_time/2) * time.Second)
+	if report := SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG); report.Deleted() != 0 {
+		t.Fatalf("SessionGC() before idle time removed %d sessions", report.Deleted())
+	}
 	//test reading
-	compareValues(t, currentSession, tests)	
-	
-	t.Logf("waiting %d seconds for session to be killed", idle_time + 2)
-	time.Sleep(time.Duration(idle_time) * time.Second)	
-	
-	SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG)
-		
-	currentSession, err = SessManager.SessionStart(sid)
-	if err != nil {
-		t.Errorf("SessionStart() failed: %v", err)
+	testkit.CompareValues(t, currentSession, tests)
+
+	t.Logf("advancing clock %d seconds for session to be killed", idle_time)
+	clock.Advance(time.Duration(idle_time) * time.Second)
+
+	if report := SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG); report.DeletedIdle == 0 {
+		t.Errorf("SessionGC() after idle time removed no sessions")
 	}
+
 	t.Logf("Trying to read from session")
-	assertNoValues(t, currentSession, tests)	
+	assertSessionDestroyed(t, SessManager, sid, tests)
 	t.Logf("The session %s is destroyed", sid)
 }
 
 // TestKillByTime creates a session with a fixed kill time set to Now() + X seconds and starts GC.
-// Some values are put to session store, then we wait some tome less then X, retrieve values, assert they exist.
-// Then we wait some more time to pass the fixed time.
-// After that SessionGC() is called. 
+// Some values are put to session store, then the clock is advanced less then X, values are retrieved
+// and asserted they exist. Then the clock is advanced to pass the fixed time.
 // Then data is retrieved. The session should have been deleted by then.
 // The test fails if any key persists.
 func TestKillByTime(t *testing.T) {
@@ -276,39 +232,44 @@ func TestKillByTime(t *testing.T) {
 	m := tm2.Format("15:04:05")
 	t.Logf("Creating session manager and start GC at %s.", tm.Format("15:04:05"))
 	t.Logf("Expecting all sessions to be cleared in %d seconds at %s", in_sec, m)
-	
+
 	SessManager, err := NewManager(t, 0, 0, m)
 	if err != nil {
 		t.Fatalf("NewManager() failed: %v", err)
-	
-	
 	}
+	clock := session.NewManualClock(tm)
+	SessManager.SetClock(clock)
+	defer SessManager.SetClock(nil)
 	SessManager.StartGC(os.Stderr, session.LOG_LEVEL_DEBUG)
-	
+	defer SessManager.StopGC()
+
 	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
 	sid := currentSession.SessionID()
 	t.Logf("SessionID: %s", sid)
 
-	tests := NewTestValues()
-	putValues(t, currentSession, tests)
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
 	if err := SessManager.SessionClose(currentSession.SessionID()); err != nil {
 		t.Errorf("SessionClose() failed: %v", err)
 	}
-	
-	t.Logf("waiting %d seconds", 1)
-	time.Sleep(time.Duration(1) * time.Second)	
-	//test reading
-	compareValues(t, currentSession, tests)	
-	
-	t.Logf("waiting %d seconds for session to be killed", in_sec + 2)
-	time.Sleep(time.Duration( in_sec + 2) * time.Second)	
-	
-	currentSession, err = SessManager.SessionStart(sid)
-	if err != nil {
-		t.Errorf("SessionStart() failed: %v", err)
+
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
 	}
+	t.Logf("advancing clock %d seconds", 1)
+	clock.Advance(time.Second)
+	//test reading
+	testkit.CompareValues(t, currentSession, tests)
+
+	t.Logf("advancing clock %d seconds for session to be killed", in_sec)
+	clock.Advance(time.Duration(in_sec) * time.Second)
+	waitDestroyed(t, SessManager, sid)
+
 	t.Logf("Trying to read from session")
-	assertNoValues(t, currentSession, tests)	
+	assertSessionDestroyed(t, SessManager, sid, tests)
 	t.Logf("The session %s is destroyed", sid)
 }
 
@@ -316,33 +277,1608 @@ func TestKillByTime(t *testing.T) {
 func TestRestartGC(t *testing.T) {
 	var lt_sec int64 = 3 //idle time
 	t.Logf("Creating session manager with idle time: %d seconds", lt_sec)
-		
+
 	SessManager, err := NewManager(t, 0, lt_sec, "")
 	if err != nil {
 		t.Fatalf("NewManager() failed: %v", err)
 	}
+	clock := session.NewManualClock(time.Now())
+	SessManager.SetClock(clock)
+	defer SessManager.SetClock(nil)
 	SessManager.StartGC(os.Stderr, session.LOG_LEVEL_DEBUG)
-	
+	defer SessManager.StopGC()
+
 	currentSession, err := SessManager.SessionStart("")
-	tests := NewTestValues()
-	putValues(t, currentSession, tests)
-	if err := SessManager.SessionClose(currentSession.SessionID()); err != nil {
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+	if err := SessManager.SessionClose(sid); err != nil {
 		t.Errorf("SessionClose() failed: %v", err)
 	}
-	time.Sleep(time.Duration(1) * time.Second)
-	compareValues(t, currentSession, tests)		
-	
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Second)
+	testkit.CompareValues(t, currentSession, tests)
+
 	//reset the GC time
 	lt_sec = lt_sec * 2
 	t.Logf("Resetting the idle time to %d seconds", lt_sec)
-	SessManager.StopGC()
 	SessManager.SetMaxIdleTime(lt_sec)
-	SessManager.StartGC(os.Stderr, session.LOG_LEVEL_DEBUG)
+	gc_done := make(chan session.GCReport, 1)
+	SessManager.OnGC(func(report session.GCReport) {
+		select {
+		case gc_done <- report:
+		default:
+		}
+	})
+	SessManager.RestartGC(os.Stderr, session.LOG_LEVEL_DEBUG)
+	//the timer of the stopped loop is kept by the clock
+	for clock.Timers() < 2 {
+		time.Sleep(time.Millisecond)
+	}
 
-	t.Logf("Waiting %d seconds", lt_sec + 2)
-	time.Sleep(time.Duration(lt_sec + 2) * time.Second)
+	t.Logf("Advancing clock %d seconds", lt_sec)
+	clock.Advance(time.Duration(lt_sec) * time.Second)
+	select {
+	case <-gc_done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("GC did not run after clock advance")
+	}
 	t.Logf("Trying to read from session")
-	assertNoValues(t, currentSession, tests)	
-	t.Logf("The session %s is destroyed", currentSession.SessionID())
-}	
+	assertSessionDestroyed(t, SessManager, sid, tests)
+	t.Logf("The session %s is destroyed", sid)
+}
+
+// assertSessionDestroyed checks that session sid is either reported expired or started without test values.
+func assertSessionDestroyed(t *testing.T, SessManager *session.Manager, sid string, tests map[string]interface{}) {
+	t.Helper()
+	currentSession, err := SessManager.SessionStart(sid)
+	if errors.Is(err, session.ErrSessionExpired) {
+		return
+	}
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	defer SessManager.SessionDestroy(sid)
+	testkit.AssertNoValues(t, currentSession, tests)
+}
+
+// waitDestroyed waits till session sid is removed by GC goroutine.
+func waitDestroyed(t *testing.T, SessManager *session.Manager, sid string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		keys, err := pder.readValues(sid)
+		if err != nil {
+			t.Fatalf("readValues() failed: %v", err)
+		}
+		if len(keys) == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("session %s is not destroyed by GC", sid)
+}
+
+// TestLock locks a session, starts the same session in a goroutine which must wait for the lock.
+func TestLock(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStartLocked("")
+	if err != nil {
+		t.Fatalf("SessionStartLocked() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	done := make(chan int64)
+	go func() {
+		sess, err := SessManager.SessionStartLocked(sid)
+		if err != nil {
+			t.Errorf("SessionStartLocked() failed: %v", err)
+			close(done)
+			return
+		}
+		got := sess.GetInt("counter")
+		if err := sess.Unlock(); err != nil {
+			t.Errorf("Unlock() failed: %v", err)
+		}
+		done <- got
+	}()
+
+	time.Sleep(time.Duration(200) * time.Millisecond)
+	if err := currentSession.Put("counter", int64(1)); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := currentSession.Unlock(); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+
+	if got := <-done; got != 1 {
+		t.Fatalf("Wanted: 1, got %v", got)
+	}
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Errorf("SessionDestroy() failed: %v", err)
+	}
+}
+
+// TestKeys puts values to a session and checks Keys() and Len() results.
+func TestKeys(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+
+	wanted := make([]string, 0, len(tests))
+	for key := range tests {
+		wanted = append(wanted, key)
+	}
+	sort.Strings(wanted)
+
+	got, err := currentSession.Keys()
+	if err != nil {
+		t.Fatalf("Keys() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Fatalf("Wanted: %v, got %v", wanted, got)
+	}
+
+	cnt, err := currentSession.Len()
+	if err != nil {
+		t.Fatalf("Len() failed: %v", err)
+	}
+	if cnt != len(tests) {
+		t.Fatalf("Wanted: %d, got %d", len(tests), cnt)
+	}
+	if err := SessManager.SessionDestroy(currentSession.SessionID()); err != nil {
+		t.Errorf("SessionDestroy() failed: %v", err)
+	}
+}
+
+// TestClear puts values to a session, clears it and checks that session ID is kept and values are gone.
+func TestClear(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+
+	if err := currentSession.Clear(); err != nil {
+		t.Fatalf("Clear() failed: %v", err)
+	}
+	if err := currentSession.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if currentSession.SessionID() != sid {
+		t.Fatalf("Wanted: %s, got %s", sid, currentSession.SessionID())
+	}
+	testkit.AssertNoValues(t, currentSession, tests)
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Errorf("SessionDestroy() failed: %v", err)
+	}
+}
+
+// TestIncrement increments a counter from several goroutines with separate session instances.
+// No increment may be lost.
+func TestIncrement(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	const workers, incs = 5, 10
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sess, err := SessManager.SessionStart(sid)
+			if err != nil {
+				t.Errorf("SessionStart() failed: %v", err)
+				return
+			}
+			for j := 0; j < incs; j++ {
+				if _, err := sess.Increment("counter", 2); err != nil {
+					t.Errorf("Increment() failed: %v", err)
+				}
+				if _, err := sess.Decrement("counter", 1); err != nil {
+					t.Errorf("Decrement() failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := currentSession.Increment("counter", 0)
+	if err != nil {
+		t.Fatalf("Increment() failed: %v", err)
+	}
+	if got != workers*incs {
+		t.Fatalf("Wanted: %d, got %d", workers*incs, got)
+	}
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Errorf("SessionDestroy() failed: %v", err)
+	}
+}
+
+// TestCompareAndSwap increments a counter with CompareAndSwap() concurrently,
+// every increment must be applied once.
+func TestCompareAndSwap(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if ok, err := currentSession.CompareAndSwap("counter", nil, int64(0)); err != nil || !ok {
+		t.Fatalf("CompareAndSwap() with nil old value wanted true, got %v (%v)", ok, err)
+	}
+
+	const workers, incs = 5, 10
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sess, err := SessManager.SessionStart(sid)
+			if err != nil {
+				t.Errorf("SessionStart() failed: %v", err)
+				return
+			}
+			for j := 0; j < incs; {
+				cur := sess.GetInt("counter")
+				ok, err := sess.CompareAndSwap("counter", cur, cur+1)
+				if err != nil {
+					t.Errorf("CompareAndSwap() failed: %v", err)
+					return
+				}
+				if ok {
+					j++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	//values are read by SessionStart()
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if got := currentSession.GetInt("counter"); got != workers*incs {
+		t.Fatalf("Wanted: %d, got %d", workers*incs, got)
+	}
+	if ok, err := currentSession.CompareAndSwap("counter", int64(workers*incs), nil); err != nil || !ok {
+		t.Fatalf("CompareAndSwap() with nil new value wanted true, got %v (%v)", ok, err)
+	}
+	var v int64
+	if err := currentSession.Get("counter", &v); !errors.Is(err, session.ErrKeyNotFound) {
+		t.Fatalf("Get() wanted ErrKeyNotFound, got %v", err)
+	}
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Errorf("SessionDestroy() failed: %v", err)
+	}
+}
+
+// TestMaxSessionSize checks that the oldest keys are evicted from a too large session.
+func TestMaxSessionSize(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	SessManager.SetMaxSessionSize(250, true)
 
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	value := strings.Repeat("a", 100)
+	for _, key := range []string{"k1", "k2", "k3"} {
+		if err := currentSession.Put(key, value); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+	}
+	if keys, _ := currentSession.Keys(); !reflect.DeepEqual(keys, []string{"k2", "k3"}) {
+		t.Fatalf("Keys() wanted [k2 k3], got %v", keys)
+	}
+	if err := currentSession.Put("big", strings.Repeat("a", 300)); !errors.Is(err, session.ErrSessionTooLarge) {
+		t.Fatalf("Put() wanted ErrSessionTooLarge, got %v", err)
+	}
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Errorf("SessionDestroy() failed: %v", err)
+	}
+}
+
+// TestHashMode runs write/read/keys/increment/destroy cycle with MODE_HASH storage.
+func TestHashMode(t *testing.T) {
+	gob.Register(testkit.TestStruct{})
+
+	SessManager, err := session.NewManager(PROVIDER, 0, 0, "", getTestVar(t, ENV_REDIS_CONN), getTestVar(t, ENV_REDIS_NAMESPACE), MODE_HASH)
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer func() {
+		//restore default mode for other tests
+		if _, err := NewManager(t, 0, 0, ""); err != nil {
+			t.Errorf("NewManager() failed: %v", err)
+		}
+	}()
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+	if _, err := currentSession.Increment("counter", 5); err != nil {
+		t.Fatalf("Increment() failed: %v", err)
+	}
+	if err := SessManager.SessionClose(sid); err != nil {
+		t.Fatalf("SessionClose() failed: %v", err)
+	}
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	testkit.CompareValues(t, currentSession, tests)
+	if v := currentSession.GetInt("counter"); v != 5 {
+		t.Errorf("GetInt() wanted 5, got %d", v)
+	}
+
+	want := []string{"counter"}
+	for key := range tests {
+		want = append(want, key)
+	}
+	sort.Strings(want)
+	got, err := currentSession.Keys()
+	if err != nil {
+		t.Fatalf("Keys() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys() wanted %v, got %v", want, got)
+	}
+
+	if err := currentSession.Delete("counter"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if n, err := currentSession.Len(); err != nil || n != len(tests) {
+		t.Errorf("Len() wanted %d, got %d (%v)", len(tests), n, err)
+	}
+
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	testkit.AssertNoValues(t, currentSession, tests)
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Errorf("SessionDestroy() failed: %v", err)
+	}
+}
+
+// TestListSessions checks that started sessions are counted and listed.
+func TestListSessions(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	cnt_before, err := SessManager.Count()
+	if err != nil {
+		t.Fatalf("Count() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Put("key", "value"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	defer SessManager.SessionDestroy(sid)
+
+	cnt, err := SessManager.Count()
+	if err != nil {
+		t.Fatalf("Count() failed: %v", err)
+	}
+	if cnt != cnt_before+1 {
+		t.Fatalf("Count() wanted %d, got %d", cnt_before+1, cnt)
+	}
+	list, err := SessManager.ListSessions(0, 0)
+	if err != nil {
+		t.Fatalf("ListSessions() failed: %v", err)
+	}
+	found := false
+	for _, meta := range list {
+		if meta.ID == sid {
+			found = !meta.TimeAccessed.IsZero()
+		}
+	}
+	if !found {
+		t.Fatalf("session %s not found in ListSessions()", sid)
+	}
+}
+
+// TestExpiry checks that TTL set by SetExpiry() is applied to session keys and kept on writes.
+func TestExpiry(t *testing.T) {
+	for _, mode := range []string{MODE_KEYS, MODE_HASH} {
+		SessManager, err := session.NewManager(PROVIDER, 0, 0, "", getTestVar(t, ENV_REDIS_CONN), getTestVar(t, ENV_REDIS_NAMESPACE), mode)
+		if err != nil {
+			t.Fatalf("NewManager() failed: %v", err)
+		}
+		currentSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		sid := currentSession.SessionID()
+		if err := currentSession.Put("key1", "value"); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+		if err := currentSession.SetExpiry(time.Hour); err != nil {
+			t.Fatalf("SetExpiry() failed: %v", err)
+		}
+
+		//reopened session keeps expiration on writes
+		currentSession, err = SessManager.SessionStart(sid)
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		if err := currentSession.Put("key2", "value"); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+		redis_keys := []string{pder.getSessionKey(sid)}
+		if mode == MODE_KEYS {
+			redis_keys = []string{pder.getPrefixedKey(sid, "key1"), pder.getPrefixedKey(sid, "key2")}
+		}
+		for _, redis_key := range redis_keys {
+			ttl, err := pder.client.TTL(context.Background(), redis_key).Result()
+			if err != nil {
+				t.Fatalf("TTL() failed: %v", err)
+			}
+			if ttl <= time.Hour-time.Minute || ttl > time.Hour {
+				t.Errorf("mode %s: TTL of %s wanted about 1h, got %v", mode, redis_key, ttl)
+			}
+		}
+
+		if err := currentSession.SetExpiry(0); err != nil {
+			t.Fatalf("SetExpiry() failed: %v", err)
+		}
+		for _, redis_key := range redis_keys {
+			if ttl, _ := pder.client.TTL(context.Background(), redis_key).Result(); ttl >= 0 {
+				t.Errorf("mode %s: TTL of %s wanted none, got %v", mode, redis_key, ttl)
+			}
+		}
+		if err := SessManager.SessionDestroy(sid); err != nil {
+			t.Errorf("SessionDestroy() failed: %v", err)
+		}
+	}
+	//restore default mode for other tests
+	if _, err := NewManager(t, 0, 0, ""); err != nil {
+		t.Errorf("NewManager() failed: %v", err)
+	}
+}
+
+// unreachableProvider is a provider failing to start sessions when down.
+type unreachableProvider struct {
+	session.Provider
+	down bool
+}
+
+func (p *unreachableProvider) SessionInit(sid string) (session.Session, error) {
+	if p.down {
+		return nil, errors.New("provider is unreachable")
+	}
+	return p.Provider.SessionInit(sid)
+}
+
+func (p *unreachableProvider) Ping(ctx context.Context) error {
+	if p.down {
+		return errors.New("provider is unreachable")
+	}
+	return p.Provider.Ping(ctx)
+}
+
+func (p *unreachableProvider) SessionRead(sid string) (session.Session, error) {
+	if p.down {
+		return nil, errors.New("provider is unreachable")
+	}
+	return p.Provider.SessionRead(sid)
+}
+
+// TestFallback uses bolt as a secondary provider while redis is unreachable.
+func TestFallback(t *testing.T) {
+	const (
+		fallback_name = PROVIDER + "_bolt"
+		bolt_file     = "fallback_test.db"
+	)
+	if _, ok := session.LookupProvider(fallback_name); ok {
+		t.Skip("fallback provider is already registered")
+	}
+	primary := &unreachableProvider{Provider: pder}
+	bolt_pder, _ := session.LookupProvider("bolt")
+	fpder := session.NewFallbackProvider(primary, bolt_pder, 0)
+	session.Register(fallback_name, fpder)
+	SessManager, err := session.NewManager(fallback_name, 0, 0, "",
+		[]interface{}{getTestVar(t, ENV_REDIS_CONN), getTestVar(t, ENV_REDIS_NAMESPACE)},
+		[]interface{}{bolt_file})
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer os.Remove(bolt_file)
+	defer SessManager.CloseProvider()
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	defer SessManager.SessionDestroy(sid)
+	if err := currentSession.Put("user", "admin"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	//outage, mirrored values are kept
+	primary.down = true
+	if err := SessManager.HealthCheck(context.Background()); err != nil {
+		t.Fatalf("HealthCheck() during outage failed: %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if !fpder.PrimaryDown() {
+		t.Fatalf("PrimaryDown() wanted true")
+	}
+	if v := currentSession.GetString("user"); v != "admin" {
+		t.Fatalf("GetString() wanted admin, got %s", v)
+	}
+	if err := currentSession.Put("role", "manager"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	//values set during outage are copied to redis
+	primary.down = false
+	if err := fpder.Resync(); err != nil {
+		t.Fatalf("Resync() failed: %v", err)
+	}
+	if fpder.PrimaryDown() {
+		t.Fatalf("PrimaryDown() wanted false")
+	}
+	currentSession, err = pder.SessionRead(sid)
+	if err != nil {
+		t.Fatalf("SessionRead() failed: %v", err)
+	}
+	if v := currentSession.GetString("role"); v != "manager" {
+		t.Fatalf("GetString() wanted manager, got %s", v)
+	}
+}
+
+// TestReplication replicates sessions to redis and bolt.
+func TestReplication(t *testing.T) {
+	const (
+		replicated_name = PROVIDER + "_bolt_replicated"
+		bolt_file       = "replicated_test.db"
+	)
+	if _, ok := session.LookupProvider(replicated_name); ok {
+		t.Skip("replicated provider is already registered")
+	}
+	primary := &unreachableProvider{Provider: pder}
+	bolt_pder, _ := session.LookupProvider("bolt")
+	session.Register(replicated_name, session.NewReplicatedProvider(2, primary, bolt_pder))
+	SessManager, err := session.NewManager(replicated_name, 0, 0, "",
+		[]interface{}{getTestVar(t, ENV_REDIS_CONN), getTestVar(t, ENV_REDIS_NAMESPACE)},
+		[]interface{}{bolt_file})
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer os.Remove(bolt_file)
+	defer SessManager.CloseProvider()
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	defer SessManager.SessionDestroy(sid)
+	if err := currentSession.Put("user", "admin"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	for _, p := range []session.Provider{pder, bolt_pder} {
+		sess, err := p.SessionRead(sid)
+		if err != nil {
+			t.Fatalf("SessionRead() failed: %v", err)
+		}
+		if v := sess.GetString("user"); v != "admin" {
+			t.Fatalf("GetString() wanted admin, got %s", v)
+		}
+	}
+
+	//one replica is not enough
+	primary.down = true
+	if _, err := SessManager.SessionStart(sid); !errors.Is(err, session.ErrQuorum) {
+		t.Fatalf("SessionStart() wanted ErrQuorum, got %v", err)
+	}
+	primary.down = false
+}
+
+// TestSharding distributes sessions between redis and bolt.
+func TestSharding(t *testing.T) {
+	const (
+		sharded_name = PROVIDER + "_bolt_sharded"
+		bolt_file    = "sharded_test.db"
+		sessions     = 20
+	)
+	if _, ok := session.LookupProvider(sharded_name); ok {
+		t.Skip("sharded provider is already registered")
+	}
+	bolt_pder, _ := session.LookupProvider("bolt")
+	spder := session.NewShardedProvider(pder, bolt_pder)
+	session.Register(sharded_name, spder)
+	SessManager, err := session.NewManager(sharded_name, 0, 0, "",
+		[]interface{}{getTestVar(t, ENV_REDIS_CONN), getTestVar(t, ENV_REDIS_NAMESPACE)},
+		[]interface{}{bolt_file})
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer os.Remove(bolt_file)
+	defer SessManager.CloseProvider()
+	SessManager.DestroyAllSessions(os.Stderr, session.LOG_LEVEL_ERROR)
+
+	shard_cnt := make([]int, 2)
+	for i := 0; i < sessions; i++ {
+		currentSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		sid := currentSession.SessionID()
+		if err := currentSession.Put("num", i); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+		shard := spder.ShardIndex(sid)
+		shard_cnt[shard]++
+
+		shard_pder := []session.Provider{pder, bolt_pder}[shard]
+		sess, err := shard_pder.SessionRead(sid)
+		if err != nil {
+			t.Fatalf("SessionRead() failed: %v", err)
+		}
+		if v := sess.GetInt("num"); v != int64(i) {
+			t.Fatalf("session %s is not kept by shard %d", sid, shard)
+		}
+	}
+	if shard_cnt[0] == 0 || shard_cnt[1] == 0 {
+		t.Fatalf("sessions are not distributed: %v", shard_cnt)
+	}
+	if cnt, _ := SessManager.Count(); cnt != sessions {
+		t.Fatalf("Count() wanted %d, got %d", sessions, cnt)
+	}
+	list, err := SessManager.ListSessions(5, 10)
+	if err != nil {
+		t.Fatalf("ListSessions() failed: %v", err)
+	}
+	if len(list) != 10 || !sort.SliceIsSorted(list, func(i, j int) bool { return list[i].ID < list[j].ID }) {
+		t.Fatalf("ListSessions() wanted 10 sorted sessions, got %v", list)
+	}
+	SessManager.DestroyAllSessions(os.Stderr, session.LOG_LEVEL_ERROR)
+}
+
+// TestReadValues checks that session values are read by SessionStart()
+// and are read again after Lock().
+func TestReadValues(t *testing.T) {
+	for _, mode := range []string{MODE_KEYS, MODE_HASH} {
+		SessManager, err := session.NewManager(PROVIDER, 0, 0, "", getTestVar(t, ENV_REDIS_CONN), getTestVar(t, ENV_REDIS_NAMESPACE), mode)
+		if err != nil {
+			t.Fatalf("NewManager() failed: %v", err)
+		}
+		currentSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		sid := currentSession.SessionID()
+		tests := testkit.NewTestValues()
+		testkit.PutValues(t, currentSession, tests)
+
+		readSession, err := SessManager.SessionStart(sid)
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		testkit.CompareValues(t, readSession, tests)
+		if keys, _ := readSession.Keys(); len(keys) != len(tests) {
+			t.Fatalf("%s: Keys() wanted %d keys, got %v", mode, len(tests), keys)
+		}
+
+		//value written by another store is read on Lock()
+		if err := currentSession.Put("stringVal", "new value"); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+		if err := readSession.Lock(); err != nil {
+			t.Fatalf("Lock() failed: %v", err)
+		}
+		if got := readSession.GetString("stringVal"); got != "new value" {
+			t.Fatalf("%s: GetString() after Lock() wanted %q, got %q", mode, "new value", got)
+		}
+		if err := readSession.Unlock(); err != nil {
+			t.Fatalf("Unlock() failed: %v", err)
+		}
+		if err := SessManager.SessionDestroy(sid); err != nil {
+			t.Fatalf("SessionDestroy() failed: %v", err)
+		}
+	}
+}
+
+// TestDestroyManyKeys destroys a session with more keys than UNLINK_BATCH_SIZE.
+func TestDestroyManyKeys(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	keys := UNLINK_BATCH_SIZE*2 + 1
+	for i := 0; i < keys; i++ {
+		if err := currentSession.Set(fmt.Sprintf("key%d", i), i); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+	}
+	if err := currentSession.Put("key0", 0); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if n, _ := currentSession.Len(); n != keys {
+		t.Fatalf("Len() wanted %d, got %d", keys, n)
+	}
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if n, _ := currentSession.Len(); n != 0 {
+		t.Fatalf("Len() after SessionDestroy() wanted 0, got %d", n)
+	}
+}
+
+// TestGCScript checks that SessionGC removes idle sessions and keeps
+// the sessions accessed before GC in both storage modes.
+func TestGCScript(t *testing.T) {
+	var idle_time int64 = 2
+	defer SetGCScanCount(GC_SCAN_COUNT)
+	for _, mode := range []string{MODE_KEYS, MODE_HASH} {
+		SessManager, err := session.NewManager(PROVIDER, 0, idle_time, "", getTestVar(t, ENV_REDIS_CONN), getTestVar(t, ENV_REDIS_NAMESPACE), mode)
+		if err != nil {
+			t.Fatalf("NewManager() failed: %v", err)
+		}
+		SetGCScanCount(1)
+		clock := session.NewManualClock(time.Now())
+		SessManager.SetClock(clock)
+
+		idleSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		activeSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		for _, sess := range []session.Session{idleSession, activeSession} {
+			if err := sess.Put("key", mode); err != nil {
+				t.Fatalf("Put() failed: %v", err)
+			}
+		}
+		clock.Advance(time.Duration(idle_time+1) * time.Second)
+		if err := activeSession.Put("key", mode); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+		SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG)
+
+		idleSession, err = SessManager.SessionStart(idleSession.SessionID())
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		if n, _ := idleSession.Len(); n != 0 {
+			t.Errorf("%s mode: idle session wanted to be removed, got %d keys", mode, n)
+		}
+		activeSession, err = SessManager.SessionStart(activeSession.SessionID())
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		if v := activeSession.GetString("key"); v != mode {
+			t.Errorf("%s mode: active session value wanted %q, got %q", mode, mode, v)
+		}
+		for _, sess := range []session.Session{idleSession, activeSession} {
+			if err := SessManager.SessionDestroy(sess.SessionID()); err != nil {
+				t.Errorf("SessionDestroy() failed: %v", err)
+			}
+		}
+	}
+	//restore default mode and clock for other tests
+	pder.SetClock(nil)
+	if _, err := NewManager(t, 0, 0, ""); err != nil {
+		t.Errorf("NewManager() failed: %v", err)
+	}
+}
+
+// TestGCLeader acquires GC leader lock by two instances simulated with different owner tokens.
+func TestGCLeader(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	if err := SessManager.SetGCLeaderLock("test"); err != nil {
+		t.Fatalf("SetGCLeaderLock() failed: %v", err)
+	}
+	token := pder.leaderToken
+	other_token := token + "_other"
+	defer func() {
+		pder.leaderToken = other_token
+		pder.ReleaseGCLeader("test")
+		pder.leaderToken = token
+	}()
+
+	if ok, err := pder.AcquireGCLeader("test", time.Minute); err != nil || !ok {
+		t.Fatalf("AcquireGCLeader() wanted leader, got %v, %v", ok, err)
+	}
+	//extending
+	if ok, err := pder.AcquireGCLeader("test", time.Minute); err != nil || !ok {
+		t.Fatalf("AcquireGCLeader() wanted leader, got %v, %v", ok, err)
+	}
+
+	pder.leaderToken = other_token
+	if ok, err := pder.AcquireGCLeader("test", time.Minute); err != nil || ok {
+		t.Fatalf("AcquireGCLeader() of other instance wanted false, got %v, %v", ok, err)
+	}
+	//not owner
+	if err := pder.ReleaseGCLeader("test"); err != nil {
+		t.Fatalf("ReleaseGCLeader() failed: %v", err)
+	}
+
+	pder.leaderToken = token
+	SessManager.StopGC() //releases leadership
+	pder.leaderToken = other_token
+	if ok, err := pder.AcquireGCLeader("test", time.Minute); err != nil || !ok {
+		t.Fatalf("AcquireGCLeader() of other instance after release wanted leader, got %v, %v", ok, err)
+	}
+	pder.leaderToken = token
+}
+
+// TestSessionMeta reads metadata of a session bound to client fingerprint in both storage modes.
+func TestSessionMeta(t *testing.T) {
+	for _, mode := range []string{MODE_KEYS, MODE_HASH} {
+		SessManager, err := session.NewManager(PROVIDER, 0, 0, "", getTestVar(t, ENV_REDIS_CONN), getTestVar(t, ENV_REDIS_NAMESPACE), mode)
+		if err != nil {
+			t.Fatalf("NewManager() failed: %v", err)
+		}
+		SessManager.SetFingerprint(session.FINGERPRINT_REJECT, nil)
+		r := httptest.NewRequest("GET", "/", nil)
+		currentSession, err := SessManager.SessionStartHTTP(r, "")
+		if err != nil {
+			t.Fatalf("SessionStartHTTP() failed: %v", err)
+		}
+		sid := currentSession.SessionID()
+		if err := currentSession.Put("key", strings.Repeat("v", 100)); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+		if err := currentSession.SetExpiry(time.Hour); err != nil {
+			t.Fatalf("SetExpiry() failed: %v", err)
+		}
+
+		meta, err := SessManager.SessionMeta(sid)
+		if err != nil {
+			t.Fatalf("%s mode: SessionMeta() failed: %v", mode, err)
+		}
+		if meta.ID != sid || meta.Provider != PROVIDER {
+			t.Errorf("%s mode: unexpected ID %q or provider %q", mode, meta.ID, meta.Provider)
+		}
+		if fp := session.DefaultFingerprint(r); meta.Fingerprint != fp {
+			t.Errorf("%s mode: fingerprint wanted %q, got %q", mode, fp, meta.Fingerprint)
+		}
+		if meta.TimeAccessed.IsZero() {
+			t.Errorf("%s mode: access time is not set", mode)
+		}
+		if till := time.Until(meta.ExpiresAt); till <= 0 || till > time.Hour {
+			t.Errorf("%s mode: unexpected expiration time %v", mode, meta.ExpiresAt)
+		}
+		if meta.Size < 100 {
+			t.Errorf("%s mode: size wanted at least 100, got %d", mode, meta.Size)
+		}
+
+		if err := SessManager.SessionDestroy(sid); err != nil {
+			t.Errorf("SessionDestroy() failed: %v", err)
+		}
+		if _, err := SessManager.SessionMeta(sid); !errors.Is(err, session.ErrSessionNotFound) {
+			t.Errorf("%s mode: SessionMeta() of destroyed session wanted ErrSessionNotFound, got %v", mode, err)
+		}
+	}
+	//restore default mode for other tests
+	if _, err := NewManager(t, 0, 0, ""); err != nil {
+		t.Errorf("NewManager() failed: %v", err)
+	}
+}
+
+// TestSetGetMany sets and reads several values at once in both storage modes,
+// values are read from redis by a new session and from memory by a read one.
+func TestSetGetMany(t *testing.T) {
+	for _, mode := range []string{MODE_KEYS, MODE_HASH} {
+		SessManager, err := session.NewManager(PROVIDER, 0, 0, "", getTestVar(t, ENV_REDIS_CONN), getTestVar(t, ENV_REDIS_NAMESPACE), mode)
+		if err != nil {
+			t.Fatalf("NewManager() failed: %v", err)
+		}
+		newSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		sid := newSession.SessionID()
+		if err := newSession.SetMany(map[string]interface{}{"name": "john", "age": int64(42)}); err != nil {
+			t.Fatalf("%s mode: SetMany() failed: %v", mode, err)
+		}
+		readSession, err := SessManager.SessionStart(sid)
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		for _, sess := range []session.Session{newSession, readSession} {
+			var name string
+			var age int64
+			var missing bool
+			dest := map[string]interface{}{"name": &name, "age": &age, "missing": &missing}
+			if err := sess.GetMany(dest); err != nil {
+				t.Fatalf("%s mode: GetMany() failed: %v", mode, err)
+			}
+			if name != "john" || age != 42 {
+				t.Errorf("%s mode: unexpected values %q, %d", mode, name, age)
+			}
+			if _, ok := dest["missing"]; ok || len(dest) != 2 {
+				t.Errorf("%s mode: missing key wanted to be deleted from dest, got %v", mode, dest)
+			}
+		}
+		if err := SessManager.SessionDestroy(sid); err != nil {
+			t.Errorf("SessionDestroy() failed: %v", err)
+		}
+	}
+	//restore default mode for other tests
+	if _, err := NewManager(t, 0, 0, ""); err != nil {
+		t.Errorf("NewManager() failed: %v", err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	for _, mode := range []string{MODE_KEYS, MODE_HASH} {
+		SessManager, err := session.NewManager(PROVIDER, 0, 0, "", getTestVar(t, ENV_REDIS_CONN), getTestVar(t, ENV_REDIS_NAMESPACE), mode)
+		if err != nil {
+			t.Fatalf("NewManager() failed: %v", err)
+		}
+		newSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		sid := newSession.SessionID()
+		if err := newSession.SetMany(map[string]interface{}{"name": "john", "age": int64(42)}); err != nil {
+			t.Fatalf("%s mode: SetMany() failed: %v", mode, err)
+		}
+		snapshot, err := newSession.Snapshot()
+		if err != nil {
+			t.Fatalf("%s mode: Snapshot() failed: %v", mode, err)
+		}
+		if len(snapshot) != 2 {
+			t.Fatalf("%s mode: expected 2 snapshot values, got %v", mode, snapshot)
+		}
+		newSession.Set("name", "jane")
+		newSession.Set("extra", true)
+		newSession.Delete("age")
+		if err := newSession.Restore(snapshot); err != nil {
+			t.Fatalf("%s mode: Restore() failed: %v", mode, err)
+		}
+
+		readSession, err := SessManager.SessionStart(sid)
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		if name, age := readSession.GetString("name"), readSession.GetInt("age"); name != "john" || age != 42 {
+			t.Errorf("%s mode: unexpected restored values %q, %d", mode, name, age)
+		}
+		if keys, _ := readSession.Keys(); len(keys) != 2 {
+			t.Errorf("%s mode: expected 2 keys after Restore(), got %v", mode, keys)
+		}
+		if err := SessManager.SessionDestroy(sid); err != nil {
+			t.Errorf("SessionDestroy() failed: %v", err)
+		}
+	}
+	//restore default mode for other tests
+	if _, err := NewManager(t, 0, 0, ""); err != nil {
+		t.Errorf("NewManager() failed: %v", err)
+	}
+}
+
+// TestSetWithTTL checks TTL of the value key in keys mode, hash mode requires Redis 7.4 or later.
+func TestSetWithTTL(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	newSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := newSession.SessionID()
+	defer SessManager.SessionDestroy(sid)
+	clock := session.NewManualClock(time.Now())
+	SessManager.SetClock(clock)
+	defer SessManager.SetClock(nil)
+	if err := newSession.SetWithTTL("otp", "1234", time.Second); err != nil {
+		t.Fatalf("SetWithTTL() failed: %v", err)
+	}
+	if got := newSession.GetString("otp"); got != "1234" {
+		t.Errorf("expected otp value, got %q", got)
+	}
+	ttl, err := pder.client.PTTL(context.Background(), pder.getPrefixedKey(sid, "otp")).Result()
+	if err != nil {
+		t.Fatalf("PTTL() failed: %v", err)
+	}
+	if ttl <= 0 || ttl > time.Second {
+		t.Errorf("expected otp key TTL up to 1s, got %v", ttl)
+	}
+
+	//values read by SessionRead() expire in memory
+	readSession, err := SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if err := readSession.SetWithTTL("code", "x", 100*time.Millisecond); err != nil {
+		t.Fatalf("SetWithTTL() failed: %v", err)
+	}
+	if got := readSession.GetString("code"); got != "x" {
+		t.Errorf("expected code value, got %q", got)
+	}
+	clock.Advance(150 * time.Millisecond)
+	if got := readSession.GetString("code"); got != "" {
+		t.Errorf("expected expired code value, got %q", got)
+	}
+	if keys, _ := readSession.Keys(); len(keys) != 1 || keys[0] != "otp" {
+		t.Errorf("expected otp key only, got %v", keys)
+	}
+
+	//TTL is kept by Snapshot() and Restore()
+	if err := readSession.SetWithTTL("code", "y", time.Minute); err != nil {
+		t.Fatalf("SetWithTTL() failed: %v", err)
+	}
+	snapshot, err := readSession.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() failed: %v", err)
+	}
+	if _, ok := snapshot["code"].(session.ExpiringValue); !ok {
+		t.Fatalf("Snapshot() code wanted session.ExpiringValue, got %T", snapshot["code"])
+	}
+	if err := readSession.Put("code", "z"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := readSession.Restore(snapshot); err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+	if got := readSession.GetString("code"); got != "y" {
+		t.Errorf("expected restored code value, got %q", got)
+	}
+	ttl, err = pder.client.PTTL(context.Background(), pder.getPrefixedKey(sid, "code")).Result()
+	if err != nil {
+		t.Fatalf("PTTL() failed: %v", err)
+	}
+	if ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected restored code key TTL up to 1m, got %v", ttl)
+	}
+}
+
+// TestConformance runs testkit conformance suite.
+func TestConformance(t *testing.T) {
+	testkit.Run(t, func(t *testing.T, maxLifeTime, maxIdleTime int64) *session.Manager {
+		manager, err := NewManager(t, maxLifeTime, maxIdleTime, "")
+		if err != nil {
+			t.Fatalf("NewManager() failed: %v", err)
+		}
+		return manager
+	})
+}
+
+// TestUserIndex binds sessions to users and destroys sessions of a user found with the index.
+func TestUserIndex(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	SessManager.SetMaxUserSessions(2, session.USER_LIMIT_EVICT)
+	users := []string{"user1", "user2", "user1", "user1"}
+	sids := make([]string, len(users))
+	for i, userID := range users {
+		currentSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		sids[i] = currentSession.SessionID()
+		if err := SessManager.BindUser(currentSession, userID); err != nil {
+			t.Fatalf("BindUser() failed: %v", err)
+		}
+		SessManager.SessionClose(sids[i])
+	}
+	list, err := pder.SessionUserIndex().Sessions("user1")
+	if err != nil || len(list) != 2 || list[0].ID != sids[2] || list[1].ID != sids[3] {
+		t.Fatalf("Sessions() wanted %v, got %v, error: %v", sids[2:], list, err)
+	}
+	if _, err := SessManager.SessionMeta(sids[0]); !errors.Is(err, session.ErrSessionNotFound) {
+		t.Fatalf("the oldest session wanted evicted, SessionMeta() error: %v", err)
+	}
+
+	if err := SessManager.DestroySessionsForUser("user1"); err != nil {
+		t.Fatalf("DestroySessionsForUser() failed: %v", err)
+	}
+	for _, sid := range sids[2:] {
+		if _, err := SessManager.SessionMeta(sid); !errors.Is(err, session.ErrSessionNotFound) {
+			t.Fatalf("SessionMeta() of destroyed session wanted ErrSessionNotFound, got %v", err)
+		}
+	}
+	if _, err := SessManager.SessionMeta(sids[1]); err != nil {
+		t.Fatalf("session of another user destroyed, SessionMeta() error: %v", err)
+	}
+	if err := SessManager.DestroySessionsForUser("user2"); err != nil {
+		t.Fatalf("DestroySessionsForUser() failed: %v", err)
+	}
+}
+
+// TestConfig initializes provider with Config.
+func TestConfig(t *testing.T) {
+	SessManager, err := session.NewManager(PROVIDER, 0, 0, "", Config{
+		URL:         getTestVar(t, ENV_REDIS_CONN),
+		Namespace:   getTestVar(t, ENV_REDIS_NAMESPACE),
+		Mode:        MODE_HASH,
+		PoolSize:    4,
+		DialTimeout: 2 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	if opts := pder.client.(*redis.Client).Options(); opts.DialTimeout != 2*time.Second || opts.ReadTimeout != 3*time.Second {
+		t.Fatalf("Config timeouts are not applied: dial %v, read %v", opts.DialTimeout, opts.ReadTimeout)
+	}
+	if !pder.hashMode || pder.client.(*redis.Client).Options().PoolSize != 4 {
+		t.Fatalf("Config is not applied: hash mode %v, pool size %d", pder.hashMode, pder.client.(*redis.Client).Options().PoolSize)
+	}
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	defer SessManager.SessionDestroy(currentSession.SessionID())
+	if err := currentSession.Set("key", "value"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+
+	if _, err := session.NewManager(PROVIDER, 0, 0, "", Config{URL: "redis://localhost", Mode: "list"}); err == nil {
+		t.Fatal("NewManager() wanted error for unknown Config.Mode")
+	}
+}
+
+// TestChangedHook checks keyspace notifications of sessions changed by another provider instance.
+func TestChangedHook(t *testing.T) {
+	cfg := Config{URL: getTestVar(t, ENV_REDIS_CONN), Namespace: getTestVar(t, ENV_REDIS_NAMESPACE)}
+	SessManager, err := session.NewManagerWithProvider(NewProvider(), 0, 0, "", cfg)
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	defer SessManager.CloseProvider()
+	other := NewProvider()
+	OtherManager, err := session.NewManagerWithProvider(other, 0, 0, "", cfg)
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	defer OtherManager.CloseProvider()
+	if err := other.client.ConfigSet(context.Background(), "notify-keyspace-events", KEYSPACE_EVENTS).Err(); err != nil {
+		t.Skipf("keyspace notifications can not be enabled: %v", err)
+	}
+
+	changed := make(chan string, 100)
+	if err := OtherManager.OnSessionChanged(func(sid string) { changed <- sid }); err != nil {
+		t.Fatalf("OnSessionChanged() failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond) //subscription is made asynchronously
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	waitChanged := func(action string) {
+		t.Helper()
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case changed_sid := <-changed:
+				if changed_sid == sid {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("session change is not reported after %s", action)
+			}
+		}
+	}
+	if err := currentSession.Put("key", "value"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	waitChanged("Put()")
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+	waitChanged("SessionDestroy()")
+}
+
+// TestChangedSession checks keyspace events reported as session changes.
+func TestChangedSession(t *testing.T) {
+	keys_pder := &Provider{namespace: "app"}
+	hash_pder := &Provider{namespace: "app", hashMode: true}
+	tests := []struct {
+		pder    *Provider
+		channel string
+		event   string
+		sid     string
+		ok      bool
+	}{
+		{keys_pder, "__keyspace@0__:app:s1:user", "set", "s1", true},
+		{keys_pder, "__keyspace@0__:app:s1:user", "del", "s1", true},
+		{keys_pder, "__keyspace@0__:app:s1:user", "expire", "", false},
+		{keys_pder, "__keyspace@0__:app:s1:" + KEY_TIME_ACCESSED, "set", "", false},
+		{keys_pder, "__keyspace@0__:app:s1:" + LOCK_KEY, "del", "", false},
+		{keys_pder, "__keyspace@0__:other:s1:user", "set", "", false},
+		{hash_pder, "__keyspace@0__:app:s1", "hset", "", false},
+		{hash_pder, "__keyspace@0__:app:s1", "expired", "s1", true},
+		{hash_pder, "__keyspace@0__:app:s1:" + LOCK_KEY, "del", "", false},
+	}
+	for _, tc := range tests {
+		sid, ok := tc.pder.changedSession(tc.channel, tc.event)
+		if sid != tc.sid || ok != tc.ok {
+			t.Errorf("changedSession(%q, %q) = %q, %v, wanted %q, %v", tc.channel, tc.event, sid, ok, tc.sid, tc.ok)
+		}
+	}
+}
+
+// TestConfigClient initializes provider with an existing client which is left open on close.
+func TestConfigClient(t *testing.T) {
+	opts, err := redis.ParseURL(getTestVar(t, ENV_REDIS_CONN))
+	if err != nil {
+		t.Fatalf("ParseURL() failed: %v", err)
+	}
+	client := redis.NewClient(opts)
+	defer client.Close()
+
+	prov := NewProvider()
+	SessManager, err := session.NewManagerWithProvider(prov, 0, 0, "", Config{Client: client, Namespace: getTestVar(t, ENV_REDIS_NAMESPACE)})
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	if prov.db != opts.DB {
+		t.Fatalf("db = %d, wanted %d", prov.db, opts.DB)
+	}
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if err := currentSession.Set("key", "value"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := SessManager.SessionDestroy(currentSession.SessionID()); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+	if err := SessManager.CloseProvider(); err != nil {
+		t.Fatalf("CloseProvider() failed: %v", err)
+	}
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Fatalf("client is closed by CloseProvider(): %v", err)
+	}
+
+	if _, err := session.NewManagerWithProvider(NewProvider(), 0, 0, "", Config{URL: "redis://localhost", Client: client}); err == nil {
+		t.Fatal("NewManagerWithProvider() wanted error for both Config.URL and Config.Client")
+	}
+}
+
+// TestConfigTLS checks TLS config validation.
+func TestConfigTLS(t *testing.T) {
+	cfg := Config{URL: "redis://localhost", TLS: &session.TLSConfig{CAFile: "not_exists.pem"}}
+	if _, err := session.NewManagerWithProvider(NewProvider(), 0, 0, "", cfg); err == nil {
+		t.Fatal("NewManagerWithProvider() wanted error for missing TLSConfig.CAFile")
+	}
+	cfg = Config{Client: redis.NewClient(&redis.Options{}), TLS: &session.TLSConfig{}}
+	if _, err := session.NewManagerWithProvider(NewProvider(), 0, 0, "", cfg); err == nil {
+		t.Fatal("NewManagerWithProvider() wanted error for Config.TLS with Config.Client")
+	}
+}
+
+// TestStats checks session statistics of sampled sessions.
+func TestStats(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	SessManager.DestroyAllSessions(os.Stderr, session.LOG_LEVEL_ERROR)
+	defer SessManager.DestroyAllSessions(os.Stderr, session.LOG_LEVEL_ERROR)
+	for i := 1; i <= 3; i++ {
+		sess, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		if err := sess.Set("value", strings.Repeat("x", i*100)); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+		if err := sess.Flush(); err != nil {
+			t.Fatalf("Flush() failed: %v", err)
+		}
+		SessManager.SessionClose(sess.SessionID())
+	}
+
+	stats, err := SessManager.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("Stats() failed: %v", err)
+	}
+	if stats.Provider != PROVIDER || stats.Count != 3 || stats.Sampled != 3 {
+		t.Fatalf("Stats() = %+v, wanted 3 sessions of %s", stats, PROVIDER)
+	}
+	if stats.Size.Min <= 0 || stats.Size.Max <= stats.Size.Min || stats.StorageBytes <= 0 {
+		t.Fatalf("Stats() Size = %+v, StorageBytes = %d", stats.Size, stats.StorageBytes)
+	}
+}
+
+// TestSensitiveValue checks that sensitive value is read decrypted and left out of debug dump.
+func TestSensitiveValue(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	if err := session.SetSensitiveKeys([]byte("sensitive key")); err != nil {
+		t.Fatalf("SetSensitiveKeys() failed: %v", err)
+	}
+	defer session.SetSensitiveKeys()
+	sess, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := sess.SessionID()
+	defer SessManager.SessionDestroy(sid)
+	if err := sess.SetSensitive("email", "user@example.com"); err != nil {
+		t.Fatalf("SetSensitive() failed: %v", err)
+	}
+	SessManager.SessionClose(sid)
+
+	sess, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	defer SessManager.SessionClose(sid)
+	if v := sess.GetString("email"); v != "user@example.com" {
+		t.Fatalf("GetString() = %q, wanted decrypted value", v)
+	}
+	var v interface{}
+	if err := sess.Get("email", &v); err != nil || v != "user@example.com" {
+		t.Fatalf("Get() = %v, %v, wanted decrypted value", v, err)
+	}
+	var buf bytes.Buffer
+	if _, err := SessManager.DebugDump(&buf, 100, session.DEBUG_VALUES_HASHED); err != nil {
+		t.Fatalf("DebugDump() failed: %v", err)
+	}
+	if dump := buf.String(); !strings.Contains(dump, " email=sensitive(") {
+		t.Fatalf("DebugDump() sensitive value is not redacted:\n%s", dump)
+	}
+}
+
+// TestProviderInstances checks that providers created with NewProvider() use their own namespace
+// and expiration mode, not those of the registered provider.
+func TestProviderInstances(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	if err := SessManager.SetExpirationMode(session.EXPIRATION_SLIDING); err != nil {
+		t.Fatalf("SetExpirationMode() failed: %v", err)
+	}
+	ctx := context.Background()
+	for _, mode := range []string{MODE_KEYS, MODE_HASH} {
+		other := NewProvider()
+		OtherManager, err := session.NewManagerWithProvider(other, 0, 0, "", Config{
+			URL:       getTestVar(t, ENV_REDIS_CONN),
+			Namespace: getTestVar(t, ENV_REDIS_NAMESPACE) + "_other",
+			Mode:      mode,
+		})
+		if err != nil {
+			t.Fatalf("NewManagerWithProvider() failed: %v", err)
+		}
+		if err := OtherManager.SetExpirationMode(session.EXPIRATION_FIXED); err != nil {
+			t.Fatalf("SetExpirationMode() failed: %v", err)
+		}
+		currentSession, err := OtherManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		sid := currentSession.SessionID()
+		if err := currentSession.Set("instance", mode); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+		keys, err := currentSession.Keys()
+		if err != nil || len(keys) != 1 || keys[0] != "instance" {
+			t.Fatalf("mode %s: Keys() = %v, %v, wanted [instance]", mode, keys, err)
+		}
+		if _, err := SessManager.SessionMeta(sid); !errors.Is(err, session.ErrSessionNotFound) {
+			t.Fatalf("mode %s: SessionMeta() of another instance wanted ErrSessionNotFound, got %v", mode, err)
+		}
+
+		//fixed expiration mode keeps access time written on the first write
+		past := time.Now().Add(-time.Hour).Truncate(time.Second)
+		past_b, err := other.encodeValue(past)
+		if err != nil {
+			t.Fatalf("encodeValue() failed: %v", err)
+		}
+		if err := other.setEncodedValues(sid, map[string][]byte{KEY_TIME_ACCESSED: past_b}, 0); err != nil {
+			t.Fatalf("setEncodedValues() failed: %v", err)
+		}
+		if err := currentSession.Put("instance", mode+"2"); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+		values, err := other.readValues(sid)
+		if err != nil {
+			t.Fatalf("readValues() failed: %v", err)
+		}
+		var accessed time.Time
+		if err := other.decodeValue(values[KEY_TIME_ACCESSED], &accessed); err != nil || !accessed.Equal(past) {
+			t.Fatalf("mode %s: access time is %v, %v after write in fixed expiration mode, wanted %v", mode, accessed, err, past)
+		}
+
+		if err := currentSession.SetExpiry(time.Hour); err != nil {
+			t.Fatalf("SetExpiry() failed: %v", err)
+		}
+		if mode == MODE_KEYS {
+			if ttl := other.client.TTL(ctx, other.getPrefixedKey(sid, "instance")).Val(); ttl <= 0 {
+				t.Fatalf("SetExpiry() did not set TTL of session value, TTL is %v", ttl)
+			}
+		}
+		if err := OtherManager.SessionDestroy(sid); err != nil {
+			t.Fatalf("SessionDestroy() failed: %v", err)
+		}
+		OtherManager.CloseProvider()
+	}
+}
+
+// TestCircuitBreaker checks that failed writes open the breaker while values are read from memory.
+func TestCircuitBreaker(t *testing.T) {
+	cfg := Config{URL: getTestVar(t, ENV_REDIS_CONN), Namespace: getTestVar(t, ENV_REDIS_NAMESPACE)}
+	pder := NewProvider()
+	SessManager, err := session.NewManagerWithProvider(pder, 0, 0, "", cfg)
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	SessManager.SetCircuitBreaker(3, time.Hour, false)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Set("key", "value"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	defer func() {
+		OtherManager, err := NewManager(t, 0, 0, "")
+		if err != nil {
+			t.Fatalf("NewManager() failed: %v", err)
+		}
+		OtherManager.SessionDestroy(sid)
+	}()
+
+	//values are read into memory
+	if currentSession, err = SessManager.SessionStart(sid); err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	pder.client.Close() //backend is down
+	for i := 0; i < 3; i++ {
+		if got := currentSession.GetString("key"); got != "value" {
+			t.Fatalf("GetString() = %q, wanted value from memory", got)
+		}
+		if err := currentSession.Flush(); err == nil {
+			t.Fatal("Flush() succeeded with closed client")
+		}
+	}
+	if st := SessManager.BreakerState(); st != session.BREAKER_OPEN {
+		t.Fatalf("BreakerState() wanted open after failed flushes, got %s", st)
+	}
+	if err := currentSession.Flush(); !errors.Is(err, session.ErrBackendUnavailable) {
+		t.Fatalf("Flush() wanted ErrBackendUnavailable, got %v", err)
+	}
+	var got string
+	if err := currentSession.Get("key", &got); err != nil || got != "value" {
+		t.Fatalf("Get() = %q, %v, wanted value from memory with open breaker", got, err)
+	}
+}
+
+// TestSessionPeek checks that peeked session values are read without updating access time in both storage modes
+// and that missing sessions are not created.
+func TestSessionPeek(t *testing.T) {
+	for _, mode := range []string{MODE_KEYS, MODE_HASH} {
+		cfg := Config{URL: getTestVar(t, ENV_REDIS_CONN), Namespace: getTestVar(t, ENV_REDIS_NAMESPACE), Mode: mode}
+		pder := NewProvider()
+		SessManager, err := session.NewManagerWithProvider(pder, 0, 0, "", cfg)
+		if err != nil {
+			t.Fatalf("NewManagerWithProvider() failed: %v", err)
+		}
+		clock := session.NewManualClock(time.Now())
+		SessManager.SetClock(clock)
+		currentSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		sid := currentSession.SessionID()
+		if err := currentSession.Put("key", "value"); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+		SessManager.SessionClose(sid)
+		meta, err := SessManager.SessionMeta(sid)
+		if err != nil {
+			t.Fatalf("%s mode: SessionMeta() failed: %v", mode, err)
+		}
+
+		clock.Advance(time.Minute)
+		peeked, err := pder.SessionPeek(sid)
+		if err != nil {
+			t.Fatalf("%s mode: SessionPeek() failed: %v", mode, err)
+		}
+		if got := peeked.GetString("key"); got != "value" {
+			t.Errorf("%s mode: GetString() of peeked session = %q, wanted value", mode, got)
+		}
+		if after, err := SessManager.SessionMeta(sid); err != nil || !after.TimeAccessed.Equal(meta.TimeAccessed) {
+			t.Errorf("%s mode: access time changed from %v to %v by peek, %v", mode, meta.TimeAccessed, after.TimeAccessed, err)
+		}
+		if err := SessManager.SessionDestroy(sid); err != nil {
+			t.Errorf("SessionDestroy() failed: %v", err)
+		}
+		if _, err := pder.SessionPeek(sid); !errors.Is(err, session.ErrSessionNotFound) {
+			t.Errorf("%s mode: SessionPeek() of destroyed session wanted ErrSessionNotFound, got %v", mode, err)
+		}
+		if _, err := SessManager.SessionMeta(sid); !errors.Is(err, session.ErrSessionNotFound) {
+			t.Errorf("%s mode: session is created by peek: %v", mode, err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dronm/session"
+	"github.com/redis/go-redis/v9"
+)
+
+// userIndex keeps sessions bound to users in sorted sets scored by bind time in Unix microseconds,
+// see USERS_KEY and USER_ID_KEY.
+type userIndex struct {
+	pder *Provider
+}
+
+// SessionUserIndex implements session.UserIndexProvider.
+func (pder *Provider) SessionUserIndex() session.UserIndex {
+	return &userIndex{pder: pder}
+}
+
+func (ix *userIndex) usersKey(userID string) string {
+	return ix.pder.namespace + USERS_KEY + userID
+}
+
+func (ix *userIndex) userIDKey(sid string) string {
+	return ix.pder.namespace + USER_ID_KEY + sid
+}
+
+// boundUser returns user ID the session is bound to, empty string if it is not bound.
+func (ix *userIndex) boundUser(ctx context.Context, sid string) (string, error) {
+	userID, err := ix.pder.client.Get(ctx, ix.userIDKey(sid)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return userID, err
+}
+
+func (ix *userIndex) Add(userID, sid string, boundAt time.Time) error {
+	ctx := context.Background()
+	old_user, err := ix.boundUser(ctx, sid)
+	if err != nil {
+		return err
+	}
+	_, err = ix.pder.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if old_user != "" && old_user != userID {
+			pipe.ZRem(ctx, ix.usersKey(old_user), sid)
+		}
+		pipe.ZAdd(ctx, ix.usersKey(userID), redis.Z{Score: float64(boundAt.UnixMicro()), Member: sid})
+		pipe.Set(ctx, ix.userIDKey(sid), userID, 0)
+		return nil
+	})
+	return err
+}
+
+func (ix *userIndex) Remove(sid string) error {
+	ctx := context.Background()
+	userID, err := ix.boundUser(ctx, sid)
+	if err != nil || userID == "" {
+		return err
+	}
+	_, err = ix.pder.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, ix.usersKey(userID), sid)
+		pipe.Del(ctx, ix.userIDKey(sid))
+		return nil
+	})
+	return err
+}
+
+func (ix *userIndex) Sessions(userID string) ([]session.UserSession, error) {
+	members, err := ix.pder.client.ZRangeWithScores(context.Background(), ix.usersKey(userID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]session.UserSession, len(members))
+	for i, m := range members {
+		list[i] = session.UserSession{ID: m.Member.(string), BoundAt: time.UnixMicro(int64(m.Score))}
+	}
+	return list, nil
+}
@@ -0,0 +1,112 @@
+package session
+
+import (
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// TypeRegistrar registers value type with a codec, e.g. gob.Register().
+type TypeRegistrar func(value interface{}) error
+
+var (
+	typesMx         sync.RWMutex
+	registeredTypes = make(map[reflect.Type]interface{}) //sample values by type
+	typeRegistrars  = []TypeRegistrar{registerGob}
+)
+
+// registerGob registers value with gob, panics of conflicting registrations are returned as errors.
+func registerGob(value interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	gob.Register(value)
+	return nil
+}
+
+// RegisterType registers concrete type of value with gob and registrars added by AddTypeRegistrar(),
+// so values of the type can be stored in sessions. Registering a type again does nothing.
+// Types of values set through Manager sessions are registered automatically,
+// see Manager.SetAutoRegisterTypes(), RegisterType() is needed for types read before they are set,
+// e.g. by another application instance, and for types nested in interface fields of values.
+func RegisterType(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	t := reflect.TypeOf(value)
+	typesMx.RLock()
+	_, ok := registeredTypes[t]
+	typesMx.RUnlock()
+	if ok {
+		return nil
+	}
+	typesMx.Lock()
+	defer typesMx.Unlock()
+	if _, ok := registeredTypes[t]; ok {
+		return nil
+	}
+	for _, registrar := range typeRegistrars {
+		if err := registrar(value); err != nil {
+			return fmt.Errorf("session: RegisterType(%s): %w", t, err)
+		}
+	}
+	registeredTypes[t] = value
+	return nil
+}
+
+// AddTypeRegistrar adds registrar of value types with another codec,
+// it is called for already registered types at once.
+func AddTypeRegistrar(registrar TypeRegistrar) error {
+	typesMx.Lock()
+	defer typesMx.Unlock()
+	for t, value := range registeredTypes {
+		if err := registrar(value); err != nil {
+			return fmt.Errorf("session: AddTypeRegistrar(%s): %w", t, err)
+		}
+	}
+	typeRegistrars = append(typeRegistrars, registrar)
+	return nil
+}
+
+// registerValueType registers type of a value set to a session, errors are ignored:
+// a type registered with gob.RegisterName() under another name is encoded anyway.
+func registerValueType(value interface{}) {
+	if exp, ok := value.(ExpiringValue); ok {
+		value = exp.Value
+	}
+	RegisterType(value)
+}
+
+// SetAutoRegisterTypes enables or disables registration of value types
+// on Set(), Put(), SetMany(), SetWithTTL() and CompareAndSwap() of manager sessions, see RegisterType().
+// Enabled by default.
+func (manager *Manager) SetAutoRegisterTypes(enable bool) {
+	manager.noAutoRegister = !enable
+}
+
+// gob error messages naming unregistered types on decoding and encoding.
+var gob_unregistered = regexp.MustCompile(`gob: (?:name|type) not registered for interface: "?([^"\s]+)"?`)
+
+// TypeError returns err wrapped with ErrTypeNotRegistered listing unregistered types
+// if err is a gob error of an unregistered type, err otherwise.
+// Providers wrap gob encoding and decoding errors of session values with this function.
+func TypeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	matches := gob_unregistered.FindAllStringSubmatch(err.Error(), -1)
+	if len(matches) == 0 {
+		return err
+	}
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = m[1]
+	}
+	return fmt.Errorf("%w: %s, call session.RegisterType() for them: %w",
+		ErrTypeNotRegistered, strings.Join(names, ", "), err)
+}
@@ -0,0 +1,520 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// ReplicatedProvider writes every session modification to all its providers
+// and reads sessions from the first healthy ones.
+// Operation succeeds if at least quorum providers succeed.
+// Sessions are read from providers in order until quorum providers respond,
+// the most recently accessed session is used then (conflict resolution by access time).
+//
+// Session lock is acquired with the provider the session is read from only.
+type ReplicatedProvider struct {
+	providers []Provider
+	quorum    int
+}
+
+// NewReplicatedProvider returns provider replicating sessions to providers.
+// Quorum 0 means majority of providers. The result should be registered
+// under its own name with Register(), then used with NewManager(), provider parameters
+// are []interface{} parameters of every provider in the same order:
+//
+//	redis_pder, _ := session.LookupProvider("redis")
+//	pg_pder, _ := session.LookupProvider("pg")
+//	session.Register("redis_pg", session.NewReplicatedProvider(1, redis_pder, pg_pder))
+//	SessManager, err := session.NewManager("redis_pg", 0, 3600, "",
+//		[]interface{}{REDIS_ADDR, REDIS_NAMESPACE}, []interface{}{PG_CONN})
+//
+// Providers are package level instances, so every provider package can be used once.
+func NewReplicatedProvider(quorum int, providers ...Provider) *ReplicatedProvider {
+	if quorum <= 0 || quorum > len(providers) {
+		quorum = len(providers)/2 + 1
+	}
+	return &ReplicatedProvider{providers: providers, quorum: quorum}
+}
+
+// splitProviderParams checks that provParams consists of n []interface{} slices of providers parameters.
+func splitProviderParams(provParams []interface{}, n int) ([][]interface{}, error) {
+	if len(provParams) != n {
+		return nil, fmt.Errorf("session: %d provider parameter slices expected, got %d", n, len(provParams))
+	}
+	params := make([][]interface{}, n)
+	for i, p := range provParams {
+		var ok bool
+		if params[i], ok = p.([]interface{}); !ok {
+			return nil, fmt.Errorf("session: provider %d parameters must be of type []interface{}", i)
+		}
+	}
+	return params, nil
+}
+
+// quorumError returns ErrQuorum with provider errors if less then quorum providers succeeded.
+func quorumError(succeeded, quorum int, errs []error) error {
+	if succeeded >= quorum {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrQuorum, errors.Join(errs...))
+}
+
+// each calls fn for all providers, quorum error is returned.
+func (rpder *ReplicatedProvider) each(fn func(Provider) error) error {
+	succeeded := 0
+	var errs []error
+	for _, p := range rpder.providers {
+		if err := fn(p); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		succeeded++
+	}
+	return quorumError(succeeded, rpder.quorum, errs)
+}
+
+// InitProvider initializes all providers.
+func (rpder *ReplicatedProvider) InitProvider(provParams []interface{}) error {
+	params, err := splitProviderParams(provParams, len(rpder.providers))
+	if err != nil {
+		return err
+	}
+	i := 0
+	return rpder.each(func(p Provider) error {
+		err := p.InitProvider(params[i])
+		i++
+		return err
+	})
+}
+
+// CloseProvider closes all providers.
+func (rpder *ReplicatedProvider) CloseProvider() error {
+	var errs []error
+	for _, p := range rpder.providers {
+		errs = append(errs, p.CloseProvider())
+	}
+	return errors.Join(errs...)
+}
+
+// Ping checks all providers, quorum error is returned.
+func (rpder *ReplicatedProvider) Ping(ctx context.Context) error {
+	return rpder.each(func(p Provider) error {
+		return p.Ping(ctx)
+	})
+}
+
+// SessionInit creates session with all providers.
+func (rpder *ReplicatedProvider) SessionInit(sid string) (Session, error) {
+	var sessions []Session
+	var replicas []Provider
+	err := rpder.each(func(p Provider) error {
+		sess, err := p.SessionInit(sid)
+		if err != nil {
+			return err
+		}
+		sessions = append(sessions, sess)
+		replicas = append(replicas, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	rsess := newReplicatedSession(sessions[0], rpder.quorum, replicas[1:]...)
+	copy(rsess.opened, sessions[1:])
+	return rsess, nil
+}
+
+// SessionRead reads session from providers until quorum providers respond,
+// the most recently accessed session is returned.
+func (rpder *ReplicatedProvider) SessionRead(sid string) (Session, error) {
+	var read []Session
+	var read_pders, other_pders []Provider
+	var errs []error
+	for _, p := range rpder.providers {
+		if len(read) == rpder.quorum {
+			other_pders = append(other_pders, p)
+			continue
+		}
+		sess, err := p.SessionRead(sid)
+		if err != nil {
+			errs = append(errs, err)
+			other_pders = append(other_pders, p)
+			continue
+		}
+		read = append(read, sess)
+		read_pders = append(read_pders, p)
+	}
+	if err := quorumError(len(read), rpder.quorum, errs); err != nil {
+		return nil, err
+	}
+
+	latest := 0
+	for i, sess := range read {
+		if sess.TimeAccessed().After(read[latest].TimeAccessed()) {
+			latest = i
+		}
+	}
+	replicas := make([]Provider, 0, len(rpder.providers)-1)
+	opened := make([]Session, 0, len(rpder.providers)-1)
+	for i, p := range read_pders {
+		if i != latest {
+			replicas = append(replicas, p)
+			opened = append(opened, read[i])
+		}
+	}
+	replicas = append(replicas, other_pders...)
+	rsess := newReplicatedSession(read[latest], rpder.quorum, replicas...)
+	copy(rsess.opened, opened)
+	return rsess, nil
+}
+
+// SessionDestroy destroys session with all providers.
+func (rpder *ReplicatedProvider) SessionDestroy(sid string) error {
+	return rpder.each(func(p Provider) error {
+		return p.SessionDestroy(sid)
+	})
+}
+
+// SessionClose closes session with all providers.
+func (rpder *ReplicatedProvider) SessionClose(sid string) error {
+	return rpder.each(func(p Provider) error {
+		return p.SessionClose(sid)
+	})
+}
+
+// SessionGC runs GC of all providers, the report sums counters of all replicas.
+func (rpder *ReplicatedProvider) SessionGC(l io.Writer, logLev LogLevel) GCReport {
+	var report GCReport
+	for _, p := range rpder.providers {
+		report.Add(p.SessionGC(l, logLev))
+	}
+	return report
+}
+
+// SetGCMaxDeletions implements GCLimiter, the limit is set for every provider.
+func (rpder *ReplicatedProvider) SetGCMaxDeletions(maxDeletions int) {
+	setGCMaxDeletions(maxDeletions, rpder.providers...)
+}
+
+// SetExpirationMode implements ExpirationModeSetter, the mode is set for every provider.
+func (rpder *ReplicatedProvider) SetExpirationMode(mode ExpirationMode) {
+	setExpirationMode(mode, rpder.providers...)
+}
+
+// SetStrictMode implements StrictModeSetter, the mode is set for every provider.
+// ErrNoStrictMode is returned if a provider does not support strict mode.
+func (rpder *ReplicatedProvider) SetStrictMode(strict bool) error {
+	return setStrictMode(strict, rpder.providers...)
+}
+
+// SetClock implements ClockSetter, the clock is set for every provider.
+func (rpder *ReplicatedProvider) SetClock(clock Clock) {
+	setClock(clock, rpder.providers...)
+}
+
+// SessionStats implements StatsProvider: numbers are of the first provider,
+// as all providers keep the same sessions, stats of every provider are in Providers.
+func (rpder *ReplicatedProvider) SessionStats(ctx context.Context, sampleSize int) (SessionStats, error) {
+	list := make([]SessionStats, len(rpder.providers))
+	for i, p := range rpder.providers {
+		stats, err := ProviderStats(ctx, p, sampleSize)
+		if err != nil {
+			return SessionStats{}, fmt.Errorf("provider %d: %w", i, err)
+		}
+		list[i] = stats
+	}
+	stats := list[0]
+	stats.Provider = ""
+	stats.Providers = list
+	return stats, nil
+}
+
+// SessionMeta implements MetaProvider, metadata of the first provider returning it is used.
+func (rpder *ReplicatedProvider) SessionMeta(sid string) (SessionMeta, error) {
+	var errs []error
+	for _, p := range rpder.providers {
+		meta, err := sessionMeta(p, sid)
+		if err == nil {
+			return meta, nil
+		}
+		errs = append(errs, err)
+	}
+	return SessionMeta{}, errors.Join(errs...)
+}
+
+// SessionPeek implements PeekProvider with the first provider having the session.
+func (rpder *ReplicatedProvider) SessionPeek(sid string) (Session, error) {
+	var errs []error
+	for _, p := range rpder.providers {
+		sess, err := sessionPeek(p, sid)
+		if err == nil {
+			return sess, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// AcquireGCLeader implements GCLeaderLocker with the first provider.
+func (rpder *ReplicatedProvider) AcquireGCLeader(name string, ttl time.Duration) (bool, error) {
+	locker, err := gcLeaderLocker(rpder.providers[0])
+	if err != nil {
+		return false, err
+	}
+	return locker.AcquireGCLeader(name, ttl)
+}
+
+// ReleaseGCLeader implements GCLeaderLocker with the first provider.
+func (rpder *ReplicatedProvider) ReleaseGCLeader(name string) error {
+	locker, err := gcLeaderLocker(rpder.providers[0])
+	if err != nil {
+		return err
+	}
+	return locker.ReleaseGCLeader(name)
+}
+
+// DestroyAllSessions destroys all sessions of all providers.
+func (rpder *ReplicatedProvider) DestroyAllSessions(l io.Writer, logLev LogLevel) {
+	for _, p := range rpder.providers {
+		p.DestroyAllSessions(l, logLev)
+	}
+}
+
+// GetSessionIDLen returns session ID length of the first provider.
+func (rpder *ReplicatedProvider) GetSessionIDLen() int {
+	return rpder.providers[0].GetSessionIDLen()
+}
+
+// SetMaxLifeTime sets max life time for all providers.
+func (rpder *ReplicatedProvider) SetMaxLifeTime(maxLifeTime int64) {
+	for _, p := range rpder.providers {
+		p.SetMaxLifeTime(maxLifeTime)
+	}
+}
+
+// GetMaxLifeTime returns max life time.
+func (rpder *ReplicatedProvider) GetMaxLifeTime() int64 {
+	return rpder.providers[0].GetMaxLifeTime()
+}
+
+// SetMaxIdleTime sets max idle time for all providers.
+func (rpder *ReplicatedProvider) SetMaxIdleTime(maxIdleTime int64) {
+	for _, p := range rpder.providers {
+		p.SetMaxIdleTime(maxIdleTime)
+	}
+}
+
+// GetMaxIdleTime returns max idle time.
+func (rpder *ReplicatedProvider) GetMaxIdleTime() int64 {
+	return rpder.providers[0].GetMaxIdleTime()
+}
+
+// SetKeyRing passes key ring to providers implementing EncryptedProvider.
+func (rpder *ReplicatedProvider) SetKeyRing(keyRing *KeyRing) {
+	for _, p := range rpder.providers {
+		if enc_pder, ok := p.(EncryptedProvider); ok {
+			enc_pder.SetKeyRing(keyRing)
+		}
+	}
+}
+
+// SetCompression passes compression to providers implementing CompressedProvider.
+func (rpder *ReplicatedProvider) SetCompression(compression *Compression) {
+	for _, p := range rpder.providers {
+		if comp_pder, ok := p.(CompressedProvider); ok {
+			comp_pder.SetCompression(compression)
+		}
+	}
+}
+
+// SetPayloadVersion passes payload version to providers implementing VersionedProvider.
+func (rpder *ReplicatedProvider) SetPayloadVersion(payloadVersion *PayloadVersion) {
+	for _, p := range rpder.providers {
+		if ver_pder, ok := p.(VersionedProvider); ok {
+			ver_pder.SetPayloadVersion(payloadVersion)
+		}
+	}
+}
+
+// SetLogger passes logger to providers implementing LoggedProvider.
+func (rpder *ReplicatedProvider) SetLogger(logger *slog.Logger) {
+	for _, p := range rpder.providers {
+		if log_pder, ok := p.(LoggedProvider); ok {
+			log_pder.SetLogger(logger)
+		}
+	}
+}
+
+// EnsureSchema creates schema of providers implementing SchemaProvider.
+func (rpder *ReplicatedProvider) EnsureSchema(ctx context.Context) error {
+	return ensureSchema(ctx, rpder.providers...)
+}
+
+// replicatedSession is a session replicating its modifications
+// to the same session of replica providers.
+// Modification succeeds if the session and at least quorum-1 replicas succeed.
+type replicatedSession struct {
+	Session
+	quorum   int
+	replicas []Provider
+	opened   []Session //replica sessions, opened on the first modification
+	failed   []bool    //replica failed, it is not used any more
+}
+
+func newReplicatedSession(sess Session, quorum int, replicas ...Provider) *replicatedSession {
+	return &replicatedSession{Session: sess,
+		quorum:   quorum,
+		replicas: replicas,
+		opened:   make([]Session, len(replicas)),
+		failed:   make([]bool, len(replicas)),
+	}
+}
+
+// replicate calls fn for every replica session.
+func (s *replicatedSession) replicate(fn func(replica Session) error) error {
+	succeeded := 1
+	var errs []error
+	for i, p := range s.replicas {
+		if s.failed[i] {
+			continue
+		}
+		if s.opened[i] == nil {
+			replica, err := p.SessionRead(s.SessionID())
+			if err != nil {
+				s.failed[i] = true
+				errs = append(errs, err)
+				continue
+			}
+			s.opened[i] = replica
+		}
+		if err := fn(s.opened[i]); err != nil {
+			s.failed[i] = true
+			errs = append(errs, err)
+			continue
+		}
+		succeeded++
+	}
+	return quorumError(succeeded, s.quorum, errs)
+}
+
+func (s *replicatedSession) Set(key string, value interface{}) error {
+	if err := s.Session.Set(key, value); err != nil {
+		return err
+	}
+	return s.replicate(func(replica Session) error { return replica.Set(key, value) })
+}
+
+func (s *replicatedSession) Put(key string, value interface{}) error {
+	if err := s.Session.Put(key, value); err != nil {
+		return err
+	}
+	return s.replicate(func(replica Session) error { return replica.Put(key, value) })
+}
+
+func (s *replicatedSession) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	if err := s.Session.SetWithTTL(key, value, ttl); err != nil {
+		return err
+	}
+	return s.replicate(func(replica Session) error { return replica.SetWithTTL(key, value, ttl) })
+}
+
+func (s *replicatedSession) SetSensitive(key string, value interface{}) error {
+	if err := s.Session.SetSensitive(key, value); err != nil {
+		return err
+	}
+	return s.replicate(func(replica Session) error { return replica.SetSensitive(key, value) })
+}
+
+func (s *replicatedSession) SetMany(values map[string]interface{}) error {
+	if err := s.Session.SetMany(values); err != nil {
+		return err
+	}
+	return s.replicate(func(replica Session) error { return replica.SetMany(values) })
+}
+
+func (s *replicatedSession) Restore(snapshot map[string]interface{}) error {
+	if err := s.Session.Restore(snapshot); err != nil {
+		return err
+	}
+	return s.replicate(func(replica Session) error { return replica.Restore(snapshot) })
+}
+
+func (s *replicatedSession) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	return GetOrSet(s, key, dest, compute)
+}
+
+func (s *replicatedSession) Delete(key string) error {
+	if err := s.Session.Delete(key); err != nil {
+		return err
+	}
+	return s.replicate(func(replica Session) error { return replica.Delete(key) })
+}
+
+func (s *replicatedSession) Clear() error {
+	if err := s.Session.Clear(); err != nil {
+		return err
+	}
+	return s.replicate(func(replica Session) error { return replica.Clear() })
+}
+
+func (s *replicatedSession) Increment(key string, delta int64) (int64, error) {
+	val, err := s.Session.Increment(key, delta)
+	if err != nil {
+		return 0, err
+	}
+	return val, s.replicate(func(replica Session) error { return replica.Put(key, val) })
+}
+
+func (s *replicatedSession) Decrement(key string, delta int64) (int64, error) {
+	return s.Increment(key, -delta)
+}
+
+func (s *replicatedSession) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
+	swapped, err := s.Session.CompareAndSwap(key, oldValue, newValue)
+	if err != nil || !swapped {
+		return swapped, err
+	}
+	return true, s.replicate(func(replica Session) error {
+		if newValue == nil {
+			if err := replica.Delete(key); err != nil {
+				return err
+			}
+			return replica.Flush()
+		}
+		return replica.Put(key, newValue)
+	})
+}
+
+func (s *replicatedSession) Bucket(name string) Session {
+	return NewBucket(s, name)
+}
+
+func (s *replicatedSession) SetExpiry(d time.Duration) error {
+	if err := s.Session.SetExpiry(d); err != nil {
+		return err
+	}
+	return s.replicate(func(replica Session) error { return replica.SetExpiry(d) })
+}
+
+func (s *replicatedSession) Touch() error {
+	if err := s.Session.Touch(); err != nil {
+		return err
+	}
+	return s.replicate(func(replica Session) error { return replica.Touch() })
+}
+
+func (s *replicatedSession) Flush() error {
+	if err := s.Session.Flush(); err != nil {
+		return err
+	}
+	return s.replicate(func(replica Session) error { return replica.Flush() })
+}
+
+// ValidateSessionID checks session ID format with the first provider.
+func (rpder *ReplicatedProvider) ValidateSessionID(sid string) error {
+	return ValidateSessionID(rpder.providers[0], sid)
+}
@@ -0,0 +1,398 @@
+package session
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// RequestSession is a session wrapper for one HTTP request.
+// Changes of request handlers are deferred: values set, put and deleted are kept in memory,
+// reads are served from them first, and they are written by Done() when the handler returns
+// with one SetMany() and one Flush(), only if the session was modified.
+// Increment(), Decrement() and CompareAndSwap() are atomic and are written at once,
+// pending values are written before them if their key is pending, before Lock() and GetOrSet()
+// as locking reloads session values. Restore() replaces pending values and is passed to the session.
+//
+//	sess, err := SessManager.SessionStart(sid)
+//	if err != nil {
+//		return err
+//	}
+//	req_sess := session.NewRequestSession(sess)
+//	defer req_sess.Done()
+//	handler(w, r, req_sess)
+type RequestSession struct {
+	Session
+	mx       sync.Mutex
+	pending  map[string]interface{} //values not written yet, as stored by providers, nil for deleted keys
+	cleared  bool                   //values are cleared before pending values are written
+	modified bool                   //session was modified since the last flush
+	clock    Clock                  //value TTLs of pending values
+}
+
+// pendingSensitive is a value set with SetSensitive() not written yet,
+// it is sealed by the session, so its type is registered as for other values.
+type pendingSensitive struct {
+	value interface{}
+}
+
+// NewRequestSession returns request wrapper of sess, TTLs of pending values are of SystemClock.
+// Manager.Middleware() wraps sessions with the manager clock.
+func NewRequestSession(sess Session) *RequestSession {
+	return newRequestSession(sess, SystemClock{})
+}
+
+// newRequestSession returns request wrapper of sess with TTLs of pending values of clock.
+func newRequestSession(sess Session, clock Clock) *RequestSession {
+	return &RequestSession{Session: sess, pending: make(map[string]interface{}), clock: clockOrSystem(clock)}
+}
+
+// setPending keeps values till Done(), nil value deletes the key.
+func (s *RequestSession) setPending(values map[string]interface{}) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	for key, value := range values {
+		s.pending[key] = value
+	}
+	s.modified = true
+}
+
+// lookup returns pending value of key, buffered is false if the value is read from the session.
+func (s *RequestSession) lookup(key string) (value interface{}, found, buffered bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	v, ok := s.pending[key]
+	if !ok {
+		return nil, false, s.cleared
+	}
+	if v == nil {
+		return nil, false, true
+	}
+	if sens, ok := v.(pendingSensitive); ok {
+		return sens.value, true, true
+	}
+	value, found = UnwrapValueAt(s.clock.Now(), v)
+	return value, found, true
+}
+
+// Modified returns true if the session has values not written yet.
+func (s *RequestSession) Modified() bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.modified
+}
+
+// flushPending writes pending values and flushes the session if it was modified.
+func (s *RequestSession) flushPending() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if !s.modified {
+		return nil
+	}
+	if s.cleared {
+		if err := s.Session.Clear(); err != nil {
+			return err
+		}
+		s.cleared = false
+	}
+	values := make(map[string]interface{}, len(s.pending))
+	for key, v := range s.pending {
+		switch v := v.(type) {
+		case nil:
+			if err := s.Session.Delete(key); err != nil {
+				return err
+			}
+		case ExpiringValue:
+			ttl := time.Until(time.Unix(0, v.Expires))
+			if ttl <= 0 {
+				if err := s.Session.Delete(key); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := s.Session.SetWithTTL(key, v.Value, ttl); err != nil {
+				return err
+			}
+		case pendingSensitive:
+			if err := s.Session.SetSensitive(key, v.value); err != nil {
+				return err
+			}
+		default:
+			values[key] = v
+		}
+	}
+	if len(values) > 0 {
+		if err := s.Session.SetMany(values); err != nil {
+			return err
+		}
+	}
+	if err := s.Session.Flush(); err != nil {
+		return err
+	}
+	s.pending = make(map[string]interface{})
+	s.modified = false
+	return nil
+}
+
+// flushKey writes pending values if key is pending, before an atomic operation on key.
+func (s *RequestSession) flushKey(key string) error {
+	if _, _, buffered := s.lookup(key); !buffered {
+		return nil
+	}
+	return s.flushPending()
+}
+
+// Done writes modified session with one Flush(), should be called when request handler returns.
+func (s *RequestSession) Done() error {
+	return s.flushPending()
+}
+
+// Set keeps value till Done().
+func (s *RequestSession) Set(key string, value interface{}) error {
+	s.setPending(map[string]interface{}{key: value})
+	return nil
+}
+
+// Put sets value, it is written by Done().
+func (s *RequestSession) Put(key string, value interface{}) error {
+	return s.Set(key, value)
+}
+
+// SetWithTTL sets expiring value, it is written by Done().
+func (s *RequestSession) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	s.setPending(map[string]interface{}{key: NewExpiringValueAt(s.clock.Now(), value, ttl)})
+	return nil
+}
+
+// SetSensitive sets value, it is encrypted and written by Done().
+func (s *RequestSession) SetSensitive(key string, value interface{}) error {
+	if sensitiveKeyRing.Load() == nil {
+		return ErrNoSensitiveKeys
+	}
+	s.setPending(map[string]interface{}{key: pendingSensitive{value: value}})
+	return nil
+}
+
+// SetMany sets values, they are written by Done() with one SetMany().
+func (s *RequestSession) SetMany(values map[string]interface{}) error {
+	pending := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		pending[key] = value
+	}
+	s.setPending(pending)
+	return nil
+}
+
+// Restore replaces values, they are written by Done().
+func (s *RequestSession) Restore(snapshot map[string]interface{}) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if err := s.Session.Restore(snapshot); err != nil {
+		return err
+	}
+	s.pending = make(map[string]interface{})
+	s.cleared = false
+	s.modified = true
+	return nil
+}
+
+// Flush is deferred till Done().
+func (s *RequestSession) Flush() error {
+	s.mx.Lock()
+	s.modified = true
+	s.mx.Unlock()
+	return nil
+}
+
+// Delete deletes value, it is deleted from the session by Done().
+func (s *RequestSession) Delete(key string) error {
+	s.setPending(map[string]interface{}{key: nil})
+	return nil
+}
+
+// Clear deletes all values, they are deleted from the session by Done().
+func (s *RequestSession) Clear() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.pending = make(map[string]interface{})
+	s.cleared = true
+	s.modified = true
+	return nil
+}
+
+func (s *RequestSession) Get(key string, val interface{}) error {
+	if v, found, buffered := s.lookup(key); buffered {
+		if !found {
+			return ErrKeyNotFound
+		}
+		return assignPtr(v, val)
+	}
+	return s.Session.Get(key, val)
+}
+
+func (s *RequestSession) GetStruct(key string, dest interface{}) error {
+	if v, found, buffered := s.lookup(key); buffered {
+		if !found {
+			return ErrKeyNotFound
+		}
+		return AssignValue(v, dest)
+	}
+	return s.Session.GetStruct(key, dest)
+}
+
+func (s *RequestSession) GetMany(dest map[string]interface{}) error {
+	return GetMany(s, dest)
+}
+
+func (s *RequestSession) GetBool(key string) bool {
+	if v, _, buffered := s.lookup(key); buffered {
+		return boolOf(v)
+	}
+	return s.Session.GetBool(key)
+}
+
+func (s *RequestSession) GetString(key string) string {
+	if v, _, buffered := s.lookup(key); buffered {
+		return stringOf(v)
+	}
+	return s.Session.GetString(key)
+}
+
+func (s *RequestSession) GetInt(key string) int64 {
+	if v, _, buffered := s.lookup(key); buffered {
+		return intOf(v)
+	}
+	return s.Session.GetInt(key)
+}
+
+func (s *RequestSession) GetFloat(key string) float64 {
+	if v, _, buffered := s.lookup(key); buffered {
+		return floatOf(v)
+	}
+	return s.Session.GetFloat(key)
+}
+
+func (s *RequestSession) GetDate(key string) time.Time {
+	if v, _, buffered := s.lookup(key); buffered {
+		return dateOf(v)
+	}
+	return s.Session.GetDate(key)
+}
+
+func (s *RequestSession) GetBytes(key string) []byte {
+	if v, _, buffered := s.lookup(key); buffered {
+		return bytesOf(v)
+	}
+	return s.Session.GetBytes(key)
+}
+
+func (s *RequestSession) GetStringSlice(key string) []string {
+	if v, _, buffered := s.lookup(key); buffered {
+		return stringSliceOf(v)
+	}
+	return s.Session.GetStringSlice(key)
+}
+
+// Keys returns keys of the session with pending changes applied.
+func (s *RequestSession) Keys() ([]string, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	var keys []string
+	if !s.cleared {
+		var err error
+		if keys, err = s.Session.Keys(); err != nil {
+			return nil, err
+		}
+	}
+	list := make([]string, 0, len(keys)+len(s.pending))
+	for _, key := range keys {
+		if _, ok := s.pending[key]; !ok {
+			list = append(list, key)
+		}
+	}
+	now := s.clock.Now()
+	for key, v := range s.pending {
+		if v != nil && !ValueExpiredAt(now, v) {
+			list = append(list, key)
+		}
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+func (s *RequestSession) Len() (int, error) {
+	keys, err := s.Keys()
+	return len(keys), err
+}
+
+// Snapshot returns values of the session with pending changes applied, see SnapshotValuesAt().
+func (s *RequestSession) Snapshot() (map[string]interface{}, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	values := make(map[string]interface{})
+	if !s.cleared {
+		var err error
+		if values, err = s.Session.Snapshot(); err != nil {
+			return nil, err
+		}
+	}
+	for key, v := range s.pending {
+		switch v := v.(type) {
+		case nil:
+			delete(values, key)
+		case pendingSensitive:
+			sealed, err := SealValue(v.value)
+			if err != nil {
+				return nil, err
+			}
+			values[key] = sealed
+		default:
+			values[key] = v
+		}
+	}
+	return SnapshotValuesAt(s.clock.Now(), values)
+}
+
+// Increment writes pending value of key first.
+func (s *RequestSession) Increment(key string, delta int64) (int64, error) {
+	if err := s.flushKey(key); err != nil {
+		return 0, err
+	}
+	return s.Session.Increment(key, delta)
+}
+
+// Decrement writes pending value of key first.
+func (s *RequestSession) Decrement(key string, delta int64) (int64, error) {
+	if err := s.flushKey(key); err != nil {
+		return 0, err
+	}
+	return s.Session.Decrement(key, delta)
+}
+
+// CompareAndSwap writes pending value of key first.
+func (s *RequestSession) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
+	if err := s.flushKey(key); err != nil {
+		return false, err
+	}
+	return s.Session.CompareAndSwap(key, oldValue, newValue)
+}
+
+// Lock writes pending values before locking.
+func (s *RequestSession) Lock() error {
+	if err := s.flushPending(); err != nil {
+		return err
+	}
+	return s.Session.Lock()
+}
+
+// GetOrSet writes pending values, computed value is written at once.
+func (s *RequestSession) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	if err := s.flushPending(); err != nil {
+		return err
+	}
+	return s.Session.GetOrSet(key, dest, compute)
+}
+
+func (s *RequestSession) Bucket(name string) Session {
+	return NewBucket(s, name)
+}
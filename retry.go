@@ -0,0 +1,452 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Backoff returns delay before retry attempt, attempt starts with 1 for the first retry.
+type Backoff func(attempt int) time.Duration
+
+// ExponentialBackoff returns backoff doubling base delay on every attempt up to max,
+// random jitter is applied: the delay is a random duration from 0 to the computed one.
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	var mx sync.Mutex
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		if d <= 0 {
+			return 0
+		}
+		mx.Lock()
+		defer mx.Unlock()
+		return time.Duration(rnd.Int63n(int64(d)) + 1)
+	}
+}
+
+// TransientClassifier is an optional interface for providers
+// recognizing their transient errors, e.g. SQLITE_BUSY, see RetryProvider.
+type TransientClassifier interface {
+	IsTransient(err error) bool
+}
+
+// IsTransient reports whether err is likely to go away on retry:
+// timeouts, connection resets and refusals, unexpected connection close and ErrChaos.
+// Cancelled contexts are not transient.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrChaos) {
+		return true
+	}
+	var net_err net.Error
+	if errors.As(err, &net_err) && net_err.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// RetryProvider is a provider decorator retrying operations of inner provider
+// and its sessions failed with transient errors, see IsTransient().
+// Errors are classified by inner provider if it implements TransientClassifier.
+// Not idempotent session operations, Increment(), Decrement(), CompareAndSwap() and GetOrSet(),
+// are not retried as they might have been applied before the error was returned.
+type RetryProvider struct {
+	Provider
+	maxAttempts int
+	backoff     Backoff
+	mx          sync.Mutex
+	logger      *slog.Logger
+}
+
+// WithRetry returns inner provider retrying operations up to maxAttempts times in total
+// with backoff delays between attempts, no delay if backoff is nil.
+// The result should be registered under its own name with Register(), then used with NewManager():
+//
+//	inner, _ := session.LookupProvider("redis")
+//	session.Register("redis_retry", session.WithRetry(inner, 3, session.ExponentialBackoff(50*time.Millisecond, time.Second)))
+//	SessManager, err := session.NewManager("redis_retry", ...)
+func WithRetry(inner Provider, maxAttempts int, backoff Backoff) *RetryProvider {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &RetryProvider{Provider: inner, maxAttempts: maxAttempts, backoff: backoff}
+}
+
+// isTransient classifies err with inner provider or with IsTransient().
+func (rpder *RetryProvider) isTransient(err error) bool {
+	if classifier, ok := rpder.Provider.(TransientClassifier); ok {
+		return classifier.IsTransient(err)
+	}
+	return IsTransient(err)
+}
+
+// retry calls fn till it succeeds, fails with not transient error or attempts are exhausted.
+func (rpder *RetryProvider) retry(op string, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || !rpder.isTransient(err) {
+			return err
+		}
+		if attempt >= rpder.maxAttempts {
+			break
+		}
+		rpder.mx.Lock()
+		log := rpder.logger
+		rpder.mx.Unlock()
+		if log != nil {
+			log.Debug("session: retrying operation", LOG_KEY_OPERATION, op, "attempt", attempt, LOG_KEY_ERROR, err)
+		}
+		if rpder.backoff != nil {
+			time.Sleep(rpder.backoff(attempt))
+		}
+	}
+	return fmt.Errorf("%w: %s failed %d times: %w", ErrRetriesExhausted, op, rpder.maxAttempts, err)
+}
+
+func (rpder *RetryProvider) SessionInit(sid string) (Session, error) {
+	var sess Session
+	if err := rpder.retry("SessionInit", func() (err error) {
+		sess, err = rpder.Provider.SessionInit(sid)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return &retrySession{Session: sess, pder: rpder}, nil
+}
+
+func (rpder *RetryProvider) SessionRead(sid string) (Session, error) {
+	var sess Session
+	if err := rpder.retry("SessionRead", func() (err error) {
+		sess, err = rpder.Provider.SessionRead(sid)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return &retrySession{Session: sess, pder: rpder}, nil
+}
+
+func (rpder *RetryProvider) SessionDestroy(sid string) error {
+	return rpder.retry("SessionDestroy", func() error {
+		return rpder.Provider.SessionDestroy(sid)
+	})
+}
+
+func (rpder *RetryProvider) Ping(ctx context.Context) error {
+	return rpder.retry("Ping", func() error {
+		return rpder.Provider.Ping(ctx)
+	})
+}
+
+// Drain implements DrainProvider with inner provider.
+func (rpder *RetryProvider) Drain() error {
+	if drain_pder, ok := rpder.Provider.(DrainProvider); ok {
+		return drain_pder.Drain()
+	}
+	return nil
+}
+
+// SetGCMaxDeletions implements GCLimiter, the limit is passed to inner provider.
+func (rpder *RetryProvider) SetGCMaxDeletions(maxDeletions int) {
+	setGCMaxDeletions(maxDeletions, rpder.Provider)
+}
+
+// SetExpirationMode implements ExpirationModeSetter, the mode is passed to inner provider.
+func (rpder *RetryProvider) SetExpirationMode(mode ExpirationMode) {
+	setExpirationMode(mode, rpder.Provider)
+}
+
+// SetStrictMode implements StrictModeSetter, the mode is passed to inner provider.
+// ErrNoStrictMode is returned if inner provider does not support strict mode.
+func (rpder *RetryProvider) SetStrictMode(strict bool) error {
+	return setStrictMode(strict, rpder.Provider)
+}
+
+// SetClock implements ClockSetter, the clock is passed to inner provider.
+func (rpder *RetryProvider) SetClock(clock Clock) {
+	setClock(clock, rpder.Provider)
+}
+
+// SessionMeta implements MetaProvider with inner provider.
+func (rpder *RetryProvider) SessionMeta(sid string) (meta SessionMeta, err error) {
+	err = rpder.retry("SessionMeta", func() (err error) {
+		meta, err = sessionMeta(rpder.Provider, sid)
+		return err
+	})
+	return meta, err
+}
+
+// SessionPeek implements PeekProvider with inner provider.
+func (rpder *RetryProvider) SessionPeek(sid string) (sess Session, err error) {
+	err = rpder.retry("SessionPeek", func() (err error) {
+		sess, err = sessionPeek(rpder.Provider, sid)
+		return err
+	})
+	return sess, err
+}
+
+// AcquireGCLeader implements GCLeaderLocker with inner provider.
+func (rpder *RetryProvider) AcquireGCLeader(name string, ttl time.Duration) (bool, error) {
+	locker, err := gcLeaderLocker(rpder.Provider)
+	if err != nil {
+		return false, err
+	}
+	return locker.AcquireGCLeader(name, ttl)
+}
+
+// ReleaseGCLeader implements GCLeaderLocker with inner provider.
+func (rpder *RetryProvider) ReleaseGCLeader(name string) error {
+	locker, err := gcLeaderLocker(rpder.Provider)
+	if err != nil {
+		return err
+	}
+	return locker.ReleaseGCLeader(name)
+}
+
+// SetExpiredHook passes hook to inner provider if it implements ExpiryNotifier.
+func (rpder *RetryProvider) SetExpiredHook(fn SessionHook) {
+	if notifier, ok := rpder.Provider.(ExpiryNotifier); ok {
+		notifier.SetExpiredHook(fn)
+	}
+}
+
+// SetChangedHook passes hook to inner provider if it implements ChangeNotifier.
+func (rpder *RetryProvider) SetChangedHook(fn SessionHook) {
+	if notifier, ok := rpder.Provider.(ChangeNotifier); ok {
+		notifier.SetChangedHook(fn)
+	}
+}
+
+// IsFatal classifies err with inner provider if it implements Reconnector.
+func (rpder *RetryProvider) IsFatal(err error) bool {
+	reconnector, ok := rpder.Provider.(Reconnector)
+	return ok && reconnector.IsFatal(err)
+}
+
+// Reconnect implements Reconnector with inner provider.
+func (rpder *RetryProvider) Reconnect(ctx context.Context) error {
+	if reconnector, ok := rpder.Provider.(Reconnector); ok {
+		return reconnector.Reconnect(ctx)
+	}
+	return nil
+}
+
+// SetKeyRing passes key ring to inner provider if it implements EncryptedProvider.
+func (rpder *RetryProvider) SetKeyRing(keyRing *KeyRing) {
+	if enc_pder, ok := rpder.Provider.(EncryptedProvider); ok {
+		enc_pder.SetKeyRing(keyRing)
+	}
+}
+
+// SetCompression passes compression to inner provider if it implements CompressedProvider.
+func (rpder *RetryProvider) SetCompression(compression *Compression) {
+	if comp_pder, ok := rpder.Provider.(CompressedProvider); ok {
+		comp_pder.SetCompression(compression)
+	}
+}
+
+// SetPayloadVersion passes payload version to inner provider if it implements VersionedProvider.
+func (rpder *RetryProvider) SetPayloadVersion(payloadVersion *PayloadVersion) {
+	if ver_pder, ok := rpder.Provider.(VersionedProvider); ok {
+		ver_pder.SetPayloadVersion(payloadVersion)
+	}
+}
+
+// SetLogger sets logger of retries logged at debug level
+// and passes it to inner provider if it implements LoggedProvider.
+func (rpder *RetryProvider) SetLogger(logger *slog.Logger) {
+	rpder.mx.Lock()
+	rpder.logger = logger
+	rpder.mx.Unlock()
+	if log_pder, ok := rpder.Provider.(LoggedProvider); ok {
+		log_pder.SetLogger(logger)
+	}
+}
+
+// EnsureSchema implements SchemaProvider with inner provider.
+func (rpder *RetryProvider) EnsureSchema(ctx context.Context) error {
+	return rpder.retry("EnsureSchema", func() error {
+		return ensureSchema(ctx, rpder.Provider)
+	})
+}
+
+// SessionStats implements StatsProvider with inner provider.
+func (rpder *RetryProvider) SessionStats(ctx context.Context, sampleSize int) (stats SessionStats, err error) {
+	err = rpder.retry("SessionStats", func() (err error) {
+		stats, err = ProviderStats(ctx, rpder.Provider, sampleSize)
+		return err
+	})
+	return stats, err
+}
+
+// SessionCount implements AdminProvider with inner provider.
+func (rpder *RetryProvider) SessionCount() (int, error) {
+	adm_pder, ok := rpder.Provider.(AdminProvider)
+	if !ok {
+		return 0, ErrNotAdminProvider
+	}
+	var cnt int
+	err := rpder.retry("SessionCount", func() (err error) {
+		cnt, err = adm_pder.SessionCount()
+		return err
+	})
+	return cnt, err
+}
+
+// SessionList implements AdminProvider with inner provider.
+func (rpder *RetryProvider) SessionList(offset, limit int) ([]SessionMeta, error) {
+	adm_pder, ok := rpder.Provider.(AdminProvider)
+	if !ok {
+		return nil, ErrNotAdminProvider
+	}
+	var list []SessionMeta
+	err := rpder.retry("SessionList", func() (err error) {
+		list, err = adm_pder.SessionList(offset, limit)
+		return err
+	})
+	return list, err
+}
+
+// SessionDestroyMany destroys sessions with inner provider.
+func (rpder *RetryProvider) SessionDestroyMany(sids []string) error {
+	if bulk_pder, ok := rpder.Provider.(BulkDestroyProvider); ok {
+		return rpder.retry("SessionDestroyMany", func() error {
+			return bulk_pder.SessionDestroyMany(sids)
+		})
+	}
+	var errs []error
+	for _, sid := range sids {
+		if err := rpder.SessionDestroy(sid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// retrySession retries idempotent session methods.
+type retrySession struct {
+	Session
+	pder *RetryProvider
+}
+
+func (s *retrySession) Set(key string, value interface{}) error {
+	return s.pder.retry("Set", func() error { return s.Session.Set(key, value) })
+}
+
+func (s *retrySession) Put(key string, value interface{}) error {
+	return s.pder.retry("Put", func() error { return s.Session.Put(key, value) })
+}
+
+func (s *retrySession) Get(key string, value interface{}) error {
+	return s.pder.retry("Get", func() error { return s.Session.Get(key, value) })
+}
+
+func (s *retrySession) GetStruct(key string, dest interface{}) error {
+	return s.pder.retry("GetStruct", func() error { return s.Session.GetStruct(key, dest) })
+}
+
+func (s *retrySession) Delete(key string) error {
+	return s.pder.retry("Delete", func() error { return s.Session.Delete(key) })
+}
+
+func (s *retrySession) Clear() error {
+	return s.pder.retry("Clear", s.Session.Clear)
+}
+
+func (s *retrySession) Keys() (keys []string, err error) {
+	err = s.pder.retry("Keys", func() (err error) {
+		keys, err = s.Session.Keys()
+		return err
+	})
+	return keys, err
+}
+
+func (s *retrySession) Len() (n int, err error) {
+	err = s.pder.retry("Len", func() (err error) {
+		n, err = s.Session.Len()
+		return err
+	})
+	return n, err
+}
+
+func (s *retrySession) Flush() error {
+	return s.pder.retry("Flush", s.Session.Flush)
+}
+
+func (s *retrySession) SetMany(values map[string]interface{}) error {
+	return s.pder.retry("SetMany", func() error { return s.Session.SetMany(values) })
+}
+
+func (s *retrySession) GetMany(dest map[string]interface{}) error {
+	return s.pder.retry("GetMany", func() error { return s.Session.GetMany(dest) })
+}
+
+func (s *retrySession) Snapshot() (snapshot map[string]interface{}, err error) {
+	err = s.pder.retry("Snapshot", func() (err error) {
+		snapshot, err = s.Session.Snapshot()
+		return err
+	})
+	return snapshot, err
+}
+
+func (s *retrySession) Restore(snapshot map[string]interface{}) error {
+	return s.pder.retry("Restore", func() error { return s.Session.Restore(snapshot) })
+}
+
+func (s *retrySession) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	return s.pder.retry("SetWithTTL", func() error { return s.Session.SetWithTTL(key, value, ttl) })
+}
+
+func (s *retrySession) SetSensitive(key string, value interface{}) error {
+	return s.pder.retry("SetSensitive", func() error { return s.Session.SetSensitive(key, value) })
+}
+
+func (s *retrySession) Lock() error {
+	return s.pder.retry("Lock", s.Session.Lock)
+}
+
+func (s *retrySession) Unlock() error {
+	return s.pder.retry("Unlock", s.Session.Unlock)
+}
+
+func (s *retrySession) SetExpiry(d time.Duration) error {
+	return s.pder.retry("SetExpiry", func() error { return s.Session.SetExpiry(d) })
+}
+
+func (s *retrySession) Touch() error {
+	return s.pder.retry("Touch", s.Session.Touch)
+}
+
+func (s *retrySession) Bucket(name string) Session {
+	return NewBucket(s, name)
+}
+
+// SessionUserIndex implements UserIndexProvider with inner provider.
+func (rpder *RetryProvider) SessionUserIndex() UserIndex {
+	return userIndexOf(rpder.Provider)
+}
+
+// ValidateSessionID checks session ID format with inner provider.
+func (rpder *RetryProvider) ValidateSessionID(sid string) error {
+	return ValidateSessionID(rpder.Provider, sid)
+}
@@ -0,0 +1,53 @@
+package session_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dronm/session"
+	"github.com/dronm/session/mock"
+)
+
+// TestRetryProvider checks retries of transient errors injected by chaos provider.
+func TestRetryProvider(t *testing.T) {
+	chaos := session.NewChaosProvider(mock.NewProvider(), session.ChaosConfig{})
+	retry := session.WithRetry(chaos, 3, session.ExponentialBackoff(time.Millisecond, 10*time.Millisecond))
+	SessManager, err := session.NewManagerWithProvider(retry, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	defer SessManager.SessionDestroy(currentSession.SessionID())
+
+	//transient error on every attempt
+	chaos.SetConfig(session.ChaosConfig{ErrorRate: 1, Operations: []string{"Put"}})
+	before := chaos.Stats().Calls
+	err = currentSession.Put("key", "value")
+	if !errors.Is(err, session.ErrRetriesExhausted) || !errors.Is(err, session.ErrChaos) {
+		t.Fatalf("Put() wanted ErrRetriesExhausted, got %v", err)
+	}
+	if calls := chaos.Stats().Calls - before; calls != 3 {
+		t.Fatalf("Put() wanted 3 attempts, got %d", calls)
+	}
+
+	//not transient error is not retried
+	errPermanent := errors.New("permanent")
+	chaos.SetConfig(session.ChaosConfig{ErrorRate: 1, Err: errPermanent, Operations: []string{"Put"}})
+	before = chaos.Stats().Calls
+	if err := currentSession.Put("key", "value"); err != errPermanent {
+		t.Fatalf("Put() wanted not wrapped error, got %v", err)
+	}
+	if calls := chaos.Stats().Calls - before; calls != 1 {
+		t.Fatalf("Put() wanted 1 attempt, got %d", calls)
+	}
+
+	chaos.SetConfig(session.ChaosConfig{})
+	if err := currentSession.Put("key", "value"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+}
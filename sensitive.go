@@ -0,0 +1,110 @@
+package session
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sync/atomic"
+)
+
+func init() {
+	gob.Register(SensitiveValue{})
+}
+
+// sensitiveKeyRing encrypts sensitive values, nil if keys are not set.
+var sensitiveKeyRing atomic.Pointer[KeyRing]
+
+// SetSensitiveKeys sets keys sensitive values are encrypted with, see Session.SetSensitive().
+// Keys are rotated as with NewKeyRing(): the first key encrypts, all keys decrypt.
+// Values are decrypted by providers, so keys are shared by all managers of the process
+// and must be the same for all application instances. No keys disable sensitive values.
+func SetSensitiveKeys(keys ...[]byte) error {
+	if len(keys) == 0 {
+		sensitiveKeyRing.Store(nil)
+		return nil
+	}
+	key_ring, err := NewKeyRing(keys...)
+	if err != nil {
+		return err
+	}
+	sensitiveKeyRing.Store(key_ring)
+	return nil
+}
+
+// SensitiveValue is a value set with Session.SetSensitive(). Providers store it in place of the value,
+// so it is encrypted at rest regardless of payload encryption. It is decrypted by LookupValue(),
+// kept encrypted by Snapshot() and migrations and left out of exports and debug dumps.
+type SensitiveValue struct {
+	Sealed []byte //encrypted gob encoding of the value
+}
+
+// SealValue returns value encrypted with keys of SetSensitiveKeys().
+// Custom value types must be registered with RegisterType().
+func SealValue(value interface{}) (SensitiveValue, error) {
+	key_ring := sensitiveKeyRing.Load()
+	if key_ring == nil {
+		return SensitiveValue{}, ErrNoSensitiveKeys
+	}
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(&value); err != nil {
+		return SensitiveValue{}, TypeError(err)
+	}
+	sealed, err := key_ring.Encrypt(b.Bytes())
+	if err != nil {
+		return SensitiveValue{}, err
+	}
+	return SensitiveValue{Sealed: sealed}, nil
+}
+
+// Open returns decrypted value.
+func (sv SensitiveValue) Open() (interface{}, error) {
+	key_ring := sensitiveKeyRing.Load()
+	if key_ring == nil {
+		return nil, ErrNoSensitiveKeys
+	}
+	data, err := key_ring.Decrypt(sv.Sealed)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		return nil, TypeError(err)
+	}
+	return value, nil
+}
+
+// SetSensitive sets value encrypted with SealValue() as Session.Set() does.
+// Sessions implement Session.SetSensitive() with this function.
+func SetSensitive(s Session, key string, value interface{}) error {
+	sealed, err := SealValue(value)
+	if err != nil {
+		return err
+	}
+	return s.Set(key, sealed)
+}
+
+// SensitiveSession is implemented by sessions not keeping values in memory, e.g. redis,
+// to report their sensitive values, values of other sessions are checked with Session.Snapshot().
+type SensitiveSession interface {
+	SensitiveValues() (map[string]SensitiveValue, error) //encrypted values by key
+}
+
+// sensitiveValues returns encrypted sensitive values of session by key.
+func sensitiveValues(s Session) (map[string]SensitiveValue, error) {
+	if sens, ok := s.(SensitiveSession); ok {
+		return sens.SensitiveValues()
+	}
+	snapshot, err := s.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]SensitiveValue)
+	for key, v := range snapshot {
+		if ev, ok := v.(ExpiringValue); ok {
+			v = ev.Value //expired values are left out of snapshot by the session clock
+		}
+		if sv, ok := v.(SensitiveValue); ok {
+			values[key] = sv
+		}
+	}
+	return values, nil
+}
@@ -10,8 +10,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,6 +21,50 @@ const (
 	TIME_MIN_LAYOUT = "15:04"
 )
 
+// Session locking parameters.
+const (
+	LOCK_TTL   = 30 * time.Second      //default time after which lock is released automatically, see SetLockTTL()
+	LOCK_WAIT  = 10 * time.Second      //max time to wait for a lock
+	LOCK_RETRY = 50 * time.Millisecond //lock acquire retry interval
+)
+
+// lockTTL is lock time to live set with SetLockTTL(), 0 for LOCK_TTL.
+var lockTTL atomic.Int64
+
+// SetLockTTL sets time after which session lock is released automatically if it is not unlocked,
+// e.g. when the process holding it dies. A lock held longer is released and another request
+// can lock the session, so the time must exceed the longest locked request. Lock time is checked
+// by providers, so it is shared by all managers of the process. Zero restores LOCK_TTL.
+func SetLockTTL(ttl time.Duration) {
+	lockTTL.Store(int64(ttl))
+}
+
+// LockTTL returns time after which session lock is released automatically, see SetLockTTL().
+func LockTTL() time.Duration {
+	if ttl := lockTTL.Load(); ttl > 0 {
+		return time.Duration(ttl)
+	}
+	return LOCK_TTL
+}
+
+// IsExpired reports if a session is expired at the moment.
+// Expiration time set with Session.SetExpiry() overrides max life and idle time,
+// zero expiresAt means it is not set. Times are in seconds, 0 means no limit.
+// Providers use this function to check sessions on read.
+func IsExpired(timeCreated, timeAccessed, expiresAt time.Time, maxLifeTime, maxIdleTime int64) bool {
+	return IsExpiredAt(time.Now(), timeCreated, timeAccessed, expiresAt, maxLifeTime, maxIdleTime)
+}
+
+// IsExpiredAt reports if a session is expired at now, providers implementing ClockSetter
+// use it with time of their clock, see IsExpired().
+func IsExpiredAt(now, timeCreated, timeAccessed, expiresAt time.Time, maxLifeTime, maxIdleTime int64) bool {
+	if !expiresAt.IsZero() {
+		return !expiresAt.After(now)
+	}
+	return (maxIdleTime > 0 && !timeAccessed.Add(time.Duration(maxIdleTime)*time.Second).After(now)) ||
+		(maxLifeTime > 0 && !timeCreated.Add(time.Duration(maxLifeTime)*time.Second).After(now))
+}
+
 var log_levels = []string{"ERROR", "WARN", "DEBUG"}
 
 type LogLevel int
@@ -36,35 +81,80 @@ const (
 
 // Session interface for session functionality.
 type Session interface {
-	Set(key string, value interface{}) error //set session value
-	Put(key string, value interface{}) error //set session value and flushes
-	Get(key string, value interface{}) error //get session value
-	GetBool(key string) bool                 //get bool session value, false if no key or assertion error
-	GetString(key string) string             //get string session value, empty string if no key or assertion error
-	GetInt(key string) int64                 //get int64 session value, 0 if no key or assertion error
-	GetFloat(key string) float64             //get float64 session value, 0.0 if no key or assertion error
-	Delete(key string) error                 //delete session value
-	SessionID() string                       //returns current sessionID
-	Flush() error                            //flushes data to persistent storage
+	Set(key string, value interface{}) error          //set session value
+	Put(key string, value interface{}) error          //set session value and flushes
+	Get(key string, value interface{}) error          //get session value
+	GetStruct(key string, dest interface{}) error     //get session value converting it to dest type, see AssignValue()
+	GetBool(key string) bool                          //get bool session value, false if no key or assertion error
+	GetString(key string) string                      //get string session value, empty string if no key or assertion error
+	GetInt(key string) int64                          //get int64 session value, 0 if no key or assertion error
+	GetFloat(key string) float64                      //get float64 session value, 0.0 if no key or assertion error
+	GetDate(key string) time.Time                     //get time.Time session value, zero time if no key or assertion error
+	GetBytes(key string) []byte                       //get []byte session value, string is converted, nil if no key or assertion error
+	GetStringSlice(key string) []string               //get []string session value, nil if no key or assertion error
+	Delete(key string) error                          //delete session value
+	Clear() error                                     //delete all session values keeping session ID
+	Increment(key string, delta int64) (int64, error) //atomically add delta to integer value, returns new value
+	Decrement(key string, delta int64) (int64, error) //atomically subtract delta from integer value, returns new value
+	Keys() ([]string, error)                          //returns sorted keys of session values
+	Len() (int, error)                                //returns number of session values
+	SessionID() string                                //returns current sessionID
+	Flush() error                                     //flushes data to persistent storage
 	TimeCreated() time.Time
 	TimeAccessed() time.Time
+	//get session value, if there is no key the value is computed, stored and flushed, see GetOrSet()
+	GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error
+	//atomically sets newValue if the current value equals oldValue, nil oldValue matches a missing value,
+	//nil newValue deletes the value. Returns false if the value does not match. See EqualValue().
+	CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error)
+	//returns a view of the session with keys prefixed by bucket name, see NewBucket()
+	Bucket(name string) Session
+	//sets several values as Set() does, providers write them at once, see SetMany()
+	SetMany(values map[string]interface{}) error
+	//assigns values to dest pointers by keys, keys without values are deleted from dest, see GetMany()
+	GetMany(dest map[string]interface{}) error
+	//returns a deep copy of session values as they are stored, e.g. ExpiringValue and SensitiveValue
+	//are not unwrapped, expired values are left out, see SnapshotValues()
+	Snapshot() (map[string]interface{}, error)
+	//replaces session values with snapshot values as Set() and Delete() do, flush to persist
+	Restore(snapshot map[string]interface{}) error
+	//sets value expiring in ttl regardless of session expiration, e.g. a one-time code,
+	//other writes of the key remove its TTL, see ExpiringValue
+	SetWithTTL(key string, value interface{}, ttl time.Duration) error
+	Lock() error                     //acquires exclusive session lock, in-memory values are reloaded
+	Unlock() error                   //releases session lock, Flush should be called before
+	SetExpiry(d time.Duration) error //session expires in d regardless of max life/idle time, 0 restores defaults
+	Touch() error                    //marks session accessed in persistent storage, resetting its idle time
+	//counts action in a fixed window of window duration, false if limit is exceeded, see AllowRate().
+	//Counters are kept apart from session values, Clear() and Restore() do not reset them
+	Allow(action string, limit int, window time.Duration) (bool, error)
+	//sets value encrypted at rest with keys of SetSensitiveKeys(), it is decrypted on reads
+	//and left out of exports and debug dumps, see SensitiveValue
+	SetSensitive(key string, value interface{}) error
 }
 
 // Provider interface for session provider.
 type Provider interface {
 	InitProvider(provParams []interface{}) error
-	CloseProvider()
+	CloseProvider() error
 	SessionInit(sid string) (Session, error)
 	SessionRead(sid string) (Session, error)
 	SessionDestroy(sid string) error
 	SessionClose(sid string) error
-	SessionGC(io.Writer, LogLevel)
+	SessionGC(io.Writer, LogLevel) GCReport
 	GetSessionIDLen() int
 	SetMaxLifeTime(int64)
 	GetMaxLifeTime() int64
 	SetMaxIdleTime(int64)
 	GetMaxIdleTime() int64
 	DestroyAllSessions(io.Writer, LogLevel)
+	Ping(ctx context.Context) error //checks connectivity to session storage
+}
+
+// EncryptedProvider is an optional interface for providers
+// supporting session payload encryption.
+type EncryptedProvider interface {
+	SetKeyRing(*KeyRing) //nil disables encryption
 }
 
 var provides = make(map[string]Provider)
@@ -82,31 +172,98 @@ func Register(name string, provide Provider) {
 	provides[name] = provide
 }
 
+// LookupProvider returns a registered provider by its name,
+// e.g. to wrap it with NewCachedProvider().
+func LookupProvider(name string) (Provider, bool) {
+	provide, ok := provides[name]
+	return provide, ok
+}
+
 // Manager structure for holding provider.
 type Manager struct {
 	lock             sync.Mutex
 	provider         Provider
-	SessionsKillTime time.Time //clears all sessions
-	gcCancel         context.CancelFunc
+	SessionsKillTime time.Time                 //clears all sessions
+	gcMx             sync.Mutex                //guards gcCancel
+	gcCancel         context.CancelFunc        //nil if GC is not running
+	gcWG             sync.WaitGroup            //running GC goroutines, see StopGC()
+	logger           *slog.Logger              //structured logger, nil if not set
+	hooks            sessionHooks              //event callbacks, see OnSessionCreated()
+	metrics          *Metrics                  //nil if not enabled, see EnableMetrics()
+	shareSessions    bool                      //see SetSessionSharing()
+	sharedMx         sync.Mutex                //guards shared
+	shared           map[string]*sharedSession //sessions in use by session ID
+	maxSessionSize   int                       //max session size in bytes, 0 if not limited
+	evictOldest      bool                      //evict oldest keys of a too large session
+	flushInterval    time.Duration             //see SetAutoFlush(), 0 if disabled
+	dirty            dirtySessions             //sessions modified since Flush(), auto flush only
+	gcInterval       time.Duration             //see SetGCInterval(), 0 if derived from timeouts
+	gcJitter         time.Duration             //see SetGCJitter()
+	killSchedule     killSchedule              //see SetSessionsKillTime(), nil if not set
+	gcLeader         string                    //GC leader lock name, see SetGCLeaderLock()
+	fpMode           FingerprintMode           //see SetFingerprint()
+	fpFunc           FingerprintFunc           //client fingerprint of a request
+	providerName     string                    //see NewManager()
+	breaker          *circuitBreaker           //see SetCircuitBreaker(), nil if disabled
+	breakerFailure   BreakerClassifier         //see SetBreakerClassifier(), nil for IsBackendFailure()
+	noAutoRegister   bool                      //see SetAutoRegisterTypes()
+	payloadVersion   byte                      //see SetPayloadVersion()
+	payloadMigration PayloadMigration          //see OnPayloadVersionMismatch()
+	trash            TrashStore                //see SetTrash(), nil if disabled
+	trashWindow      time.Duration             //restore window of trashed sessions
+	userIndex        UserIndex                 //see SetUserIndex(), created on the first BindUser()
+	maxUserSessions  int                       //see SetMaxUserSessions()
+	userPolicy       UserLimitPolicy           //see SetMaxUserSessions()
+	usersMx          sync.Mutex                //serializes BindUser() limit checks
+	flushCancel      context.CancelFunc
+	flushWG          sync.WaitGroup     //running auto flush goroutine, see stopAutoFlush()
+	reconnectCancel  context.CancelFunc //stops provider monitor, see SetReconnect()
+	sidValidator     SIDValidator       //see SetSIDValidator()
+	clock            Clock              //see SetClock()
 }
 
 // NewManager is a Manager create function.
 // providerName is a name of the data storage provider.
 // maxLifeTime is a maximum life of a session in seconds.
 // maxIdleTime is a maximum idle time of a session in seconds. Idling is the time during which a session is not accessed.
-// sessionsKillTime is a time in format 00:00 or 00:00:00 at which all sessions will be killed on dayly bases.
+// sessionsKillTime is a time in format 00:00 or 00:00:00 at which all sessions will be killed on dayly bases,
+// see SetSessionsKillTime() for other formats.
 // See details how session destruction is handled in StartGC()
-// provParams contains provider specific arguments.
+// provParams contains provider specific arguments, providers having typed config structs
+// accept a config as the only parameter, e.g. redis.Config.
 func NewManager(providerName string, maxLifeTime int64, maxIdleTime int64, sessionsKillTime string, provParams ...interface{}) (*Manager, error) {
 	provider, ok := provides[providerName]
 	if !ok {
 		return nil, fmt.Errorf("session: unknown provider %q (forgotten import?)", providerName)
 	}
+	return newManager(provider, providerName, maxLifeTime, maxIdleTime, sessionsKillTime, provParams)
+}
+
+// NewManagerWithProvider creates a Manager for a provider instance, e.g. a decorated provider,
+// the provider does not have to be registered. Other parameters are the same as of NewManager().
+func NewManagerWithProvider(provider Provider, maxLifeTime int64, maxIdleTime int64, sessionsKillTime string, provParams ...interface{}) (*Manager, error) {
+	if provider == nil {
+		return nil, errors.New("session: NewManagerWithProvider provider is nil")
+	}
+	provider_name := ""
+	for name, provide := range provides {
+		if provide == provider {
+			provider_name = name
+			break
+		}
+	}
+	return newManager(provider, provider_name, maxLifeTime, maxIdleTime, sessionsKillTime, provParams)
+}
 
+func newManager(provider Provider, providerName string, maxLifeTime int64, maxIdleTime int64, sessionsKillTime string, provParams []interface{}) (*Manager, error) {
 	provider.SetMaxLifeTime(maxLifeTime)
 	provider.SetMaxIdleTime(maxIdleTime)
 
-	manager := &Manager{provider: provider}
+	if notifier, ok := provider.(ExpiryNotifier); ok {
+		notifier.SetExpiredHook(nil) //hooks of a previous manager
+	}
+
+	manager := &Manager{provider: provider, providerName: providerName, clock: SystemClock{}}
 	if sessionsKillTime != "" {
 		if err := manager.SetSessionsKillTime(sessionsKillTime); err != nil {
 			return nil, err
@@ -115,19 +272,71 @@ func NewManager(providerName string, maxLifeTime int64, maxIdleTime int64, sessi
 	if err := manager.provider.InitProvider(provParams); err != nil {
 		return nil, err
 	}
+	if id_len := provider.GetSessionIDLen(); id_len > 0 && id_len < MIN_SESS_ID_LEN {
+		return nil, fmt.Errorf("session: provider session ID length %d is less than %d", id_len, MIN_SESS_ID_LEN)
+	}
 	return manager, nil
 }
 
-// SetSessionsKillTime sets time from the given string value
+// SetSessionsKillTime sets times when all sessions are destroyed by GC from the given string value:
+//   - a time in format 00:00 or 00:00:00, sessions are killed every day;
+//   - comma separated times, e.g. "03:00,15:00";
+//   - a cron expression with 5 fields: minute hour day-of-month month day-of-week, e.g. "0 3 * * SUN".
+//
+// Times are local. SessionsKillTime is set to the first time of a list, zero time for cron expressions.
 func (manager *Manager) SetSessionsKillTime(sessionsKillTime string) error {
-	var err error
-	manager.SessionsKillTime, err = parseTime(sessionsKillTime)
+	sched, err := parseKillSchedule(sessionsKillTime)
 	if err != nil {
 		return err
 	}
+	manager.killSchedule = sched
+	manager.SessionsKillTime = time.Time{}
+	if times, ok := sched.(dailySchedule); ok {
+		manager.SessionsKillTime = times[0]
+	}
 	return nil
 }
 
+// SetEncryptionKeys enables session payload encryption with the given keys.
+// The first key is used for encryption, all keys are used for decryption (key rotation).
+// Calling without keys disables encryption.
+// Payloads written without encryption are read as plaintext and are encrypted on the next write.
+// Provider must implement EncryptedProvider interface.
+func (manager *Manager) SetEncryptionKeys(keys ...[]byte) error {
+	enc_pder, ok := manager.provider.(EncryptedProvider)
+	if !ok {
+		return errors.New("session: provider does not support encryption")
+	}
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if len(keys) == 0 {
+		enc_pder.SetKeyRing(nil)
+		return nil
+	}
+	key_ring, err := NewKeyRing(keys...)
+	if err != nil {
+		return err
+	}
+	enc_pder.SetKeyRing(key_ring)
+	return nil
+}
+
+// SetLogger sets structured logger for the manager and its provider.
+// When set, the logger is used instead of io.Writer passed to StartGC/SessionGC/DestroyAllSessions,
+// records are filtered by the logger handler level then.
+// Setting nil logger restores io.Writer logging.
+func (manager *Manager) SetLogger(logger *slog.Logger) {
+	manager.logger = logger
+	if cb := manager.breaker; cb != nil {
+		cb.mx.Lock()
+		cb.logger = logger
+		cb.mx.Unlock()
+	}
+	if log_pder, ok := manager.provider.(LoggedProvider); ok {
+		log_pder.SetLogger(logger)
+	}
+}
+
 // SetMaxLifeTime is an alias for provider SetMaxLifeTime
 func (manager *Manager) SetMaxLifeTime(maxLifeTime int64) {
 	manager.provider.SetMaxLifeTime(maxLifeTime)
@@ -143,24 +352,113 @@ func (manager *Manager) GetSessionIDLen() int {
 	return manager.provider.GetSessionIDLen()
 }
 
-// SessionStart opens session with the given ID.
+// SessionStart opens session with the given ID, a new session is created for empty ID.
+// Malformed ID is rejected with ErrInvalidSessionID before it reaches the provider,
+// see ValidateSessionID() and SetSIDValidator().
+// ErrSessionExpired is returned for an expired session, OnSessionExpired hooks are called then.
+// If session sharing is enabled, see SetSessionSharing(), concurrent calls
+// for the same ID return the same session.
 func (manager *Manager) SessionStart(sid string) (Session, error) {
-	//manager.lock.Lock()
-	//defer manager.lock.Unlock()
+	if sid != "" {
+		if err := manager.validateSessionID(sid); err != nil {
+			return nil, err
+		}
+	}
+	if manager.shareSessions {
+		return manager.sharedSessionStart(sid)
+	}
+	return manager.sessionStart(sid)
+}
 
+// sessionStart opens session with the given ID, new session is created for empty ID.
+func (manager *Manager) sessionStart(sid string) (Session, error) {
+	var sess Session
+	var err error
+	cb := manager.breaker
+	if err := cb.allow(); err != nil {
+		if cb.degraded {
+			return cb.memorySession(sid, manager.genSessionID), nil
+		}
+		return nil, err
+	}
+	start := time.Now()
 	if sid == "" {
 		sid := manager.genSessionID()
-		return manager.provider.SessionInit(sid)
+		sess, err = manager.provider.SessionInit(sid)
+		manager.metrics.observe(METRIC_WRITES, METRIC_WRITE_DURATION, start, err)
+		cb.done(err)
+		if err != nil {
+			return nil, err
+		}
+		manager.sessionCreated(sid)
+	} else {
+		sess, err = manager.provider.SessionRead(sid)
+		cb.done(err)
+		if errors.Is(err, ErrSessionExpired) {
+			manager.metrics.observe(METRIC_READS, METRIC_READ_DURATION, start, nil)
+			manager.sessionExpired(sid)
+			return nil, err
+		}
+		manager.metrics.observe(METRIC_READS, METRIC_READ_DURATION, start, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cb != nil {
+		sess = &breakerSession{Session: sess, cb: cb}
+	}
+	if manager.maxSessionSize > 0 {
+		sess = &limitedSession{Session: sess, maxSize: manager.maxSessionSize, evict: manager.evictOldest}
+	}
+	if len(manager.hooks.valueSet) > 0 || manager.metrics != nil || !manager.noAutoRegister {
+		sess = &managedSession{Session: sess, manager: manager}
+	}
+	if manager.flushInterval > 0 {
+		sess = &autoFlushSession{Session: sess, dirty: &manager.dirty}
+	}
+	if manager.fpMode != FINGERPRINT_OFF {
+		sess = &fingerprintSession{Session: sess}
 	}
-	return manager.provider.SessionRead(sid)
+	return sess, nil
+}
+
+// SessionStartLocked opens session with the given ID and acquires its lock,
+// so that concurrent requests for the same session are serialized.
+// Session must be released with Unlock() before LockTTL() elapses.
+// The session is closed if the lock is not acquired.
+func (manager *Manager) SessionStartLocked(sid string) (Session, error) {
+	sess, err := manager.SessionStart(sid)
+	if err != nil {
+		return nil, err
+	}
+	if err := sess.Lock(); err != nil {
+		manager.SessionClose(sess.SessionID())
+		return nil, err
+	}
+	return sess, nil
 }
 
 // SessionClose closes session with the given ID.
+// Shared session is closed when it is released by all its users.
+// With auto flush the session is flushed if it is modified, see SetAutoFlush().
 func (manager *Manager) SessionClose(sid string) error {
-	if sid != "" {
-		return manager.provider.SessionClose(sid)
+	if sid == "" {
+		return nil
 	}
-	return nil
+	if manager.shareSessions && !manager.releaseShared(sid) {
+		return nil //still in use
+	}
+	var errs []error
+	for _, s := range manager.dirty.forget(sid) {
+		errs = append(errs, s.Session.Flush()) //closed session must not be flushed by auto flush
+	}
+	if manager.breaker.isDegraded(sid) {
+		return errors.Join(errs...)
+	}
+	errs = append(errs, manager.breaker.call(func() error {
+		return manager.provider.SessionClose(sid)
+	}))
+	return errors.Join(errs...)
 }
 
 // InitProvider initializes provider with its specific parameters.
@@ -169,136 +467,319 @@ func (manager *Manager) InitProvider(provParams []interface{}) error {
 	return manager.provider.InitProvider(provParams)
 }
 
-// InitProvider initializes provider with its specific parameters.
-func (manager *Manager) CloseProvider() {
-	manager.provider.CloseProvider()
+// CloseProvider closes provider connections, see Close() for graceful shutdown.
+func (manager *Manager) CloseProvider() error {
+	return manager.provider.CloseProvider()
+}
+
+// HealthCheck checks that session storage is reachable, e.g. for readiness probes:
+//
+//	http.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+//		if err := SessManager.HealthCheck(r.Context()); err != nil {
+//			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+//		}
+//	})
+func (manager *Manager) HealthCheck(ctx context.Context) error {
+	if err := manager.provider.Ping(ctx); err != nil {
+		return fmt.Errorf("session: health check failed: %w", err)
+	}
+	return nil
 }
 
 // SessionDestroy destroys session by its ID.
 func (manager *Manager) SessionDestroy(sid string) error {
 	if sid == "" {
 		return nil
-	} else {
+	}
+	if manager.shareSessions {
+		manager.forgetShared(sid)
+	}
+	manager.dirty.forget(sid)
+	if manager.breaker.forget(sid) {
+		return nil //degraded session
+	}
+	if err := manager.trashSessions(sid); err != nil {
+		return err
+	}
+	start := time.Now()
+	err := manager.breaker.call(func() error {
 		return manager.provider.SessionDestroy(sid)
+	})
+	manager.metrics.observe(METRIC_WRITES, METRIC_WRITE_DURATION, start, err)
+	if err != nil {
+		return err
 	}
+	manager.sessionDestroyed(sid)
+	return nil
 }
 
-func (manager *Manager) SessionGC(l io.Writer, logLev LogLevel) {
-	manager.provider.SessionGC(l, logLev)
+// SessionGC removes expired sessions and sessions trashed before the restore window, returns GC results.
+func (manager *Manager) SessionGC(l io.Writer, logLev LogLevel) GCReport {
+	start := time.Now()
+	report := manager.provider.SessionGC(l, logLev)
+	if err := manager.purgeTrash(l, logLev); err != nil {
+		report.Errors++
+	}
+	report.Duration = time.Since(start)
+	manager.metrics.observe(METRIC_GC_RUNS, METRIC_GC_DURATION, start, nil)
+	return report
 }
 
+// DestroyAllSessions destroys all sessions. In trash mode sessions are trashed first,
+// nothing is destroyed if that fails, so provider must implement AdminProvider then, see SetTrash().
 func (manager *Manager) DestroyAllSessions(l io.Writer, logLev LogLevel) {
+	if err := manager.trashAllSessions(); err != nil {
+		LoggerFor(manager.logger, l, logLev).Error("sessions not destroyed: trash failed",
+			LOG_KEY_OPERATION, "DestroyAllSessions", LOG_KEY_ERROR, err)
+		return
+	}
 	manager.provider.DestroyAllSessions(l, logLev)
 }
 
 // StartGC starts garbage collection (GC) server for managing sessions destruction.
 // Session can be destroyed:
-//   - if SessionsKillTime is set, then all sessions will be cleared at that time.
+//   - if SessionsKillTime is set, then all sessions will be cleared at that time, see SetSessionsKillTime().
 //   - if idle time is set, then sessions idling (session access time is controled) more then that time will be cleared.
 //   - if max life time is set, then sessions will live no more then that specified time, no matter idling or not.
 //
 // SessionsKillTime runs its own independant gorouting.
-// MaxLifeTime and MaxIdleTime are combined in one gorouting.
+// MaxLifeTime and MaxIdleTime are combined in one gorouting, it runs every min(MaxLifeTime, MaxIdleTime)
+// seconds or every interval set with SetGCInterval().
 // All thee parameters can be used together.
+// With SetGCLeaderLock() only one of application instances sharing the storage runs GC.
 // Goroutings are controled by a context an can be cancelled.
-// So it is possible to modify SessionsKillTime/MaxLifeTime/MaxIdleTime and to restart the GC server
-// Server does not generate any output. Instead all errors/comments are sent to io.Writer passed as argument to StartGC() function.
+// So it is possible to modify SessionsKillTime/MaxLifeTime/MaxIdleTime and to restart the GC server, see RestartGC().
+// StartGC() does nothing if GC is already running, a warning is logged.
+// Server does not generate any output. Instead all errors/comments are sent to the logger set with SetLogger()
+// or, if no logger is set, to io.Writer passed as argument to StartGC() function.
 func (manager *Manager) StartGC(l io.Writer, logLev LogLevel) {
+	log := LoggerFor(manager.logger, l, logLev).With(LOG_KEY_OPERATION, "StartGC")
+
+	manager.gcMx.Lock()
+	defer manager.gcMx.Unlock()
+	if manager.gcCancel != nil {
+		log.Warn("GC is already running, use RestartGC() to apply new parameters")
+		return
+	}
+	kill_sched := manager.killScheduler()
+	interval := manager.gcPeriod()
+	if kill_sched == nil && interval == 0 {
+		return //nothing to start, GC is not running
+	}
 	var ctx context.Context
 	ctx, manager.gcCancel = context.WithCancel(context.Background())
 
-	empty_t := time.Time{}
-	if manager.SessionsKillTime != empty_t {
+	if kill_sched != nil {
 		//destroy all sessions at certain time
+		manager.gcWG.Add(1)
 		go (func() {
+			defer manager.gcWG.Done()
 		gc_loop:
 			for {
 				//calculate new sleep time
-				now := time.Now().Truncate(time.Second)
-				now_sec := now.Hour()*60*60 + now.Minute()*60 + now.Second()
-				kill_sec := manager.SessionsKillTime.Hour()*60*60 + manager.SessionsKillTime.Minute()*60 + manager.SessionsKillTime.Second()
-				sleep_sec := kill_sec - now_sec
-				if sleep_sec < 0 {
-					sleep_sec = 24*60*60 + sleep_sec
+				now := manager.clock.Now().Truncate(time.Second)
+				kill_t := kill_sched.next(now)
+				if kill_t.IsZero() {
+					log.Warn("no session killer time found")
+					break gc_loop
 				}
+				sleep_sec := int64(kill_t.Sub(now) / time.Second)
 
-				if l != nil && logLev >= LOG_LEVEL_WARN {
-					WriteToLog(l, fmt.Sprintf("waiting session killer in %d seconds", sleep_sec), LOG_LEVEL_WARN)
-				}
+				log.Warn(fmt.Sprintf("waiting session killer in %d seconds", sleep_sec))
 
 				select {
 				case <-ctx.Done(): //context cancelled
 					break gc_loop
 
-				case <-time.After(time.Duration(sleep_sec) * time.Second): //timeout
-					if l != nil && logLev >= LOG_LEVEL_DEBUG {
-						WriteToLog(l, "calling manager.DestroyAllSessions()", LOG_LEVEL_DEBUG)
+				case <-manager.clock.After(kill_t.Sub(now)): //timeout
+					if manager.isGCLeader(log, GC_LEADER_KILL_TTL) {
+						log.Debug("calling manager.DestroyAllSessions()")
+						start := time.Now()
+						manager.provider.DestroyAllSessions(l, logLev) //kill time sessions are not trashed
+						log.Debug("manager.DestroyAllSessions() done", LOG_KEY_DURATION, time.Since(start))
+					}
+					//pause till the kill second is over
+					select {
+					case <-ctx.Done():
+						break gc_loop
+					case <-time.After(time.Duration(1) * time.Second):
 					}
-					manager.DestroyAllSessions(l, logLev)
-					time.Sleep(time.Duration(1) * time.Second)
 				}
 			}
 		})()
 	}
 
-	var sleep_sec int64 //seconds to sleep before the next GC
-	life_time := manager.provider.GetMaxLifeTime()
-	idle_time := manager.provider.GetMaxIdleTime()
-	if life_time == 0 && idle_time == 0 {
+	if interval == 0 {
 		return //do not start
 	}
-	if idle_time > 0 && life_time == 0 {
-		sleep_sec = idle_time
-
-	} else if life_time > 0 && idle_time == 0 {
-		sleep_sec = life_time
 
-	} else if idle_time < life_time {
-		sleep_sec = idle_time
-
-	} else {
-		sleep_sec = life_time
-	}
-
-	if l != nil && logLev >= LOG_LEVEL_WARN {
-		WriteToLog(l, fmt.Sprintf("running garbage collector every %d seconds", sleep_sec), LOG_LEVEL_DEBUG)
-	}
+	log.Debug(fmt.Sprintf("running garbage collector every %v", interval))
 
+	manager.gcWG.Add(1)
 	go (func() {
+		defer manager.gcWG.Done()
 	gc_loop:
 		for {
 			select {
 			case <-ctx.Done(): //context cancelled
 				break gc_loop
 
-			case <-time.After(time.Duration(sleep_sec) * time.Second): //timeout
-				if l != nil && logLev >= LOG_LEVEL_DEBUG {
-					WriteToLog(l, "calling manager.SessionGC()", LOG_LEVEL_DEBUG)
+			case <-manager.clock.After(manager.gcDelay(interval)): //timeout
+				//leadership is kept till the next run
+				if !manager.isGCLeader(log, 2*interval+manager.gcJitter) {
+					continue
 				}
-
-				manager.SessionGC(l, logLev)
+				log.Debug("calling manager.SessionGC()")
+				report := manager.SessionGC(l, logLev)
+				log.Debug("manager.SessionGC() done", LOG_KEY_COUNT, report.Deleted(), LOG_KEY_DURATION, report.Duration)
+				manager.gcDone(report)
 			}
 		}
 	})()
 }
 
-// StopGC stops garbage collection server
+// SetGCInterval sets the period of SessionGC() calls made by StartGC().
+// By default GC runs every min(MaxLifeTime, MaxIdleTime) seconds which can be hours,
+// so expired sessions live long after expiration, and GC is not started at all if both are 0,
+// e.g. when only per-session expiration is used. 0 restores the default.
+// Restart GC with RestartGC() to apply a new interval.
+func (manager *Manager) SetGCInterval(interval time.Duration) {
+	manager.gcInterval = interval
+}
+
+// gcPeriod returns the period of SessionGC() calls, 0 if GC is not needed.
+func (manager *Manager) gcPeriod() time.Duration {
+	if manager.gcInterval > 0 {
+		return manager.gcInterval
+	}
+	life_time := manager.provider.GetMaxLifeTime()
+	idle_time := manager.provider.GetMaxIdleTime()
+	switch {
+	case life_time == 0 && idle_time == 0:
+		return 0
+	case life_time == 0 || (idle_time > 0 && idle_time < life_time):
+		return time.Duration(idle_time) * time.Second
+	default:
+		return time.Duration(life_time) * time.Second
+	}
+}
+
+// StopGC stops garbage collection server and waits till its goroutines exit, a GC run in progress
+// is finished first, so provider can be closed after StopGC() returns. GC leadership is released.
+// Must not be called from OnGC() callbacks.
 func (manager *Manager) StopGC() {
+	manager.stopGC(context.Background())
+}
+
+// RestartGC stops GC as StopGC() does and starts it again, e.g. to apply a new GC interval
+// or kill time.
+func (manager *Manager) RestartGC(l io.Writer, logLev LogLevel) {
+	manager.StopGC()
+	manager.StartGC(l, logLev)
+}
+
+// stopGC cancels GC goroutines and waits till they exit or ctx is done,
+// returns ctx error if goroutines are still running.
+func (manager *Manager) stopGC(ctx context.Context) error {
+	manager.gcMx.Lock()
+	defer manager.gcMx.Unlock()
 	if manager.gcCancel != nil {
 		manager.gcCancel()
+		manager.gcCancel = nil
+	}
+	done := make(chan struct{})
+	go func() {
+		manager.gcWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err() //leadership expires
 	}
+	manager.releaseGCLeader() //expires if not released
+	return nil
 }
 
-// genSessionID generates unique ID for a session.
-func (manager *Manager) genSessionID() string {
-	source := rand.NewSource(time.Now().UnixNano())
-	r := rand.New(source)
-	b := make([]byte, 16)
-	_, err := r.Read(b)
-	if err != nil {
-		return ""
+// SchemaProvider is implemented by providers able to create their database objects.
+type SchemaProvider interface {
+	EnsureSchema(ctx context.Context) error //creates missing tables and indexes
+}
+
+// EnsureSchema creates database objects used by provider if they do not exist,
+// so external SQL scripts are not necessary. It is safe to call on every start.
+// Provider must implement SchemaProvider interface.
+func (manager *Manager) EnsureSchema(ctx context.Context) error {
+	schema_pder, ok := manager.provider.(SchemaProvider)
+	if !ok {
+		return errors.New("session: provider does not support schema creation")
+	}
+	return schema_pder.EnsureSchema(ctx)
+}
+
+// ensureSchema creates schema of providers implementing SchemaProvider, others are skipped.
+func ensureSchema(ctx context.Context, providers ...Provider) error {
+	var errs []error
+	for _, p := range providers {
+		if schema_pder, ok := p.(SchemaProvider); ok {
+			errs = append(errs, schema_pder.EnsureSchema(ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DrainProvider is implemented by providers keeping values not yet written
+// to storage, e.g. in write-behind queues or caches.
+type DrainProvider interface {
+	Drain() error //writes pending values to storage
+}
+
+// Close shuts manager down gracefully: stops GC, auto flush and provider monitor, flushes sessions in use
+// (shared sessions, see SetSessionSharing(), and modified sessions, see SetAutoFlush()),
+// drains provider if it implements
+// DrainProvider and closes provider connections.
+// A GC run in progress is finished before provider is closed, see StopGC().
+// Provider is closed when ctx is done even if GC or flushing, including auto flush in progress, is not finished,
+// ctx error is returned then.
+func (manager *Manager) Close(ctx context.Context) error {
+	gc_err := manager.stopGC(ctx)
+	manager.stopReconnect()
+	done := make(chan error, 1)
+	go func() {
+		manager.stopAutoFlush() //auto flush in progress is finished first
+		done <- manager.flushAll()
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if gc_err != nil {
+		err = gc_err //ctx is done
+	}
+	return errors.Join(err, manager.CloseProvider())
+}
+
+// flushAll flushes shared and modified sessions and drains provider.
+func (manager *Manager) flushAll() error {
+	errs := []error{manager.flushDirty()}
+	manager.sharedMx.Lock()
+	shared := make([]*sharedSession, 0, len(manager.shared))
+	for _, sh := range manager.shared {
+		shared = append(shared, sh)
+	}
+	manager.sharedMx.Unlock()
+	for _, sh := range shared {
+		<-sh.ready
+		if sh.err == nil {
+			errs = append(errs, sh.sess.Flush())
+		}
+	}
+	if drain_pder, ok := manager.provider.(DrainProvider); ok {
+		errs = append(errs, drain_pder.Drain())
 	}
-	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	return errors.Join(errs...)
 }
 
 func WriteToLog(w io.Writer, s string, logLevel LogLevel) {
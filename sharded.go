@@ -0,0 +1,459 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SHARD_VNODES is a number of points of every shard on the hash ring.
+const SHARD_VNODES = 160
+
+// ShardedProvider distributes sessions across providers (shards)
+// by consistent hashing of session ID, so adding a shard moves
+// only a part of sessions to it.
+// GC and destruction of all sessions run on all shards concurrently.
+type ShardedProvider struct {
+	shards []Provider
+	ring   []shardPoint //sorted by hash
+}
+
+// shardPoint is a point of a shard on the hash ring.
+type shardPoint struct {
+	hash  uint32
+	shard int
+}
+
+// NewShardedProvider returns provider sharding sessions across shards.
+// The result should be registered under its own name with Register(), then used with NewManager(),
+// provider parameters are []interface{} parameters of every shard in the same order:
+//
+//	redis_pder, _ := session.LookupProvider("redis")
+//	pg_pder, _ := session.LookupProvider("pg")
+//	session.Register("redis_pg", session.NewShardedProvider(redis_pder, pg_pder))
+//	SessManager, err := session.NewManager("redis_pg", 0, 3600, "",
+//		[]interface{}{REDIS_ADDR, REDIS_NAMESPACE}, []interface{}{PG_CONN})
+//
+// Providers are package level instances, so every provider package can be used once.
+func NewShardedProvider(shards ...Provider) *ShardedProvider {
+	spder := &ShardedProvider{shards: shards, ring: make([]shardPoint, 0, len(shards)*SHARD_VNODES)}
+	for i := range shards {
+		for v := 0; v < SHARD_VNODES; v++ {
+			spder.ring = append(spder.ring, shardPoint{hash: hashString(strconv.Itoa(i) + "#" + strconv.Itoa(v)), shard: i})
+		}
+	}
+	sort.Slice(spder.ring, func(i, j int) bool {
+		return spder.ring[i].hash < spder.ring[j].hash
+	})
+	return spder
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// ShardIndex returns index of the shard keeping session.
+func (spder *ShardedProvider) ShardIndex(sid string) int {
+	h := hashString(sid)
+	i := sort.Search(len(spder.ring), func(i int) bool {
+		return spder.ring[i].hash >= h
+	})
+	if i == len(spder.ring) {
+		i = 0
+	}
+	return spder.ring[i].shard
+}
+
+func (spder *ShardedProvider) shard(sid string) Provider {
+	return spder.shards[spder.ShardIndex(sid)]
+}
+
+// fanOut calls fn for all shards concurrently and waits for them.
+func (spder *ShardedProvider) fanOut(fn func(Provider)) {
+	var wg sync.WaitGroup
+	for _, p := range spder.shards {
+		wg.Add(1)
+		go func(p Provider) {
+			defer wg.Done()
+			fn(p)
+		}(p)
+	}
+	wg.Wait()
+}
+
+// InitProvider initializes all shards.
+func (spder *ShardedProvider) InitProvider(provParams []interface{}) error {
+	params, err := splitProviderParams(provParams, len(spder.shards))
+	if err != nil {
+		return err
+	}
+	for i, p := range spder.shards {
+		if err := p.InitProvider(params[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CloseProvider closes all shards.
+func (spder *ShardedProvider) CloseProvider() error {
+	var errs []error
+	for _, p := range spder.shards {
+		errs = append(errs, p.CloseProvider())
+	}
+	return errors.Join(errs...)
+}
+
+// Ping checks all shards concurrently, every shard must be reachable.
+func (spder *ShardedProvider) Ping(ctx context.Context) error {
+	var mx sync.Mutex
+	var errs []error
+	spder.fanOut(func(p Provider) {
+		if err := p.Ping(ctx); err != nil {
+			mx.Lock()
+			errs = append(errs, err)
+			mx.Unlock()
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// SessionInit creates session with its shard.
+func (spder *ShardedProvider) SessionInit(sid string) (Session, error) {
+	return spder.shard(sid).SessionInit(sid)
+}
+
+// SessionRead reads session from its shard.
+func (spder *ShardedProvider) SessionRead(sid string) (Session, error) {
+	return spder.shard(sid).SessionRead(sid)
+}
+
+// SessionDestroy destroys session with its shard.
+func (spder *ShardedProvider) SessionDestroy(sid string) error {
+	return spder.shard(sid).SessionDestroy(sid)
+}
+
+// SessionClose closes session with its shard.
+func (spder *ShardedProvider) SessionClose(sid string) error {
+	return spder.shard(sid).SessionClose(sid)
+}
+
+// SessionGC runs GC of all shards concurrently.
+func (spder *ShardedProvider) SessionGC(l io.Writer, logLev LogLevel) GCReport {
+	var mx sync.Mutex
+	var report GCReport
+	spder.fanOut(func(p Provider) {
+		shard_report := p.SessionGC(l, logLev)
+		mx.Lock()
+		report.Add(shard_report)
+		mx.Unlock()
+	})
+	return report
+}
+
+// SetGCMaxDeletions implements GCLimiter, the limit is set for every shard.
+func (spder *ShardedProvider) SetGCMaxDeletions(maxDeletions int) {
+	setGCMaxDeletions(maxDeletions, spder.shards...)
+}
+
+// SetExpirationMode implements ExpirationModeSetter, the mode is set for every shard.
+func (spder *ShardedProvider) SetExpirationMode(mode ExpirationMode) {
+	setExpirationMode(mode, spder.shards...)
+}
+
+// SetStrictMode implements StrictModeSetter, the mode is set for every shard.
+// ErrNoStrictMode is returned if a shard does not support strict mode.
+func (spder *ShardedProvider) SetStrictMode(strict bool) error {
+	return setStrictMode(strict, spder.shards...)
+}
+
+// SetClock implements ClockSetter, the clock is set for every shard.
+func (spder *ShardedProvider) SetClock(clock Clock) {
+	setClock(clock, spder.shards...)
+}
+
+// SessionMeta implements MetaProvider with the shard of the session.
+func (spder *ShardedProvider) SessionMeta(sid string) (SessionMeta, error) {
+	return sessionMeta(spder.shard(sid), sid)
+}
+
+// SessionPeek implements PeekProvider with the shard of the session.
+func (spder *ShardedProvider) SessionPeek(sid string) (Session, error) {
+	return sessionPeek(spder.shard(sid), sid)
+}
+
+// AcquireGCLeader implements GCLeaderLocker with the first shard.
+func (spder *ShardedProvider) AcquireGCLeader(name string, ttl time.Duration) (bool, error) {
+	locker, err := gcLeaderLocker(spder.shards[0])
+	if err != nil {
+		return false, err
+	}
+	return locker.AcquireGCLeader(name, ttl)
+}
+
+// ReleaseGCLeader implements GCLeaderLocker with the first shard.
+func (spder *ShardedProvider) ReleaseGCLeader(name string) error {
+	locker, err := gcLeaderLocker(spder.shards[0])
+	if err != nil {
+		return err
+	}
+	return locker.ReleaseGCLeader(name)
+}
+
+// DestroyAllSessions destroys all sessions of all shards concurrently.
+func (spder *ShardedProvider) DestroyAllSessions(l io.Writer, logLev LogLevel) {
+	spder.fanOut(func(p Provider) {
+		p.DestroyAllSessions(l, logLev)
+	})
+}
+
+// GetSessionIDLen returns session ID length of the first shard.
+func (spder *ShardedProvider) GetSessionIDLen() int {
+	return spder.shards[0].GetSessionIDLen()
+}
+
+// SetMaxLifeTime sets max life time for all shards.
+func (spder *ShardedProvider) SetMaxLifeTime(maxLifeTime int64) {
+	for _, p := range spder.shards {
+		p.SetMaxLifeTime(maxLifeTime)
+	}
+}
+
+// GetMaxLifeTime returns max life time.
+func (spder *ShardedProvider) GetMaxLifeTime() int64 {
+	return spder.shards[0].GetMaxLifeTime()
+}
+
+// SetMaxIdleTime sets max idle time for all shards.
+func (spder *ShardedProvider) SetMaxIdleTime(maxIdleTime int64) {
+	for _, p := range spder.shards {
+		p.SetMaxIdleTime(maxIdleTime)
+	}
+}
+
+// GetMaxIdleTime returns max idle time.
+func (spder *ShardedProvider) GetMaxIdleTime() int64 {
+	return spder.shards[0].GetMaxIdleTime()
+}
+
+// SetKeyRing passes key ring to shards implementing EncryptedProvider.
+func (spder *ShardedProvider) SetKeyRing(keyRing *KeyRing) {
+	for _, p := range spder.shards {
+		if enc_pder, ok := p.(EncryptedProvider); ok {
+			enc_pder.SetKeyRing(keyRing)
+		}
+	}
+}
+
+// SetCompression passes compression to shards implementing CompressedProvider.
+func (spder *ShardedProvider) SetCompression(compression *Compression) {
+	for _, p := range spder.shards {
+		if comp_pder, ok := p.(CompressedProvider); ok {
+			comp_pder.SetCompression(compression)
+		}
+	}
+}
+
+// SetPayloadVersion passes payload version to shards implementing VersionedProvider.
+func (spder *ShardedProvider) SetPayloadVersion(payloadVersion *PayloadVersion) {
+	for _, p := range spder.shards {
+		if ver_pder, ok := p.(VersionedProvider); ok {
+			ver_pder.SetPayloadVersion(payloadVersion)
+		}
+	}
+}
+
+// SetLogger passes logger to shards implementing LoggedProvider.
+func (spder *ShardedProvider) SetLogger(logger *slog.Logger) {
+	for _, p := range spder.shards {
+		if log_pder, ok := p.(LoggedProvider); ok {
+			log_pder.SetLogger(logger)
+		}
+	}
+}
+
+// EnsureSchema creates schema of shards implementing SchemaProvider.
+func (spder *ShardedProvider) EnsureSchema(ctx context.Context) error {
+	return ensureSchema(ctx, spder.shards...)
+}
+
+// SetExpiredHook passes hook to shards implementing ExpiryNotifier.
+func (spder *ShardedProvider) SetExpiredHook(fn SessionHook) {
+	for _, p := range spder.shards {
+		if notifier, ok := p.(ExpiryNotifier); ok {
+			notifier.SetExpiredHook(fn)
+		}
+	}
+}
+
+// SetChangedHook passes hook to shards implementing ChangeNotifier.
+func (spder *ShardedProvider) SetChangedHook(fn SessionHook) {
+	for _, p := range spder.shards {
+		if notifier, ok := p.(ChangeNotifier); ok {
+			notifier.SetChangedHook(fn)
+		}
+	}
+}
+
+// IsFatal returns true if err is fatal for any shard implementing Reconnector.
+func (spder *ShardedProvider) IsFatal(err error) bool {
+	for _, p := range spder.shards {
+		if reconnector, ok := p.(Reconnector); ok && reconnector.IsFatal(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reconnect opens connections of shards implementing Reconnector again.
+func (spder *ShardedProvider) Reconnect(ctx context.Context) error {
+	var errs []error
+	for _, p := range spder.shards {
+		if reconnector, ok := p.(Reconnector); ok {
+			errs = append(errs, reconnector.Reconnect(ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SessionStats implements StatsProvider: counts, sizes and storage of shards are summed,
+// distributions are of the shard with the largest sample, stats of every shard are in Providers.
+func (spder *ShardedProvider) SessionStats(ctx context.Context, sampleSize int) (SessionStats, error) {
+	list := make([]SessionStats, len(spder.shards))
+	for i, p := range spder.shards {
+		stats, err := ProviderStats(ctx, p, sampleSize)
+		if err != nil {
+			return SessionStats{}, fmt.Errorf("shard %d: %w", i, err)
+		}
+		list[i] = stats
+	}
+	return mergeStats(list), nil
+}
+
+// SessionCount returns number of sessions of all shards.
+// All shards must implement AdminProvider.
+func (spder *ShardedProvider) SessionCount() (int, error) {
+	cnt := 0
+	for _, p := range spder.shards {
+		adm_pder, ok := p.(AdminProvider)
+		if !ok {
+			return 0, ErrNotAdminProvider
+		}
+		shard_cnt, err := adm_pder.SessionCount()
+		if err != nil {
+			return 0, err
+		}
+		cnt += shard_cnt
+	}
+	return cnt, nil
+}
+
+// SessionList merges sessions of all shards ordered by ID.
+// All shards must implement AdminProvider.
+func (spder *ShardedProvider) SessionList(offset, limit int) ([]SessionMeta, error) {
+	var list []SessionMeta
+	for _, p := range spder.shards {
+		adm_pder, ok := p.(AdminProvider)
+		if !ok {
+			return nil, ErrNotAdminProvider
+		}
+		//every shard may hold the whole page
+		shard_limit := 0
+		if limit > 0 {
+			shard_limit = offset + limit
+		}
+		shard_list, err := adm_pder.SessionList(0, shard_limit)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, shard_list...)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	if offset >= len(list) {
+		return []SessionMeta{}, nil
+	}
+	list = list[offset:]
+	if limit > 0 && limit < len(list) {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+// SessionDestroyMany destroys sessions with their shards.
+func (spder *ShardedProvider) SessionDestroyMany(sids []string) error {
+	by_shard := make(map[int][]string)
+	for _, sid := range sids {
+		i := spder.ShardIndex(sid)
+		by_shard[i] = append(by_shard[i], sid)
+	}
+	var errs []error
+	for i, shard_sids := range by_shard {
+		if bulk_pder, ok := spder.shards[i].(BulkDestroyProvider); ok {
+			if err := bulk_pder.SessionDestroyMany(shard_sids); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		for _, sid := range shard_sids {
+			if err := spder.shards[i].SessionDestroy(sid); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SessionUserIndex returns user index binding sessions with indexes of their shards,
+// sessions of a user are merged from all shards. Nil if a shard does not keep user index.
+func (spder *ShardedProvider) SessionUserIndex() UserIndex {
+	ix := &shardedUserIndex{spder: spder, shards: make([]UserIndex, len(spder.shards))}
+	for i, p := range spder.shards {
+		if ix.shards[i] = userIndexOf(p); ix.shards[i] == nil {
+			return nil
+		}
+	}
+	return ix
+}
+
+// shardedUserIndex keeps bindings of sessions in user indexes of their shards.
+type shardedUserIndex struct {
+	spder  *ShardedProvider
+	shards []UserIndex
+}
+
+func (ix *shardedUserIndex) Add(userID, sid string, boundAt time.Time) error {
+	return ix.shards[ix.spder.ShardIndex(sid)].Add(userID, sid, boundAt)
+}
+
+func (ix *shardedUserIndex) Remove(sid string) error {
+	return ix.shards[ix.spder.ShardIndex(sid)].Remove(sid)
+}
+
+func (ix *shardedUserIndex) Sessions(userID string) ([]UserSession, error) {
+	var list []UserSession
+	for _, shard_ix := range ix.shards {
+		shard_list, err := shard_ix.Sessions(userID)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, shard_list...)
+	}
+	SortUserSessions(list)
+	return list, nil
+}
+
+// ValidateSessionID checks session ID format with the first shard.
+func (spder *ShardedProvider) ValidateSessionID(sid string) error {
+	return ValidateSessionID(spder.shards[0], sid)
+}
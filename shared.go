@@ -0,0 +1,82 @@
+package session
+
+// sharedSession is a session shared by concurrent SessionStart() calls.
+type sharedSession struct {
+	sess  Session
+	err   error
+	refs  int           //number of users, decremented by SessionClose()
+	ready chan struct{} //closed when session is loaded
+}
+
+// SetSessionSharing enables or disables session sharing.
+// When enabled, concurrent SessionStart() calls for the same ID are served
+// with one load from provider and return the same session, so values set
+// by concurrent requests are merged in one store instead of clobbering each other.
+// Shared session is kept until every SessionStart() is paired with SessionClose(),
+// or until SessionDestroy().
+// Should be set before sessions are started.
+func (manager *Manager) SetSessionSharing(share bool) {
+	manager.sharedMx.Lock()
+	defer manager.sharedMx.Unlock()
+	manager.shareSessions = share
+	manager.shared = make(map[string]*sharedSession)
+}
+
+// sharedSessionStart returns session in use or loads it from provider.
+// Concurrent loads of the same session wait for the first one.
+func (manager *Manager) sharedSessionStart(sid string) (Session, error) {
+	if sid == "" {
+		sess, err := manager.sessionStart(sid)
+		if err != nil {
+			return nil, err
+		}
+		ready := make(chan struct{})
+		close(ready)
+		manager.sharedMx.Lock()
+		manager.shared[sess.SessionID()] = &sharedSession{sess: sess, refs: 1, ready: ready}
+		manager.sharedMx.Unlock()
+		return sess, nil
+	}
+
+	manager.sharedMx.Lock()
+	sh, ok := manager.shared[sid]
+	if ok {
+		sh.refs++
+		manager.sharedMx.Unlock()
+		<-sh.ready
+	} else {
+		sh = &sharedSession{refs: 1, ready: make(chan struct{})}
+		manager.shared[sid] = sh
+		manager.sharedMx.Unlock()
+		sh.sess, sh.err = manager.sessionStart(sid)
+		close(sh.ready)
+	}
+	if sh.err != nil {
+		manager.releaseShared(sid)
+		return nil, sh.err
+	}
+	return sh.sess, nil
+}
+
+// releaseShared decrements session users, returns true if the session is not used any more.
+func (manager *Manager) releaseShared(sid string) bool {
+	manager.sharedMx.Lock()
+	defer manager.sharedMx.Unlock()
+	sh, ok := manager.shared[sid]
+	if !ok {
+		return true
+	}
+	sh.refs--
+	if sh.refs > 0 {
+		return false
+	}
+	delete(manager.shared, sid)
+	return true
+}
+
+// forgetShared removes session from shared sessions.
+func (manager *Manager) forgetShared(sid string) {
+	manager.sharedMx.Lock()
+	delete(manager.shared, sid)
+	manager.sharedMx.Unlock()
+}
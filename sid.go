@@ -0,0 +1,103 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// DEFAULT_SESS_ID_LEN is a length of session ID generated for providers accepting longer IDs.
+const DEFAULT_SESS_ID_LEN = 36
+
+// MIN_SESS_ID_LEN is a minimal length of generated session ID, 64 random bits.
+const MIN_SESS_ID_LEN = 16
+
+// SessionIDValidator is an optional interface for providers with session ID format constraints
+// other than the default ones of ValidateSessionID().
+type SessionIDValidator interface {
+	ValidateSessionID(sid string) error //returns ErrInvalidSessionID for a malformed ID
+}
+
+// ValidateSessionID checks session ID of a client with provider SessionIDValidator if it is implemented.
+// Otherwise ID must be of URL-safe characters, letters, digits, '-', '_', '.' or '~',
+// not longer than provider GetSessionIDLen(). The error wraps ErrInvalidSessionID.
+func ValidateSessionID(provider Provider, sid string) error {
+	if validator, ok := provider.(SessionIDValidator); ok {
+		return validator.ValidateSessionID(sid)
+	}
+	if sid == "" {
+		return fmt.Errorf("%w: empty", ErrInvalidSessionID)
+	}
+	if max_len := provider.GetSessionIDLen(); max_len > 0 && len(sid) > max_len {
+		return fmt.Errorf("%w: length exceeds %d", ErrInvalidSessionID, max_len)
+	}
+	for i := 0; i < len(sid); i++ {
+		c := sid[i]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			continue
+		}
+		return fmt.Errorf("%w: character %q at %d", ErrInvalidSessionID, c, i)
+	}
+	return nil
+}
+
+// SIDValidator checks format of session ID given by a client, see SetSIDValidator().
+type SIDValidator func(sid string) error
+
+// SetSIDValidator sets application check of session IDs given by clients, nil removes it.
+// It is applied by SessionStart() after ValidateSessionID() and before the provider reads the session,
+// so attacker chosen IDs of other formats never reach providers creating unknown sessions on read.
+// Errors not wrapping ErrInvalidSessionID are wrapped with it:
+//
+//	SessManager.SetSIDValidator(func(sid string) error {
+//		if len(sid) != 36 {
+//			return errors.New("not a UUID")
+//		}
+//		return nil
+//	})
+func (manager *Manager) SetSIDValidator(fn SIDValidator) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.sidValidator = fn
+}
+
+// validateSessionID checks session ID given by a client with the provider and application validators.
+func (manager *Manager) validateSessionID(sid string) error {
+	if err := ValidateSessionID(manager.provider, sid); err != nil {
+		return err
+	}
+	manager.lock.Lock()
+	fn := manager.sidValidator
+	manager.lock.Unlock()
+	if fn == nil {
+		return nil
+	}
+	if err := fn(sid); err != nil {
+		if errors.Is(err, ErrInvalidSessionID) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", ErrInvalidSessionID, err)
+	}
+	return nil
+}
+
+// genSessionID generates unique ID for a session with crypto/rand. The ID is formatted
+// as UUID of DEFAULT_SESS_ID_LEN characters, or is a hex string of provider GetSessionIDLen()
+// if provider IDs are shorter. Empty string is returned if the random source fails.
+func (manager *Manager) genSessionID() string {
+	id_len := manager.provider.GetSessionIDLen()
+	if id_len <= 0 || id_len >= DEFAULT_SESS_ID_LEN {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return ""
+		}
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	}
+	b := make([]byte, (id_len+1)/2)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)[:id_len]
+}
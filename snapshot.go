@@ -0,0 +1,57 @@
+package session
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+)
+
+// CopyValues returns a deep copy of session values made with gob encoding,
+// custom value types must be registered with RegisterType().
+// Providers keeping values in memory implement Session.Restore() with this function, Session.Snapshot() with SnapshotValuesAt().
+func CopyValues(values map[string]interface{}) (map[string]interface{}, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(values); err != nil {
+		return nil, TypeError(err)
+	}
+	res := make(map[string]interface{}, len(values))
+	if err := gob.NewDecoder(&b).Decode(&res); err != nil {
+		return nil, TypeError(err)
+	}
+	return res, nil
+}
+
+// SnapshotValues returns a snapshot of values as SnapshotValuesAt() does by system time.
+func SnapshotValues(values map[string]interface{}) (map[string]interface{}, error) {
+	return SnapshotValuesAt(time.Now(), values)
+}
+
+// SnapshotValuesAt returns a deep copy of session values for Session.Snapshot(), see CopyValues().
+// Values are kept as they are stored, ExpiringValue and SensitiveValue are not unwrapped,
+// so TTLs survive Session.Restore(). Values expired by now are left out.
+func SnapshotValuesAt(now time.Time, values map[string]interface{}) (map[string]interface{}, error) {
+	snapshot, err := CopyValues(values)
+	if err != nil {
+		return nil, err
+	}
+	PurgeExpiredValuesAt(now, snapshot)
+	return snapshot, nil
+}
+
+// Restore replaces session values with snapshot values: values missing in snapshot are deleted
+// with Session.Delete(), snapshot values are set with Session.SetMany().
+// Session views, e.g. buckets, implement Session.Restore() with this function.
+func Restore(s Session, snapshot map[string]interface{}) error {
+	keys, err := s.Keys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, ok := snapshot[key]; !ok {
+			if err := s.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	return s.SetMany(snapshot)
+}
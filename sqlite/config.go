@@ -0,0 +1,143 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds provider parameters. It is passed to InitProvider() as the only parameter
+// instead of positional parameters:
+//
+//	session.NewManager("sqlite3", 0, 3600, "", sqlite.Config{Path: "sessions.db", BusyTimeout: 5 * time.Second})
+//
+// Concurrent Flush() calls of the default connection pool may fail with SQLITE_BUSY,
+// WAL, BusyTimeout and SingleWriter avoid it:
+//
+//	sqlite.Config{Path: "sessions.db", WAL: true, Synchronous: "NORMAL", BusyTimeout: 5 * time.Second, SingleWriter: true}
+type Config struct {
+	Path         string        //path to a database file or MEMORY_PATH, required
+	BusyTimeout  time.Duration //time to wait for a locked database, driver default if 0
+	WAL          bool          //enables write-ahead log journal mode, readers do not block the writer
+	Synchronous  string        //synchronous level: OFF, NORMAL, FULL or EXTRA, driver default if empty
+	SingleWriter bool          //limits connection pool to one connection, so writes are serialized by the pool
+	EncryptKey   string        //if set session payload is encrypted with AES-GCM
+	WriteBehind  time.Duration //write-behind interval, 0 disables write-behind
+	Names        Names         //database object names, DefaultNames if not set
+
+	//Connection pool parameters, zero values keep database/sql defaults: unlimited open connections,
+	//2 idle connections, connections are not closed by age. SingleWriter and in-memory database
+	//limit open connections to one.
+	MaxOpenConns    int           //max open connections
+	MaxIdleConns    int           //max idle connections
+	ConnMaxLifetime time.Duration //connections are closed after this time, not used with in-memory database
+}
+
+// MEMORY_PATH is Config.Path of the in-memory database shared by all providers of the process.
+// A separate in-memory database is opened with a named URI, e.g. file:sessions?mode=memory&cache=shared.
+// In-memory database is created with the schema on InitProvider() and is lost on CloseProvider().
+const MEMORY_PATH = ":memory:"
+
+// SYNCHRONOUS_LEVELS are values of Config.Synchronous.
+var SYNCHRONOUS_LEVELS = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+
+// configOf returns config from InitProvider() parameters: a Config or positional parameters.
+func configOf(provParams []interface{}) (Config, error) {
+	if len(provParams) < 1 {
+		return Config{}, errors.New("InitProvider missing parameters: path to a database file")
+	}
+	if cfg, ok := provParams[0].(Config); ok {
+		if cfg.Path == "" {
+			return Config{}, errors.New("InitProvider Config.Path must not be empty")
+		}
+		if cfg.BusyTimeout < 0 || cfg.WriteBehind < 0 {
+			return Config{}, errors.New("InitProvider Config.BusyTimeout and Config.WriteBehind must not be negative")
+		}
+		if cfg.MaxOpenConns < 0 || cfg.MaxIdleConns < 0 || cfg.ConnMaxLifetime < 0 {
+			return Config{}, errors.New("InitProvider Config connection pool parameters must not be negative")
+		}
+		if cfg.ConnMaxLifetime > 0 && cfg.inMemory() {
+			//in-memory database is lost with its last connection
+			return Config{}, errors.New("InitProvider Config.ConnMaxLifetime must not be set for in-memory database")
+		}
+		if cfg.Synchronous != "" && !slices.Contains(SYNCHRONOUS_LEVELS, strings.ToUpper(cfg.Synchronous)) {
+			return Config{}, fmt.Errorf("InitProvider Config.Synchronous must be one of %s", strings.Join(SYNCHRONOUS_LEVELS, ", "))
+		}
+		return cfg, nil
+	}
+
+	var cfg Config
+	var ok bool
+	if cfg.Path, ok = provParams[0].(string); !ok {
+		return Config{}, errors.New("InitProvider path to a database file must be a string")
+	}
+	if len(provParams) >= 2 {
+		if cfg.EncryptKey, ok = provParams[1].(string); !ok {
+			return Config{}, errors.New("InitProvider encryptKey parameter(1) must be a string")
+		}
+	}
+	if len(provParams) >= 3 {
+		write_behind, ok := provParams[2].(int)
+		if !ok || write_behind < 0 {
+			return Config{}, errors.New("InitProvider write-behind interval parameter(2) must be a non negative int")
+		}
+		cfg.WriteBehind = time.Duration(write_behind) * time.Millisecond
+	}
+	return cfg, nil
+}
+
+// inMemory returns true if database is kept in memory: MEMORY_PATH or file: URI with mode=memory.
+func (cfg Config) inMemory() bool {
+	return cfg.Path == MEMORY_PATH ||
+		strings.HasPrefix(cfg.Path, "file::memory:") ||
+		strings.HasPrefix(cfg.Path, "file:") && strings.Contains(cfg.Path, "mode=memory")
+}
+
+// applyPool sets connection pool parameters to the database handle.
+func (cfg Config) applyPool(db *sql.DB) {
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.SingleWriter || cfg.inMemory() {
+		//connections of shared cache fail with SQLITE_LOCKED instead of waiting for busy timeout
+		db.SetMaxOpenConns(1)
+	}
+}
+
+// dataSource returns driver data source name of the database file with busy timeout,
+// journal mode and synchronous level, they are applied by the driver to every connection.
+// MEMORY_PATH is opened with shared cache, so the database is visible to other connections of the process.
+func (cfg Config) dataSource() string {
+	path := cfg.Path
+	if path == MEMORY_PATH {
+		path = "file::memory:?cache=shared"
+	}
+	params := make([]string, 0, 3)
+	if cfg.BusyTimeout > 0 {
+		params = append(params, "_busy_timeout="+strconv.FormatInt(cfg.BusyTimeout.Milliseconds(), 10))
+	}
+	if cfg.WAL {
+		params = append(params, "_journal_mode=WAL")
+	}
+	if cfg.Synchronous != "" {
+		params = append(params, "_synchronous="+strings.ToUpper(cfg.Synchronous))
+	}
+	if len(params) == 0 {
+		return path
+	}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + strings.Join(params, "&")
+}
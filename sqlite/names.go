@@ -0,0 +1,122 @@
+package sqlite
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Names holds names of database objects used by the provider, empty names are defaults.
+// It is set in Config.Names, so sessions can coexist with other naming conventions
+// or be kept in an attached database of a tenant:
+//
+//	sqlite.Config{Path: "app.db", Names: sqlite.Names{Schema: "tenant1", Table: "web_sessions"}}
+//
+// Names are used in queries as they are, they must be valid sqlite identifiers.
+type Names struct {
+	Schema             string //attached database name, main database if empty
+	Table              string //session values table, session_vals by default
+	LocksTable         string //session locks table, session_locks by default
+	UsersTable         string //sessions bound to users, session_users by default
+	IDColumn           string //session ID column of all tables, id by default
+	ValColumn          string //session payload column, val by default
+	CreateTimeColumn   string //create_time by default
+	AccessedTimeColumn string //accessed_time by default
+	ExpiresAtColumn    string //expires_at by default
+	RatesColumn        string //counters of session.AllowRate(), rates by default
+}
+
+// DefaultNames are names of database objects used if Config.Names is not set, see schema.sql.
+var DefaultNames = Names{
+	Table:              "session_vals",
+	LocksTable:         "session_locks",
+	UsersTable:         "session_users",
+	IDColumn:           "id",
+	ValColumn:          "val",
+	CreateTimeColumn:   "create_time",
+	AccessedTimeColumn: "accessed_time",
+	ExpiresAtColumn:    "expires_at",
+	RatesColumn:        "rates",
+}
+
+// defaultNamesExp matches identifiers of DefaultNames and indexes of schema.sql in queries.
+var defaultNamesExp = regexp.MustCompile(`\b(session_vals_accessed_time_idx|session_vals_create_time_idx|session_users_user_id_idx|session_vals|session_locks|session_users|id|val|create_time|accessed_time|expires_at|rates)\b`)
+
+// withDefaults returns names with empty names replaced by DefaultNames.
+func (n Names) withDefaults() Names {
+	set := func(name *string, def string) {
+		if *name == "" {
+			*name = def
+		}
+	}
+	set(&n.Table, DefaultNames.Table)
+	set(&n.LocksTable, DefaultNames.LocksTable)
+	set(&n.UsersTable, DefaultNames.UsersTable)
+	set(&n.IDColumn, DefaultNames.IDColumn)
+	set(&n.ValColumn, DefaultNames.ValColumn)
+	set(&n.CreateTimeColumn, DefaultNames.CreateTimeColumn)
+	set(&n.AccessedTimeColumn, DefaultNames.AccessedTimeColumn)
+	set(&n.ExpiresAtColumn, DefaultNames.ExpiresAtColumn)
+	set(&n.RatesColumn, DefaultNames.RatesColumn)
+	return n
+}
+
+// nameMap replaces default identifiers of queries with configured names.
+type nameMap struct {
+	names Names
+	query map[string]string //default identifier to configured one in queries, tables are qualified with the schema
+}
+
+// newNameMap returns map of names, nil if default names are used and queries are not changed.
+func newNameMap(names Names) *nameMap {
+	names = names.withDefaults()
+	if names == DefaultNames {
+		return nil
+	}
+	return &nameMap{names: names, query: map[string]string{
+		DefaultNames.Table:               names.qualified(names.Table),
+		DefaultNames.LocksTable:          names.qualified(names.LocksTable),
+		DefaultNames.UsersTable:          names.qualified(names.UsersTable),
+		DefaultNames.IDColumn:            names.IDColumn,
+		DefaultNames.ValColumn:           names.ValColumn,
+		DefaultNames.CreateTimeColumn:    names.CreateTimeColumn,
+		DefaultNames.AccessedTimeColumn:  names.AccessedTimeColumn,
+		DefaultNames.ExpiresAtColumn:     names.ExpiresAtColumn,
+		DefaultNames.RatesColumn:         names.RatesColumn,
+		"session_vals_accessed_time_idx": names.qualified(names.Table + "_" + names.AccessedTimeColumn + "_idx"),
+		"session_vals_create_time_idx":   names.qualified(names.Table + "_" + names.CreateTimeColumn + "_idx"),
+		"session_users_user_id_idx":      names.qualified(names.UsersTable + "_user_id_idx"),
+	}}
+}
+
+// qualified returns name qualified with the schema if it is set.
+func (n Names) qualified(name string) string {
+	if n.Schema == "" {
+		return name
+	}
+	return n.Schema + "." + name
+}
+
+// replace returns query with configured names.
+func (m *nameMap) replace(query string) string {
+	if m == nil {
+		return query
+	}
+	return defaultNamesExp.ReplaceAllStringFunc(query, func(ident string) string {
+		return m.query[ident]
+	})
+}
+
+// schema returns schema SQL with configured names. Indexes are created in the schema
+// of their tables, sqlite does not allow qualified table names after ON.
+func (m *nameMap) schema(schemaSQL string) string {
+	if m == nil {
+		return schemaSQL
+	}
+	schemaSQL = m.replace(schemaSQL)
+	if m.names.Schema != "" {
+		for _, table := range []string{m.names.Table, m.names.LocksTable, m.names.UsersTable} {
+			schemaSQL = strings.ReplaceAll(schemaSQL, " ON "+m.names.qualified(table)+"(", " ON "+table+"(")
+		}
+	}
+	return schemaSQL
+}
@@ -0,0 +1,107 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/dronm/session"
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrDatabaseMoved is returned by Ping() if the database file is replaced or removed
+// since it was opened, connections keep using the old file till Reconnect().
+var ErrDatabaseMoved = errors.New("sqlite provider: database file is replaced or removed")
+
+// db returns current database handle, nil if provider is not initialized.
+func (pder *Provider) db() *sql.DB {
+	return pder.conn.Load()
+}
+
+// IsFatal implements session.Reconnector: database file is replaced or removed,
+// can not be opened, read or is not a database.
+func (pder *Provider) IsFatal(err error) bool {
+	if errors.Is(err, ErrDatabaseMoved) {
+		return true
+	}
+	var sqlite_err sqlite3.Error
+	if !errors.As(err, &sqlite_err) {
+		return false
+	}
+	switch sqlite_err.Code {
+	case sqlite3.ErrIoErr, sqlite3.ErrCorrupt, sqlite3.ErrCantOpen, sqlite3.ErrNotADB:
+		return true
+	case sqlite3.ErrReadonly:
+		return sqlite_err.ExtendedCode == sqlite3.ErrReadonlyDbMoved
+	}
+	return false
+}
+
+// Reconnect implements session.Reconnector: database file is opened again with Config of InitProvider(),
+// old connections are closed when their queries are finished. In-memory database can not be reopened.
+func (pder *Provider) Reconnect(ctx context.Context) error {
+	old := pder.db()
+	if old == nil {
+		return session.ErrProviderNotInitialized
+	}
+	if pder.cfg.inMemory() {
+		return errors.New("sqlite provider: in-memory database can not be reopened")
+	}
+	conn, err := sql.Open(PROVIDER, pder.cfg.dataSource())
+	if err != nil {
+		return err
+	}
+	pder.cfg.applyPool(conn)
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return err
+	}
+	if !pder.conn.CompareAndSwap(old, conn) {
+		//closed or reconnected concurrently
+		conn.Close()
+		return nil
+	}
+	pder.statFile()
+	return old.Close()
+}
+
+// filePath returns path of the database file, empty for in-memory database.
+func (cfg Config) filePath() string {
+	if cfg.inMemory() {
+		return ""
+	}
+	path, found := strings.CutPrefix(cfg.Path, "file:")
+	if found {
+		path, _, _ = strings.Cut(path, "?")
+	}
+	return path
+}
+
+// statFile keeps information of the opened database file, so its replacement is detected by checkFile().
+func (pder *Provider) statFile() {
+	var file os.FileInfo
+	if path := pder.cfg.filePath(); path != "" {
+		file, _ = os.Stat(path) //created by the first query if it does not exist
+	}
+	pder.fileMx.Lock()
+	pder.file = file
+	pder.fileMx.Unlock()
+}
+
+// checkFile returns ErrDatabaseMoved if the database file is not the file opened.
+func (pder *Provider) checkFile() error {
+	pder.fileMx.Lock()
+	file := pder.file
+	pder.fileMx.Unlock()
+	if file == nil {
+		pder.statFile() //created after InitProvider()
+		return nil
+	}
+	cur, err := os.Stat(pder.cfg.filePath())
+	if err != nil || !os.SameFile(file, cur) {
+		return ErrDatabaseMoved
+	}
+	return nil
+}
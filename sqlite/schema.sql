@@ -0,0 +1,22 @@
+-- Session provider schema, applied by Provider.EnsureSchema().
+CREATE TABLE IF NOT EXISTS session_vals
+(id varchar(36) NOT NULL PRIMARY KEY,
+accessed_time datetime DEFAULT CURRENT_TIMESTAMP,
+create_time datetime DEFAULT CURRENT_TIMESTAMP,
+val bytea,
+expires_at datetime,
+rates bytea
+);
+CREATE INDEX IF NOT EXISTS session_vals_accessed_time_idx ON session_vals(accessed_time);
+CREATE INDEX IF NOT EXISTS session_vals_create_time_idx ON session_vals(create_time);
+CREATE TABLE IF NOT EXISTS session_locks
+(id varchar(36) NOT NULL PRIMARY KEY,
+token varchar(32),
+lock_till integer
+);
+CREATE TABLE IF NOT EXISTS session_users
+(id varchar(36) NOT NULL PRIMARY KEY,
+user_id varchar(100) NOT NULL,
+bound_at integer NOT NULL
+);
+CREATE INDEX IF NOT EXISTS session_users_user_id_idx ON session_users(user_id);
@@ -2,33 +2,51 @@
 // Requirements:
 //
 //	 Sqlite connection github.com/mattn/go-sqlite3
+//		Tables and indexes are created with Provider.EnsureSchema(), see schema.sql.
 //		Some SQL scripts are nesessary:
-//			session_vals.sql contains table for holding session values
+//			session_vals.sql contains table for holding session values,
+//				expires_at datetime column holds per-session expiration set with SessionStore.SetExpiry():
+//				ALTER TABLE session_vals ADD COLUMN expires_at datetime
+//			session_locks table is used by SessionStore.Lock():
+//				CREATE TABLE session_locks(id varchar(36) NOT NULL PRIMARY KEY, token varchar(32), lock_till integer)
+//			session_users table indexed by user_id keeps sessions bound to users with session.Manager.BindUser(), see schema.sql
 //			session_vals_process.sql trigger function for updating login information (logins table must be present in database)
 //			session_vals_trigger.sql creating trigger script
 //
 // Internally gob encoder is used for data serialization. Session data is read at start and kept in memory SessionStore structure.
 // Session key-value pares are kept in storeValue type.
+//
+// Write-behind mode (see InitProvider) coalesces Flush() calls: session values are queued
+// and written in batches within a single transaction. Pending values are written by Drain()
+// and on CloseProvider().
 package sqlite
 
 import (
-	"bytes"
 	"context"
+	"crypto/rand"
 	"database/sql"
-	"encoding/gob"
+	_ "embed"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"os"
 	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dronm/session"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
-var EKeyNotFound = errors.New("key not found")
-var EValMustBePtr = errors.New("value must be of type ptr")
+// Deprecated: use session.ErrKeyNotFound.
+var EKeyNotFound = session.ErrKeyNotFound
+
+// Deprecated: use session.ErrValMustBePtr.
+var EValMustBePtr = session.ErrValMustBePtr
 
 // Session key ID length. As it is stored it pg data base in varchar column its length is limited.
 const SESS_ID_LEN = 36
@@ -37,20 +55,28 @@ const PROVIDER = "sqlite3"
 
 const LOG_PREF = "sqlite provider:"
 
-// pder holds pointer to Provider struct.
-var pder = &Provider{}
+// pder holds pointer to Provider struct registered as PROVIDER.
+var pder = NewProvider()
+
+// NewProvider returns a provider independent of the registered one, e.g. to use another database file
+// in another Manager created with session.NewManagerWithProvider().
+func NewProvider() *Provider {
+	return &Provider{clock: session.SystemClock{}}
+}
 
 // storeValue holds session key-value pares.
 type storeValue map[string]interface{}
 
 // SessionStore contains session information.
 type SessionStore struct {
-	sid           string //session id
+	sid           string    //session id
+	pder          *Provider //provider storing the session
 	mx            sync.RWMutex
-	timeAccessed  time.Time  //last modified
-	timeCreated   time.Time  //when created
-	value         storeValue //key-value pair
+	timeAccessed  atomic.Int64 //last access in Unix nanoseconds, updated under read lock by getters
+	timeCreated   time.Time    //when created
+	value         storeValue   //key-value pair
 	valueModified bool
+	lockToken     string //set when session is locked
 }
 
 // Set sets inmemory value. No database flush is done.
@@ -70,12 +96,12 @@ func (st *SessionStore) Set(key string, value interface{}) error {
 			v = value
 		}
 	*/
+	st.mx.Lock()
+	defer st.mx.Unlock()
 	if !reflect.DeepEqual(st.value[key], value) {
-		st.mx.Lock()
 		st.value[key] = value
 		st.valueModified = true
-		st.timeAccessed = time.Now()
-		st.mx.Unlock()
+		st.accessed()
 	}
 	return nil
 }
@@ -87,31 +113,90 @@ func (st *SessionStore) Put(key string, value interface{}) error {
 	return st.Flush()
 }
 
+// SetMany sets in-memory values under one lock, they are written by one Flush().
+func (st *SessionStore) SetMany(values map[string]interface{}) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	for key, value := range values {
+		if !reflect.DeepEqual(st.value[key], value) {
+			st.value[key] = value
+			st.valueModified = true
+			st.accessed()
+		}
+	}
+	return nil
+}
+
+// SetWithTTL sets in-memory value expiring in ttl, see session.ExpiringValue.
+func (st *SessionStore) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value[key] = session.NewExpiringValueAt(st.pder.clock.Now(), value, ttl)
+	st.valueModified = true
+	st.accessed()
+	return nil
+}
+
+// GetMany assigns in-memory values to dest pointers, see session.GetMany().
+func (st *SessionStore) GetMany(dest map[string]interface{}) error {
+	return session.GetMany(st, dest)
+}
+
+// Snapshot returns a deep copy of in-memory values without expired ones, see session.SnapshotValuesAt().
+func (st *SessionStore) Snapshot() (map[string]interface{}, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return session.SnapshotValuesAt(st.pder.clock.Now(), st.value)
+}
+
+// Restore replaces in-memory values with a copy of snapshot, they are written by Flush().
+func (st *SessionStore) Restore(snapshot map[string]interface{}) error {
+	value, err := session.CopyValues(snapshot)
+	if err != nil {
+		return err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value = value
+	st.valueModified = true
+	st.accessed()
+	return nil
+}
+
 // Flush performs the actual write to database.
+// In write-behind mode value is queued and written later in a batch.
 func (st *SessionStore) Flush() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+
 	//flush val only if it's been modified
 	if st.valueModified {
 		//modified
-		val, err := getForDb(&st.value)
+		val, err := st.pder.getForDb(&st.value)
 		if err != nil {
 			return err
 		}
 
-		st.mx.Lock()
-		defer st.mx.Unlock()
+		if st.pder.writeQueue != nil {
+			st.pder.writeQueue.put(st.sid, val)
+			st.valueModified = false
+			st.written()
+			return nil
+		}
 
-		if _, err = pder.dbConn.ExecContext(context.Background(),
-			`UPDATE session_vals
+		if _, err = st.pder.db().ExecContext(context.Background(),
+			st.pder.query(`UPDATE session_vals
 			SET
 				val = $1,
-				accessed_time = datetime()
-			WHERE id = $2`,
+				accessed_time = `+st.pder.accessedTime(false)+`
+			WHERE id = $2`),
 			val,
 			st.sid,
 		); err != nil {
 			return err
 		}
 		st.valueModified = false
+		st.written()
 	}
 
 	return nil
@@ -119,9 +204,11 @@ func (st *SessionStore) Flush() error {
 
 // Get returns session value by its key. Value is retrieved from memory.
 func (st *SessionStore) Get(key string, val interface{}) error {
-	store_val, ok := st.value[key]
+	st.mx.RLock()
+	store_val, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
 	if !ok {
-		return EKeyNotFound
+		return session.ErrKeyNotFound
 	}
 
 	// Get the type of val
@@ -129,13 +216,13 @@ func (st *SessionStore) Get(key string, val interface{}) error {
 
 	// Make sure val is a pointer
 	if val_type.Kind() != reflect.Ptr {
-		return EValMustBePtr
+		return session.ErrValMustBePtr
 	}
 
 	// Dereference the pointer and check if it's assignable
 	val_elem := val_type.Elem()
 	if !reflect.TypeOf(store_val).AssignableTo(val_elem) {
-		return errors.New("value type mismatch")
+		return session.ErrTypeMismatch
 	}
 
 	// Assign the value to val
@@ -144,15 +231,24 @@ func (st *SessionStore) Get(key string, val interface{}) error {
 	return nil
 }
 
+// GetStruct retrieves session value by its key into dest pointer.
+// Value of a different type is converted, see session.AssignValue().
+func (st *SessionStore) GetStruct(key string, dest interface{}) error {
+	st.mx.RLock()
+	store_val, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if !ok {
+		return session.ErrKeyNotFound
+	}
+	return session.AssignValue(store_val, dest)
+}
+
 // GetBool returns bool value by key.
 func (st *SessionStore) GetBool(key string) bool {
-	v, ok := st.value[key]
+	v, ok := st.lookup(key)
 	if !ok {
 		return false
 	}
-	st.mx.Lock()
-	defer st.mx.Unlock()
-	st.timeAccessed = time.Now()
 
 	if v_bool, ok := v.(bool); ok {
 		return v_bool
@@ -162,15 +258,11 @@ func (st *SessionStore) GetBool(key string) bool {
 
 // GetString returns string value by key.
 func (st *SessionStore) GetString(key string) string {
-	v, ok := st.value[key]
+	v, ok := st.lookup(key)
 	if !ok {
 		return ""
 	}
 
-	st.mx.Lock()
-	defer st.mx.Unlock()
-	st.timeAccessed = time.Now()
-
 	if v_str, ok := v.(string); ok {
 		return v_str
 
@@ -182,15 +274,11 @@ func (st *SessionStore) GetString(key string) string {
 
 // GetInt returns int value by key.
 func (st *SessionStore) GetInt(key string) int64 {
-	v, ok := st.value[key]
+	v, ok := st.lookup(key)
 	if !ok {
 		return 0
 	}
 
-	st.mx.Lock()
-	defer st.mx.Unlock()
-	st.timeAccessed = time.Now()
-
 	if v_i, ok := v.(int64); ok {
 		return v_i
 
@@ -202,15 +290,11 @@ func (st *SessionStore) GetInt(key string) int64 {
 
 // GetFloat returns float value by key.
 func (st *SessionStore) GetFloat(key string) float64 {
-	v, ok := st.value[key]
+	v, ok := st.lookup(key)
 	if !ok {
 		return 0
 	}
 
-	st.mx.Lock()
-	defer st.mx.Unlock()
-	st.timeAccessed = time.Now()
-
 	if v_f, ok := v.(float64); ok {
 		return v_f
 
@@ -222,36 +306,233 @@ func (st *SessionStore) GetFloat(key string) float64 {
 
 // GetDate returns time.Time value by key.
 func (st *SessionStore) GetDate(key string) time.Time {
-	v, ok := st.value[key]
+	v, ok := st.lookup(key)
 	if !ok {
 		return time.Time{}
 	}
 
-	st.mx.Lock()
-	defer st.mx.Unlock()
-	st.timeAccessed = time.Now()
-
 	if v_t, ok := v.(time.Time); ok {
 		return v_t
 	}
 	return time.Time{}
 }
 
-// Delete deletes session value from memmory by key. No flushing is done.
-func (st *SessionStore) Delete(key string) error {
-	_, ok := st.value[key]
+// GetBytes returns []byte value by key, string value is converted.
+func (st *SessionStore) GetBytes(key string) []byte {
+	v, ok := st.lookup(key)
 	if !ok {
 		return nil
 	}
 
+	if v_b, ok := v.([]byte); ok {
+		return v_b
+
+	} else if v_b, ok := v.(string); ok {
+		return []byte(v_b)
+	}
+	return nil
+}
+
+// GetStringSlice returns []string value by key.
+func (st *SessionStore) GetStringSlice(key string) []string {
+	v, ok := st.lookup(key)
+	if !ok {
+		return nil
+	}
+
+	if v_s, ok := v.([]string); ok {
+		return v_s
+	}
+	return nil
+}
+
+// GetOrSet assigns session value to dest, if there is no value
+// it is computed, stored and flushed under session lock, see session.GetOrSet().
+func (st *SessionStore) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	return session.GetOrSet(st, key, dest, compute)
+}
+
+// Delete deletes session value from memmory by key. No flushing is done.
+func (st *SessionStore) Delete(key string) error {
 	st.mx.Lock()
 	defer st.mx.Unlock()
-	st.timeAccessed = time.Now()
+	if _, ok := st.value[key]; !ok {
+		return nil
+	}
+	st.accessed()
 	delete(st.value, key)
+	st.valueModified = true
+
+	return nil
+}
+
+// Clear deletes all session values from memory keeping session ID and creation time. No flushing is done.
+func (st *SessionStore) Clear() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if len(st.value) > 0 {
+		st.value = make(storeValue)
+		st.valueModified = true
+	}
+	st.accessed()
 
 	return nil
 }
 
+// Increment atomically adds delta to integer session value and returns the new value.
+// Missing value is treated as 0. The value is updated in database within a transaction
+// and in memory, other modified in-memory values are not flushed.
+func (st *SessionStore) Increment(key string, delta int64) (int64, error) {
+	//held till the value is updated in memory, concurrent Flush() must not overwrite it
+	st.mx.Lock()
+	defer st.mx.Unlock()
+
+	if st.pder.writeQueue != nil {
+		if err := st.pder.writeQueue.flushSession(st.sid); err != nil {
+			return 0, err
+		}
+	}
+	ctx := context.Background()
+	tx, err := st.pder.db().BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	//write first to take database write lock before reading
+	if _, err := tx.ExecContext(ctx,
+		st.pder.query(`INSERT INTO session_vals(id, accessed_time, create_time) VALUES($1, `+st.pder.now()+`, `+st.pder.now()+`)
+		ON CONFLICT(id) DO UPDATE SET accessed_time = `+st.pder.accessedTime(false)),
+		st.sid,
+	); err != nil {
+		return 0, err
+	}
+	var val []byte
+	if err := tx.QueryRowContext(ctx, st.pder.query(`SELECT val FROM session_vals WHERE id = $1`), st.sid).Scan(&val); err != nil {
+		return 0, err
+	}
+	db_value := make(storeValue)
+	if err := st.pder.setFromDb(&db_value, val); err != nil {
+		return 0, err
+	}
+	cur, _ := session.LookupValueAt(st.pder.clock.Now(), db_value, key)
+	new_val, err := session.IncrementValue(cur, delta)
+	if err != nil {
+		return 0, err
+	}
+	db_value[key] = new_val
+	if val, err = st.pder.getForDb(&db_value); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, st.pder.query(`UPDATE session_vals SET val = $1 WHERE id = $2`), val, st.sid); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	st.value[key] = new_val
+	st.written()
+
+	return new_val, nil
+}
+
+// Decrement atomically subtracts delta from integer session value and returns the new value.
+func (st *SessionStore) Decrement(key string, delta int64) (int64, error) {
+	return st.Increment(key, -delta)
+}
+
+// CompareAndSwap sets newValue in a transaction if the stored value equals oldValue,
+// see session.Session.CompareAndSwap().
+func (st *SessionStore) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+
+	if st.pder.writeQueue != nil {
+		if err := st.pder.writeQueue.flushSession(st.sid); err != nil {
+			return false, err
+		}
+	}
+	ctx := context.Background()
+	tx, err := st.pder.db().BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	//write first to take database write lock before reading
+	if _, err := tx.ExecContext(ctx,
+		st.pder.query(`INSERT INTO session_vals(id, accessed_time, create_time) VALUES($1, `+st.pder.now()+`, `+st.pder.now()+`)
+		ON CONFLICT(id) DO UPDATE SET accessed_time = `+st.pder.accessedTime(false)),
+		st.sid,
+	); err != nil {
+		return false, err
+	}
+	var val []byte
+	if err := tx.QueryRowContext(ctx, st.pder.query(`SELECT val FROM session_vals WHERE id = $1`), st.sid).Scan(&val); err != nil {
+		return false, err
+	}
+	db_value := make(storeValue)
+	if err := st.pder.setFromDb(&db_value, val); err != nil {
+		return false, err
+	}
+	if cur, _ := session.LookupValueAt(st.pder.clock.Now(), db_value, key); !session.EqualValue(cur, oldValue) {
+		return false, nil
+	}
+	if newValue == nil {
+		delete(db_value, key)
+	} else {
+		db_value[key] = newValue
+	}
+	if val, err = st.pder.getForDb(&db_value); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, st.pder.query(`UPDATE session_vals SET val = $1 WHERE id = $2`), val, st.sid); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	if newValue == nil {
+		delete(st.value, key)
+	} else {
+		st.value[key] = newValue
+	}
+	st.written()
+
+	return true, nil
+}
+
+// Bucket returns a view of the session with keys prefixed by name, see session.NewBucket().
+func (st *SessionStore) Bucket(name string) session.Session {
+	return session.NewBucket(st, name)
+}
+
+// Keys returns sorted session value keys.
+func (st *SessionStore) Keys() ([]string, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	keys := make([]string, 0, len(st.value))
+	now := st.pder.clock.Now()
+	for key, v := range st.value {
+		if !session.ValueExpiredAt(now, v) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Len returns number of session values.
+func (st *SessionStore) Len() (int, error) {
+	keys, err := st.Keys()
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
 // SessionID returns session unique ID.
 func (st *SessionStore) SessionID() string {
 	return st.sid
@@ -264,38 +545,262 @@ func (st *SessionStore) TimeCreated() time.Time {
 
 // TimeAccessed returns timeAccessed property.
 func (st *SessionStore) TimeAccessed() time.Time {
-	return st.timeAccessed
+	return time.Unix(0, st.timeAccessed.Load())
+}
+
+// Lock acquires session lock. Lock is a row in session_locks table
+// with expiration time, it is released automatically after session.LockTTL().
+// Values are reloaded from database after the lock is acquired.
+func (st *SessionStore) Lock() error {
+	token, err := genLockToken()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), session.LOCK_WAIT)
+	defer cancel()
+	for {
+		now := st.pder.clock.Now()
+		res, err := st.pder.db().ExecContext(ctx,
+			st.pder.query(`INSERT INTO session_locks(id, token, lock_till) VALUES($1, $2, $3)
+			ON CONFLICT(id) DO UPDATE SET
+				token = excluded.token,
+				lock_till = excluded.lock_till
+			WHERE session_locks.lock_till < $4`),
+			st.sid, token, now.Add(session.LockTTL()).UnixMilli(), now.UnixMilli(),
+		)
+		if err != nil {
+			if ctx.Err() != nil {
+				return session.ErrLockTimeout
+			}
+			return err
+		}
+		if cnt, err := res.RowsAffected(); err != nil {
+			return err
+		} else if cnt > 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return session.ErrLockTimeout
+		case <-time.After(session.LOCK_RETRY):
+		}
+	}
+
+	st.mx.Lock()
+	st.lockToken = token
+	st.mx.Unlock()
+
+	return st.reload()
+}
+
+// Unlock releases session lock.
+func (st *SessionStore) Unlock() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if st.lockToken == "" {
+		return nil
+	}
+	if _, err := st.pder.db().ExecContext(context.Background(),
+		st.pder.query(`DELETE FROM session_locks WHERE id = $1 AND token = $2`),
+		st.sid, st.lockToken,
+	); err != nil {
+		return err
+	}
+	st.lockToken = ""
+	return nil
+}
+
+// SetExpiry sets session expiration time to now+d, the session is removed by GC
+// after that time regardless of max life and idle time. d <= 0 restores defaults.
+func (st *SessionStore) SetExpiry(d time.Duration) error {
+	var expires_at interface{} //NULL
+	if d > 0 {
+		expires_at = st.pder.clock.Now().Add(d).UTC().Format(time.DateTime)
+	}
+	res, err := st.pder.db().ExecContext(context.Background(),
+		st.pder.query(`UPDATE session_vals SET expires_at = $1 WHERE id = $2`),
+		expires_at, st.sid,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return session.ErrSessionNotFound
+	}
+	return nil
+}
+
+// Touch updates session access time in database,
+// nothing is done in session.EXPIRATION_FIXED mode.
+func (st *SessionStore) Touch() error {
+	if !st.pder.expMode.TouchOnWrite() {
+		return nil
+	}
+	res, err := st.pder.db().ExecContext(context.Background(),
+		st.pder.query(`UPDATE session_vals SET accessed_time = `+st.pder.now()+` WHERE id = $1`),
+		st.sid,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return session.ErrSessionNotFound
+	}
+	st.mx.Lock()
+	st.written()
+	st.mx.Unlock()
+	return nil
+}
+
+// Allow counts action in the current window of provider clock, see session.AllowRate().
+func (st *SessionStore) Allow(action string, limit int, window time.Duration) (bool, error) {
+	return session.AllowRate(st, st.pder.clock, action, limit, window)
+}
+
+// CountRate implements session.RateCounter, counters are kept in rates column in a transaction.
+func (st *SessionStore) CountRate(action string, now time.Time, window time.Duration) (int64, error) {
+	ctx := context.Background()
+	tx, err := st.pder.db().BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	//write first to take database write lock before reading
+	if _, err := tx.ExecContext(ctx,
+		st.pder.query(`INSERT INTO session_vals(id, accessed_time, create_time) VALUES($1, `+st.pder.now()+`, `+st.pder.now()+`)
+		ON CONFLICT(id) DO UPDATE SET accessed_time = `+st.pder.accessedTime(false)),
+		st.sid,
+	); err != nil {
+		return 0, err
+	}
+	var rates_b []byte
+	if err := tx.QueryRowContext(ctx, st.pder.query(`SELECT rates FROM session_vals WHERE id = $1`), st.sid).Scan(&rates_b); err != nil {
+		return 0, err
+	}
+	rates, err := session.DecodeRates(rates_b)
+	if err != nil {
+		return 0, err
+	}
+	cnt := rates.Count(action, now, window)
+	if rates_b, err = session.EncodeRates(rates); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, st.pder.query(`UPDATE session_vals SET rates = $1 WHERE id = $2`), rates_b, st.sid); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return cnt, nil
+}
+
+// SetSensitive sets value encrypted with keys of session.SetSensitiveKeys(), see session.SetSensitive().
+func (st *SessionStore) SetSensitive(key string, value interface{}) error {
+	return session.SetSensitive(st, key, value)
+}
+
+// lookup returns in-memory value by key under read lock and updates access time on success,
+// so concurrent readers do not serialize.
+func (st *SessionStore) lookup(key string) (interface{}, bool) {
+	st.mx.RLock()
+	v, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if ok {
+		st.accessed()
+	}
+	return v, ok
+}
+
+// accessed updates in-memory access time if it is updated on every access.
+// The time is stored atomically, st.mx is not required.
+func (st *SessionStore) accessed() {
+	if st.pder.expMode.TouchOnRead() {
+		st.timeAccessed.Store(st.pder.clock.Now().UnixNano())
+	}
+}
+
+// written updates in-memory access time after session is written to database.
+func (st *SessionStore) written() {
+	if st.pder.expMode.TouchOnWrite() {
+		st.timeAccessed.Store(st.pder.clock.Now().UnixNano())
+	}
+}
+
+// reload reads values from database to memory.
+func (st *SessionStore) reload() error {
+	if st.pder.writeQueue != nil {
+		if err := st.pder.writeQueue.flushSession(st.sid); err != nil {
+			return err
+		}
+	}
+	var val []byte
+	if err := st.pder.db().QueryRowContext(context.Background(),
+		st.pder.query(`SELECT val FROM session_vals WHERE id = $1`),
+		st.sid).Scan(&val); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	value := make(storeValue)
+	if err := st.pder.setFromDb(&value, val); err != nil {
+		return err
+	}
+	st.mx.Lock()
+	st.value = value
+	st.valueModified = false
+	st.mx.Unlock()
+	return nil
 }
 
 // Provider structure holds provider information.
 type Provider struct {
-	dbConn      *sql.DB
-	encrkey     string
-	maxLifeTime int64
-	maxIdleTime int64
+	conn           atomic.Pointer[sql.DB]  //replaced by Reconnect(), see db()
+	cfg            Config                  //config of InitProvider(), used by Reconnect()
+	fileMx         sync.Mutex              //guards file
+	file           os.FileInfo             //database file opened, nil for in-memory database
+	compression    *session.Compression    //payload compression, nil if not used
+	payloadVersion *session.PayloadVersion //payload versions, nil if not used
+	maxLifeTime    int64
+	maxIdleTime    int64
+	logger         *slog.Logger        //structured logger, nil if not set
+	expiredHook    session.SessionHook //called for sessions removed by SessionGC
+	writeQueue     *writeQueue         //write-behind queue, nil if not used
+	gcLimit        int                 //max sessions removed by one SessionGC run, 0 if not limited
+	names          *nameMap            //configured database object names, nil if defaults are used
+
+	expMode session.ExpirationMode //when accessed_time is updated
+	strict  bool                   //unknown sessions are not created on read, see SetStrictMode()
+	clock   session.Clock          //session times and expiration, see SetClock()
+
+	keyRing atomic.Pointer[session.KeyRing] //payload encryption, nil if not used
 }
 
 func (pder *Provider) NewSessionStore(sid string) *SessionStore {
-	return &SessionStore{
-		sid:          sid,
-		timeAccessed: time.Now(),
-		timeCreated:  time.Now(),
-		value:        make(map[string]interface{}, 0),
+	store := &SessionStore{
+		pder:        pder,
+		sid:         sid,
+		timeCreated: pder.clock.Now(),
+		value:       make(map[string]interface{}, 0),
 	}
+	store.timeAccessed.Store(store.timeCreated.UnixNano())
+	return store
 }
 
 // SessionInit initializes session with given ID.
 func (pder *Provider) SessionInit(sid string) (session.Session, error) {
-	if pder.dbConn == nil {
-		return nil, errors.New("Provider not initialized")
+	if pder.db() == nil {
+		return nil, session.ErrProviderNotInitialized
 	}
 
 	if len(sid) > SESS_ID_LEN {
-		return nil, errors.New("Session key length exceeded max value")
+		return nil, fmt.Errorf("%w: length exceeds %d", session.ErrInvalidSessionID, SESS_ID_LEN)
 	}
 
-	if _, err := pder.dbConn.ExecContext(context.Background(),
-		"INSERT OR IGNORE INTO session_vals(id) VALUES($1)",
+	if _, err := pder.db().ExecContext(context.Background(),
+		pder.query("INSERT OR IGNORE INTO session_vals(id, accessed_time, create_time) VALUES($1, "+pder.now()+", "+pder.now()+")"),
 		sid,
 	); err != nil {
 		return nil, err
@@ -304,32 +809,68 @@ func (pder *Provider) SessionInit(sid string) (session.Session, error) {
 }
 
 // SessionRead reads session data from db to memory.
+// Expired session is destroyed, session.ErrSessionExpired is returned then.
 func (pder *Provider) SessionRead(sid string) (session.Session, error) {
 	var val []byte
 
 	store := pder.NewSessionStore(sid)
 
-	if err := pder.dbConn.QueryRowContext(context.Background(),
-		`UPDATE session_vals
+	var expires_at sql.NullTime
+	var accessed_time time.Time
+	if err := pder.db().QueryRowContext(context.Background(),
+		pder.query(`SELECT accessed_time, create_time, expires_at FROM session_vals WHERE id = $1`),
+		sid).Scan(&accessed_time,
+		&store.timeCreated,
+		&expires_at,
+	); err != nil && err == sql.ErrNoRows {
+		//no such session
+		if pder.strict {
+			return nil, session.ErrSessionNotFound
+		}
+		return pder.SessionInit(sid)
+
+	} else if err != nil {
+		return nil, err
+	}
+	if session.IsExpiredAt(pder.clock.Now(), store.timeCreated, accessed_time, expires_at.Time, pder.maxLifeTime, pder.maxIdleTime) {
+		if err := pder.removeSessionFromDb(sid); err != nil {
+			return nil, err
+		}
+		return nil, session.ErrSessionExpired
+	}
+
+	if err := pder.db().QueryRowContext(context.Background(),
+		pder.query(`UPDATE session_vals
 		SET
-			accessed_time = datetime()
+			accessed_time = `+pder.accessedTime(true)+`
 		WHERE id = $1
 		RETURNING
 			accessed_time,
 			create_time,
-			val`,
-		sid).Scan(&store.timeAccessed,
+			val`),
+		sid).Scan(&accessed_time,
 		&store.timeCreated,
 		&val,
 	); err != nil && err == sql.ErrNoRows {
 		//no such session
+		if pder.strict {
+			return nil, session.ErrSessionNotFound
+		}
 		return pder.SessionInit(sid)
 
 	} else if err != nil {
 		return nil, err
 	}
+	store.timeAccessed.Store(accessed_time.UnixNano())
 
-	if err := setFromDb(&store.value, val); err != nil {
+	if pder.writeQueue != nil {
+		//not yet written value
+		if pending_val, ok := pder.writeQueue.get(sid); ok {
+			val = pending_val
+		}
+	}
+
+	if err := pder.setFromDb(&store.value, val); err != nil {
 		return nil, err
 	}
 
@@ -348,42 +889,394 @@ func (pder *Provider) SessionDestroy(sid string) error {
 	return nil
 }
 
-// SessionGC clears unused sessions
-func (pder *Provider) SessionGC(l io.Writer, logLev session.LogLevel) {
-	if pder.maxIdleTime == 0 && pder.maxLifeTime == 0 {
-		return
+// SessionGC clears unused sessions.
+// Sessions with expiration time set by SessionStore.SetExpiry() are removed after that time only.
+func (pder *Provider) SessionGC(l io.Writer, logLev session.LogLevel) session.GCReport {
+	report, _ := pder.sessionGC(pder.getLogger(l, logLev))
+	return report
+}
+
+// CollectGarbage implements session.ProviderV2, errors of GC queries are returned joined.
+func (pder *Provider) CollectGarbage() (int, error) {
+	report, err := pder.sessionGC(pder.getLogger(nil, session.LOG_LEVEL_ERROR))
+	return report.Deleted(), err
+}
+
+// sessionGC clears unused sessions, errors are logged and returned joined.
+func (pder *Provider) sessionGC(log *slog.Logger) (session.GCReport, error) {
+	log = log.With(session.LOG_KEY_OPERATION, "SessionGC")
+	start := time.Now()
+	var report session.GCReport
+	var errs []error
+	defer func() {
+		log.Debug(LOG_PREF+"SessionGC() done", session.LOG_KEY_COUNT, report.Deleted(), session.LOG_KEY_DURATION, time.Since(start))
+	}()
+
+	if cnt, err := pder.gcDelete(
+		`expires_at IS NOT NULL AND expires_at <= `+pder.now(), pder.gcLimit,
+	); err != nil {
+		report.Errors++
+		errs = append(errs, err)
+		log.Error(LOG_PREF+"gcDelete() failed on expires_at", session.LOG_KEY_ERROR, err)
+	} else {
+		report.DeletedExpiry = cnt
+		log.Debug(LOG_PREF+"expired sessions deleted", session.LOG_KEY_COUNT, cnt)
 	}
 
 	//inactive sessions
-	if pder.maxIdleTime > 0 {
-		if _, err := pder.dbConn.ExecContext(context.Background(),
-			fmt.Sprintf(`DELETE FROM session_vals WHERE accessed_time + '%d seconds' <= datetime()`, pder.maxIdleTime),
+	if limit, ok := pder.gcRemaining(report); ok && pder.maxIdleTime > 0 {
+		if cnt, err := pder.gcDelete(
+			`expires_at IS NULL AND datetime(accessed_time, $1) <= `+pder.now(), limit,
+			secondsModifier(pder.maxIdleTime),
 		); err != nil {
-			//log error
-			if l != nil {
-				session.WriteToLog(l, fmt.Sprintf(LOG_PREF+"Exec() failed on DELETE FROM session_vals WHERE accessed_time: %v", err), session.LOG_LEVEL_ERROR)
-			}
+			report.Errors++
+			errs = append(errs, err)
+			log.Error(LOG_PREF+"gcDelete() failed on accessed_time", session.LOG_KEY_ERROR, err)
+		} else {
+			report.DeletedIdle = cnt
+			log.Debug(LOG_PREF+"idle sessions deleted", session.LOG_KEY_COUNT, cnt)
 		}
 	}
 
-	if pder.maxLifeTime > 0 {
-		if _, err := pder.dbConn.ExecContext(context.Background(),
-			fmt.Sprintf(`DELETE FROM session_vals WHERE create_time + '%d seconds' <= datetime()`, pder.maxLifeTime),
+	if limit, ok := pder.gcRemaining(report); ok && pder.maxLifeTime > 0 {
+		if cnt, err := pder.gcDelete(
+			`expires_at IS NULL AND datetime(create_time, $1) <= `+pder.now(), limit,
+			secondsModifier(pder.maxLifeTime),
 		); err != nil {
-			//log error
-			if l != nil {
-				session.WriteToLog(l, fmt.Sprintf(LOG_PREF+"Exec() failed on DELETE FROM session_vals WHERE create_time: %v", err), session.LOG_LEVEL_ERROR)
-			}
+			report.Errors++
+			errs = append(errs, err)
+			log.Error(LOG_PREF+"gcDelete() failed on create_time", session.LOG_KEY_ERROR, err)
+		} else {
+			report.DeletedLifetime = cnt
+			log.Debug(LOG_PREF+"sessions with life time elapsed deleted", session.LOG_KEY_COUNT, cnt)
 		}
 	}
+	return report, errors.Join(errs...)
 }
 
-func (pder *Provider) DestroyAllSessions(l io.Writer, logLev session.LogLevel) {
-	if _, err := pder.dbConn.ExecContext(context.Background(), `DELETE FROM session_vals`); err != nil {
-		if l != nil {
-			session.WriteToLog(l, fmt.Sprintf(LOG_PREF+"Exec() failed on DELETE FROM session_vals: %v", err), session.LOG_LEVEL_ERROR)
+// gcRemaining returns max number of sessions the next GC query may remove, 0 if not limited.
+// ok is false if GC limit is reached.
+func (pder *Provider) gcRemaining(report session.GCReport) (limit int, ok bool) {
+	if pder.gcLimit <= 0 {
+		return 0, true
+	}
+	limit = pder.gcLimit - report.Deleted()
+	return limit, limit > 0
+}
+
+// gcDelete deletes sessions matching where condition, no more than limit sessions if limit > 0.
+func (pder *Provider) gcDelete(where string, limit int, args ...interface{}) (int, error) {
+	if limit > 0 {
+		return pder.deleteExpired(
+			fmt.Sprintf(`DELETE FROM session_vals WHERE id IN (SELECT id FROM session_vals WHERE %s LIMIT %d) RETURNING id`, where, limit),
+			args...,
+		)
+	}
+	return pder.deleteExpired(`DELETE FROM session_vals WHERE `+where+` RETURNING id`, args...)
+}
+
+// SetGCMaxDeletions implements session.GCLimiter.
+func (pder *Provider) SetGCMaxDeletions(maxDeletions int) {
+	pder.gcLimit = maxDeletions
+}
+
+// SetExpirationMode implements session.ExpirationModeSetter.
+func (pder *Provider) SetExpirationMode(mode session.ExpirationMode) {
+	pder.expMode = mode
+}
+
+// SetStrictMode implements session.StrictModeSetter: SessionRead() returns session.ErrSessionNotFound
+// for an unknown session instead of creating it.
+func (pder *Provider) SetStrictMode(strict bool) error {
+	pder.strict = strict
+	return nil
+}
+
+// accessedTime returns SQL expression for accessed_time column on session read or write,
+// column is not changed if expiration mode does not update access time.
+func (pder *Provider) accessedTime(read bool) string {
+	if read && pder.expMode.TouchOnRead() || !read && pder.expMode.TouchOnWrite() {
+		return pder.now()
+	}
+	return "accessed_time"
+}
+
+// now returns SQL expression for current time: datetime() for session.SystemClock,
+// time of the clock set with SetClock() otherwise.
+func (pder *Provider) now() string {
+	if _, ok := pder.clock.(session.SystemClock); ok {
+		return "datetime()"
+	}
+	return "datetime('" + pder.clock.Now().UTC().Format(time.DateTime) + "')"
+}
+
+// SetClock implements session.ClockSetter, session times and expiration checked on read and by GC
+// are of the clock. Nil restores session.SystemClock.
+func (pder *Provider) SetClock(clock session.Clock) {
+	if clock == nil {
+		clock = session.SystemClock{}
+	}
+	pder.clock = clock
+}
+
+// query returns query with configured database object names.
+func (pder *Provider) query(query string) string {
+	return pder.names.replace(query)
+}
+
+// secondsModifier returns sqlite datetime() modifier adding seconds, e.g. +3600 seconds.
+func secondsModifier(seconds int64) string {
+	return fmt.Sprintf("%+d seconds", seconds)
+}
+
+// deleteExpired runs DELETE ... RETURNING id query with args
+// and calls expired hook for every deleted session.
+// Number of deleted sessions is returned.
+func (pder *Provider) deleteExpired(query string, args ...interface{}) (int, error) {
+	rows, err := pder.db().QueryContext(context.Background(), pder.query(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	sids := make([]string, 0)
+	for rows.Next() {
+		var sid string
+		if err := rows.Scan(&sid); err != nil {
+			return 0, err
+		}
+		sids = append(sids, sid)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+	for _, sid := range sids {
+		if pder.writeQueue != nil {
+			pder.writeQueue.remove(sid)
+		}
+		pder.sessionExpired(sid)
+	}
+	return len(sids), nil
+}
+
+// SessionCount returns number of sessions in session_vals table.
+func (pder *Provider) SessionCount() (int, error) {
+	var cnt int
+	if err := pder.db().QueryRowContext(context.Background(),
+		pder.query(`SELECT count(*) FROM session_vals`),
+	).Scan(&cnt); err != nil {
+		return 0, err
+	}
+	return cnt, nil
+}
+
+// SessionList returns session metadata ordered by session ID.
+func (pder *Provider) SessionList(offset, limit int) ([]session.SessionMeta, error) {
+	if limit == 0 {
+		limit = -1 //no limit
+	}
+	rows, err := pder.db().QueryContext(context.Background(),
+		pder.query(`SELECT id, create_time, accessed_time
+		FROM session_vals
+		ORDER BY id
+		LIMIT $1 OFFSET $2`),
+		limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]session.SessionMeta, 0)
+	for rows.Next() {
+		var meta session.SessionMeta
+		if err := rows.Scan(&meta.ID, &meta.TimeCreated, &meta.TimeAccessed); err != nil {
+			return nil, err
 		}
+		list = append(list, meta)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// SessionStats implements session.StatsProvider: count and total size are SQL aggregates,
+// distributions are computed of a random sample, StorageBytes is the database size.
+// Payloads of write-behind queue are not counted.
+func (pder *Provider) SessionStats(ctx context.Context, sampleSize int) (session.SessionStats, error) {
+	var cnt int
+	var total int64
+	if err := pder.db().QueryRowContext(ctx,
+		pder.query(`SELECT count(*), coalesce(sum(length(val)), 0) FROM session_vals`),
+	).Scan(&cnt, &total); err != nil {
+		return session.SessionStats{}, err
+	}
+	rows, err := pder.db().QueryContext(ctx,
+		pder.query(`SELECT create_time, accessed_time, coalesce(length(val), 0)
+		FROM session_vals
+		ORDER BY random()
+		LIMIT $1`),
+		sampleSize,
+	)
+	if err != nil {
+		return session.SessionStats{}, err
+	}
+	defer rows.Close()
+	sample := make([]session.SessionMeta, 0)
+	for rows.Next() {
+		var meta session.SessionMeta
+		if err := rows.Scan(&meta.TimeCreated, &meta.TimeAccessed, &meta.Size); err != nil {
+			return session.SessionStats{}, err
+		}
+		sample = append(sample, meta)
+	}
+	if err := rows.Err(); err != nil {
+		return session.SessionStats{}, err
+	}
+	stats := session.SampleStats(cnt, sample, pder.clock.Now())
+	stats.Provider = PROVIDER
+	stats.TotalSize = total
+	if err := pder.db().QueryRowContext(ctx,
+		`SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()`,
+	).Scan(&stats.StorageBytes); err != nil {
+		return session.SessionStats{}, err
+	}
+	return stats, nil
+}
+
+// SessionMeta implements session.MetaProvider, session values are not decoded.
+// Size is a size of stored payload, including not yet written one of write-behind queue.
+func (pder *Provider) SessionMeta(sid string) (session.SessionMeta, error) {
+	meta := session.SessionMeta{ID: sid, Provider: PROVIDER}
+	var expires_at sql.NullTime
+	if err := pder.db().QueryRowContext(context.Background(),
+		pder.query(`SELECT create_time, accessed_time, expires_at, coalesce(length(val), 0)
+		FROM session_vals
+		WHERE id = $1`),
+		sid).Scan(&meta.TimeCreated, &meta.TimeAccessed, &expires_at, &meta.Size); err == sql.ErrNoRows {
+		return session.SessionMeta{}, session.ErrSessionNotFound
+
+	} else if err != nil {
+		return session.SessionMeta{}, err
+	}
+	meta.ExpiresAt = expires_at.Time
+	if pder.writeQueue != nil {
+		if pending_val, ok := pder.writeQueue.get(sid); ok {
+			meta.Size = len(pending_val)
+		}
+	}
+	return meta, nil
+}
+
+// SessionPeek implements session.PeekProvider: session values are read
+// without updating access time, missing session is not created, expired one is not removed.
+func (pder *Provider) SessionPeek(sid string) (session.Session, error) {
+	var val []byte
+	store := pder.NewSessionStore(sid)
+	var expires_at sql.NullTime
+	var accessed_time time.Time
+	if err := pder.db().QueryRowContext(context.Background(),
+		pder.query(`SELECT accessed_time, create_time, expires_at, val FROM session_vals WHERE id = $1`),
+		sid).Scan(&accessed_time,
+		&store.timeCreated,
+		&expires_at,
+		&val,
+	); err == sql.ErrNoRows {
+		return nil, session.ErrSessionNotFound
+
+	} else if err != nil {
+		return nil, err
+	}
+	if session.IsExpiredAt(pder.clock.Now(), store.timeCreated, accessed_time, expires_at.Time, pder.maxLifeTime, pder.maxIdleTime) {
+		return nil, session.ErrSessionExpired
+	}
+	store.timeAccessed.Store(accessed_time.UnixNano())
+
+	if pder.writeQueue != nil {
+		//not yet written value
+		if pending_val, ok := pder.writeQueue.get(sid); ok {
+			val = pending_val
+		}
+	}
+	if err := pder.setFromDb(&store.value, val); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// SessionDestroyMany destroys sessions in one transaction.
+func (pder *Provider) SessionDestroyMany(sids []string) error {
+	ctx := context.Background()
+	tx, err := pder.db().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, pder.query(`DELETE FROM session_vals WHERE id = $1`))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, sid := range sids {
+		if _, err := stmt.ExecContext(ctx, sid); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if pder.writeQueue != nil {
+		for _, sid := range sids {
+			pder.writeQueue.remove(sid)
+		}
+	}
+	return nil
+}
+
+func (pder *Provider) DestroyAllSessions(l io.Writer, logLev session.LogLevel) {
+	log := pder.getLogger(l, logLev).With(session.LOG_KEY_OPERATION, "DestroyAllSessions")
+	start := time.Now()
+	cnt, err := pder.DestroyAll()
+	if err != nil {
+		log.Error(LOG_PREF+"Exec() failed on DELETE FROM session_vals", session.LOG_KEY_ERROR, err)
+		return
+	}
+	log.Debug(LOG_PREF+"DestroyAllSessions() done", session.LOG_KEY_COUNT, cnt, session.LOG_KEY_DURATION, time.Since(start))
+}
+
+// DestroyAll implements session.ProviderV2, returns number of destroyed sessions.
+func (pder *Provider) DestroyAll() (int, error) {
+	if pder.writeQueue != nil {
+		pder.writeQueue.clear()
+	}
+	res, err := pder.db().ExecContext(context.Background(), pder.query(`DELETE FROM session_vals`))
+	if err != nil {
+		return 0, err
+	}
+	cnt, err := res.RowsAffected()
+	return int(cnt), err
+}
+
+// SetExpiredHook sets callback for sessions removed by SessionGC.
+func (pder *Provider) SetExpiredHook(hook session.SessionHook) {
+	pder.expiredHook = hook
+}
+
+// sessionExpired calls expired hook if it is set.
+func (pder *Provider) sessionExpired(sid string) {
+	if pder.expiredHook != nil {
+		pder.expiredHook(sid)
+	}
+}
+
+// SetLogger sets structured logger.
+func (pder *Provider) SetLogger(logger *slog.Logger) {
+	pder.logger = logger
+}
+
+// getLogger returns provider logger or io.Writer adapter if logger is not set.
+func (pder *Provider) getLogger(l io.Writer, logLev session.LogLevel) *slog.Logger {
+	return session.LoggerFor(pder.logger, l, logLev).With(session.LOG_KEY_PROVIDER, PROVIDER)
 }
 
 func (pder *Provider) SetMaxLifeTime(maxLifeTime int64) {
@@ -401,34 +1294,152 @@ func (pder *Provider) GetMaxIdleTime() int64 {
 	return pder.maxIdleTime
 }
 
+// SetKeyRing sets keys for payload encryption.
+func (pder *Provider) SetKeyRing(keyRing *session.KeyRing) {
+	pder.keyRing.Store(keyRing)
+}
+
+// SetCompression sets payload compression, nil disables it.
+func (pder *Provider) SetCompression(compression *session.Compression) {
+	pder.compression = compression
+}
+
+// SetPayloadVersion sets payload version, nil disables versions.
+func (pder *Provider) SetPayloadVersion(payloadVersion *session.PayloadVersion) {
+	pder.payloadVersion = payloadVersion
+}
+
 // InitProvider initializes postgresql provider.
-// Function expects one parameter: path to a database file.
+// Function expects a Config or parameters:
+//
+//	First parameter: path to a database file, MEMORY_PATH opens in-memory database with the schema.
+//	Second parameter (optional): encryptKey string, if set session payload is encrypted with AES-GCM.
+//	Third parameter (optional): write-behind interval in milliseconds (int), 0 disables write-behind.
+//
 // This function opens connection.
 func (pder *Provider) InitProvider(provParams []interface{}) error {
-	if len(provParams) < 1 {
-		return errors.New("InitProvider missing parameters: path to a database file")
+	cfg, err := configOf(provParams)
+	if err != nil {
+		return err
 	}
-	dbFileName, ok := provParams[0].(string)
-	if !ok {
-		return errors.New("InitProvider path to a database file must be a string")
+	if cfg.EncryptKey != "" {
+		key_ring, err := session.NewKeyRing([]byte(cfg.EncryptKey))
+		if err != nil {
+			return err
+		}
+		pder.keyRing.Store(key_ring)
 	}
 
-	conn, err := sql.Open(PROVIDER, dbFileName)
+	//previous queue is written to its connection
+	if err := pder.closeWriteQueue(); err != nil {
+		return err
+	}
+
+	conn, err := sql.Open(PROVIDER, cfg.dataSource())
 	if err != nil {
 		return fmt.Errorf("sql.Open failed: %v", err)
 	}
-	pder.dbConn = conn
+	cfg.applyPool(conn)
+	pder.conn.Store(conn)
+	pder.cfg = cfg
+	pder.names = newNameMap(cfg.Names)
+
+	if cfg.inMemory() {
+		//new in-memory database is empty
+		if err := pder.EnsureSchema(context.Background()); err != nil {
+			conn.Close()
+			pder.conn.Store(nil)
+			return err
+		}
+	}
+	pder.statFile()
+
+	if cfg.WriteBehind > 0 {
+		pder.writeQueue = newWriteQueue(pder, cfg.WriteBehind)
+	}
 
 	return nil
 }
 
-// CloseProvider closes all database connections.
-func (pder *Provider) CloseProvider() {
-	pder.dbConn.Close()
+// CloseProvider writes pending values and closes all database connections.
+func (pder *Provider) CloseProvider() error {
+	if pder.db() == nil {
+		return nil
+	}
+	queue_err := pder.closeWriteQueue()
+	if queue_err != nil {
+		pder.getLogger(nil, session.LOG_LEVEL_ERROR).Error(LOG_PREF+"closeWriteQueue() failed", session.LOG_KEY_ERROR, queue_err)
+	}
+	return errors.Join(queue_err, pder.conn.Swap(nil).Close())
+}
+
+// Ping checks database connection with SELECT 1,
+// ErrDatabaseMoved is returned if the database file is replaced or removed.
+func (pder *Provider) Ping(ctx context.Context) error {
+	if pder.db() == nil {
+		return session.ErrProviderNotInitialized
+	}
+	var one int
+	if err := pder.db().QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return err
+	}
+	return pder.checkFile()
+}
+
+// IsTransient implements session.TransientClassifier,
+// SQLITE_BUSY and SQLITE_LOCKED errors of concurrent writers are transient.
+func (pder *Provider) IsTransient(err error) bool {
+	var sqlite_err sqlite3.Error
+	if errors.As(err, &sqlite_err) && (sqlite_err.Code == sqlite3.ErrBusy || sqlite_err.Code == sqlite3.ErrLocked) {
+		return true
+	}
+	return session.IsTransient(err)
+}
+
+// SCHEMA_SQL creates database objects used by the provider, see EnsureSchema().
+//
+//go:embed schema.sql
+var SCHEMA_SQL string
+
+// EnsureSchema creates session_vals, session_locks and session_users tables with indexes if they do not exist.
+// Objects are named with Config.Names, schema (attached database) must exist.
+// Application specific triggers (e.g. updating login information) are not created.
+func (pder *Provider) EnsureSchema(ctx context.Context) error {
+	if pder.db() == nil {
+		return session.ErrProviderNotInitialized
+	}
+	_, err := pder.db().ExecContext(ctx, pder.names.schema(SCHEMA_SQL))
+	return err
+}
+
+// Drain writes all pending write-behind values to database.
+// Does nothing if write-behind is not used.
+func (pder *Provider) Drain() error {
+	if pder.writeQueue == nil {
+		return nil
+	}
+	return pder.writeQueue.writeBatch()
+}
+
+// Drain writes all pending write-behind values of the registered provider to database.
+func Drain() error {
+	return pder.Drain()
+}
+
+func (pder *Provider) closeWriteQueue() error {
+	if pder.writeQueue == nil {
+		return nil
+	}
+	q := pder.writeQueue
+	pder.writeQueue = nil
+	return q.close()
 }
 
 func (pder *Provider) removeSessionFromDb(sid string) error {
-	if _, err := pder.dbConn.ExecContext(context.Background(), `DELETE FROM session_vals WHERE id = $1`, sid); err != nil {
+	if pder.writeQueue != nil {
+		pder.writeQueue.remove(sid)
+	}
+	if _, err := pder.db().ExecContext(context.Background(), pder.query(`DELETE FROM session_vals WHERE id = $1`), sid); err != nil {
 		return err
 	}
 	return nil
@@ -438,29 +1449,53 @@ func (pder *Provider) GetSessionIDLen() int {
 	return SESS_ID_LEN
 }
 
+// genLockToken returns random lock owner token.
+func genLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 // setFromDb is a helper function, called on retrieving value from data base.
-// It decodes data base value for in-memory store.
-func setFromDb(strucVal *storeValue, dbVal []byte) error {
+// It decrypts, decompresses and decodes data base value for in-memory store.
+func (pder *Provider) setFromDb(strucVal *storeValue, dbVal []byte) error {
 	if len(dbVal) == 0 {
 		return nil
 	}
-	dec := gob.NewDecoder(bytes.NewBuffer(dbVal))
-	if err := dec.Decode(strucVal); err != nil {
+	var err error
+	if key_ring := pder.keyRing.Load(); key_ring != nil {
+		if dbVal, err = key_ring.DecryptPayload(dbVal); err != nil {
+			return err
+		}
+	}
+	if dbVal, err = session.Decompress(dbVal); err != nil {
+		return err
+	}
+	if err := pder.payloadVersion.Decode(dbVal, (*map[string]interface{})(strucVal)); err != nil {
 		return err
 	}
+	session.PurgeExpiredValuesAt(pder.clock.Now(), *strucVal) //values set with SetWithTTL() are filtered on read
 	return nil
 }
 
 // getForDb is a helper function called before putting value to database.
-// It encodes in-memory session value for data base.
-func getForDb(strucVal *storeValue) ([]byte, error) {
-	var b bytes.Buffer
-	enc := gob.NewEncoder(&b)
-	err := enc.Encode(strucVal)
+// It encodes, compresses and encrypts in-memory session value for data base.
+func (pder *Provider) getForDb(strucVal *storeValue) ([]byte, error) {
+	val, err := pder.payloadVersion.Encode(strucVal)
 	if err != nil {
 		return []byte{}, err
 	}
-	return b.Bytes(), nil
+	if pder.compression != nil {
+		if val, err = pder.compression.Compress(val); err != nil {
+			return nil, err
+		}
+	}
+	if key_ring := pder.keyRing.Load(); key_ring != nil {
+		return key_ring.Encrypt(val)
+	}
+	return val, nil
 }
 
 func init() {
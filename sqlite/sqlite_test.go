@@ -2,86 +2,32 @@
 package sqlite
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/dronm/session" //session manager
+	"github.com/dronm/session/bolt"
+	"github.com/dronm/session/testkit"
+	"github.com/mattn/go-sqlite3"
 )
 
 const (
 	SQLITE_FILENAME = "test.db"
 )
 
-// TestStruct custom struct for use in session.
-type TestStruct struct {
-	IntVal   int
-	FloatVal float32
-	StrVal   string
-}
-
-func NewTestStruct() TestStruct {
-	return TestStruct{IntVal: 375, FloatVal: 3.14, StrVal: "Some string value in struct"}
-}
-
-func NewTestValues() map[string]interface{} {
-	//Register custom struct for marshaling.
-	gob.Register(TestStruct{})
-	gob.Register(time.Time{})
-
-	return map[string]interface{}{
-		"stringVal":  "some string value",
-		"int32Val":   int32(2147483647),
-		"int64Val":   2147483647 * 2,
-		"float32Val": float32(3.14),
-		"float64Val": float64(3.14),
-		"dateVal":    time.Now().Truncate(time.Second),
-		"structVal":  NewTestStruct(),
-	}
-}
-
-func putValues(t *testing.T, currentSession session.Session, tests map[string]interface{}) {
-	//test writing
-	for key, val := range tests {
-		t.Logf("Setting key: %s to %v", key, val)
-		if err := currentSession.Set(key, val); err != nil {
-			t.Fatalf("Set() for string value failed: %v", err)
-		}
-	}
-	if err := currentSession.Flush(); err != nil {
-		t.Fatalf("Flush() failed: %v", err)
-	}
-}
-
-func compareValues(t *testing.T, currentSession session.Session, tests map[string]interface{}) {
-	for key, wanted := range tests {
-		t.Logf("Getting key: %s", key)
-
-		ptr := reflect.New(reflect.TypeOf(wanted))
-		err := currentSession.Get(key, ptr.Interface())
-		if err != nil {
-			t.Fatalf("Get() failed: %v", err)
-		}
-		got := ptr.Elem().Interface()
-		if !reflect.DeepEqual(got, wanted) {
-			t.Fatalf("Wanted: %v, got %v", wanted, got)
-		}
-	}
-}
-
-func assertNoValues(t *testing.T, currentSession session.Session, tests map[string]interface{}) {
-	for key, wanted := range tests {
-		ptr := reflect.New(reflect.TypeOf(wanted))
-		err := currentSession.Get(key, ptr.Interface())
-		if err == nil {
-			t.Fatalf("Session: %s is not destroyed", currentSession.SessionID())
-		}
-	}
-}
-
 func NewManager(t *testing.T, idleTime int64, lifeTime int64, killTime string) (*session.Manager, error) {
 	return session.NewManager(PROVIDER, idleTime, lifeTime, killTime, SQLITE_FILENAME)
 }
@@ -97,11 +43,24 @@ func InitTestDb() error {
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
 	sql := `CREATE TABLE IF NOT EXISTS session_vals
 	(id varchar(35) NOT NULL PRIMARY KEY,
-	accessed_time datetime DEFAULT CURRENT_DATETIME,
-	create_time datetime DEFAULT CURRENT_DATETIME,
-	val bytea
+	accessed_time datetime DEFAULT CURRENT_TIMESTAMP,
+	create_time datetime DEFAULT CURRENT_TIMESTAMP,
+	val bytea,
+	expires_at datetime,
+	rates bytea
+	);
+	CREATE TABLE IF NOT EXISTS session_locks
+	(id varchar(36) NOT NULL PRIMARY KEY,
+	token varchar(32),
+	lock_till integer
+	);
+	CREATE TABLE IF NOT EXISTS session_users
+	(id varchar(36) NOT NULL PRIMARY KEY,
+	user_id varchar(100) NOT NULL,
+	bound_at integer NOT NULL
 	);`
 	if _, err := conn.Exec(sql); err != nil {
 		return err
@@ -168,11 +127,11 @@ func TestSession(t *testing.T) {
 	sid := currentSession.SessionID()
 	t.Logf("SessionID: %s", sid)
 
-	tests := NewTestValues()
-	putValues(t, currentSession, tests)
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
 
 	//test reading
-	compareValues(t, currentSession, tests)
+	testkit.CompareValues(t, currentSession, tests)
 
 	t.Logf("Closing session: %s", sid)
 	if err := SessManager.SessionClose(sid); err != nil {
@@ -186,7 +145,7 @@ func TestSession(t *testing.T) {
 		t.Errorf("SessionStart() failed: %v", err)
 	}
 	//test reading
-	compareValues(t, currentSession, tests)
+	testkit.CompareValues(t, currentSession, tests)
 
 	if err := SessManager.SessionClose(currentSession.SessionID()); err != nil {
 		t.Errorf("SessionClose() failed: %v", err)
@@ -203,6 +162,2302 @@ func TestSession(t *testing.T) {
 		t.Errorf("SessionStart() failed: %v", err)
 	}
 	t.Logf("Trying to read from session")
-	assertNoValues(t, currentSession, tests)
+	testkit.AssertNoValues(t, currentSession, tests)
 	t.Logf("Session destroyed to read from session")
 }
+
+// TestEncryption puts values to an encrypted session, rotates keys and reads values back.
+// Reading with an unknown key must fail.
+func TestEncryption(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	defer SessManager.SetEncryptionKeys()
+
+	if err := SessManager.SetEncryptionKeys([]byte("old key")); err != nil {
+		t.Fatalf("SetEncryptionKeys() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+
+	t.Logf("Rotating keys")
+	if err := SessManager.SetEncryptionKeys([]byte("new key"), []byte("old key")); err != nil {
+		t.Fatalf("SetEncryptionKeys() failed: %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	testkit.CompareValues(t, currentSession, tests)
+
+	t.Logf("Reading with unknown key")
+	if err := SessManager.SetEncryptionKeys([]byte("new key")); err != nil {
+		t.Fatalf("SetEncryptionKeys() failed: %v", err)
+	}
+	if _, err := SessManager.SessionStart(sid); err == nil {
+		t.Fatalf("SessionStart() succeeded with unknown key")
+	}
+}
+
+// TestEncryptionEnabled enables encryption for a session written without it.
+func TestEncryptionEnabled(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	defer SessManager.SetEncryptionKeys()
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+
+	t.Logf("Reading plaintext session with encryption enabled")
+	if err := SessManager.SetEncryptionKeys([]byte("new key")); err != nil {
+		t.Fatalf("SetEncryptionKeys() failed: %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	testkit.CompareValues(t, currentSession, tests)
+
+	t.Logf("Writing encrypted session")
+	if err := currentSession.Set("encrypted", true); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := currentSession.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if err := SessManager.SetEncryptionKeys([]byte("other key")); err != nil {
+		t.Fatalf("SetEncryptionKeys() failed: %v", err)
+	}
+	if _, err := SessManager.SessionStart(sid); err == nil {
+		t.Fatalf("SessionStart() succeeded with unknown key")
+	}
+}
+
+// TestLock locks a session, starts the same session in a goroutine which must wait for the lock.
+// The waiting session must see the value flushed by the first one.
+func TestLock(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStartLocked("")
+	if err != nil {
+		t.Fatalf("SessionStartLocked() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	done := make(chan int64)
+	go func() {
+		sess, err := SessManager.SessionStartLocked(sid)
+		if err != nil {
+			t.Errorf("SessionStartLocked() failed: %v", err)
+			close(done)
+			return
+		}
+		got := sess.GetInt("counter")
+		if err := sess.Unlock(); err != nil {
+			t.Errorf("Unlock() failed: %v", err)
+		}
+		done <- got
+	}()
+
+	time.Sleep(time.Duration(200) * time.Millisecond)
+	if err := currentSession.Put("counter", int64(1)); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := currentSession.Unlock(); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+
+	if got := <-done; got != 1 {
+		t.Fatalf("Wanted: 1, got %v", got)
+	}
+}
+
+// TestLogger checks that provider logs via io.Writer adapter and via slog.Logger set on the manager.
+func TestLogger(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	var buf bytes.Buffer
+	SessManager.DestroyAllSessions(&buf, session.LOG_LEVEL_DEBUG)
+	if !strings.Contains(buf.String(), "provider="+PROVIDER) {
+		t.Fatalf("io.Writer log does not contain provider: %s", buf.String())
+	}
+
+	buf.Reset()
+	SessManager.SetLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer SessManager.SetLogger(nil)
+	SessManager.DestroyAllSessions(nil, session.LOG_LEVEL_ERROR)
+	if !strings.Contains(buf.String(), `"operation":"DestroyAllSessions"`) {
+		t.Fatalf("slog log does not contain operation: %s", buf.String())
+	}
+}
+
+// TestGetStruct stores a struct and an int, reads them back with GetStruct() and GetAs()
+// into different but compatible types.
+func TestGetStruct(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if err := session.SetAs(currentSession, "structVal", testkit.NewTestStruct()); err != nil {
+		t.Fatalf("SetAs() failed: %v", err)
+	}
+	if err := currentSession.Set("intVal", 125); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+
+	//struct with a subset of fields
+	var got struct{ StrVal string }
+	if err := currentSession.GetStruct("structVal", &got); err != nil {
+		t.Fatalf("GetStruct() failed: %v", err)
+	}
+	if got.StrVal != testkit.NewTestStruct().StrVal {
+		t.Fatalf("Wanted: %v, got %v", testkit.NewTestStruct().StrVal, got.StrVal)
+	}
+
+	got_struct, err := session.GetAs[testkit.TestStruct](currentSession, "structVal")
+	if err != nil {
+		t.Fatalf("GetAs() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got_struct, testkit.NewTestStruct()) {
+		t.Fatalf("Wanted: %v, got %v", testkit.NewTestStruct(), got_struct)
+	}
+
+	got_int, err := session.GetAs[int64](currentSession, "intVal")
+	if err != nil {
+		t.Fatalf("GetAs() failed: %v", err)
+	}
+	if got_int != 125 {
+		t.Fatalf("Wanted: 125, got %v", got_int)
+	}
+
+	if _, err := session.GetAs[string](currentSession, "intVal"); !errors.Is(err, session.ETypeMismatch) {
+		t.Fatalf("Wanted: %v, got %v", session.ETypeMismatch, err)
+	}
+}
+
+// TestKeys puts values to a session and checks Keys() and Len() results.
+func TestKeys(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+
+	wanted := make([]string, 0, len(tests))
+	for key := range tests {
+		wanted = append(wanted, key)
+	}
+	sort.Strings(wanted)
+
+	got, err := currentSession.Keys()
+	if err != nil {
+		t.Fatalf("Keys() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Fatalf("Wanted: %v, got %v", wanted, got)
+	}
+
+	cnt, err := currentSession.Len()
+	if err != nil {
+		t.Fatalf("Len() failed: %v", err)
+	}
+	if cnt != len(tests) {
+		t.Fatalf("Wanted: %d, got %d", len(tests), cnt)
+	}
+}
+
+// TestClear puts values to a session, clears it and checks that session ID is kept and values are gone.
+func TestClear(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+
+	if err := currentSession.Clear(); err != nil {
+		t.Fatalf("Clear() failed: %v", err)
+	}
+	if err := currentSession.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if currentSession.SessionID() != sid {
+		t.Fatalf("Wanted: %s, got %s", sid, currentSession.SessionID())
+	}
+	testkit.AssertNoValues(t, currentSession, tests)
+}
+
+// TestIncrement increments a counter from several goroutines with separate session instances.
+// No increment may be lost.
+func TestIncrement(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	const workers, incs = 5, 10
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sess, err := SessManager.SessionStart(sid)
+			if err != nil {
+				t.Errorf("SessionStart() failed: %v", err)
+				return
+			}
+			for j := 0; j < incs; j++ {
+				if _, err := sess.Increment("counter", 2); err != nil {
+					t.Errorf("Increment() failed: %v", err)
+				}
+				if _, err := sess.Decrement("counter", 1); err != nil {
+					t.Errorf("Decrement() failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := currentSession.Increment("counter", 0)
+	if err != nil {
+		t.Fatalf("Increment() failed: %v", err)
+	}
+	if got != workers*incs {
+		t.Fatalf("Wanted: %d, got %d", workers*incs, got)
+	}
+}
+
+// TestWriteBehind flushes values in write-behind mode, reads them back before they are written,
+// then drains the queue and checks values in database.
+func TestWriteBehind(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+
+	SessManager, err := session.NewManager(PROVIDER, 0, 0, "", SQLITE_FILENAME, "", 60000)
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+
+	conn, err := sql.Open("sqlite3", SQLITE_FILENAME)
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %v", err)
+	}
+	defer conn.Close()
+	var val []byte
+	if err := conn.QueryRow(`SELECT val FROM session_vals WHERE id = $1`, sid).Scan(&val); err != nil {
+		t.Fatalf("QueryRow() failed: %v", err)
+	}
+	if len(val) != 0 {
+		t.Fatalf("value is written before interval elapsed")
+	}
+
+	//pending value is returned
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	testkit.CompareValues(t, currentSession, tests)
+
+	if err := Drain(); err != nil {
+		t.Fatalf("Drain() failed: %v", err)
+	}
+	if err := conn.QueryRow(`SELECT val FROM session_vals WHERE id = $1`, sid).Scan(&val); err != nil {
+		t.Fatalf("QueryRow() failed: %v", err)
+	}
+	if len(val) == 0 {
+		t.Fatalf("value is not written after Drain()")
+	}
+}
+
+// TestListSessions creates sessions, counts and lists them page by page.
+func TestListSessions(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	sids := make([]string, 3)
+	for i := range sids {
+		currentSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		sids[i] = currentSession.SessionID()
+	}
+	sort.Strings(sids)
+
+	cnt, err := SessManager.Count()
+	if err != nil {
+		t.Fatalf("Count() failed: %v", err)
+	}
+	if cnt != len(sids) {
+		t.Fatalf("Count() wanted %d, got %d", len(sids), cnt)
+	}
+
+	got := make([]string, 0)
+	for _, page := range [][2]int{{0, 2}, {2, 2}, {4, 0}} {
+		list, err := SessManager.ListSessions(page[0], page[1])
+		if err != nil {
+			t.Fatalf("ListSessions() failed: %v", err)
+		}
+		for _, meta := range list {
+			got = append(got, meta.ID)
+		}
+	}
+	if !reflect.DeepEqual(got, sids) {
+		t.Fatalf("ListSessions() wanted %v, got %v", sids, got)
+	}
+}
+
+// TestDestroySessionsWhere destroys sessions matching a filter and checks that others are kept.
+func TestDestroySessionsWhere(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	destroy := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		currentSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		destroy[currentSession.SessionID()] = i%2 == 0
+	}
+
+	destroyed := 0
+	SessManager.OnSessionDestroyed(func(sid string) {
+		destroyed++
+	})
+	if err := SessManager.DestroySessionsWhere(func(meta session.SessionMeta) bool {
+		return destroy[meta.ID]
+	}); err != nil {
+		t.Fatalf("DestroySessionsWhere() failed: %v", err)
+	}
+	if destroyed != 2 {
+		t.Fatalf("OnSessionDestroyed wanted 2 calls, got %d", destroyed)
+	}
+
+	list, err := SessManager.ListSessions(0, 0)
+	if err != nil {
+		t.Fatalf("ListSessions() failed: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("ListSessions() wanted 2 sessions, got %d", len(list))
+	}
+	for _, meta := range list {
+		if destroy[meta.ID] {
+			t.Fatalf("session %s is not destroyed", meta.ID)
+		}
+	}
+}
+
+// TestExpiry checks that GC removes a session after its own expiration time only.
+func TestExpiry(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	shortSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if err := shortSession.SetExpiry(time.Second); err != nil {
+		t.Fatalf("SetExpiry() failed: %v", err)
+	}
+	longSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if err := longSession.SetExpiry(time.Hour); err != nil {
+		t.Fatalf("SetExpiry() failed: %v", err)
+	}
+	if err := longSession.Touch(); err != nil {
+		t.Fatalf("Touch() failed: %v", err)
+	}
+
+	time.Sleep(2 * time.Second)
+	SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG)
+	list, err := SessManager.ListSessions(0, 0)
+	if err != nil {
+		t.Fatalf("ListSessions() failed: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != longSession.SessionID() {
+		t.Fatalf("ListSessions() wanted only %s, got %v", longSession.SessionID(), list)
+	}
+}
+
+// TestSessionSharing starts the same session concurrently with session sharing enabled,
+// all starts must return one store loaded once, values set by all users must be kept.
+func TestSessionSharing(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	SessManager.SetSessionSharing(true)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := SessManager.SessionClose(sid); err != nil {
+		t.Fatalf("SessionClose() failed: %v", err)
+	}
+
+	metrics, err := SessManager.EnableMetrics("")
+	if err != nil {
+		t.Fatalf("EnableMetrics() failed: %v", err)
+	}
+
+	const users = 10
+	sessions := make([]session.Session, users)
+	var wg sync.WaitGroup
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sess, err := SessManager.SessionStart(sid)
+			if err != nil {
+				t.Errorf("SessionStart() failed: %v", err)
+				return
+			}
+			sessions[i] = sess
+		}(i)
+	}
+	wg.Wait()
+
+	if reads := metrics.Get(session.METRIC_READS); reads != 1 {
+		t.Fatalf("provider reads wanted 1, got %d", reads)
+	}
+	for i, sess := range sessions {
+		if sess != sessions[0] {
+			t.Fatalf("session %d is not shared", i)
+		}
+		if err := sess.Set("key"+string(rune('0'+i)), i); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+	}
+	if err := sessions[0].Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	for i := 0; i < users; i++ {
+		if err := SessManager.SessionClose(sid); err != nil {
+			t.Fatalf("SessionClose() failed: %v", err)
+		}
+	}
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if currentSession == sessions[0] {
+		t.Fatalf("released session is still shared")
+	}
+	if n, _ := currentSession.Len(); n != users {
+		t.Fatalf("Len() wanted %d, got %d", users, n)
+	}
+}
+
+// TestExpiredOnRead checks that an idle session not removed by GC
+// is destroyed on read with ErrSessionExpired.
+func TestExpiredOnRead(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	var idle_time int64 = 1
+	SessManager, err := NewManager(t, 0, idle_time, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Put("key", "value"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	time.Sleep(time.Duration(idle_time+1) * time.Second)
+	if _, err := SessManager.SessionStart(sid); !errors.Is(err, session.ErrSessionExpired) {
+		t.Fatalf("SessionStart() wanted ErrSessionExpired, got %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if n, _ := currentSession.Len(); n != 0 {
+		t.Fatalf("Session: %s is not destroyed", sid)
+	}
+}
+
+// TestCompareAndSwap checks that a value is swapped only if it matches,
+// missing value is matched by nil and nil new value deletes it.
+func TestCompareAndSwap(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	if ok, err := currentSession.CompareAndSwap("state", "new", "paid"); err != nil || ok {
+		t.Fatalf("CompareAndSwap() of missing value wanted false, got %v (%v)", ok, err)
+	}
+	if ok, err := currentSession.CompareAndSwap("state", nil, "new"); err != nil || !ok {
+		t.Fatalf("CompareAndSwap() with nil old value wanted true, got %v (%v)", ok, err)
+	}
+	if ok, err := currentSession.CompareAndSwap("state", "paid", "shipped"); err != nil || ok {
+		t.Fatalf("CompareAndSwap() of other value wanted false, got %v (%v)", ok, err)
+	}
+	if ok, err := currentSession.CompareAndSwap("state", "new", "paid"); err != nil || !ok {
+		t.Fatalf("CompareAndSwap() wanted true, got %v (%v)", ok, err)
+	}
+	if v := currentSession.GetString("state"); v != "paid" {
+		t.Fatalf("GetString() wanted paid, got %s", v)
+	}
+	SessManager.SessionClose(sid)
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if v := currentSession.GetString("state"); v != "paid" {
+		t.Fatalf("GetString() after reading wanted paid, got %s", v)
+	}
+	if ok, err := currentSession.CompareAndSwap("state", "paid", nil); err != nil || !ok {
+		t.Fatalf("CompareAndSwap() with nil new value wanted true, got %v (%v)", ok, err)
+	}
+	if n, _ := currentSession.Len(); n != 0 {
+		t.Fatalf("value is not deleted")
+	}
+}
+
+// TestHealthCheck pings database before and after the provider is closed.
+func TestHealthCheck(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	if err := SessManager.HealthCheck(context.Background()); err != nil {
+		t.Fatalf("HealthCheck() failed: %v", err)
+	}
+	ClearManager(SessManager)
+	if err := SessManager.HealthCheck(context.Background()); err == nil {
+		t.Fatalf("HealthCheck() of closed provider succeeded")
+	}
+}
+
+// TestClose closes manager in write-behind mode and checks that pending values are written.
+func TestClose(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	defer os.Remove(SQLITE_FILENAME)
+
+	SessManager, err := session.NewManager(PROVIDER, 0, 0, "", SQLITE_FILENAME, "", 60000)
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	SessManager.StartGC(io.Discard, session.LOG_LEVEL_ERROR)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	testkit.PutValues(t, currentSession, testkit.NewTestValues())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := SessManager.Close(ctx); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+
+	conn, err := sql.Open("sqlite3", SQLITE_FILENAME)
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %v", err)
+	}
+	defer conn.Close()
+	var val []byte
+	if err := conn.QueryRow(`SELECT val FROM session_vals WHERE id = $1`, sid).Scan(&val); err != nil {
+		t.Fatalf("QueryRow() failed: %v", err)
+	}
+	if len(val) == 0 {
+		t.Fatalf("value is not written on Close()")
+	}
+}
+
+// TestAutoFlush sets values without Flush() and checks that they are written by auto flush.
+func TestAutoFlush(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	SessManager.SetAutoFlush(100 * time.Millisecond)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Set("key", "value"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	readSession, err := SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if got := readSession.GetString("key"); got != "value" {
+		t.Fatalf("value is not written by auto flush, got %q", got)
+	}
+}
+
+// TestMigrate copies sessions from sqlite to bolt provider.
+func TestMigrate(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	const BOLT_FILENAME = "test_migrate.bolt"
+	DstManager, err := session.NewManager(bolt.PROVIDER, 0, 0, "", BOLT_FILENAME)
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer os.Remove(BOLT_FILENAME)
+	defer DstManager.CloseProvider()
+
+	tests := testkit.NewTestValues()
+	sids := make([]string, 3)
+	for i := range sids {
+		currentSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		testkit.PutValues(t, currentSession, tests)
+		sids[i] = currentSession.SessionID()
+	}
+
+	src, _ := session.LookupProvider(PROVIDER)
+	dst, _ := session.LookupProvider(bolt.PROVIDER)
+	res, err := session.Migrate(src, dst, session.MigrateOptions{PageSize: 2, DestroySource: true})
+	if err != nil {
+		t.Fatalf("Migrate() failed: %v", err)
+	}
+	if res.Migrated != len(sids) {
+		t.Fatalf("Migrate() wanted %d sessions, got %+v", len(sids), res)
+	}
+	for _, sid := range sids {
+		currentSession, err := DstManager.SessionStart(sid)
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		testkit.CompareValues(t, currentSession, tests)
+	}
+	if n, _ := SessManager.Count(); n != 0 {
+		t.Fatalf("source sessions are not destroyed, count: %d", n)
+	}
+}
+
+// TestEnsureSchema creates database objects in an empty database and uses them.
+func TestEnsureSchema(t *testing.T) {
+	os.Remove(SQLITE_FILENAME)
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	for i := 0; i < 2; i++ {
+		if err := SessManager.EnsureSchema(context.Background()); err != nil {
+			t.Fatalf("EnsureSchema() failed: %v", err)
+		}
+	}
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+	if err := currentSession.Lock(); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	if err := currentSession.Unlock(); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+
+	currentSession, err = SessManager.SessionStart(currentSession.SessionID())
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	testkit.CompareValues(t, currentSession, tests)
+}
+
+// TestGCTime checks that GC removes only sessions idle or living longer than allowed.
+func TestGCTime(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 3600, 3600, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	sids := make([]string, 3)
+	for i := range sids {
+		currentSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		sids[i] = currentSession.SessionID()
+	}
+
+	conn, err := sql.Open("sqlite3", SQLITE_FILENAME)
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %v", err)
+	}
+	defer conn.Close()
+	//idle for 2 hours
+	if _, err := conn.Exec(`UPDATE session_vals SET accessed_time = datetime('now', '-2 hours') WHERE id = $1`, sids[0]); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	//created 2 hours ago, accessed now
+	if _, err := conn.Exec(`UPDATE session_vals SET create_time = datetime('now', '-2 hours') WHERE id = $1`, sids[1]); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+
+	SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG)
+	list, err := SessManager.ListSessions(0, 0)
+	if err != nil {
+		t.Fatalf("ListSessions() failed: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != sids[2] {
+		t.Fatalf("ListSessions() wanted only %s, got %v", sids[2], list)
+	}
+}
+
+// TestGCInterval starts GC with no max life and idle time,
+// a session with its own expiration time must be removed by GC running every SetGCInterval().
+func TestGCInterval(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if err := currentSession.SetExpiry(time.Second); err != nil {
+		t.Fatalf("SetExpiry() failed: %v", err)
+	}
+	SessManager.SetGCInterval(500 * time.Millisecond)
+	SessManager.StartGC(os.Stderr, session.LOG_LEVEL_ERROR)
+	defer SessManager.StopGC()
+
+	time.Sleep(2500 * time.Millisecond)
+	list, err := SessManager.ListSessions(0, 0)
+	if err != nil {
+		t.Fatalf("ListSessions() failed: %v", err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("ListSessions() wanted no sessions, got %v", list)
+	}
+}
+
+// TestGCReport checks GC report counters and OnGC() hook called by StartGC().
+func TestGCReport(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 3600, 3600, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	sids := make([]string, 4)
+	for i := range sids {
+		currentSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		sids[i] = currentSession.SessionID()
+		if i == 2 {
+			if err := currentSession.SetExpiry(time.Second); err != nil {
+				t.Fatalf("SetExpiry() failed: %v", err)
+			}
+		}
+	}
+	conn, err := sql.Open("sqlite3", SQLITE_FILENAME)
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec(`UPDATE session_vals SET accessed_time = datetime('now', '-2 hours') WHERE id = $1`, sids[0]); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	if _, err := conn.Exec(`UPDATE session_vals SET create_time = datetime('now', '-2 hours') WHERE id = $1`, sids[1]); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	time.Sleep(2 * time.Second)
+
+	reports := make(chan session.GCReport, 1)
+	SessManager.OnGC(func(report session.GCReport) {
+		select {
+		case reports <- report:
+		default:
+		}
+	})
+	SessManager.SetGCInterval(100 * time.Millisecond)
+	SessManager.StartGC(os.Stderr, session.LOG_LEVEL_ERROR)
+	defer SessManager.StopGC()
+
+	select {
+	case report := <-reports:
+		want := session.GCReport{DeletedIdle: 1, DeletedLifetime: 1, DeletedExpiry: 1, Duration: report.Duration}
+		if report != want {
+			t.Errorf("GC report wanted %+v, got %+v", want, report)
+		}
+		if report.Deleted() != 3 {
+			t.Errorf("Deleted() wanted 3, got %d", report.Deleted())
+		}
+		if report.Duration == 0 {
+			t.Error("GC report duration is not set")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnGC() hook is not called")
+	}
+}
+
+// TestGCMaxDeletions removes idle sessions with GC limited to 2 sessions per run.
+func TestGCMaxDeletions(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 3600, 3600, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	if err := SessManager.SetGCMaxDeletions(2); err != nil {
+		t.Fatalf("SetGCMaxDeletions() failed: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := SessManager.SessionStart(""); err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+	}
+	conn, err := sql.Open("sqlite3", SQLITE_FILENAME)
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec(`UPDATE session_vals SET accessed_time = datetime('now', '-2 hours')`); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+
+	for _, want := range []int{2, 2, 1, 0} {
+		if report := SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_ERROR); report.Deleted() != want {
+			t.Fatalf("SessionGC() wanted %d sessions deleted, got %d", want, report.Deleted())
+		}
+	}
+}
+
+// TestCollectGarbage removes idle sessions and destroys the rest with ProviderV2 methods.
+func TestCollectGarbage(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 3600, 3600, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	sids := make([]string, 3)
+	for i := range sids {
+		currentSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		sids[i] = currentSession.SessionID()
+	}
+	conn, err := sql.Open("sqlite3", SQLITE_FILENAME)
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec(`UPDATE session_vals SET accessed_time = datetime('now', '-2 hours') WHERE id = $1`, sids[0]); err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+
+	if deleted, err := SessManager.CollectGarbage(); err != nil || deleted != 1 {
+		t.Fatalf("CollectGarbage() wanted 1 session deleted, got %d, %v", deleted, err)
+	}
+	if destroyed, err := SessManager.DestroyAll(); err != nil || destroyed != 2 {
+		t.Fatalf("DestroyAll() wanted 2 sessions destroyed, got %d, %v", destroyed, err)
+	}
+	if cnt, err := SessManager.Count(); err != nil || cnt != 0 {
+		t.Fatalf("Count() wanted 0 sessions, got %d, %v", cnt, err)
+	}
+}
+
+// TestKillSchedule checks kill times set with lists of times and cron expressions.
+func TestKillSchedule(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	monday := time.Date(2024, 1, 1, 10, 7, 0, 0, time.Local)
+	tests := []struct {
+		killTime string
+		after    time.Time
+		want     time.Time
+	}{
+		{"03:00", monday, time.Date(2024, 1, 2, 3, 0, 0, 0, time.Local)},
+		{"03:00, 15:00:30", monday, time.Date(2024, 1, 1, 15, 0, 30, 0, time.Local)},
+		{"03:00,15:00", monday.Add(6 * time.Hour), time.Date(2024, 1, 2, 3, 0, 0, 0, time.Local)},
+		{"0 3 * * SUN", monday, time.Date(2024, 1, 7, 3, 0, 0, 0, time.Local)},
+		{"*/15 * * * *", monday, time.Date(2024, 1, 1, 10, 15, 0, 0, time.Local)},
+		{"0 0 1 JAN *", monday, time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)},
+		{"0 12 13 * FRI", monday, time.Date(2024, 1, 5, 12, 0, 0, 0, time.Local)},
+		{"30 2 * * 1-5", monday, time.Date(2024, 1, 2, 2, 30, 0, 0, time.Local)},
+		{"0 0 30 2 *", monday, time.Time{}},
+	}
+	for _, tt := range tests {
+		if err := SessManager.SetSessionsKillTime(tt.killTime); err != nil {
+			t.Fatalf("SetSessionsKillTime(%q) failed: %v", tt.killTime, err)
+		}
+		if got := SessManager.NextKillTime(tt.after); !got.Equal(tt.want) {
+			t.Errorf("NextKillTime() for %q wanted %v, got %v", tt.killTime, tt.want, got)
+		}
+	}
+
+	for _, killTime := range []string{"25:00", "03:00,", "61 * * * *", "0 3 * * MON-", "0 3 * * * *", "*/0 * * * *"} {
+		if err := SessManager.SetSessionsKillTime(killTime); err == nil {
+			t.Errorf("SetSessionsKillTime(%q) wanted error", killTime)
+		}
+	}
+}
+
+// TestNonce issues and consumes one-time tokens.
+func TestNonce(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+
+	token, err := session.IssueNonce(currentSession, "form", time.Minute)
+	if err != nil {
+		t.Fatalf("IssueNonce() failed: %v", err)
+	}
+	if ok, err := session.ConsumeNonce(currentSession, "download", token); err != nil || ok {
+		t.Errorf("ConsumeNonce() of other scope wanted false, got %v, %v", ok, err)
+	}
+	if ok, err := session.ConsumeNonce(currentSession, "form", "not a token"); err != nil || ok {
+		t.Errorf("ConsumeNonce() of invalid token wanted false, got %v, %v", ok, err)
+	}
+	if ok, err := session.ConsumeNonce(currentSession, "form", token); err != nil || !ok {
+		t.Fatalf("ConsumeNonce() wanted true, got %v, %v", ok, err)
+	}
+	if ok, err := session.ConsumeNonce(currentSession, "form", token); err != nil || ok {
+		t.Errorf("ConsumeNonce() of consumed token wanted false, got %v, %v", ok, err)
+	}
+
+	expired, err := session.IssueNonce(currentSession, "form", time.Millisecond)
+	if err != nil {
+		t.Fatalf("IssueNonce() failed: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if ok, err := session.ConsumeNonce(currentSession, "form", expired); err != nil || ok {
+		t.Errorf("ConsumeNonce() of expired token wanted false, got %v, %v", ok, err)
+	}
+
+	//expired nonces are purged on issue
+	if _, err := session.IssueNonce(currentSession, "form", time.Millisecond); err != nil {
+		t.Fatalf("IssueNonce() failed: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := session.IssueNonce(currentSession, "form", time.Minute); err != nil {
+		t.Fatalf("IssueNonce() failed: %v", err)
+	}
+	if n, _ := currentSession.Len(); n != 1 {
+		t.Errorf("Len() wanted 1 nonce, got %d", n)
+	}
+}
+
+// TestKeepAlive keeps a session alive longer than max idle time.
+func TestKeepAlive(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	var idle_time int64 = 2
+	SessManager, err := NewManager(t, 0, idle_time, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- session.KeepAlive(ctx, currentSession, 500*time.Millisecond)
+	}()
+	time.Sleep(time.Duration(idle_time+1) * time.Second)
+	SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_ERROR)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("KeepAlive() failed: %v", err)
+	}
+	if _, err := SessManager.SessionStart(sid); err != nil {
+		t.Fatalf("SessionStart() of kept alive session failed: %v", err)
+	}
+}
+
+// TestExpirationMode checks that reads do not reset idle time
+// in fixed and explicit modes, and Touch() resets it in explicit mode only.
+func TestExpirationMode(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	var idle_time int64 = 4 //accessed_time has seconds precision
+	SessManager, err := NewManager(t, 0, idle_time, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	defer SessManager.SetExpirationMode(session.EXPIRATION_SLIDING)
+
+	tests := []struct {
+		mode  session.ExpirationMode
+		touch bool
+		alive bool
+	}{
+		{session.EXPIRATION_SLIDING, false, true},
+		{session.EXPIRATION_FIXED, true, false},
+		{session.EXPIRATION_EXPLICIT, false, false},
+		{session.EXPIRATION_EXPLICIT, true, true},
+	}
+	for _, tt := range tests {
+		if err := SessManager.SetExpirationMode(tt.mode); err != nil {
+			t.Fatalf("SetExpirationMode() failed: %v", err)
+		}
+		currentSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		sid := currentSession.SessionID()
+		if err := currentSession.Put("val", int64(1)); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+		time.Sleep(2500 * time.Millisecond)
+
+		currentSession, err = SessManager.SessionStart(sid)
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		if currentSession.GetInt("val") != 1 {
+			t.Fatalf("mode %d: value lost before idle time", tt.mode)
+		}
+		if tt.touch {
+			if err := currentSession.Touch(); err != nil {
+				t.Fatalf("Touch() failed: %v", err)
+			}
+		}
+		time.Sleep(2500 * time.Millisecond)
+		SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_ERROR)
+
+		currentSession, err = SessManager.SessionStart(sid)
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		if alive := currentSession.GetInt("val") == 1; alive != tt.alive {
+			t.Fatalf("mode %d, touch %v: expected alive %v, got %v", tt.mode, tt.touch, tt.alive, alive)
+		}
+		SessManager.SessionDestroy(sid)
+	}
+}
+
+// TestSnapshot checks that Restore() reverts values modified after Snapshot().
+func TestSnapshot(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.SetMany(map[string]interface{}{"a": 1, "b": []string{"x", "y"}}); err != nil {
+		t.Fatalf("SetMany() failed: %v", err)
+	}
+	snapshot, err := currentSession.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() failed: %v", err)
+	}
+
+	currentSession.Set("a", 2)
+	currentSession.Set("c", true)
+	currentSession.Delete("b")
+	if got := snapshot["a"]; got != 1 {
+		t.Fatalf("snapshot value changed: %v", got)
+	}
+	if err := currentSession.Restore(snapshot); err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+	if err := currentSession.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	SessManager.SessionClose(sid)
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if got := currentSession.GetInt("a"); got != 1 {
+		t.Fatalf("expected restored a=1, got %d", got)
+	}
+	if got := currentSession.GetStringSlice("b"); len(got) != 2 || got[1] != "y" {
+		t.Fatalf("expected restored b, got %v", got)
+	}
+	if keys, _ := currentSession.Keys(); len(keys) != 2 {
+		t.Fatalf("expected 2 keys after Restore(), got %v", keys)
+	}
+}
+
+// TestSetWithTTL checks that a value set with SetWithTTL() expires before the session.
+func TestSetWithTTL(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	clock := session.NewManualClock(time.Now())
+	SessManager.SetClock(clock)
+	defer SessManager.SetClock(nil)
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.SetWithTTL("otp", "1234", time.Second); err != nil {
+		t.Fatalf("SetWithTTL() failed: %v", err)
+	}
+	currentSession.Set("user", "john")
+	if err := currentSession.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if got := currentSession.GetString("otp"); got != "1234" {
+		t.Fatalf("expected otp value before TTL, got %q", got)
+	}
+	clock.Advance(1200 * time.Millisecond)
+
+	if got := currentSession.GetString("otp"); got != "" {
+		t.Errorf("expected expired otp value, got %q", got)
+	}
+	if n, _ := currentSession.Len(); n != 1 {
+		t.Errorf("expected 1 value after TTL, got %d", n)
+	}
+	SessManager.SessionClose(sid)
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if keys, _ := currentSession.Keys(); len(keys) != 1 || keys[0] != "user" {
+		t.Errorf("expected only user value after read, got %v", keys)
+	}
+}
+
+// TestExportTTL checks that snapshot leaves out expired values and
+// values set with SetWithTTL() keep their expiration through export and import.
+func TestExportTTL(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.SetWithTTL("otp", "1234", time.Hour); err != nil {
+		t.Fatalf("SetWithTTL() failed: %v", err)
+	}
+	if err := currentSession.SetWithTTL("gone", "x", time.Nanosecond); err != nil {
+		t.Fatalf("SetWithTTL() failed: %v", err)
+	}
+	if err := currentSession.Put("user", "john"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	snapshot, err := currentSession.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() failed: %v", err)
+	}
+	if _, ok := snapshot["gone"]; ok {
+		t.Fatalf("Snapshot() contains expired value")
+	}
+	otp, ok := snapshot["otp"].(session.ExpiringValue)
+	if !ok {
+		t.Fatalf("Snapshot() otp wanted session.ExpiringValue, got %T", snapshot["otp"])
+	}
+	SessManager.SessionClose(sid)
+
+	var dump bytes.Buffer
+	if n, err := SessManager.ExportSessions(&dump); err != nil || n != 1 {
+		t.Fatalf("ExportSessions() wanted 1 session, got %d, %v", n, err)
+	}
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+	if n, err := SessManager.ImportSessions(&dump); err != nil || n != 1 {
+		t.Fatalf("ImportSessions() wanted 1 session, got %d, %v", n, err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if snapshot, err = currentSession.Snapshot(); err != nil {
+		t.Fatalf("Snapshot() failed: %v", err)
+	}
+	if got, ok := snapshot["otp"].(session.ExpiringValue); !ok || got != otp {
+		t.Fatalf("imported otp wanted %v, got %v", otp, snapshot["otp"])
+	}
+	if got := currentSession.GetString("user"); got != "john" {
+		t.Fatalf("imported user wanted john, got %q", got)
+	}
+}
+
+// TestCompression checks that large payloads are written compressed
+// and payloads written before compression is enabled or after it is disabled are read.
+func TestCompression(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	defer SessManager.SetCompression(nil, 0)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+
+	if err := SessManager.SetCompression(session.GzipCompressor{}, 100); err != nil {
+		t.Fatalf("SetCompression() failed: %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	testkit.CompareValues(t, currentSession, tests)
+
+	tests["blob"] = strings.Repeat("session payload ", 100)
+	testkit.PutValues(t, currentSession, tests)
+	conn, err := sql.Open("sqlite3", SQLITE_FILENAME)
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %v", err)
+	}
+	defer conn.Close()
+	var val []byte
+	if err := conn.QueryRow(`SELECT val FROM session_vals WHERE id = $1`, sid).Scan(&val); err != nil {
+		t.Fatalf("QueryRow() failed: %v", err)
+	}
+	if len(val) == 0 || val[0] != session.COMPRESSION_MAGIC || len(val) > 1000 {
+		t.Fatalf("expected compressed payload, got %d bytes", len(val))
+	}
+
+	if err := SessManager.SetCompression(nil, 0); err != nil {
+		t.Fatalf("SetCompression() failed: %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	testkit.CompareValues(t, currentSession, tests)
+}
+
+// TestConcurrentAccess uses one session from goroutines, run with -race.
+func TestConcurrentAccess(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	defer SessManager.SessionDestroy(currentSession.SessionID())
+
+	testkit.ConcurrentAccess(t, currentSession, 8, 50)
+}
+
+// TestConformance runs testkit conformance suite.
+func TestConformance(t *testing.T) {
+	testkit.Run(t, func(t *testing.T, maxLifeTime, maxIdleTime int64) *session.Manager {
+		//separate in-memory database of every test
+		path := "file:" + strings.ReplaceAll(t.Name(), "/", "_") + "?mode=memory&cache=shared"
+		manager, err := session.NewManagerWithProvider(NewProvider(), maxLifeTime, maxIdleTime, "", Config{Path: path})
+		if err != nil {
+			t.Fatalf("NewManagerWithProvider() failed: %v", err)
+		}
+		t.Cleanup(func() { manager.CloseProvider() })
+		return manager
+	})
+}
+
+// TestIsTransient checks classification of sqlite errors retried by session.RetryProvider.
+func TestIsTransient(t *testing.T) {
+	if !pder.IsTransient(sqlite3.Error{Code: sqlite3.ErrBusy}) {
+		t.Fatalf("SQLITE_BUSY is not transient")
+	}
+	if !pder.IsTransient(fmt.Errorf("flush: %w", sqlite3.Error{Code: sqlite3.ErrLocked})) {
+		t.Fatalf("wrapped SQLITE_LOCKED is not transient")
+	}
+	if pder.IsTransient(sqlite3.Error{Code: sqlite3.ErrConstraint}) || pder.IsTransient(sql.ErrNoRows) {
+		t.Fatalf("not transient error classified as transient")
+	}
+}
+
+// userV1 and userV2 are incompatible versions of a session value.
+type userV1 struct{ Name string }
+type userV2 struct{ First, Last string }
+
+// TestPayloadVersion migrates a payload written before versions were enabled.
+func TestPayloadVersion(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	defer pder.SetPayloadVersion(nil)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Put("user", userV1{Name: "John Smith"}); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	SessManager.SessionClose(sid)
+
+	//the new type is set by migration only
+	session.RegisterType(userV2{})
+	migrated := 0
+	if err := SessManager.SetPayloadVersion(2); err != nil {
+		t.Fatalf("SetPayloadVersion() failed: %v", err)
+	}
+	if err := SessManager.OnPayloadVersionMismatch(func(oldVersion byte, data []byte, dest interface{}) error {
+		if oldVersion != 0 {
+			return fmt.Errorf("unexpected version %d", oldVersion)
+		}
+		migrated++
+		values := dest.(*map[string]interface{})
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(values); err != nil {
+			return err
+		}
+		if u, ok := (*values)["user"].(userV1); ok {
+			first, last, _ := strings.Cut(u.Name, " ")
+			(*values)["user"] = userV2{First: first, Last: last}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("OnPayloadVersionMismatch() failed: %v", err)
+	}
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	var u userV2
+	if err := currentSession.Get("user", &u); err != nil || u.Last != "Smith" {
+		t.Fatalf("Get() wanted migrated value, got %+v, error: %v", u, err)
+	}
+	if err := currentSession.Put("visits", int64(1)); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	SessManager.SessionClose(sid)
+
+	conn, err := sql.Open("sqlite3", SQLITE_FILENAME)
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %v", err)
+	}
+	defer conn.Close()
+	var val []byte
+	if err := conn.QueryRow(`SELECT val FROM session_vals WHERE id = $1`, sid).Scan(&val); err != nil {
+		t.Fatalf("QueryRow() failed: %v", err)
+	}
+	if version, _ := session.SplitPayloadVersion(val); version != 2 {
+		t.Fatalf("payload wanted version 2, got %d", version)
+	}
+	if _, err := SessManager.SessionStart(sid); err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if migrated != 1 {
+		t.Fatalf("wanted 1 migration, got %d", migrated)
+	}
+}
+
+// TestDestroySessionsForUser destroys sessions bound to a user found with session_users table.
+func TestDestroySessionsForUser(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	users := []string{"user1", "user1", "user2"}
+	sids := make([]string, len(users))
+	for i, userID := range users {
+		currentSession, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		sids[i] = currentSession.SessionID()
+		if err := SessManager.BindUser(currentSession, userID); err != nil {
+			t.Fatalf("BindUser() failed: %v", err)
+		}
+		SessManager.SessionClose(sids[i])
+	}
+	list, err := pder.SessionUserIndex().Sessions("user1")
+	if err != nil || len(list) != 2 || list[0].ID != sids[0] || list[1].ID != sids[1] {
+		t.Fatalf("Sessions() wanted %v, got %v, error: %v", sids[:2], list, err)
+	}
+
+	if err := SessManager.DestroySessionsForUser("user1"); err != nil {
+		t.Fatalf("DestroySessionsForUser() failed: %v", err)
+	}
+	for i, sid := range sids {
+		_, err := SessManager.SessionMeta(sid)
+		if destroyed := errors.Is(err, session.ErrSessionNotFound); destroyed != (users[i] == "user1") {
+			t.Fatalf("session of %s destroyed: %v, SessionMeta() error: %v", users[i], destroyed, err)
+		}
+	}
+	if list, err := pder.SessionUserIndex().Sessions("user1"); err != nil || len(list) != 0 {
+		t.Fatalf("Sessions() after DestroySessionsForUser() wanted none, got %v, error: %v", list, err)
+	}
+}
+
+// TestConfig creates manager for the provider instance initialized with Config.
+func TestConfig(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := session.NewManagerWithProvider(pder, 3600, 3600, "",
+		Config{Path: SQLITE_FILENAME, BusyTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if err := currentSession.Set("key", "value"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := currentSession.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	meta, err := SessManager.SessionMeta(currentSession.SessionID())
+	if err != nil {
+		t.Fatalf("SessionMeta() failed: %v", err)
+	}
+	if meta.Provider != PROVIDER {
+		t.Errorf("SessionMeta() wanted provider %q, got %q", PROVIDER, meta.Provider)
+	}
+
+	if _, err := session.NewManager(PROVIDER, 0, 0, "", Config{}); err == nil {
+		t.Fatal("NewManager() wanted error for empty Config.Path")
+	}
+}
+
+// TestProviderInstances keeps sessions of two managers in different database files.
+func TestProviderInstances(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+
+	const other_file = "test_other.db"
+	OtherManager, err := session.NewManagerWithProvider(NewProvider(), 0, 0, "", Config{Path: other_file})
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	defer os.Remove(other_file)
+	defer OtherManager.CloseProvider()
+	if err := OtherManager.EnsureSchema(context.Background()); err != nil {
+		t.Fatalf("EnsureSchema() failed: %v", err)
+	}
+
+	for i, manager := range []*session.Manager{SessManager, OtherManager} {
+		currentSession, err := manager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		if err := currentSession.Set("instance", i); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+		if err := currentSession.Flush(); err != nil {
+			t.Fatalf("Flush() failed: %v", err)
+		}
+		if _, err := manager.SessionMeta(currentSession.SessionID()); err != nil {
+			t.Fatalf("SessionMeta() failed: %v", err)
+		}
+		other := []*session.Manager{OtherManager, SessManager}[i]
+		if _, err := other.SessionMeta(currentSession.SessionID()); !errors.Is(err, session.ErrSessionNotFound) {
+			t.Fatalf("SessionMeta() of another instance wanted ErrSessionNotFound, got %v", err)
+		}
+	}
+}
+
+// TestNames keeps sessions in tables and columns with configured names.
+func TestNames(t *testing.T) {
+	const names_file = "test_names.db"
+	SessManager, err := session.NewManagerWithProvider(NewProvider(), 0, 0, "", Config{
+		Path: names_file,
+		Names: Names{
+			Schema:             "main",
+			Table:              "web_sessions",
+			LocksTable:         "web_session_locks",
+			UsersTable:         "web_session_users",
+			IDColumn:           "sid",
+			ValColumn:          "payload",
+			AccessedTimeColumn: "touched_at",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	defer os.Remove(names_file)
+	defer SessManager.CloseProvider()
+	if err := SessManager.EnsureSchema(context.Background()); err != nil {
+		t.Fatalf("EnsureSchema() failed: %v", err)
+	}
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Lock(); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	if err := currentSession.Put("key", "value"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if _, err := currentSession.Increment("cnt", 1); err != nil {
+		t.Fatalf("Increment() failed: %v", err)
+	}
+	if err := currentSession.Unlock(); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+	if err := SessManager.BindUser(currentSession, "user1"); err != nil {
+		t.Fatalf("BindUser() failed: %v", err)
+	}
+	if err := SessManager.SessionClose(sid); err != nil {
+		t.Fatalf("SessionClose() failed: %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if v := currentSession.GetString("key"); v != "value" {
+		t.Fatalf("GetString() wanted value, got %q", v)
+	}
+
+	conn, err := sql.Open("sqlite3", names_file)
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %v", err)
+	}
+	defer conn.Close()
+	var cnt int
+	if err := conn.QueryRow(`SELECT count(*) FROM web_sessions WHERE sid = $1 AND payload IS NOT NULL AND touched_at IS NOT NULL`, sid).Scan(&cnt); err != nil {
+		t.Fatalf("QueryRow() failed: %v", err)
+	}
+	if cnt != 1 {
+		t.Fatalf("wanted session in web_sessions table, got %d rows", cnt)
+	}
+
+	if err := currentSession.SetExpiry(time.Millisecond); err != nil {
+		t.Fatalf("SetExpiry() failed: %v", err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if cnt, err := SessManager.CollectGarbage(); err != nil || cnt != 1 {
+		t.Fatalf("CollectGarbage() = %d, %v, wanted 1", cnt, err)
+	}
+}
+
+// TestConnectionTuning checks journal mode, synchronous level and pool size set by Config
+// and concurrent flushes of one connection pool.
+func TestConnectionTuning(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	prov := NewProvider()
+	SessManager, err := session.NewManagerWithProvider(prov, 0, 0, "",
+		Config{Path: SQLITE_FILENAME, WAL: true, Synchronous: "normal", BusyTimeout: 5 * time.Second, SingleWriter: true})
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	defer func() {
+		ClearManager(SessManager)
+		os.Remove(SQLITE_FILENAME + "-wal")
+		os.Remove(SQLITE_FILENAME + "-shm")
+	}()
+
+	var journal_mode string
+	if err := prov.db().QueryRow("PRAGMA journal_mode").Scan(&journal_mode); err != nil {
+		t.Fatalf("QueryRow() failed: %v", err)
+	}
+	if journal_mode != "wal" {
+		t.Errorf("journal_mode wanted wal, got %s", journal_mode)
+	}
+	var synchronous int
+	if err := prov.db().QueryRow("PRAGMA synchronous").Scan(&synchronous); err != nil {
+		t.Fatalf("QueryRow() failed: %v", err)
+	}
+	if synchronous != 1 { //NORMAL
+		t.Errorf("synchronous wanted 1, got %d", synchronous)
+	}
+	if n := prov.db().Stats().MaxOpenConnections; n != 1 {
+		t.Errorf("MaxOpenConnections wanted 1, got %d", n)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			currentSession, err := SessManager.SessionStart("")
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := currentSession.Put("i", i); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent Put() failed: %v", err)
+	}
+
+	if _, err := session.NewManagerWithProvider(NewProvider(), 0, 0, "", Config{Path: SQLITE_FILENAME, Synchronous: "SOMETIMES"}); err == nil {
+		t.Fatal("NewManagerWithProvider() wanted error for wrong Config.Synchronous")
+	}
+}
+
+// TestMemory keeps sessions in in-memory database created with the schema.
+func TestMemory(t *testing.T) {
+	SessManager, err := session.NewManagerWithProvider(NewProvider(), 0, 0, "", Config{Path: MEMORY_PATH})
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	defer SessManager.CloseProvider()
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+	if err := SessManager.SessionClose(sid); err != nil {
+		t.Fatalf("SessionClose() failed: %v", err)
+	}
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	testkit.CompareValues(t, currentSession, tests)
+
+	//shared cache is visible to other connections of the process
+	conn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %v", err)
+	}
+	defer conn.Close()
+	var cnt int
+	if err := conn.QueryRow(`SELECT count(*) FROM session_vals WHERE id = $1`, sid).Scan(&cnt); err != nil {
+		t.Fatalf("QueryRow() failed: %v", err)
+	}
+	if cnt != 1 {
+		t.Fatalf("wanted session in shared in-memory database, got %d rows", cnt)
+	}
+
+	if _, err := os.Stat(MEMORY_PATH); !os.IsNotExist(err) {
+		t.Fatalf("wanted no database file, got %v", err)
+	}
+}
+
+// TestConnectionPool checks connection pool parameters of Config.
+func TestConnectionPool(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	prov := NewProvider()
+	SessManager, err := session.NewManagerWithProvider(prov, 0, 0, "",
+		Config{Path: SQLITE_FILENAME, MaxOpenConns: 3, MaxIdleConns: 2, ConnMaxLifetime: time.Minute})
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	if n := prov.db().Stats().MaxOpenConnections; n != 3 {
+		t.Errorf("MaxOpenConnections wanted 3, got %d", n)
+	}
+
+	if _, err := session.NewManagerWithProvider(NewProvider(), 0, 0, "", Config{Path: MEMORY_PATH, ConnMaxLifetime: time.Minute}); err == nil {
+		t.Error("NewManagerWithProvider() wanted error for ConnMaxLifetime of in-memory database")
+	}
+	if _, err := session.NewManagerWithProvider(NewProvider(), 0, 0, "", Config{Path: SQLITE_FILENAME, MaxIdleConns: -1}); err == nil {
+		t.Error("NewManagerWithProvider() wanted error for negative MaxIdleConns")
+	}
+}
+
+// TestReconnect replaces database file and checks that the manager monitor reconnects to the new file.
+func TestReconnect(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	prov := NewProvider()
+	SessManager, err := session.NewManagerWithProvider(prov, 0, 0, "", Config{Path: SQLITE_FILENAME})
+	if err != nil {
+		t.Fatalf("NewManagerWithProvider() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	if _, err := SessManager.SessionStart(""); err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+
+	//new database file replaces the opened one
+	const new_file = "test_new.db"
+	conn, err := sql.Open("sqlite3", new_file)
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %v", err)
+	}
+	_, err = conn.Exec(SCHEMA_SQL)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Exec() failed: %v", err)
+	}
+	if err := os.Rename(new_file, SQLITE_FILENAME); err != nil {
+		t.Fatalf("Rename() failed: %v", err)
+	}
+	if err := prov.Ping(context.Background()); !errors.Is(err, ErrDatabaseMoved) {
+		t.Fatalf("Ping() = %v, wanted ErrDatabaseMoved", err)
+	}
+
+	recovered := make(chan error, 1)
+	SessManager.OnRecovered(func(err error, down time.Duration) { recovered <- err })
+	SessManager.SetReconnect(50*time.Millisecond, nil)
+	defer SessManager.SetReconnect(0, nil)
+	select {
+	case err := <-recovered:
+		if !errors.Is(err, ErrDatabaseMoved) {
+			t.Fatalf("recovered from %v, wanted ErrDatabaseMoved", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("provider is not recovered")
+	}
+	if err := prov.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping() after reconnect failed: %v", err)
+	}
+	if cnt, err := prov.SessionCount(); err != nil || cnt != 0 {
+		t.Fatalf("SessionCount() = %d, %v, wanted 0 sessions of the new file", cnt, err)
+	}
+}
+
+// TestStrictMode checks that unknown session IDs are not created in strict mode.
+func TestStrictMode(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	if err := SessManager.SetStrictMode(true); err != nil {
+		t.Fatalf("SetStrictMode() failed: %v", err)
+	}
+	defer SessManager.SetStrictMode(false)
+
+	const unknown_sid = "00000000-0000-0000-0000-000000000000"
+	if _, err := SessManager.SessionStart(unknown_sid); !errors.Is(err, session.ErrSessionNotFound) {
+		t.Fatalf("SessionStart() of unknown ID error is %v, wanted %v", err, session.ErrSessionNotFound)
+	}
+	if _, err := SessManager.SessionMeta(unknown_sid); !errors.Is(err, session.ErrSessionNotFound) {
+		t.Fatalf("SessionMeta() of unknown ID error is %v: unknown session is created", err)
+	}
+
+	sess, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := sess.SessionID()
+	SessManager.SessionClose(sid)
+	if _, err := SessManager.SessionStart(sid); err != nil {
+		t.Fatalf("SessionStart() of created session failed: %v", err)
+	}
+	SessManager.SessionClose(sid)
+}
+
+// TestStats checks session statistics aggregates.
+func TestStats(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	for i := 1; i <= 3; i++ {
+		sess, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		if err := sess.Set("value", strings.Repeat("x", i*100)); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+		if err := sess.Flush(); err != nil {
+			t.Fatalf("Flush() failed: %v", err)
+		}
+		SessManager.SessionClose(sess.SessionID())
+	}
+
+	stats, err := SessManager.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("Stats() failed: %v", err)
+	}
+	if stats.Provider != PROVIDER || stats.Count != 3 || stats.Sampled != 3 {
+		t.Fatalf("Stats() = %+v, wanted 3 sessions of %s", stats, PROVIDER)
+	}
+	if stats.Size.Min <= 0 || stats.Size.Max <= stats.Size.Min || stats.Size.P50 < stats.Size.Min || stats.Size.P50 > stats.Size.Max {
+		t.Fatalf("Stats() Size = %+v, wanted distribution of different sizes", stats.Size)
+	}
+	if stats.TotalSize < stats.Size.Min+stats.Size.Max || stats.StorageBytes <= 0 {
+		t.Fatalf("Stats() TotalSize = %d, StorageBytes = %d", stats.TotalSize, stats.StorageBytes)
+	}
+}
+
+// TestDebugDump checks that dump of sampled sessions contains keys without values and session IDs.
+func TestDebugDump(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	sids := make([]string, 3)
+	for i := range sids {
+		sess, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		sids[i] = sess.SessionID()
+		if err := sess.Set("email", "user@example.com"); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+		if err := sess.Set("cart", strings.Repeat("item", 100)); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+		if err := sess.Flush(); err != nil {
+			t.Fatalf("Flush() failed: %v", err)
+		}
+		SessManager.SessionClose(sids[i])
+	}
+
+	var buf bytes.Buffer
+	n, err := SessManager.DebugDump(&buf, 2, session.DEBUG_VALUES_HASHED)
+	if err != nil {
+		t.Fatalf("DebugDump() failed: %v", err)
+	}
+	dump := buf.String()
+	if n != 2 || strings.Count(dump, "\n") != 2 {
+		t.Fatalf("DebugDump() dumped %d sessions, wanted 2:\n%s", n, dump)
+	}
+	if !strings.Contains(dump, " cart=string(") || !strings.Contains(dump, " email=string(") {
+		t.Fatalf("DebugDump() keys are missing:\n%s", dump)
+	}
+	if strings.Index(dump, " cart=") > strings.Index(dump, " email=") {
+		t.Fatalf("DebugDump() keys are not ordered by size:\n%s", dump)
+	}
+	if strings.Contains(dump, "user@example.com") || strings.Contains(dump, "itemitem") {
+		t.Fatalf("DebugDump() contains values:\n%s", dump)
+	}
+	for _, sid := range sids {
+		if strings.Contains(dump, sid) {
+			t.Fatalf("DebugDump() contains session ID %s:\n%s", sid, dump)
+		}
+	}
+}
+
+// TestSensitiveValue checks that sensitive values are left out of export and debug dump.
+func TestSensitiveValue(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	if err := session.SetSensitiveKeys([]byte("sensitive key")); err != nil {
+		t.Fatalf("SetSensitiveKeys() failed: %v", err)
+	}
+	defer session.SetSensitiveKeys()
+
+	sess, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := sess.SessionID()
+	if err := sess.SetSensitive("email", "user@example.com"); err != nil {
+		t.Fatalf("SetSensitive() failed: %v", err)
+	}
+	if err := sess.Set("cart", "item"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := sess.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	SessManager.SessionClose(sid)
+
+	sess, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if v := sess.GetString("email"); v != "user@example.com" {
+		t.Fatalf("GetString() = %q, wanted decrypted value", v)
+	}
+	SessManager.SessionClose(sid)
+
+	var buf bytes.Buffer
+	if _, err := SessManager.DebugDump(&buf, 1, session.DEBUG_VALUES_HASHED); err != nil {
+		t.Fatalf("DebugDump() failed: %v", err)
+	}
+	dump := buf.String()
+	if !strings.Contains(dump, " email=sensitive(") || strings.Contains(dump, "user@example.com") {
+		t.Fatalf("DebugDump() sensitive value is not redacted:\n%s", dump)
+	}
+
+	buf.Reset()
+	if n, err := SessManager.ExportSessions(&buf); err != nil || n != 1 {
+		t.Fatalf("ExportSessions() = %d, %v", n, err)
+	}
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+	if _, err := SessManager.ImportSessions(&buf); err != nil {
+		t.Fatalf("ImportSessions() failed: %v", err)
+	}
+	sess, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	defer SessManager.SessionClose(sid)
+	if v := sess.GetString("cart"); v != "item" {
+		t.Fatalf("imported value is %q, wanted %q", v, "item")
+	}
+	var v interface{}
+	if err := sess.Get("email", &v); !errors.Is(err, session.ErrKeyNotFound) {
+		t.Fatalf("sensitive value is exported: %v, %v", v, err)
+	}
+}
+
+// TestManualClock checks idle time on read and by GC with times of a manual clock.
+func TestManualClock(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 60, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	clock := session.NewManualClock(time.Now())
+	SessManager.SetClock(clock)
+	defer SessManager.SetClock(nil)
+
+	sids := make([]string, 2)
+	for i := range sids {
+		sess, err := SessManager.SessionStart("")
+		if err != nil {
+			t.Fatalf("SessionStart() failed: %v", err)
+		}
+		sids[i] = sess.SessionID()
+		if err := sess.Put("key", "value"); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+		SessManager.SessionClose(sids[i])
+	}
+
+	clock.Advance(30 * time.Second)
+	if _, err := SessManager.SessionStart(sids[0]); err != nil {
+		t.Fatalf("SessionStart() before idle time failed: %v", err)
+	}
+	SessManager.SessionClose(sids[0])
+	clock.Advance(31 * time.Second)
+	if report := SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_DEBUG); report.DeletedIdle != 1 {
+		t.Fatalf("SessionGC() removed %d idle sessions, wanted 1", report.DeletedIdle)
+	}
+	clock.Advance(30 * time.Second)
+	if _, err := SessManager.SessionStart(sids[0]); !errors.Is(err, session.ErrSessionExpired) {
+		t.Fatalf("SessionStart() after idle time error is %v, wanted %v", err, session.ErrSessionExpired)
+	}
+}
+
+// TestExportReadOnly checks that export and debug dump do not touch sessions nor remove expired ones.
+func TestExportReadOnly(t *testing.T) {
+	if err := InitTestDb(); err != nil {
+		t.Fatalf("InitTestDb() failed: %v", err)
+	}
+	SessManager, err := NewManager(t, 0, 60, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer ClearManager(SessManager)
+	clock := session.NewManualClock(time.Now())
+	SessManager.SetClock(clock)
+	defer SessManager.SetClock(nil)
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Put("key", "value"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	SessManager.SessionClose(sid)
+	meta, err := SessManager.SessionMeta(sid)
+	if err != nil {
+		t.Fatalf("SessionMeta() failed: %v", err)
+	}
+
+	clock.Advance(30 * time.Second)
+	if n, err := SessManager.ExportSessions(io.Discard); err != nil || n != 1 {
+		t.Fatalf("ExportSessions() wanted 1 session, got %d, %v", n, err)
+	}
+	if n, err := SessManager.DebugDump(io.Discard, 10, session.DEBUG_VALUES_HASHED); err != nil || n != 1 {
+		t.Fatalf("DebugDump() wanted 1 session, got %d, %v", n, err)
+	}
+	if after, err := SessManager.SessionMeta(sid); err != nil || !after.TimeAccessed.Equal(meta.TimeAccessed) {
+		t.Fatalf("access time changed from %v to %v by export, %v", meta.TimeAccessed, after.TimeAccessed, err)
+	}
+
+	clock.Advance(31 * time.Second)
+	if n, err := SessManager.ExportSessions(io.Discard); err != nil || n != 0 {
+		t.Fatalf("ExportSessions() of expired session wanted 0 sessions, got %d, %v", n, err)
+	}
+	if _, err := SessManager.SessionMeta(sid); err != nil {
+		t.Fatalf("expired session removed by export: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package sqlite
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dronm/session"
+)
+
+// writeQueue is a write-behind queue coalescing SessionStore.Flush() calls.
+// Only the last encoded value of a session is kept, pending values are
+// written in batches within a single transaction with a prepared statement.
+type writeQueue struct {
+	pder     *Provider //database connection, logger and access time mode
+	interval time.Duration
+	mx       sync.Mutex        //guards pending
+	pending  map[string][]byte //session id -> encoded value
+	writeMx  sync.Mutex        //serializes batch and single session writes
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// newWriteQueue creates queue and starts writing goroutine.
+func newWriteQueue(pder *Provider, interval time.Duration) *writeQueue {
+	q := &writeQueue{
+		pder:     pder,
+		interval: interval,
+		pending:  make(map[string][]byte),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *writeQueue) run() {
+	defer close(q.done)
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+			if err := q.writeBatch(); err != nil {
+				q.pder.getLogger(nil, session.LOG_LEVEL_ERROR).Error(LOG_PREF+"write-behind batch failed", session.LOG_KEY_ERROR, err)
+			}
+		}
+	}
+}
+
+// put queues session value replacing previously queued value.
+func (q *writeQueue) put(sid string, val []byte) {
+	q.mx.Lock()
+	q.pending[sid] = val
+	q.mx.Unlock()
+}
+
+// get returns queued session value if any.
+func (q *writeQueue) get(sid string) ([]byte, bool) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	val, ok := q.pending[sid]
+	return val, ok
+}
+
+// remove drops queued session value, used when session is destroyed.
+func (q *writeQueue) remove(sid string) {
+	q.writeMx.Lock()
+	defer q.writeMx.Unlock()
+	q.mx.Lock()
+	delete(q.pending, sid)
+	q.mx.Unlock()
+}
+
+// clear drops all queued values.
+func (q *writeQueue) clear() {
+	q.writeMx.Lock()
+	defer q.writeMx.Unlock()
+	q.mx.Lock()
+	q.pending = make(map[string][]byte)
+	q.mx.Unlock()
+}
+
+// writeBatch writes all pending values in one transaction.
+// On failure values not replaced in the meantime are queued back.
+func (q *writeQueue) writeBatch() error {
+	q.writeMx.Lock()
+	defer q.writeMx.Unlock()
+
+	q.mx.Lock()
+	batch := q.pending
+	q.pending = make(map[string][]byte)
+	q.mx.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err := q.write(batch); err != nil {
+		q.mx.Lock()
+		for sid, val := range batch {
+			if _, ok := q.pending[sid]; !ok {
+				q.pending[sid] = val
+			}
+		}
+		q.mx.Unlock()
+		return err
+	}
+	return nil
+}
+
+// flushSession writes pending value of one session synchronously.
+// It is called before reading session value directly from database.
+func (q *writeQueue) flushSession(sid string) error {
+	q.writeMx.Lock()
+	defer q.writeMx.Unlock()
+
+	q.mx.Lock()
+	val, ok := q.pending[sid]
+	delete(q.pending, sid)
+	q.mx.Unlock()
+	if !ok {
+		return nil
+	}
+
+	if err := q.write(map[string][]byte{sid: val}); err != nil {
+		q.mx.Lock()
+		if _, ok := q.pending[sid]; !ok {
+			q.pending[sid] = val
+		}
+		q.mx.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (q *writeQueue) write(batch map[string][]byte) error {
+	ctx := context.Background()
+	tx, err := q.pder.db().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx,
+		q.pder.query(`UPDATE session_vals
+		SET
+			val = $1,
+			accessed_time = `+q.pder.accessedTime(false)+`
+		WHERE id = $2`))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for sid, val := range batch {
+		if _, err := stmt.ExecContext(ctx, val, sid); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// close stops writing goroutine and writes all pending values.
+func (q *writeQueue) close() error {
+	close(q.stop)
+	<-q.done
+	return q.writeBatch()
+}
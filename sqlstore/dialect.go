@@ -0,0 +1,170 @@
+package sqlstore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect adapts provider queries to a database engine.
+// Queries are written with $1, $2... placeholders and numbered parameters
+// are used in ascending order, so they can be replaced with positional ones.
+type Dialect interface {
+	// Placeholder returns n-th query parameter, n starts from 1, e.g. $1 or ?.
+	Placeholder(n int) string
+
+	// Now returns SQL expression of current UTC time.
+	Now() string
+
+	// AddSeconds returns SQL expression of time expression expr shifted by seconds.
+	AddSeconds(expr string, seconds int64) string
+
+	// InsertIgnore returns INSERT statement of values (SQL expressions) to columns of table,
+	// nothing is inserted without an error if the row with the same primary key exists.
+	InsertIgnore(table string, columns, values []string) string
+
+	// Schema returns statements creating tables and indexes if they do not exist.
+	Schema() []string
+}
+
+// SQLiteDialect is a dialect of sqlite, e.g. github.com/mattn/go-sqlite3 driver.
+type SQLiteDialect struct{}
+
+func (SQLiteDialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (SQLiteDialect) Now() string {
+	return "datetime()"
+}
+
+func (SQLiteDialect) AddSeconds(expr string, seconds int64) string {
+	return fmt.Sprintf("datetime(%s, '%+d seconds')", expr, seconds)
+}
+
+func (SQLiteDialect) InsertIgnore(table string, columns, values []string) string {
+	return fmt.Sprintf("INSERT OR IGNORE INTO %s(%s) VALUES(%s)", table, strings.Join(columns, ", "), strings.Join(values, ", "))
+}
+
+func (SQLiteDialect) Schema() []string {
+	return []string{
+		`CREATE TABLE IF NOT EXISTS session_vals
+		(id varchar(36) NOT NULL PRIMARY KEY,
+		accessed_time datetime NOT NULL,
+		create_time datetime NOT NULL,
+		val blob,
+		expires_at datetime,
+		rates blob
+		)`,
+		`CREATE INDEX IF NOT EXISTS session_vals_accessed_time_idx ON session_vals(accessed_time)`,
+		`CREATE INDEX IF NOT EXISTS session_vals_create_time_idx ON session_vals(create_time)`,
+		`CREATE TABLE IF NOT EXISTS session_locks
+		(id varchar(36) NOT NULL PRIMARY KEY,
+		token varchar(32) NOT NULL,
+		lock_till bigint NOT NULL
+		)`,
+	}
+}
+
+// PostgresDialect is a dialect of postgresql, e.g. github.com/jackc/pgx/v5/stdlib driver.
+type PostgresDialect struct{}
+
+func (PostgresDialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (PostgresDialect) Now() string {
+	return "now()"
+}
+
+func (PostgresDialect) AddSeconds(expr string, seconds int64) string {
+	return fmt.Sprintf("(%s + interval '%d seconds')", expr, seconds)
+}
+
+func (PostgresDialect) InsertIgnore(table string, columns, values []string) string {
+	return fmt.Sprintf("INSERT INTO %s(%s) VALUES(%s) ON CONFLICT DO NOTHING", table, strings.Join(columns, ", "), strings.Join(values, ", "))
+}
+
+func (PostgresDialect) Schema() []string {
+	return []string{
+		`CREATE TABLE IF NOT EXISTS session_vals
+		(id varchar(36) NOT NULL PRIMARY KEY,
+		accessed_time timestamptz NOT NULL,
+		create_time timestamptz NOT NULL,
+		val bytea,
+		expires_at timestamptz,
+		rates bytea
+		)`,
+		`CREATE INDEX IF NOT EXISTS session_vals_accessed_time_idx ON session_vals(accessed_time)`,
+		`CREATE INDEX IF NOT EXISTS session_vals_create_time_idx ON session_vals(create_time)`,
+		`CREATE TABLE IF NOT EXISTS session_locks
+		(id varchar(36) NOT NULL PRIMARY KEY,
+		token varchar(32) NOT NULL,
+		lock_till bigint NOT NULL
+		)`,
+	}
+}
+
+// MySQLDialect is a dialect of mysql, e.g. github.com/go-sql-driver/mysql driver.
+// Connection must be opened with parseTime=true parameter for datetime columns to be scanned
+// and clientFoundRows=true for matched rows to be reported by updates not changing values.
+type MySQLDialect struct{}
+
+func (MySQLDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (MySQLDialect) Now() string {
+	return "UTC_TIMESTAMP()"
+}
+
+func (MySQLDialect) AddSeconds(expr string, seconds int64) string {
+	return fmt.Sprintf("DATE_ADD(%s, INTERVAL %d SECOND)", expr, seconds)
+}
+
+func (MySQLDialect) InsertIgnore(table string, columns, values []string) string {
+	return fmt.Sprintf("INSERT IGNORE INTO %s(%s) VALUES(%s)", table, strings.Join(columns, ", "), strings.Join(values, ", "))
+}
+
+func (MySQLDialect) Schema() []string {
+	return []string{
+		`CREATE TABLE IF NOT EXISTS session_vals
+		(id varchar(36) NOT NULL PRIMARY KEY,
+		accessed_time datetime NOT NULL,
+		create_time datetime NOT NULL,
+		val longblob,
+		expires_at datetime,
+		rates blob,
+		INDEX session_vals_accessed_time_idx (accessed_time),
+		INDEX session_vals_create_time_idx (create_time)
+		)`,
+		`CREATE TABLE IF NOT EXISTS session_locks
+		(id varchar(36) NOT NULL PRIMARY KEY,
+		token varchar(32) NOT NULL,
+		lock_till bigint NOT NULL
+		)`,
+	}
+}
+
+// rebind replaces $n placeholders of query with dialect ones.
+func rebind(dialect Dialect, query string) string {
+	var b strings.Builder
+	for i := 0; i < len(query); i++ {
+		if query[i] != '$' {
+			b.WriteByte(query[i])
+			continue
+		}
+		j := i + 1
+		for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+			j++
+		}
+		n, err := strconv.Atoi(query[i+1 : j])
+		if err != nil {
+			b.WriteByte(query[i])
+			continue
+		}
+		b.WriteString(dialect.Placeholder(n))
+		i = j - 1
+	}
+	return b.String()
+}
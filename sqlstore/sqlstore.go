@@ -0,0 +1,1329 @@
+// Package sqlstore contains generic database/sql session provider.
+// Requirements:
+//
+//	Opened *sql.DB of any driver and a Dialect of its database engine.
+//	Built-in dialects: SQLiteDialect, PostgresDialect and MySQLDialect.
+//		Tables and indexes are created with Provider.EnsureSchema(), see Dialect.Schema():
+//			session_vals table holds session values,
+//				expires_at column holds per-session expiration set with SessionStore.SetExpiry()
+//			session_locks table is used by SessionStore.Lock()
+//
+// All times are set by database with Dialect.Now(), so application and database clocks
+// do not need to be synchronized for GC.
+//
+// Internally gob encoder is used for data serialization. Session data is read at start and kept in memory SessionStore structure.
+// Session key-value pares are kept in storeValue type.
+package sqlstore
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dronm/session"
+)
+
+// Session key ID length. As it is stored in varchar column its length is limited.
+const SESS_ID_LEN = 36
+
+const PROVIDER = "sqlstore"
+
+const LOG_PREF = "sqlstore provider:"
+
+// pder holds pointer to Provider struct registered as PROVIDER.
+var pder = NewProvider()
+
+// NewProvider returns a provider independent of the registered one, e.g. to use another database
+// in another Manager created with session.NewManagerWithProvider().
+func NewProvider() *Provider {
+	return &Provider{clock: session.SystemClock{}}
+}
+
+// storeValue holds session key-value pares.
+type storeValue map[string]interface{}
+
+// SessionStore contains session information.
+type SessionStore struct {
+	sid           string    //session id
+	pder          *Provider //provider storing the session
+	mx            sync.RWMutex
+	timeAccessed  atomic.Int64 //last access in Unix nanoseconds, updated under read lock by getters
+	timeCreated   time.Time    //when created
+	value         storeValue   //key-value pair
+	valueModified bool
+	lockToken     string //set when session is locked
+}
+
+// Set sets inmemory value. No database flush is done.
+func (st *SessionStore) Set(key string, value interface{}) error {
+	//type assertion is needed
+	/*
+		var v interface{}
+
+		switch value.(type) {
+		case int:
+			v = int64(value.(int))
+		case int32:
+			v = int64(value.(int32))
+		case float32:
+			v = float64(value.(float32))
+		default:
+			v = value
+		}
+	*/
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if !reflect.DeepEqual(st.value[key], value) {
+		st.value[key] = value
+		st.valueModified = true
+		st.accessed()
+	}
+	return nil
+}
+
+func (st *SessionStore) Put(key string, value interface{}) error {
+	if err := st.Set(key, value); err != nil {
+		return err
+	}
+	return st.Flush()
+}
+
+// SetMany sets in-memory values under one lock, they are written by one Flush().
+func (st *SessionStore) SetMany(values map[string]interface{}) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	for key, value := range values {
+		if !reflect.DeepEqual(st.value[key], value) {
+			st.value[key] = value
+			st.valueModified = true
+			st.accessed()
+		}
+	}
+	return nil
+}
+
+// SetWithTTL sets in-memory value expiring in ttl, see session.ExpiringValue.
+func (st *SessionStore) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value[key] = session.NewExpiringValueAt(st.pder.clock.Now(), value, ttl)
+	st.valueModified = true
+	st.accessed()
+	return nil
+}
+
+// GetMany assigns in-memory values to dest pointers, see session.GetMany().
+func (st *SessionStore) GetMany(dest map[string]interface{}) error {
+	return session.GetMany(st, dest)
+}
+
+// Snapshot returns a deep copy of in-memory values without expired ones, see session.SnapshotValuesAt().
+func (st *SessionStore) Snapshot() (map[string]interface{}, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	return session.SnapshotValuesAt(st.pder.clock.Now(), st.value)
+}
+
+// Restore replaces in-memory values with a copy of snapshot, they are written by Flush().
+func (st *SessionStore) Restore(snapshot map[string]interface{}) error {
+	value, err := session.CopyValues(snapshot)
+	if err != nil {
+		return err
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.value = value
+	st.valueModified = true
+	st.accessed()
+	return nil
+}
+
+// Flush performs the actual write to database.
+func (st *SessionStore) Flush() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+
+	//flush val only if it's been modified
+	if st.valueModified {
+		//modified
+		val, err := st.pder.getForDb(&st.value)
+		if err != nil {
+			return err
+		}
+		if _, err = st.pder.dbConn.ExecContext(context.Background(),
+			st.pder.query(`UPDATE session_vals
+			SET
+				val = $1,
+				accessed_time = `+st.pder.accessedTime(false)+`
+			WHERE id = $2`),
+			val,
+			st.sid,
+		); err != nil {
+			return err
+		}
+		st.valueModified = false
+		st.written()
+	}
+
+	return nil
+}
+
+// Get returns session value by its key. Value is retrieved from memory.
+func (st *SessionStore) Get(key string, val interface{}) error {
+	st.mx.RLock()
+	store_val, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if !ok {
+		return session.ErrKeyNotFound
+	}
+
+	// Get the type of val
+	val_type := reflect.TypeOf(val)
+
+	// Make sure val is a pointer
+	if val_type.Kind() != reflect.Ptr {
+		return session.ErrValMustBePtr
+	}
+
+	// Dereference the pointer and check if it's assignable
+	val_elem := val_type.Elem()
+	if !reflect.TypeOf(store_val).AssignableTo(val_elem) {
+		return session.ErrTypeMismatch
+	}
+
+	// Assign the value to val
+	reflect.ValueOf(val).Elem().Set(reflect.ValueOf(store_val))
+
+	return nil
+}
+
+// GetStruct retrieves session value by its key into dest pointer.
+// Value of a different type is converted, see session.AssignValue().
+func (st *SessionStore) GetStruct(key string, dest interface{}) error {
+	st.mx.RLock()
+	store_val, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if !ok {
+		return session.ErrKeyNotFound
+	}
+	return session.AssignValue(store_val, dest)
+}
+
+// GetBool returns bool value by key.
+func (st *SessionStore) GetBool(key string) bool {
+	v, ok := st.lookup(key)
+	if !ok {
+		return false
+	}
+
+	if v_bool, ok := v.(bool); ok {
+		return v_bool
+	}
+	return false
+}
+
+// GetString returns string value by key.
+func (st *SessionStore) GetString(key string) string {
+	v, ok := st.lookup(key)
+	if !ok {
+		return ""
+	}
+
+	if v_str, ok := v.(string); ok {
+		return v_str
+
+	} else if v_str, ok := v.([]byte); ok {
+		return string(v_str)
+	}
+	return ""
+}
+
+// GetInt returns int value by key.
+func (st *SessionStore) GetInt(key string) int64 {
+	v, ok := st.lookup(key)
+	if !ok {
+		return 0
+	}
+
+	if v_i, ok := v.(int64); ok {
+		return v_i
+
+	} else if v_i, ok := v.(int); ok {
+		return int64(v_i)
+	}
+	return 0
+}
+
+// GetFloat returns float value by key.
+func (st *SessionStore) GetFloat(key string) float64 {
+	v, ok := st.lookup(key)
+	if !ok {
+		return 0
+	}
+
+	if v_f, ok := v.(float64); ok {
+		return v_f
+
+	} else if v_f, ok := v.(float32); ok {
+		return float64(v_f)
+	}
+	return 0
+}
+
+// GetDate returns time.Time value by key.
+func (st *SessionStore) GetDate(key string) time.Time {
+	v, ok := st.lookup(key)
+	if !ok {
+		return time.Time{}
+	}
+
+	if v_t, ok := v.(time.Time); ok {
+		return v_t
+	}
+	return time.Time{}
+}
+
+// GetBytes returns []byte value by key, string value is converted.
+func (st *SessionStore) GetBytes(key string) []byte {
+	v, ok := st.lookup(key)
+	if !ok {
+		return nil
+	}
+
+	if v_b, ok := v.([]byte); ok {
+		return v_b
+
+	} else if v_b, ok := v.(string); ok {
+		return []byte(v_b)
+	}
+	return nil
+}
+
+// GetStringSlice returns []string value by key.
+func (st *SessionStore) GetStringSlice(key string) []string {
+	v, ok := st.lookup(key)
+	if !ok {
+		return nil
+	}
+
+	if v_s, ok := v.([]string); ok {
+		return v_s
+	}
+	return nil
+}
+
+// GetOrSet assigns session value to dest, if there is no value
+// it is computed, stored and flushed under session lock, see session.GetOrSet().
+func (st *SessionStore) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	return session.GetOrSet(st, key, dest, compute)
+}
+
+// Delete deletes session value from memmory by key. No flushing is done.
+func (st *SessionStore) Delete(key string) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if _, ok := st.value[key]; !ok {
+		return nil
+	}
+	st.accessed()
+	delete(st.value, key)
+	st.valueModified = true
+
+	return nil
+}
+
+// Clear deletes all session values from memory keeping session ID and creation time. No flushing is done.
+func (st *SessionStore) Clear() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if len(st.value) > 0 {
+		st.value = make(storeValue)
+		st.valueModified = true
+	}
+	st.accessed()
+
+	return nil
+}
+
+// Increment atomically adds delta to integer session value and returns the new value.
+// Missing value is treated as 0. The value is updated in database within a transaction
+// and in memory, other modified in-memory values are not flushed.
+func (st *SessionStore) Increment(key string, delta int64) (int64, error) {
+	//held till the value is updated in memory, concurrent Flush() must not overwrite it
+	st.mx.Lock()
+	defer st.mx.Unlock()
+
+	ctx := context.Background()
+	tx, err := st.pder.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	db_value, err := st.pder.readForUpdate(ctx, tx, st.sid)
+	if err != nil {
+		return 0, err
+	}
+	cur, _ := session.LookupValueAt(st.pder.clock.Now(), db_value, key)
+	new_val, err := session.IncrementValue(cur, delta)
+	if err != nil {
+		return 0, err
+	}
+	db_value[key] = new_val
+	if err := st.pder.writeValue(ctx, tx, st.sid, db_value); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	st.value[key] = new_val
+	st.written()
+
+	return new_val, nil
+}
+
+// Decrement atomically subtracts delta from integer session value and returns the new value.
+func (st *SessionStore) Decrement(key string, delta int64) (int64, error) {
+	return st.Increment(key, -delta)
+}
+
+// CompareAndSwap sets newValue in a transaction if the stored value equals oldValue,
+// see session.Session.CompareAndSwap().
+func (st *SessionStore) CompareAndSwap(key string, oldValue, newValue interface{}) (bool, error) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+
+	ctx := context.Background()
+	tx, err := st.pder.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	db_value, err := st.pder.readForUpdate(ctx, tx, st.sid)
+	if err != nil {
+		return false, err
+	}
+	if cur, _ := session.LookupValueAt(st.pder.clock.Now(), db_value, key); !session.EqualValue(cur, oldValue) {
+		return false, nil
+	}
+	if newValue == nil {
+		delete(db_value, key)
+	} else {
+		db_value[key] = newValue
+	}
+	if err := st.pder.writeValue(ctx, tx, st.sid, db_value); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	if newValue == nil {
+		delete(st.value, key)
+	} else {
+		st.value[key] = newValue
+	}
+	st.written()
+
+	return true, nil
+}
+
+// Bucket returns a view of the session with keys prefixed by name, see session.NewBucket().
+func (st *SessionStore) Bucket(name string) session.Session {
+	return session.NewBucket(st, name)
+}
+
+// Keys returns sorted session value keys.
+func (st *SessionStore) Keys() ([]string, error) {
+	st.mx.RLock()
+	defer st.mx.RUnlock()
+	keys := make([]string, 0, len(st.value))
+	now := st.pder.clock.Now()
+	for key, v := range st.value {
+		if !session.ValueExpiredAt(now, v) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Len returns number of session values.
+func (st *SessionStore) Len() (int, error) {
+	keys, err := st.Keys()
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// SessionID returns session unique ID.
+func (st *SessionStore) SessionID() string {
+	return st.sid
+}
+
+// TimeCreated returns timeCreated property.
+func (st *SessionStore) TimeCreated() time.Time {
+	return st.timeCreated
+}
+
+// TimeAccessed returns timeAccessed property.
+func (st *SessionStore) TimeAccessed() time.Time {
+	return time.Unix(0, st.timeAccessed.Load())
+}
+
+// Lock acquires session lock. Lock is a row in session_locks table
+// with expiration time, it is released automatically after session.LockTTL().
+// Expired lock is deleted before a new one is inserted.
+// Values are reloaded from database after the lock is acquired.
+func (st *SessionStore) Lock() error {
+	token, err := genLockToken()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), session.LOCK_WAIT)
+	defer cancel()
+	for {
+		acquired, err := st.pder.tryLock(ctx, st.sid, token)
+		if err != nil {
+			if ctx.Err() != nil {
+				return session.ErrLockTimeout
+			}
+			return err
+		}
+		if acquired {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return session.ErrLockTimeout
+		case <-time.After(session.LOCK_RETRY):
+		}
+	}
+
+	st.mx.Lock()
+	st.lockToken = token
+	st.mx.Unlock()
+
+	return st.reload()
+}
+
+// Unlock releases session lock.
+func (st *SessionStore) Unlock() error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if st.lockToken == "" {
+		return nil
+	}
+	if _, err := st.pder.dbConn.ExecContext(context.Background(),
+		st.pder.query(`DELETE FROM session_locks WHERE id = $1 AND token = $2`),
+		st.sid, st.lockToken,
+	); err != nil {
+		return err
+	}
+	st.lockToken = ""
+	return nil
+}
+
+// SetExpiry sets session expiration time to now+d (rounded up to seconds), the session is removed by GC
+// after that time regardless of max life and idle time. d <= 0 restores defaults.
+func (st *SessionStore) SetExpiry(d time.Duration) error {
+	expires_at := "NULL"
+	if d > 0 {
+		expires_at = st.pder.dialect.AddSeconds(st.pder.dialect.Now(), int64((d+time.Second-1)/time.Second))
+	}
+	res, err := st.pder.dbConn.ExecContext(context.Background(),
+		st.pder.query(`UPDATE session_vals SET expires_at = `+expires_at+` WHERE id = $1`),
+		st.sid,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return session.ErrSessionNotFound
+	}
+	return nil
+}
+
+// Touch updates session access time in database,
+// nothing is done in session.EXPIRATION_FIXED mode.
+func (st *SessionStore) Touch() error {
+	if !st.pder.expMode.TouchOnWrite() {
+		return nil
+	}
+	res, err := st.pder.dbConn.ExecContext(context.Background(),
+		st.pder.query(`UPDATE session_vals SET accessed_time = `+st.pder.dialect.Now()+` WHERE id = $1`),
+		st.sid,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return session.ErrSessionNotFound
+	}
+	st.mx.Lock()
+	st.written()
+	st.mx.Unlock()
+	return nil
+}
+
+// Allow counts action in the current window of provider clock, see session.AllowRate().
+func (st *SessionStore) Allow(action string, limit int, window time.Duration) (bool, error) {
+	return session.AllowRate(st, st.pder.clock, action, limit, window)
+}
+
+// CountRate implements session.RateCounter, counters are kept in rates column in a transaction.
+func (st *SessionStore) CountRate(action string, now time.Time, window time.Duration) (int64, error) {
+	ctx := context.Background()
+	tx, err := st.pder.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := st.pder.lockForUpdate(ctx, tx, st.sid); err != nil {
+		return 0, err
+	}
+	var rates_b []byte
+	if err := tx.QueryRowContext(ctx, st.pder.query(`SELECT rates FROM session_vals WHERE id = $1`), st.sid).Scan(&rates_b); err != nil {
+		return 0, err
+	}
+	rates, err := session.DecodeRates(rates_b)
+	if err != nil {
+		return 0, err
+	}
+	cnt := rates.Count(action, now, window)
+	if rates_b, err = session.EncodeRates(rates); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, st.pder.query(`UPDATE session_vals SET rates = $1 WHERE id = $2`), rates_b, st.sid); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return cnt, nil
+}
+
+// SetSensitive sets value encrypted with keys of session.SetSensitiveKeys(), see session.SetSensitive().
+func (st *SessionStore) SetSensitive(key string, value interface{}) error {
+	return session.SetSensitive(st, key, value)
+}
+
+// lookup returns in-memory value by key under read lock and updates access time on success,
+// so concurrent readers do not serialize.
+func (st *SessionStore) lookup(key string) (interface{}, bool) {
+	st.mx.RLock()
+	v, ok := session.LookupValueAt(st.pder.clock.Now(), st.value, key)
+	st.mx.RUnlock()
+	if ok {
+		st.accessed()
+	}
+	return v, ok
+}
+
+// accessed updates in-memory access time if it is updated on every access.
+// The time is stored atomically, st.mx is not required.
+func (st *SessionStore) accessed() {
+	if st.pder.expMode.TouchOnRead() {
+		st.timeAccessed.Store(st.pder.clock.Now().UnixNano())
+	}
+}
+
+// written updates in-memory access time after session is written to database.
+func (st *SessionStore) written() {
+	if st.pder.expMode.TouchOnWrite() {
+		st.timeAccessed.Store(st.pder.clock.Now().UnixNano())
+	}
+}
+
+// reload reads values from database to memory.
+func (st *SessionStore) reload() error {
+	var val []byte
+	if err := st.pder.dbConn.QueryRowContext(context.Background(),
+		st.pder.query(`SELECT val FROM session_vals WHERE id = $1`),
+		st.sid).Scan(&val); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	value := make(storeValue)
+	if err := st.pder.setFromDb(&value, val); err != nil {
+		return err
+	}
+	st.mx.Lock()
+	st.value = value
+	st.valueModified = false
+	st.mx.Unlock()
+	return nil
+}
+
+// Provider structure holds provider information.
+type Provider struct {
+	dbConn         *sql.DB
+	dialect        Dialect
+	compression    *session.Compression    //payload compression, nil if not used
+	payloadVersion *session.PayloadVersion //payload versions, nil if not used
+	maxLifeTime    int64
+	maxIdleTime    int64
+	logger         *slog.Logger        //structured logger, nil if not set
+	expiredHook    session.SessionHook //called for sessions removed by SessionGC
+	gcLimit        int                 //max sessions removed by one SessionGC run, 0 if not limited
+
+	expMode session.ExpirationMode //when accessed_time is updated
+	strict  bool                   //unknown sessions are not created on read, see SetStrictMode()
+	clock   session.Clock          //expiration checked on read, value TTLs and lock times, see SetClock()
+
+	keyRing atomic.Pointer[session.KeyRing] //payload encryption, nil if not used
+}
+
+func (pder *Provider) NewSessionStore(sid string) *SessionStore {
+	store := &SessionStore{
+		pder:        pder,
+		sid:         sid,
+		timeCreated: pder.clock.Now(),
+		value:       make(map[string]interface{}, 0),
+	}
+	store.timeAccessed.Store(store.timeCreated.UnixNano())
+	return store
+}
+
+// SessionInit initializes session with given ID.
+func (pder *Provider) SessionInit(sid string) (session.Session, error) {
+	if pder.dbConn == nil {
+		return nil, session.ErrProviderNotInitialized
+	}
+
+	if len(sid) > SESS_ID_LEN {
+		return nil, fmt.Errorf("%w: length exceeds %d", session.ErrInvalidSessionID, SESS_ID_LEN)
+	}
+
+	if _, err := pder.dbConn.ExecContext(context.Background(), pder.insertSession(), sid); err != nil {
+		return nil, err
+	}
+	return pder.NewSessionStore(sid), nil
+}
+
+// SessionRead reads session data from db to memory.
+// Expired session is destroyed, session.ErrSessionExpired is returned then.
+func (pder *Provider) SessionRead(sid string) (session.Session, error) {
+	var val []byte
+
+	store := pder.NewSessionStore(sid)
+
+	var expires_at sql.NullTime
+	var accessed_time time.Time
+	if err := pder.dbConn.QueryRowContext(context.Background(),
+		pder.query(`SELECT accessed_time, create_time, expires_at, val FROM session_vals WHERE id = $1`),
+		sid).Scan(&accessed_time,
+		&store.timeCreated,
+		&expires_at,
+		&val,
+	); err != nil && err == sql.ErrNoRows {
+		//no such session
+		if pder.strict {
+			return nil, session.ErrSessionNotFound
+		}
+		return pder.SessionInit(sid)
+
+	} else if err != nil {
+		return nil, err
+	}
+	if session.IsExpiredAt(pder.clock.Now(), store.timeCreated, accessed_time, expires_at.Time, pder.maxLifeTime, pder.maxIdleTime) {
+		if err := pder.removeSessionFromDb(sid); err != nil {
+			return nil, err
+		}
+		return nil, session.ErrSessionExpired
+	}
+
+	if pder.expMode.TouchOnRead() {
+		if _, err := pder.dbConn.ExecContext(context.Background(),
+			pder.query(`UPDATE session_vals SET accessed_time = `+pder.dialect.Now()+` WHERE id = $1`),
+			sid,
+		); err != nil {
+			return nil, err
+		}
+		accessed_time = pder.clock.Now()
+	}
+	store.timeAccessed.Store(accessed_time.UnixNano())
+
+	if err := pder.setFromDb(&store.value, val); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (pder *Provider) SessionClose(sid string) error {
+	return nil
+}
+
+// SessionDestroy destoys session by its ID.
+func (pder *Provider) SessionDestroy(sid string) error {
+	if err := pder.removeSessionFromDb(sid); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SessionGC clears unused sessions.
+// Sessions with expiration time set by SessionStore.SetExpiry() are removed after that time only.
+func (pder *Provider) SessionGC(l io.Writer, logLev session.LogLevel) session.GCReport {
+	report, _ := pder.sessionGC(pder.getLogger(l, logLev))
+	return report
+}
+
+// CollectGarbage implements session.ProviderV2, errors of GC queries are returned joined.
+func (pder *Provider) CollectGarbage() (int, error) {
+	report, err := pder.sessionGC(pder.getLogger(nil, session.LOG_LEVEL_ERROR))
+	return report.Deleted(), err
+}
+
+// sessionGC clears unused sessions, errors are logged and returned joined.
+func (pder *Provider) sessionGC(log *slog.Logger) (session.GCReport, error) {
+	log = log.With(session.LOG_KEY_OPERATION, "SessionGC")
+	start := time.Now()
+	var report session.GCReport
+	var errs []error
+	defer func() {
+		log.Debug(LOG_PREF+"SessionGC() done", session.LOG_KEY_COUNT, report.Deleted(), session.LOG_KEY_DURATION, time.Since(start))
+	}()
+
+	now := pder.dialect.Now()
+	if cnt, err := pder.gcDelete(
+		`expires_at IS NOT NULL AND expires_at <= `+now, pder.gcLimit,
+	); err != nil {
+		report.Errors++
+		errs = append(errs, err)
+		log.Error(LOG_PREF+"gcDelete() failed on expires_at", session.LOG_KEY_ERROR, err)
+	} else {
+		report.DeletedExpiry = cnt
+		log.Debug(LOG_PREF+"expired sessions deleted", session.LOG_KEY_COUNT, cnt)
+	}
+
+	//inactive sessions
+	if limit, ok := pder.gcRemaining(report); ok && pder.maxIdleTime > 0 {
+		if cnt, err := pder.gcDelete(
+			`expires_at IS NULL AND `+pder.dialect.AddSeconds("accessed_time", pder.maxIdleTime)+` <= `+now, limit,
+		); err != nil {
+			report.Errors++
+			errs = append(errs, err)
+			log.Error(LOG_PREF+"gcDelete() failed on accessed_time", session.LOG_KEY_ERROR, err)
+		} else {
+			report.DeletedIdle = cnt
+			log.Debug(LOG_PREF+"idle sessions deleted", session.LOG_KEY_COUNT, cnt)
+		}
+	}
+
+	if limit, ok := pder.gcRemaining(report); ok && pder.maxLifeTime > 0 {
+		if cnt, err := pder.gcDelete(
+			`expires_at IS NULL AND `+pder.dialect.AddSeconds("create_time", pder.maxLifeTime)+` <= `+now, limit,
+		); err != nil {
+			report.Errors++
+			errs = append(errs, err)
+			log.Error(LOG_PREF+"gcDelete() failed on create_time", session.LOG_KEY_ERROR, err)
+		} else {
+			report.DeletedLifetime = cnt
+			log.Debug(LOG_PREF+"sessions with life time elapsed deleted", session.LOG_KEY_COUNT, cnt)
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// gcRemaining returns max number of sessions the next GC query may remove, 0 if not limited.
+// ok is false if GC limit is reached.
+func (pder *Provider) gcRemaining(report session.GCReport) (limit int, ok bool) {
+	if pder.gcLimit <= 0 {
+		return 0, true
+	}
+	limit = pder.gcLimit - report.Deleted()
+	return limit, limit > 0
+}
+
+// gcDelete deletes sessions matching where condition, no more than limit sessions if limit > 0.
+// Matching IDs are selected first and every session is deleted if it still matches the condition,
+// as DELETE ... RETURNING is not supported by all engines. Expired hook is called for every deleted session.
+func (pder *Provider) gcDelete(where string, limit int) (int, error) {
+	query := `SELECT id FROM session_vals WHERE ` + where
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	ctx := context.Background()
+	rows, err := pder.dbConn.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	sids := make([]string, 0)
+	for rows.Next() {
+		var sid string
+		if err := rows.Scan(&sid); err != nil {
+			return 0, err
+		}
+		sids = append(sids, sid)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	cnt := 0
+	for _, sid := range sids {
+		res, err := pder.dbConn.ExecContext(ctx, pder.query(`DELETE FROM session_vals WHERE id = $1 AND `+where), sid)
+		if err != nil {
+			return cnt, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return cnt, err
+		} else if n > 0 {
+			cnt++
+			pder.sessionExpired(sid)
+		}
+	}
+	return cnt, nil
+}
+
+// SetGCMaxDeletions implements session.GCLimiter.
+func (pder *Provider) SetGCMaxDeletions(maxDeletions int) {
+	pder.gcLimit = maxDeletions
+}
+
+// SetExpirationMode implements session.ExpirationModeSetter.
+func (pder *Provider) SetExpirationMode(mode session.ExpirationMode) {
+	pder.expMode = mode
+}
+
+// SetStrictMode implements session.StrictModeSetter: SessionRead() returns session.ErrSessionNotFound
+// for an unknown session instead of creating it.
+func (pder *Provider) SetStrictMode(strict bool) error {
+	pder.strict = strict
+	return nil
+}
+
+// SetClock implements session.ClockSetter, expiration checked on read, value TTLs, rate windows
+// and lock times are of the clock. Times stored with the session and checked by GC are set
+// by database with Dialect.Now(). Nil restores session.SystemClock.
+func (pder *Provider) SetClock(clock session.Clock) {
+	if clock == nil {
+		clock = session.SystemClock{}
+	}
+	pder.clock = clock
+}
+
+// accessedTime returns SQL expression for accessed_time column on session read or write,
+// column is not changed if expiration mode does not update access time.
+func (pder *Provider) accessedTime(read bool) string {
+	if read && pder.expMode.TouchOnRead() || !read && pder.expMode.TouchOnWrite() {
+		return pder.dialect.Now()
+	}
+	return "accessed_time"
+}
+
+// query returns query with placeholders of the dialect.
+func (pder *Provider) query(query string) string {
+	return rebind(pder.dialect, query)
+}
+
+// insertSession returns query inserting session with ID as the first parameter if it does not exist.
+func (pder *Provider) insertSession() string {
+	now := pder.dialect.Now()
+	return pder.query(pder.dialect.InsertIgnore("session_vals",
+		[]string{"id", "accessed_time", "create_time"},
+		[]string{"$1", now, now},
+	))
+}
+
+// tryLock inserts session lock row with token, lock expired before is deleted first.
+// acquired is false if the session is locked by another owner.
+func (pder *Provider) tryLock(ctx context.Context, sid, token string) (acquired bool, err error) {
+	now := pder.clock.Now()
+	if _, err := pder.dbConn.ExecContext(ctx,
+		pder.query(`DELETE FROM session_locks WHERE id = $1 AND lock_till < $2`),
+		sid, now.UnixMilli(),
+	); err != nil {
+		return false, err
+	}
+	res, err := pder.dbConn.ExecContext(ctx,
+		pder.query(pder.dialect.InsertIgnore("session_locks",
+			[]string{"id", "token", "lock_till"},
+			[]string{"$1", "$2", "$3"},
+		)),
+		sid, token, now.Add(session.LockTTL()).UnixMilli(),
+	)
+	if err != nil {
+		return false, err
+	}
+	cnt, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return cnt > 0, nil
+}
+
+// readForUpdate returns decoded session value within transaction tx. Session row is written first
+// to take database write lock before reading, it is created if it does not exist.
+func (pder *Provider) readForUpdate(ctx context.Context, tx *sql.Tx, sid string) (storeValue, error) {
+	if err := pder.lockForUpdate(ctx, tx, sid); err != nil {
+		return nil, err
+	}
+	var val []byte
+	if err := tx.QueryRowContext(ctx, pder.query(`SELECT val FROM session_vals WHERE id = $1`), sid).Scan(&val); err != nil {
+		return nil, err
+	}
+	db_value := make(storeValue)
+	if err := pder.setFromDb(&db_value, val); err != nil {
+		return nil, err
+	}
+	return db_value, nil
+}
+
+// lockForUpdate creates session row if it does not exist and updates its access time,
+// so the row is locked till tx ends.
+func (pder *Provider) lockForUpdate(ctx context.Context, tx *sql.Tx, sid string) error {
+	if _, err := tx.ExecContext(ctx, pder.insertSession(), sid); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx,
+		pder.query(`UPDATE session_vals SET accessed_time = `+pder.accessedTime(false)+` WHERE id = $1`),
+		sid,
+	)
+	return err
+}
+
+// writeValue encodes and writes session value within transaction tx.
+func (pder *Provider) writeValue(ctx context.Context, tx *sql.Tx, sid string, value storeValue) error {
+	val, err := pder.getForDb(&value)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, pder.query(`UPDATE session_vals SET val = $1 WHERE id = $2`), val, sid)
+	return err
+}
+
+// SessionCount returns number of sessions in session_vals table.
+func (pder *Provider) SessionCount() (int, error) {
+	var cnt int
+	if err := pder.dbConn.QueryRowContext(context.Background(),
+		`SELECT count(*) FROM session_vals`,
+	).Scan(&cnt); err != nil {
+		return 0, err
+	}
+	return cnt, nil
+}
+
+// SessionList returns session metadata ordered by session ID.
+func (pder *Provider) SessionList(offset, limit int) ([]session.SessionMeta, error) {
+	var query_limit int64 = int64(limit)
+	if limit == 0 {
+		query_limit = math.MaxInt64 //no limit, LIMIT clause is required by OFFSET
+	}
+	rows, err := pder.dbConn.QueryContext(context.Background(),
+		pder.query(`SELECT id, create_time, accessed_time
+		FROM session_vals
+		ORDER BY id
+		LIMIT $1 OFFSET $2`),
+		query_limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]session.SessionMeta, 0)
+	for rows.Next() {
+		var meta session.SessionMeta
+		if err := rows.Scan(&meta.ID, &meta.TimeCreated, &meta.TimeAccessed); err != nil {
+			return nil, err
+		}
+		list = append(list, meta)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// SessionMeta implements session.MetaProvider, session values are not decoded.
+func (pder *Provider) SessionMeta(sid string) (session.SessionMeta, error) {
+	meta := session.SessionMeta{ID: sid, Provider: PROVIDER}
+	var expires_at sql.NullTime
+	if err := pder.dbConn.QueryRowContext(context.Background(),
+		pder.query(`SELECT create_time, accessed_time, expires_at, coalesce(length(val), 0)
+		FROM session_vals
+		WHERE id = $1`),
+		sid).Scan(&meta.TimeCreated, &meta.TimeAccessed, &expires_at, &meta.Size); err == sql.ErrNoRows {
+		return session.SessionMeta{}, session.ErrSessionNotFound
+
+	} else if err != nil {
+		return session.SessionMeta{}, err
+	}
+	meta.ExpiresAt = expires_at.Time
+	return meta, nil
+}
+
+// SessionPeek implements session.PeekProvider: session values are read
+// without updating access time, missing session is not created, expired one is not removed.
+func (pder *Provider) SessionPeek(sid string) (session.Session, error) {
+	var val []byte
+	store := pder.NewSessionStore(sid)
+	var expires_at sql.NullTime
+	var accessed_time time.Time
+	if err := pder.dbConn.QueryRowContext(context.Background(),
+		pder.query(`SELECT accessed_time, create_time, expires_at, val FROM session_vals WHERE id = $1`),
+		sid).Scan(&accessed_time,
+		&store.timeCreated,
+		&expires_at,
+		&val,
+	); err == sql.ErrNoRows {
+		return nil, session.ErrSessionNotFound
+
+	} else if err != nil {
+		return nil, err
+	}
+	if session.IsExpiredAt(pder.clock.Now(), store.timeCreated, accessed_time, expires_at.Time, pder.maxLifeTime, pder.maxIdleTime) {
+		return nil, session.ErrSessionExpired
+	}
+	store.timeAccessed.Store(accessed_time.UnixNano())
+	if err := pder.setFromDb(&store.value, val); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// SessionDestroyMany destroys sessions in one transaction.
+func (pder *Provider) SessionDestroyMany(sids []string) error {
+	ctx := context.Background()
+	tx, err := pder.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, pder.query(`DELETE FROM session_vals WHERE id = $1`))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, sid := range sids {
+		if _, err := stmt.ExecContext(ctx, sid); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (pder *Provider) DestroyAllSessions(l io.Writer, logLev session.LogLevel) {
+	log := pder.getLogger(l, logLev).With(session.LOG_KEY_OPERATION, "DestroyAllSessions")
+	start := time.Now()
+	cnt, err := pder.DestroyAll()
+	if err != nil {
+		log.Error(LOG_PREF+"Exec() failed on DELETE FROM session_vals", session.LOG_KEY_ERROR, err)
+		return
+	}
+	log.Debug(LOG_PREF+"DestroyAllSessions() done", session.LOG_KEY_COUNT, cnt, session.LOG_KEY_DURATION, time.Since(start))
+}
+
+// DestroyAll implements session.ProviderV2, returns number of destroyed sessions.
+func (pder *Provider) DestroyAll() (int, error) {
+	res, err := pder.dbConn.ExecContext(context.Background(), `DELETE FROM session_vals`)
+	if err != nil {
+		return 0, err
+	}
+	cnt, err := res.RowsAffected()
+	return int(cnt), err
+}
+
+// SetExpiredHook sets callback for sessions removed by SessionGC.
+func (pder *Provider) SetExpiredHook(hook session.SessionHook) {
+	pder.expiredHook = hook
+}
+
+// sessionExpired calls expired hook if it is set.
+func (pder *Provider) sessionExpired(sid string) {
+	if pder.expiredHook != nil {
+		pder.expiredHook(sid)
+	}
+}
+
+// SetLogger sets structured logger.
+func (pder *Provider) SetLogger(logger *slog.Logger) {
+	pder.logger = logger
+}
+
+// getLogger returns provider logger or io.Writer adapter if logger is not set.
+func (pder *Provider) getLogger(l io.Writer, logLev session.LogLevel) *slog.Logger {
+	return session.LoggerFor(pder.logger, l, logLev).With(session.LOG_KEY_PROVIDER, PROVIDER)
+}
+
+func (pder *Provider) SetMaxLifeTime(maxLifeTime int64) {
+	pder.maxLifeTime = maxLifeTime
+}
+func (pder *Provider) GetMaxLifeTime() int64 {
+	return pder.maxLifeTime
+}
+
+func (pder *Provider) SetMaxIdleTime(maxIdleTime int64) {
+	pder.maxIdleTime = maxIdleTime
+}
+
+func (pder *Provider) GetMaxIdleTime() int64 {
+	return pder.maxIdleTime
+}
+
+// SetKeyRing sets keys for payload encryption.
+func (pder *Provider) SetKeyRing(keyRing *session.KeyRing) {
+	pder.keyRing.Store(keyRing)
+}
+
+// SetCompression sets payload compression, nil disables it.
+func (pder *Provider) SetCompression(compression *session.Compression) {
+	pder.compression = compression
+}
+
+// SetPayloadVersion sets payload version, nil disables versions.
+func (pder *Provider) SetPayloadVersion(payloadVersion *session.PayloadVersion) {
+	pder.payloadVersion = payloadVersion
+}
+
+// InitProvider initializes generic database/sql provider.
+// Function expects parameters:
+//
+//	First parameter: *sql.DB, opened connection pool owned by the caller.
+//	Second parameter: Dialect of the database engine, e.g. SQLiteDialect{}.
+//	Third parameter (optional): encryptKey string, if set session payload is encrypted with AES-GCM.
+func (pder *Provider) InitProvider(provParams []interface{}) error {
+	if len(provParams) < 2 {
+		return errors.New("InitProvider missing parameters: *sql.DB, Dialect")
+	}
+	var ok bool
+	pder.dbConn, ok = provParams[0].(*sql.DB)
+	if !ok || pder.dbConn == nil {
+		return errors.New("InitProvider db parameter(0) must be of type *sql.DB")
+	}
+
+	pder.dialect, ok = provParams[1].(Dialect)
+	if !ok || pder.dialect == nil {
+		return errors.New("InitProvider dialect parameter(1) must implement Dialect")
+	}
+
+	if len(provParams) >= 3 {
+		encrkey, ok := provParams[2].(string)
+		if !ok {
+			return errors.New("InitProvider encryptKey parameter(2) must be a string")
+		}
+		if encrkey != "" {
+			key_ring, err := session.NewKeyRing([]byte(encrkey))
+			if err != nil {
+				return err
+			}
+			pder.keyRing.Store(key_ring)
+		}
+	}
+
+	return nil
+}
+
+// CloseProvider does nothing, *sql.DB is owned by the caller.
+func (pder *Provider) CloseProvider() error {
+	return nil
+}
+
+// Ping checks database connection with SELECT 1.
+func (pder *Provider) Ping(ctx context.Context) error {
+	if pder.dbConn == nil {
+		return session.ErrProviderNotInitialized
+	}
+	var one int
+	return pder.dbConn.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+}
+
+// EnsureSchema creates session_vals and session_locks tables with indexes if they do not exist,
+// statements are returned by Dialect.Schema().
+func (pder *Provider) EnsureSchema(ctx context.Context) error {
+	if pder.dbConn == nil {
+		return session.ErrProviderNotInitialized
+	}
+	for _, stmt := range pder.dialect.Schema() {
+		if _, err := pder.dbConn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pder *Provider) removeSessionFromDb(sid string) error {
+	if _, err := pder.dbConn.ExecContext(context.Background(), pder.query(`DELETE FROM session_vals WHERE id = $1`), sid); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (pder *Provider) GetSessionIDLen() int {
+	return SESS_ID_LEN
+}
+
+// genLockToken returns random lock owner token.
+func genLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// setFromDb is a helper function, called on retrieving value from data base.
+// It decrypts, decompresses and decodes data base value for in-memory store.
+func (pder *Provider) setFromDb(strucVal *storeValue, dbVal []byte) error {
+	if len(dbVal) == 0 {
+		return nil
+	}
+	var err error
+	if key_ring := pder.keyRing.Load(); key_ring != nil {
+		if dbVal, err = key_ring.DecryptPayload(dbVal); err != nil {
+			return err
+		}
+	}
+	if dbVal, err = session.Decompress(dbVal); err != nil {
+		return err
+	}
+	if err := pder.payloadVersion.Decode(dbVal, (*map[string]interface{})(strucVal)); err != nil {
+		return err
+	}
+	session.PurgeExpiredValuesAt(pder.clock.Now(), *strucVal) //values set with SetWithTTL() are filtered on read
+	return nil
+}
+
+// getForDb is a helper function called before putting value to database.
+// It encodes, compresses and encrypts in-memory session value for data base.
+func (pder *Provider) getForDb(strucVal *storeValue) ([]byte, error) {
+	val, err := pder.payloadVersion.Encode(strucVal)
+	if err != nil {
+		return []byte{}, err
+	}
+	if pder.compression != nil {
+		if val, err = pder.compression.Compress(val); err != nil {
+			return nil, err
+		}
+	}
+	if key_ring := pder.keyRing.Load(); key_ring != nil {
+		return key_ring.Encrypt(val)
+	}
+	return val, nil
+}
+
+func init() {
+	session.Register(PROVIDER, pder)
+}
@@ -0,0 +1,125 @@
+// testing functions for session/sqlstore.
+// Testing uses sqlite database file with SQLiteDialect.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dronm/session" //session manager
+	"github.com/dronm/session/testkit"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	SQLITE_FILENAME = "test.db"
+)
+
+// NewManager opens test database, creates schema and returns manager of a new provider instance.
+func NewManager(t *testing.T, maxLifeTime int64, maxIdleTime int64, killTime string) (*session.Manager, error) {
+	db, err := sql.Open("sqlite3", SQLITE_FILENAME)
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.Remove(SQLITE_FILENAME)
+	})
+	prov := NewProvider()
+	manager, err := session.NewManagerWithProvider(prov, maxLifeTime, maxIdleTime, killTime, db, SQLiteDialect{})
+	if err != nil {
+		return nil, err
+	}
+	if err := prov.EnsureSchema(context.Background()); err != nil {
+		t.Fatalf("EnsureSchema() failed: %v", err)
+	}
+	return manager, nil
+}
+
+func TestSession(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer SessManager.CloseProvider()
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+
+	tests := testkit.NewTestValues()
+	testkit.PutValues(t, currentSession, tests)
+	if err := SessManager.SessionClose(sid); err != nil {
+		t.Fatalf("SessionClose() failed: %v", err)
+	}
+
+	currentSession, err = SessManager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	testkit.CompareValues(t, currentSession, tests)
+
+	if cnt, err := currentSession.Increment("cnt", 2); err != nil || cnt != 2 {
+		t.Fatalf("Increment() = %d, %v, expected 2", cnt, err)
+	}
+	if err := currentSession.Lock(); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	if err := currentSession.Unlock(); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+	if err := SessManager.SessionDestroy(sid); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+}
+
+// TestExpiry checks that SetExpiry() is handled with dialect time arithmetic by GC.
+func TestExpiry(t *testing.T) {
+	SessManager, err := NewManager(t, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	defer SessManager.CloseProvider()
+
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	if err := currentSession.SetExpiry(time.Second); err != nil {
+		t.Fatalf("SetExpiry() failed: %v", err)
+	}
+	if cnt, err := SessManager.CollectGarbage(); err != nil || cnt != 0 {
+		t.Fatalf("CollectGarbage() = %d, %v, expected 0 before expiry", cnt, err)
+	}
+	time.Sleep(2100 * time.Millisecond)
+	if cnt, err := SessManager.CollectGarbage(); err != nil || cnt != 1 {
+		t.Fatalf("CollectGarbage() = %d, %v, expected 1 after expiry", cnt, err)
+	}
+}
+
+// TestRebind checks placeholders of built-in dialects.
+func TestRebind(t *testing.T) {
+	const query = `UPDATE t SET a = $1, b = '$' WHERE id = $2`
+	if got := rebind(PostgresDialect{}, query); got != query {
+		t.Fatalf("rebind() of postgres = %q, expected %q", got, query)
+	}
+	if got, expected := rebind(MySQLDialect{}, query), `UPDATE t SET a = ?, b = '$' WHERE id = ?`; got != expected {
+		t.Fatalf("rebind() of mysql = %q, expected %q", got, expected)
+	}
+}
+
+// TestConformance runs testkit conformance suite.
+func TestConformance(t *testing.T) {
+	testkit.Run(t, func(t *testing.T, maxLifeTime, maxIdleTime int64) *session.Manager {
+		manager, err := NewManager(t, maxLifeTime, maxIdleTime, "")
+		if err != nil {
+			t.Fatalf("NewManager() failed: %v", err)
+		}
+		return manager
+	})
+}
@@ -0,0 +1,165 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// STATS_SAMPLE_SIZE is a max number of sessions distributions of Stats() are computed of.
+const STATS_SAMPLE_SIZE = 10000
+
+// Distribution holds percentiles of session sizes or times.
+type Distribution[T ~int64] struct {
+	Min T
+	P50 T
+	P90 T
+	P99 T
+	Max T
+}
+
+// SessionStats holds aggregate numbers of stored sessions for capacity planning, see Stats().
+type SessionStats struct {
+	Provider     string
+	Count        int                         //stored sessions, expired ones not yet removed by GC included
+	Sampled      int                         //sessions distributions are computed of, equals Count if all are read
+	TotalSize    int64                       //sum of payload sizes in bytes, estimated from the sample if Sampled < Count
+	Size         Distribution[int64]         //stored payload size in bytes
+	Age          Distribution[time.Duration] //time since creation
+	Idle         Distribution[time.Duration] //time since last access
+	StorageBytes int64                       //storage used by the provider, e.g. database file, table or memory, 0 if unknown
+	Providers    []SessionStats              //inner providers of replicated, sharded and fallback providers
+}
+
+// StatsProvider is an optional interface for providers computing statistics efficiently,
+// e.g. with SQL aggregates. Distributions may be computed of at most sampleSize sessions.
+type StatsProvider interface {
+	SessionStats(ctx context.Context, sampleSize int) (SessionStats, error)
+}
+
+// Stats returns aggregate numbers of stored sessions: count, payload size, age and idle time
+// distributions and storage usage. Providers implementing StatsProvider compute them on the server side,
+// for other providers metadata of at most STATS_SAMPLE_SIZE sessions is read with ListSessions()
+// and SessionMeta(). Provider must implement StatsProvider or AdminProvider interface.
+func (manager *Manager) Stats(ctx context.Context) (SessionStats, error) {
+	stats, err := providerStats(ctx, manager.provider, STATS_SAMPLE_SIZE, manager.clock)
+	if err != nil {
+		return SessionStats{}, err
+	}
+	if stats.Provider == "" {
+		stats.Provider = manager.providerName
+	}
+	return stats, nil
+}
+
+// ProviderStats returns statistics of provider implementing StatsProvider or AdminProvider interface,
+// at most sampleSize sessions are read. It is a helper for provider decorators.
+func ProviderStats(ctx context.Context, p Provider, sampleSize int) (SessionStats, error) {
+	return providerStats(ctx, p, sampleSize, SystemClock{})
+}
+
+// providerStats returns statistics of provider, ages and idle times of sampled sessions are of clock.
+func providerStats(ctx context.Context, p Provider, sampleSize int, clock Clock) (SessionStats, error) {
+	if stats_pder, ok := p.(StatsProvider); ok {
+		return stats_pder.SessionStats(ctx, sampleSize)
+	}
+	adm_pder, ok := p.(AdminProvider)
+	if !ok {
+		return SessionStats{}, ErrNotAdminProvider
+	}
+	cnt, err := adm_pder.SessionCount()
+	if err != nil {
+		return SessionStats{}, err
+	}
+	//session IDs are random, so the first IDs in order are a random sample
+	list, err := adm_pder.SessionList(0, sampleSize)
+	if err != nil {
+		return SessionStats{}, err
+	}
+	sample := make([]SessionMeta, 0, len(list))
+	for _, meta := range list {
+		if err := ctx.Err(); err != nil {
+			return SessionStats{}, err
+		}
+		full_meta, err := sessionMeta(p, meta.ID)
+		if errors.Is(err, ErrSessionNotFound) {
+			continue //removed since listed
+		}
+		if errors.Is(err, ErrNoSessionMeta) {
+			full_meta, err = meta, nil //no sizes
+		}
+		if err != nil {
+			return SessionStats{}, err
+		}
+		sample = append(sample, full_meta)
+	}
+	if cnt < len(sample) {
+		cnt = len(sample)
+	}
+	return SampleStats(cnt, sample, clock.Now()), nil
+}
+
+// SampleStats returns statistics of count sessions computed of the sample of their metadata,
+// TotalSize is estimated if the sample is smaller than count. It is a helper for providers
+// implementing StatsProvider.
+func SampleStats(count int, sample []SessionMeta, now time.Time) SessionStats {
+	stats := SessionStats{Count: count, Sampled: len(sample)}
+	if len(sample) == 0 {
+		return stats
+	}
+	sizes := make([]int64, len(sample))
+	ages := make([]time.Duration, len(sample))
+	idles := make([]time.Duration, len(sample))
+	var total int64
+	for i, meta := range sample {
+		sizes[i] = int64(meta.Size)
+		total += sizes[i]
+		if !meta.TimeCreated.IsZero() {
+			ages[i] = now.Sub(meta.TimeCreated)
+		}
+		if !meta.TimeAccessed.IsZero() {
+			idles[i] = now.Sub(meta.TimeAccessed)
+		}
+	}
+	stats.TotalSize = total * int64(count) / int64(len(sample))
+	stats.Size = NewDistribution(sizes)
+	stats.Age = NewDistribution(ages)
+	stats.Idle = NewDistribution(idles)
+	return stats
+}
+
+// NewDistribution returns percentiles of values, which are sorted.
+func NewDistribution[T ~int64](values []T) Distribution[T] {
+	if len(values) == 0 {
+		return Distribution[T]{}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	at := func(p int) T {
+		return values[(len(values)-1)*p/100]
+	}
+	return Distribution[T]{Min: values[0], P50: at(50), P90: at(90), P99: at(99), Max: values[len(values)-1]}
+}
+
+// mergeStats returns stats of providers summing counts, sizes and storage,
+// distributions are of the provider with the largest sample as percentiles can not be merged.
+func mergeStats(list []SessionStats) SessionStats {
+	var stats SessionStats
+	largest := -1
+	for i, s := range list {
+		stats.Count += s.Count
+		stats.TotalSize += s.TotalSize
+		stats.StorageBytes += s.StorageBytes
+		if largest < 0 || s.Sampled > list[largest].Sampled {
+			largest = i
+		}
+	}
+	if largest >= 0 {
+		stats.Sampled = list[largest].Sampled
+		stats.Size = list[largest].Size
+		stats.Age = list[largest].Age
+		stats.Idle = list[largest].Idle
+	}
+	stats.Providers = list
+	return stats
+}
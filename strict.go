@@ -0,0 +1,43 @@
+package session
+
+import "errors"
+
+// StrictModeSetter is an optional interface for providers creating a session
+// when SessionRead() is called for an unknown ID.
+type StrictModeSetter interface {
+	//decorators return ErrNoStrictMode if an inner provider does not support strict mode
+	SetStrictMode(strict bool) error
+}
+
+// SetStrictMode disables creation of sessions on read of unknown IDs: in strict mode
+// SessionStart() returns ErrSessionNotFound for an ID without stored session, so expired and removed
+// sessions are not silently replaced and attacker supplied IDs are never created (session fixation).
+// New sessions are created with SessionStart("") only, Middleware() starts one on ErrSessionNotFound.
+// Decorators pass the mode to every inner provider.
+// Provider must implement StrictModeSetter interface, every inner provider of decorators as well,
+// ErrNoStrictMode is returned otherwise.
+func (manager *Manager) SetStrictMode(strict bool) error {
+	setter, ok := manager.provider.(StrictModeSetter)
+	if !ok {
+		return ErrNoStrictMode
+	}
+	return setter.SetStrictMode(strict)
+}
+
+// setStrictMode passes strict mode to providers. ErrNoStrictMode is returned
+// and the mode is not changed if any provider does not implement StrictModeSetter.
+func setStrictMode(strict bool, providers ...Provider) error {
+	setters := make([]StrictModeSetter, 0, len(providers))
+	for _, p := range providers {
+		setter, ok := p.(StrictModeSetter)
+		if !ok {
+			return ErrNoStrictMode
+		}
+		setters = append(setters, setter)
+	}
+	var errs []error
+	for _, setter := range setters {
+		errs = append(errs, setter.SetStrictMode(strict))
+	}
+	return errors.Join(errs...)
+}
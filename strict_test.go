@@ -0,0 +1,49 @@
+package session_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dronm/session"
+	"github.com/dronm/session/mock"
+)
+
+// noStrictProvider hides StrictModeSetter of the provider.
+type noStrictProvider struct {
+	session.Provider
+}
+
+// TestStrictModeDecorators checks that decorators return ErrNoStrictMode
+// if an inner provider does not support strict mode.
+func TestStrictModeDecorators(t *testing.T) {
+	pder := mock.NewProvider()
+	other := mock.NewProvider() //decorated with unsupported provider
+	unsupported := noStrictProvider{mock.NewProvider()}
+	tests := []struct {
+		name  string
+		pder  session.Provider
+		valid bool
+	}{
+		{"sharded", session.NewShardedProvider(pder, mock.NewProvider()), true},
+		{"sharded unsupported", session.NewShardedProvider(other, unsupported), false},
+		{"replicated unsupported", session.NewReplicatedProvider(1, other, unsupported), false},
+		{"fallback unsupported", session.NewFallbackProvider(other, unsupported, 0), false},
+		{"retry unsupported", session.WithRetry(unsupported, 1, nil), false},
+		{"cached of sharded unsupported", session.NewCachedProvider(session.NewShardedProvider(other, unsupported), 0, 0), false},
+	}
+	for _, tt := range tests {
+		err := tt.pder.(session.StrictModeSetter).SetStrictMode(true)
+		if tt.valid && err != nil {
+			t.Fatalf("%s: SetStrictMode() failed: %v", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, session.ErrNoStrictMode) {
+			t.Fatalf("%s: SetStrictMode() wanted ErrNoStrictMode, got %v", tt.name, err)
+		}
+	}
+	if _, err := pder.SessionRead("unknown"); !errors.Is(err, session.ErrSessionNotFound) {
+		t.Fatalf("strict mode is not set, SessionRead() error is %v", err)
+	}
+	if _, err := other.SessionRead("unknown"); err != nil {
+		t.Fatalf("strict mode is set with unsupported provider, SessionRead() error is %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+// Package testkit contains helpers for testing session providers.
+package testkit
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/dronm/session"
+)
+
+// CONCURRENT_COUNTER is a session key incremented by ConcurrentAccess().
+const CONCURRENT_COUNTER = "testkit_counter"
+
+// ConcurrentAccess uses session s from goroutines concurrently, each one
+// sets, reads, deletes its own values, lists keys, increments a shared counter
+// and flushes the session iterations times. Run it with -race to detect
+// unsynchronized access of the provider session store.
+// The counter is checked to be incremented by every goroutine.
+func ConcurrentAccess(t testing.TB, s session.Session, goroutines, iterations int) {
+	t.Helper()
+
+	if err := s.Delete(CONCURRENT_COUNTER); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			if err := concurrentUse(s, g, iterations); err != nil {
+				errs <- err
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	if got, want := s.GetInt(CONCURRENT_COUNTER), int64(goroutines*iterations); got != want {
+		t.Fatalf("expected %s=%d, got %d", CONCURRENT_COUNTER, want, got)
+	}
+}
+
+func concurrentUse(s session.Session, g, iterations int) error {
+	key := fmt.Sprintf("testkit_%d", g)
+	tmp_key := key + "_tmp"
+	for i := 0; i < iterations; i++ {
+		if err := s.Set(key, i); err != nil {
+			return fmt.Errorf("goroutine %d: Set() failed: %v", g, err)
+		}
+		if got := s.GetInt(key); got != int64(i) {
+			return fmt.Errorf("goroutine %d: expected %s=%d, got %d", g, key, i, got)
+		}
+		if err := s.Set(tmp_key, fmt.Sprint(i)); err != nil {
+			return fmt.Errorf("goroutine %d: Set() failed: %v", g, err)
+		}
+		var v string
+		if err := s.Get(tmp_key, &v); err != nil {
+			return fmt.Errorf("goroutine %d: Get() failed: %v", g, err)
+		}
+		if _, err := s.Keys(); err != nil {
+			return fmt.Errorf("goroutine %d: Keys() failed: %v", g, err)
+		}
+		if err := s.Delete(tmp_key); err != nil {
+			return fmt.Errorf("goroutine %d: Delete() failed: %v", g, err)
+		}
+		if _, err := s.Increment(CONCURRENT_COUNTER, 1); err != nil {
+			return fmt.Errorf("goroutine %d: Increment() failed: %v", g, err)
+		}
+		if err := s.Flush(); err != nil {
+			return fmt.Errorf("goroutine %d: Flush() failed: %v", g, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package testkit
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/dronm/session"
+)
+
+// NewManagerFunc returns a manager of the provider tested with empty storage,
+// maxLifeTime and maxIdleTime are in seconds. Storage objects must exist, e.g. database schema.
+// Run() closes the manager with CloseProvider().
+type NewManagerFunc func(t *testing.T, maxLifeTime, maxIdleTime int64) *session.Manager
+
+// Run runs conformance suite of a provider, every check is a subtest
+// which can be selected with -run, e.g. -run 'TestConformance/Codec':
+//   - Lifecycle: start, flush, close, reopen, delete values and destroy a session.
+//   - Concurrency: ConcurrentAccess() of one session, run it with -race.
+//   - Expiry: an idle session is expired on read.
+//   - GC: SessionGC() removes only expired sessions, provider must implement session.AdminProvider.
+//   - DestroyAll: DestroyAllSessions() removes all sessions.
+//   - Codec: values of different types survive encoding by the provider.
+//   - RateLimit: Session.Allow() counts actions in a window, Clear() does not reset counters.
+//
+// Third-party providers run it from their tests:
+//
+//	func TestConformance(t *testing.T) {
+//		testkit.Run(t, func(t *testing.T, maxLifeTime, maxIdleTime int64) *session.Manager {
+//			manager, err := session.NewManager(PROVIDER, maxLifeTime, maxIdleTime, "", params...)
+//			if err != nil {
+//				t.Fatalf("NewManager() failed: %v", err)
+//			}
+//			return manager
+//		})
+//	}
+func Run(t *testing.T, newManager NewManagerFunc) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, newManager NewManagerFunc)
+	}{
+		{"Lifecycle", testLifecycle},
+		{"Concurrency", testConcurrency},
+		{"Expiry", testExpiry},
+		{"GC", testGC},
+		{"DestroyAll", testDestroyAll},
+		{"Codec", testCodec},
+		{"RateLimit", testRateLimit},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fn(t, newManager)
+		})
+	}
+}
+
+// startManager returns a manager closed on test cleanup.
+func startManager(t *testing.T, newManager NewManagerFunc, maxLifeTime, maxIdleTime int64) *session.Manager {
+	manager := newManager(t, maxLifeTime, maxIdleTime)
+	t.Cleanup(func() { manager.CloseProvider() })
+	return manager
+}
+
+func startSession(t *testing.T, manager *session.Manager, sid string) session.Session {
+	t.Helper()
+	sess, err := manager.SessionStart(sid)
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	return sess
+}
+
+func closeSession(t *testing.T, manager *session.Manager, sid string) {
+	t.Helper()
+	if err := manager.SessionClose(sid); err != nil {
+		t.Fatalf("SessionClose() failed: %v", err)
+	}
+}
+
+// assertDestroyed checks that session sid is either reported expired or started empty.
+func assertDestroyed(t *testing.T, manager *session.Manager, sid string) {
+	t.Helper()
+	sess, err := manager.SessionStart(sid)
+	if errors.Is(err, session.ErrSessionExpired) {
+		sess, err = manager.SessionStart(sid)
+	}
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	defer manager.SessionDestroy(sid)
+	if n, err := sess.Len(); err != nil || n != 0 {
+		t.Fatalf("Session: %s is not destroyed, Len()=%d, err: %v", sid, n, err)
+	}
+}
+
+func testLifecycle(t *testing.T, newManager NewManagerFunc) {
+	manager := startManager(t, newManager, 0, 0)
+
+	sess := startSession(t, manager, "")
+	sid := sess.SessionID()
+	if sid == "" {
+		t.Fatalf("SessionID() is empty")
+	}
+	tests := NewTestValues()
+	PutValues(t, sess, tests)
+	CompareValues(t, sess, tests)
+	closeSession(t, manager, sid)
+
+	sess = startSession(t, manager, sid)
+	if sess.SessionID() != sid {
+		t.Fatalf("SessionStart() wanted session %s, got %s", sid, sess.SessionID())
+	}
+	CompareValues(t, sess, tests)
+	if err := sess.Delete("stringVal"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if err := sess.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	closeSession(t, manager, sid)
+
+	sess = startSession(t, manager, sid)
+	if sess.GetString("stringVal") != "" {
+		t.Fatalf("deleted value is read after reopening")
+	}
+	if keys, err := sess.Keys(); err != nil || len(keys) != len(tests)-1 {
+		t.Fatalf("Keys() wanted %d keys, got %v, err: %v", len(tests)-1, keys, err)
+	}
+	if err := manager.SessionDestroy(sid); err != nil {
+		t.Fatalf("SessionDestroy() failed: %v", err)
+	}
+
+	sess = startSession(t, manager, sid)
+	defer manager.SessionDestroy(sid)
+	AssertNoValues(t, sess, tests)
+}
+
+func testConcurrency(t *testing.T, newManager NewManagerFunc) {
+	const goroutines, iterations = 8, 20
+
+	manager := startManager(t, newManager, 0, 0)
+	sess := startSession(t, manager, "")
+	sid := sess.SessionID()
+	defer manager.SessionDestroy(sid)
+
+	ConcurrentAccess(t, sess, goroutines, iterations)
+	closeSession(t, manager, sid)
+
+	sess = startSession(t, manager, sid)
+	if got := sess.GetInt(CONCURRENT_COUNTER); got != goroutines*iterations {
+		t.Fatalf("expected %s=%d after reopening, got %d", CONCURRENT_COUNTER, goroutines*iterations, got)
+	}
+}
+
+func testExpiry(t *testing.T, newManager NewManagerFunc) {
+	var idle_time int64 = 1
+	manager := startManager(t, newManager, 0, idle_time)
+
+	sess := startSession(t, manager, "")
+	sid := sess.SessionID()
+	if err := sess.Put("key", "value"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	closeSession(t, manager, sid)
+
+	time.Sleep(time.Duration(idle_time+1) * time.Second)
+	assertDestroyed(t, manager, sid)
+}
+
+func testGC(t *testing.T, newManager NewManagerFunc) {
+	manager := startManager(t, newManager, 0, 0)
+	if _, err := manager.ListSessions(0, 0); errors.Is(err, session.ErrNotAdminProvider) {
+		t.Skip("provider does not list sessions")
+	}
+
+	short_sess := startSession(t, manager, "")
+	if err := short_sess.SetExpiry(time.Second); err != nil {
+		t.Fatalf("SetExpiry() failed: %v", err)
+	}
+	long_sess := startSession(t, manager, "")
+	defer manager.SessionDestroy(long_sess.SessionID())
+	if err := long_sess.Put("key", "value"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	time.Sleep(2 * time.Second)
+	manager.SessionGC(io.Discard, session.LOG_LEVEL_ERROR)
+	list, err := manager.ListSessions(0, 0)
+	if err != nil {
+		t.Fatalf("ListSessions() failed: %v", err)
+	}
+	var short_found, long_found bool
+	for _, meta := range list {
+		short_found = short_found || meta.ID == short_sess.SessionID()
+		long_found = long_found || meta.ID == long_sess.SessionID()
+	}
+	if short_found || !long_found {
+		t.Fatalf("ListSessions() after SessionGC() wanted only %s, got %v", long_sess.SessionID(), list)
+	}
+}
+
+func testDestroyAll(t *testing.T, newManager NewManagerFunc) {
+	manager := startManager(t, newManager, 0, 0)
+
+	tests := NewTestValues()
+	var sids []string
+	for i := 0; i < 2; i++ {
+		sess := startSession(t, manager, "")
+		PutValues(t, sess, tests)
+		sids = append(sids, sess.SessionID())
+		closeSession(t, manager, sess.SessionID())
+	}
+
+	manager.DestroyAllSessions(io.Discard, session.LOG_LEVEL_ERROR)
+	for _, sid := range sids {
+		assertDestroyed(t, manager, sid)
+	}
+}
+
+func testCodec(t *testing.T, newManager NewManagerFunc) {
+	manager := startManager(t, newManager, 0, 0)
+
+	sess := startSession(t, manager, "")
+	sid := sess.SessionID()
+	defer manager.SessionDestroy(sid)
+	tests := NewCodecValues()
+	PutValues(t, sess, tests)
+	CompareValues(t, sess, tests)
+	closeSession(t, manager, sid)
+
+	sess = startSession(t, manager, sid)
+	CompareValues(t, sess, tests)
+	if n, err := sess.Len(); err != nil || n != len(tests) {
+		t.Fatalf("Len() wanted %d, got %d, err: %v", len(tests), n, err)
+	}
+}
+
+func testRateLimit(t *testing.T, newManager NewManagerFunc) {
+	manager := startManager(t, newManager, 0, 0)
+	window := time.Minute
+	//windows are of the manager clock, it is stopped at a window start, so calls are counted in one window
+	clock := session.NewManualClock(time.Unix(0, time.Now().UnixNano()/int64(window)*int64(window)))
+	manager.SetClock(clock)
+	defer manager.SetClock(nil)
+
+	sess := startSession(t, manager, "")
+	sid := sess.SessionID()
+	defer manager.SessionDestroy(sid)
+	for i := 1; i <= 3; i++ {
+		ok, err := sess.Allow("login", 2, window)
+		if err != nil {
+			t.Fatalf("Allow() failed: %v", err)
+		}
+		if ok != (i <= 2) {
+			t.Fatalf("Allow() call %d wanted %v, got %v", i, i <= 2, ok)
+		}
+	}
+	//counters are not session values
+	if err := sess.Clear(); err != nil {
+		t.Fatalf("Clear() failed: %v", err)
+	}
+	if err := sess.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if ok, err := sess.Allow("login", 2, window); err != nil || ok {
+		t.Fatalf("Allow() after Clear() wanted false, got %v, error: %v", ok, err)
+	}
+	if ok, err := sess.Allow("download", 2, window); err != nil || !ok {
+		t.Fatalf("Allow() of another action wanted true, got %v, error: %v", ok, err)
+	}
+	clock.Advance(window)
+	if ok, err := sess.Allow("login", 2, window); err != nil || !ok {
+		t.Fatalf("Allow() in a new window wanted true, got %v, error: %v", ok, err)
+	}
+}
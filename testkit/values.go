@@ -0,0 +1,101 @@
+package testkit
+
+import (
+	"encoding/gob"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dronm/session"
+)
+
+// TestStruct custom struct for use in session.
+type TestStruct struct {
+	IntVal   int
+	FloatVal float32
+	StrVal   string
+}
+
+func NewTestStruct() TestStruct {
+	return TestStruct{IntVal: 375, FloatVal: 3.14, StrVal: "Some string value in struct"}
+}
+
+// NewTestValues returns session values of different types keyed by type names.
+func NewTestValues() map[string]interface{} {
+	//Register custom struct for marshaling.
+	gob.Register(TestStruct{})
+	gob.Register(time.Time{})
+
+	return map[string]interface{}{
+		"stringVal":  "some string value",
+		"int32Val":   int32(2147483647),
+		"int64Val":   2147483647 * 2,
+		"float32Val": float32(3.14),
+		"float64Val": float64(3.14),
+		"dateVal":    time.Now().Truncate(time.Second),
+		"structVal":  NewTestStruct(),
+	}
+}
+
+// NewCodecValues returns values of NewTestValues() and composite ones, slices and maps,
+// all of them must survive a provider encoding round trip.
+func NewCodecValues() map[string]interface{} {
+	gob.Register([]string{})
+	gob.Register([]byte{})
+	gob.Register(map[string]string{})
+
+	values := NewTestValues()
+	values["boolVal"] = true
+	values["emptyStringVal"] = ""
+	values["unicodeVal"] = "значение 値 🙂"
+	values["bytesVal"] = []byte{0, 1, 2, 255}
+	values["stringSliceVal"] = []string{"a", "b", "c"}
+	values["mapVal"] = map[string]string{"k1": "v1", "k2": "v2"}
+	values["negativeStructVal"] = TestStruct{IntVal: -1, FloatVal: -0.5}
+	return values
+}
+
+// PutValues sets tests values to session and flushes it.
+func PutValues(t testing.TB, currentSession session.Session, tests map[string]interface{}) {
+	t.Helper()
+	//test writing
+	for key, val := range tests {
+		t.Logf("Setting key: %s to %v", key, val)
+		if err := currentSession.Set(key, val); err != nil {
+			t.Fatalf("Set() for string value failed: %v", err)
+		}
+	}
+	if err := currentSession.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+}
+
+// CompareValues reads tests values from session and compares them to the wanted ones.
+func CompareValues(t testing.TB, currentSession session.Session, tests map[string]interface{}) {
+	t.Helper()
+	for key, wanted := range tests {
+		t.Logf("Getting key: %s", key)
+
+		ptr := reflect.New(reflect.TypeOf(wanted))
+		err := currentSession.Get(key, ptr.Interface())
+		if err != nil {
+			t.Fatalf("Get() failed: %v", err)
+		}
+		got := ptr.Elem().Interface()
+		if !reflect.DeepEqual(got, wanted) {
+			t.Fatalf("Wanted: %v, got %v", wanted, got)
+		}
+	}
+}
+
+// AssertNoValues checks that none of tests values are in session.
+func AssertNoValues(t testing.TB, currentSession session.Session, tests map[string]interface{}) {
+	t.Helper()
+	for key, wanted := range tests {
+		ptr := reflect.New(reflect.TypeOf(wanted))
+		err := currentSession.Get(key, ptr.Interface())
+		if err == nil {
+			t.Fatalf("Session: %s is not destroyed", currentSession.SessionID())
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package session
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSConfig holds TLS parameters of provider connections to managed services
+// requiring TLS or client certificates, e.g. redis.Config.TLS or pg.Config.TLS.
+// Files are PEM encoded.
+type TLSConfig struct {
+	CAFile             string //certificate authorities verifying the server, system pool if empty
+	CertFile           string //client certificate, no client certificate if empty
+	KeyFile            string //client certificate key, required with CertFile
+	ServerName         string //server name verified by the certificate, connection host if empty
+	InsecureSkipVerify bool   //server certificate is not verified, for testing only
+}
+
+// Load returns tls.Config with loaded certificates, nil if the config is nil.
+func (c *TLSConfig) Load() (*tls.Config, error) {
+	if c == nil {
+		return nil, nil
+	}
+	tls_cfg := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if c.CAFile != "" {
+		ca, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("session: TLSConfig.CAFile: %w", err)
+		}
+		tls_cfg.RootCAs = x509.NewCertPool()
+		if !tls_cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("session: TLSConfig.CAFile contains no PEM certificates")
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, errors.New("session: TLSConfig.CertFile and TLSConfig.KeyFile must be set both")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("session: TLSConfig client certificate: %w", err)
+		}
+		tls_cfg.Certificates = []tls.Certificate{cert}
+	}
+	return tls_cfg, nil
+}
@@ -0,0 +1,552 @@
+// Package tracing contains a session provider decorator recording OpenTelemetry spans
+// around provider and session operations, so session storage latency shows up in distributed traces.
+// Requirements:
+//
+//	opentelemetry https://github.com/open-telemetry/opentelemetry-go
+//
+// Usage:
+//
+//	redis_pder, _ := session.LookupProvider("redis")
+//	session.Register("redis_traced", tracing.WithTracerProvider(redis_pder, "redis", tp))
+//	SessManager, err := session.NewManager("redis_traced", 0, 3600, "", REDIS_ADDR, REDIS_NAMESPACE)
+//
+// Spans are named after operations, e.g. session.SessionRead or session.Flush,
+// with attributes ATTR_PROVIDER, ATTR_SID_HASH and ATTR_PAYLOAD_SIZE.
+// Session IDs are never recorded as is, only their SHA-256 hash prefix.
+// Payload size is recorded for sessions implementing session.SizedSession, e.g. redis.
+// Failed operations have error status with the error recorded as span event.
+package tracing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/dronm/session"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TRACER_NAME is the instrumentation name of the tracer.
+const TRACER_NAME = "github.com/dronm/session/tracing"
+
+// Span attributes.
+const (
+	ATTR_PROVIDER     = "session.provider"     //provider name passed to WithTracerProvider()
+	ATTR_SID_HASH     = "session.sid_hash"     //hex encoded prefix of SHA-256 of session ID
+	ATTR_PAYLOAD_SIZE = "session.payload_size" //total size of keys and encoded values in bytes
+	ATTR_GC_SCANNED   = "session.gc.scanned"
+	ATTR_GC_DELETED   = "session.gc.deleted"
+	ATTR_GC_ERRORS    = "session.gc.errors"
+)
+
+// SID_HASH_LEN is the number of hash bytes in ATTR_SID_HASH.
+const SID_HASH_LEN = 8
+
+// Provider is a session provider decorator recording spans of inner provider and its sessions operations.
+type Provider struct {
+	session.Provider
+	name   string
+	tracer trace.Tracer
+}
+
+// WithTracerProvider returns inner provider recording spans with tracers of tp,
+// global tracer provider is used if tp is nil. Name is recorded as ATTR_PROVIDER attribute.
+// The result should be registered under its own name with session.Register(), then used with session.NewManager().
+func WithTracerProvider(inner session.Provider, name string, tp trace.TracerProvider) *Provider {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return &Provider{Provider: inner, name: name, tracer: tp.Tracer(TRACER_NAME)}
+}
+
+// HashSessionID returns ATTR_SID_HASH value of sid.
+func HashSessionID(sid string) string {
+	h := sha256.Sum256([]byte(sid))
+	return hex.EncodeToString(h[:SID_HASH_LEN])
+}
+
+// start starts span of operation op, sid is not recorded if empty.
+func (tpder *Provider) start(op, sid string) trace.Span {
+	attrs := []attribute.KeyValue{attribute.String(ATTR_PROVIDER, tpder.name)}
+	if sid != "" {
+		attrs = append(attrs, attribute.String(ATTR_SID_HASH, HashSessionID(sid)))
+	}
+	_, span := tpder.tracer.Start(context.Background(), "session."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	return span
+}
+
+// end records err and ends span.
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// trace runs fn in span of operation op.
+func (tpder *Provider) trace(op, sid string, fn func() error) error {
+	span := tpder.start(op, sid)
+	err := fn()
+	end(span, err)
+	return err
+}
+
+// setPayloadSize records payload size of sess if the span is recording and sess reports it.
+func setPayloadSize(span trace.Span, sess session.Session) {
+	if !span.IsRecording() {
+		return
+	}
+	sized, ok := sess.(session.SizedSession)
+	if !ok {
+		return
+	}
+	sizes, err := sized.ValueSizes()
+	if err != nil {
+		return
+	}
+	size := 0
+	for key, n := range sizes {
+		size += len(key) + n
+	}
+	span.SetAttributes(attribute.Int(ATTR_PAYLOAD_SIZE, size))
+}
+
+func (tpder *Provider) SessionInit(sid string) (session.Session, error) {
+	span := tpder.start("SessionInit", sid)
+	sess, err := tpder.Provider.SessionInit(sid)
+	end(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedSession{Session: sess, pder: tpder}, nil
+}
+
+func (tpder *Provider) SessionRead(sid string) (session.Session, error) {
+	span := tpder.start("SessionRead", sid)
+	sess, err := tpder.Provider.SessionRead(sid)
+	if err == nil {
+		setPayloadSize(span, sess)
+	}
+	end(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedSession{Session: sess, pder: tpder}, nil
+}
+
+func (tpder *Provider) SessionDestroy(sid string) error {
+	return tpder.trace("SessionDestroy", sid, func() error {
+		return tpder.Provider.SessionDestroy(sid)
+	})
+}
+
+func (tpder *Provider) SessionClose(sid string) error {
+	return tpder.trace("SessionClose", sid, func() error {
+		return tpder.Provider.SessionClose(sid)
+	})
+}
+
+// SessionGC records GC report as span attributes, the span has error status if report has errors.
+func (tpder *Provider) SessionGC(l io.Writer, logLev session.LogLevel) session.GCReport {
+	span := tpder.start("SessionGC", "")
+	report := tpder.Provider.SessionGC(l, logLev)
+	span.SetAttributes(
+		attribute.Int(ATTR_GC_SCANNED, report.Scanned),
+		attribute.Int(ATTR_GC_DELETED, report.DeletedIdle+report.DeletedLifetime+report.DeletedExpiry),
+		attribute.Int(ATTR_GC_ERRORS, report.Errors),
+	)
+	if report.Errors > 0 {
+		span.SetStatus(codes.Error, "session: GC failed operations")
+	}
+	span.End()
+	return report
+}
+
+func (tpder *Provider) DestroyAllSessions(l io.Writer, logLev session.LogLevel) {
+	span := tpder.start("DestroyAllSessions", "")
+	tpder.Provider.DestroyAllSessions(l, logLev)
+	span.End()
+}
+
+// Ping records span in context of ctx.
+func (tpder *Provider) Ping(ctx context.Context) error {
+	ctx, span := tpder.tracer.Start(ctx, "session.Ping",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String(ATTR_PROVIDER, tpder.name)),
+	)
+	err := tpder.Provider.Ping(ctx)
+	end(span, err)
+	return err
+}
+
+// Drain implements session.DrainProvider with inner provider.
+func (tpder *Provider) Drain() error {
+	drain_pder, ok := tpder.Provider.(session.DrainProvider)
+	if !ok {
+		return nil
+	}
+	return tpder.trace("Drain", "", drain_pder.Drain)
+}
+
+// SetGCMaxDeletions implements session.GCLimiter, the limit is passed to inner provider.
+func (tpder *Provider) SetGCMaxDeletions(maxDeletions int) {
+	if limiter, ok := tpder.Provider.(session.GCLimiter); ok {
+		limiter.SetGCMaxDeletions(maxDeletions)
+	}
+}
+
+// SetExpirationMode implements session.ExpirationModeSetter, the mode is passed to inner provider.
+func (tpder *Provider) SetExpirationMode(mode session.ExpirationMode) {
+	if setter, ok := tpder.Provider.(session.ExpirationModeSetter); ok {
+		setter.SetExpirationMode(mode)
+	}
+}
+
+// SetStrictMode implements session.StrictModeSetter, the mode is passed to inner provider.
+// session.ErrNoStrictMode is returned if inner provider does not support strict mode.
+func (tpder *Provider) SetStrictMode(strict bool) error {
+	setter, ok := tpder.Provider.(session.StrictModeSetter)
+	if !ok {
+		return session.ErrNoStrictMode
+	}
+	return setter.SetStrictMode(strict)
+}
+
+// SetClock implements session.ClockSetter, the clock is passed to inner provider.
+func (tpder *Provider) SetClock(clock session.Clock) {
+	if setter, ok := tpder.Provider.(session.ClockSetter); ok {
+		setter.SetClock(clock)
+	}
+}
+
+// SessionMeta implements session.MetaProvider with inner provider.
+func (tpder *Provider) SessionMeta(sid string) (meta session.SessionMeta, err error) {
+	meta_pder, ok := tpder.Provider.(session.MetaProvider)
+	if !ok {
+		return meta, session.ErrNoSessionMeta
+	}
+	err = tpder.trace("SessionMeta", sid, func() (err error) {
+		meta, err = meta_pder.SessionMeta(sid)
+		return err
+	})
+	return meta, err
+}
+
+// SessionPeek implements session.PeekProvider with inner provider.
+func (tpder *Provider) SessionPeek(sid string) (sess session.Session, err error) {
+	peek_pder, ok := tpder.Provider.(session.PeekProvider)
+	if !ok {
+		return nil, session.ErrNoSessionPeek
+	}
+	err = tpder.trace("SessionPeek", sid, func() (err error) {
+		sess, err = peek_pder.SessionPeek(sid)
+		return err
+	})
+	return sess, err
+}
+
+// AcquireGCLeader implements session.GCLeaderLocker with inner provider.
+func (tpder *Provider) AcquireGCLeader(name string, ttl time.Duration) (bool, error) {
+	locker, ok := tpder.Provider.(session.GCLeaderLocker)
+	if !ok {
+		return false, session.ErrNoGCLeaderLock
+	}
+	return locker.AcquireGCLeader(name, ttl)
+}
+
+// ReleaseGCLeader implements session.GCLeaderLocker with inner provider.
+func (tpder *Provider) ReleaseGCLeader(name string) error {
+	locker, ok := tpder.Provider.(session.GCLeaderLocker)
+	if !ok {
+		return session.ErrNoGCLeaderLock
+	}
+	return locker.ReleaseGCLeader(name)
+}
+
+// SetExpiredHook passes hook to inner provider if it implements session.ExpiryNotifier.
+func (tpder *Provider) SetExpiredHook(fn session.SessionHook) {
+	if notifier, ok := tpder.Provider.(session.ExpiryNotifier); ok {
+		notifier.SetExpiredHook(fn)
+	}
+}
+
+// SetChangedHook passes hook to inner provider if it implements session.ChangeNotifier.
+func (tpder *Provider) SetChangedHook(fn session.SessionHook) {
+	if notifier, ok := tpder.Provider.(session.ChangeNotifier); ok {
+		notifier.SetChangedHook(fn)
+	}
+}
+
+// IsFatal classifies err with inner provider if it implements session.Reconnector.
+func (tpder *Provider) IsFatal(err error) bool {
+	reconnector, ok := tpder.Provider.(session.Reconnector)
+	return ok && reconnector.IsFatal(err)
+}
+
+// Reconnect implements session.Reconnector with inner provider.
+func (tpder *Provider) Reconnect(ctx context.Context) error {
+	if reconnector, ok := tpder.Provider.(session.Reconnector); ok {
+		return reconnector.Reconnect(ctx)
+	}
+	return nil
+}
+
+// SetKeyRing passes key ring to inner provider if it implements session.EncryptedProvider.
+func (tpder *Provider) SetKeyRing(keyRing *session.KeyRing) {
+	if enc_pder, ok := tpder.Provider.(session.EncryptedProvider); ok {
+		enc_pder.SetKeyRing(keyRing)
+	}
+}
+
+// SetCompression passes compression to inner provider if it implements session.CompressedProvider.
+func (tpder *Provider) SetCompression(compression *session.Compression) {
+	if comp_pder, ok := tpder.Provider.(session.CompressedProvider); ok {
+		comp_pder.SetCompression(compression)
+	}
+}
+
+// SetPayloadVersion passes payload version to inner provider if it implements session.VersionedProvider.
+func (tpder *Provider) SetPayloadVersion(payloadVersion *session.PayloadVersion) {
+	if ver_pder, ok := tpder.Provider.(session.VersionedProvider); ok {
+		ver_pder.SetPayloadVersion(payloadVersion)
+	}
+}
+
+// SetLogger passes logger to inner provider if it implements session.LoggedProvider.
+func (tpder *Provider) SetLogger(logger *slog.Logger) {
+	if log_pder, ok := tpder.Provider.(session.LoggedProvider); ok {
+		log_pder.SetLogger(logger)
+	}
+}
+
+// EnsureSchema implements session.SchemaProvider with inner provider.
+func (tpder *Provider) EnsureSchema(ctx context.Context) error {
+	schema_pder, ok := tpder.Provider.(session.SchemaProvider)
+	if !ok {
+		return nil
+	}
+	return tpder.trace("EnsureSchema", "", func() error {
+		return schema_pder.EnsureSchema(ctx)
+	})
+}
+
+// SessionStats implements session.StatsProvider with inner provider.
+func (tpder *Provider) SessionStats(ctx context.Context, sampleSize int) (stats session.SessionStats, err error) {
+	err = tpder.trace("SessionStats", "", func() (err error) {
+		stats, err = session.ProviderStats(ctx, tpder.Provider, sampleSize)
+		return err
+	})
+	return stats, err
+}
+
+// SessionCount implements session.AdminProvider with inner provider.
+func (tpder *Provider) SessionCount() (cnt int, err error) {
+	adm_pder, ok := tpder.Provider.(session.AdminProvider)
+	if !ok {
+		return 0, session.ErrNotAdminProvider
+	}
+	err = tpder.trace("SessionCount", "", func() (err error) {
+		cnt, err = adm_pder.SessionCount()
+		return err
+	})
+	return cnt, err
+}
+
+// SessionList implements session.AdminProvider with inner provider.
+func (tpder *Provider) SessionList(offset, limit int) (list []session.SessionMeta, err error) {
+	adm_pder, ok := tpder.Provider.(session.AdminProvider)
+	if !ok {
+		return nil, session.ErrNotAdminProvider
+	}
+	err = tpder.trace("SessionList", "", func() (err error) {
+		list, err = adm_pder.SessionList(offset, limit)
+		return err
+	})
+	return list, err
+}
+
+// SessionDestroyMany destroys sessions with inner provider.
+func (tpder *Provider) SessionDestroyMany(sids []string) error {
+	if bulk_pder, ok := tpder.Provider.(session.BulkDestroyProvider); ok {
+		return tpder.trace("SessionDestroyMany", "", func() error {
+			return bulk_pder.SessionDestroyMany(sids)
+		})
+	}
+	var errs []error
+	for _, sid := range sids {
+		if err := tpder.SessionDestroy(sid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SessionUserIndex implements session.UserIndexProvider with inner provider.
+func (tpder *Provider) SessionUserIndex() session.UserIndex {
+	if ix_pder, ok := tpder.Provider.(session.UserIndexProvider); ok {
+		return ix_pder.SessionUserIndex()
+	}
+	return nil
+}
+
+// tracedSession records spans of session methods returning errors.
+type tracedSession struct {
+	session.Session
+	pder *Provider
+}
+
+func (s *tracedSession) trace(op string, fn func() error) error {
+	return s.pder.trace(op, s.SessionID(), fn)
+}
+
+func (s *tracedSession) Set(key string, value interface{}) error {
+	return s.trace("Set", func() error { return s.Session.Set(key, value) })
+}
+
+func (s *tracedSession) Put(key string, value interface{}) error {
+	span := s.pder.start("Put", s.SessionID())
+	err := s.Session.Put(key, value)
+	setPayloadSize(span, s.Session)
+	end(span, err)
+	return err
+}
+
+func (s *tracedSession) Get(key string, value interface{}) error {
+	return s.trace("Get", func() error { return s.Session.Get(key, value) })
+}
+
+func (s *tracedSession) GetStruct(key string, dest interface{}) error {
+	return s.trace("GetStruct", func() error { return s.Session.GetStruct(key, dest) })
+}
+
+func (s *tracedSession) Delete(key string) error {
+	return s.trace("Delete", func() error { return s.Session.Delete(key) })
+}
+
+func (s *tracedSession) Clear() error {
+	return s.trace("Clear", s.Session.Clear)
+}
+
+func (s *tracedSession) Increment(key string, delta int64) (n int64, err error) {
+	err = s.trace("Increment", func() (err error) {
+		n, err = s.Session.Increment(key, delta)
+		return err
+	})
+	return n, err
+}
+
+func (s *tracedSession) Decrement(key string, delta int64) (n int64, err error) {
+	err = s.trace("Decrement", func() (err error) {
+		n, err = s.Session.Decrement(key, delta)
+		return err
+	})
+	return n, err
+}
+
+func (s *tracedSession) Keys() (keys []string, err error) {
+	err = s.trace("Keys", func() (err error) {
+		keys, err = s.Session.Keys()
+		return err
+	})
+	return keys, err
+}
+
+func (s *tracedSession) Len() (n int, err error) {
+	err = s.trace("Len", func() (err error) {
+		n, err = s.Session.Len()
+		return err
+	})
+	return n, err
+}
+
+func (s *tracedSession) Flush() error {
+	span := s.pder.start("Flush", s.SessionID())
+	setPayloadSize(span, s.Session)
+	err := s.Session.Flush()
+	end(span, err)
+	return err
+}
+
+func (s *tracedSession) GetOrSet(key string, dest interface{}, compute func() (interface{}, error)) error {
+	return s.trace("GetOrSet", func() error { return s.Session.GetOrSet(key, dest, compute) })
+}
+
+func (s *tracedSession) CompareAndSwap(key string, oldValue, newValue interface{}) (swapped bool, err error) {
+	err = s.trace("CompareAndSwap", func() (err error) {
+		swapped, err = s.Session.CompareAndSwap(key, oldValue, newValue)
+		return err
+	})
+	return swapped, err
+}
+
+func (s *tracedSession) SetMany(values map[string]interface{}) error {
+	return s.trace("SetMany", func() error { return s.Session.SetMany(values) })
+}
+
+func (s *tracedSession) GetMany(dest map[string]interface{}) error {
+	return s.trace("GetMany", func() error { return s.Session.GetMany(dest) })
+}
+
+func (s *tracedSession) Snapshot() (snapshot map[string]interface{}, err error) {
+	err = s.trace("Snapshot", func() (err error) {
+		snapshot, err = s.Session.Snapshot()
+		return err
+	})
+	return snapshot, err
+}
+
+func (s *tracedSession) Restore(snapshot map[string]interface{}) error {
+	return s.trace("Restore", func() error { return s.Session.Restore(snapshot) })
+}
+
+func (s *tracedSession) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	return s.trace("SetWithTTL", func() error { return s.Session.SetWithTTL(key, value, ttl) })
+}
+
+func (s *tracedSession) SetSensitive(key string, value interface{}) error {
+	return s.trace("SetSensitive", func() error { return s.Session.SetSensitive(key, value) })
+}
+
+func (s *tracedSession) Lock() error {
+	return s.trace("Lock", s.Session.Lock)
+}
+
+func (s *tracedSession) Unlock() error {
+	return s.trace("Unlock", s.Session.Unlock)
+}
+
+func (s *tracedSession) SetExpiry(d time.Duration) error {
+	return s.trace("SetExpiry", func() error { return s.Session.SetExpiry(d) })
+}
+
+func (s *tracedSession) Touch() error {
+	return s.trace("Touch", s.Session.Touch)
+}
+
+func (s *tracedSession) Allow(action string, limit int, window time.Duration) (ok bool, err error) {
+	err = s.trace("Allow", func() (err error) {
+		ok, err = s.Session.Allow(action, limit, window)
+		return err
+	})
+	return ok, err
+}
+
+func (s *tracedSession) Bucket(name string) session.Session {
+	return session.NewBucket(s, name)
+}
+
+// ValidateSessionID checks session ID format with inner provider.
+func (tpder *Provider) ValidateSessionID(sid string) error {
+	return session.ValidateSessionID(tpder.Provider, sid)
+}
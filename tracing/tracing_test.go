@@ -0,0 +1,105 @@
+// testing functions for session/tracing.
+package tracing
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/dronm/session"
+	"github.com/dronm/session/mock"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const PROVIDER = "mock_traced"
+
+var (
+	testProvider = mock.NewProvider()
+	recorder     = tracetest.NewSpanRecorder()
+)
+
+func init() {
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	session.Register(PROVIDER, WithTracerProvider(testProvider, mock.PROVIDER, tp))
+}
+
+// findSpan returns the last ended span named name.
+func findSpan(name string) sdktrace.ReadOnlySpan {
+	spans := recorder.Ended()
+	for i := len(spans) - 1; i >= 0; i-- {
+		if spans[i].Name() == name {
+			return spans[i]
+		}
+	}
+	return nil
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key string) (attribute.Value, bool) {
+	for _, attr := range span.Attributes() {
+		if string(attr.Key) == key {
+			return attr.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+// TestSpans checks spans of provider and session operations with their attributes.
+func TestSpans(t *testing.T) {
+	testProvider.Reset()
+	SessManager, err := session.NewManager(PROVIDER, 0, 0, "")
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	currentSession, err := SessManager.SessionStart("")
+	if err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+	sid := currentSession.SessionID()
+	if err := currentSession.Put("key", "value"); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if _, err := SessManager.SessionStart(sid); err != nil {
+		t.Fatalf("SessionStart() failed: %v", err)
+	}
+
+	for _, name := range []string{"session.SessionInit", "session.Put", "session.SessionRead"} {
+		span := findSpan(name)
+		if span == nil {
+			t.Fatalf("span %s not recorded", name)
+		}
+		if v, _ := spanAttr(span, ATTR_PROVIDER); v.AsString() != mock.PROVIDER {
+			t.Fatalf("span %s wanted provider %s, got %q", name, mock.PROVIDER, v.AsString())
+		}
+		if v, _ := spanAttr(span, ATTR_SID_HASH); v.AsString() != HashSessionID(sid) {
+			t.Fatalf("span %s wanted sid hash %s, got %q", name, HashSessionID(sid), v.AsString())
+		}
+		if span.Status().Code == codes.Error {
+			t.Fatalf("span %s has error status", name)
+		}
+	}
+
+	errDown := errors.New("storage is down")
+	testProvider.FailWith("Flush", errDown)
+	if err := currentSession.Flush(); !errors.Is(err, errDown) {
+		t.Fatalf("Flush() wanted scripted error, got %v", err)
+	}
+	span := findSpan("session.Flush")
+	if span == nil || span.Status().Code != codes.Error || len(span.Events()) == 0 {
+		t.Fatalf("Flush() span wanted error status and event, got %+v", span)
+	}
+
+	SessManager.SessionGC(os.Stderr, session.LOG_LEVEL_ERROR)
+	span = findSpan("session.SessionGC")
+	if span == nil {
+		t.Fatalf("span session.SessionGC not recorded")
+	}
+	if _, ok := spanAttr(span, ATTR_GC_DELETED); !ok {
+		t.Fatalf("SessionGC span has no %s attribute", ATTR_GC_DELETED)
+	}
+	if _, ok := spanAttr(span, ATTR_SID_HASH); ok {
+		t.Fatalf("SessionGC span has %s attribute", ATTR_SID_HASH)
+	}
+}
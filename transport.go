@@ -0,0 +1,170 @@
+package session
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// DEFAULT_SID_HEADER is a header of HeaderTransport and BearerTransport if Header is empty.
+const DEFAULT_SID_HEADER = "X-Session-ID"
+
+// SIDTransport reads session ID of a request and sends session ID of a new session to the client,
+// see MiddlewareConfig.Transport.
+type SIDTransport interface {
+	ReadSID(r *http.Request) string                              //empty if the request has no session ID
+	WriteSID(w http.ResponseWriter, r *http.Request, sid string) //called before response header is written
+}
+
+// HOST_COOKIE_PREFIX is a cookie name prefix binding the cookie to the host: browsers accept
+// such cookies only if they are Secure, with Path "/" and without Domain.
+const HOST_COOKIE_PREFIX = "__Host-"
+
+// SECURE_COOKIE_PREFIX is a cookie name prefix of cookies browsers accept only if they are Secure.
+const SECURE_COOKIE_PREFIX = "__Secure-"
+
+// CookieTransport passes session ID in a cookie, it is the default transport of Middleware().
+type CookieTransport struct {
+	Cookie http.Cookie //cookie template, Name is DEFAULT_COOKIE_NAME and Path is "/" if empty
+
+	//KeyRing encrypts session ID of the cookie value if set, so the raw ID never appears client-side.
+	//Values are authenticated, modified values and values of unknown keys are ignored.
+	KeyRing *KeyRing
+}
+
+// HardenedCookie returns session cookie template of DEFAULT_COOKIE_NAME with HOST_COOKIE_PREFIX,
+// sent over HTTPS only, to all paths of the host, not available to scripts.
+// sameSite is http.SameSiteLaxMode if not set, cross-site requests, e.g. of embedded frames,
+// need http.SameSiteNoneMode:
+//
+//	session.CookieTransport{Cookie: session.HardenedCookie(http.SameSiteNoneMode), KeyRing: keys}
+func HardenedCookie(sameSite http.SameSite) http.Cookie {
+	if sameSite == 0 || sameSite == http.SameSiteDefaultMode {
+		sameSite = http.SameSiteLaxMode
+	}
+	return http.Cookie{
+		Name:     HOST_COOKIE_PREFIX + DEFAULT_COOKIE_NAME,
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: sameSite,
+	}
+}
+
+func (t CookieTransport) ReadSID(r *http.Request) string {
+	c, err := r.Cookie(t.name())
+	if err != nil || c.Value == "" {
+		return ""
+	}
+	if t.KeyRing == nil {
+		return c.Value
+	}
+	data, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil {
+		return ""
+	}
+	sid, err := t.KeyRing.Decrypt(data)
+	if err != nil {
+		return ""
+	}
+	return string(sid)
+}
+
+// WriteSID sets session cookie. Cookie attributes required by browsers are enforced:
+// Secure for SameSite=None and prefixed names, Path "/" and no Domain for HOST_COOKIE_PREFIX.
+func (t CookieTransport) WriteSID(w http.ResponseWriter, r *http.Request, sid string) {
+	cookie := t.Cookie
+	cookie.Name = t.name()
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	if cookie.SameSite == http.SameSiteNoneMode || strings.HasPrefix(cookie.Name, SECURE_COOKIE_PREFIX) {
+		cookie.Secure = true
+	}
+	if strings.HasPrefix(cookie.Name, HOST_COOKIE_PREFIX) {
+		cookie.Secure = true
+		cookie.Path = "/"
+		cookie.Domain = ""
+	}
+	cookie.Value = sid
+	if t.KeyRing != nil {
+		data, err := t.KeyRing.Encrypt([]byte(sid))
+		if err != nil {
+			//raw ID is never sent, the client gets a new session with the next request
+			return
+		}
+		cookie.Value = base64.RawURLEncoding.EncodeToString(data)
+	}
+	http.SetCookie(w, &cookie)
+}
+
+func (t CookieTransport) name() string {
+	if t.Cookie.Name == "" {
+		return DEFAULT_COOKIE_NAME
+	}
+	return t.Cookie.Name
+}
+
+// HeaderTransport passes session ID in a request and response header, e.g. for API and mobile clients
+// not using cookies: the client keeps the ID of the response header and sends it with every request.
+type HeaderTransport struct {
+	Header string //DEFAULT_SID_HEADER if empty
+}
+
+func (t HeaderTransport) ReadSID(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get(t.header()))
+}
+
+func (t HeaderTransport) WriteSID(w http.ResponseWriter, r *http.Request, sid string) {
+	w.Header().Set(t.header(), sid)
+}
+
+func (t HeaderTransport) header() string {
+	if t.Header == "" {
+		return DEFAULT_SID_HEADER
+	}
+	return t.Header
+}
+
+// BearerTransport reads session ID from Authorization: Bearer <sid> request header,
+// session ID of a new session is sent in the response Header.
+type BearerTransport struct {
+	Header string //response header, DEFAULT_SID_HEADER if empty
+}
+
+func (t BearerTransport) ReadSID(r *http.Request) string {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func (t BearerTransport) WriteSID(w http.ResponseWriter, r *http.Request, sid string) {
+	HeaderTransport{Header: t.Header}.WriteSID(w, r, sid)
+}
+
+// MultiTransport returns transport reading session ID with the first transport finding it,
+// session ID of a new session is sent with all transports:
+//
+//	session.MultiTransport(session.CookieTransport{}, session.BearerTransport{})
+func MultiTransport(transports ...SIDTransport) SIDTransport {
+	return multiTransport(transports)
+}
+
+type multiTransport []SIDTransport
+
+func (m multiTransport) ReadSID(r *http.Request) string {
+	for _, t := range m {
+		if sid := t.ReadSID(r); sid != "" {
+			return sid
+		}
+	}
+	return ""
+}
+
+func (m multiTransport) WriteSID(w http.ResponseWriter, r *http.Request, sid string) {
+	for _, t := range m {
+		t.WriteSID(w, r, sid)
+	}
+}
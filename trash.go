@@ -0,0 +1,197 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// TrashedSession is a destroyed session kept for restoration, see Manager.SetTrash().
+type TrashedSession struct {
+	ID        string
+	Values    map[string]interface{}
+	DeletedAt time.Time
+}
+
+// TrashStore keeps destroyed sessions till they are restored or purged.
+type TrashStore interface {
+	Put(ts TrashedSession) error             //replaces a session with the same ID
+	Take(sid string) (TrashedSession, error) //removes session from trash, ErrSessionNotFound if there is no session
+	Purge(before time.Time) (int, error)     //removes sessions deleted before the given time, returns their number
+}
+
+// UserTrashStore is an optional interface for trash stores removing sessions of a user,
+// it is used by Manager.EraseUserData() as destroyed sessions are no longer in the user index.
+type UserTrashStore interface {
+	TakeUser(userID string) (int, error) //removes sessions bound to the user, returns their number
+}
+
+// MemoryTrash is a TrashStore keeping sessions in process memory.
+// Sessions are lost on restart and are not shared between application instances.
+type MemoryTrash struct {
+	mx       sync.Mutex
+	sessions map[string]TrashedSession
+}
+
+// NewMemoryTrash returns an empty trash.
+func NewMemoryTrash() *MemoryTrash {
+	return &MemoryTrash{sessions: make(map[string]TrashedSession)}
+}
+
+func (tr *MemoryTrash) Put(ts TrashedSession) error {
+	tr.mx.Lock()
+	defer tr.mx.Unlock()
+	tr.sessions[ts.ID] = ts
+	return nil
+}
+
+func (tr *MemoryTrash) Take(sid string) (TrashedSession, error) {
+	tr.mx.Lock()
+	defer tr.mx.Unlock()
+	ts, ok := tr.sessions[sid]
+	if !ok {
+		return TrashedSession{}, ErrSessionNotFound
+	}
+	delete(tr.sessions, sid)
+	return ts, nil
+}
+
+func (tr *MemoryTrash) Purge(before time.Time) (int, error) {
+	tr.mx.Lock()
+	defer tr.mx.Unlock()
+	cnt := 0
+	for sid, ts := range tr.sessions {
+		if ts.DeletedAt.Before(before) {
+			delete(tr.sessions, sid)
+			cnt++
+		}
+	}
+	return cnt, nil
+}
+
+func (tr *MemoryTrash) TakeUser(userID string) (int, error) {
+	tr.mx.Lock()
+	defer tr.mx.Unlock()
+	cnt := 0
+	for sid, ts := range tr.sessions {
+		if user_id, ok := ts.Values[USER_KEY].(string); ok && user_id == userID {
+			delete(tr.sessions, sid)
+			cnt++
+		}
+	}
+	return cnt, nil
+}
+
+// SetTrash enables trash mode: sessions destroyed with SessionDestroy(), DestroySessionsWhere()
+// and DestroyAllSessions() are copied to store before destruction and can be restored with
+// RestoreSession() during window, e.g. to undo logging out all users by mistake.
+// Sessions removed by GC or at kill time are not trashed. Trashed sessions older than window
+// are purged by SessionGC(). MemoryTrash is used if store is nil. Window 0 disables trash mode.
+// Should be set before sessions are destroyed.
+func (manager *Manager) SetTrash(window time.Duration, store TrashStore) {
+	if window <= 0 {
+		manager.trash = nil
+		manager.trashWindow = 0
+		return
+	}
+	if store == nil {
+		store = NewMemoryTrash()
+	}
+	manager.trash = store
+	manager.trashWindow = window
+}
+
+// RestoreSession restores session destroyed in trash mode with its values, see SetTrash().
+// Values of a session started with the same ID after destruction are replaced.
+// ErrSessionNotFound is returned if the session is not in trash or its restore window has passed.
+func (manager *Manager) RestoreSession(sid string) error {
+	if manager.trash == nil {
+		return ErrSessionNotFound
+	}
+	ts, err := manager.trash.Take(sid)
+	if err != nil {
+		return err
+	}
+	if manager.clock.Now().Sub(ts.DeletedAt) > manager.trashWindow {
+		return ErrSessionNotFound
+	}
+	if err := replaceValues(manager.provider, sid, ts.Values); err != nil {
+		manager.trash.Put(ts) //can be restored again
+		return err
+	}
+	if userID, ok := ts.Values[USER_KEY].(string); ok && userID != "" {
+		manager.users().Add(userID, sid, manager.clock.Now()) //bound again, the limit is not checked
+	}
+	manager.sessionCreated(sid)
+	return nil
+}
+
+// trashSessions copies sessions to trash if trash mode is enabled.
+// Sessions without values, e.g. not existing, are skipped.
+func (manager *Manager) trashSessions(sids ...string) error {
+	if manager.trash == nil {
+		return nil
+	}
+	now := manager.clock.Now()
+	for _, sid := range sids {
+		sess, err := manager.provider.SessionRead(sid)
+		if errors.Is(err, ErrSessionExpired) || errors.Is(err, ErrSessionNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("session: trash %s: %w", sid, err)
+		}
+		values, err := sessionValues(sess)
+		manager.provider.SessionClose(sid)
+		if err != nil {
+			return fmt.Errorf("session: trash %s: %w", sid, err)
+		}
+		if len(values) == 0 {
+			continue
+		}
+		if err := manager.trash.Put(TrashedSession{ID: sid, Values: values, DeletedAt: now}); err != nil {
+			return fmt.Errorf("session: trash %s: %w", sid, err)
+		}
+	}
+	return nil
+}
+
+// trashAllSessions copies all sessions to trash if trash mode is enabled.
+func (manager *Manager) trashAllSessions() error {
+	if manager.trash == nil {
+		return nil
+	}
+	for offset := 0; ; offset += LIST_PAGE_SIZE {
+		list, err := manager.ListSessions(offset, LIST_PAGE_SIZE)
+		if err != nil {
+			return err
+		}
+		for _, meta := range list {
+			if err := manager.trashSessions(meta.ID); err != nil {
+				return err
+			}
+		}
+		if len(list) < LIST_PAGE_SIZE {
+			return nil
+		}
+	}
+}
+
+// purgeTrash removes sessions trashed before the restore window, errors are logged to l.
+func (manager *Manager) purgeTrash(l io.Writer, logLev LogLevel) error {
+	if manager.trash == nil {
+		return nil
+	}
+	cnt, err := manager.trash.Purge(manager.clock.Now().Add(-manager.trashWindow))
+	log := LoggerFor(manager.logger, l, logLev).With(LOG_KEY_OPERATION, "purgeTrash")
+	if err != nil {
+		log.Error("trash purge failed", LOG_KEY_ERROR, err)
+		return err
+	}
+	if cnt > 0 {
+		log.Debug("trashed sessions purged", LOG_KEY_COUNT, cnt)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package session
+
+import (
+	"encoding/gob"
+	"time"
+)
+
+func init() {
+	gob.Register(ExpiringValue{})
+}
+
+// ExpiringValue is a value set with Session.SetWithTTL(). Providers keeping values in memory
+// store it in place of the value, it is unwrapped by LookupValue() and removed by PurgeExpiredValues().
+type ExpiringValue struct {
+	Value   interface{}
+	Expires int64 //expiration time in Unix nanoseconds
+}
+
+// NewExpiringValue returns value expiring in ttl of system time, see NewExpiringValueAt().
+func NewExpiringValue(value interface{}, ttl time.Duration) ExpiringValue {
+	return NewExpiringValueAt(time.Now(), value, ttl)
+}
+
+// NewExpiringValueAt returns value expiring in ttl from now.
+// Providers pass current time of their clock, see ClockSetter.
+func NewExpiringValueAt(now time.Time, value interface{}, ttl time.Duration) ExpiringValue {
+	return ExpiringValue{Value: value, Expires: now.Add(ttl).UnixNano()}
+}
+
+// ValueExpired returns true if v is an ExpiringValue expired by system time, see ValueExpiredAt().
+func ValueExpired(v interface{}) bool {
+	return ValueExpiredAt(time.Now(), v)
+}
+
+// ValueExpiredAt returns true if v is an ExpiringValue expired by now.
+func ValueExpiredAt(now time.Time, v interface{}) bool {
+	ev, ok := v.(ExpiringValue)
+	return ok && now.UnixNano() >= ev.Expires
+}
+
+// LookupValue returns value by key as LookupValueAt() does by system time.
+func LookupValue(values map[string]interface{}, key string) (interface{}, bool) {
+	return LookupValueAt(time.Now(), values, key)
+}
+
+// LookupValueAt returns value by key, ExpiringValue and SensitiveValue are unwrapped, values expired by now are not found.
+func LookupValueAt(now time.Time, values map[string]interface{}, key string) (interface{}, bool) {
+	v, ok := values[key]
+	if !ok {
+		return nil, false
+	}
+	return UnwrapValueAt(now, v)
+}
+
+// UnwrapValue unwraps v as UnwrapValueAt() does by system time.
+func UnwrapValue(v interface{}) (interface{}, bool) {
+	return UnwrapValueAt(time.Now(), v)
+}
+
+// UnwrapValueAt returns the value of ExpiringValue, the decrypted value of SensitiveValue or v itself,
+// ok is false if the value is expired by now or can not be decrypted, e.g. its key is removed with SetSensitiveKeys().
+func UnwrapValueAt(now time.Time, v interface{}) (interface{}, bool) {
+	if ev, ok := v.(ExpiringValue); ok {
+		if ValueExpiredAt(now, ev) {
+			return nil, false
+		}
+		v = ev.Value
+	}
+	if sv, ok := v.(SensitiveValue); ok {
+		value, err := sv.Open()
+		if err != nil {
+			return nil, false
+		}
+		return value, true
+	}
+	return v, true
+}
+
+// PurgeExpiredValues deletes values expired by system time, see PurgeExpiredValuesAt().
+func PurgeExpiredValues(values map[string]interface{}) bool {
+	return PurgeExpiredValuesAt(time.Now(), values)
+}
+
+// PurgeExpiredValuesAt deletes values expired by now, returns true if any value is deleted.
+func PurgeExpiredValuesAt(now time.Time, values map[string]interface{}) bool {
+	purged := false
+	for key, v := range values {
+		if ValueExpiredAt(now, v) {
+			delete(values, key)
+			purged = true
+		}
+	}
+	return purged
+}
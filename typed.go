@@ -0,0 +1,121 @@
+package session
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+func init() {
+	//time.Time values read with Session.GetDate() are stored by gob encoding providers without registration
+	gob.Register(time.Time{})
+}
+
+// GetAs returns session value of type T by its key.
+// Value is retrieved with Session.GetStruct(), so it is converted uniformly across providers.
+func GetAs[T any](s Session, key string) (T, error) {
+	var v T
+	if err := s.GetStruct(key, &v); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// SetAs sets session value of type T.
+func SetAs[T any](s Session, key string, value T) error {
+	return s.Set(key, value)
+}
+
+// GetOrSet assigns session value to dest if it exists. Otherwise compute() is called
+// under session lock, its result is stored, flushed and assigned to dest.
+// The value is checked again after the lock is acquired, so concurrent callers
+// of the same session compute it once. Session must not be locked by the caller.
+// Providers implement Session.GetOrSet() with this function.
+func GetOrSet(s Session, key string, dest interface{}, compute func() (interface{}, error)) error {
+	if err := s.GetStruct(key, dest); !errors.Is(err, ErrKeyNotFound) {
+		return err
+	}
+	if err := s.Lock(); err != nil {
+		return err
+	}
+	defer s.Unlock()
+
+	//Lock() reloads values, the value may have been set while waiting
+	if err := s.GetStruct(key, dest); !errors.Is(err, ErrKeyNotFound) {
+		return err
+	}
+	value, err := compute()
+	if err != nil {
+		return err
+	}
+	if err := s.Put(key, value); err != nil {
+		return err
+	}
+	return AssignValue(value, dest)
+}
+
+// AssignValue assigns value to dest which must be a pointer.
+// If value type is not assignable to dest type, value is converted
+// with gob encoding/decoding, so structs are copied field by field
+// the same way providers keeping encoded values do it.
+// Providers keeping values in memory use this function for GetStruct().
+func AssignValue(value interface{}, dest interface{}) error {
+	dest_v := reflect.ValueOf(dest)
+	if dest_v.Kind() != reflect.Ptr || dest_v.IsNil() {
+		return ErrValMustBePtr
+	}
+	if value == nil {
+		return ErrTypeMismatch
+	}
+	val_v := reflect.ValueOf(value)
+	if val_v.Type().AssignableTo(dest_v.Elem().Type()) {
+		dest_v.Elem().Set(val_v)
+		return nil
+	}
+
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(value); err != nil {
+		return err
+	}
+	if err := gob.NewDecoder(&b).Decode(dest); err != nil {
+		return fmt.Errorf("%w: %v", ErrTypeMismatch, err)
+	}
+	return nil
+}
+
+// EqualValue reports if session value equals old, it is used by Session.CompareAndSwap().
+// Nil old matches a missing value only. Value of a different type is converted
+// to old type before comparison, see AssignValue().
+func EqualValue(value interface{}, old interface{}) bool {
+	if old == nil || value == nil {
+		return old == nil && value == nil
+	}
+	if reflect.DeepEqual(value, old) {
+		return true
+	}
+	dest := reflect.New(reflect.TypeOf(old))
+	if err := AssignValue(value, dest.Interface()); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(dest.Elem().Interface(), old)
+}
+
+// IncrementValue adds delta to an integer value.
+// Nil value is treated as 0, integer types other than int64 are converted.
+// ErrTypeMismatch is returned for non integer values.
+func IncrementValue(value interface{}, delta int64) (int64, error) {
+	if value == nil {
+		return delta, nil
+	}
+	val_v := reflect.ValueOf(value)
+	switch val_v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return val_v.Int() + delta, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(val_v.Uint()) + delta, nil
+	}
+	return 0, ErrTypeMismatch
+}
@@ -0,0 +1,232 @@
+package session
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// USER_KEY is a session value holding ID of the user the session is bound to, see Manager.BindUser().
+const USER_KEY = "session_user"
+
+// UserLimitPolicy defines what is done if a user binds more sessions than allowed.
+type UserLimitPolicy int
+
+const (
+	USER_LIMIT_EVICT  UserLimitPolicy = iota //the oldest sessions of the user are destroyed
+	USER_LIMIT_REJECT                        //ErrTooManySessions is returned for the new session
+)
+
+// UserSession is a session bound to a user.
+type UserSession struct {
+	ID      string
+	BoundAt time.Time
+}
+
+// UserIndex keeps IDs of sessions bound to users.
+type UserIndex interface {
+	Add(userID, sid string, boundAt time.Time) error //binds session, replacing its binding to another user
+	Remove(sid string) error                         //unbinds session, does nothing if it is not bound
+	Sessions(userID string) ([]UserSession, error)   //sessions of the user, the oldest bound first
+}
+
+// SortUserSessions sorts sessions by bind time, the oldest first, sessions bound at the same time by ID.
+// It is a helper for user indexes not able to sort on the server side.
+func SortUserSessions(list []UserSession) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].BoundAt.Equal(list[j].BoundAt) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].BoundAt.Before(list[j].BoundAt)
+	})
+}
+
+// UserIndexProvider is an optional interface for providers keeping user index in session storage
+// with a secondary index, so bindings are shared by application instances.
+type UserIndexProvider interface {
+	SessionUserIndex() UserIndex //nil if provider does not keep user index
+}
+
+// MemoryUserIndex is a UserIndex keeping bindings in process memory.
+// Bindings are lost on restart and are not shared between application instances.
+type MemoryUserIndex struct {
+	mx    sync.Mutex
+	users map[string]map[string]time.Time //user ID -> session ID -> bind time
+	sids  map[string]string               //session ID -> user ID
+}
+
+// NewMemoryUserIndex returns an empty index.
+func NewMemoryUserIndex() *MemoryUserIndex {
+	return &MemoryUserIndex{users: make(map[string]map[string]time.Time), sids: make(map[string]string)}
+}
+
+func (ix *MemoryUserIndex) Add(userID, sid string, boundAt time.Time) error {
+	ix.mx.Lock()
+	defer ix.mx.Unlock()
+	ix.remove(sid)
+	if ix.users[userID] == nil {
+		ix.users[userID] = make(map[string]time.Time)
+	}
+	ix.users[userID][sid] = boundAt
+	ix.sids[sid] = userID
+	return nil
+}
+
+func (ix *MemoryUserIndex) Remove(sid string) error {
+	ix.mx.Lock()
+	defer ix.mx.Unlock()
+	ix.remove(sid)
+	return nil
+}
+
+func (ix *MemoryUserIndex) remove(sid string) {
+	userID, ok := ix.sids[sid]
+	if !ok {
+		return
+	}
+	delete(ix.sids, sid)
+	delete(ix.users[userID], sid)
+	if len(ix.users[userID]) == 0 {
+		delete(ix.users, userID)
+	}
+}
+
+func (ix *MemoryUserIndex) Sessions(userID string) ([]UserSession, error) {
+	ix.mx.Lock()
+	defer ix.mx.Unlock()
+	list := make([]UserSession, 0, len(ix.users[userID]))
+	for sid, bound_at := range ix.users[userID] {
+		list = append(list, UserSession{ID: sid, BoundAt: bound_at})
+	}
+	SortUserSessions(list)
+	return list, nil
+}
+
+// SetUserIndex sets index of sessions bound to users with BindUser(). By default the index
+// of provider implementing UserIndexProvider is used, MemoryUserIndex otherwise.
+// An index shared by application instances is needed to enforce SetMaxUserSessions() across them.
+// Should be set before sessions are bound.
+func (manager *Manager) SetUserIndex(index UserIndex) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.userIndex = index
+}
+
+// SetMaxUserSessions limits number of concurrent sessions bound to a user with BindUser(),
+// e.g. for licensing or security policies. When a session is bound to a user having max sessions,
+// the oldest bound sessions are destroyed or the new one is rejected depending on policy.
+// 0 means no limit.
+func (manager *Manager) SetMaxUserSessions(max int, policy UserLimitPolicy) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.maxUserSessions = max
+	manager.userPolicy = policy
+}
+
+// BindUser binds session to user, e.g. after login, so the number of user sessions is limited,
+// see SetMaxUserSessions(). User ID is stored in the session under USER_KEY and the session
+// is added to the user index, see SetUserIndex(). Binding a session to another user rebinds it.
+// Indexed sessions no longer in storage are unbound when the limit is checked, provider must implement
+// MetaProvider interface for it, otherwise only sessions destroyed with SessionDestroy() are unbound.
+func (manager *Manager) BindUser(sess Session, userID string) error {
+	if userID == "" {
+		return errors.New("session: BindUser user ID must not be empty")
+	}
+	manager.usersMx.Lock()
+	defer manager.usersMx.Unlock()
+
+	index := manager.users()
+	sid := sess.SessionID()
+	if manager.maxUserSessions > 0 {
+		list, err := manager.liveUserSessions(index, userID, sid)
+		if err != nil {
+			return err
+		}
+		if excess := len(list) - manager.maxUserSessions + 1; excess > 0 {
+			if manager.userPolicy == USER_LIMIT_REJECT {
+				return ErrTooManySessions
+			}
+			for _, us := range list[:excess] {
+				if err := manager.SessionDestroy(us.ID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if sess.GetString(USER_KEY) != userID {
+		if err := sess.Put(USER_KEY, userID); err != nil {
+			return err
+		}
+	}
+	return index.Add(userID, sid, manager.clock.Now())
+}
+
+// DestroySessionsForUser destroys all sessions bound to user with BindUser(), e.g. on password change.
+// Sessions are looked up in user index, see SetUserIndex(), storage is not scanned.
+// Sessions are destroyed as DestroySessionsWhere() does.
+func (manager *Manager) DestroySessionsForUser(userID string) error {
+	list, err := manager.liveUserSessions(manager.users(), userID, "")
+	if err != nil {
+		return err
+	}
+	sids := make([]string, len(list))
+	for i, us := range list {
+		sids[i] = us.ID
+	}
+	return manager.destroySessions(sids)
+}
+
+// users returns user index: the one set with SetUserIndex(), provider index or a new MemoryUserIndex.
+func (manager *Manager) users() UserIndex {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if manager.userIndex == nil {
+		manager.userIndex = userIndexOf(manager.provider)
+	}
+	if manager.userIndex == nil {
+		manager.userIndex = NewMemoryUserIndex()
+	}
+	return manager.userIndex
+}
+
+// userIndexOf returns user index of provider implementing UserIndexProvider, nil otherwise, used by decorators.
+func userIndexOf(p Provider) UserIndex {
+	if ix_pder, ok := p.(UserIndexProvider); ok {
+		return ix_pder.SessionUserIndex()
+	}
+	return nil
+}
+
+// liveUserSessions returns indexed sessions of the user except sid,
+// sessions no longer in storage are removed from index.
+func (manager *Manager) liveUserSessions(index UserIndex, userID, sid string) ([]UserSession, error) {
+	list, err := index.Sessions(userID)
+	if err != nil {
+		return nil, err
+	}
+	live := list[:0]
+	for _, us := range list {
+		if us.ID == sid {
+			continue
+		}
+		if _, err := manager.SessionMeta(us.ID); errors.Is(err, ErrSessionNotFound) {
+			if err := index.Remove(us.ID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		live = append(live, us)
+	}
+	return live, nil
+}
+
+// unbindUser removes destroyed or expired session from user index.
+func (manager *Manager) unbindUser(sid string) {
+	manager.lock.Lock()
+	index := manager.userIndex
+	manager.lock.Unlock()
+	if index != nil {
+		index.Remove(sid) //stale bindings are removed on limit checks
+	}
+}